@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/store"
+	"github.com/danstis/ado-asana-sync/internal/sync"
+)
+
+// pairInfo is the configuration and last cycle of a pair listed by GET /pairs.
+type pairInfo struct {
+	Name            string         `json:"name"`
+	ADOConnection   string         `json:"ado_connection,omitempty"`
+	ADOProject      string         `json:"ado_project"`
+	AsanaConnection string         `json:"asana_connection,omitempty"`
+	AsanaWorkspace  string         `json:"asana_workspace"`
+	AsanaProject    string         `json:"asana_project"`
+	Direction       sync.Direction `json:"direction"`
+	// Interval is the time between cycles, empty when the pair runs on a cron schedule.
+	Interval string        `json:"interval,omitempty"`
+	DryRun   bool          `json:"dry_run,omitempty"`
+	Progress sync.Progress `json:"progress"`
+	// Paused is the pause the pair is in, nil while it syncs.
+	Paused *sync.Pause `json:"paused"`
+	// LastCycle is the most recent cycle of the pair, nil before its first one.
+	LastCycle *sync.CycleStatus `json:"last_cycle"`
+}
+
+// itemInfo is the sync state of a work item in a connection, served by GET /items/{adoId}.
+type itemInfo struct {
+	Connection string           `json:"connection,omitempty"`
+	Mapping    store.Mapping    `json:"mapping"`
+	Conflicts  []store.Conflict `json:"conflicts"`
+	// Retry is the queued retry of the item, nil when it is not waiting for one.
+	Retry *store.Retry `json:"retry"`
+}
+
+// adminHandler returns the handler of the admin API, accepting requests that carry token as a bearer token:
+//
+//	POST   /sync/{pair}        starts a cycle of the pair now
+//	POST   /pause/{pair}       pauses the pair until resumed, or for the duration of the for query parameter
+//	POST   /resume/{pair}      resumes the pair and starts a cycle syncing the changes held back
+//	GET    /pairs              lists the pairs with their configuration and last cycle
+//	GET    /items/{adoId}      shows the mapping of a work item, its conflicts and queued retry
+//	DELETE /mappings/{adoId}   deletes the mapping of a work item and syncs it again, creating a new task
+//
+// Work item IDs are looked up in every ADO connection, and the connection query parameter picks one when
+// several map the same ID.
+func (a *app) adminHandler(token string) http.Handler {
+	return requireToken(token, a.adminMux())
+}
+
+// adminMux returns the routes of the admin API of the app, without authentication.
+func (a *app) adminMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sync/", a.handleTrigger)
+	mux.HandleFunc("/pause/", a.handlePause)
+	mux.HandleFunc("/resume/", a.handleResume)
+	mux.HandleFunc("/pairs", a.handlePairs)
+	mux.HandleFunc("/items/", a.handleItem)
+	mux.HandleFunc("/mappings/", a.handleDeleteMapping)
+	return mux
+}
+
+// requireToken returns a handler passing the requests that carry token as a bearer token to h.
+func requireToken(token string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") || subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (a *app) handleTrigger(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/sync/")
+	switch err := a.manager.Trigger(name); {
+	case errors.Is(err, sync.ErrUnknownPair):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("sync cycle triggered through the admin api", logging.KeyPair, name)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (a *app) handlePause(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/pause/")
+	var until time.Time
+	if v := r.URL.Query().Get("for"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid pause duration", http.StatusBadRequest)
+			return
+		}
+		until = time.Now().Add(d)
+	}
+	if !pauseResult(w, name, a.manager.Pause(r.Context(), name, until, r.URL.Query().Get("reason"))) {
+		return
+	}
+	slog.Info("sync pair paused through the admin api", logging.KeyPair, name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *app) handleResume(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/resume/")
+	if !pauseResult(w, name, a.manager.Resume(r.Context(), name)) {
+		return
+	}
+	slog.Info("sync pair resumed through the admin api", logging.KeyPair, name)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// pauseResult writes the error response of pausing or resuming the named pair, and reports whether err is
+// nil.
+func pauseResult(w http.ResponseWriter, name string, err error) bool {
+	switch {
+	case errors.Is(err, sync.ErrUnknownPair):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return false
+	case err != nil:
+		slog.Error("failed to record pause", logging.KeyPair, name, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+func (a *app) handlePairs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	stores := a.connStores()
+	pairs := []pairInfo{}
+	for _, e := range a.manager.Engines() {
+		cfg := e.Config()
+		p := pairInfo{
+			Name:            cfg.Name,
+			ADOConnection:   cfg.ADOConnection,
+			ADOProject:      cfg.ADOProject,
+			AsanaConnection: cfg.AsanaConnection,
+			AsanaWorkspace:  cfg.AsanaWorkspace,
+			AsanaProject:    cfg.AsanaProject,
+			Direction:       cfg.Direction,
+			DryRun:          cfg.DryRun,
+			Progress:        e.Progress(),
+		}
+		if cfg.Schedule == nil {
+			interval := cfg.Interval
+			if interval <= 0 {
+				interval = sync.DefaultInterval
+			}
+			p.Interval = interval.String()
+		}
+		paused, err := e.Paused(r.Context())
+		if err != nil {
+			slog.Error("failed to read pause", logging.KeyPair, cfg.Name, "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		p.Paused = paused
+		s, err := sync.LastCycle(r.Context(), stores[cfg.ADOConnection], cfg.Name)
+		switch {
+		case err == nil:
+			p.LastCycle = s
+		case !errors.Is(err, store.ErrNotFound):
+			slog.Error("failed to read last sync cycle", logging.KeyPair, cfg.Name, "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		pairs = append(pairs, p)
+	}
+	serveJSON(w, pairs)
+}
+
+func (a *app) handleItem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/items/"))
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid work item id", http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	items := []itemInfo{}
+	for _, c := range a.itemConns(r) {
+		st := a.connStore(c)
+		m, err := st.Get(ctx, id)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			slog.Error("failed to read mapping", logging.KeyWorkItem, id, "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		item := itemInfo{Connection: c.name, Mapping: m, Conflicts: []store.Conflict{}}
+		conflicts, err := st.Conflicts(ctx)
+		if err != nil {
+			slog.Error("failed to read conflicts", logging.KeyWorkItem, id, "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		for _, cf := range conflicts {
+			if cf.ADOID == id {
+				item.Conflicts = append(item.Conflicts, cf)
+			}
+		}
+		switch retry, err := st.Retry(ctx, id); {
+		case err == nil:
+			item.Retry = &retry
+		case !errors.Is(err, store.ErrNotFound):
+			slog.Error("failed to read queued retry", logging.KeyWorkItem, id, "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		items = append(items, item)
+	}
+	if len(items) == 0 {
+		http.Error(w, "work item not mapped", http.StatusNotFound)
+		return
+	}
+	serveJSON(w, items)
+}
+
+func (a *app) handleDeleteMapping(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/mappings/"))
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid work item id", http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	var found []*connection
+	for _, c := range a.itemConns(r) {
+		switch _, err := a.connStore(c).Get(ctx, id); {
+		case err == nil:
+			found = append(found, c)
+		case !errors.Is(err, store.ErrNotFound):
+			slog.Error("failed to read mapping", logging.KeyWorkItem, id, "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+	}
+	switch {
+	case len(found) == 0:
+		http.Error(w, "work item not mapped", http.StatusNotFound)
+		return
+	case len(found) > 1:
+		http.Error(w, "work item mapped in several connections, pick one with the connection parameter", http.StatusConflict)
+		return
+	}
+	c := found[0]
+	if err := deleteMapping(ctx, a.connStore(c), id); err != nil {
+		slog.Error("failed to delete mapping", logging.KeyWorkItem, id, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("mapping deleted through the admin api, syncing the work item again", logging.KeyWorkItem, id)
+
+	// The item is synced at once, as incremental cycles would only pick it up once it changes again, and
+	// without adopting the task carrying its ID, which would map the old task again.
+	rep, err := a.manager.Recreate(ctx, c.ado.OrgURL, id)
+	if err == nil && len(rep.Failures) > 0 {
+		err = rep.Failures[0].Err
+	}
+	if err != nil {
+		slog.Error("failed to sync work item after deleting its mapping", logging.KeyWorkItem, id, "error", err)
+		http.Error(w, "mapping deleted, syncing the work item failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	m, err := a.connStore(c).Get(ctx, id)
+	if err != nil {
+		// The item is no longer synced by any pair, such as after it was closed or filtered out.
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	serveJSON(w, m)
+}
+
+// deleteMapping removes the mapping of the work item from st with its conflicts and queued retry, which
+// refer to the task that is no longer mapped.
+func deleteMapping(ctx context.Context, st store.Store, id int) error {
+	conflicts, err := st.Conflicts(ctx)
+	if err != nil {
+		return err
+	}
+	for _, c := range conflicts {
+		if c.ADOID != id {
+			continue
+		}
+		if err := st.DeleteConflict(ctx, id, c.Field); err != nil {
+			return err
+		}
+	}
+	if err := st.DeleteRetry(ctx, id); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+	if err := st.Delete(ctx, id); err != nil {
+		return err
+	}
+	return st.Flush(ctx)
+}
+
+// itemConns returns the ADO connections a request for a work item looks in: the one named by its
+// connection parameter, or every connection.
+func (a *app) itemConns(r *http.Request) []*connection {
+	if !r.URL.Query().Has("connection") {
+		return a.conns
+	}
+	name := r.URL.Query().Get("connection")
+	for _, c := range a.conns {
+		if c.name == name {
+			return []*connection{c}
+		}
+	}
+	return nil
+}
+
+// connStore returns the store holding the mappings of the connection.
+func (a *app) connStore(c *connection) store.Store {
+	if c.store != nil {
+		return c.store
+	}
+	return a.store
+}
+
+// connStores returns the store of every ADO connection by name.
+func (a *app) connStores() map[string]store.Store {
+	stores := make(map[string]store.Store, len(a.conns))
+	for _, c := range a.conns {
+		stores[c.name] = a.connStore(c)
+	}
+	return stores
+}
+
+// serveJSON writes v as the JSON body of the response.
+func serveJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
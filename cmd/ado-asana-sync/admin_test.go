@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/store"
+	"github.com/danstis/ado-asana-sync/internal/sync"
+	"github.com/danstis/ado-asana-sync/internal/testfixtures"
+)
+
+// adminServer serves the admin API of an app syncing the pair of h, accepting the token "admin".
+func adminServer(t *testing.T, h *testfixtures.Harness) *httptest.Server {
+	t.Helper()
+	m, err := h.Manager(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &app{
+		pairs:   []sync.Config{h.Config},
+		store:   h.Store,
+		conns:   []*connection{{name: h.Config.ADOConnection, ado: ado.NewClient(h.ADO.URL(), "pat")}},
+		manager: m,
+	}
+	srv := httptest.NewServer(a.adminHandler("admin"))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// adminRequest sends a request to the admin API with the token "admin", decoding the JSON body of the
+// response into v when it is not nil, and returns the status code.
+func adminRequest(t *testing.T, srv *httptest.Server, method, path string, v interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer admin")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s: decoding the response: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func newAdminHarness(t *testing.T) *testfixtures.Harness {
+	t.Helper()
+	cfg := sync.DefaultConfig()
+	cfg.Name = "admin"
+	h := testfixtures.NewHarness(cfg)
+	t.Cleanup(h.Close)
+	return h
+}
+
+// addItem adds a work item the pair of h selects and returns its ID.
+func addItem(h *testfixtures.Harness) int {
+	h.Asana.AddUser("Alice", "alice@example.com")
+	return h.ADO.Add("Task", "Write the release notes", map[string]interface{}{
+		ado.FieldAssignedTo: testfixtures.Assignee("Alice", "alice@example.com"),
+	})
+}
+
+func TestAdminRequiresToken(t *testing.T) {
+	srv := adminServer(t, newAdminHarness(t))
+	for _, auth := range []string{"", "Bearer wrong", "admin"} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/pairs", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: want 401, got %d", auth, resp.StatusCode)
+		}
+	}
+}
+
+func TestAdminPairs(t *testing.T) {
+	h := newAdminHarness(t)
+	srv := adminServer(t, h)
+	if _, err := h.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var pairs []pairInfo
+	if code := adminRequest(t, srv, http.MethodGet, "/pairs", &pairs); code != http.StatusOK {
+		t.Fatalf("GET /pairs: want 200, got %d", code)
+	}
+	if len(pairs) != 1 || pairs[0].Name != "admin" || pairs[0].AsanaProject != h.Project || pairs[0].Paused != nil || pairs[0].LastCycle == nil {
+		t.Fatalf("GET /pairs: want the unpaused pair with its last cycle, got %+v", pairs)
+	}
+
+	if code := adminRequest(t, srv, http.MethodPost, "/pause/admin?for=1h&reason=release", nil); code != http.StatusNoContent {
+		t.Fatalf("POST /pause: want 204, got %d", code)
+	}
+	adminRequest(t, srv, http.MethodGet, "/pairs", &pairs)
+	if p := pairs[0].Paused; p == nil || p.Reason != "release" || p.Until.Before(time.Now().Add(50*time.Minute)) {
+		t.Fatalf("GET /pairs: want the pair paused for an hour for the release, got %+v", p)
+	}
+	if code := adminRequest(t, srv, http.MethodPost, "/resume/admin", nil); code != http.StatusAccepted {
+		t.Fatalf("POST /resume: want 202, got %d", code)
+	}
+	adminRequest(t, srv, http.MethodGet, "/pairs", &pairs)
+	if pairs[0].Paused != nil {
+		t.Fatalf("GET /pairs: want the pair resumed, got %+v", pairs[0].Paused)
+	}
+
+	for _, c := range []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodPost, "/sync/admin", http.StatusAccepted},
+		{http.MethodPost, "/sync/other", http.StatusNotFound},
+		{http.MethodGet, "/sync/admin", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/pause/other", http.StatusNotFound},
+		{http.MethodPost, "/pause/admin?for=soon", http.StatusBadRequest},
+		{http.MethodPost, "/resume/other", http.StatusNotFound},
+	} {
+		if code := adminRequest(t, srv, c.method, c.path, nil); code != c.want {
+			t.Errorf("%s %s: want %d, got %d", c.method, c.path, c.want, code)
+		}
+	}
+}
+
+func TestAdminItems(t *testing.T) {
+	h := newAdminHarness(t)
+	srv := adminServer(t, h)
+	id := addItem(h)
+	if _, err := h.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	old, err := h.Store.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var items []itemInfo
+	if code := adminRequest(t, srv, http.MethodGet, "/items/"+strconv.Itoa(id), &items); code != http.StatusOK {
+		t.Fatalf("GET /items: want 200, got %d", code)
+	}
+	if len(items) != 1 || items[0].Mapping.AsanaGID != old.AsanaGID || items[0].Retry != nil {
+		t.Fatalf("GET /items: want the mapping of the item, got %+v", items)
+	}
+	for _, c := range []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/items/999", http.StatusNotFound},
+		{http.MethodGet, "/items/abc", http.StatusBadRequest},
+		{http.MethodGet, "/items/" + strconv.Itoa(id) + "?connection=other", http.StatusNotFound},
+		{http.MethodDelete, "/mappings/999", http.StatusNotFound},
+		{http.MethodGet, "/mappings/" + strconv.Itoa(id), http.StatusMethodNotAllowed},
+	} {
+		if code := adminRequest(t, srv, c.method, c.path, nil); code != c.want {
+			t.Errorf("%s %s: want %d, got %d", c.method, c.path, c.want, code)
+		}
+	}
+}
+
+func TestAdminDeleteMapping(t *testing.T) {
+	h := newAdminHarness(t)
+	srv := adminServer(t, h)
+	ctx := context.Background()
+	id := addItem(h)
+	if _, err := h.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	old, err := h.Store.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Store.PutRetry(ctx, store.Retry{ADOID: id, Pair: "admin", Attempts: 1, NextAttempt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The old task carries the ID of the item in its name, yet a new task is created rather than the old one
+	// mapped again.
+	var m store.Mapping
+	if code := adminRequest(t, srv, http.MethodDelete, "/mappings/"+strconv.Itoa(id), &m); code != http.StatusOK {
+		t.Fatalf("DELETE /mappings: want 200, got %d", code)
+	}
+	if m.ADOID != id || m.AsanaGID == "" || m.AsanaGID == old.AsanaGID {
+		t.Fatalf("want a new task mapped in place of %s, got %+v", old.AsanaGID, m)
+	}
+	if got, err := h.Store.Get(ctx, id); err != nil || got.AsanaGID != m.AsanaGID {
+		t.Fatalf("want the new mapping stored, got %+v, %v", got, err)
+	}
+	if _, ok := h.Asana.Task(old.AsanaGID); !ok {
+		t.Fatalf("want the old task %s left in asana", old.AsanaGID)
+	}
+	if n := len(h.Asana.Tasks(h.Project)); n != 2 {
+		t.Fatalf("want the old and the new task in the project, got %d tasks", n)
+	}
+	if _, err := h.Store.Retry(ctx, id); err == nil {
+		t.Fatal("want the queued retry of the old task deleted")
+	}
+
+	// Later cycles keep the new task.
+	if _, err := h.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := h.Store.Get(ctx, id); err != nil || got.AsanaGID != m.AsanaGID {
+		t.Fatalf("want the new mapping kept by the next cycle, got %+v, %v", got, err)
+	}
+}
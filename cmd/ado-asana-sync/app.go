@@ -0,0 +1,497 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/breaker"
+	"github.com/danstis/ado-asana-sync/internal/config"
+	"github.com/danstis/ado-asana-sync/internal/egress"
+	"github.com/danstis/ado-asana-sync/internal/metrics"
+	"github.com/danstis/ado-asana-sync/internal/notify"
+	"github.com/danstis/ado-asana-sync/internal/ratelimit"
+	"github.com/danstis/ado-asana-sync/internal/secret"
+	"github.com/danstis/ado-asana-sync/internal/store"
+	"github.com/danstis/ado-asana-sync/internal/sync"
+	"github.com/danstis/ado-asana-sync/internal/tracing"
+	"github.com/danstis/ado-asana-sync/internal/version"
+)
+
+// app holds the clients, store and sync pairs shared by the commands that talk to ADO and Asana.
+type app struct {
+	pairs []sync.Config
+	store store.Store
+	// conns and asanaConns hold the ADO and Asana connections in use by the pairs, the default ones first.
+	conns      []*connection
+	asanaConns []*asanaConnection
+	manager    *sync.Manager
+	// notifier posts failures and conflicts to a chat webhook. It is nil when notifications are off.
+	notifier *notify.Notifier
+
+	shutdownTracing func(context.Context) error
+}
+
+// connection is an ADO organization the pairs sync with, with its own rate limiter and circuit breaker.
+type connection struct {
+	name string
+	// provider labels the metrics of the connection.
+	provider string
+	ado      *ado.Client
+	breaker  *breaker.Breaker
+	limiter  *ratelimit.Limiter
+	// store holds the mappings of the connection's pairs. It is nil when they use the app's store.
+	store store.Store
+}
+
+// asanaConnection is an Asana account the pairs sync with, with its own rate limiter and circuit breaker.
+type asanaConnection struct {
+	name     string
+	provider string
+	asana    *asana.Client
+	breaker  *breaker.Breaker
+	limiter  *ratelimit.Limiter
+}
+
+// ping checks that the token of the connection is accepted.
+func (c *asanaConnection) ping(ctx context.Context) error {
+	_, err := c.asana.Me(ctx)
+	return err
+}
+
+// ado returns the ADO client of the named connection.
+func (a *app) ado(name string) *ado.Client {
+	for _, c := range a.conns {
+		if c.name == name {
+			return c.ado
+		}
+	}
+	return nil
+}
+
+// openApp loads the configuration and connects to the store and both APIs. In dry run mode the engines work
+// on an in-memory copy of the store so nothing they record is persisted.
+func openApp(ctx context.Context, dryRun bool) (*app, error) {
+	pairs, err := loadPairs()
+	if err != nil {
+		return nil, err
+	}
+	for i := range pairs {
+		pairs[i].DryRun = dryRun
+	}
+
+	a := &app{pairs: pairs}
+	if a.shutdownTracing, err = tracing.Setup(ctx, version.Version); err != nil {
+		return nil, err
+	}
+	if a.store, err = openStore(ctx); err != nil {
+		a.close()
+		return nil, err
+	}
+
+	limits, err := rateLimitOptions()
+	if err != nil {
+		a.close()
+		return nil, err
+	}
+	conns, err := loadConnections(pairs)
+	if err != nil {
+		a.close()
+		return nil, err
+	}
+	asanaConns, err := loadAsanaConnections(pairs)
+	if err != nil {
+		a.close()
+		return nil, err
+	}
+	if err := a.open(ctx, dryRun, limits, conns, asanaConns); err != nil {
+		a.close()
+		return nil, err
+	}
+	if !dryRun && replayer == nil {
+		if a.notifier, err = newNotifier(ctx); err != nil {
+			a.close()
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// open connects to the organizations and accounts of conns and asanaConns, limited by limits, and creates
+// the manager of the pairs of a. Connections without a store of their own use that of a.
+func (a *app) open(ctx context.Context, dryRun bool, limits ratelimit.Options, conns []config.ADOConnection, asanaConns []config.AsanaConnection) error {
+	batch, err := batchWindow()
+	if err != nil {
+		return err
+	}
+	cacheTTL, err := cacheTTL()
+	if err != nil {
+		return err
+	}
+	circuits, err := circuitOptions()
+	if err != nil {
+		return err
+	}
+	engineConns := make(map[string]sync.ADOConnection, len(conns))
+	for _, c := range conns {
+		conn, err := connect(ctx, c, limits, circuits)
+		if err != nil {
+			return err
+		}
+		a.conns = append(a.conns, conn)
+		st := a.store
+		if conn.store != nil {
+			st = conn.store
+		}
+		if dryRun {
+			if st, err = store.Copy(ctx, st); err != nil {
+				return err
+			}
+		}
+		var client sync.ADO = conn.ado
+		if batch > 0 {
+			b := ado.NewBatcher(conn.ado)
+			b.Window = batch
+			client = b
+		}
+		engineConns[c.Name] = sync.ADOConnection{OrgURL: conn.ado.OrgURL, ADO: client, Store: st, Circuit: conn.breaker}
+	}
+	engineAsana := make(map[string]sync.AsanaConnection, len(asanaConns))
+	for _, c := range asanaConns {
+		conn, err := a.connectAsana(ctx, c, limits, circuits)
+		if err != nil {
+			return err
+		}
+		a.asanaConns = append(a.asanaConns, conn)
+		var client sync.Asana = conn.asana
+		if batch > 0 {
+			b := asana.NewBatcher(conn.asana)
+			b.Window = batch
+			client = b
+		}
+		client = sync.NewCachedAsana(client, cacheTTL)
+		engineAsana[c.Name] = sync.AsanaConnection{Asana: client, Circuit: conn.breaker}
+	}
+	a.manager, err = sync.NewManager(a.pairs, engineConns, engineAsana)
+	return err
+}
+
+// notify posts the events of a finished cycle of e when notifications are on.
+func (a *app) notify(ctx context.Context, e *sync.Engine, rep *sync.Report, err error) {
+	if a.notifier != nil {
+		a.notifier.Cycle(ctx, e.Name(), rep, err)
+	}
+}
+
+// connect returns the connection to the organization of c. Its metrics and circuit are labelled with the
+// provider ado, followed by the name of the connection for named ones.
+func connect(ctx context.Context, c config.ADOConnection, limits ratelimit.Options, circuits breaker.Options) (*connection, error) {
+	provider := metrics.ProviderADO
+	if c.Name != sync.DefaultConnection {
+		provider += ":" + c.Name
+	}
+	var err error
+	if limits.Caps, err = ratelimit.ParseCaps(c.CallBudget); err != nil {
+		return nil, fmt.Errorf("call budget of ado connection %s: %w", connectionName(c.Name), err)
+	}
+	conn := &connection{name: c.Name, provider: provider, breaker: breaker.New(provider, circuits), limiter: ratelimit.New(provider, limits)}
+	if replayer != nil {
+		// Replayed requests never leave the process, so they need no credentials.
+		conn.ado = ado.NewClient(c.OrgURL, "replay")
+		conn.ado.HTTP = apiClient(provider, conn.limiter, conn.breaker, nil)
+		if c.StoreURL != "" {
+			conn.store, err = replayStore(ctx, c.Name)
+		}
+		return conn, err
+	}
+	pat, patName := c.PAT, "ado_pat"
+	if c.PATEnv != "" {
+		pat, patName = os.Getenv(c.PATEnv), c.PATEnv
+	}
+	network, err := connectionTransport(c.Options)
+	if err != nil {
+		return nil, fmt.Errorf("ado connection %s: %w", connectionName(c.Name), err)
+	}
+	conn.ado = ado.NewClient(c.OrgURL, pat)
+	conn.ado.HTTP = apiClient(provider, conn.limiter, conn.breaker, network)
+	if pat == "" && os.Getenv("AZURE_CLIENT_ID") != "" {
+		if conn.ado.Tokens, err = entraSource(ado.Scope); err != nil {
+			return nil, err
+		}
+	}
+	if conn.ado.PATs, err = secretRefSource(ctx, patName, pat); err != nil {
+		return nil, err
+	}
+	if c.StoreURL != "" {
+		if conn.store, err = openStoreAt(ctx, c.StoreURL); err != nil {
+			return nil, fmt.Errorf("ado connection %q: %w", c.Name, err)
+		}
+	}
+	return conn, nil
+}
+
+// connectAsana returns the connection to the Asana account of c, labelled like connect does with the
+// provider asana. The default connection signs in with OAuth when ASANA_TOKEN is unset.
+func (a *app) connectAsana(ctx context.Context, c config.AsanaConnection, limits ratelimit.Options, circuits breaker.Options) (*asanaConnection, error) {
+	provider := metrics.ProviderAsana
+	if c.Name != sync.DefaultConnection {
+		provider += ":" + c.Name
+	}
+	var err error
+	if limits.Caps, err = ratelimit.ParseCaps(c.CallBudget); err != nil {
+		return nil, fmt.Errorf("call budget of asana connection %s: %w", asanaConnectionName(c.Name), err)
+	}
+	conn := &asanaConnection{name: c.Name, provider: provider, breaker: breaker.New(provider, circuits), limiter: ratelimit.New(provider, limits)}
+	if replayer != nil {
+		conn.asana = asana.NewClient("replay")
+		conn.asana.HTTP = apiClient(provider, conn.limiter, conn.breaker, nil)
+		return conn, nil
+	}
+	network, err := connectionTransport(c.Options)
+	if err != nil {
+		return nil, fmt.Errorf("asana connection %s: %w", asanaConnectionName(c.Name), err)
+	}
+	token, tokenName := c.Token, "asana_token"
+	if c.TokenEnv != "" {
+		token, tokenName = os.Getenv(c.TokenEnv), c.TokenEnv
+	}
+	conn.asana = asana.NewClient(token)
+	conn.asana.HTTP = apiClient(provider, conn.limiter, conn.breaker, network)
+	if conn.asana.Tokens, err = secretRefSource(ctx, tokenName, token); err != nil {
+		return nil, err
+	}
+	if cfg := oauthConfig(); cfg != nil && c.Name == sync.DefaultConnection && token == "" {
+		// Tokens are persisted in the real store even in dry run mode, as a refresh rotates the refresh token.
+		tokens, err := newTokenStore(a.store)
+		if err != nil {
+			return nil, err
+		}
+		conn.asana.Tokens = asana.NewOAuthSource(cfg, tokens)
+	}
+	return conn, nil
+}
+
+// close stops the transform plugins, closes the stores and flushes pending traces.
+func (a *app) close() {
+	if a.manager != nil {
+		if err := a.manager.Close(); err != nil {
+			slog.Error("failed to stop transform plugins", "error", err)
+		}
+	}
+	for _, c := range a.conns {
+		if c.store == nil {
+			continue
+		}
+		if err := c.store.Close(); err != nil {
+			slog.Error("failed to close store", "connection", c.name, "error", err)
+		}
+	}
+	if a.store != nil {
+		if err := a.store.Close(); err != nil {
+			slog.Error("failed to close store", "error", err)
+		}
+	}
+	if a.shutdownTracing == nil {
+		// Tenants share the tracing of the service.
+		return
+	}
+	if err := a.shutdownTracing(context.Background()); err != nil {
+		slog.Error("failed to flush traces", "error", err)
+	}
+}
+
+// openStore opens the mapping database named by STORE_URL or STORE_PATH. In replay mode it is a copy of the
+// store the recording started from.
+func openStore(ctx context.Context) (store.Store, error) {
+	if replayer != nil {
+		return replayStore(ctx, "")
+	}
+	return openStoreAt(ctx, storeLocation())
+}
+
+// openStoreAt opens the store at location, encrypting its records with STORE_KEY when it is set.
+func openStoreAt(ctx context.Context, location string) (store.Store, error) {
+	st, err := store.Open(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := storeKeys(ctx)
+	if err != nil {
+		st.Close()
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return st, nil
+	}
+	return store.NewEncrypted(st, keys[0], keys[1:]...), nil
+}
+
+// storeKeys returns the key of STORE_KEY followed by the previous keys of STORE_PREVIOUS_KEYS, or nothing
+// when the store is not encrypted. Every key may be a secret reference.
+func storeKeys(ctx context.Context) ([]*secret.Box, error) {
+	key := os.Getenv("STORE_KEY")
+	if key == "" {
+		if os.Getenv("STORE_PREVIOUS_KEYS") != "" {
+			return nil, errors.New("STORE_PREVIOUS_KEYS needs STORE_KEY to be set")
+		}
+		return nil, nil
+	}
+	values := []string{key}
+	for _, v := range strings.Split(os.Getenv("STORE_PREVIOUS_KEYS"), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	r, err := secretResolver()
+	if err != nil {
+		return nil, err
+	}
+	boxes := make([]*secret.Box, 0, len(values))
+	for i, v := range values {
+		name := "STORE_KEY"
+		if i > 0 {
+			name = "STORE_PREVIOUS_KEYS"
+		}
+		if v, err = r.Resolve(ctx, v); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		box, err := secret.New(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		boxes = append(boxes, box)
+	}
+	return boxes, nil
+}
+
+// storeLocation returns the location of the mapping database.
+func storeLocation() string {
+	return getenv("STORE_URL", getenv("STORE_PATH", "data/mappings.json"))
+}
+
+// rateLimitOptions reads the rate limiter settings shared by both API clients from the environment, including
+// the call budget shared by every connection.
+func rateLimitOptions() (ratelimit.Options, error) {
+	opts := ratelimit.Options{Concurrency: ratelimit.DefaultConcurrency, Retries: ratelimit.DefaultRetries}
+	var err error
+	if v := os.Getenv("RATE_LIMIT_CONCURRENCY"); v != "" {
+		if opts.Concurrency, err = strconv.Atoi(v); err != nil || opts.Concurrency < 1 {
+			return opts, fmt.Errorf("invalid RATE_LIMIT_CONCURRENCY %q", v)
+		}
+	}
+	if v := os.Getenv("RATE_LIMIT_RETRIES"); v != "" {
+		if opts.Retries, err = strconv.Atoi(v); err != nil || opts.Retries < 0 {
+			return opts, fmt.Errorf("invalid RATE_LIMIT_RETRIES %q", v)
+		}
+	}
+	caps, err := ratelimit.ParseCaps(os.Getenv("CALL_BUDGET"))
+	if err != nil {
+		return opts, fmt.Errorf("invalid CALL_BUDGET: %w", err)
+	}
+	if len(caps) > 0 {
+		opts.Global = ratelimit.NewAllowance("global", caps)
+	}
+	return opts, nil
+}
+
+// warmUpRate returns the requests a second of the warm-up serve runs before the first cycles, read from
+// WARMUP_RATE. Zero turns the warm-up off.
+func warmUpRate() (float64, error) {
+	v := os.Getenv("WARMUP_RATE")
+	if v == "" {
+		return sync.DefaultWarmUpRate, nil
+	}
+	rate, err := strconv.ParseFloat(v, 64)
+	if err != nil || rate < 0 {
+		return 0, fmt.Errorf("invalid WARMUP_RATE %q", v)
+	}
+	return rate, nil
+}
+
+// batchWindow returns how long task and work item calls wait to be grouped into batch requests, read from
+// the environment. It is zero when BATCH_REQUESTS does not turn batching on.
+func batchWindow() (time.Duration, error) {
+	v := os.Getenv("BATCH_REQUESTS")
+	if v == "" {
+		return 0, nil
+	}
+	on, err := strconv.ParseBool(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid BATCH_REQUESTS: %w", err)
+	}
+	if !on {
+		return 0, nil
+	}
+	window := asana.DefaultBatchWindow
+	if v := os.Getenv("BATCH_WINDOW"); v != "" {
+		if window, err = time.ParseDuration(v); err != nil || window <= 0 {
+			return 0, fmt.Errorf("invalid BATCH_WINDOW %q", v)
+		}
+	}
+	return window, nil
+}
+
+// cacheTTL returns how long Asana reference data is cached, read from CACHE_TTL. Zero turns the cache off.
+func cacheTTL() (time.Duration, error) {
+	v := os.Getenv("CACHE_TTL")
+	if v == "" {
+		return sync.DefaultCacheTTL, nil
+	}
+	ttl, err := time.ParseDuration(v)
+	if err != nil || ttl < 0 {
+		return 0, fmt.Errorf("invalid CACHE_TTL %q", v)
+	}
+	return ttl, nil
+}
+
+// circuitOptions reads the circuit breaker settings shared by both API clients from the environment.
+func circuitOptions() (breaker.Options, error) {
+	opts := breaker.Options{Threshold: breaker.DefaultThreshold, Cooldown: breaker.DefaultCooldown}
+	var err error
+	if v := os.Getenv("CIRCUIT_THRESHOLD"); v != "" {
+		if opts.Threshold, err = strconv.Atoi(v); err != nil || opts.Threshold < 1 {
+			return opts, fmt.Errorf("invalid CIRCUIT_THRESHOLD %q", v)
+		}
+	}
+	if v := os.Getenv("CIRCUIT_COOLDOWN"); v != "" {
+		if opts.Cooldown, err = time.ParseDuration(v); err != nil || opts.Cooldown <= 0 {
+			return opts, fmt.Errorf("invalid CIRCUIT_COOLDOWN %q", v)
+		}
+	}
+	return opts, nil
+}
+
+// apiClient returns the HTTP client for provider, sending its requests through network, or the default
+// transport when nil. Requests are traced once, while the circuit breaker and the metrics see every attempt
+// the rate limiter makes.
+func apiClient(provider string, limiter *ratelimit.Limiter, b *breaker.Breaker, network http.RoundTripper) *http.Client {
+	return &http.Client{Transport: tracing.Transport(provider, limiter.Transport(b.Transport(metrics.Transport(provider, apiTransport(provider, network)))))}
+}
+
+// connectionTransport returns the transport of a connection with proxy or TLS settings of its own, which
+// fall back to those of the environment, or nil for the default transport.
+func connectionTransport(o egress.Options) (http.RoundTripper, error) {
+	if o.Empty() {
+		return nil, nil
+	}
+	return o.Or(egressOptions()).Transport()
+}
+
+// probe checks the providers whose circuit is open until ctx is done, closing their circuit once they
+// respond again.
+func (a *app) probe(ctx context.Context) {
+	for _, c := range a.conns {
+		go c.breaker.Probe(ctx, c.ado.Ping)
+	}
+	for _, c := range a.asanaConns {
+		go c.breaker.Probe(ctx, c.ping)
+	}
+}
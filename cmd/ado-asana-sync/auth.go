@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/secret"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// asanaTokenKey is the setting holding the sealed Asana OAuth token.
+const asanaTokenKey = "asana_oauth_token"
+
+// defaultRedirectURL is the redirect URL served by the login command when ASANA_REDIRECT_URL is unset.
+const defaultRedirectURL = "http://localhost:8484/oauth/callback"
+
+// tokenStore persists the Asana OAuth token in the store, encrypted with ASANA_TOKEN_KEY.
+type tokenStore struct {
+	store store.Store
+	box   *secret.Box
+}
+
+// newTokenStore returns the token store backed by st.
+func newTokenStore(st store.Store) (*tokenStore, error) {
+	key := os.Getenv("ASANA_TOKEN_KEY")
+	if key == "" {
+		return nil, errors.New("ASANA_TOKEN_KEY is required to store the Asana OAuth token")
+	}
+	box, err := secret.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return &tokenStore{store: st, box: box}, nil
+}
+
+// LoadToken implements asana.TokenStore.
+func (s *tokenStore) LoadToken(ctx context.Context) (*asana.Token, error) {
+	sealed, err := s.store.Setting(ctx, asanaTokenKey)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, asana.ErrNoToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.box.Open(sealed)
+	if err != nil {
+		return nil, err
+	}
+	var t asana.Token
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("decoding oauth token: %w", err)
+	}
+	return &t, nil
+}
+
+// SaveToken implements asana.TokenStore. The token is flushed at once because a rotated refresh token
+// replaces the previous one.
+func (s *tokenStore) SaveToken(ctx context.Context, t *asana.Token) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	sealed, err := s.box.Seal(data)
+	if err != nil {
+		return err
+	}
+	if err := s.store.SetSetting(ctx, asanaTokenKey, sealed); err != nil {
+		return err
+	}
+	return s.store.Flush(ctx)
+}
+
+// entraSource returns the Entra ID token source for ADO, configured with the variables the Azure SDKs and
+// Azure Workload Identity use.
+func entraSource(scope string) (*ado.EntraSource, error) {
+	return ado.NewEntraSource(ado.EntraConfig{
+		TenantID:           os.Getenv("AZURE_TENANT_ID"),
+		ClientID:           os.Getenv("AZURE_CLIENT_ID"),
+		ClientSecret:       os.Getenv("AZURE_CLIENT_SECRET"),
+		FederatedTokenFile: os.Getenv("AZURE_FEDERATED_TOKEN_FILE"),
+		AuthorityHost:      os.Getenv("AZURE_AUTHORITY_HOST"),
+		Scope:              scope,
+	})
+}
+
+// secretSource returns a source re-resolving the secret reference held in the environment variable key
+// every SECRET_REFRESH, or nil when the variable holds the secret itself.
+func secretSource(ctx context.Context, key string) (secret.TokenSource, error) {
+	return secretRefSource(ctx, key, os.Getenv(key))
+}
+
+// secretRefSource returns a source re-resolving the secret reference ref, the setting name in its errors,
+// every SECRET_REFRESH, or nil when ref is the secret itself.
+func secretRefSource(ctx context.Context, name, ref string) (secret.TokenSource, error) {
+	if !secret.IsReference(ref) {
+		return nil, nil
+	}
+	var refresh time.Duration
+	if v := os.Getenv("SECRET_REFRESH"); v != "" {
+		var err error
+		if refresh, err = time.ParseDuration(v); err != nil || refresh <= 0 {
+			return nil, fmt.Errorf("invalid SECRET_REFRESH %q", v)
+		}
+	}
+	r, err := secretResolver()
+	if err != nil {
+		return nil, err
+	}
+	src, err := secret.NewSource(ctx, r, ref, refresh)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return src, nil
+}
+
+// secretResolver returns the resolver of secret references. Key Vault is read with the Entra ID identity
+// configured by the AZURE_ variables and Vault with VAULT_ADDR and VAULT_TOKEN.
+func secretResolver() (secret.Resolver, error) {
+	r := secret.Resolver{}
+	if os.Getenv("AZURE_CLIENT_ID") != "" {
+		tokens, err := entraSource(secret.KeyVaultScope)
+		if err != nil {
+			return nil, err
+		}
+		r[secret.SchemeKeyVault] = &secret.KeyVault{Tokens: tokens}
+	}
+	if addr := os.Getenv("VAULT_ADDR"); addr != "" {
+		r[secret.SchemeVault] = &secret.Vault{Addr: addr, Token: os.Getenv("VAULT_TOKEN"), Namespace: os.Getenv("VAULT_NAMESPACE")}
+	}
+	return r, nil
+}
+
+// oauthConfig returns the Asana OAuth app configuration, or nil when ASANA_CLIENT_ID is unset.
+func oauthConfig() *asana.OAuthConfig {
+	id := os.Getenv("ASANA_CLIENT_ID")
+	if id == "" {
+		return nil
+	}
+	return &asana.OAuthConfig{
+		ClientID:     id,
+		ClientSecret: os.Getenv("ASANA_CLIENT_SECRET"),
+		RedirectURL:  getenv("ASANA_REDIRECT_URL", defaultRedirectURL),
+	}
+}
+
+// runLogin authorizes the app with Asana in the browser and stores the OAuth token for the other commands.
+func runLogin(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("login", flag.ExitOnError)
+	timeout := fs.Duration("timeout", 5*time.Minute, "how long to wait for the authorization")
+	_ = fs.Parse(args)
+
+	cfg := oauthConfig()
+	if cfg == nil {
+		return errors.New("ASANA_CLIENT_ID is required to log in")
+	}
+	redirect, err := url.Parse(cfg.RedirectURL)
+	if err != nil {
+		return fmt.Errorf("invalid ASANA_REDIRECT_URL: %w", err)
+	}
+	st, err := openStore(ctx)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	tokens, err := newTokenStore(st)
+	if err != nil {
+		return err
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	state := hex.EncodeToString(b)
+
+	// Only the first outcome is waited for, so the callbacks after it, a reload of the page or a retry, are
+	// answered without blocking on the channels.
+	codes, errs := make(chan string, 1), make(chan error, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc(redirect.Path, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case q.Get("state") != state:
+			http.Error(w, "invalid state", http.StatusBadRequest)
+			return
+		case q.Get("error") != "":
+			http.Error(w, "authorization failed", http.StatusBadRequest)
+			select {
+			case errs <- fmt.Errorf("authorization failed: %s %s", q.Get("error"), q.Get("error_description")):
+			default:
+			}
+			return
+		}
+		select {
+		case codes <- q.Get("code"):
+			fmt.Fprintln(w, "ado-asana-sync is authorized, you can close this window.")
+		default:
+			http.Error(w, "authorization already received", http.StatusConflict)
+		}
+	})
+	ln, err := net.Listen("tcp", redirect.Host)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Close()
+
+	fmt.Printf("Open this URL in a browser to authorize ado-asana-sync with Asana:\n\n  %s\n\n", cfg.AuthCodeURL(state))
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	var code string
+	select {
+	case code = <-codes:
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for authorization: %w", ctx.Err())
+	}
+
+	t, err := cfg.Exchange(ctx, code)
+	if err != nil {
+		return fmt.Errorf("exchanging authorization code: %w", err)
+	}
+	if err := tokens.SaveToken(ctx, t); err != nil {
+		return err
+	}
+	me, err := asana.NewClient(t.AccessToken).Me(ctx)
+	if err != nil {
+		return err
+	}
+	slog.Info("logged in to asana", "user", me.Name)
+	return nil
+}
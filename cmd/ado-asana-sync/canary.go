@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/danstis/ado-asana-sync/internal/sync"
+)
+
+// runCanary compares the writes of the current configuration and that of the configuration file given by
+// -config for a sample of the work items of every pair, or of -pair, and prints the differences. With -apply
+// the sampled items are then synced with the candidate configuration.
+func runCanary(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("canary", flag.ExitOnError)
+	candidate := fs.String("config", "", "the candidate configuration file to compare with the current one")
+	pair := fs.String("pair", "", "only try the candidate on this sync pair")
+	percent := fs.Float64("percent", 5, "the percentage of the work items of each pair to sample, from 0 to 100")
+	items := fs.String("items", "", "comma separated work item IDs to try whether or not they are sampled")
+	apply := fs.Bool("apply", false, "sync the sampled items with the candidate configuration after comparing")
+	asJSON := fs.Bool("json", false, "print the reports as JSON")
+	_ = fs.Parse(args)
+	opts := sync.CanaryOptions{Percent: *percent, Apply: *apply}
+	for _, v := range strings.Split(*items, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid work item ID %q", v)
+		}
+		opts.Items = append(opts.Items, id)
+	}
+	if *candidate == "" || opts.Percent < 0 || opts.Percent > 100 {
+		return errors.New("usage: canary -config file [-pair name] [-percent 0-100] [-items ids] [-apply] [-json]")
+	}
+
+	a, err := openApp(ctx, false)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+	// The candidate is loaded the way a reload loads a changed configuration file.
+	if err := os.Setenv("CONFIG_FILE", *candidate); err != nil {
+		return err
+	}
+	if err := loadEnv(true); err != nil {
+		return fmt.Errorf("loading candidate configuration: %w", err)
+	}
+	pairs, err := loadPairs()
+	if err != nil {
+		return fmt.Errorf("loading candidate configuration: %w", err)
+	}
+
+	tried := 0
+	for _, p := range pairs {
+		if *pair != "" && p.Name != *pair {
+			continue
+		}
+		tried++
+		rep, err := a.manager.Canary(ctx, p, opts)
+		if err != nil {
+			return fmt.Errorf("sync pair %q: %w", p.Name, err)
+		}
+		if *asJSON {
+			err = rep.WriteJSON(os.Stdout)
+		} else {
+			err = rep.WriteText(os.Stdout)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if tried == 0 {
+		return fmt.Errorf("%w: %q", sync.ErrUnknownPair, *pair)
+	}
+	return nil
+}
@@ -0,0 +1,1144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/health"
+	"github.com/danstis/ado-asana-sync/internal/leader"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/metrics"
+	"github.com/danstis/ado-asana-sync/internal/ratelimit"
+	"github.com/danstis/ado-asana-sync/internal/replay"
+	"github.com/danstis/ado-asana-sync/internal/schedule"
+	"github.com/danstis/ado-asana-sync/internal/store"
+	"github.com/danstis/ado-asana-sync/internal/sync"
+	"github.com/danstis/ado-asana-sync/internal/webhook"
+)
+
+// command is a subcommand of the app.
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, args []string) error
+}
+
+// commands lists the subcommands in the order they are shown in the usage message.
+var commands = []command{
+	{"serve", "sync every pair on its interval and serve webhooks, metrics and health checks until interrupted", runServe},
+	{"service", "with install or uninstall, register serve as a Windows service or systemd unit, or remove it; run is what the service manager starts", runService},
+	{"sync", "run a single sync cycle of every pair, or with item, sync one work item and show what changed", runSync},
+	{"replay", "run the sync cycle of a recording made by sync -record again, offline", runReplay},
+	{"backfill", "sync the whole backlog of every pair in resumable pages, showing progress", runBackfill},
+	{"drift", "check every stored mapping still matches its work item and task, optionally repairing drift", runDrift},
+	{"dedupe", "propose Asana tasks made by hand as the tasks of unsynced work items and adopt the confirmed ones", runDedupe},
+	{"conflicts", "with list, show the conflicts queued for manual resolution side by side; with resolve, pick the winner of each or merge them", runConflicts},
+	{"canary", "compare what a candidate configuration file would write for a sample of work items, optionally syncing them with it", runCanary},
+	{"pause", "stop a pair writing to either side, for a while or until resumed, holding its changes back", runPause},
+	{"resume", "end the pause of a pair, so its next cycle syncs the changes held back", runResume},
+	{"status", "show the outcome and statistics of each pair's recent sync cycles", runStatus},
+	{"dashboard", "show a live terminal dashboard of the cycles, health, rate limits and errors of every pair", runDashboard},
+	{"init", "walk through connecting ADO and Asana and picking the projects to sync, and write a checked configuration file", runInit},
+	{"validate", "check the configuration and the credentials for both APIs", runValidate},
+	{"discover", "list the ADO area paths, iterations and work item types, or the Asana workspaces, projects, sections and custom fields, to write the configuration with", runDiscover},
+	{"users", "with verify, list the assignees of every pair and the Asana user each is matched to", runUsers},
+	{"login", "authorize the app with Asana using OAuth and store the token", runLogin},
+	{"digest", "email the digest of the tasks created and completed, open conflicts and failing items of every pair", runDigest},
+	{"history", "show the audit log of the writes made to either system", runHistory},
+	{"journal", "with list, show the changes found by each sync cycle; with replay, apply them again from a cycle onwards", runJournal},
+	{"export", "with analytics, write the work item samples recorded for burndown and cycle time analytics as CSV or parquet", runExport},
+	{"migrate", "apply mapping database schema migrations, optionally copying the data to another store", runMigrate},
+	{"store", "with export or import, dump the mapping database to a file or restore it into any store; with rekey, encrypt it again with STORE_KEY; with purge, scrub a user or old records", runStore},
+	{"version", "print the version", runVersion},
+}
+
+// runServe syncs until ctx is done.
+func runServe(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	_ = fs.Parse(args)
+	if dir := os.Getenv("TENANTS_DIR"); dir != "" {
+		return serveTenants(ctx, dir)
+	}
+
+	a, err := openApp(ctx, false)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+	rate, err := warmUpRate()
+	if err != nil {
+		return err
+	}
+	// An unavailable provider does not stop the service: pairs are validated by their first cycle once it
+	// recovers, and cycles run degraded until then.
+	a.probe(ctx)
+	if rate > 0 {
+		slog.Info("warming up before the first cycles", "requests_per_second", rate)
+		err = a.manager.WarmUp(ctx, rate)
+	} else {
+		err = a.manager.Validate(ctx)
+	}
+	if err != nil {
+		if !sync.Unavailable(err) {
+			return err
+		}
+		slog.Warn("could not validate sync pairs, a provider is unavailable", "error", err)
+	}
+
+	checker, err := a.healthChecker()
+	if err != nil {
+		return err
+	}
+	elector, err := a.elector()
+	if err != nil {
+		return err
+	}
+	sharder, err := a.sharder()
+	if err != nil {
+		return err
+	}
+	if elector != nil && sharder != nil {
+		return errors.New("SHARDING and LEADER_ELECTION cannot be combined")
+	}
+	if sharder != nil {
+		sharder.OnChange = checker.SetOwned
+		for _, p := range sharder.Pairs {
+			checker.SetOwned(p, false)
+		}
+	}
+	var leading atomic.Bool
+	if elector != nil {
+		checker.SetStandby(true)
+		elector.OnChange = func(l bool) {
+			leading.Store(l)
+			checker.SetStandby(!l)
+		}
+	}
+
+	if addr := os.Getenv("WEBHOOK_ADDR"); addr != "" {
+		hooks := webhook.NewServer(a.manager, a.store)
+		hooks.ADOUsername = os.Getenv("ADO_HOOK_USERNAME")
+		hooks.ADOPassword = os.Getenv("ADO_HOOK_PASSWORD")
+		if v := os.Getenv("ADO_HOOK_UNAUTHENTICATED"); v != "" {
+			if hooks.ADOUnauthenticated, err = strconv.ParseBool(v); err != nil {
+				return fmt.Errorf("invalid ADO_HOOK_UNAUTHENTICATED: %w", err)
+			}
+		}
+		if hooks.ADOUsername == "" && hooks.ADOPassword == "" && !hooks.ADOUnauthenticated {
+			return errors.New("ADO_HOOK_USERNAME and ADO_HOOK_PASSWORD are required when WEBHOOK_ADDR is set, or set ADO_HOOK_UNAUTHENTICATED=true to accept unauthenticated service hooks")
+		}
+		if elector != nil {
+			hooks.Active = leading.Load
+		}
+		go hooks.Run(ctx)
+		serve(ctx, "webhook", addr, hooks.Handler())
+	}
+	if addr := os.Getenv("ADMIN_ADDR"); addr != "" {
+		token := os.Getenv("ADMIN_TOKEN")
+		if token == "" {
+			return errors.New("ADMIN_TOKEN is required when ADMIN_ADDR is set")
+		}
+		serve(ctx, "admin", addr, a.adminHandler(token))
+	}
+	serveProbes(ctx, map[string]http.Handler{
+		"/healthz": checker.Handler(),
+		"/readyz":  checker.Handler(),
+		"/status":  a.statusHandler(checker),
+	})
+	go a.watchConfig(ctx)
+	if v := os.Getenv("DIGEST_SCHEDULE"); v != "" {
+		sched, err := schedule.Parse(v)
+		if err != nil {
+			return fmt.Errorf("invalid DIGEST_SCHEDULE: %w", err)
+		}
+		d, err := newDigester(ctx, a)
+		if err != nil {
+			return err
+		}
+		if d == nil {
+			return errors.New("DIGEST_TO is required when DIGEST_SCHEDULE is set")
+		}
+		var active func() bool
+		if elector != nil {
+			active = leading.Load
+		}
+		go d.Run(ctx, sched, a.store, active)
+	}
+
+	onCycle := func(e *sync.Engine, rep *sync.Report, err error) {
+		checker.CycleFinished(e.Name())
+		a.notify(ctx, e, rep, err)
+		logCycle(e, rep, err)
+	}
+	announceReady(ctx, checker.Live)
+	switch {
+	case sharder != nil:
+		metrics.Leader.Set(1)
+		sharder.Run(ctx, func(ctx context.Context, pair string) {
+			if err := a.manager.RunPair(ctx, pair, onCycle); err != nil {
+				slog.Warn("claimed a pair that is no longer configured", logging.KeyPair, pair, "error", err)
+			}
+		})
+	case elector != nil:
+		elector.Run(ctx, func(ctx context.Context) { a.manager.Run(ctx, onCycle) })
+	default:
+		metrics.Leader.Set(1)
+		a.manager.Run(ctx, onCycle)
+	}
+	return nil
+}
+
+// logCycle logs the outcome of a cycle of e.
+func logCycle(e *sync.Engine, rep *sync.Report, err error) {
+	if errors.Is(err, sync.ErrDegraded) {
+		slog.Warn("sync cycle ran degraded", logging.KeyPair, e.Name(), "error", err)
+		return
+	}
+	if errors.Is(err, ratelimit.ErrBudgetExhausted) {
+		slog.Warn("sync cycle stopped by a call budget, the next cycle resumes it", logging.KeyPair, e.Name(), "error", err)
+		return
+	}
+	if errors.Is(err, sync.ErrInterrupted) {
+		slog.Info("sync cycle interrupted, the next cycle resumes it", logging.KeyPair, e.Name())
+		return
+	}
+	if errors.Is(err, sync.ErrPaused) {
+		slog.Info("sync cycle skipped, the pair is paused", logging.KeyPair, e.Name(), "error", err)
+		return
+	}
+	if err != nil {
+		slog.With(sync.ErrorAttrs(err)...).Error("sync cycle failed", logging.KeyPair, e.Name())
+		return
+	}
+	if len(rep.Failures) > 0 {
+		slog.Warn("sync cycle finished with failed work items", logging.KeyPair, e.Name(), "failed", len(rep.Failures))
+	}
+	if len(rep.Conflicts) > 0 {
+		slog.Warn("unresolved conflicts awaiting manual resolution", "conflicts", len(rep.Conflicts))
+		_ = rep.WriteConflicts(os.Stderr)
+	}
+}
+
+// leaseHolder returns the identity and TTL of the leases of the instance, from LEADER_ID and LEADER_LEASE.
+func leaseHolder() (string, time.Duration, error) {
+	id := os.Getenv("LEADER_ID")
+	if id == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return "", 0, fmt.Errorf("LEADER_ID is unset and the host name is unknown: %w", err)
+		}
+		id = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	var ttl time.Duration
+	if v := os.Getenv("LEADER_LEASE"); v != "" {
+		var err error
+		if ttl, err = time.ParseDuration(v); err != nil || ttl < 3*time.Second {
+			return "", 0, fmt.Errorf("invalid LEADER_LEASE %q, want a duration of at least 3s", v)
+		}
+	}
+	return id, ttl, nil
+}
+
+// sqlStore returns the SQL store under the app's store, or nil when the app does not use one.
+func (a *app) sqlStore() *store.SQL {
+	st := a.store
+	if enc, ok := st.(*store.Encrypted); ok {
+		st = enc.Store
+	}
+	s, _ := st.(*store.SQL)
+	return s
+}
+
+// sharder returns the sharder spreading the pairs across the instances sharing the store when SHARDING is
+// set, or nil when the instance syncs every pair. Pairs added by a configuration reload are only shared once
+// the instances restart.
+func (a *app) sharder() (*leader.Sharder, error) {
+	v := os.Getenv("SHARDING")
+	if v == "" {
+		return nil, nil
+	}
+	on, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SHARDING: %w", err)
+	}
+	if !on {
+		return nil, nil
+	}
+	s := a.sqlStore()
+	if s == nil {
+		return nil, errors.New("SHARDING needs a sqlite:// or postgres:// STORE_URL")
+	}
+	id, ttl, err := leaseHolder()
+	if err != nil {
+		return nil, err
+	}
+	sh := &leader.Sharder{Store: s, Identity: id, TTL: ttl}
+	for _, e := range a.manager.Engines() {
+		sh.Pairs = append(sh.Pairs, e.Name())
+	}
+	return sh, nil
+}
+
+// elector returns the leader elector configured by LEADER_ELECTION, or nil when the replica always syncs.
+// The store lease needs a SQL store, as the file and S3 stores are not shared safely between processes.
+func (a *app) elector() (*leader.Elector, error) {
+	mode := os.Getenv("LEADER_ELECTION")
+	if mode == "" {
+		return nil, nil
+	}
+	id, ttl, err := leaseHolder()
+	if err != nil {
+		return nil, err
+	}
+	e := &leader.Elector{Identity: id, TTL: ttl}
+	name := getenv("LEADER_LEASE_NAME", "ado-asana-sync")
+	switch mode {
+	case "store":
+		s := a.sqlStore()
+		if s == nil {
+			return nil, errors.New("LEADER_ELECTION=store needs a sqlite:// or postgres:// STORE_URL")
+		}
+		e.Lock = leader.StoreLock{Store: s, Name: name}
+	case "kubernetes":
+		k, err := leader.InCluster(os.Getenv("LEADER_NAMESPACE"), name)
+		if err != nil {
+			return nil, err
+		}
+		e.Lock = k
+	default:
+		return nil, fmt.Errorf("invalid LEADER_ELECTION %q, want store or kubernetes", mode)
+	}
+	return e, nil
+}
+
+// healthChecker returns the checker behind the health endpoints. Each pair may go HEALTH_STALENESS, or
+// three of its intervals or longest schedule gaps when that is unset, without a cycle before it is reported
+// unhealthy.
+func (a *app) healthChecker() (*health.Checker, error) {
+	var staleness time.Duration
+	if v := os.Getenv("HEALTH_STALENESS"); v != "" {
+		var err error
+		if staleness, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid HEALTH_STALENESS: %w", err)
+		}
+	}
+	stores := map[string]store.Store{}
+	credentials := make([]health.Credential, 0, len(a.conns)+len(a.asanaConns))
+	for _, c := range a.conns {
+		stores[c.name] = c.store
+		credentials = append(credentials, health.Credential{Name: c.provider, Check: c.ado.Ping})
+	}
+	for _, c := range a.asanaConns {
+		credentials = append(credentials, health.Credential{Name: c.provider, Check: c.ping})
+	}
+	pairs := make([]health.Pair, 0, len(a.pairs))
+	for _, p := range a.pairs {
+		hp := health.Pair{Name: p.Name, Staleness: staleness, Store: stores[p.ADOConnection]}
+		if hp.Staleness <= 0 {
+			hp.Staleness = 3 * p.Interval
+			if p.Schedule != nil {
+				hp.Staleness = 3 * schedule.Gap(p.Schedule, time.Now())
+			}
+		}
+		pairs = append(pairs, hp)
+	}
+	return health.New(a.store, pairs, credentials...), nil
+}
+
+// runSync runs one cycle of every pair, or writes the plan of a dry run. With item, it syncs a single item.
+func runSync(ctx context.Context, args []string) error {
+	if len(args) > 0 && args[0] == "item" {
+		return runSyncItem(ctx, args[1:])
+	}
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "read from both systems without writing to either and print the planned changes")
+	planJSON := fs.String("plan-json", "", "with -dry-run, also write the plan as JSON to this file (- for stdout)")
+	record := fs.String("record", "", "record the API traffic of the cycle and the stores it starts from to this file, for replay")
+	_ = fs.Parse(args)
+
+	if *record != "" {
+		recorder = replay.NewRecorder()
+	}
+	a, err := openApp(ctx, *dryRun)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+	if *record != "" {
+		if err := a.recordStores(ctx); err != nil {
+			return err
+		}
+		defer saveRecording(*record)
+	}
+	if err := a.manager.Validate(ctx); err != nil {
+		return err
+	}
+	if *dryRun {
+		return planOnce(ctx, a.manager, *planJSON)
+	}
+	return a.syncOnce(ctx)
+}
+
+// syncOnce runs a cycle of every pair, logging its outcome, and fails when a pair or any work item failed.
+func (a *app) syncOnce(ctx context.Context) error {
+	failed := 0
+	for _, e := range a.manager.Engines() {
+		rep, err := e.Run(ctx)
+		a.notify(ctx, e, rep, err)
+		if errors.Is(err, sync.ErrPaused) {
+			slog.Info("sync cycle skipped, the pair is paused", logging.KeyPair, e.Name(), "error", err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("sync pair %q: %w", e.Name(), err)
+		}
+		slog.Info("sync cycle finished", logging.KeyPair, e.Name(), "synced", rep.Items-len(rep.Failures), "failed", len(rep.Failures), "removed", rep.Removed)
+		for _, f := range rep.Failures {
+			slog.With(sync.ErrorAttrs(f.Err)...).Error("failed to sync work item", logging.KeyPair, e.Name(), logging.KeyWorkItem, f.ADOID)
+		}
+		if len(rep.Conflicts) > 0 {
+			slog.Warn("unresolved conflicts awaiting manual resolution", "conflicts", len(rep.Conflicts))
+			_ = rep.WriteConflicts(os.Stderr)
+		}
+		failed += len(rep.Failures)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d work items failed to sync", failed)
+	}
+	return nil
+}
+
+// runSyncItem syncs the single work item given by -ado-id, or mapped to the task given by -asana-gid, without
+// running a cycle, and prints the fields of the work item and task the sync changed.
+func runSyncItem(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("sync item", flag.ExitOnError)
+	adoID := fs.Int("ado-id", 0, "sync the work item with this ID")
+	taskGID := fs.String("asana-gid", "", "sync the work item mapped to the Asana task with this GID")
+	org := fs.String("org", "", "URL of the ADO organization of the work item, when several are configured")
+	_ = fs.Parse(args)
+	if (*adoID == 0) == (*taskGID == "") {
+		return errors.New("usage: sync item -ado-id <id> [-org url] | sync item -asana-gid <gid>")
+	}
+
+	a, err := openApp(ctx, false)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+	if err := a.manager.Validate(ctx); err != nil {
+		return err
+	}
+	if *taskGID != "" {
+		if *org, *adoID, err = a.manager.TaskItem(ctx, *taskGID); err != nil {
+			return err
+		}
+	}
+
+	before, err := a.manager.ItemState(ctx, *org, *adoID)
+	if err != nil {
+		return err
+	}
+	rep, err := a.manager.SyncItem(ctx, *org, *adoID)
+	if err != nil {
+		return fmt.Errorf("sync work item %d: %w", *adoID, err)
+	}
+	for _, f := range rep.Failures {
+		err = f.Err
+		slog.With(sync.ErrorAttrs(f.Err)...).Error("failed to sync work item", logging.KeyPair, before.Pair, logging.KeyWorkItem, f.ADOID)
+	}
+	if len(rep.Conflicts) > 0 {
+		slog.Warn("unresolved conflicts awaiting manual resolution", "conflicts", len(rep.Conflicts))
+		_ = rep.WriteConflicts(os.Stderr)
+	}
+	after, serr := a.manager.ItemState(ctx, *org, *adoID)
+	if serr != nil {
+		return serr
+	}
+
+	changes := before.Diff(after)
+	slog.Info("work item synced", logging.KeyPair, after.Pair, logging.KeyWorkItem, *adoID, "changes", len(changes))
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SYSTEM\tFIELD\tBEFORE\tAFTER")
+	for _, c := range changes {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.System, c.Field, shorten(c.Before), shorten(c.After))
+	}
+	if ferr := tw.Flush(); ferr != nil {
+		return ferr
+	}
+	if err != nil {
+		return fmt.Errorf("sync work item %d: %w", *adoID, err)
+	}
+	return nil
+}
+
+// runBackfill backfills every pair, or the one named by -pair, drawing a progress bar on a terminal and
+// logging the progress of every page otherwise.
+func runBackfill(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	pair := fs.String("pair", "", "only backfill this sync pair")
+	pageSize := fs.Int("page-size", sync.DefaultBackfillPage, "work items synced between checkpoints")
+	restart := fs.Bool("restart", false, "discard the checkpoint of an interrupted backfill and start over")
+	_ = fs.Parse(args)
+
+	a, err := openApp(ctx, false)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+	if err := a.manager.Validate(ctx); err != nil {
+		return err
+	}
+
+	fi, _ := os.Stderr.Stat()
+	tty := fi != nil && fi.Mode()&os.ModeCharDevice != 0
+	found, failed := false, 0
+	for _, e := range a.manager.Engines() {
+		if *pair != "" && e.Name() != *pair {
+			continue
+		}
+		found = true
+		name := e.Name()
+		progress := func(p sync.BackfillProgress) {
+			if tty {
+				fmt.Fprintf(os.Stderr, "\r\033[K%s %s", name, progressBar(p))
+				return
+			}
+			slog.Info("backfill progress", logging.KeyPair, name, "done", p.Done, "total", p.Total, "failed", p.Failed, "remaining", p.Remaining.String())
+		}
+		rep, err := e.Backfill(ctx, sync.BackfillOptions{PageSize: *pageSize, Restart: *restart, Progress: progress})
+		if tty {
+			fmt.Fprintln(os.Stderr)
+		}
+		if err != nil {
+			return fmt.Errorf("backfill pair %q: %w", name, err)
+		}
+		for _, f := range rep.Failures {
+			slog.With(sync.ErrorAttrs(f.Err)...).Error("failed to sync work item", logging.KeyPair, name, logging.KeyWorkItem, f.ADOID)
+		}
+		if len(rep.Conflicts) > 0 {
+			slog.Warn("unresolved conflicts awaiting manual resolution", "conflicts", len(rep.Conflicts))
+			_ = rep.WriteConflicts(os.Stderr)
+		}
+		failed += len(rep.Failures)
+	}
+	if *pair != "" && !found {
+		return fmt.Errorf("no sync pair named %q", *pair)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d work items failed to sync", failed)
+	}
+	return nil
+}
+
+// runDrift checks the mappings of every pair for drift and lists what drifted. It fails when a drifted
+// mapping is left unrepaired.
+func runDrift(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("drift", flag.ExitOnError)
+	pair := fs.String("pair", "", "only check this sync pair")
+	repair := fs.Bool("repair", false, "repair the drift found instead of only reporting it")
+	_ = fs.Parse(args)
+
+	a, err := openApp(ctx, false)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+	if err := a.manager.Validate(ctx); err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PAIR\tWORK ITEM\tTASK\tDRIFT\tREPAIRED\tERROR")
+	found, left := false, 0
+	for _, e := range a.manager.Engines() {
+		if *pair != "" && e.Name() != *pair {
+			continue
+		}
+		found = true
+		rep, err := e.CheckDrift(ctx, *repair)
+		if err != nil {
+			return fmt.Errorf("drift check of pair %q: %w", e.Name(), err)
+		}
+		for _, d := range rep.Drift {
+			msg := ""
+			if d.Err != nil {
+				msg = d.Err.Error()
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%t\t%s\n", e.Name(), d.ADOID, d.AsanaGID, d.Kind, d.Repaired, msg)
+		}
+		slog.Info("drift check", logging.KeyPair, e.Name(), "checked", rep.Checked, "drifted", len(rep.Drift), "score", rep.Score())
+		left += len(rep.Drift) - rep.Repaired()
+	}
+	if *pair != "" && !found {
+		return fmt.Errorf("no sync pair named %q", *pair)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if left > 0 {
+		return fmt.Errorf("%d mappings drifted", left)
+	}
+	return nil
+}
+
+// progressBar renders the progress of a backfill, for example "[#####---------------] 25% 250/1000, 2 failed, ETA 3m0s".
+func progressBar(p sync.BackfillProgress) string {
+	const width = 20
+	pct := 100
+	if p.Total > 0 {
+		pct = p.Done * 100 / p.Total
+	}
+	bar := strings.Repeat("#", pct*width/100) + strings.Repeat("-", width-pct*width/100)
+	s := fmt.Sprintf("[%s] %d%% %d/%d", bar, pct, p.Done, p.Total)
+	if p.Failed > 0 {
+		s += fmt.Sprintf(", %d failed", p.Failed)
+	}
+	if p.Remaining > 0 {
+		s += ", ETA " + p.Remaining.String()
+	}
+	return s
+}
+
+// runStatus prints the last cycle of every configured pair from the store.
+func runStatus(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	last := fs.Int("last", 1, fmt.Sprintf("show the last `n` cycles of each pair, up to %d", sync.HistoryLength))
+	_ = fs.Parse(args)
+
+	pairs, err := loadPairs()
+	if err != nil {
+		return err
+	}
+	// Pairs record their cycles in the store of their connection.
+	stores := map[string]store.Store{}
+	defer func() {
+		for _, st := range stores {
+			st.Close()
+		}
+	}()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PAIR\tSTARTED\tDURATION\tKIND\tSCANNED\tCREATED\tUPDATED\tSKIPPED\tFAILED\tCONFLICTS\tAPI CALLS\tLAST SUCCESS\tERROR")
+	for _, p := range pairs {
+		cycles, err := pairCycles(ctx, stores, p, *last)
+		if err != nil {
+			return err
+		}
+		if len(cycles) == 0 {
+			fmt.Fprintf(tw, "%s\tnever\t\t\t\t\t\t\t\t\t\t\t\n", p.Name)
+			continue
+		}
+		for _, s := range cycles {
+			kind := "incremental"
+			if s.Full {
+				kind = "full"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t%s\n", s.Pair, formatTime(s.Started),
+				s.Duration().Round(time.Second), kind, s.Items, s.Created, s.Updated, s.Skipped, s.Failed, s.Conflicts, s.APICalls,
+				formatTime(s.LastSuccess), s.Error)
+		}
+	}
+	return tw.Flush()
+}
+
+// formatTime formats t for the status table.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+// runUsers runs a users subcommand. The only one is verify, which scans the work items of every pair and
+// reports how each assignee is matched, failing when some are unmatched.
+func runUsers(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "verify" {
+		return errors.New("usage: users verify")
+	}
+	fs := flag.NewFlagSet("users verify", flag.ExitOnError)
+	_ = fs.Parse(args[1:])
+
+	a, err := openApp(ctx, false)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	unmatched := 0
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PAIR	ADO USER	NAME	ITEMS	ASANA USER	MATCHED BY")
+	for _, e := range a.manager.Engines() {
+		matches, err := e.ScanAssignees(ctx)
+		if err != nil {
+			return fmt.Errorf("sync pair %q: %w", e.Name(), err)
+		}
+		for _, m := range matches {
+			user, method := "-", "unmatched"
+			if m.User != nil {
+				user, method = fmt.Sprintf("%s (%s)", m.User.Name, m.User.GID), m.Method
+			} else {
+				unmatched++
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\n", e.Name(), m.Identity.UniqueName, m.Identity.DisplayName, m.Items, user, method)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if unmatched > 0 {
+		return fmt.Errorf("%d assignees have no asana user, add them to the user mappings", unmatched)
+	}
+	return nil
+}
+
+// runHistory prints the audit records matching the flags, most recent last.
+func runHistory(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	var f store.AuditFilter
+	fs.StringVar(&f.Pair, "pair", "", "only show the writes of this sync pair")
+	fs.IntVar(&f.ADOID, "item", 0, "only show the writes about this work item")
+	fs.StringVar(&f.AsanaGID, "task", "", "only show the writes about this Asana task")
+	fs.StringVar(&f.CycleID, "cycle", "", "only show the writes of this sync cycle")
+	since := fs.Duration("since", 0, "only show the writes made within this duration, for example 24h")
+	fs.IntVar(&f.Limit, "limit", 100, "show at most this many of the most recent records, or every record when 0")
+	jsonOut := fs.String("json", "", "write the records as JSON to this file (- for stdout) instead of a table")
+	conn := fs.String("connection", "", "read the store of this ADO connection")
+	_ = fs.Parse(args)
+	if *since > 0 {
+		f.Since = time.Now().Add(-*since)
+	}
+
+	location, err := connectionStore(*conn)
+	if err != nil {
+		return err
+	}
+	st, err := openStoreAt(ctx, location)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	records, err := st.Audit(ctx, f)
+	if err != nil {
+		return err
+	}
+	if *jsonOut != "" {
+		if records == nil {
+			records = []store.AuditRecord{}
+		}
+		return writeJSON(*jsonOut, records)
+	}
+	if len(records) == 0 {
+		fmt.Println("No writes recorded.")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tPAIR\tCYCLE\tSYSTEM\tACTION\tWORK ITEM\tTASK\tFIELD\tBEFORE\tAFTER")
+	for _, r := range records {
+		id := ""
+		if r.ADOID != 0 {
+			id = fmt.Sprint(r.ADOID)
+		}
+		head := fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s\t%s", formatTime(r.Time), r.Pair, r.CycleID, r.System, r.Action, id, r.AsanaGID)
+		if len(r.Changes) == 0 {
+			fmt.Fprintf(tw, "%s\t\t\t\n", head)
+		}
+		// Further changes of the same write leave the record columns blank.
+		for i, c := range r.Changes {
+			if i > 0 {
+				head = "\t\t\t\t\t\t"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", head, c.Field, shorten(c.Before), shorten(c.After))
+		}
+	}
+	return tw.Flush()
+}
+
+// shorten trims long values, such as notes, to a single short line for the history table.
+func shorten(s string) string {
+	const max = 60
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > max {
+		return string(r[:max-3]) + "..."
+	}
+	return s
+}
+
+// writeJSON writes v as indented JSON to the file at path, or to stdout when path is "-".
+func writeJSON(path string, v interface{}) error {
+	if path == "-" {
+		return encodeJSON(os.Stdout, v)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := encodeJSON(f, v); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func encodeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// runMigrate opens the store, which applies pending schema migrations, and optionally copies every record
+// into the store given by -to, for example to move from the JSON file to a database.
+func runMigrate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	to := fs.String("to", "", "copy every record into the store at this location after migrating")
+	conn := fs.String("connection", "", "migrate the store of this ADO connection")
+	_ = fs.Parse(args)
+
+	location, err := connectionStore(*conn)
+	if err != nil {
+		return err
+	}
+	src, err := openStoreAt(ctx, location)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	logSchema(ctx, location, src)
+	if *to == "" {
+		return nil
+	}
+
+	snap, err := src.Export(ctx)
+	if err != nil {
+		return err
+	}
+	dst, err := openStoreAt(ctx, *to)
+	if err != nil {
+		return err
+	}
+	logSchema(ctx, *to, dst)
+	if err := dst.Import(ctx, snap); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	slog.Info("copied store", append([]interface{}{"to", *to}, snapshotCounts(snap)...)...)
+	return nil
+}
+
+// snapshotCounts returns the number of records of each kind in snap as log attributes.
+func snapshotCounts(snap *store.Snapshot) []interface{} {
+	return []interface{}{"mappings", len(snap.Mappings), "conflicts", len(snap.Conflicts), "comments", len(snap.Comments),
+		"attachments", len(snap.Attachments), "retries", len(snap.Retries), "projects", len(snap.Projects),
+		"audit", len(snap.Audit), "journal", len(snap.Journal), "samples", len(snap.Samples), "settings", len(snap.Settings)}
+}
+
+// runStore runs a store subcommand: export dumps every record of the mapping database to a file, import
+// restores such a file into a store of any backend, and rekey encrypts the records again with STORE_KEY.
+func runStore(ctx context.Context, args []string) error {
+	const usage = "usage: store export [-o file] [-format json|ndjson] | store import [-to location] [-merge] <file> | store rekey | store purge [-user email] [-name name] [-older-than duration]"
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	switch args[0] {
+	case "export":
+		return runStoreExport(ctx, args[1:])
+	case "import":
+		return runStoreImport(ctx, args[1:])
+	case "rekey":
+		return runStoreRekey(ctx, args[1:])
+	case "purge":
+		return runStorePurge(ctx, args[1:])
+	}
+	return errors.New(usage)
+}
+
+// runStoreExport writes every record of the store, watermarks and audit log included, to a versioned JSON
+// or NDJSON file.
+func runStoreExport(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("store export", flag.ExitOnError)
+	out := fs.String("o", "-", "write the export to this file, - for stdout")
+	format := fs.String("format", "", "json or ndjson, defaulting to ndjson for .ndjson and .jsonl files and json otherwise")
+	conn := fs.String("connection", "", "export the store of this ADO connection")
+	_ = fs.Parse(args)
+
+	location, err := connectionStore(*conn)
+	if err != nil {
+		return err
+	}
+	st, err := openStoreAt(ctx, location)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	snap, err := st.Export(ctx)
+	if err != nil {
+		return err
+	}
+	if *format == "" {
+		*format = store.FormatFor(*out)
+	}
+	if *out == "-" {
+		return store.WriteSnapshot(os.Stdout, snap, *format)
+	}
+	// Exports hold the webhook secret and the sealed OAuth token, so only the owner may read them.
+	f, err := os.OpenFile(*out, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := store.WriteSnapshot(f, snap, *format); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	slog.Info("exported store", append([]interface{}{"store", location, "to", *out, "format", *format}, snapshotCounts(snap)...)...)
+	return nil
+}
+
+// runStoreImport restores an export into the configured store, or the one given by -to. Stores that already
+// hold mappings are only written with -merge.
+func runStoreImport(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("store import", flag.ExitOnError)
+	to := fs.String("to", "", "restore into the store at this location instead of the configured one")
+	conn := fs.String("connection", "", "restore into the store of this ADO connection")
+	merge := fs.Bool("merge", false, "import into a store that already holds mappings, replacing records with the same key")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: store import [-to location] [-connection name] [-merge] <file|->")
+	}
+
+	in := os.Stdin
+	if path := fs.Arg(0); path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	snap, err := store.ReadSnapshot(in)
+	if err != nil {
+		return err
+	}
+
+	location := *to
+	if location == "" {
+		if location, err = connectionStore(*conn); err != nil {
+			return err
+		}
+	}
+	dst, err := openStoreAt(ctx, location)
+	if err != nil {
+		return err
+	}
+	if !*merge {
+		existing, err := dst.All(ctx)
+		if err != nil {
+			dst.Close()
+			return err
+		}
+		if len(existing) > 0 {
+			dst.Close()
+			return fmt.Errorf("store %s already holds %d mappings, import with -merge to replace their records", location, len(existing))
+		}
+	}
+	if err := dst.Import(ctx, snap); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	slog.Info("imported store", append([]interface{}{"store", location, "version", snap.Version}, snapshotCounts(snap)...)...)
+	return nil
+}
+
+// runStoreRekey seals every encrypted value of the store with STORE_KEY, after which the keys of
+// STORE_PREVIOUS_KEYS can be dropped. It also encrypts a store written before STORE_KEY was set.
+func runStoreRekey(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("store rekey", flag.ExitOnError)
+	conn := fs.String("connection", "", "rekey the store of this ADO connection")
+	_ = fs.Parse(args)
+
+	location, err := connectionStore(*conn)
+	if err != nil {
+		return err
+	}
+	st, err := openStoreAt(ctx, location)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	enc, ok := st.(*store.Encrypted)
+	if !ok {
+		return errors.New("STORE_KEY must be set to rekey the store")
+	}
+	n, err := enc.Rekey(ctx)
+	if err != nil {
+		return err
+	}
+	if err := st.Flush(ctx); err != nil {
+		return err
+	}
+	slog.Info("rekeyed store", "store", location, "values", n)
+	return nil
+}
+
+// runStorePurge scrubs the identity given by -user and -name from the store, and removes the audit records,
+// journal entries and analytics samples older than -older-than.
+func runStorePurge(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("store purge", flag.ExitOnError)
+	user := fs.String("user", "", "replace this email address everywhere in the store")
+	name := fs.String("name", "", "also replace this display name, such as that of the user")
+	olderThan := fs.Duration("older-than", 0, "remove the audit records, journal entries and analytics samples older than this, for example 720h")
+	conn := fs.String("connection", "", "purge the store of this ADO connection")
+	_ = fs.Parse(args)
+	if (*user == "" && *name == "" && *olderThan <= 0) || *olderThan < 0 {
+		return errors.New("usage: store purge [-user email] [-name name] [-older-than duration] [-connection name]")
+	}
+
+	location, err := connectionStore(*conn)
+	if err != nil {
+		return err
+	}
+	st, err := openStoreAt(ctx, location)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	if *olderThan > 0 {
+		before := time.Now().Add(-*olderThan)
+		audit, err := st.PruneAudit(ctx, before)
+		if err != nil {
+			return err
+		}
+		journal, err := st.PruneJournal(ctx, before)
+		if err != nil {
+			return err
+		}
+		samples, err := st.PruneSamples(ctx, before)
+		if err != nil {
+			return err
+		}
+		slog.Info("removed old records", "store", location, "before", before.Format(time.RFC3339), "audit", audit, "journal", journal,
+			"samples", samples)
+	}
+	if *user != "" || *name != "" {
+		n, err := store.Scrub(ctx, st, *user, *name)
+		if err != nil {
+			return err
+		}
+		slog.Info("scrubbed identity", "store", location, "values", n)
+	}
+	return st.Flush(ctx)
+}
+
+// logSchema logs the schema version of stores that have one.
+func logSchema(ctx context.Context, location string, st store.Store) {
+	if e, ok := st.(*store.Encrypted); ok {
+		st = e.Store
+	}
+	if s, ok := st.(*store.SQL); ok {
+		if v, err := s.SchemaVersion(ctx); err == nil {
+			slog.Info("store is up to date", "store", location, "schema_version", v)
+			return
+		}
+	}
+	slog.Info("store is up to date", "store", location)
+}
+
+// runVersion prints the version.
+func runVersion(context.Context, []string) error {
+	fmt.Println(versionString())
+	return nil
+}
+
+// serve runs an HTTP server for handler on addr in the background until ctx is done.
+func serve(ctx context.Context, name, addr string, handler http.Handler) {
+	srv := &http.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Shutdown(context.Background())
+	}()
+	go func() {
+		slog.Info("server listening", "server", name, "addr", addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("server failed", "server", name, "error", err)
+		}
+	}()
+}
+
+// serveProbes serves the metrics on METRICS_ADDR and the health endpoints, by path, on HEALTH_ADDR. They
+// share a server when they are given the same address.
+func serveProbes(ctx context.Context, healthRoutes map[string]http.Handler) {
+	muxes, names := map[string]*http.ServeMux{}, map[string]string{}
+	mux := func(addr, name string) *http.ServeMux {
+		if muxes[addr] == nil {
+			muxes[addr], names[addr] = http.NewServeMux(), name
+		} else {
+			names[addr] += " and " + name
+		}
+		return muxes[addr]
+	}
+	if addr := os.Getenv("METRICS_ADDR"); addr != "" {
+		mux(addr, "metrics").Handle("/metrics", metrics.Handler())
+	}
+	if addr := os.Getenv("HEALTH_ADDR"); addr != "" {
+		m := mux(addr, "health")
+		for path, h := range healthRoutes {
+			m.Handle(path, h)
+		}
+	}
+	for addr, m := range muxes {
+		serve(ctx, names[addr], addr, m)
+	}
+}
+
+// planOnce runs a single dry run cycle of every pair and writes the combined plan, also as JSON to planJSON
+// when it is set.
+func planOnce(ctx context.Context, manager *sync.Manager, planJSON string) error {
+	var plans []*sync.Plan
+	for _, e := range manager.Engines() {
+		rep, err := e.Run(ctx)
+		if err != nil {
+			return fmt.Errorf("sync pair %q: %w", e.Name(), err)
+		}
+		plans = append(plans, rep.Plan)
+	}
+	plan := sync.Merge(plans...)
+	if err := plan.WriteText(os.Stdout); err != nil {
+		return err
+	}
+	switch planJSON {
+	case "":
+		return nil
+	case "-":
+		return plan.WriteJSON(os.Stdout)
+	default:
+		f, err := os.Create(planJSON)
+		if err != nil {
+			return err
+		}
+		if err := plan.WriteJSON(f); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+}
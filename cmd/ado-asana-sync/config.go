@@ -0,0 +1,528 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/config"
+	"github.com/danstis/ado-asana-sync/internal/egress"
+	"github.com/danstis/ado-asana-sync/internal/ratelimit"
+	"github.com/danstis/ado-asana-sync/internal/schedule"
+	"github.com/danstis/ado-asana-sync/internal/sync"
+)
+
+// envPair reads the sync pair configured by the environment, which the pairs of configuration files start
+// from.
+func envPair() (sync.Config, error) {
+	cfg := sync.DefaultConfig()
+	cfg.ADOProject = os.Getenv("ADO_PROJECT")
+	cfg.AsanaWorkspace = os.Getenv("ASANA_WORKSPACE")
+	cfg.AsanaProject = os.Getenv("ASANA_PROJECT")
+	cfg.Query = os.Getenv("ADO_QUERY")
+
+	var err error
+	if cfg.Routes, err = sync.ParseRoutes(os.Getenv("SYNC_ROUTES")); err != nil {
+		return cfg, err
+	}
+	if v := os.Getenv("SYNC_PROVISION"); v != "" {
+		if cfg.Provision.Enabled, err = strconv.ParseBool(v); err != nil {
+			return cfg, fmt.Errorf("invalid SYNC_PROVISION: %w", err)
+		}
+	}
+	cfg.Provision.Template = os.Getenv("SYNC_PROVISION_TEMPLATE")
+	cfg.Provision.Team = os.Getenv("SYNC_PROVISION_TEAM")
+	cfg.Provision.Portfolio = os.Getenv("SYNC_PROVISION_PORTFOLIO")
+	if cfg.Direction, err = sync.ParseDirection(os.Getenv("SYNC_DIRECTION")); err != nil {
+		return cfg, err
+	}
+	if cfg.FieldDirections, err = sync.ParseFieldDirections(os.Getenv("SYNC_FIELD_DIRECTIONS")); err != nil {
+		return cfg, err
+	}
+	if err := sync.ValidateQuery(cfg.Query); err != nil {
+		return cfg, err
+	}
+	if cfg.ConflictStrategy, err = sync.ParseConflictStrategy(os.Getenv("SYNC_CONFLICT_STRATEGY")); err != nil {
+		return cfg, err
+	}
+	if v := os.Getenv("SYNC_COMMENTS"); v != "" {
+		if cfg.CommentDirection, err = sync.ParseDirection(v); err != nil {
+			return cfg, err
+		}
+	}
+	if v := os.Getenv("SYNC_ATTACHMENTS"); v != "" {
+		if cfg.AttachmentDirection, err = sync.ParseDirection(v); err != nil {
+			return cfg, err
+		}
+	}
+	if v := os.Getenv("SYNC_MAX_ATTACHMENT_SIZE"); v != "" {
+		if cfg.MaxAttachmentSize, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return cfg, fmt.Errorf("invalid SYNC_MAX_ATTACHMENT_SIZE: %w", err)
+		}
+	}
+
+	if cfg.SectionMappings, err = sync.ParseSectionMappings(os.Getenv("SYNC_SECTIONS")); err != nil {
+		return cfg, err
+	}
+	if cfg.States.States, err = sync.ParseStateMap(os.Getenv("SYNC_STATES")); err != nil {
+		return cfg, err
+	}
+	cfg.States.StatusField = os.Getenv("SYNC_STATUS_FIELD")
+	if err := cfg.ValidateStates(); err != nil {
+		return cfg, err
+	}
+	if v := os.Getenv("SYNC_TAGS"); v != "" {
+		if cfg.Tags.Direction, err = sync.ParseDirection(v); err != nil {
+			return cfg, err
+		}
+	}
+	cfg.Tags.Allow = sync.ParseTagPatterns(os.Getenv("SYNC_TAGS_ALLOW"))
+	cfg.Tags.Deny = sync.ParseTagPatterns(os.Getenv("SYNC_TAGS_DENY"))
+	if err := cfg.Tags.Validate(); err != nil {
+		return cfg, err
+	}
+	if cfg.Sprints.Mode, err = sync.ParseSprintMode(os.Getenv("SYNC_SPRINTS")); err != nil {
+		return cfg, err
+	}
+	cfg.Sprints.Field = os.Getenv("SYNC_SPRINT_FIELD")
+	cfg.Sprints.Backlog = os.Getenv("SYNC_SPRINT_BACKLOG")
+	if err := cfg.ValidateSprints(); err != nil {
+		return cfg, err
+	}
+	cfg.Board.Team = os.Getenv("SYNC_BOARD_TEAM")
+	cfg.Board.Board = os.Getenv("SYNC_BOARD")
+	if v := os.Getenv("SYNC_BOARD_SECTIONS"); v != "" {
+		if cfg.Board.Sections, err = strconv.ParseBool(v); err != nil {
+			return cfg, fmt.Errorf("invalid SYNC_BOARD_SECTIONS: %w", err)
+		}
+	}
+	cfg.Board.ColumnField = os.Getenv("SYNC_BOARD_COLUMN_FIELD")
+	cfg.Board.LaneField = os.Getenv("SYNC_BOARD_LANE_FIELD")
+	if err := cfg.ValidateBoard(); err != nil {
+		return cfg, err
+	}
+	cfg.Types = sync.ParseSkipTypes(os.Getenv("SYNC_SKIP_TYPES"))
+	cfg.OptOut.Tag = os.Getenv("SYNC_OPT_OUT_TAG")
+	cfg.OptOut.Field = os.Getenv("SYNC_OPT_OUT_FIELD")
+	cfg.Anchor = os.Getenv("SYNC_ANCHOR")
+	cfg.BackLink = os.Getenv("SYNC_BACK_LINK")
+	if v := os.Getenv("SYNC_HIERARCHY"); v != "" {
+		if cfg.Hierarchy, err = strconv.ParseBool(v); err != nil {
+			return cfg, fmt.Errorf("invalid SYNC_HIERARCHY: %w", err)
+		}
+	}
+	if v := os.Getenv("SYNC_DEPENDENCIES"); v != "" {
+		if cfg.Dependencies, err = strconv.ParseBool(v); err != nil {
+			return cfg, fmt.Errorf("invalid SYNC_DEPENDENCIES: %w", err)
+		}
+	}
+	if v := os.Getenv("SYNC_DEVELOPMENT"); v != "" {
+		if cfg.Development, err = strconv.ParseBool(v); err != nil {
+			return cfg, fmt.Errorf("invalid SYNC_DEVELOPMENT: %w", err)
+		}
+	}
+	if v := os.Getenv("SYNC_TEST_CASES"); v != "" {
+		if cfg.TestCases, err = strconv.ParseBool(v); err != nil {
+			return cfg, fmt.Errorf("invalid SYNC_TEST_CASES: %w", err)
+		}
+	}
+	if v := os.Getenv("SYNC_MANAGE_SCHEMA"); v != "" {
+		if cfg.ManageSchema, err = strconv.ParseBool(v); err != nil {
+			return cfg, fmt.Errorf("invalid SYNC_MANAGE_SCHEMA: %w", err)
+		}
+	}
+	if v := os.Getenv("SYNC_DUE_DATES"); v != "" {
+		if cfg.DueDates, err = strconv.ParseBool(v); err != nil {
+			return cfg, fmt.Errorf("invalid SYNC_DUE_DATES: %w", err)
+		}
+	}
+	cfg.Calendar.TimeZone = os.Getenv("SYNC_TIME_ZONE")
+	cfg.Calendar.WorkingDays = sync.ParseDays(os.Getenv("SYNC_WORKING_DAYS"))
+	cfg.Calendar.Holidays = sync.ParseDays(os.Getenv("SYNC_HOLIDAYS"))
+	if v := os.Getenv("SYNC_SNAP_DUE_DATES"); v != "" {
+		if cfg.Calendar.Snap, err = strconv.ParseBool(v); err != nil {
+			return cfg, fmt.Errorf("invalid SYNC_SNAP_DUE_DATES: %w", err)
+		}
+	}
+	if err := cfg.ValidateCalendar(); err != nil {
+		return cfg, err
+	}
+	cfg.Intake.Tag = os.Getenv("SYNC_INTAKE_TAG")
+	cfg.Intake.Section = os.Getenv("SYNC_INTAKE_SECTION")
+	cfg.Intake.Type = os.Getenv("SYNC_INTAKE_TYPE")
+	if cfg.Effort, err = sync.ParseEffort(os.Getenv("SYNC_EFFORT")); err != nil {
+		return cfg, err
+	}
+	if err := cfg.ValidateEffort(); err != nil {
+		return cfg, err
+	}
+	if cfg.Rollup, err = sync.ParseRollup(os.Getenv("SYNC_ROLLUP")); err != nil {
+		return cfg, err
+	}
+	if err := cfg.ValidateRollup(); err != nil {
+		return cfg, err
+	}
+	if v := os.Getenv("SYNC_STATUS_UPDATES"); v != "" {
+		if cfg.StatusUpdates.Enabled, err = strconv.ParseBool(v); err != nil {
+			return cfg, fmt.Errorf("invalid SYNC_STATUS_UPDATES: %w", err)
+		}
+	}
+	cfg.StatusUpdates.BlockedTag = os.Getenv("SYNC_BLOCKED_TAG")
+	cfg.MyTasks = sync.ParseMyTasks(os.Getenv("SYNC_MY_TASKS"))
+	cfg.Priority = sync.ParsePriority(os.Getenv("SYNC_PRIORITY_BOOSTS"))
+	if cfg.Links, err = sync.ParseLinks(os.Getenv("SYNC_LINKS")); err != nil {
+		return cfg, err
+	}
+	cfg.Links.BlockedByType = os.Getenv("SYNC_BLOCKED_BY_LINK")
+	if err := cfg.ValidateLinks(); err != nil {
+		return cfg, err
+	}
+	if cfg.UserMappings, err = sync.ParseUserMappings(os.Getenv("SYNC_USERS")); err != nil {
+		return cfg, err
+	}
+	if v := os.Getenv("SYNC_FUZZY_USERS"); v != "" {
+		if cfg.FuzzyUserMatching, err = strconv.ParseBool(v); err != nil {
+			return cfg, fmt.Errorf("invalid SYNC_FUZZY_USERS: %w", err)
+		}
+	}
+	if cfg.Removal.Policy, err = sync.ParseRemovalPolicy(os.Getenv("SYNC_REMOVAL")); err != nil {
+		return cfg, err
+	}
+	cfg.Removal.Section = os.Getenv("SYNC_REMOVAL_SECTION")
+	cfg.Removal.Tag = os.Getenv("SYNC_REMOVAL_TAG")
+	if cfg.Closing.Action, err = sync.ParseClosingAction(os.Getenv("SYNC_CLOSING")); err != nil {
+		return cfg, err
+	}
+	cfg.Closing.Grace = os.Getenv("SYNC_CLOSING_GRACE")
+	cfg.Closing.Section = os.Getenv("SYNC_CLOSING_SECTION")
+	if err := cfg.ValidateClosing(); err != nil {
+		return cfg, err
+	}
+	if v := os.Getenv("SYNC_ADD_MEMBERS"); v != "" {
+		if cfg.Members.Add, err = strconv.ParseBool(v); err != nil {
+			return cfg, fmt.Errorf("invalid SYNC_ADD_MEMBERS: %w", err)
+		}
+	}
+	if v := os.Getenv("SYNC_ADD_FOLLOWERS"); v != "" {
+		if cfg.Members.Follow, err = strconv.ParseBool(v); err != nil {
+			return cfg, fmt.Errorf("invalid SYNC_ADD_FOLLOWERS: %w", err)
+		}
+	}
+	cfg.Members.Fallback = os.Getenv("SYNC_FALLBACK_ASSIGNEE")
+	cfg.Recurrence.Tag = os.Getenv("SYNC_RECURRENCE_TAG")
+	cfg.Recurrence.Field = os.Getenv("SYNC_RECURRENCE_FIELD")
+	cfg.NameTemplate = os.Getenv("SYNC_NAME_TEMPLATE")
+	cfg.NotesTemplate = os.Getenv("SYNC_NOTES_TEMPLATE")
+	if cfg.NotesFormat, err = sync.ParseNotesFormat(os.Getenv("SYNC_NOTES_FORMAT")); err != nil {
+		return cfg, err
+	}
+	if cfg.NotesOverflow, err = sync.ParseOverflowPolicy(os.Getenv("SYNC_NOTES_OVERFLOW")); err != nil {
+		return cfg, err
+	}
+	if v := os.Getenv("SYNC_NOTES_LIMIT"); v != "" {
+		if cfg.NotesLimit, err = strconv.Atoi(v); err != nil {
+			return cfg, fmt.Errorf("invalid SYNC_NOTES_LIMIT: %w", err)
+		}
+	}
+	if err := cfg.ValidateTemplates(); err != nil {
+		return cfg, err
+	}
+
+	if v := os.Getenv("SYNC_WORKERS"); v != "" {
+		if cfg.Workers, err = strconv.Atoi(v); err != nil || cfg.Workers < 1 {
+			return cfg, fmt.Errorf("invalid SYNC_WORKERS %q", v)
+		}
+	}
+	if v := os.Getenv("SYNC_PREFETCH"); v != "" {
+		if cfg.Prefetch, err = strconv.Atoi(v); err != nil || cfg.Prefetch < 1 {
+			return cfg, fmt.Errorf("invalid SYNC_PREFETCH %q", v)
+		}
+	}
+	if v := os.Getenv("SYNC_QUERY_PAGE"); v != "" {
+		if cfg.QueryPage, err = strconv.Atoi(v); err != nil || cfg.QueryPage < 1 || cfg.QueryPage > ado.MaxQueryResults {
+			return cfg, fmt.Errorf("invalid SYNC_QUERY_PAGE %q", v)
+		}
+	}
+	if cfg.CallBudget, err = ratelimit.ParseCaps(os.Getenv("SYNC_CALL_BUDGET")); err != nil {
+		return cfg, fmt.Errorf("invalid SYNC_CALL_BUDGET: %w", err)
+	}
+	if v := os.Getenv("SYNC_INCREMENTAL"); v != "" {
+		if cfg.Incremental, err = strconv.ParseBool(v); err != nil {
+			return cfg, fmt.Errorf("invalid SYNC_INCREMENTAL: %w", err)
+		}
+	}
+	if v := os.Getenv("SYNC_FULL_INTERVAL"); v != "" {
+		if cfg.FullSyncInterval, err = time.ParseDuration(v); err != nil {
+			return cfg, fmt.Errorf("invalid SYNC_FULL_INTERVAL: %w", err)
+		}
+	}
+	if v := os.Getenv("SYNC_DRIFT_INTERVAL"); v != "" {
+		if cfg.DriftInterval, err = time.ParseDuration(v); err != nil {
+			return cfg, fmt.Errorf("invalid SYNC_DRIFT_INTERVAL: %w", err)
+		}
+	}
+	if v := os.Getenv("SYNC_DRIFT_REPAIR"); v != "" {
+		if cfg.DriftRepair, err = strconv.ParseBool(v); err != nil {
+			return cfg, fmt.Errorf("invalid SYNC_DRIFT_REPAIR: %w", err)
+		}
+	}
+	if v := os.Getenv("SYNC_INTERVAL"); v != "" {
+		if cfg.Interval, err = time.ParseDuration(v); err != nil {
+			return cfg, fmt.Errorf("invalid SYNC_INTERVAL: %w", err)
+		}
+	}
+	if v := os.Getenv("SYNC_SCHEDULE"); v != "" {
+		if cfg.Schedule, err = schedule.Parse(v); err != nil {
+			return cfg, fmt.Errorf("invalid SYNC_SCHEDULE: %w", err)
+		}
+	}
+	if v := os.Getenv("SYNC_MAINTENANCE"); v != "" {
+		for _, m := range strings.Split(v, ";") {
+			w, err := schedule.ParseWindow(m)
+			if err != nil {
+				return cfg, fmt.Errorf("invalid SYNC_MAINTENANCE: %w", err)
+			}
+			cfg.Maintenance = append(cfg.Maintenance, w)
+		}
+	}
+	if v := os.Getenv("SYNC_JITTER"); v != "" {
+		if cfg.Jitter, err = time.ParseDuration(v); err != nil {
+			return cfg, fmt.Errorf("invalid SYNC_JITTER: %w", err)
+		}
+	}
+	if v := os.Getenv("SYNC_RETRY_ATTEMPTS"); v != "" {
+		if cfg.Retry.MaxAttempts, err = strconv.Atoi(v); err != nil || cfg.Retry.MaxAttempts < 0 {
+			return cfg, fmt.Errorf("invalid SYNC_RETRY_ATTEMPTS %q", v)
+		}
+	}
+	if v := os.Getenv("SYNC_RETRY_BACKOFF"); v != "" {
+		if cfg.Retry.Backoff, err = time.ParseDuration(v); err != nil {
+			return cfg, fmt.Errorf("invalid SYNC_RETRY_BACKOFF: %w", err)
+		}
+	}
+	if v := os.Getenv("SYNC_RETRY_MAX_BACKOFF"); v != "" {
+		if cfg.Retry.MaxBackoff, err = time.ParseDuration(v); err != nil {
+			return cfg, fmt.Errorf("invalid SYNC_RETRY_MAX_BACKOFF: %w", err)
+		}
+	}
+	if v := os.Getenv("SHUTDOWN_TIMEOUT"); v != "" {
+		if cfg.ShutdownTimeout, err = time.ParseDuration(v); err != nil {
+			return cfg, fmt.Errorf("invalid SHUTDOWN_TIMEOUT: %w", err)
+		}
+	}
+	if v := os.Getenv("SYNC_AUDIT"); v != "" {
+		if cfg.Audit, err = strconv.ParseBool(v); err != nil {
+			return cfg, fmt.Errorf("invalid SYNC_AUDIT: %w", err)
+		}
+	}
+	if v := os.Getenv("SYNC_AUDIT_RETENTION"); v != "" {
+		if cfg.AuditRetention, err = time.ParseDuration(v); err != nil {
+			return cfg, fmt.Errorf("invalid SYNC_AUDIT_RETENTION: %w", err)
+		}
+	}
+	if v := os.Getenv("SYNC_JOURNAL"); v != "" {
+		if cfg.Journal, err = strconv.ParseBool(v); err != nil {
+			return cfg, fmt.Errorf("invalid SYNC_JOURNAL: %w", err)
+		}
+	}
+	if v := os.Getenv("SYNC_JOURNAL_RETENTION"); v != "" {
+		if cfg.JournalRetention, err = time.ParseDuration(v); err != nil {
+			return cfg, fmt.Errorf("invalid SYNC_JOURNAL_RETENTION: %w", err)
+		}
+	}
+	if v := os.Getenv("SYNC_ANALYTICS"); v != "" {
+		if cfg.Analytics, err = strconv.ParseBool(v); err != nil {
+			return cfg, fmt.Errorf("invalid SYNC_ANALYTICS: %w", err)
+		}
+	}
+	if v := os.Getenv("SYNC_ANALYTICS_RETENTION"); v != "" {
+		if cfg.AnalyticsRetention, err = time.ParseDuration(v); err != nil {
+			return cfg, fmt.Errorf("invalid SYNC_ANALYTICS_RETENTION: %w", err)
+		}
+	}
+
+	return cfg, nil
+}
+
+// loadPairs reads the sync pairs from the environment and the configuration file named by CONFIG_FILE.
+func loadPairs() ([]sync.Config, error) {
+	cfg, err := envPair()
+	if err != nil {
+		return nil, err
+	}
+	pairs := []sync.Config{cfg}
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		f, err := config.Load(path)
+		if err != nil {
+			return nil, err
+		}
+		if pairs, err = f.SyncPairs(cfg); err != nil {
+			return nil, err
+		}
+	}
+	return pairs, nil
+}
+
+// loadConnections returns the ADO connections the pairs use, the one set by ADO_ORG_URL and ADO_PAT first
+// followed by those of the configuration file in their order. It fails when the pairs of several
+// organizations would share a mapping store.
+func loadConnections(pairs []sync.Config) ([]config.ADOConnection, error) {
+	conns := []config.ADOConnection{{Name: sync.DefaultConnection, OrgURL: os.Getenv("ADO_ORG_URL"), PATEnv: "ADO_PAT",
+		CallBudget: os.Getenv("ADO_CALL_BUDGET")}}
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		f, err := config.Load(path)
+		if err != nil {
+			return nil, err
+		}
+		conns = append(conns, f.ADOConnections...)
+	}
+	used := map[string]bool{}
+	for _, p := range pairs {
+		used[p.ADOConnection] = true
+	}
+	var inUse []config.ADOConnection
+	stores := map[string]string{}
+	for _, c := range conns {
+		if !used[c.Name] {
+			continue
+		}
+		location := c.StoreURL
+		if location == "" {
+			location = storeLocation()
+		}
+		if other, ok := stores[location]; ok {
+			return nil, fmt.Errorf("ado connections %s and %s share the mapping store %s, set store_url on one of them", connectionName(other), connectionName(c.Name), location)
+		}
+		stores[location] = c.Name
+		inUse = append(inUse, c)
+	}
+	if len(inUse) < len(used) {
+		return nil, fmt.Errorf("sync pairs use unknown ado connections")
+	}
+	return inUse, nil
+}
+
+// loadAsanaConnections returns the Asana connections the pairs use, the one set by ASANA_TOKEN first followed
+// by those of the configuration file in their order.
+func loadAsanaConnections(pairs []sync.Config) ([]config.AsanaConnection, error) {
+	conns := []config.AsanaConnection{{Name: sync.DefaultConnection, TokenEnv: "ASANA_TOKEN", CallBudget: os.Getenv("ASANA_CALL_BUDGET")}}
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		f, err := config.Load(path)
+		if err != nil {
+			return nil, err
+		}
+		conns = append(conns, f.AsanaConnections...)
+	}
+	used := map[string]bool{}
+	for _, p := range pairs {
+		used[p.AsanaConnection] = true
+	}
+	var inUse []config.AsanaConnection
+	for _, c := range conns {
+		if used[c.Name] {
+			inUse = append(inUse, c)
+		}
+	}
+	if len(inUse) < len(used) {
+		return nil, fmt.Errorf("sync pairs use unknown asana connections")
+	}
+	return inUse, nil
+}
+
+// egressOptions returns the proxy and TLS settings of every HTTP client, from PROXY_URL, PROXY_PASSWORD,
+// TLS_CA_FILE, TLS_CERT_FILE and TLS_KEY_FILE.
+func egressOptions() egress.Options {
+	o := egress.Options{ProxyURL: os.Getenv("PROXY_URL"), CAFile: os.Getenv("TLS_CA_FILE"),
+		CertFile: os.Getenv("TLS_CERT_FILE"), KeyFile: os.Getenv("TLS_KEY_FILE")}
+	if o.ProxyURL != "" && os.Getenv("PROXY_PASSWORD") != "" {
+		o.ProxyPasswordEnv = "PROXY_PASSWORD"
+	}
+	return o
+}
+
+// connectionName names a connection in messages.
+func connectionName(name string) string {
+	if name == sync.DefaultConnection {
+		return "ADO_ORG_URL"
+	}
+	return fmt.Sprintf("%q", name)
+}
+
+// asanaConnectionName names an Asana connection in messages.
+func asanaConnectionName(name string) string {
+	if name == sync.DefaultConnection {
+		return "ASANA_TOKEN"
+	}
+	return fmt.Sprintf("%q", name)
+}
+
+// connectionStore returns the location of the mapping store of the named connection.
+func connectionStore(name string) (string, error) {
+	if name == sync.DefaultConnection {
+		return storeLocation(), nil
+	}
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		return "", fmt.Errorf("unknown ado connection %q, CONFIG_FILE is not set", name)
+	}
+	f, err := config.Load(path)
+	if err != nil {
+		return "", err
+	}
+	for _, c := range f.ADOConnections {
+		if c.Name == name {
+			if c.StoreURL == "" {
+				return storeLocation(), nil
+			}
+			return c.StoreURL, nil
+		}
+	}
+	return "", fmt.Errorf("unknown ado connection %q", name)
+}
+
+// fileEnv records the variables set from the env section of the configuration file.
+var fileEnv = map[string]bool{}
+
+// loadEnv sets the variables listed in the env section of the configuration file named by CONFIG_FILE that
+// are not set in the environment. On reload the variables set from the file follow its changes, except for
+// credentials, which the API clients read once.
+func loadEnv(reload bool) error {
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		return nil
+	}
+	f, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+	for name, v := range f.Env {
+		if _, set := os.LookupEnv(name); set && !fileEnv[name] {
+			continue
+		}
+		if reload && config.Credential(name) {
+			continue
+		}
+		if err := os.Setenv(name, v); err != nil {
+			return fmt.Errorf("setting %s: %w", name, err)
+		}
+		fileEnv[name] = true
+	}
+	for name := range fileEnv {
+		if _, ok := f.Env[name]; !ok && !config.Credential(name) {
+			os.Unsetenv(name)
+			delete(fileEnv, name)
+		}
+	}
+	return nil
+}
+
+// getenv returns the value of the environment variable key, or fallback when unset.
+func getenv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
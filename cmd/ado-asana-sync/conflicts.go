@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/store"
+	"github.com/danstis/ado-asana-sync/internal/sync"
+)
+
+// pairConflict is a queued conflict with the pair of its work item.
+type pairConflict struct {
+	Pair string `json:"pair"`
+	store.Conflict
+}
+
+// runConflicts runs a conflicts subcommand: list shows the conflicts queued for manual resolution, and
+// resolve applies the value an operator picks for each.
+func runConflicts(ctx context.Context, args []string) error {
+	const usage = "usage: conflicts list [-pair name] [-item id] [-json] | conflicts resolve [-pair name] [-item id] [-field name] [-pick ado|asana | -value merged]"
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	switch args[0] {
+	case "list":
+		return runConflictsList(ctx, args[1:])
+	case "resolve":
+		return runConflictsResolve(ctx, args[1:])
+	}
+	return errors.New(usage)
+}
+
+// runConflictsList prints the queued conflicts matching the flags, each field with the values of both sides.
+func runConflictsList(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("conflicts list", flag.ExitOnError)
+	pair := fs.String("pair", "", "only show the conflicts of this sync pair")
+	item := fs.Int("item", 0, "only show the conflicts of this work item")
+	asJSON := fs.Bool("json", false, "print the conflicts as JSON")
+	_ = fs.Parse(args)
+
+	a, err := openApp(ctx, false)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+	_, conflicts, err := queuedConflicts(ctx, a, *pair, *item, "")
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(conflicts)
+	}
+	if len(conflicts) == 0 {
+		fmt.Println("No conflicts queued.")
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PAIR\tWORK ITEM\tTASK\tFIELD\tADO\tASANA\tDETECTED")
+	for _, c := range conflicts {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n", c.Pair, c.ADOID, c.AsanaGID, c.Field, quoteValue(c.ADOValue), quoteValue(c.AsanaValue), formatTime(c.DetectedAt))
+	}
+	return tw.Flush()
+}
+
+// runConflictsResolve resolves the queued conflicts matching the flags. With -pick or -value every match is
+// resolved alike; otherwise each is shown with the values of both sides and the operator picks the winner
+// or types a merged value at the terminal.
+func runConflictsResolve(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("conflicts resolve", flag.ExitOnError)
+	pair := fs.String("pair", "", "only resolve the conflicts of this sync pair")
+	item := fs.Int("item", 0, "only resolve the conflicts of this work item")
+	field := fs.String("field", "", "only resolve the conflicts of this field, such as title or field:Microsoft.VSTS.Common.Priority")
+	pick := fs.String("pick", "", "keep the value of this side, ado or asana, without asking")
+	value := fs.String("value", "", "write this merged value to both sides without asking; needs -item and -field")
+	_ = fs.Parse(args)
+	merged := false
+	fs.Visit(func(f *flag.Flag) { merged = merged || f.Name == "value" })
+	switch {
+	case *pick != "" && *pick != sync.SystemADO && *pick != sync.SystemAsana:
+		return fmt.Errorf("unknown side %q, expected %s or %s", *pick, sync.SystemADO, sync.SystemAsana)
+	case *pick != "" && merged:
+		return errors.New("-pick and -value cannot be combined")
+	case merged && (*item == 0 || *field == ""):
+		return errors.New("a merged value is only written to the conflict given by -item and -field")
+	}
+
+	a, err := openApp(ctx, false)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+	engines, conflicts, err := queuedConflicts(ctx, a, *pair, *item, *field)
+	if err != nil {
+		return err
+	}
+	if len(conflicts) == 0 {
+		fmt.Println("No conflicts queued.")
+		return nil
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	resolved, failed := 0, 0
+conflicts:
+	for i, c := range conflicts {
+		var r sync.Resolution
+		switch {
+		case merged:
+			r.Merged = value
+		case *pick != "":
+			r.Winner = *pick
+		default:
+			if i == 0 || conflicts[i-1].Pair != c.Pair || conflicts[i-1].ADOID != c.ADOID {
+				showConflicts(c, conflicts[i:])
+			}
+			var ok bool
+			r, ok, err = askResolution(in, c)
+			if errors.Is(err, io.EOF) {
+				break conflicts
+			}
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+		}
+		if _, err := engines[c.Pair].ResolveConflict(ctx, c.ADOID, c.Field, r); err != nil {
+			slog.With(sync.ErrorAttrs(err)...).Error("failed to resolve conflict", logging.KeyPair, c.Pair, logging.KeyWorkItem, c.ADOID, "field", c.Field)
+			failed++
+			continue
+		}
+		slog.Info("resolved conflict", logging.KeyPair, c.Pair, logging.KeyWorkItem, c.ADOID, "field", c.Field)
+		resolved++
+	}
+	fmt.Printf("Resolved %d of %d conflicts.\n", resolved, len(conflicts))
+	if failed > 0 {
+		return fmt.Errorf("%d conflicts failed to resolve", failed)
+	}
+	return nil
+}
+
+// queuedConflicts returns the engines of the pairs matching pair by name, and their queued conflicts that
+// match the work item and field when they are given, ordered by pair and work item.
+func queuedConflicts(ctx context.Context, a *app, pair string, item int, field string) (map[string]*sync.Engine, []pairConflict, error) {
+	engines := map[string]*sync.Engine{}
+	var conflicts []pairConflict
+	for _, e := range a.manager.Engines() {
+		if pair != "" && e.Name() != pair {
+			continue
+		}
+		engines[e.Name()] = e
+		queued, err := e.Conflicts(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("sync pair %q: %w", e.Name(), err)
+		}
+		for _, c := range queued {
+			if (item == 0 || c.ADOID == item) && (field == "" || c.Field == field) {
+				conflicts = append(conflicts, pairConflict{Pair: e.Name(), Conflict: c})
+			}
+		}
+	}
+	if pair != "" && len(engines) == 0 {
+		return nil, nil, fmt.Errorf("no sync pair named %q", pair)
+	}
+	return engines, conflicts, nil
+}
+
+// showConflicts prints the fields in conflict of the work item of c, the first of conflicts, side by side.
+func showConflicts(c pairConflict, conflicts []pairConflict) {
+	fmt.Printf("\n[%s] work item %d, task %s\n", c.Pair, c.ADOID, c.AsanaGID)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "  FIELD\tADO (changed %s)\tASANA (modified %s)\n", formatTime(c.ADOChanged), formatTime(c.AsanaModified))
+	for _, o := range conflicts {
+		if o.Pair != c.Pair || o.ADOID != c.ADOID {
+			break
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", o.Field, quoteValue(o.ADOValue), quoteValue(o.AsanaValue))
+	}
+	_ = tw.Flush()
+}
+
+// askResolution asks how to resolve c, reading the answer from in. ok is false when the operator skips it,
+// and io.EOF is returned when they quit.
+func askResolution(in *bufio.Reader, c pairConflict) (r sync.Resolution, ok bool, err error) {
+	for {
+		fmt.Printf("%s: keep [a]do %s, keep a[s]ana %s, [e]dit a merged value, s[k]ip or [q]uit? ", c.Field, quoteValue(c.ADOValue), quoteValue(c.AsanaValue))
+		line, err := in.ReadString('\n')
+		if err != nil && line == "" {
+			return r, false, io.EOF
+		}
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "a", "ado":
+			return sync.Resolution{Winner: sync.SystemADO}, true, nil
+		case "s", "asana":
+			return sync.Resolution{Winner: sync.SystemAsana}, true, nil
+		case "e", "edit":
+			fmt.Print("Merged value: ")
+			v, err := in.ReadString('\n')
+			if err != nil && v == "" {
+				return r, false, io.EOF
+			}
+			v = strings.TrimRight(v, "\r\n")
+			return sync.Resolution{Merged: &v}, true, nil
+		case "", "k", "skip":
+			return r, false, nil
+		case "q", "quit":
+			return r, false, io.EOF
+		}
+	}
+}
+
+// quoteValue quotes a conflicting value, so empty values and surrounding spaces show.
+func quoteValue(v string) string {
+	const max = 60
+	if r := []rune(v); len(r) > max {
+		v = string(r[:max]) + "…"
+	}
+	return fmt.Sprintf("%q", v)
+}
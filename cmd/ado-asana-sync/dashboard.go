@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/health"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/ratelimit"
+	"github.com/danstis/ado-asana-sync/internal/store"
+	"github.com/danstis/ado-asana-sync/internal/sync"
+	"github.com/danstis/ado-asana-sync/internal/version"
+)
+
+// recentErrorCount is the number of logged errors the status endpoint shows.
+const recentErrorCount = 20
+
+// recentErrors keeps the last errors logged, set up by setupLogging.
+var recentErrors *logging.Recent
+
+// daemonStatus is the live state of the daemon served at /status.
+type daemonStatus struct {
+	Version   string           `json:"version"`
+	Started   time.Time        `json:"started"`
+	Pairs     []pairStatus     `json:"pairs"`
+	Providers []providerStatus `json:"providers"`
+	// Errors lists the last errors logged, oldest first.
+	Errors []logging.Entry `json:"errors"`
+}
+
+// pairStatus is the live state of a pair.
+type pairStatus struct {
+	Name     string        `json:"name"`
+	Progress sync.Progress `json:"progress"`
+	// Health is why the pair is reported unhealthy, empty while it cycles as it should.
+	Health string `json:"health,omitempty"`
+}
+
+// providerStatus is the state of an API connection.
+type providerStatus struct {
+	Name    string           `json:"name"`
+	Circuit string           `json:"circuit"`
+	Budget  ratelimit.Budget `json:"budget"`
+}
+
+// statusHandler returns the handler of /status, serving the live state of the daemon as JSON.
+func (a *app) statusHandler(checker *health.Checker) http.Handler {
+	started := time.Now()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := daemonStatus{Version: version.Version, Started: started, Pairs: []pairStatus{}, Providers: []providerStatus{}}
+		live := checker.Live()
+		for _, e := range a.manager.Engines() {
+			s.Pairs = append(s.Pairs, pairStatus{Name: e.Name(), Progress: e.Progress(), Health: live["loop:"+e.Name()]})
+		}
+		for _, c := range a.conns {
+			s.Providers = append(s.Providers, providerStatus{Name: c.provider, Circuit: c.breaker.State().String(), Budget: c.limiter.Budget()})
+		}
+		for _, c := range a.asanaConns {
+			s.Providers = append(s.Providers, providerStatus{Name: c.provider, Circuit: c.breaker.State().String(), Budget: c.limiter.Budget()})
+		}
+		s.Errors = []logging.Entry{}
+		if recentErrors != nil {
+			s.Errors = recentErrors.Entries()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(s)
+	})
+}
+
+// runDashboard shows the recent cycles of every pair from the store and, while the daemon runs, the
+// progress of its cycles, the health of the pairs, the rate limit budgets and the recent errors read from
+// its status endpoint, refreshing the screen until interrupted.
+func runDashboard(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("dashboard", flag.ExitOnError)
+	addr := fs.String("addr", daemonURL(os.Getenv("HEALTH_ADDR")), "`URL` of the status endpoint of the daemon")
+	interval := fs.Duration("interval", 2*time.Second, "time between refreshes")
+	once := fs.Bool("once", false, "print the dashboard once instead of refreshing it")
+	_ = fs.Parse(args)
+	if *interval <= 0 {
+		return errors.New("dashboard: -interval must be positive")
+	}
+
+	pairs, err := loadPairs()
+	if err != nil {
+		return err
+	}
+	stores := map[string]store.Store{}
+	defer func() {
+		for _, st := range stores {
+			st.Close()
+		}
+	}()
+	fi, _ := os.Stdout.Stat()
+	tty := fi != nil && fi.Mode()&os.ModeCharDevice != 0
+	client := &http.Client{Timeout: *interval}
+
+	for {
+		d := dashboard{now: time.Now(), color: tty, cycles: map[string][]sync.CycleStatus{}}
+		for _, p := range pairs {
+			d.pairs = append(d.pairs, p.Name)
+			cycles, err := pairCycles(ctx, stores, p, 5)
+			if err != nil {
+				return err
+			}
+			d.cycles[p.Name] = cycles
+		}
+		if *addr != "" {
+			d.status, d.statusErr = fetchStatus(ctx, client, *addr)
+		}
+		var buf bytes.Buffer
+		d.render(&buf)
+		if tty && !*once {
+			// Home the cursor and clear the screen before drawing the frame in one write, so it does not flicker.
+			os.Stdout.WriteString("\033[H\033[2J")
+		}
+		if _, err := buf.WriteTo(os.Stdout); err != nil || *once {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(*interval):
+		}
+	}
+}
+
+// daemonURL returns the URL of the status endpoint served on the health address addr, empty when the daemon
+// serves none.
+func daemonURL(addr string) string {
+	if addr == "" {
+		return ""
+	}
+	if strings.HasPrefix(addr, ":") {
+		addr = "localhost" + addr
+	}
+	return "http://" + addr + "/status"
+}
+
+// pairCycles returns the last n cycles of the pair from the store of its connection, opened into stores.
+func pairCycles(ctx context.Context, stores map[string]store.Store, p sync.Config, n int) ([]sync.CycleStatus, error) {
+	location, err := connectionStore(p.ADOConnection)
+	if err != nil {
+		return nil, err
+	}
+	if stores[location] == nil {
+		st, err := openStoreAt(ctx, location)
+		if err != nil {
+			return nil, err
+		}
+		stores[location] = st
+	}
+	cycles, err := sync.RecentCycles(ctx, stores[location], p.Name, n)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	return cycles, err
+}
+
+// fetchStatus reads the status of the daemon from url.
+func fetchStatus(ctx context.Context, client *http.Client, url string) (*daemonStatus, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status endpoint returned %s", resp.Status)
+	}
+	var s daemonStatus
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return nil, fmt.Errorf("decoding daemon status: %w", err)
+	}
+	return &s, nil
+}
+
+// dashboard is a frame of the dashboard.
+type dashboard struct {
+	now   time.Time
+	color bool
+	pairs []string
+	// cycles holds the recent cycles of each pair from the store, newest first.
+	cycles map[string][]sync.CycleStatus
+	// status is the state of the daemon, nil when it was not read. statusErr is why it could not be.
+	status    *daemonStatus
+	statusErr error
+}
+
+// ANSI colors of the dashboard.
+const (
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorBold   = "\033[1m"
+	colorReset  = "\033[0m"
+)
+
+// paint returns s in color when the dashboard is drawn on a terminal.
+func (d *dashboard) paint(color, s string) string {
+	if !d.color || s == "" {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// render draws the frame to w.
+func (d *dashboard) render(w io.Writer) {
+	fmt.Fprintf(w, "%s  %s\n", d.paint(colorBold, "ado-asana-sync dashboard"), d.now.Format("2006-01-02 15:04:05"))
+	switch {
+	case d.status != nil:
+		fmt.Fprintf(w, "daemon %s, up %s\n", d.status.Version, d.now.Sub(d.status.Started).Round(time.Second))
+	case d.statusErr != nil:
+		fmt.Fprintln(w, d.paint(colorYellow, "daemon unreachable: "+d.statusErr.Error()))
+	default:
+		fmt.Fprintln(w, "no daemon status endpoint, set HEALTH_ADDR or -addr")
+	}
+
+	live := map[string]pairStatus{}
+	if d.status != nil {
+		for _, p := range d.status.Pairs {
+			live[p.Name] = p
+		}
+	}
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PAIR\tCYCLE\tLAST CYCLE\tRESULT\tSCANNED\tCREATED\tUPDATED\tFAILED\tCONFLICTS\tLAST SUCCESS\tHEALTH")
+	for _, name := range d.pairs {
+		cycle := "idle"
+		if p, ok := live[name]; ok && p.Progress.Running {
+			cycle = cycleBar(p.Progress, d.now)
+		} else if !ok {
+			cycle = ""
+		}
+		health := ""
+		if p, ok := live[name]; ok {
+			health = d.paint(colorGreen, "ok")
+			if p.Health != "" {
+				health = d.paint(colorRed, p.Health)
+			}
+		}
+		cycles := d.cycles[name]
+		if len(cycles) == 0 {
+			fmt.Fprintf(tw, "%s\t%s\tnever\t\t\t\t\t\t\t\t%s\n", name, cycle, health)
+			continue
+		}
+		s := cycles[0]
+		result := d.paint(colorGreen, "ok")
+		switch {
+		case s.Error != "":
+			result = d.paint(colorRed, "failed: "+s.Error)
+		case s.Failed > 0:
+			result = d.paint(colorYellow, fmt.Sprintf("%d items failed", s.Failed))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%d\t%s\t%s\n", name, cycle, formatTime(s.Started), result,
+			s.Items, s.Created, s.Updated, s.Failed, s.Conflicts, formatTime(s.LastSuccess), health)
+	}
+	tw.Flush()
+	if d.status == nil {
+		return
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(tw, "PROVIDER\tCIRCUIT\tCONCURRENCY\tIN FLIGHT\tREMAINING\tPAUSED")
+	for _, p := range d.status.Providers {
+		circuit := d.paint(colorGreen, p.Circuit)
+		if p.Circuit != "closed" {
+			circuit = d.paint(colorRed, p.Circuit)
+		}
+		remaining := "-"
+		if p.Budget.Remaining >= 0 {
+			remaining = fmt.Sprint(p.Budget.Remaining)
+		}
+		paused := ""
+		if p.Budget.PausedUntil.After(d.now) {
+			paused = d.paint(colorYellow, "for "+p.Budget.PausedUntil.Sub(d.now).Round(time.Second).String())
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d/%d\t%d\t%s\t%s\n", p.Name, circuit, p.Budget.Concurrency, p.Budget.Max, p.Budget.InFlight, remaining, paused)
+	}
+	tw.Flush()
+
+	fmt.Fprintln(w)
+	if len(d.status.Errors) == 0 {
+		fmt.Fprintln(w, "no recent errors")
+		return
+	}
+	fmt.Fprintln(tw, "TIME\tPAIR\tWORK ITEM\tERROR")
+	// The most recent errors are shown first.
+	for i := len(d.status.Errors) - 1; i >= 0; i-- {
+		e := d.status.Errors[i]
+		item := ""
+		if e.WorkItem != 0 {
+			item = fmt.Sprint(e.WorkItem)
+		}
+		msg := e.Message
+		if e.Error != "" {
+			msg += ": " + e.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", formatTime(e.Time), e.Pair, item, d.paint(colorRed, msg))
+	}
+	tw.Flush()
+}
+
+// cycleBar renders the progress of a running cycle, for example "[#####-----] 50% 60/120, 2 failed, 1m5s".
+func cycleBar(p sync.Progress, now time.Time) string {
+	const width = 10
+	pct := 0
+	if p.Total > 0 {
+		pct = p.Done * 100 / p.Total
+	}
+	bar := strings.Repeat("#", pct*width/100) + strings.Repeat("-", width-pct*width/100)
+	s := fmt.Sprintf("[%s] %d%% %d/%d", bar, pct, p.Done, p.Total)
+	if p.Failed > 0 {
+		s += fmt.Sprintf(", %d failed", p.Failed)
+	}
+	return s + ", " + now.Sub(p.Started).Round(time.Second).String()
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/sync"
+)
+
+// reviewHeader is the header of the review file of the dedupe command.
+var reviewHeader = []string{"pair", "work_item", "title", "task", "task_name", "score", "reason", "adopt"}
+
+// runDedupe proposes unmapped Asana tasks as the tasks of unmapped work items, such as tasks mirrored by hand
+// before the first sync, and adopts the confirmed ones instead of creating new tasks. Proposals are confirmed
+// one at a time at the terminal, or written to a review file with -review and adopted from it with -apply.
+func runDedupe(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("dedupe", flag.ExitOnError)
+	pair := fs.String("pair", "", "only dedupe this sync pair")
+	min := fs.Float64("min", sync.DefaultDuplicateScore, "propose tasks whose name is at least this alike the title, from 0 to 1")
+	review := fs.String("review", "", "write the proposals to this CSV file (- for stdout) to be reviewed instead of asking")
+	apply := fs.String("apply", "", "adopt the proposals of this reviewed CSV file whose adopt column is yes")
+	_ = fs.Parse(args)
+	if *review != "" && *apply != "" {
+		return errors.New("usage: dedupe [-pair name] [-min score] [-review file | -apply file]")
+	}
+
+	a, err := openApp(ctx, false)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+	if err := a.manager.Validate(ctx); err != nil {
+		return err
+	}
+	engines := map[string]*sync.Engine{}
+	for _, e := range a.manager.Engines() {
+		if *pair == "" || e.Name() == *pair {
+			engines[e.Name()] = e
+		}
+	}
+	if *pair != "" && len(engines) == 0 {
+		return fmt.Errorf("no sync pair named %q", *pair)
+	}
+
+	adopt := map[string][]sync.Duplicate{}
+	if *apply != "" {
+		if adopt, err = readReview(*apply); err != nil {
+			return err
+		}
+	} else {
+		var w *csv.Writer
+		if *review != "" {
+			out := os.Stdout
+			if *review != "-" {
+				f, err := os.Create(*review)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				out = f
+			}
+			w = csv.NewWriter(out)
+			_ = w.Write(reviewHeader)
+		}
+		in := bufio.NewReader(os.Stdin)
+	pairs:
+		for _, e := range a.manager.Engines() {
+			if engines[e.Name()] == nil {
+				continue
+			}
+			dups, err := e.FindDuplicates(ctx, *min)
+			if err != nil {
+				return fmt.Errorf("sync pair %q: %w", e.Name(), err)
+			}
+			for _, d := range dups {
+				if w != nil {
+					_ = w.Write(reviewRow(e.Name(), d))
+					continue
+				}
+				ok, err := confirm(in, e.Name(), d)
+				if errors.Is(err, io.EOF) {
+					break pairs
+				}
+				if err != nil {
+					return err
+				}
+				if ok {
+					adopt[e.Name()] = append(adopt[e.Name()], d)
+				}
+			}
+		}
+		if w != nil {
+			w.Flush()
+			if err := w.Error(); err != nil {
+				return err
+			}
+			slog.Info("wrote the proposals for review, set adopt to yes on the rows to adopt and run dedupe -apply", "file", *review)
+			return nil
+		}
+	}
+
+	for name := range adopt {
+		if engines[name] == nil && *pair == "" {
+			return fmt.Errorf("no sync pair named %q", name)
+		}
+	}
+	failed := 0
+	for _, e := range a.manager.Engines() {
+		name, dups := e.Name(), adopt[e.Name()]
+		if engines[name] == nil || len(dups) == 0 {
+			continue
+		}
+		rep, err := e.Adopt(ctx, dups)
+		if err != nil {
+			return fmt.Errorf("sync pair %q: %w", name, err)
+		}
+		for _, f := range rep.Failures {
+			slog.With(sync.ErrorAttrs(f.Err)...).Error("failed to adopt task", logging.KeyPair, name, logging.KeyWorkItem, f.ADOID)
+		}
+		failed += len(rep.Failures)
+		slog.Info("adopted tasks", logging.KeyPair, name, "adopted", len(dups)-len(rep.Failures), "failed", len(rep.Failures))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d tasks failed to be adopted", failed)
+	}
+	return nil
+}
+
+// confirm asks whether to adopt the task proposed by d, reading the answer from in. It returns io.EOF when
+// the user quits.
+func confirm(in *bufio.Reader, pair string, d sync.Duplicate) (bool, error) {
+	fmt.Printf("\n[%s] work item %d %q\n  task %s %q (%s match, score %.2f)\nAdopt this task? [y/N/q] ", pair, d.ADOID, d.Title, d.AsanaGID, d.TaskName, d.Reason, d.Score)
+	line, err := in.ReadString('\n')
+	if err != nil && line == "" {
+		return false, io.EOF
+	}
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true, nil
+	case "q", "quit":
+		return false, io.EOF
+	}
+	return false, nil
+}
+
+// reviewRow returns the row of d in the review file. Tasks matched by ID are adopted unless the reviewer
+// says otherwise.
+func reviewRow(pair string, d sync.Duplicate) []string {
+	adopt := ""
+	if d.Reason == sync.DuplicateByID {
+		adopt = "yes"
+	}
+	return []string{pair, strconv.Itoa(d.ADOID), d.Title, d.AsanaGID, d.TaskName, strconv.FormatFloat(d.Score, 'f', 2, 64), d.Reason, adopt}
+}
+
+// readReview returns the rows of the review file at path whose adopt column is yes, by pair.
+func readReview(path string) (map[string][]sync.Duplicate, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("reading review file: %w", err)
+	}
+	adopt := map[string][]sync.Duplicate{}
+	for i, row := range rows {
+		if i == 0 && len(row) > 0 && row[0] == reviewHeader[0] {
+			continue
+		}
+		if len(row) != len(reviewHeader) {
+			return nil, fmt.Errorf("review file line %d: want the columns %s", i+1, strings.Join(reviewHeader, ","))
+		}
+		if v := strings.ToLower(strings.TrimSpace(row[7])); v != "yes" && v != "y" {
+			continue
+		}
+		id, err := strconv.Atoi(row[1])
+		if err != nil {
+			return nil, fmt.Errorf("review file line %d: invalid work item %q", i+1, row[1])
+		}
+		score, _ := strconv.ParseFloat(row[5], 64)
+		adopt[row[0]] = append(adopt[row[0]], sync.Duplicate{ADOID: id, Title: row[2], AsanaGID: row[3], TaskName: row[4], Score: score, Reason: row[6]})
+	}
+	return adopt, nil
+}
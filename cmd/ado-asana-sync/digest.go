@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/digest"
+)
+
+// newDigester returns the digester configured by the DIGEST_ variables for the pairs of a, or nil when
+// DIGEST_TO is unset.
+func newDigester(ctx context.Context, a *app) (*digest.Digester, error) {
+	var to []string
+	for _, addr := range strings.Split(os.Getenv("DIGEST_TO"), ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			to = append(to, addr)
+		}
+	}
+	if len(to) == 0 {
+		return nil, nil
+	}
+	d := &digest.Digester{To: to, From: os.Getenv("DIGEST_FROM")}
+	if d.From == "" {
+		return nil, errors.New("DIGEST_FROM is required when DIGEST_TO is set")
+	}
+
+	smtpAddr, sendgridKey := os.Getenv("DIGEST_SMTP_ADDR"), os.Getenv("DIGEST_SENDGRID_API_KEY")
+	switch {
+	case smtpAddr != "" && sendgridKey != "":
+		return nil, errors.New("set one of DIGEST_SMTP_ADDR and DIGEST_SENDGRID_API_KEY, not both")
+	case smtpAddr != "":
+		s := &digest.SMTP{Addr: smtpAddr, Username: os.Getenv("DIGEST_SMTP_USERNAME"), Password: os.Getenv("DIGEST_SMTP_PASSWORD")}
+		var err error
+		if s.Passwords, err = secretSource(ctx, "DIGEST_SMTP_PASSWORD"); err != nil {
+			return nil, err
+		}
+		d.Sender = s
+	case sendgridKey != "":
+		s := &digest.SendGrid{APIKey: sendgridKey}
+		var err error
+		if s.APIKeys, err = secretSource(ctx, "DIGEST_SENDGRID_API_KEY"); err != nil {
+			return nil, err
+		}
+		d.Sender = s
+	default:
+		return nil, errors.New("DIGEST_SMTP_ADDR or DIGEST_SENDGRID_API_KEY is required when DIGEST_TO is set")
+	}
+
+	var err error
+	if v := os.Getenv("DIGEST_SUBJECT"); v != "" {
+		if d.Subject, err = digest.ParseSubject(v); err != nil {
+			return nil, fmt.Errorf("DIGEST_SUBJECT: %w", err)
+		}
+	}
+	if path := os.Getenv("DIGEST_TEMPLATE"); path != "" {
+		text, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("DIGEST_TEMPLATE: %w", err)
+		}
+		if d.Body, err = digest.ParseBody(string(text)); err != nil {
+			return nil, fmt.Errorf("DIGEST_TEMPLATE: %w", err)
+		}
+	}
+
+	stores := a.connStores()
+	for _, e := range a.manager.Engines() {
+		cfg := e.Config()
+		d.Pairs = append(d.Pairs, digest.Pair{Name: cfg.Name, ADOProject: cfg.ADOProject, AsanaProject: cfg.AsanaProject, Store: stores[cfg.ADOConnection]})
+	}
+	return d, nil
+}
+
+// runDigest sends the digest of the sync activity of the last -since by email, or with -dry-run prints it.
+func runDigest(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("digest", flag.ExitOnError)
+	since := fs.Duration("since", 7*24*time.Hour, "summarize the activity of this long before now")
+	dryRun := fs.Bool("dry-run", false, "print the digest rather than sending it")
+	_ = fs.Parse(args)
+	if *since <= 0 {
+		return errors.New("usage: digest [-since duration] [-dry-run]")
+	}
+
+	a, err := openApp(ctx, false)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+	d, err := newDigester(ctx, a)
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		return errors.New("DIGEST_TO is required to send digests")
+	}
+	until := time.Now()
+	if *dryRun {
+		msg, err := d.Render(ctx, until.Add(-*since), until)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Subject: %s\nTo: %s\n\n%s\n", msg.Subject, strings.Join(msg.To, ", "), msg.HTML)
+		return nil
+	}
+	if err := d.Send(ctx, until.Add(-*since), until); err != nil {
+		return err
+	}
+	fmt.Printf("Sent the sync digest to %s.\n", strings.Join(d.To, ", "))
+	return nil
+}
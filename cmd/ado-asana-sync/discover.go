@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/sync"
+)
+
+// listing is what a discover subcommand found, as the rows of a table or a value written as JSON.
+type listing struct {
+	columns []string
+	rows    [][]string
+	value   interface{}
+}
+
+// discoveredIteration is an iteration as written by discover iterations -json.
+type discoveredIteration struct {
+	Path       string     `json:"path"`
+	StartDate  *time.Time `json:"start_date,omitempty"`
+	FinishDate *time.Time `json:"finish_date,omitempty"`
+}
+
+// discoveredField is a field as written by discover ado-fields -json, with the values of its picklist.
+type discoveredField struct {
+	ado.Field
+	Values []string `json:"values,omitempty"`
+}
+
+// runDiscover lists the ADO area paths, iterations, work item types and fields of a project, or the Asana
+// workspaces, projects, sections and custom fields the token can see, with the exact paths, names and GIDs
+// routes and mappings are written with.
+func runDiscover(ctx context.Context, args []string) error {
+	const usage = "usage: discover areas|iterations|types|ado-fields [-project name] [-connection name] [-json] | discover workspaces|projects|sections|fields [-workspace gid] [-project gid] [-asana-connection name] [-json]"
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	kind := args[0]
+	fs := flag.NewFlagSet("discover "+kind, flag.ExitOnError)
+	project := fs.String("project", "", "the ADO project, or the GID of the Asana project, to list; ADO_PROJECT, or ASANA_PROJECT for sections, when empty")
+	workspace := fs.String("workspace", os.Getenv("ASANA_WORKSPACE"), "the Asana workspace GID to list the projects or custom fields of")
+	conn := fs.String("connection", sync.DefaultConnection, "the ADO connection to list")
+	asanaConn := fs.String("asana-connection", sync.DefaultConnection, "the Asana connection to list")
+	asJSON := fs.Bool("json", false, "print the results as JSON")
+	_ = fs.Parse(args[1:])
+
+	var l *listing
+	var err error
+	switch kind {
+	case "areas", "iterations", "types", "ado-fields":
+		if *project == "" {
+			*project = os.Getenv("ADO_PROJECT")
+		}
+		if *project == "" {
+			return fmt.Errorf("discover %s: set -project or ADO_PROJECT", kind)
+		}
+		var client *ado.Client
+		if client, err = discoveryADO(ctx, *conn); err != nil {
+			return err
+		}
+		l, err = discoverADO(ctx, client, kind, *project)
+	case "workspaces", "projects", "sections", "fields":
+		if *project == "" && kind == "sections" {
+			*project = os.Getenv("ASANA_PROJECT")
+		}
+		a := &app{shutdownTracing: func(context.Context) error { return nil }}
+		if a.store, err = openStore(ctx); err != nil {
+			return err
+		}
+		defer a.close()
+		var client *asana.Client
+		if client, err = discoveryAsana(ctx, a, *asanaConn); err != nil {
+			return err
+		}
+		l, err = discoverAsana(ctx, client, kind, *workspace, *project)
+	default:
+		return errors.New(usage)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return encodeJSON(os.Stdout, l.value)
+	}
+	if len(l.rows) == 0 {
+		fmt.Printf("No %s found.\n", kind)
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(l.columns, "\t"))
+	for _, r := range l.rows {
+		fmt.Fprintln(tw, strings.Join(r, "\t"))
+	}
+	return tw.Flush()
+}
+
+// discoverADO lists the areas, iterations, work item types or fields of the ADO project.
+func discoverADO(ctx context.Context, client *ado.Client, kind, project string) (*listing, error) {
+	switch kind {
+	case "areas":
+		paths, err := client.AreaPaths(ctx, project)
+		if err != nil {
+			return nil, fmt.Errorf("listing area paths: %w", err)
+		}
+		l := &listing{columns: []string{"PATH"}, value: paths}
+		for _, p := range paths {
+			l.rows = append(l.rows, []string{p})
+		}
+		return l, nil
+	case "iterations":
+		its, err := client.Iterations(ctx, project)
+		if err != nil {
+			return nil, fmt.Errorf("listing iterations: %w", err)
+		}
+		l := &listing{columns: []string{"PATH", "START", "FINISH"}}
+		found := make([]discoveredIteration, 0, len(its))
+		for _, it := range its {
+			found = append(found, discoveredIteration{Path: it.Path, StartDate: it.StartDate, FinishDate: it.FinishDate})
+			l.rows = append(l.rows, []string{it.Path, formatDate(it.StartDate), formatDate(it.FinishDate)})
+		}
+		l.value = found
+		return l, nil
+	case "ado-fields":
+		fields, err := client.Fields(ctx, project)
+		if err != nil {
+			return nil, fmt.Errorf("listing fields: %w", err)
+		}
+		l := &listing{columns: []string{"REFERENCE NAME", "NAME", "TYPE", "VALUES"}}
+		found := make([]discoveredField, 0, len(fields))
+		for _, f := range fields {
+			d := discoveredField{Field: f}
+			if f.IsPicklist && f.PicklistID != "" {
+				if d.Values, err = client.Picklist(ctx, f.PicklistID); err != nil {
+					return nil, fmt.Errorf("listing the values of %s: %w", f.ReferenceName, err)
+				}
+			}
+			found = append(found, d)
+			l.rows = append(l.rows, []string{f.ReferenceName, f.Name, f.Type, strings.Join(d.Values, ", ")})
+		}
+		l.value = found
+		return l, nil
+	default:
+		types, err := client.WorkItemTypes(ctx, project)
+		if err != nil {
+			return nil, fmt.Errorf("listing work item types: %w", err)
+		}
+		l := &listing{columns: []string{"TYPE", "STATES", "DISABLED"}, value: types}
+		for _, t := range types {
+			states := make([]string, 0, len(t.States))
+			for _, s := range t.States {
+				states = append(states, fmt.Sprintf("%s (%s)", s.Name, s.Category))
+			}
+			l.rows = append(l.rows, []string{t.Name, strings.Join(states, ", "), fmt.Sprint(t.Disabled)})
+		}
+		return l, nil
+	}
+}
+
+// discoverAsana lists the workspaces, the projects of the workspace, or the sections or custom fields of the
+// project. Custom fields are those of the workspace when no project is given.
+func discoverAsana(ctx context.Context, client *asana.Client, kind, workspace, project string) (*listing, error) {
+	named := func(gids, names []string) *listing {
+		l := &listing{columns: []string{"GID", "NAME"}}
+		for i := range gids {
+			l.rows = append(l.rows, []string{gids[i], names[i]})
+		}
+		return l
+	}
+	switch kind {
+	case "workspaces":
+		ws, err := client.Workspaces(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("listing asana workspaces: %w", err)
+		}
+		var gids, names []string
+		for _, w := range ws {
+			gids, names = append(gids, w.GID), append(names, w.Name)
+		}
+		l := named(gids, names)
+		l.value = ws
+		return l, nil
+	case "projects":
+		if workspace == "" {
+			return nil, errors.New("discover projects: set -workspace or ASANA_WORKSPACE")
+		}
+		ps, err := client.WorkspaceProjects(ctx, workspace)
+		if err != nil {
+			return nil, fmt.Errorf("listing asana projects: %w", err)
+		}
+		var gids, names []string
+		for _, p := range ps {
+			gids, names = append(gids, p.GID), append(names, p.Name)
+		}
+		l := named(gids, names)
+		l.value = ps
+		return l, nil
+	case "sections":
+		if project == "" {
+			return nil, errors.New("discover sections: set -project or ASANA_PROJECT")
+		}
+		ss, err := client.ProjectSections(ctx, project)
+		if err != nil {
+			return nil, fmt.Errorf("listing asana sections: %w", err)
+		}
+		var gids, names []string
+		for _, s := range ss {
+			gids, names = append(gids, s.GID), append(names, s.Name)
+		}
+		l := named(gids, names)
+		l.value = ss
+		return l, nil
+	default:
+		var fields []asana.CustomField
+		var err error
+		switch {
+		case project != "":
+			fields, err = client.ProjectCustomFields(ctx, project)
+		case workspace != "":
+			fields, err = client.WorkspaceCustomFields(ctx, workspace)
+		default:
+			return nil, errors.New("discover fields: set -project, -workspace, ASANA_PROJECT or ASANA_WORKSPACE")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("listing asana custom fields: %w", err)
+		}
+		l := &listing{columns: []string{"GID", "NAME", "TYPE", "OPTIONS"}, value: fields}
+		for _, f := range fields {
+			options := make([]string, 0, len(f.EnumOptions))
+			for _, o := range f.EnumOptions {
+				options = append(options, fmt.Sprintf("%s (%s)", o.Name, o.GID))
+			}
+			l.rows = append(l.rows, []string{f.GID, f.Name, f.ResourceSubtype, strings.Join(options, ", ")})
+		}
+		return l, nil
+	}
+}
+
+// discoveryADO connects to the named ADO connection without the mapping store it may have.
+func discoveryADO(ctx context.Context, name string) (*ado.Client, error) {
+	conns, err := loadConnections([]sync.Config{{ADOConnection: name}})
+	if err != nil {
+		return nil, err
+	}
+	limits, err := rateLimitOptions()
+	if err != nil {
+		return nil, err
+	}
+	circuits, err := circuitOptions()
+	if err != nil {
+		return nil, err
+	}
+	c := conns[0]
+	c.StoreURL = ""
+	conn, err := connect(ctx, c, limits, circuits)
+	if err != nil {
+		return nil, err
+	}
+	return conn.ado, nil
+}
+
+// discoveryAsana connects to the named Asana connection, reading the OAuth token from the store of a.
+func discoveryAsana(ctx context.Context, a *app, name string) (*asana.Client, error) {
+	conns, err := loadAsanaConnections([]sync.Config{{AsanaConnection: name}})
+	if err != nil {
+		return nil, err
+	}
+	limits, err := rateLimitOptions()
+	if err != nil {
+		return nil, err
+	}
+	circuits, err := circuitOptions()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := a.connectAsana(ctx, conns[0], limits, circuits)
+	if err != nil {
+		return nil, err
+	}
+	return conn.asana, nil
+}
+
+// formatDate formats an iteration date, which is empty when unset.
+func formatDate(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.UTC().Format("2006-01-02")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/analytics"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// runExport runs an export subcommand: analytics writes the work item samples recorded by the sync cycles of
+// pairs with SYNC_ANALYTICS set, read from the store alone.
+func runExport(ctx context.Context, args []string) error {
+	const usage = "usage: export analytics [-o file|s3://bucket/key|https://account.blob.core.windows.net/container/blob] [-format csv|parquet] [-pair name] [-since duration] [-daily]"
+	if len(args) == 0 || args[0] != "analytics" {
+		return errors.New(usage)
+	}
+	return runExportAnalytics(ctx, args[1:])
+}
+
+// runExportAnalytics writes the samples matching the flags, or with -daily a row per item and day, as CSV or
+// parquet.
+func runExportAnalytics(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("export analytics", flag.ExitOnError)
+	out := fs.String("o", "-", "write the export to this file, S3 object or Azure blob, - for stdout")
+	format := fs.String("format", "", "csv or parquet, defaulting to parquet for .parquet files and csv otherwise")
+	var f store.SampleFilter
+	fs.StringVar(&f.Pair, "pair", "", "only export the samples of this sync pair")
+	since := fs.Duration("since", 0, "only export the rows of this duration, for example 720h")
+	daily := fs.Bool("daily", false, "export the state of every item at the end of each day instead of each sample")
+	conn := fs.String("connection", "", "read the store of this ADO connection")
+	_ = fs.Parse(args)
+	if *format == "" {
+		*format = analytics.FormatFor(*out)
+	}
+	if *format != analytics.FormatCSV && *format != analytics.FormatParquet {
+		return errors.New("export analytics: -format must be csv or parquet")
+	}
+
+	location, err := connectionStore(*conn)
+	if err != nil {
+		return err
+	}
+	st, err := openStoreAt(ctx, location)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	now := time.Now().UTC()
+	var from time.Time
+	if *since > 0 {
+		from = now.Add(-*since)
+	}
+	// Daily rows carry the items forward from their last sample, which may be older than the rows exported.
+	if !*daily {
+		f.Since = from
+	}
+	samples, err := st.Samples(ctx, f)
+	if err != nil {
+		return err
+	}
+	rows := analytics.Snapshots(samples, *daily, now)
+	if *daily && !from.IsZero() {
+		day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+		kept := rows[:0]
+		for _, r := range rows {
+			if !r.Time.Before(day) {
+				kept = append(kept, r)
+			}
+		}
+		rows = kept
+	}
+
+	var b bytes.Buffer
+	if err := analytics.Write(&b, rows, *format); err != nil {
+		return err
+	}
+	if *out == "-" {
+		_, err := os.Stdout.Write(b.Bytes())
+		return err
+	}
+	if err := analytics.Upload(ctx, *out, analytics.ContentType(*format), b.Bytes()); err != nil {
+		return err
+	}
+	slog.Info("exported analytics", "store", location, "to", *out, "format", *format, "rows", len(rows))
+	return nil
+}
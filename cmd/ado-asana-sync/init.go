@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/breaker"
+	"github.com/danstis/ado-asana-sync/internal/config"
+	"github.com/danstis/ado-asana-sync/internal/ratelimit"
+	"github.com/danstis/ado-asana-sync/internal/sync"
+)
+
+// errInputEnded is returned by init when the operator ends the input before the configuration is complete.
+var errInputEnded = errors.New("init: input ended before the configuration was complete")
+
+// runInit walks the operator through connecting to ADO and Asana, picking the organization, the projects
+// and the workspace from lists, writes a configuration file serve runs with, and checks with it that the
+// credentials may read and write what the pair syncs, as validate does.
+func runInit(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	path := fs.String("config", getenv("CONFIG_FILE", "ado-asana-sync.yaml"), "the configuration file to write, as YAML when its name ends in .yaml or .yml and JSON otherwise")
+	force := fs.Bool("force", false, "overwrite the configuration file when it exists")
+	_ = fs.Parse(args)
+	if _, err := os.Stat(*path); err == nil && !*force {
+		return fmt.Errorf("%s exists, run init -force to overwrite it", *path)
+	}
+
+	s := &setup{in: bufio.NewReader(os.Stdin), profileURL: ado.ProfileURL, asanaURL: asana.DefaultBaseURL}
+	f, err := s.run(ctx)
+	if err != nil {
+		return err
+	}
+	if err := f.Validate(); err != nil {
+		return err
+	}
+	b, err := encodeConfig(*path, f)
+	if err != nil {
+		return err
+	}
+	// The file holds the credentials, so only its owner may read it.
+	if err := os.WriteFile(*path, b, 0o600); err != nil {
+		return err
+	}
+	fmt.Printf("\nWrote %s.\n", *path)
+
+	write, err := s.yes("Create and delete a task in the Asana project to check the token may write to it?", true)
+	if err != nil {
+		return err
+	}
+	// The check reads the new file, with the settings chosen here over those of the environment.
+	for name, v := range f.Env {
+		os.Setenv(name, v)
+	}
+	os.Setenv("CONFIG_FILE", *path)
+	if err := checkSetup(ctx, write); err != nil {
+		return fmt.Errorf("%w; fix the configuration in %s and run validate", err, *path)
+	}
+	fmt.Printf("\nThe configuration is ready. Preview the first cycle with\n\n  CONFIG_FILE=%s %s sync -dry-run\n\nand start syncing with\n\n  CONFIG_FILE=%s %s serve\n", *path, filepath.Base(os.Args[0]), *path, filepath.Base(os.Args[0]))
+	return nil
+}
+
+// setup asks the questions of init, reading the answers from in.
+type setup struct {
+	in *bufio.Reader
+	// profileURL is the URL of the ADO profile service listing the organizations, and asanaURL the base URL
+	// of the Asana API.
+	profileURL, asanaURL string
+}
+
+// run asks for the credentials and the projects of the pair, and returns the configuration file syncing them.
+func (s *setup) run(ctx context.Context) (*config.File, error) {
+	limits, err := rateLimitOptions()
+	if err != nil {
+		return nil, err
+	}
+	circuits, err := circuitOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Azure DevOps")
+	fmt.Println("The personal access token needs the Work Items (Read & write) scope. It is shown as it is typed;")
+	fmt.Println("enter a secret reference such as keyvault://<vault>/<secret> to keep it out of the file.")
+	pat, err := s.ask("Personal access token", os.Getenv("ADO_PAT"), true)
+	if err != nil {
+		return nil, err
+	}
+	orgURL, err := s.organization(ctx, pat, limits, circuits)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := connect(ctx, config.ADOConnection{Name: sync.DefaultConnection, OrgURL: orgURL, PAT: pat}, limits, circuits)
+	if err != nil {
+		return nil, err
+	}
+	projects, err := conn.ado.Projects(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing the projects of %s: %w", orgURL, err)
+	}
+	if len(projects) == 0 {
+		return nil, fmt.Errorf("the PAT cannot see any project of %s", orgURL)
+	}
+	names := make([]string, 0, len(projects))
+	for _, p := range projects {
+		names = append(names, p.Name)
+	}
+	i, err := s.pick("ADO project to sync", names)
+	if err != nil {
+		return nil, err
+	}
+	adoProject := projects[i].Name
+
+	fmt.Println("\nAsana")
+	fmt.Println("Create a personal access token under My settings > Apps > Developer apps in Asana.")
+	token, err := s.ask("Personal access token", os.Getenv("ASANA_TOKEN"), true)
+	if err != nil {
+		return nil, err
+	}
+	a := &app{}
+	ac, err := a.connectAsana(ctx, config.AsanaConnection{Name: sync.DefaultConnection, Token: token}, limits, circuits)
+	if err != nil {
+		return nil, err
+	}
+	ac.asana.BaseURL = s.asanaURL
+	me, err := ac.asana.Me(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("signing in to asana: %w", err)
+	}
+	fmt.Printf("Signed in as %s <%s>.\n", me.Name, me.Email)
+	workspaces, err := ac.asana.Workspaces(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing asana workspaces: %w", err)
+	}
+	if len(workspaces) == 0 {
+		return nil, errors.New("the asana token cannot see any workspace")
+	}
+	names = names[:0]
+	for _, w := range workspaces {
+		names = append(names, w.Name)
+	}
+	if i, err = s.pick("Asana workspace", names); err != nil {
+		return nil, err
+	}
+	workspace := workspaces[i]
+	asanaProjects, err := ac.asana.WorkspaceProjects(ctx, workspace.GID)
+	if err != nil {
+		return nil, fmt.Errorf("listing asana projects: %w", err)
+	}
+	if len(asanaProjects) == 0 {
+		return nil, fmt.Errorf("workspace %s has no projects, create the project to sync into first", workspace.Name)
+	}
+	names = names[:0]
+	for _, p := range asanaProjects {
+		names = append(names, p.Name)
+	}
+	if i, err = s.pick("Asana project to sync into", names); err != nil {
+		return nil, err
+	}
+	asanaProject := asanaProjects[i]
+
+	fmt.Println("\nSync")
+	directions := []string{
+		"ado-to-asana: work items are copied to Asana as tasks",
+		"bidirectional: changes made on either side are synced to the other",
+	}
+	if i, err = s.pick("Direction", directions); err != nil {
+		return nil, err
+	}
+	name, err := s.ask("Name of the pair", pairName(adoProject), false)
+	if err != nil {
+		return nil, err
+	}
+	pair := config.Pair{Name: name, ADOProject: adoProject, AsanaWorkspace: workspace.GID, AsanaProject: asanaProject.GID}
+	if i == 1 {
+		pair.Direction = string(sync.Bidirectional)
+	}
+	return &config.File{
+		Env:   map[string]string{"ADO_ORG_URL": orgURL, "ADO_PAT": pat, "ASANA_TOKEN": token},
+		Pairs: []config.Pair{pair},
+	}, nil
+}
+
+// organization returns the URL of the ADO organization to sync with, picked from those of the user of pat or
+// typed in when they cannot be listed.
+func (s *setup) organization(ctx context.Context, pat string, limits ratelimit.Options, circuits breaker.Options) (string, error) {
+	profile, err := connect(ctx, config.ADOConnection{Name: sync.DefaultConnection, OrgURL: s.profileURL, PAT: pat}, limits, circuits)
+	if err != nil {
+		return "", err
+	}
+	orgs, err := profile.ado.Organizations(ctx)
+	if err == nil && len(orgs) > 0 {
+		names := make([]string, 0, len(orgs))
+		for _, o := range orgs {
+			names = append(names, o.Name)
+		}
+		i, err := s.pick("Organization", names)
+		if err != nil {
+			return "", err
+		}
+		return orgs[i].URL(), nil
+	}
+	if err != nil {
+		fmt.Printf("Your organizations could not be listed (%v), a PAT valid for all accessible organizations can list them.\n", err)
+	}
+	for {
+		u, err := s.ask("Organization URL, such as https://dev.azure.com/contoso", os.Getenv("ADO_ORG_URL"), false)
+		if err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(u, "https://") || strings.HasPrefix(u, "http://") {
+			return strings.TrimRight(u, "/"), nil
+		}
+		fmt.Println("The URL must start with https://.")
+	}
+}
+
+// ask prints prompt and returns the line answered, or def when the answer is empty. A credential default is
+// not shown. An answer is required when there is no default.
+func (s *setup) ask(prompt, def string, credential bool) (string, error) {
+	for {
+		switch {
+		case def != "" && credential:
+			fmt.Printf("%s [press enter to keep the current one]: ", prompt)
+		case def != "":
+			fmt.Printf("%s [%s]: ", prompt, def)
+		default:
+			fmt.Printf("%s: ", prompt)
+		}
+		line, err := s.in.ReadString('\n')
+		if err != nil && line == "" {
+			return "", errInputEnded
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			return line, nil
+		}
+		if def != "" {
+			return def, nil
+		}
+	}
+}
+
+// pick lists options and returns the index of the one chosen by number. A single option is chosen without
+// asking.
+func (s *setup) pick(prompt string, options []string) (int, error) {
+	if len(options) == 1 {
+		fmt.Printf("%s: %s\n", prompt, options[0])
+		return 0, nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for i, o := range options {
+		fmt.Fprintf(tw, "  %d)\t%s\n", i+1, o)
+	}
+	_ = tw.Flush()
+	for {
+		answer, err := s.ask(prompt, "1", false)
+		if err != nil {
+			return 0, err
+		}
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(options) {
+			return n - 1, nil
+		}
+		fmt.Printf("Enter a number from 1 to %d.\n", len(options))
+	}
+}
+
+// yes asks a yes or no question, returning def for an empty answer.
+func (s *setup) yes(prompt string, def bool) (bool, error) {
+	hint := "[y/N]"
+	if def {
+		hint = "[Y/n]"
+	}
+	for {
+		fmt.Printf("%s %s ", prompt, hint)
+		line, err := s.in.ReadString('\n')
+		if err != nil && line == "" {
+			return false, errInputEnded
+		}
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "":
+			return def, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+	}
+}
+
+// pairNameChars matches the runs of characters left out of the names init suggests for pairs.
+var pairNameChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// pairName suggests the name of the pair syncing the ADO project.
+func pairName(project string) string {
+	if name := strings.Trim(pairNameChars.ReplaceAllString(strings.ToLower(project), "-"), "-"); name != "" {
+		return name
+	}
+	return "default"
+}
+
+// encodeConfig returns f as the contents of the configuration file at path, YAML when its name ends in
+// .yaml or .yml and JSON otherwise, as config.Load reads it. Settings left unset are left out.
+func encodeConfig(path string, f *config.File) ([]byte, error) {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return nil, err
+	}
+	var v map[string]interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	for k, x := range v {
+		if x == nil {
+			delete(v, k)
+		}
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return yaml.Marshal(v)
+	default:
+		b, err := json.MarshalIndent(v, "", "  ")
+		return append(b, '\n'), err
+	}
+}
+
+// checkSetup runs the checks of validate with the configuration just written and prints them.
+func checkSetup(ctx context.Context, write bool) error {
+	a, err := openApp(ctx, false)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+	var checks []sync.Check
+	for _, c := range a.conns {
+		checks = append(checks, connectionCheck("ado credentials", connectionName(c.name), c.ado.Ping(ctx),
+			"check the organization URL and that the PAT has the Work Items scope"))
+	}
+	for _, c := range a.asanaConns {
+		checks = append(checks, connectionCheck("asana credentials", asanaConnectionName(c.name), c.ping(ctx),
+			"check that the token has not expired"))
+	}
+	fmt.Println()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PAIR	CHECK	TARGET	RESULT	DETAIL")
+	failed := printChecks(tw, "-", checks)
+	for _, e := range a.manager.Engines() {
+		failed += printChecks(tw, e.Name(), e.Probe(ctx, write))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+	return nil
+}
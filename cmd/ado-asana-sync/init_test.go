@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/danstis/ado-asana-sync/internal/config"
+	"github.com/danstis/ado-asana-sync/internal/sync"
+	"github.com/danstis/ado-asana-sync/internal/testfixtures"
+)
+
+// newSetup returns the questions of init answered by lines, asked against the fakes of h. The ADO fake has
+// no profile service, so the organizations cannot be listed and the URL is asked for.
+func newSetup(t *testing.T, h *testfixtures.Harness, lines ...string) *setup {
+	t.Helper()
+	for _, name := range []string{"ADO_PAT", "ADO_ORG_URL", "ASANA_TOKEN"} {
+		t.Setenv(name, "")
+	}
+	return &setup{
+		in:         bufio.NewReader(strings.NewReader(strings.Join(lines, "\n") + "\n")),
+		profileURL: h.ADO.URL(),
+		asanaURL:   h.Asana.URL(),
+	}
+}
+
+func TestInit(t *testing.T) {
+	h := testfixtures.NewHarness(sync.DefaultConfig())
+	defer h.Close()
+	archive := h.Asana.AddProject("Archive")
+	// The fake lists the projects by GID.
+	choice := "2"
+	if archive < h.Project {
+		choice = "1"
+	}
+
+	s := newSetup(t, h,
+		"pat",
+		"dev.azure.com/contoso", // not a URL, asked again
+		h.ADO.URL(),
+		"token",
+		"3", // out of range, asked again
+		choice,
+		"2", // bidirectional
+		"",  // the suggested pair name
+	)
+	f, err := s.run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := config.Pair{
+		Name:           "fabrikam",
+		ADOProject:     testfixtures.ProjectName,
+		AsanaWorkspace: h.Asana.Workspace,
+		AsanaProject:   archive,
+		Direction:      string(sync.Bidirectional),
+	}
+	if len(f.Pairs) != 1 || f.Pairs[0].Name != want.Name || f.Pairs[0].ADOProject != want.ADOProject ||
+		f.Pairs[0].AsanaWorkspace != want.AsanaWorkspace || f.Pairs[0].AsanaProject != want.AsanaProject ||
+		f.Pairs[0].Direction != want.Direction {
+		t.Fatalf("want the pair %+v, got %+v", want, f.Pairs)
+	}
+	if f.Env["ADO_ORG_URL"] != h.ADO.URL() || f.Env["ADO_PAT"] != "pat" || f.Env["ASANA_TOKEN"] != "token" {
+		t.Fatalf("want the connection settings answered, got %v", f.Env)
+	}
+
+	// The file written is read back as the configuration serve runs with.
+	for _, name := range []string{"ado-asana-sync.yaml", "ado-asana-sync.json"} {
+		path := filepath.Join(t.TempDir(), name)
+		b, err := encodeConfig(path, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, b, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := config.Load(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(loaded.Pairs) != 1 || loaded.Pairs[0].AsanaProject != archive || loaded.Env["ADO_ORG_URL"] != h.ADO.URL() {
+			t.Fatalf("%s: want the configuration read back, got %+v", name, loaded)
+		}
+	}
+}
+
+func TestInitInputEnded(t *testing.T) {
+	h := testfixtures.NewHarness(sync.DefaultConfig())
+	defer h.Close()
+	s := newSetup(t, h, "pat", h.ADO.URL())
+	if _, err := s.run(context.Background()); !errors.Is(err, errInputEnded) {
+		t.Fatalf("want errInputEnded, got %v", err)
+	}
+}
+
+func TestPairName(t *testing.T) {
+	for project, want := range map[string]string{
+		"Fabrikam":          "fabrikam",
+		"Contoso Web  Team": "contoso-web-team",
+		"  (Ops)  ":         "ops",
+		"日本":                "default",
+	} {
+		if got := pairName(project); got != want {
+			t.Errorf("pairName(%q): want %q, got %q", project, want, got)
+		}
+	}
+}
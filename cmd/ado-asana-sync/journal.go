@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/store"
+	"github.com/danstis/ado-asana-sync/internal/sync"
+)
+
+// runJournal runs a journal subcommand: list shows the changes journaled by the sync cycles, and replay
+// applies the changes journaled from a cycle onwards again with the current configuration.
+func runJournal(ctx context.Context, args []string) error {
+	const usage = "usage: journal list [-pair name] [-cycle id] [-item id] [-since duration] | journal replay -from <cycle> [-pair name] [-dry-run]"
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	switch args[0] {
+	case "list":
+		return runJournalList(ctx, args[1:])
+	case "replay":
+		return runJournalReplay(ctx, args[1:])
+	}
+	return errors.New(usage)
+}
+
+// runJournalList prints the journal entries matching the flags, oldest first.
+func runJournalList(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("journal list", flag.ExitOnError)
+	var f store.JournalFilter
+	fs.StringVar(&f.Pair, "pair", "", "only show the changes of this sync pair")
+	fs.StringVar(&f.CycleID, "cycle", "", "only show the changes found by this sync cycle")
+	fs.IntVar(&f.ADOID, "item", 0, "only show the changes of this work item")
+	since := fs.Duration("since", 0, "only show the changes journaled within this duration, for example 24h")
+	conn := fs.String("connection", "", "read the store of this ADO connection")
+	_ = fs.Parse(args)
+	if *since > 0 {
+		f.Since = time.Now().Add(-*since)
+	}
+
+	location, err := connectionStore(*conn)
+	if err != nil {
+		return err
+	}
+	st, err := openStoreAt(ctx, location)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	entries, err := st.Journal(ctx, f)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Println("No changes journaled.")
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tPAIR\tCYCLE\tWORK ITEM\tTASK\tCHANGED")
+	for _, j := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\n", formatTime(j.Time), j.Pair, j.CycleID, j.ADOID, j.AsanaGID, strings.Join(j.Sources, ","))
+	}
+	return tw.Flush()
+}
+
+// runJournalReplay replays the journal of every pair, or the one given by -pair, from the cycle given by
+// -from. Pairs that journaled nothing in that cycle are left alone.
+func runJournalReplay(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("journal replay", flag.ExitOnError)
+	from := fs.String("from", "", "replay the changes journaled from the start of this sync cycle onwards")
+	pair := fs.String("pair", "", "only replay the journal of this sync pair")
+	dryRun := fs.Bool("dry-run", false, "print the changes the replay would make instead of making them")
+	_ = fs.Parse(args)
+	if *from == "" {
+		return errors.New("usage: journal replay -from <cycle> [-pair name] [-dry-run]")
+	}
+
+	a, err := openApp(ctx, *dryRun)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+	if err := a.manager.Validate(ctx); err != nil {
+		return err
+	}
+
+	var plans []*sync.Plan
+	found, replayed, failed := false, false, 0
+	for _, e := range a.manager.Engines() {
+		if *pair != "" && e.Name() != *pair {
+			continue
+		}
+		found = true
+		rep, err := e.ReplayJournal(ctx, *from)
+		if errors.Is(err, sync.ErrNoJournal) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("journal replay of pair %q: %w", e.Name(), err)
+		}
+		replayed = true
+		plans = append(plans, rep.Plan)
+		slog.Info("journal replayed", logging.KeyPair, e.Name(), "synced", rep.Items-len(rep.Failures), "failed", len(rep.Failures))
+		for _, f := range rep.Failures {
+			slog.With(sync.ErrorAttrs(f.Err)...).Error("failed to replay work item", logging.KeyPair, e.Name(), logging.KeyWorkItem, f.ADOID)
+		}
+		failed += len(rep.Failures)
+	}
+	if !found {
+		return fmt.Errorf("no sync pair named %q", *pair)
+	}
+	if !replayed {
+		return fmt.Errorf("no changes journaled in cycle %s", *from)
+	}
+	if *dryRun {
+		if err := sync.Merge(plans...).WriteText(os.Stdout); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d work items failed to replay", failed)
+	}
+	return nil
+}
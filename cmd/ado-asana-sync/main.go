@@ -1,12 +1,103 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
+	"github.com/danstis/ado-asana-sync/internal/egress"
+	"github.com/danstis/ado-asana-sync/internal/logging"
 	"github.com/danstis/ado-asana-sync/internal/version"
 )
 
 // Main entry point for the app.
 func main() {
-	log.Printf("Version %q", version.Version)
+	// Without a command the app keeps its original behaviour and runs as a daemon.
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 {
+		switch a := args[0]; {
+		case a == "help", a == "-h", a == "-help", a == "--help":
+			usage()
+			return
+		case !strings.HasPrefix(a, "-"):
+			name, args = a, args[1:]
+		}
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == name {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
+	}
+
+	// init writes the configuration file, so the file CONFIG_FILE names need not exist yet.
+	if err := loadEnv(false); err != nil && !(cmd.name == "init" && errors.Is(err, fs.ErrNotExist)) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := setupLogging(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := egress.Apply(egressOptions()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if cmd.name != "version" {
+		slog.Info("starting", "version", version.Version, "command", cmd.name)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := cmd.run(ctx, args); err != nil {
+		stop()
+		slog.Error("command failed", "command", cmd.name, "error", err)
+		os.Exit(1)
+	}
+}
+
+// setupLogging makes the logger configured by LOG_LEVEL, LOG_FORMAT and LOG_REDACT the default, which the
+// standard log package also writes to. The last errors logged are kept for the status endpoint.
+func setupLogging() error {
+	level, err := logging.ParseLevel(os.Getenv("LOG_LEVEL"))
+	if err != nil {
+		return fmt.Errorf("invalid LOG_LEVEL: %w", err)
+	}
+	l, err := logging.New(os.Stderr, level, os.Getenv("LOG_FORMAT"))
+	if err != nil {
+		return fmt.Errorf("invalid LOG_FORMAT: %w", err)
+	}
+	recentErrors = logging.NewRecent(l.Handler(), slog.LevelError, recentErrorCount)
+	// Redaction comes first, so the recent errors shown by the status endpoint are redacted as well.
+	var h slog.Handler = recentErrors
+	if r := logging.ParseRedaction(os.Getenv("LOG_REDACT")); !r.Empty() {
+		h = logging.NewRedact(h, r)
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// usage prints the available commands.
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s%s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the flags of a command.\n", os.Args[0])
+}
+
+// versionString returns the version of the app.
+func versionString() string {
+	return "ado-asana-sync " + version.Version
 }
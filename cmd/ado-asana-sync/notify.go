@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/notify"
+	"github.com/danstis/ado-asana-sync/internal/secret"
+)
+
+// newNotifier returns the notifier configured by the NOTIFY_ variables, or nil when NOTIFY_WEBHOOK_URL is
+// unset.
+func newNotifier(ctx context.Context) (*notify.Notifier, error) {
+	hook := os.Getenv("NOTIFY_WEBHOOK_URL")
+	if hook == "" {
+		return nil, nil
+	}
+	detect := hook
+	if secret.IsReference(hook) {
+		detect = ""
+	}
+	format, err := notify.ParseFormat(os.Getenv("NOTIFY_FORMAT"), detect)
+	if err != nil {
+		return nil, err
+	}
+	kinds, err := notify.ParseKinds(os.Getenv("NOTIFY_EVENTS"))
+	if err != nil {
+		return nil, err
+	}
+	templates := map[notify.Kind]*template.Template{}
+	for _, k := range []notify.Kind{notify.CycleFailed, notify.ItemFailing, notify.Conflicts, notify.Summary} {
+		key := "NOTIFY_TEMPLATE_" + strings.ToUpper(string(k))
+		if v := os.Getenv(key); v != "" {
+			if templates[k], err = notify.ParseTemplate(k, v); err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+		}
+	}
+
+	n := notify.New(hook, format, kinds, templates)
+	if n.URLs, err = secretSource(ctx, "NOTIFY_WEBHOOK_URL"); err != nil {
+		return nil, err
+	}
+	if v := os.Getenv("NOTIFY_ITEM_FAILURES"); v != "" {
+		if n.ItemFailures, err = strconv.Atoi(v); err != nil || n.ItemFailures < 1 {
+			return nil, fmt.Errorf("invalid NOTIFY_ITEM_FAILURES %q", v)
+		}
+	}
+	if v := os.Getenv("NOTIFY_COOLDOWN"); v != "" {
+		if n.Cooldown, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid NOTIFY_COOLDOWN: %w", err)
+		}
+	}
+	if v := os.Getenv("NOTIFY_MAX_PER_HOUR"); v != "" {
+		if n.MaxPerHour, err = strconv.Atoi(v); err != nil || n.MaxPerHour < 0 {
+			return nil, fmt.Errorf("invalid NOTIFY_MAX_PER_HOUR %q", v)
+		}
+	}
+	return n, nil
+}
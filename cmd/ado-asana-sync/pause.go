@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/sync"
+)
+
+// runPause pauses the pair given by -pair in its store, so a running service stops writing to either side
+// from its next cycle on. The pause lasts -for, or until resume when it is not given.
+func runPause(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("pause", flag.ExitOnError)
+	pair := fs.String("pair", "", "pause this sync pair")
+	d := fs.Duration("for", 0, "resume the pair by itself after this duration, for example 2h")
+	reason := fs.String("reason", "", "record this reason with the pause")
+	conn := fs.String("connection", "", "the ADO connection of the pair, whose store holds the pause")
+	_ = fs.Parse(args)
+	if *pair == "" || *d < 0 {
+		return errors.New("usage: pause -pair name [-for duration] [-reason text] [-connection name]")
+	}
+
+	p := &sync.Pause{Since: time.Now().UTC(), Reason: *reason}
+	if *d > 0 {
+		p.Until = p.Since.Add(*d)
+	}
+	if err := setPause(ctx, *conn, *pair, p); err != nil {
+		return err
+	}
+	fmt.Printf("Paused %s %s.\n", *pair, p)
+	return nil
+}
+
+// runResume ends the pause of the pair given by -pair. A running service syncs the changes held back by the
+// pause in the pair's next cycle; resuming through the admin API starts that cycle at once.
+func runResume(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("resume", flag.ExitOnError)
+	pair := fs.String("pair", "", "resume this sync pair")
+	conn := fs.String("connection", "", "the ADO connection of the pair, whose store holds the pause")
+	_ = fs.Parse(args)
+	if *pair == "" {
+		return errors.New("usage: resume -pair name [-connection name]")
+	}
+	if err := setPause(ctx, *conn, *pair, nil); err != nil {
+		return err
+	}
+	fmt.Printf("Resumed %s.\n", *pair)
+	return nil
+}
+
+// setPause records p as the pause of the pair in the store of the named ADO connection.
+func setPause(ctx context.Context, conn, pair string, p *sync.Pause) error {
+	location, err := connectionStore(conn)
+	if err != nil {
+		return err
+	}
+	st, err := openStoreAt(ctx, location)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	return sync.SetPause(ctx, st, pair, p)
+}
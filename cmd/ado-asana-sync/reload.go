@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/systemd"
+)
+
+// configPoll is how often serve checks the configuration file for changes.
+const configPoll = 10 * time.Second
+
+// watchConfig reloads the configuration on SIGHUP and whenever the file named by CONFIG_FILE changes, until
+// ctx is done.
+func (a *app) watchConfig(ctx context.Context) {
+	path := os.Getenv("CONFIG_FILE")
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(configPoll)
+	defer ticker.Stop()
+
+	last := modTime(path)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			slog.Info("reloading configuration on SIGHUP")
+		case <-ticker.C:
+			if path == "" || modTime(path).Equal(last) {
+				continue
+			}
+			slog.Info("configuration file changed, reloading", "path", path)
+		}
+		last = modTime(path)
+		a.reload()
+	}
+}
+
+// reload applies the current configuration to the sync pairs. A configuration that fails to load or
+// validate is logged and the pairs keep their settings.
+func (a *app) reload() {
+	if ok, _ := systemd.Notify(systemd.Reloading); ok {
+		defer systemd.Notify(systemd.Ready)
+	}
+	if err := loadEnv(true); err != nil {
+		slog.Error("failed to reload configuration, keeping the current one", "error", err)
+		return
+	}
+	pairs, err := loadPairs()
+	if err == nil {
+		err = a.manager.Reload(pairs)
+	}
+	if err != nil {
+		slog.Error("failed to reload configuration, keeping the current one", "error", err)
+		return
+	}
+	slog.Info("reloaded configuration", "pairs", len(pairs))
+}
+
+// modTime returns the modification time of the file at path, or the zero time when it cannot be read.
+func modTime(path string) time.Time {
+	if path == "" {
+		return time.Time{}
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/danstis/ado-asana-sync/internal/replay"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// recorder records the API traffic of sync -record, and replayer answers the requests of the replay
+// command from a recording. Both are nil otherwise.
+var (
+	recorder *replay.Recorder
+	replayer *replay.Replayer
+)
+
+// apiTransport returns the transport the API clients of provider send their requests with: the recording
+// in replay mode, network recorded by the recorder with sync -record, and network otherwise. A nil network
+// is the default transport.
+func apiTransport(provider string, network http.RoundTripper) http.RoundTripper {
+	switch {
+	case replayer != nil:
+		return replayer.Transport(provider)
+	case recorder != nil:
+		return recorder.Transport(provider, network)
+	}
+	return network
+}
+
+// recordStores records the state of the stores of the app before its cycles change them.
+func (a *app) recordStores(ctx context.Context) error {
+	snap, err := a.store.Export(ctx)
+	if err != nil {
+		return err
+	}
+	recorder.Snapshot("", snap)
+	for _, c := range a.conns {
+		if c.store == nil {
+			continue
+		}
+		if snap, err = c.store.Export(ctx); err != nil {
+			return err
+		}
+		recorder.Snapshot(c.name, snap)
+	}
+	return nil
+}
+
+// saveRecording writes the recording to path, whether or not the cycle succeeded. Recordings hold the
+// work items and tasks of the pairs, so only the owner may read them.
+func saveRecording(path string) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err == nil {
+		err = recorder.Save(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		slog.Error("failed to save recording", "path", path, "error", err)
+		return
+	}
+	slog.Info("saved recording", "path", path)
+}
+
+// replayStore returns an in-memory copy of the recorded store named name, which is empty when the recording
+// has none, so replays never write to a real store.
+func replayStore(ctx context.Context, name string) (store.Store, error) {
+	st := store.NewMemory()
+	if snap := replayer.Snapshot(name); snap != nil {
+		if err := st.Import(ctx, snap); err != nil {
+			return nil, err
+		}
+	}
+	return st, nil
+}
+
+// runReplay runs a sync cycle of every pair against a recording made by sync -record, with the configuration
+// of the environment. Every request is answered from the recording and the stores start from their
+// recorded state, so the cycle runs offline, without credentials, and makes the same decisions as the
+// recorded one did.
+func runReplay(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: replay <recording>")
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	replayer, err = replay.Load(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	a, err := openApp(ctx, false)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+	slog.Info("replaying recorded cycle", "recorded", replayer.Recorded())
+	if err := a.manager.Validate(ctx); err != nil {
+		return err
+	}
+	err = a.syncOnce(ctx)
+	if n := replayer.Unplayed(); n > 0 {
+		slog.Warn("the replay sent fewer requests than the recorded cycle", "unplayed", n)
+	}
+	return err
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/health"
+	"github.com/danstis/ado-asana-sync/internal/systemd"
+)
+
+// defaultServiceName is the name the daemon is installed as when -name is not given.
+const defaultServiceName = "ado-asana-sync"
+
+// defaultWatchdog is the interval the daemon must ping the systemd watchdog within.
+const defaultWatchdog = time.Minute
+
+// serviceStarted, when set, is closed by serve once it starts syncing. Windows services report that they
+// are running with it.
+var serviceStarted chan struct{}
+
+// serviceOptions are the flags of the service subcommands.
+type serviceOptions struct {
+	name string
+	// config is the configuration file of the daemon, set as CONFIG_FILE.
+	config string
+	// logFile is a file the daemon logs to instead of stderr, which Windows services do not have.
+	logFile string
+	// user and envFile are the user the daemon runs as and a file holding its environment, for systemd.
+	user     string
+	envFile  string
+	watchdog time.Duration
+	// unitDir is the directory systemd units are installed in.
+	unitDir string
+}
+
+// runService runs a service subcommand: install registers the daemon with the service manager of the
+// platform, a Windows service or a systemd unit, and starts it; uninstall stops and removes it; run is what
+// the service manager starts; and unit prints the systemd unit install would write.
+func runService(ctx context.Context, args []string) error {
+	const usage = "usage: service install|uninstall|run|unit [-name name] [-config file] [-log-file file] [-user name] [-env-file file] [-watchdog duration]"
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	o, err := parseServiceFlags(args[0], args[1:])
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "install":
+		return installService(ctx, o)
+	case "uninstall":
+		return uninstallService(ctx, o)
+	case "run":
+		return runInService(ctx, o)
+	case "unit":
+		unit, err := serviceUnit(o)
+		if err != nil {
+			return err
+		}
+		fmt.Print(unit)
+		return nil
+	}
+	return errors.New(usage)
+}
+
+// parseServiceFlags parses the flags of a service subcommand, making the paths given absolute since
+// service managers start the daemon in another directory.
+func parseServiceFlags(cmd string, args []string) (*serviceOptions, error) {
+	fs := flag.NewFlagSet("service "+cmd, flag.ExitOnError)
+	o := &serviceOptions{}
+	fs.StringVar(&o.name, "name", defaultServiceName, "the name of the service")
+	fs.StringVar(&o.config, "config", os.Getenv("CONFIG_FILE"), "the configuration file of the service")
+	fs.StringVar(&o.logFile, "log-file", "", "log to this file instead of stderr, defaulting to a file next to the executable for Windows services")
+	fs.StringVar(&o.user, "user", "", "run the systemd unit as this user")
+	fs.StringVar(&o.envFile, "env-file", "", "read the environment of the systemd unit from this file")
+	fs.DurationVar(&o.watchdog, "watchdog", defaultWatchdog, "restart the systemd unit when its sync loop is not live for this long, 0 to disable")
+	fs.StringVar(&o.unitDir, "unit-dir", "/etc/systemd/system", "the directory the systemd unit is installed in")
+	_ = fs.Parse(args)
+	for _, p := range []*string{&o.config, &o.logFile, &o.envFile} {
+		if *p == "" {
+			continue
+		}
+		abs, err := filepath.Abs(*p)
+		if err != nil {
+			return nil, err
+		}
+		*p = abs
+	}
+	return o, nil
+}
+
+// runArgs returns the arguments of the executable started by the service manager.
+func (o *serviceOptions) runArgs() []string {
+	args := []string{"service", "run", "-name", o.name}
+	if o.config != "" {
+		args = append(args, "-config", o.config)
+	}
+	if o.logFile != "" {
+		args = append(args, "-log-file", o.logFile)
+	}
+	return args
+}
+
+// runInService applies the configuration file and log file of the service, which the service manager does
+// not pass in the environment, then runs serve under the service manager.
+func runInService(ctx context.Context, o *serviceOptions) error {
+	if o.config != "" && o.config != os.Getenv("CONFIG_FILE") {
+		if err := os.Setenv("CONFIG_FILE", o.config); err != nil {
+			return err
+		}
+		if err := loadEnv(false); err != nil {
+			return err
+		}
+	}
+	if o.logFile != "" {
+		f, err := os.OpenFile(o.logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		os.Stderr = f
+	}
+	if err := setupLogging(); err != nil {
+		return err
+	}
+	return serveService(ctx, o)
+}
+
+// announceReady tells the service manager running the daemon that it started, tells systemd again when it
+// stops, and pings the systemd watchdog for as long as the sync loop is live.
+func announceReady(ctx context.Context, live func() health.Result) {
+	if serviceStarted != nil {
+		close(serviceStarted)
+		serviceStarted = nil
+	}
+	notified, err := systemd.Notify(systemd.Ready)
+	if err != nil {
+		slog.Warn("failed to notify systemd of readiness", "error", err)
+	}
+	if !notified {
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		_, _ = systemd.Notify(systemd.Stopping)
+	}()
+	if interval := systemd.WatchdogInterval(); interval > 0 {
+		slog.Info("pinging the systemd watchdog", "interval", interval.String())
+		go systemd.RunWatchdog(ctx, interval, func() bool { return live().OK() })
+	}
+}
+
+// serviceUnit returns the systemd unit running the executable as the service.
+func serviceUnit(o *serviceOptions) (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return "", err
+	}
+	return systemd.Unit(systemd.UnitConfig{
+		Description:     "Azure DevOps to Asana sync",
+		Exec:            append([]string{exe}, o.runArgs()...),
+		User:            o.user,
+		EnvironmentFile: o.envFile,
+		Watchdog:        o.watchdog,
+	}), nil
+}
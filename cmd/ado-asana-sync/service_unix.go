@@ -0,0 +1,70 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// installService writes the systemd unit of the service, then enables and starts it.
+func installService(ctx context.Context, o *serviceOptions) error {
+	unit, err := serviceUnit(o)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(o.unitDir, o.name+".service")
+	if err := os.WriteFile(path, []byte(unit), 0o644); err != nil {
+		return err
+	}
+	if err := systemctl(ctx, "daemon-reload"); err != nil {
+		return err
+	}
+	if err := systemctl(ctx, "enable", "--now", o.name); err != nil {
+		return err
+	}
+	slog.Info("installed systemd unit", "unit", path)
+	return nil
+}
+
+// uninstallService stops and disables the systemd unit of the service and removes it.
+func uninstallService(ctx context.Context, o *serviceOptions) error {
+	path := filepath.Join(o.unitDir, o.name+".service")
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("systemd unit %s is not installed: %w", o.name, err)
+	}
+	if err := systemctl(ctx, "disable", "--now", o.name); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	if err := systemctl(ctx, "daemon-reload"); err != nil {
+		return err
+	}
+	slog.Info("uninstalled systemd unit", "unit", path)
+	return nil
+}
+
+// serveService runs serve, which notifies systemd itself.
+func serveService(ctx context.Context, _ *serviceOptions) error {
+	return runServe(ctx, nil)
+}
+
+// systemctl runs systemctl with args.
+func systemctl(ctx context.Context, args ...string) error {
+	out, err := exec.CommandContext(ctx, "systemctl", args...).CombinedOutput()
+	if err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) {
+			return fmt.Errorf("systemctl %s: %s", args[0], out)
+		}
+		return fmt.Errorf("systemctl %s: %w", args[0], err)
+	}
+	return nil
+}
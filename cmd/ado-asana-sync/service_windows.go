@@ -0,0 +1,161 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceStopWait is how long uninstall waits for the service to stop before deleting it.
+const serviceStopWait = time.Minute
+
+// installService registers the executable as a Windows service started automatically and restarted when it
+// fails, then starts it.
+func installService(_ context.Context, o *serviceOptions) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if o.logFile == "" {
+		o.logFile = filepath.Join(filepath.Dir(exe), o.name+".log")
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("connecting to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(o.name); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s is already installed", o.name)
+	}
+	s, err := m.CreateService(o.name, exe, mgr.Config{
+		DisplayName:      "Azure DevOps to Asana sync",
+		Description:      "Syncs Azure DevOps work items with Asana tasks.",
+		StartType:        mgr.StartAutomatic,
+		DelayedAutoStart: true,
+	}, o.runArgs()...)
+	if err != nil {
+		return fmt.Errorf("creating service %s: %w", o.name, err)
+	}
+	defer s.Close()
+	restart := []mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: 10 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: time.Minute},
+		{Type: mgr.ServiceRestart, Delay: 5 * time.Minute},
+	}
+	if err := s.SetRecoveryActions(restart, uint32((24 * time.Hour).Seconds())); err != nil {
+		return fmt.Errorf("setting the recovery actions of service %s: %w", o.name, err)
+	}
+	if err := s.Start(); err != nil {
+		return fmt.Errorf("starting service %s: %w", o.name, err)
+	}
+	slog.Info("installed windows service", "service", o.name, "log_file", o.logFile)
+	return nil
+}
+
+// uninstallService stops the Windows service and deletes it.
+func uninstallService(ctx context.Context, o *serviceOptions) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("connecting to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(o.name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed: %w", o.name, err)
+	}
+	defer s.Close()
+	if status, err := s.Control(svc.Stop); err == nil {
+		deadline := time.Now().Add(serviceStopWait)
+		for status.State != svc.Stopped && time.Now().Before(deadline) {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Second):
+			}
+			if status, err = s.Query(); err != nil {
+				return fmt.Errorf("querying service %s: %w", o.name, err)
+			}
+		}
+	}
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("deleting service %s: %w", o.name, err)
+	}
+	slog.Info("uninstalled windows service", "service", o.name)
+	return nil
+}
+
+// serveService runs serve under the Windows service manager, or in the console when started from one.
+func serveService(ctx context.Context, o *serviceOptions) error {
+	inService, err := svc.IsWindowsService()
+	if err != nil {
+		return err
+	}
+	if !inService {
+		return runServe(ctx, nil)
+	}
+	h := &windowsService{ctx: ctx}
+	if err := svc.Run(o.name, h); err != nil {
+		return err
+	}
+	return h.err
+}
+
+// windowsService runs serve as a Windows service, stopping it when the service manager asks.
+type windowsService struct {
+	ctx context.Context
+	err error
+}
+
+// Execute reports the service running once serve starts syncing, and stops serve on a stop or shutdown
+// request.
+func (w *windowsService) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	const accepts = svc.AcceptStop | svc.AcceptShutdown
+	status <- svc.Status{State: svc.StartPending}
+	ctx, cancel := context.WithCancel(w.ctx)
+	defer cancel()
+	started := make(chan struct{})
+	serviceStarted = started
+	done := make(chan error, 1)
+	go func() { done <- runServe(ctx, nil) }()
+
+	for {
+		select {
+		case <-started:
+			started = nil
+			status <- svc.Status{State: svc.Running, Accepts: accepts}
+		case err := <-done:
+			return w.stopped(err)
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				status <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				slog.Info("stopping on request of the service manager")
+				status <- svc.Status{State: svc.StopPending}
+				cancel()
+				return w.stopped(<-done)
+			}
+		}
+	}
+}
+
+// stopped records the error serve stopped with, which the service manager is given as the exit code of the
+// service.
+func (w *windowsService) stopped(err error) (bool, uint32) {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false, 0
+	}
+	w.err = err
+	slog.Error("service failed", "error", err)
+	return true, 1
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	gosync "sync"
+
+	"github.com/danstis/ado-asana-sync/internal/config"
+	"github.com/danstis/ado-asana-sync/internal/health"
+	"github.com/danstis/ado-asana-sync/internal/metrics"
+	"github.com/danstis/ado-asana-sync/internal/ratelimit"
+	"github.com/danstis/ado-asana-sync/internal/store"
+	"github.com/danstis/ado-asana-sync/internal/sync"
+	"github.com/danstis/ado-asana-sync/internal/tracing"
+	"github.com/danstis/ado-asana-sync/internal/version"
+)
+
+// tenantExts are the extensions of the files of tenants, read as config.Load reads configuration files.
+var tenantExts = []string{".json", ".yaml", ".yml"}
+
+// maxTenantFile bounds the size of the tenant files accepted by the admin API.
+const maxTenantFile = 1 << 20
+
+// tenant is a tenant synced by the service, with the app running its pairs.
+type tenant struct {
+	name string
+	cfg  *config.Tenant
+	app  *app
+	// budget caps the API requests of every pair of the tenant. It is nil when the tenant has no call budget.
+	budget *ratelimit.Allowance
+	admin  http.Handler
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// tenantInfo is a tenant listed by GET /tenants.
+type tenantInfo struct {
+	Name  string   `json:"name"`
+	Pairs []string `json:"pairs"`
+	// CallBudget is the call budget of the tenant, empty when it has none.
+	CallBudget string `json:"call_budget,omitempty"`
+}
+
+// tenants syncs the tenants of a multi-tenant service, each read from its file in dir, and onboards and
+// offboards them through the admin API while the service runs.
+type tenants struct {
+	// ctx is that of the service, which the pairs of every tenant run with until the tenant is stopped.
+	ctx  context.Context
+	dir  string
+	base sync.Config
+	// limits are shared by the connections of every tenant, so CALL_BUDGET caps the service as a whole.
+	limits ratelimit.Options
+	health *health.Group
+
+	// changes serializes the onboarding and offboarding of tenants, which mu is not held through.
+	changes gosync.Mutex
+	mu      gosync.Mutex
+	byName  map[string]*tenant
+}
+
+// serveTenants syncs the pairs of the tenants whose files are in dir until ctx is done, serving the admin
+// API that onboards and offboards them on ADMIN_ADDR. The pairs of the service itself are not synced.
+func serveTenants(ctx context.Context, dir string) error {
+	addr, token := os.Getenv("ADMIN_ADDR"), os.Getenv("ADMIN_TOKEN")
+	if addr == "" || token == "" {
+		return errors.New("TENANTS_DIR needs ADMIN_ADDR and ADMIN_TOKEN, as tenants are onboarded through the admin api")
+	}
+	if os.Getenv("WEBHOOK_ADDR") != "" || os.Getenv("LEADER_ELECTION") != "" {
+		return errors.New("TENANTS_DIR cannot be combined with WEBHOOK_ADDR or LEADER_ELECTION")
+	}
+	if on, _ := strconv.ParseBool(os.Getenv("SHARDING")); on {
+		return errors.New("TENANTS_DIR cannot be combined with SHARDING")
+	}
+	base, err := envPair()
+	if err != nil {
+		return err
+	}
+	limits, err := rateLimitOptions()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	files, err := tenantFiles(dir)
+	if err != nil {
+		return err
+	}
+	shutdownTracing, err := tracing.Setup(ctx, version.Version)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			slog.Error("failed to flush traces", "error", err)
+		}
+	}()
+
+	t := &tenants{ctx: ctx, dir: dir, base: base, limits: limits, health: health.NewGroup(), byName: map[string]*tenant{}}
+	defer t.stopAll()
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		// A tenant that fails to start does not stop the others; it starts again once its file is replaced.
+		cfg, err := config.LoadTenant(files[name])
+		if err == nil {
+			err = t.start(ctx, name, cfg)
+		}
+		if err != nil {
+			slog.Error("failed to start tenant", "tenant", name, "error", err)
+		}
+	}
+
+	serve(ctx, "admin", addr, requireToken(token, t.handler()))
+	serveProbes(ctx, map[string]http.Handler{
+		"/healthz": t.health.Handler(),
+		"/readyz":  t.health.Handler(),
+	})
+	announceReady(ctx, t.health.Live)
+	<-ctx.Done()
+	return nil
+}
+
+// tenantFiles returns the path of the file of every tenant in dir by the name of the tenant.
+func tenantFiles(dir string) (map[string]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]string{}
+	for _, e := range entries {
+		if e.IsDir() || !isTenantFile(e.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		name := config.TenantName(path)
+		if !config.ValidTenantName(name) {
+			slog.Warn("ignoring tenant file, its name is not a valid tenant name", "path", path)
+			continue
+		}
+		if other, ok := files[name]; ok {
+			return nil, fmt.Errorf("tenant %s has two files, %s and %s", name, other, path)
+		}
+		files[name] = path
+	}
+	return files, nil
+}
+
+// isTenantFile reports whether the file name has the extension of a tenant file.
+func isTenantFile(name string) bool {
+	for _, ext := range tenantExts {
+		if strings.EqualFold(filepath.Ext(name), ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// open connects to the organization, account and store of the named tenant and validates its pairs within
+// ctx. Its pairs are not synced until run is called.
+func (t *tenants) open(ctx context.Context, name string, cfg *config.Tenant) (*tenant, error) {
+	pairs, err := cfg.SyncPairs(name, t.base)
+	if err != nil {
+		return nil, err
+	}
+	conn, asanaConn := cfg.Connections(name)
+	a := &app{pairs: pairs}
+	// Connections outlive the request onboarding the tenant, so they are opened with the context of the
+	// service.
+	if a.store, err = openStoreAt(t.ctx, store.TenantLocation(storeLocation(), name)); err != nil {
+		return nil, err
+	}
+	if err := a.open(t.ctx, false, t.limits, []config.ADOConnection{conn}, []config.AsanaConnection{asanaConn}); err != nil {
+		a.close()
+		return nil, err
+	}
+	tn := &tenant{name: name, cfg: cfg, app: a, admin: a.adminMux()}
+	if caps, _ := ratelimit.ParseCaps(cfg.CallBudget); len(caps) > 0 {
+		tn.budget = ratelimit.NewAllowance("tenant:"+name, caps)
+	}
+	// As for the pairs of the service, an unavailable provider does not keep the tenant from starting.
+	if err := a.manager.Validate(ratelimit.WithAllowance(ctx, tn.budget)); err != nil {
+		if !sync.Unavailable(err) {
+			a.close()
+			return nil, err
+		}
+		slog.Warn("could not validate the pairs of the tenant, a provider is unavailable", "tenant", name, "error", err)
+	}
+	return tn, nil
+}
+
+// run syncs the pairs of the tenant until it is stopped.
+func (t *tenants) run(tn *tenant) error {
+	checker, err := tn.app.healthChecker()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(t.ctx)
+	tn.cancel, tn.done = cancel, make(chan struct{})
+	tn.app.probe(ctx)
+	go func() {
+		defer close(tn.done)
+		tn.app.manager.Run(ratelimit.WithAllowance(ctx, tn.budget), func(e *sync.Engine, rep *sync.Report, err error) {
+			checker.CycleFinished(e.Name())
+			logCycle(e, rep, err)
+		})
+	}()
+	t.mu.Lock()
+	t.byName[tn.name] = tn
+	t.mu.Unlock()
+	t.health.Set(tn.name, checker)
+	metrics.TenantPairs.WithLabelValues(tn.name).Set(float64(len(tn.app.pairs)))
+	slog.Info("tenant started", "tenant", tn.name, "pairs", len(tn.app.pairs))
+	return nil
+}
+
+// start opens the named tenant and syncs its pairs.
+func (t *tenants) start(ctx context.Context, name string, cfg *config.Tenant) error {
+	tn, err := t.open(ctx, name, cfg)
+	if err != nil {
+		return err
+	}
+	if err := t.run(tn); err != nil {
+		tn.app.close()
+		return err
+	}
+	return nil
+}
+
+// stop stops syncing the pairs of the named tenant, waiting for their cycles to finish, and closes its
+// connections. It returns the stopped tenant, or nil when it was not running.
+func (t *tenants) stop(name string) *tenant {
+	t.mu.Lock()
+	tn := t.byName[name]
+	delete(t.byName, name)
+	t.mu.Unlock()
+	if tn == nil {
+		return nil
+	}
+	t.health.Set(name, nil)
+	metrics.TenantPairs.DeleteLabelValues(name)
+	tn.cancel()
+	<-tn.done
+	tn.app.close()
+	slog.Info("tenant stopped", "tenant", name)
+	return tn
+}
+
+// stopAll stops every tenant.
+func (t *tenants) stopAll() {
+	t.mu.Lock()
+	names := make([]string, 0, len(t.byName))
+	for name := range t.byName {
+		names = append(names, name)
+	}
+	t.mu.Unlock()
+	for _, name := range names {
+		t.stop(name)
+	}
+}
+
+// get returns the named tenant, or nil when it is not running.
+func (t *tenants) get(name string) *tenant {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.byName[name]
+}
+
+// info describes the tenant as GET /tenants lists it.
+func (tn *tenant) info() tenantInfo {
+	info := tenantInfo{Name: tn.name, Pairs: []string{}, CallBudget: tn.cfg.CallBudget}
+	for _, p := range tn.app.pairs {
+		info.Pairs = append(info.Pairs, p.Name)
+	}
+	return info
+}
+
+// handler returns the routes of the admin API in multi-tenant mode, without authentication:
+//
+//	GET    /tenants              lists the tenants with their pairs
+//	GET    /tenants/{name}       shows the tenant
+//	PUT    /tenants/{name}       onboards the tenant whose file is the JSON body, or replaces its file
+//	DELETE /tenants/{name}       offboards the tenant, keeping its store
+//	*      /tenants/{name}/...   serves the admin API of the tenant, naming its pairs without the prefix
+//
+// A tenant file that fails to validate, or whose pairs fail to validate against ADO and Asana, is rejected
+// with 422 Unprocessable Entity and the tenant keeps its previous file.
+func (t *tenants) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tenants", t.handleList)
+	mux.HandleFunc("/tenants/", t.handleTenant)
+	return mux
+}
+
+func (t *tenants) handleList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	t.mu.Lock()
+	list := make([]tenantInfo, 0, len(t.byName))
+	for _, tn := range t.byName {
+		list = append(list, tn.info())
+	}
+	t.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	serveJSON(w, list)
+}
+
+func (t *tenants) handleTenant(w http.ResponseWriter, r *http.Request) {
+	name, rest, sub := strings.Cut(strings.TrimPrefix(r.URL.Path, "/tenants/"), "/")
+	if !config.ValidTenantName(name) {
+		http.Error(w, "invalid tenant name", http.StatusBadRequest)
+		return
+	}
+	if sub {
+		t.delegate(w, r, name, "/"+rest)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		tn := t.get(name)
+		if tn == nil {
+			http.Error(w, "unknown tenant", http.StatusNotFound)
+			return
+		}
+		serveJSON(w, tn.info())
+	case http.MethodPut:
+		t.handlePut(w, r, name)
+	case http.MethodDelete:
+		t.handleDelete(w, name)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// delegate serves the request for path of the admin API of the named tenant, counting the API requests it
+// sends against the budget of the tenant.
+func (t *tenants) delegate(w http.ResponseWriter, r *http.Request, name, path string) {
+	tn := t.get(name)
+	if tn == nil {
+		http.Error(w, "unknown tenant", http.StatusNotFound)
+		return
+	}
+	for _, prefix := range []string{"/sync/", "/pause/", "/resume/"} {
+		if strings.HasPrefix(path, prefix) {
+			path = prefix + name + "/" + strings.TrimPrefix(path, prefix)
+		}
+	}
+	req := r.Clone(ratelimit.WithAllowance(r.Context(), tn.budget))
+	req.URL.Path, req.URL.RawPath = path, ""
+	tn.admin.ServeHTTP(w, req)
+}
+
+func (t *tenants) handlePut(w http.ResponseWriter, r *http.Request, name string) {
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxTenantFile))
+	if err != nil {
+		http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	cfg, err := config.ParseTenant(name, b)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	t.changes.Lock()
+	defer t.changes.Unlock()
+	// The previous tenant is stopped first, as its store cannot be shared with the new one.
+	prev := t.stop(name)
+	tn, err := t.open(r.Context(), name, cfg)
+	if err != nil {
+		t.restore(prev)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err := writeTenantFile(t.dir, name, b); err != nil {
+		tn.app.close()
+		t.restore(prev)
+		slog.Error("failed to write tenant file", "tenant", name, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if err := t.run(tn); err != nil {
+		tn.app.close()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if prev == nil {
+		slog.Info("tenant onboarded through the admin api", "tenant", name)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		slog.Info("tenant file replaced through the admin api", "tenant", name)
+	}
+	serveJSON(w, tn.info())
+}
+
+// restore starts the tenant stopped to replace it again, after its replacement failed.
+func (t *tenants) restore(prev *tenant) {
+	if prev == nil {
+		return
+	}
+	if err := t.start(t.ctx, prev.name, prev.cfg); err != nil {
+		slog.Error("failed to restart tenant with its previous file", "tenant", prev.name, "error", err)
+	}
+}
+
+func (t *tenants) handleDelete(w http.ResponseWriter, name string) {
+	t.changes.Lock()
+	defer t.changes.Unlock()
+	if t.stop(name) == nil {
+		http.Error(w, "unknown tenant", http.StatusNotFound)
+		return
+	}
+	if err := removeTenantFiles(t.dir, name); err != nil {
+		slog.Error("failed to remove tenant file", "tenant", name, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("tenant offboarded through the admin api, its store is kept", "tenant", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeTenantFile replaces the file of the named tenant in dir with the JSON b. It is written to a
+// temporary file first and readable only by the service, as it holds the credentials of the tenant.
+func writeTenantFile(dir, name string, b []byte) error {
+	path := filepath.Join(dir, name+".json")
+	tmp := filepath.Join(dir, "."+name+".json.tmp")
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// A YAML file of the tenant would otherwise be read besides the new one on the next start.
+	for _, ext := range tenantExts[1:] {
+		if err := os.Remove(filepath.Join(dir, name+ext)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeTenantFiles removes the file of the named tenant from dir.
+func removeTenantFiles(dir, name string) error {
+	for _, ext := range tenantExts {
+		if err := os.Remove(filepath.Join(dir, name+ext)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+
+	"github.com/danstis/ado-asana-sync/internal/sync"
+)
+
+// runValidate checks the configuration, the store and the credentials and project access of every pair,
+// and prints a report of the checks with a hint for each failure. With -write the Asana token is shown to
+// be able to write to every project by creating and deleting a task in it.
+func runValidate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	pair := fs.String("pair", "", "only validate this sync pair")
+	write := fs.Bool("write", false, "create and delete a task in every asana project to check the token may write to it")
+	_ = fs.Parse(args)
+
+	a, err := openApp(ctx, false)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+	slog.Info("configuration loaded", "pairs", len(a.pairs), "store", storeLocation())
+
+	var checks []sync.Check
+	for _, c := range a.conns {
+		checks = append(checks, connectionCheck("ado credentials", connectionName(c.name), c.ado.Ping(ctx),
+			"check ADO_ORG_URL and the PAT or Entra ID credentials of the connection"))
+	}
+	for _, c := range a.asanaConns {
+		checks = append(checks, connectionCheck("asana credentials", asanaConnectionName(c.name), c.ping(ctx),
+			"check that the token of the connection is set and has not expired"))
+	}
+	found := *pair == ""
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PAIR	CHECK	TARGET	RESULT	DETAIL")
+	failed := printChecks(tw, "-", checks)
+	for _, e := range a.manager.Engines() {
+		if *pair != "" && e.Name() != *pair {
+			continue
+		}
+		found = true
+		failed += printChecks(tw, e.Name(), e.Probe(ctx, *write))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no sync pair named %q", *pair)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+	slog.Info("configuration is valid")
+	return nil
+}
+
+// connectionCheck returns the check of the credentials of a connection.
+func connectionCheck(name, target string, err error, hint string) sync.Check {
+	if err != nil {
+		return sync.Check{Name: name, Target: target, Result: sync.CheckFail, Detail: err.Error(), Hint: hint}
+	}
+	return sync.Check{Name: name, Target: target, Result: sync.CheckPass, Detail: "accepted"}
+}
+
+// printChecks writes the checks of a pair to tw, each hint on a line below its check, and returns how many
+// failed.
+func printChecks(tw *tabwriter.Writer, pair string, checks []sync.Check) int {
+	failed := 0
+	for _, c := range checks {
+		fmt.Fprintf(tw, "%s	%s	%s	%s	%s\n", pair, c.Name, c.Target, c.Result, c.Detail)
+		if c.Hint != "" {
+			fmt.Fprintf(tw, "	  hint: %s\n", c.Hint)
+		}
+		if c.Result == sync.CheckFail {
+			failed++
+		}
+	}
+	return failed
+}
@@ -0,0 +1,107 @@
+package ado
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// RelAttachedFile is the relation type of work item attachments.
+const RelAttachedFile = "AttachedFile"
+
+// RelHyperlink is the relation type of the hyperlinks of a work item.
+const RelHyperlink = "Hyperlink"
+
+// ErrTooLarge is returned when a download exceeds the permitted size.
+var ErrTooLarge = errors.New("ado: attachment exceeds size limit")
+
+// Attachments returns the attachment relations of the work item.
+func (w WorkItem) Attachments() []Relation {
+	var atts []Relation
+	for _, r := range w.Relations {
+		if r.Rel == RelAttachedFile {
+			atts = append(atts, r)
+		}
+	}
+	return atts
+}
+
+// Name returns the file name of an attachment relation.
+func (r Relation) Name() string {
+	s, _ := r.Attributes["name"].(string)
+	return s
+}
+
+// Size returns the size in bytes of an attachment relation, or -1 when unknown.
+func (r Relation) Size() int64 {
+	if f, ok := r.Attributes["resourceSize"].(float64); ok {
+		return int64(f)
+	}
+	return -1
+}
+
+// DownloadAttachment returns the content of the attachment at attachmentURL, failing with ErrTooLarge
+// when it is larger than max bytes.
+func (c *Client) DownloadAttachment(ctx context.Context, attachmentURL string, max int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, attachmentURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.authorize(req); err != nil {
+		return nil, err
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &Error{StatusCode: resp.StatusCode, Message: readMessage(resp.Body)}
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > max {
+		return nil, ErrTooLarge
+	}
+	return b, nil
+}
+
+// UploadAttachment uploads data as a file named name and returns the attachment URL, which can then be
+// linked to a work item with AddRelation.
+func (c *Client) UploadAttachment(ctx context.Context, project, name string, data []byte) (string, error) {
+	u := fmt.Sprintf("%s%s/_apis/wit/attachments?fileName=%s&api-version=%s", c.OrgURL, projectPath(project), url.QueryEscape(name), apiVersion)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	var resp struct {
+		URL string `json:"url"`
+	}
+	if err := c.send(req, &resp); err != nil {
+		return "", err
+	}
+	return resp.URL, nil
+}
+
+// RemoveRelation returns a patch operation that removes the relation at index i of the work item's
+// relations. Removing several relations in one patch must start from the highest index.
+func RemoveRelation(i int) PatchOperation {
+	return PatchOperation{Op: "remove", Path: "/relations/" + strconv.Itoa(i)}
+}
+
+// AddRelation returns a patch operation that links the work item to u with the given relation type.
+func AddRelation(rel, u string, attributes map[string]interface{}) PatchOperation {
+	value := map[string]interface{}{"rel": rel, "url": u}
+	if len(attributes) > 0 {
+		value["attributes"] = attributes
+	}
+	return PatchOperation{Op: "add", Path: "/relations/-", Value: value}
+}
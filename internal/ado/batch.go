@@ -0,0 +1,224 @@
+package ado
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MaxBatchRequests is the number of requests the $batch endpoint accepts at once.
+const MaxBatchRequests = 200
+
+// DefaultBatchWindow is how long a Batcher waits for more calls to join a batch.
+const DefaultBatchWindow = 20 * time.Millisecond
+
+// BatchRequest is a request sent as part of a batch.
+type BatchRequest struct {
+	Method string `json:"method"`
+	// URI is the path of the request relative to the organization URL, including its API version.
+	URI     string            `json:"uri"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    interface{}       `json:"body,omitempty"`
+}
+
+// BatchResponse is the response to a batch request. Its body is the JSON of the response as a string.
+type BatchResponse struct {
+	Code int    `json:"code"`
+	Body string `json:"body"`
+}
+
+// Err returns the error of a failed request, or nil when it succeeded.
+func (r BatchResponse) Err() error {
+	if r.Code >= 200 && r.Code <= 299 {
+		return nil
+	}
+	return &Error{StatusCode: r.Code, Message: readMessage(strings.NewReader(r.Body))}
+}
+
+// Decode decodes the body of the response to a successful request into out.
+func (r BatchResponse) Decode(out interface{}) error {
+	if out == nil || r.Body == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(r.Body), out); err != nil {
+		return fmt.Errorf("ado: decoding batch response: %w", err)
+	}
+	return nil
+}
+
+// Batch sends reqs to the $batch endpoint of the work item tracking API, MaxBatchRequests at a time, and
+// returns their responses in order. It returns an error only when a batch as a whole failed; the failures
+// of single requests are in their responses.
+func (c *Client) Batch(ctx context.Context, reqs []BatchRequest) ([]BatchResponse, error) {
+	responses := make([]BatchResponse, 0, len(reqs))
+	for start := 0; start < len(reqs); start += MaxBatchRequests {
+		end := start + MaxBatchRequests
+		if end > len(reqs) {
+			end = len(reqs)
+		}
+		var resp struct {
+			Value []BatchResponse `json:"value"`
+		}
+		if err := c.do(ctx, http.MethodPost, "/_apis/wit/$batch", "application/json", reqs[start:end], &resp); err != nil {
+			return nil, err
+		}
+		if len(resp.Value) != end-start {
+			return nil, fmt.Errorf("ado: batch of %d requests returned %d responses", end-start, len(resp.Value))
+		}
+		responses = append(responses, resp.Value...)
+	}
+	return responses, nil
+}
+
+// UpdateRequest returns the batch request applying ops to the work item with the given ID.
+func UpdateRequest(id int, ops []PatchOperation) BatchRequest {
+	return BatchRequest{
+		Method:  http.MethodPatch,
+		URI:     fmt.Sprintf("/_apis/wit/workitems/%d?api-version=%s", id, apiVersion),
+		Headers: map[string]string{"Content-Type": "application/json-patch+json"},
+		Body:    ops,
+	}
+}
+
+// Batcher is a Client that groups the work item updates made concurrently, such as by the workers of a sync
+// cycle, into requests to the $batch endpoint. Calls made within Window of each other share a batch of up to
+// MaxBatchRequests. An update that failed alone returns its own error, such as the conflict of a failed
+// revision test, while one that was rate limited is sent again on its own, so it waits and is retried like
+// any other call. Should a batch fail as a whole, its calls are sent singly, and once the endpoint is
+// rejected as unsupported calls are no longer batched.
+type Batcher struct {
+	*Client
+	// Window is how long calls wait for more calls to join their batch.
+	Window time.Duration
+
+	mu      sync.Mutex
+	pending []*batchCall
+	timer   *time.Timer
+	off     atomic.Bool
+}
+
+// NewBatcher returns a Batcher sending the updates of c in batches.
+func NewBatcher(c *Client) *Batcher {
+	return &Batcher{Client: c, Window: DefaultBatchWindow}
+}
+
+// batchCall is a call waiting for its batch.
+type batchCall struct {
+	ctx context.Context
+	req BatchRequest
+	out interface{}
+	// single sends the call on its own.
+	single func(ctx context.Context) error
+	done   chan error
+}
+
+// UpdateWorkItem applies ops to the work item as part of a batch and returns the updated item.
+func (b *Batcher) UpdateWorkItem(ctx context.Context, id int, ops []PatchOperation) (*WorkItem, error) {
+	var wi WorkItem
+	single := func(ctx context.Context) error {
+		updated, err := b.Client.UpdateWorkItem(ctx, id, ops)
+		if err == nil {
+			wi = *updated
+		}
+		return err
+	}
+	if err := b.call(ctx, UpdateRequest(id, ops), &wi, single); err != nil {
+		return nil, err
+	}
+	return &wi, nil
+}
+
+// call queues req for the next batch and waits for its result, decoded into out.
+func (b *Batcher) call(ctx context.Context, req BatchRequest, out interface{}, single func(ctx context.Context) error) error {
+	if b.off.Load() {
+		return single(ctx)
+	}
+	c := &batchCall{ctx: ctx, req: req, out: out, single: single, done: make(chan error, 1)}
+	b.mu.Lock()
+	b.pending = append(b.pending, c)
+	switch {
+	case len(b.pending) >= MaxBatchRequests:
+		calls := b.take()
+		b.mu.Unlock()
+		go b.send(calls)
+	case b.timer == nil:
+		b.timer = time.AfterFunc(b.Window, b.flush)
+		b.mu.Unlock()
+	default:
+		b.mu.Unlock()
+	}
+	select {
+	case err := <-c.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// take returns the pending calls and stops the timer of their batch. The caller must hold b.mu.
+func (b *Batcher) take() []*batchCall {
+	calls := b.pending
+	b.pending = nil
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	return calls
+}
+
+// flush sends the pending calls once the window of their batch passed.
+func (b *Batcher) flush() {
+	b.mu.Lock()
+	calls := b.take()
+	b.mu.Unlock()
+	b.send(calls)
+}
+
+// send sends calls as a batch and hands each its result.
+func (b *Batcher) send(calls []*batchCall) {
+	switch len(calls) {
+	case 0:
+		return
+	case 1:
+		calls[0].done <- calls[0].single(calls[0].ctx)
+		return
+	}
+	reqs := make([]BatchRequest, len(calls))
+	for i, c := range calls {
+		reqs[i] = c.req
+	}
+	// The batch carries the calls of several callers, so it is not cancelled with the first of them.
+	responses, err := b.Batch(context.WithoutCancel(calls[0].ctx), reqs)
+	if err != nil {
+		if unsupported(err) {
+			b.off.Store(true)
+		}
+		for _, c := range calls {
+			go func(c *batchCall) { c.done <- c.single(c.ctx) }(c)
+		}
+		return
+	}
+	for i, c := range calls {
+		switch r := responses[i]; {
+		case r.Code == http.StatusTooManyRequests:
+			go func(c *batchCall) { c.done <- c.single(c.ctx) }(c)
+		case r.Err() != nil:
+			c.done <- r.Err()
+		default:
+			c.done <- r.Decode(c.out)
+		}
+	}
+}
+
+// unsupported reports whether err rejects the $batch endpoint itself rather than the batch.
+func unsupported(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && (e.StatusCode == http.StatusNotFound || e.StatusCode == http.StatusMethodNotAllowed ||
+		e.StatusCode == http.StatusNotImplemented)
+}
@@ -0,0 +1,55 @@
+package ado
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Board is the Kanban board of a backlog level of a team.
+type Board struct {
+	ID      string        `json:"id"`
+	Name    string        `json:"name"`
+	Columns []BoardColumn `json:"columns"`
+	// Rows are the swimlanes of the board. The default lane has no name.
+	Rows   []BoardRow  `json:"rows"`
+	Fields BoardFields `json:"fields"`
+}
+
+// BoardColumn is a column of a board.
+type BoardColumn struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// IsSplit is set for columns split into doing and done, which the done field of the board tells apart.
+	IsSplit bool `json:"isSplit"`
+}
+
+// BoardRow is a swimlane of a board.
+type BoardRow struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// BoardFields names the work item fields holding the position of work items on a board. They are specific
+// to the board, as a work item can be on the boards of several teams.
+type BoardFields struct {
+	ColumnField FieldReference `json:"columnField"`
+	RowField    FieldReference `json:"rowField"`
+	DoneField   FieldReference `json:"doneField"`
+}
+
+// FieldReference refers to a work item field.
+type FieldReference struct {
+	ReferenceName string `json:"referenceName"`
+}
+
+// Board returns the Kanban board of the team in the project, named after its backlog level or by its ID.
+func (c *Client) Board(ctx context.Context, project, team, board string) (*Board, error) {
+	var b Board
+	path := fmt.Sprintf("%s/%s/_apis/work/boards/%s", projectPath(project), url.PathEscape(team), url.PathEscape(board))
+	if err := c.do(ctx, http.MethodGet, path, "", nil, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
@@ -0,0 +1,155 @@
+// Package ado provides a minimal client for the Azure DevOps work item tracking REST API.
+package ado
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const apiVersion = "7.0"
+
+// Client talks to a single Azure DevOps organization.
+type Client struct {
+	// OrgURL is the organization URL, for example https://dev.azure.com/contoso.
+	OrgURL string
+	// HTTP is the underlying HTTP client used for requests.
+	HTTP *http.Client
+	// Tokens, when set, supplies a bearer token for every request instead of the personal access token,
+	// for example an EntraSource.
+	Tokens TokenSource
+	// PATs, when set, supplies the personal access token of every request instead of the one the client was
+	// created with, so a rotated token is picked up.
+	PATs TokenSource
+
+	pat string
+}
+
+// TokenSource supplies access tokens.
+type TokenSource interface {
+	// Token returns a valid access token.
+	Token(ctx context.Context) (string, error)
+}
+
+// NewClient returns a Client for the organization at orgURL authenticating with a personal access token.
+func NewClient(orgURL, pat string) *Client {
+	return &Client{
+		OrgURL: strings.TrimRight(orgURL, "/"),
+		HTTP:   http.DefaultClient,
+		pat:    pat,
+	}
+}
+
+// Error is returned when the Azure DevOps API responds with a non-success status.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("ado: %d %s", e.StatusCode, e.Message)
+}
+
+// do performs a request against path (relative to the organization URL) and decodes the JSON response into out.
+// The default API version is appended unless path already specifies one.
+func (c *Client) do(ctx context.Context, method, path, contentType string, body, out interface{}) error {
+	u := c.OrgURL + path
+	switch {
+	case strings.Contains(u, "api-version="):
+	case strings.Contains(u, "?"):
+		u += "&api-version=" + apiVersion
+	default:
+		u += "?api-version=" + apiVersion
+	}
+
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("ado: encoding request: %w", err)
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return c.send(req, out)
+}
+
+// Ping checks that the organization can be reached with the client's credentials.
+func (c *Client) Ping(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, "/_apis/projects?$top=1", "", nil, nil)
+}
+
+// authorize sets the credentials of req.
+func (c *Client) authorize(req *http.Request) error {
+	if c.Tokens == nil {
+		pat := c.pat
+		if c.PATs != nil {
+			var err error
+			if pat, err = c.PATs.Token(req.Context()); err != nil {
+				return fmt.Errorf("ado: %w", err)
+			}
+		}
+		req.SetBasicAuth("", pat)
+		return nil
+	}
+	token, err := c.Tokens.Token(req.Context())
+	if err != nil {
+		return fmt.Errorf("ado: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// send authenticates and performs req, decoding the JSON response into out.
+func (c *Client) send(req *http.Request, out interface{}) error {
+	if err := c.authorize(req); err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &Error{StatusCode: resp.StatusCode, Message: readMessage(resp.Body)}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("ado: decoding response: %w", err)
+	}
+	return nil
+}
+
+// readMessage extracts the error message from an Azure DevOps error response body.
+func readMessage(r io.Reader) string {
+	b, _ := io.ReadAll(io.LimitReader(r, 64<<10))
+	var e struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(b, &e) == nil && e.Message != "" {
+		return e.Message
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// projectPath returns the escaped path prefix for project.
+func projectPath(project string) string {
+	return "/" + url.PathEscape(project)
+}
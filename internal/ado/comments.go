@@ -0,0 +1,60 @@
+package ado
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// commentsAPIVersion is the API version of the work item comments endpoints, which are still in preview.
+const commentsAPIVersion = "7.0-preview.3"
+
+// Comment is an entry in a work item discussion.
+type Comment struct {
+	ID          int       `json:"id"`
+	Text        string    `json:"text"`
+	CreatedBy   Identity  `json:"createdBy"`
+	CreatedDate time.Time `json:"createdDate"`
+}
+
+// commentsPath returns the comments endpoint for the work item.
+func commentsPath(project string, id int) string {
+	return fmt.Sprintf("%s/_apis/wit/workItems/%d/comments", projectPath(project), id)
+}
+
+// Comments returns every comment on the work item, oldest first.
+func (c *Client) Comments(ctx context.Context, project string, id int) ([]Comment, error) {
+	var comments []Comment
+	token := ""
+	for {
+		q := url.Values{"api-version": {commentsAPIVersion}, "order": {"asc"}}
+		if token != "" {
+			q.Set("continuationToken", token)
+		}
+		var resp struct {
+			Comments          []Comment `json:"comments"`
+			ContinuationToken string    `json:"continuationToken"`
+		}
+		if err := c.do(ctx, http.MethodGet, commentsPath(project, id)+"?"+q.Encode(), "", nil, &resp); err != nil {
+			return nil, err
+		}
+		comments = append(comments, resp.Comments...)
+		if resp.ContinuationToken == "" {
+			return comments, nil
+		}
+		token = resp.ContinuationToken
+	}
+}
+
+// AddComment adds an HTML comment to the work item.
+func (c *Client) AddComment(ctx context.Context, project string, id int, text string) (*Comment, error) {
+	var comment Comment
+	body := map[string]string{"text": text}
+	path := commentsPath(project, id) + "?api-version=" + commentsAPIVersion
+	if err := c.do(ctx, http.MethodPost, path, "application/json", body, &comment); err != nil {
+		return nil, err
+	}
+	return &comment, nil
+}
@@ -0,0 +1,139 @@
+package ado
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// RelArtifact is the relation type of links from a work item to artifacts such as pull requests.
+const RelArtifact = "ArtifactLink"
+
+// Kinds of development links.
+const (
+	LinkPullRequest = "pull request"
+	LinkCommit      = "commit"
+	LinkBranch      = "branch"
+)
+
+// artifactKinds maps the artifact types of Git artifact URIs to the kinds of development links.
+var artifactKinds = map[string]string{
+	"PullRequestId": LinkPullRequest,
+	"Commit":        LinkCommit,
+	"Ref":           LinkBranch,
+}
+
+// DevelopmentLink is a link from a work item to a pull request, commit or branch in Azure Repos.
+type DevelopmentLink struct {
+	// Kind is LinkPullRequest, LinkCommit or LinkBranch.
+	Kind string
+	// Project and Repository are the IDs of the project and repository holding the artifact.
+	Project    string
+	Repository string
+	// ID is the pull request number, the commit SHA or the branch name.
+	ID string
+	// URL is the browser URL of the artifact, empty when the work item has no URL.
+	URL string
+}
+
+// DevelopmentLinks returns the pull requests, commits and branches the work item links to, in the order
+// they were linked.
+func (w WorkItem) DevelopmentLinks() []DevelopmentLink {
+	// Artifacts are in the organization of the work item, whose URL is <org>/<project>/_apis/wit/workItems/<id>.
+	org := ""
+	if i := strings.Index(strings.ToLower(w.URL), "/_apis/wit/workitems/"); i >= 0 {
+		org = w.URL[:strings.LastIndex(w.URL[:i], "/")]
+	}
+	var links []DevelopmentLink
+	for _, r := range w.Relations {
+		if r.Rel != RelArtifact {
+			continue
+		}
+		l, ok := parseArtifact(r.URL)
+		if !ok {
+			continue
+		}
+		if org != "" {
+			l.URL = l.webURL(org)
+		}
+		links = append(links, l)
+	}
+	return links
+}
+
+// parseArtifact parses a Git artifact URI such as vstfs:///Git/PullRequestId/<project>%2F<repository>%2F<id>.
+func parseArtifact(uri string) (DevelopmentLink, bool) {
+	rest, ok := strings.CutPrefix(uri, "vstfs:///Git/")
+	if !ok {
+		return DevelopmentLink{}, false
+	}
+	typ, id, _ := strings.Cut(rest, "/")
+	kind, ok := artifactKinds[typ]
+	if !ok {
+		return DevelopmentLink{}, false
+	}
+	id, err := url.PathUnescape(id)
+	if err != nil {
+		return DevelopmentLink{}, false
+	}
+	parts := strings.SplitN(id, "/", 3)
+	if len(parts) != 3 || parts[2] == "" {
+		return DevelopmentLink{}, false
+	}
+	l := DevelopmentLink{Kind: kind, Project: parts[0], Repository: parts[1], ID: parts[2]}
+	if kind == LinkBranch {
+		// Branch refs are prefixed with GB, for Git branch.
+		l.ID = strings.TrimPrefix(l.ID, "GB")
+	}
+	return l, true
+}
+
+// webURL returns the browser URL of the artifact in the organization at org.
+func (l DevelopmentLink) webURL(org string) string {
+	repo := org + "/" + url.PathEscape(l.Project) + "/_git/" + url.PathEscape(l.Repository)
+	switch l.Kind {
+	case LinkPullRequest:
+		return repo + "/pullrequest/" + l.ID
+	case LinkCommit:
+		return repo + "/commit/" + l.ID
+	default:
+		return repo + "?version=GB" + url.QueryEscape(l.ID)
+	}
+}
+
+// PullRequest is an Azure Repos pull request.
+type PullRequest struct {
+	ID    int    `json:"pullRequestId"`
+	Title string `json:"title"`
+	// Status is active, completed or abandoned.
+	Status  string `json:"status"`
+	IsDraft bool   `json:"isDraft"`
+}
+
+// Repository is an Azure Repos Git repository.
+type Repository struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Repositories returns the Git repositories of the project.
+func (c *Client) Repositories(ctx context.Context, project string) ([]Repository, error) {
+	var out struct {
+		Value []Repository `json:"value"`
+	}
+	if err := c.do(ctx, http.MethodGet, projectPath(project)+"/_apis/git/repositories", "", nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Value, nil
+}
+
+// PullRequest returns the pull request with the given number in the project.
+func (c *Client) PullRequest(ctx context.Context, project string, id int) (*PullRequest, error) {
+	var pr PullRequest
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("%s/_apis/git/pullrequests/%d", projectPath(project), id), "", nil, &pr); err != nil {
+		return nil, err
+	}
+	return &pr, nil
+}
@@ -0,0 +1,137 @@
+package ado
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultAuthorityHost is the Entra ID authority of the public cloud.
+const DefaultAuthorityHost = "https://login.microsoftonline.com"
+
+// Scope is the OAuth scope of the Azure DevOps resource.
+const Scope = "499b84ac-1321-427f-aa17-267ca6975798/.default"
+
+// refreshMargin is how long before its expiry an access token is refreshed.
+const refreshMargin = 5 * time.Minute
+
+// EntraConfig identifies an Entra ID service principal or workload identity. Exactly one of ClientSecret
+// and FederatedTokenFile is used, FederatedTokenFile taking precedence.
+type EntraConfig struct {
+	TenantID string
+	ClientID string
+	// ClientSecret authenticates a service principal with a client secret.
+	ClientSecret string
+	// FederatedTokenFile is the path of a federated token, such as the service account token projected by
+	// Azure Workload Identity, used as a client assertion. It is read again on every refresh as it rotates.
+	FederatedTokenFile string
+	// AuthorityHost defaults to DefaultAuthorityHost.
+	AuthorityHost string
+	// Scope is the scope tokens are requested for, defaulting to Scope. Other Azure resources, such as Key
+	// Vault, can be accessed with the same identity.
+	Scope string
+	// HTTP is the client used for token requests, defaulting to http.DefaultClient.
+	HTTP *http.Client
+}
+
+// EntraSource is a TokenSource that obtains Azure DevOps access tokens with the client credentials flow and
+// caches them until shortly before they expire. It is safe for concurrent use.
+type EntraSource struct {
+	config EntraConfig
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// NewEntraSource returns an EntraSource for cfg.
+func NewEntraSource(cfg EntraConfig) (*EntraSource, error) {
+	if cfg.TenantID == "" || cfg.ClientID == "" {
+		return nil, errors.New("ado: entra id authentication needs a tenant and client id")
+	}
+	if cfg.ClientSecret == "" && cfg.FederatedTokenFile == "" {
+		return nil, errors.New("ado: entra id authentication needs a client secret or federated token file")
+	}
+	return &EntraSource{config: cfg}, nil
+}
+
+// Token implements TokenSource.
+func (s *EntraSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Until(s.expiry) > refreshMargin {
+		return s.token, nil
+	}
+	token, expiry, err := s.request(ctx)
+	if err != nil {
+		return "", err
+	}
+	s.token, s.expiry = token, expiry
+	return token, nil
+}
+
+// request obtains a new access token.
+func (s *EntraSource) request(ctx context.Context) (string, time.Time, error) {
+	cfg := s.config
+	scope := cfg.Scope
+	if scope == "" {
+		scope = Scope
+	}
+	form := url.Values{
+		"grant_type": {"client_credentials"},
+		"client_id":  {cfg.ClientID},
+		"scope":      {scope},
+	}
+	if cfg.FederatedTokenFile != "" {
+		b, err := os.ReadFile(cfg.FederatedTokenFile)
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("reading federated token: %w", err)
+		}
+		form.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+		form.Set("client_assertion", strings.TrimSpace(string(b)))
+	} else {
+		form.Set("client_secret", cfg.ClientSecret)
+	}
+
+	host := cfg.AuthorityHost
+	if host == "" {
+		host = DefaultAuthorityHost
+	}
+	u := strings.TrimRight(host, "/") + "/" + url.PathEscape(cfg.TenantID) + "/oauth2/v2.0/token"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	client := cfg.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int    `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", time.Time{}, fmt.Errorf("decoding token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || body.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("entra id token request: %d %s: %s", resp.StatusCode, body.Error, body.ErrorDescription)
+	}
+	return body.AccessToken, time.Now().Add(time.Duration(body.ExpiresIn) * time.Second), nil
+}
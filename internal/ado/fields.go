@@ -0,0 +1,63 @@
+package ado
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Types of the values of work item fields.
+const (
+	FieldTypeString    = "string"
+	FieldTypeInteger   = "integer"
+	FieldTypeDouble    = "double"
+	FieldTypeDateTime  = "dateTime"
+	FieldTypeBoolean   = "boolean"
+	FieldTypeHTML      = "html"
+	FieldTypePlainText = "plainText"
+	FieldTypeTreePath  = "treePath"
+	FieldTypeIdentity  = "identity"
+)
+
+// Field is a work item field of a project, the custom fields of its inherited process included.
+type Field struct {
+	// ReferenceName is the name the field is read and written with, such as Custom.ReleaseTrain.
+	ReferenceName string `json:"referenceName"`
+	// Name is the display name of the field, such as Release Train.
+	Name string `json:"name"`
+	// Type is the type of the values of the field, one of the FieldType constants or another type such as
+	// guid or history.
+	Type     string `json:"type"`
+	ReadOnly bool   `json:"readOnly"`
+	// IsPicklist is set for fields whose values are chosen from the picklist PicklistID of the process.
+	IsPicklist bool   `json:"isPicklist"`
+	PicklistID string `json:"picklistId,omitempty"`
+}
+
+// Fields returns the work item fields of the project.
+func (c *Client) Fields(ctx context.Context, project string) ([]Field, error) {
+	var resp struct {
+		Value []Field `json:"value"`
+	}
+	if err := c.do(ctx, http.MethodGet, projectPath(project)+"/_apis/wit/fields", "", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Value, nil
+}
+
+// Picklist returns the values of the picklist of an inherited process with the given ID. The values of
+// integer picklists are returned as text.
+func (c *Client) Picklist(ctx context.Context, id string) ([]string, error) {
+	var resp struct {
+		Items []interface{} `json:"items"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/_apis/work/processes/lists/"+url.PathEscape(id), "", nil, &resp); err != nil {
+		return nil, err
+	}
+	values := make([]string, 0, len(resp.Items))
+	for _, v := range resp.Items {
+		values = append(values, fmt.Sprint(v))
+	}
+	return values, nil
+}
@@ -0,0 +1,69 @@
+package ado
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Iteration is a node of a project's iteration tree.
+type Iteration struct {
+	// Path is the iteration path as stored in System.IterationPath, for example "Project\Sprint 1".
+	Path string
+	// StartDate and FinishDate are nil when the iteration has no dates.
+	StartDate  *time.Time
+	FinishDate *time.Time
+}
+
+// iterationNode is a classification node, an iteration or area, as returned by the API.
+type iterationNode struct {
+	Name       string `json:"name"`
+	Attributes struct {
+		StartDate  *time.Time `json:"startDate"`
+		FinishDate *time.Time `json:"finishDate"`
+	} `json:"attributes"`
+	Children []iterationNode `json:"children"`
+}
+
+// iterationDepth is the depth of the iteration and area trees fetched, which ADO limits to 14 levels.
+const iterationDepth = 14
+
+// Iterations returns every iteration of the project, parents before their children.
+func (c *Client) Iterations(ctx context.Context, project string) ([]Iteration, error) {
+	var root iterationNode
+	path := fmt.Sprintf("%s/_apis/wit/classificationnodes/Iterations?$depth=%d", projectPath(project), iterationDepth)
+	if err := c.do(ctx, http.MethodGet, path, "", nil, &root); err != nil {
+		return nil, err
+	}
+	var its []Iteration
+	var walk func(n iterationNode, path string)
+	walk = func(n iterationNode, path string) {
+		its = append(its, Iteration{Path: path, StartDate: n.Attributes.StartDate, FinishDate: n.Attributes.FinishDate})
+		for _, child := range n.Children {
+			walk(child, path+`\`+child.Name)
+		}
+	}
+	// The root node is named after the project, as are the iteration paths of work items.
+	walk(root, root.Name)
+	return its, nil
+}
+
+// AreaPaths returns the area path of every area of the project, parents before their children.
+func (c *Client) AreaPaths(ctx context.Context, project string) ([]string, error) {
+	var root iterationNode
+	path := fmt.Sprintf("%s/_apis/wit/classificationnodes/Areas?$depth=%d", projectPath(project), iterationDepth)
+	if err := c.do(ctx, http.MethodGet, path, "", nil, &root); err != nil {
+		return nil, err
+	}
+	var paths []string
+	var walk func(n iterationNode, path string)
+	walk = func(n iterationNode, path string) {
+		paths = append(paths, path)
+		for _, child := range n.Children {
+			walk(child, path+`\`+child.Name)
+		}
+	}
+	walk(root, root.Name)
+	return paths, nil
+}
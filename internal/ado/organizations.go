@@ -0,0 +1,69 @@
+package ado
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// ProfileURL is the URL of the Azure DevOps profile service, which knows the organizations of a user.
+const ProfileURL = "https://app.vssps.visualstudio.com"
+
+// Organization is an Azure DevOps organization.
+type Organization struct {
+	ID   string `json:"accountId"`
+	Name string `json:"accountName"`
+}
+
+// URL returns the URL of the organization, for example https://dev.azure.com/contoso.
+func (o Organization) URL() string {
+	return "https://dev.azure.com/" + url.PathEscape(o.Name)
+}
+
+// Organizations returns the organizations the user of the credentials is a member of. The client must be one
+// of ProfileURL rather than of an organization, and a PAT needs to be valid for all accessible organizations
+// to list them.
+func (c *Client) Organizations(ctx context.Context) ([]Organization, error) {
+	var me struct {
+		ID string `json:"id"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/_apis/profile/profiles/me?api-version=6.0", "", nil, &me); err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Value []Organization `json:"value"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/_apis/accounts?memberId="+url.QueryEscape(me.ID)+"&api-version=6.0", "", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Value, nil
+}
+
+// Project is a project of the organization.
+type Project struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// projectsPage is the number of projects listed per request.
+const projectsPage = 100
+
+// Projects returns the projects of the organization the credentials can see.
+func (c *Client) Projects(ctx context.Context) ([]Project, error) {
+	var projects []Project
+	for {
+		var resp struct {
+			Value []Project `json:"value"`
+		}
+		path := fmt.Sprintf("/_apis/projects?$top=%d&$skip=%d", projectsPage, len(projects))
+		if err := c.do(ctx, http.MethodGet, path, "", nil, &resp); err != nil {
+			return nil, err
+		}
+		projects = append(projects, resp.Value...)
+		if len(resp.Value) < projectsPage {
+			return projects, nil
+		}
+	}
+}
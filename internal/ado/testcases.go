@@ -0,0 +1,177 @@
+package ado
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TypeTestCase is the work item type of test cases.
+const TypeTestCase = "Test Case"
+
+// FieldSteps is the reference name of the field holding the steps of a test case as XML.
+const FieldSteps = "Microsoft.VSTS.TCM.Steps"
+
+// RelTestedBy is the relation type of links from a work item to the test cases that test it.
+const RelTestedBy = "Microsoft.VSTS.Common.TestedBy-Forward"
+
+// Test outcomes that mark a test case or step as run.
+const (
+	OutcomePassed = "Passed"
+	OutcomeFailed = "Failed"
+)
+
+// TestStep is a step of a test case.
+type TestStep struct {
+	// ID identifies the step within its test case; results refer to it.
+	ID int
+	// Action and Expected are the HTML of what to do and of the expected result, which may be empty.
+	Action   string
+	Expected string
+	// SharedSteps is the ID of the shared steps work item the step stands for, or 0 for an ordinary step.
+	SharedSteps int
+}
+
+// xmlSteps is the XML of the steps field, <steps><step id="2"><parameterizedString>...</steps>.
+type xmlSteps struct {
+	Nodes []struct {
+		XMLName xml.Name
+		ID      int      `xml:"id,attr"`
+		Ref     int      `xml:"ref,attr"`
+		Strings []string `xml:"parameterizedString"`
+	} `xml:",any"`
+}
+
+// TestSteps returns the steps of a test case in order, or nil when the work item has none or they cannot be
+// parsed.
+func (w WorkItem) TestSteps() []TestStep {
+	raw := w.String(FieldSteps)
+	if raw == "" {
+		return nil
+	}
+	var doc xmlSteps
+	if err := xml.Unmarshal([]byte(raw), &doc); err != nil {
+		return nil
+	}
+	var steps []TestStep
+	for _, n := range doc.Nodes {
+		s := TestStep{ID: n.ID}
+		switch n.XMLName.Local {
+		case "step":
+			if len(n.Strings) > 0 {
+				s.Action = n.Strings[0]
+			}
+			if len(n.Strings) > 1 {
+				s.Expected = n.Strings[1]
+			}
+		case "compref":
+			s.SharedSteps = n.Ref
+		default:
+			continue
+		}
+		steps = append(steps, s)
+	}
+	return steps
+}
+
+// TestPoint is a test case in a suite of a test plan, with the outcome of its latest run.
+type TestPoint struct {
+	ID int `json:"id"`
+	// Outcome is the outcome of the latest run, such as Passed, Failed or Blocked, or Unspecified when it
+	// has not been run.
+	Outcome  string        `json:"outcome"`
+	TestCase TestReference `json:"testCase"`
+	TestPlan TestReference `json:"testPlan"`
+	// LastTestRun and LastResult identify the result of the latest run, and are empty when it has not been
+	// run.
+	LastTestRun     TestReference `json:"lastTestRun"`
+	LastResult      TestReference `json:"lastResult"`
+	LastUpdatedDate time.Time     `json:"lastUpdatedDate"`
+}
+
+// TestReference is a reference to a test object, whose ID the API returns as a string.
+type TestReference struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+// TestCaseID returns the ID of the test case of the point.
+func (p TestPoint) TestCaseID() int {
+	id, _ := strconv.Atoi(p.TestCase.ID)
+	return id
+}
+
+// Run returns the IDs of the test run and result of the latest run of the point, and false when it has not
+// been run.
+func (p TestPoint) Run() (run, result int, ok bool) {
+	run, err := strconv.Atoi(strings.TrimSpace(p.LastTestRun.ID))
+	if err != nil || run == 0 {
+		return 0, 0, false
+	}
+	result, err = strconv.Atoi(strings.TrimSpace(p.LastResult.ID))
+	if err != nil {
+		return 0, 0, false
+	}
+	return run, result, true
+}
+
+// TestPoints returns the test points of the test cases in every test plan of the project.
+func (c *Client) TestPoints(ctx context.Context, project string, testCases []int) ([]TestPoint, error) {
+	body := map[string]interface{}{"pointsFilter": map[string]interface{}{"testcaseIds": testCases}}
+	var out struct {
+		Points []TestPoint `json:"points"`
+	}
+	if err := c.do(ctx, http.MethodPost, projectPath(project)+"/_apis/test/points", "application/json", body, &out); err != nil {
+		return nil, err
+	}
+	return out.Points, nil
+}
+
+// TestResult is the result of a test case in a test run.
+type TestResult struct {
+	ID         int    `json:"id"`
+	Outcome    string `json:"outcome"`
+	Iterations []struct {
+		ID            int `json:"id"`
+		ActionResults []struct {
+			// ActionPath is the ID of the step in 8 hexadecimal digits, prefixed with those of its shared
+			// steps for steps within them.
+			ActionPath string `json:"actionPath"`
+			Outcome    string `json:"outcome"`
+		} `json:"actionResults"`
+	} `json:"iterationDetails"`
+}
+
+// StepOutcomes returns the outcomes of the steps of the last iteration of the result by step ID. Steps
+// within shared steps count for their shared steps, which take the outcome of their last step.
+func (r TestResult) StepOutcomes() map[int]string {
+	if len(r.Iterations) == 0 {
+		return nil
+	}
+	outcomes := map[int]string{}
+	for _, a := range r.Iterations[len(r.Iterations)-1].ActionResults {
+		if len(a.ActionPath) < 8 {
+			continue
+		}
+		id, err := strconv.ParseInt(a.ActionPath[:8], 16, 32)
+		if err != nil {
+			continue
+		}
+		outcomes[int(id)] = a.Outcome
+	}
+	return outcomes
+}
+
+// TestResult returns the result with its step outcomes from the test run of the project.
+func (c *Client) TestResult(ctx context.Context, project string, run, result int) (*TestResult, error) {
+	var r TestResult
+	path := fmt.Sprintf("%s/_apis/test/Runs/%d/Results/%d?detailsToInclude=Iterations", projectPath(project), run, result)
+	if err := c.do(ctx, http.MethodGet, path, "", nil, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
@@ -0,0 +1,32 @@
+package ado
+
+import (
+	"context"
+	"net/http"
+)
+
+// WorkItemType is a work item type of a project and the states its items can be in.
+type WorkItemType struct {
+	Name   string          `json:"name"`
+	States []WorkItemState `json:"states"`
+	// Disabled types cannot be used for new work items.
+	Disabled bool `json:"isDisabled"`
+}
+
+// WorkItemState is a state of a work item type.
+type WorkItemState struct {
+	Name string `json:"name"`
+	// Category is the state category, such as Proposed, InProgress, Resolved, Completed or Removed.
+	Category string `json:"category"`
+}
+
+// WorkItemTypes returns the work item types of the project with their states.
+func (c *Client) WorkItemTypes(ctx context.Context, project string) ([]WorkItemType, error) {
+	var resp struct {
+		Value []WorkItemType `json:"value"`
+	}
+	if err := c.do(ctx, http.MethodGet, projectPath(project)+"/_apis/wit/workitemtypes", "", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Value, nil
+}
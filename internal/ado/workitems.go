@@ -0,0 +1,318 @@
+package ado
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Well known work item field reference names.
+const (
+	FieldID            = "System.Id"
+	FieldTitle         = "System.Title"
+	FieldState         = "System.State"
+	FieldWorkItemType  = "System.WorkItemType"
+	FieldAssignedTo    = "System.AssignedTo"
+	FieldChangedDate   = "System.ChangedDate"
+	FieldCreatedDate   = "System.CreatedDate"
+	FieldDescription   = "System.Description"
+	FieldAreaPath      = "System.AreaPath"
+	FieldIterationPath = "System.IterationPath"
+	FieldTags          = "System.Tags"
+	FieldTeamProject   = "System.TeamProject"
+	FieldTargetDate    = "Microsoft.VSTS.Scheduling.TargetDate"
+
+	FieldCompletedWork    = "Microsoft.VSTS.Scheduling.CompletedWork"
+	FieldRemainingWork    = "Microsoft.VSTS.Scheduling.RemainingWork"
+	FieldOriginalEstimate = "Microsoft.VSTS.Scheduling.OriginalEstimate"
+	FieldStoryPoints      = "Microsoft.VSTS.Scheduling.StoryPoints"
+
+	FieldStateChangeDate = "Microsoft.VSTS.Common.StateChangeDate"
+	// FieldBlocked is "Yes" on blocked work items of the CMMI process.
+	FieldBlocked = "Microsoft.VSTS.CMMI.Blocked"
+)
+
+// maxBatch is the maximum number of work items the API returns per request.
+const maxBatch = 200
+
+// WorkItem is an Azure DevOps work item.
+type WorkItem struct {
+	ID        int                    `json:"id"`
+	Rev       int                    `json:"rev"`
+	Fields    map[string]interface{} `json:"fields"`
+	Relations []Relation             `json:"relations,omitempty"`
+	URL       string                 `json:"url"`
+}
+
+// Relation is a link from a work item to another resource.
+type Relation struct {
+	Rel        string                 `json:"rel"`
+	URL        string                 `json:"url"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// Relation types between work items.
+const (
+	// RelParent links a work item to its parent.
+	RelParent = "System.LinkTypes.Hierarchy-Reverse"
+	// RelChild links a work item to one of its children, the reverse of RelParent.
+	RelChild = "System.LinkTypes.Hierarchy-Forward"
+	// RelPredecessor links a work item to a predecessor, which must finish before it can start.
+	RelPredecessor = "System.LinkTypes.Dependency-Reverse"
+	// RelSuccessor links a work item to a successor, the reverse of RelPredecessor.
+	RelSuccessor = "System.LinkTypes.Dependency-Forward"
+	// RelRelated links a work item to a related one, both ways.
+	RelRelated = "System.LinkTypes.Related"
+	// RelDuplicateOf links a duplicate to the work item it duplicates.
+	RelDuplicateOf = "System.LinkTypes.Duplicate-Reverse"
+	// RelDuplicate links a work item to one of its duplicates, the reverse of RelDuplicateOf.
+	RelDuplicate = "System.LinkTypes.Duplicate-Forward"
+)
+
+// ParentID returns the ID of the work item's parent, or false when it has none.
+func (w WorkItem) ParentID() (int, bool) {
+	if ids := w.Linked(RelParent); len(ids) > 0 {
+		return ids[0], true
+	}
+	return 0, false
+}
+
+// Linked returns the IDs of the work items the work item links to with the relation type rel.
+func (w WorkItem) Linked(rel string) []int {
+	var ids []int
+	for _, r := range w.Relations {
+		if r.Rel != rel {
+			continue
+		}
+		if id, err := strconv.Atoi(r.URL[strings.LastIndex(r.URL, "/")+1:]); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Identity is an Azure DevOps identity reference.
+type Identity struct {
+	DisplayName string `json:"displayName"`
+	UniqueName  string `json:"uniqueName"`
+}
+
+// String returns the string value of field, or an empty string.
+func (w WorkItem) String(field string) string {
+	s, _ := w.Fields[field].(string)
+	return s
+}
+
+// Title returns the work item title.
+func (w WorkItem) Title() string { return w.String(FieldTitle) }
+
+// State returns the work item state.
+func (w WorkItem) State() string { return w.String(FieldState) }
+
+// Type returns the work item type.
+func (w WorkItem) Type() string { return w.String(FieldWorkItemType) }
+
+// Tags returns the tags of the work item.
+func (w WorkItem) Tags() []string {
+	var tags []string
+	for _, t := range strings.Split(w.String(FieldTags), ";") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// IterationPath returns the iteration path of the work item.
+func (w WorkItem) IterationPath() string { return w.String(FieldIterationPath) }
+
+// TargetDate returns the date the work item is due, or false when it has none.
+func (w WorkItem) TargetDate() (time.Time, bool) {
+	t, err := time.Parse(time.RFC3339Nano, w.String(FieldTargetDate))
+	return t, err == nil
+}
+
+// ChangedDate returns the time the work item was last changed.
+func (w WorkItem) ChangedDate() time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, w.String(FieldChangedDate))
+	return t
+}
+
+// CreatedDate returns the time the work item was created.
+func (w WorkItem) CreatedDate() time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, w.String(FieldCreatedDate))
+	return t
+}
+
+// StateChangeDate returns the time the work item entered its state, or the time it was last changed when
+// its process does not record state changes.
+func (w WorkItem) StateChangeDate() time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, w.String(FieldStateChangeDate)); err == nil {
+		return t
+	}
+	return w.ChangedDate()
+}
+
+// AssignedTo returns the identity the work item is assigned to, or nil when unassigned.
+func (w WorkItem) AssignedTo() *Identity {
+	m, ok := w.Fields[FieldAssignedTo].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	id := &Identity{}
+	id.DisplayName, _ = m["displayName"].(string)
+	id.UniqueName, _ = m["uniqueName"].(string)
+	return id
+}
+
+// Link returns the browser URL of the work item, derived from its API URL.
+func (w WorkItem) Link() string {
+	if i := strings.Index(strings.ToLower(w.URL), "/_apis/wit/workitems/"); i >= 0 {
+		return fmt.Sprintf("%s/_workitems/edit/%d", w.URL[:i], w.ID)
+	}
+	return ""
+}
+
+// WebURL returns the browser URL of the work item.
+func (c *Client) WebURL(project string, id int) string {
+	return fmt.Sprintf("%s%s/_workitems/edit/%d", c.OrgURL, projectPath(project), id)
+}
+
+// MaxQueryResults is the most work items a WIQL query returns. ADO fails a query selecting more unless it is
+// limited with $top, as QueryTop does.
+const MaxQueryResults = 20000
+
+// Query runs a WIQL query scoped to project and returns the IDs of the matching work items. Date
+// comparisons in the query use the full time, not just the day.
+func (c *Client) Query(ctx context.Context, project, wiql string) ([]int, error) {
+	return c.query(ctx, project, wiql, 0)
+}
+
+// QueryTop runs a WIQL query like Query, returning the IDs of the first top of the matching work items in
+// the order of the query. Unlike Query, it does not fail when the query selects more than
+// MaxQueryResults items, and top is at most that.
+func (c *Client) QueryTop(ctx context.Context, project, wiql string, top int) ([]int, error) {
+	if top <= 0 || top > MaxQueryResults {
+		return nil, fmt.Errorf("query top %d is not between 1 and %d", top, MaxQueryResults)
+	}
+	return c.query(ctx, project, wiql, top)
+}
+
+// query runs a WIQL query, limited to top results when top is positive.
+func (c *Client) query(ctx context.Context, project, wiql string, top int) ([]int, error) {
+	var resp struct {
+		WorkItems []struct {
+			ID int `json:"id"`
+		} `json:"workItems"`
+	}
+	path := projectPath(project) + "/_apis/wit/wiql?timePrecision=true"
+	if top > 0 {
+		path += "&$top=" + strconv.Itoa(top)
+	}
+	body := map[string]string{"query": wiql}
+	if err := c.do(ctx, http.MethodPost, path, "application/json", body, &resp); err != nil {
+		return nil, err
+	}
+	ids := make([]int, 0, len(resp.WorkItems))
+	for _, wi := range resp.WorkItems {
+		ids = append(ids, wi.ID)
+	}
+	return ids, nil
+}
+
+// GetWorkItems returns the work items with the given IDs, including their relations.
+func (c *Client) GetWorkItems(ctx context.Context, ids []int) ([]WorkItem, error) {
+	items := make([]WorkItem, 0, len(ids))
+	for start := 0; start < len(ids); start += maxBatch {
+		end := start + maxBatch
+		if end > len(ids) {
+			end = len(ids)
+		}
+		s := make([]string, 0, end-start)
+		for _, id := range ids[start:end] {
+			s = append(s, strconv.Itoa(id))
+		}
+		var resp struct {
+			Value []WorkItem `json:"value"`
+		}
+		path := "/_apis/wit/workitems?ids=" + strings.Join(s, ",") + "&$expand=relations&errorPolicy=omit"
+		if err := c.do(ctx, http.MethodGet, path, "", nil, &resp); err != nil {
+			return nil, err
+		}
+		for _, wi := range resp.Value {
+			// Deleted or inaccessible items are returned as null entries with errorPolicy=omit.
+			if wi.ID != 0 {
+				items = append(items, wi)
+			}
+		}
+	}
+	return items, nil
+}
+
+// GetWorkItem returns a single work item including its relations.
+func (c *Client) GetWorkItem(ctx context.Context, id int) (*WorkItem, error) {
+	var wi WorkItem
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/_apis/wit/workitems/%d?$expand=relations", id), "", nil, &wi); err != nil {
+		return nil, err
+	}
+	return &wi, nil
+}
+
+// PatchOperation is a JSON patch operation applied to a work item.
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// SetField returns a patch operation that sets field to value.
+func SetField(field string, value interface{}) PatchOperation {
+	return PatchOperation{Op: "add", Path: "/fields/" + field, Value: value}
+}
+
+// TestRev returns a patch operation that fails the patch unless the work item is still at revision rev, so
+// edits made since it was read are not overwritten. IsConflict reports the failure.
+func TestRev(rev int) PatchOperation {
+	return PatchOperation{Op: "test", Path: "/rev", Value: rev}
+}
+
+// IsConflict reports whether err is the rejection of a patch whose work item changed since it was read.
+func IsConflict(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && (e.StatusCode == http.StatusConflict || e.StatusCode == http.StatusPreconditionFailed)
+}
+
+// RemoveField returns a patch operation that clears field.
+func RemoveField(field string) PatchOperation {
+	return PatchOperation{Op: "remove", Path: "/fields/" + field}
+}
+
+// ValidateUpdate checks that ops could be applied to the work item, without saving them. It fails as
+// UpdateWorkItem would, so it shows whether the credentials may edit the item.
+func (c *Client) ValidateUpdate(ctx context.Context, id int, ops []PatchOperation) error {
+	return c.do(ctx, http.MethodPatch, fmt.Sprintf("/_apis/wit/workitems/%d?validateOnly=true", id), "application/json-patch+json", ops, nil)
+}
+
+// CreateWorkItem creates a work item of type typ in project with the fields set by ops and returns it.
+func (c *Client) CreateWorkItem(ctx context.Context, project, typ string, ops []PatchOperation) (*WorkItem, error) {
+	var wi WorkItem
+	path := fmt.Sprintf("%s/_apis/wit/workitems/$%s", projectPath(project), url.PathEscape(typ))
+	if err := c.do(ctx, http.MethodPost, path, "application/json-patch+json", ops, &wi); err != nil {
+		return nil, err
+	}
+	return &wi, nil
+}
+
+// UpdateWorkItem applies ops to the work item and returns the updated item.
+func (c *Client) UpdateWorkItem(ctx context.Context, id int, ops []PatchOperation) (*WorkItem, error) {
+	var wi WorkItem
+	if err := c.do(ctx, http.MethodPatch, fmt.Sprintf("/_apis/wit/workitems/%d", id), "application/json-patch+json", ops, &wi); err != nil {
+		return nil, err
+	}
+	return &wi, nil
+}
@@ -0,0 +1,188 @@
+// Package analytics exports the work item samples recorded by sync cycles as tables for burndown, cycle
+// time and throughput analysis in BI tools, read from the store alone without calling either API.
+package analytics
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// Formats of an export.
+const (
+	FormatCSV     = "csv"
+	FormatParquet = "parquet"
+)
+
+// kind is the type of the values of a column.
+type kind int
+
+const (
+	kindString kind = iota
+	kindInt
+	kindFloat
+	kindBool
+	kindTime
+)
+
+// column is a column of the exported table.
+type column struct {
+	name  string
+	kind  kind
+	value func(store.Sample) interface{}
+}
+
+// columns are those of the exported table, one row per sample. Values are nil when unset.
+var columns = []column{
+	{"snapshot_time", kindTime, func(s store.Sample) interface{} { return timeValue(s.Time) }},
+	{"pair", kindString, func(s store.Sample) interface{} { return s.Pair }},
+	{"cycle_id", kindString, func(s store.Sample) interface{} { return s.CycleID }},
+	{"ado_id", kindInt, func(s store.Sample) interface{} { return int64(s.ADOID) }},
+	{"rev", kindInt, func(s store.Sample) interface{} { return int64(s.Rev) }},
+	{"type", kindString, func(s store.Sample) interface{} { return s.Type }},
+	{"state", kindString, func(s store.Sample) interface{} { return s.State }},
+	{"done", kindBool, func(s store.Sample) interface{} { return s.Done }},
+	{"iteration", kindString, func(s store.Sample) interface{} { return s.Iteration }},
+	{"area", kindString, func(s store.Sample) interface{} { return s.Area }},
+	{"points", kindFloat, func(s store.Sample) interface{} { return floatValue(s.Points) }},
+	{"remaining", kindFloat, func(s store.Sample) interface{} { return floatValue(s.Remaining) }},
+	{"created", kindTime, func(s store.Sample) interface{} { return timeValue(s.Created) }},
+	{"changed", kindTime, func(s store.Sample) interface{} { return timeValue(s.Changed) }},
+	{"state_changed", kindTime, func(s store.Sample) interface{} { return timeValue(s.StateChanged) }},
+}
+
+func timeValue(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC()
+}
+
+func floatValue(f *float64) interface{} {
+	if f == nil {
+		return nil
+	}
+	return *f
+}
+
+// FormatFor returns the format of an export written to path: parquet for .parquet files and CSV otherwise.
+func FormatFor(path string) string {
+	if strings.HasSuffix(strings.ToLower(path), ".parquet") {
+		return FormatParquet
+	}
+	return FormatCSV
+}
+
+// itemKey identifies a work item across pairs.
+type itemKey struct {
+	pair string
+	id   int
+}
+
+// Snapshots returns the rows to export for samples, oldest first. A sample repeating the revision of the
+// previous sample of its item, taken again by a cycle that failed before recording the sync, is dropped.
+//
+// With daily set, it returns a row per item and day instead, from the day of the first sample of the item
+// to the day of until, holding the item as it was at the end of the day. The snapshot time of a row is the
+// start of its day in UTC.
+func Snapshots(samples []store.Sample, daily bool, until time.Time) []store.Sample {
+	sorted := append([]store.Sample(nil), samples...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+	last := map[itemKey]int{}
+	var rows []store.Sample
+	for _, s := range sorted {
+		k := itemKey{s.Pair, s.ADOID}
+		if rev, ok := last[k]; ok && rev == s.Rev {
+			continue
+		}
+		last[k] = s.Rev
+		rows = append(rows, s)
+	}
+	if !daily || len(rows) == 0 {
+		return rows
+	}
+
+	day := func(t time.Time) time.Time {
+		t = t.UTC()
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	current := map[itemKey]store.Sample{}
+	var keys []itemKey
+	var out []store.Sample
+	next := 0
+	for d := day(rows[0].Time); !d.After(day(until)); d = d.AddDate(0, 0, 1) {
+		end := d.AddDate(0, 0, 1)
+		for ; next < len(rows) && rows[next].Time.Before(end); next++ {
+			k := itemKey{rows[next].Pair, rows[next].ADOID}
+			if _, ok := current[k]; !ok {
+				keys = append(keys, k)
+			}
+			current[k] = rows[next]
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].pair != keys[j].pair {
+				return keys[i].pair < keys[j].pair
+			}
+			return keys[i].id < keys[j].id
+		})
+		for _, k := range keys {
+			s := current[k]
+			s.Time = d
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// Write writes rows to w in the given format.
+func Write(w io.Writer, rows []store.Sample, format string) error {
+	switch format {
+	case FormatCSV:
+		return writeCSV(w, rows)
+	case FormatParquet:
+		return writeParquet(w, rows)
+	default:
+		return fmt.Errorf("analytics: unknown format %q", format)
+	}
+}
+
+// writeCSV writes rows as CSV with a header row. Times are RFC 3339 and unset values empty.
+func writeCSV(w io.Writer, rows []store.Sample) error {
+	cw := csv.NewWriter(w)
+	record := make([]string, len(columns))
+	for i, c := range columns {
+		record[i] = c.name
+	}
+	if err := cw.Write(record); err != nil {
+		return err
+	}
+	for _, s := range rows {
+		for i, c := range columns {
+			switch v := c.value(s).(type) {
+			case nil:
+				record[i] = ""
+			case string:
+				record[i] = v
+			case int64:
+				record[i] = strconv.FormatInt(v, 10)
+			case float64:
+				record[i] = strconv.FormatFloat(v, 'f', -1, 64)
+			case bool:
+				record[i] = strconv.FormatBool(v)
+			case time.Time:
+				record[i] = v.Format(time.RFC3339)
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,295 @@
+package analytics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// The parquet files written are the simplest the format allows: a single row group of optional, flat
+// columns, each stored as one uncompressed data page with PLAIN encoded values and RLE definition levels.
+// Their metadata is encoded with the Thrift compact protocol by the small encoder below.
+
+// parquetMagic starts and ends a parquet file.
+const parquetMagic = "PAR1"
+
+// Parquet physical types, converted types, repetitions and encodings.
+const (
+	parquetBoolean   = 0
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	convertedUTF8            = 0
+	convertedTimestampMillis = 9
+
+	repetitionOptional = 1
+
+	encodingPlain = 0
+	encodingRLE   = 3
+)
+
+// physical returns the physical type of the column and its converted type, negative when it has none.
+func (c column) physical() (typ, converted int32) {
+	switch c.kind {
+	case kindString:
+		return parquetByteArray, convertedUTF8
+	case kindInt:
+		return parquetInt64, -1
+	case kindFloat:
+		return parquetDouble, -1
+	case kindBool:
+		return parquetBoolean, -1
+	default:
+		return parquetInt64, convertedTimestampMillis
+	}
+}
+
+// chunk is the data page of a column and where it starts in the file.
+type chunk struct {
+	offset int64
+	size   int64
+}
+
+// writeParquet writes rows as a parquet file.
+func writeParquet(w io.Writer, rows []store.Sample) error {
+	var file bytes.Buffer
+	file.WriteString(parquetMagic)
+	chunks := make([]chunk, len(columns))
+	var total int64
+	for i, c := range columns {
+		data := columnPage(c, rows)
+		var h compact
+		h.i32(1, 0) // DATA_PAGE
+		h.i32(2, int32(len(data)))
+		h.i32(3, int32(len(data)))
+		h.begin(5)
+		h.i32(1, int32(len(rows)))
+		h.i32(2, encodingPlain)
+		h.i32(3, encodingRLE)
+		h.i32(4, encodingRLE)
+		h.end()
+		h.stop()
+		chunks[i] = chunk{offset: int64(file.Len()), size: int64(h.buf.Len() + len(data))}
+		total += chunks[i].size
+		file.Write(h.buf.Bytes())
+		file.Write(data)
+	}
+
+	var m compact
+	m.i32(1, 1)
+	m.list(2, len(columns)+1, compactStruct)
+	m.elem()
+	m.str(4, "schema")
+	m.i32(5, int32(len(columns)))
+	m.end()
+	for _, c := range columns {
+		typ, converted := c.physical()
+		m.elem()
+		m.i32(1, typ)
+		m.i32(3, repetitionOptional)
+		m.str(4, c.name)
+		if converted >= 0 {
+			m.i32(6, converted)
+		}
+		m.end()
+	}
+	m.i64(3, int64(len(rows)))
+	m.list(4, 1, compactStruct)
+	m.elem()
+	m.list(1, len(columns), compactStruct)
+	for i, c := range columns {
+		typ, _ := c.physical()
+		m.elem()
+		m.i64(2, chunks[i].offset)
+		m.begin(3)
+		m.i32(1, typ)
+		m.i32List(2, encodingPlain, encodingRLE)
+		m.list(3, 1, compactBinary)
+		m.raw(c.name)
+		m.i32(4, 0) // UNCOMPRESSED
+		m.i64(5, int64(len(rows)))
+		m.i64(6, chunks[i].size)
+		m.i64(7, chunks[i].size)
+		m.i64(9, chunks[i].offset)
+		m.end()
+		m.end()
+	}
+	m.i64(2, total)
+	m.i64(3, int64(len(rows)))
+	m.end()
+	m.str(6, "ado-asana-sync")
+	m.stop()
+
+	file.Write(m.buf.Bytes())
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(m.buf.Len()))
+	file.Write(size[:])
+	file.WriteString(parquetMagic)
+	_, err := w.Write(file.Bytes())
+	return err
+}
+
+// columnPage encodes the values of column c in rows as the body of a data page: the length prefixed
+// definition levels, one bit per row set when the value is present, then the present values.
+func columnPage(c column, rows []store.Sample) []byte {
+	levels := make([]byte, (len(rows)+7)/8)
+	var values bytes.Buffer
+	var bools []bool
+	for i, s := range rows {
+		v := c.value(s)
+		if v == nil {
+			continue
+		}
+		levels[i/8] |= 1 << (i % 8)
+		var b [8]byte
+		switch v := v.(type) {
+		case string:
+			binary.LittleEndian.PutUint32(b[:4], uint32(len(v)))
+			values.Write(b[:4])
+			values.WriteString(v)
+		case int64:
+			binary.LittleEndian.PutUint64(b[:], uint64(v))
+			values.Write(b[:])
+		case float64:
+			binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+			values.Write(b[:])
+		case bool:
+			bools = append(bools, v)
+		case time.Time:
+			binary.LittleEndian.PutUint64(b[:], uint64(v.UnixMilli()))
+			values.Write(b[:])
+		}
+	}
+	if c.kind == kindBool {
+		packed := make([]byte, (len(bools)+7)/8)
+		for i, v := range bools {
+			if v {
+				packed[i/8] |= 1 << (i % 8)
+			}
+		}
+		values.Write(packed)
+	}
+
+	// The levels are a single bit-packed run of groups of eight, preceded by its header.
+	var rle []byte
+	rle = binary.AppendUvarint(rle, uint64(len(levels))<<1|1)
+	rle = append(rle, levels...)
+	var page bytes.Buffer
+	var n [4]byte
+	binary.LittleEndian.PutUint32(n[:], uint32(len(rle)))
+	page.Write(n[:])
+	page.Write(rle)
+	page.Write(values.Bytes())
+	return page.Bytes()
+}
+
+// compact encodes Thrift structs with the compact protocol. Fields must be written in increasing order of
+// their IDs within each struct.
+type compact struct {
+	buf bytes.Buffer
+	// last holds the ID of the last field written in each struct being written, innermost last.
+	last []int16
+}
+
+// Compact protocol types.
+const (
+	compactI32    = 5
+	compactI64    = 6
+	compactBinary = 8
+	compactList   = 9
+	compactStruct = 12
+)
+
+func (c *compact) field(id int16, typ byte) {
+	if len(c.last) == 0 {
+		c.last = []int16{0}
+	}
+	top := &c.last[len(c.last)-1]
+	if delta := id - *top; delta > 0 && delta <= 15 {
+		c.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		c.buf.WriteByte(typ)
+		c.varint(int64(id))
+	}
+	*top = id
+}
+
+// varint writes v zigzag encoded.
+func (c *compact) varint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	c.buf.Write(b[:binary.PutUvarint(b[:], uint64(v<<1^v>>63))])
+}
+
+func (c *compact) i32(id int16, v int32) {
+	c.field(id, compactI32)
+	c.varint(int64(v))
+}
+
+func (c *compact) i64(id int16, v int64) {
+	c.field(id, compactI64)
+	c.varint(v)
+}
+
+func (c *compact) str(id int16, s string) {
+	c.field(id, compactBinary)
+	c.raw(s)
+}
+
+// raw writes s without a field header, as an element of a list of strings.
+func (c *compact) raw(s string) {
+	var b [binary.MaxVarintLen64]byte
+	c.buf.Write(b[:binary.PutUvarint(b[:], uint64(len(s)))])
+	c.buf.WriteString(s)
+}
+
+// list starts a list field of n elements of the given type.
+func (c *compact) list(id int16, n int, elem byte) {
+	c.field(id, compactList)
+	c.listHeader(n, elem)
+}
+
+func (c *compact) listHeader(n int, elem byte) {
+	if n < 15 {
+		c.buf.WriteByte(byte(n)<<4 | elem)
+		return
+	}
+	c.buf.WriteByte(0xf0 | elem)
+	var b [binary.MaxVarintLen64]byte
+	c.buf.Write(b[:binary.PutUvarint(b[:], uint64(n))])
+}
+
+func (c *compact) i32List(id int16, vs ...int32) {
+	c.field(id, compactList)
+	c.listHeader(len(vs), compactI32)
+	for _, v := range vs {
+		c.varint(int64(v))
+	}
+}
+
+// begin starts a struct field, and elem a struct element of a list; end finishes either.
+func (c *compact) begin(id int16) {
+	c.field(id, compactStruct)
+	c.last = append(c.last, 0)
+}
+
+func (c *compact) elem() {
+	if len(c.last) == 0 {
+		c.last = []int16{0}
+	}
+	c.last = append(c.last, 0)
+}
+
+func (c *compact) end() {
+	c.buf.WriteByte(0)
+	c.last = c.last[:len(c.last)-1]
+}
+
+// stop finishes the top level struct.
+func (c *compact) stop() {
+	c.buf.WriteByte(0)
+}
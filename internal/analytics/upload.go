@@ -0,0 +1,75 @@
+package analytics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// azureVersion is the Blob service REST API version requested.
+const azureVersion = "2021-08-06"
+
+// ContentType returns the media type of an export in the given format.
+func ContentType(format string) string {
+	if format == FormatParquet {
+		return "application/vnd.apache.parquet"
+	}
+	return "text/csv"
+}
+
+// Upload writes body to location, which is one of
+//
+//	path/to/file.csv                                           a local file
+//	s3://bucket/path/to/file.parquet?region=eu-west-1           an S3 object, as for s3 stores
+//	https://account.blob.core.windows.net/container/file.csv   an Azure blob
+//
+// Azure blobs are written with the shared access signature in the query of the URL, or in
+// AZURE_STORAGE_SAS_TOKEN when the URL has none. The signature must allow creating and writing blobs.
+func Upload(ctx context.Context, location, contentType string, body []byte) error {
+	switch {
+	case strings.HasPrefix(location, "s3://"):
+		return store.PutS3(ctx, location, contentType, body)
+	case strings.HasPrefix(location, "https://") || strings.HasPrefix(location, "http://"):
+		return putBlob(ctx, location, contentType, body)
+	default:
+		return os.WriteFile(location, body, 0o644)
+	}
+}
+
+// putBlob uploads body as a block blob.
+func putBlob(ctx context.Context, location, contentType string, body []byte) error {
+	u, err := url.Parse(location)
+	if err != nil {
+		return fmt.Errorf("analytics: invalid blob location: %w", err)
+	}
+	if u.RawQuery == "" {
+		u.RawQuery = strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?")
+	}
+	if u.RawQuery == "" {
+		return fmt.Errorf("analytics: blob location %s%s has no shared access signature and AZURE_STORAGE_SAS_TOKEN is unset", u.Host, u.Path)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("x-ms-blob-type", "BlockBlob")
+	req.Header.Set("x-ms-version", azureVersion)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("analytics: blob put %s%s: %d %s", u.Host, u.Path, resp.StatusCode, msg)
+	}
+	return nil
+}
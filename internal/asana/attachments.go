@@ -0,0 +1,103 @@
+package asana
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrTooLarge is returned when a download exceeds the permitted size.
+var ErrTooLarge = errors.New("asana: attachment exceeds size limit")
+
+// Attachment is a file attached to a task.
+type Attachment struct {
+	GID         string `json:"gid"`
+	Name        string `json:"name"`
+	Size        int64  `json:"size"`
+	DownloadURL string `json:"download_url"`
+}
+
+// Attachments returns the attachments of the task.
+func (c *Client) Attachments(ctx context.Context, taskGID string) ([]Attachment, error) {
+	var atts []Attachment
+	offset := ""
+	for {
+		q := url.Values{"parent": {taskGID}, "opt_fields": {"name,size,download_url"}, "limit": {"100"}}
+		if offset != "" {
+			q.Set("offset", offset)
+		}
+		var page []Attachment
+		next, err := c.do(ctx, http.MethodGet, "/attachments?"+q.Encode(), nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		atts = append(atts, page...)
+		if next == "" {
+			return atts, nil
+		}
+		offset = next
+	}
+}
+
+// DownloadAttachment returns the content of a, failing with ErrTooLarge when it is larger than max bytes.
+// Download URLs are pre-signed, so no credentials are sent.
+func (c *Client) DownloadAttachment(ctx context.Context, a Attachment, max int64) ([]byte, error) {
+	if a.Size > max {
+		return nil, ErrTooLarge
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.DownloadURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &Error{StatusCode: resp.StatusCode, Message: readMessage(resp.Body)}
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > max {
+		return nil, ErrTooLarge
+	}
+	return b, nil
+}
+
+// UploadAttachment attaches data to the task as a file named name.
+func (c *Client) UploadAttachment(ctx context.Context, taskGID, name string, data []byte) (*Attachment, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	if err := w.WriteField("parent", taskGID); err != nil {
+		return nil, err
+	}
+	part, err := w.CreateFormFile("file", name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := part.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(c.BaseURL, "/")+"/attachments", &buf)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	var a Attachment
+	if _, err := c.send(req, &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
@@ -0,0 +1,242 @@
+package asana
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MaxBatchActions is the number of actions the batch API accepts in a single request.
+const MaxBatchActions = 10
+
+// DefaultBatchWindow is how long a Batcher waits for more calls to join a batch.
+const DefaultBatchWindow = 20 * time.Millisecond
+
+// BatchAction is a request sent as part of a batch.
+type BatchAction struct {
+	// RelativePath is the path of the request relative to the API base URL, for example /tasks/123.
+	RelativePath string        `json:"relative_path"`
+	Method       string        `json:"method"`
+	Data         interface{}   `json:"data,omitempty"`
+	Options      *BatchOptions `json:"options,omitempty"`
+}
+
+// BatchOptions are the options of a batch action.
+type BatchOptions struct {
+	// Fields are the fields of the response, as opt_fields selects them for single requests.
+	Fields []string `json:"fields,omitempty"`
+}
+
+// BatchResult is the response to a batch action.
+type BatchResult struct {
+	StatusCode int             `json:"status_code"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// Err returns the error of a failed action, or nil when it succeeded.
+func (r BatchResult) Err() error {
+	if r.StatusCode >= 200 && r.StatusCode <= 299 {
+		return nil
+	}
+	return &Error{StatusCode: r.StatusCode, Message: readMessage(bytes.NewReader(r.Body))}
+}
+
+// Decode decodes the data of the response to a successful action into out.
+func (r BatchResult) Decode(out interface{}) error {
+	if out == nil || len(r.Body) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(r.Body, &envelope{Data: out}); err != nil {
+		return fmt.Errorf("asana: decoding batch response: %w", err)
+	}
+	return nil
+}
+
+// Batch sends actions to the batch API, MaxBatchActions at a time, and returns their results in order. It
+// returns an error only when a batch as a whole failed; the failures of single actions are in their results.
+func (c *Client) Batch(ctx context.Context, actions []BatchAction) ([]BatchResult, error) {
+	results := make([]BatchResult, 0, len(actions))
+	for start := 0; start < len(actions); start += MaxBatchActions {
+		end := start + MaxBatchActions
+		if end > len(actions) {
+			end = len(actions)
+		}
+		var page []BatchResult
+		body := map[string]interface{}{"actions": actions[start:end]}
+		if _, err := c.do(ctx, http.MethodPost, "/batch", body, &page); err != nil {
+			return nil, err
+		}
+		if len(page) != end-start {
+			return nil, fmt.Errorf("asana: batch of %d actions returned %d results", end-start, len(page))
+		}
+		results = append(results, page...)
+	}
+	return results, nil
+}
+
+// Batcher is a Client that groups the task reads and writes made concurrently, such as by the workers of a
+// sync cycle, into requests to the batch API. Calls made within Window of each other share a batch of up to
+// MaxBatchActions. An action that failed alone returns its own error, while one that was rate limited is sent
+// again on its own, so it waits and is retried like any other call. Should a batch fail as a whole, its
+// calls are sent singly, and once the batch API is rejected as unsupported calls are no longer batched.
+type Batcher struct {
+	*Client
+	// Window is how long calls wait for more calls to join their batch.
+	Window time.Duration
+
+	mu      sync.Mutex
+	pending []*batchCall
+	timer   *time.Timer
+	off     atomic.Bool
+}
+
+// NewBatcher returns a Batcher sending the calls of c in batches.
+func NewBatcher(c *Client) *Batcher {
+	return &Batcher{Client: c, Window: DefaultBatchWindow}
+}
+
+// batchCall is a call waiting for its batch.
+type batchCall struct {
+	ctx    context.Context
+	action BatchAction
+	out    interface{}
+	// single sends the call on its own.
+	single func(ctx context.Context) error
+	done   chan error
+}
+
+// taskFieldList are the task fields requested by batch actions.
+var taskFieldList = strings.Split(taskFields, ",")
+
+// GetTask returns the task with the given GID, fetched as part of a batch.
+func (b *Batcher) GetTask(ctx context.Context, gid string) (*Task, error) {
+	var t Task
+	if err := b.call(ctx, http.MethodGet, "/tasks/"+gid, nil, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// CreateTask creates a task as part of a batch and returns it.
+func (b *Batcher) CreateTask(ctx context.Context, req TaskRequest) (*Task, error) {
+	var t Task
+	if err := b.call(ctx, http.MethodPost, "/tasks", req, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// UpdateTask updates the task with the given GID as part of a batch and returns the updated task.
+func (b *Batcher) UpdateTask(ctx context.Context, gid string, req TaskRequest) (*Task, error) {
+	var t Task
+	if err := b.call(ctx, http.MethodPut, "/tasks/"+gid, req, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// call queues a task request for the next batch and waits for its result, decoded into out.
+func (b *Batcher) call(ctx context.Context, method, path string, data, out interface{}) error {
+	single := func(ctx context.Context) error {
+		_, err := b.do(ctx, method, path+"?opt_fields="+taskFields, data, out)
+		return err
+	}
+	if b.off.Load() {
+		return single(ctx)
+	}
+	c := &batchCall{
+		ctx:    ctx,
+		action: BatchAction{RelativePath: path, Method: strings.ToLower(method), Data: data, Options: &BatchOptions{Fields: taskFieldList}},
+		out:    out,
+		single: single,
+		done:   make(chan error, 1),
+	}
+	b.mu.Lock()
+	b.pending = append(b.pending, c)
+	switch {
+	case len(b.pending) >= MaxBatchActions:
+		calls := b.take()
+		b.mu.Unlock()
+		go b.send(calls)
+	case b.timer == nil:
+		b.timer = time.AfterFunc(b.Window, b.flush)
+		b.mu.Unlock()
+	default:
+		b.mu.Unlock()
+	}
+	select {
+	case err := <-c.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// take returns the pending calls and stops the timer of their batch. The caller must hold b.mu.
+func (b *Batcher) take() []*batchCall {
+	calls := b.pending
+	b.pending = nil
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	return calls
+}
+
+// flush sends the pending calls once the window of their batch passed.
+func (b *Batcher) flush() {
+	b.mu.Lock()
+	calls := b.take()
+	b.mu.Unlock()
+	b.send(calls)
+}
+
+// send sends calls as a batch and hands each its result.
+func (b *Batcher) send(calls []*batchCall) {
+	switch len(calls) {
+	case 0:
+		return
+	case 1:
+		calls[0].done <- calls[0].single(calls[0].ctx)
+		return
+	}
+	actions := make([]BatchAction, len(calls))
+	for i, c := range calls {
+		actions[i] = c.action
+	}
+	// The batch carries the calls of several callers, so it is not cancelled with the first of them.
+	results, err := b.Batch(context.WithoutCancel(calls[0].ctx), actions)
+	if err != nil {
+		if unsupported(err) {
+			b.off.Store(true)
+		}
+		for _, c := range calls {
+			go func(c *batchCall) { c.done <- c.single(c.ctx) }(c)
+		}
+		return
+	}
+	for i, c := range calls {
+		switch r := results[i]; {
+		case r.StatusCode == http.StatusTooManyRequests:
+			go func(c *batchCall) { c.done <- c.single(c.ctx) }(c)
+		case r.Err() != nil:
+			c.done <- r.Err()
+		default:
+			c.done <- r.Decode(c.out)
+		}
+	}
+}
+
+// unsupported reports whether err rejects the batch API itself rather than the batch.
+func unsupported(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && (e.StatusCode == http.StatusNotFound || e.StatusCode == http.StatusMethodNotAllowed ||
+		e.StatusCode == http.StatusNotImplemented)
+}
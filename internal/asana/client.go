@@ -0,0 +1,132 @@
+// Package asana provides a minimal client for the Asana REST API.
+package asana
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultBaseURL is the base URL of the Asana API.
+const DefaultBaseURL = "https://app.asana.com/api/1.0"
+
+// Client talks to the Asana API using a bearer token.
+type Client struct {
+	// BaseURL is the API base URL, defaulting to DefaultBaseURL.
+	BaseURL string
+	// HTTP is the underlying HTTP client used for requests.
+	HTTP *http.Client
+	// Tokens, when set, supplies the access token of every request instead of the token the client was
+	// created with, for example an OAuthSource.
+	Tokens TokenSource
+
+	token string
+}
+
+// TokenSource supplies access tokens.
+type TokenSource interface {
+	// Token returns a valid access token.
+	Token(ctx context.Context) (string, error)
+}
+
+// NewClient returns a Client authenticating with the given access token.
+func NewClient(token string) *Client {
+	return &Client{
+		BaseURL: DefaultBaseURL,
+		HTTP:    http.DefaultClient,
+		token:   token,
+	}
+}
+
+// Error is returned when the Asana API responds with a non-success status.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("asana: %d %s", e.StatusCode, e.Message)
+}
+
+// envelope wraps every Asana request and response body.
+type envelope struct {
+	Data     interface{} `json:"data"`
+	NextPage *struct {
+		Offset string `json:"offset"`
+	} `json:"next_page,omitempty"`
+}
+
+// do performs a request against path and decodes the response data into out.
+// It returns the offset of the next page for paginated endpoints.
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) (string, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(envelope{Data: body})
+		if err != nil {
+			return "", fmt.Errorf("asana: encoding request: %w", err)
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(c.BaseURL, "/")+path, r)
+	if err != nil {
+		return "", err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.send(req, out)
+}
+
+// send authenticates and performs req, decoding the response data into out.
+func (c *Client) send(req *http.Request, out interface{}) (string, error) {
+	token := c.token
+	if c.Tokens != nil {
+		var err error
+		if token, err = c.Tokens.Token(req.Context()); err != nil {
+			return "", fmt.Errorf("asana: %w", err)
+		}
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", &Error{StatusCode: resp.StatusCode, Message: readMessage(resp.Body)}
+	}
+	env := envelope{Data: out}
+	if err := json.NewDecoder(resp.Body).Decode(&env); err != nil && err != io.EOF {
+		return "", fmt.Errorf("asana: decoding response: %w", err)
+	}
+	if env.NextPage != nil {
+		return env.NextPage.Offset, nil
+	}
+	return "", nil
+}
+
+// readMessage extracts the error messages from an Asana error response body.
+func readMessage(r io.Reader) string {
+	b, _ := io.ReadAll(io.LimitReader(r, 64<<10))
+	var e struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if json.Unmarshal(b, &e) == nil && len(e.Errors) > 0 {
+		msgs := make([]string, 0, len(e.Errors))
+		for _, m := range e.Errors {
+			msgs = append(msgs, m.Message)
+		}
+		return strings.Join(msgs, "; ")
+	}
+	return strings.TrimSpace(string(b))
+}
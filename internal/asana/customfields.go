@@ -0,0 +1,133 @@
+package asana
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Custom field types.
+const (
+	CustomFieldText   = "text"
+	CustomFieldNumber = "number"
+	CustomFieldEnum   = "enum"
+	CustomFieldDate   = "date"
+)
+
+// EnumOption is an option of an enum custom field.
+type EnumOption struct {
+	GID  string `json:"gid"`
+	Name string `json:"name"`
+}
+
+// DateValue is the value of a date custom field.
+type DateValue struct {
+	Date string `json:"date"`
+}
+
+// CustomField is a custom field definition, or a custom field value when attached to a task.
+type CustomField struct {
+	GID             string       `json:"gid"`
+	Name            string       `json:"name"`
+	ResourceSubtype string       `json:"resource_subtype"`
+	EnumOptions     []EnumOption `json:"enum_options,omitempty"`
+	TextValue       *string      `json:"text_value,omitempty"`
+	NumberValue     *float64     `json:"number_value,omitempty"`
+	EnumValue       *EnumOption  `json:"enum_value,omitempty"`
+	DateValue       *DateValue   `json:"date_value,omitempty"`
+
+	// RepresentationType is how a number field is shown, for example duration for fields holding minutes.
+	RepresentationType string `json:"representation_type,omitempty"`
+}
+
+// ProjectCustomFields returns the custom fields enabled on the project.
+func (c *Client) ProjectCustomFields(ctx context.Context, projectGID string) ([]CustomField, error) {
+	var fields []CustomField
+	offset := ""
+	for {
+		q := url.Values{
+			"opt_fields": {"custom_field.name,custom_field.resource_subtype,custom_field.representation_type,custom_field.enum_options.name"},
+			"limit":      {"100"},
+		}
+		if offset != "" {
+			q.Set("offset", offset)
+		}
+		var page []struct {
+			CustomField CustomField `json:"custom_field"`
+		}
+		next, err := c.do(ctx, http.MethodGet, "/projects/"+projectGID+"/custom_field_settings?"+q.Encode(), nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range page {
+			fields = append(fields, s.CustomField)
+		}
+		if next == "" {
+			return fields, nil
+		}
+		offset = next
+	}
+}
+
+// WorkspaceCustomFields returns the custom fields of the workspace, including those enabled on no project.
+func (c *Client) WorkspaceCustomFields(ctx context.Context, workspaceGID string) ([]CustomField, error) {
+	var fields []CustomField
+	offset := ""
+	for {
+		q := url.Values{
+			"opt_fields": {"name,resource_subtype,representation_type,enum_options.name"},
+			"limit":      {"100"},
+		}
+		if offset != "" {
+			q.Set("offset", offset)
+		}
+		var page []CustomField
+		next, err := c.do(ctx, http.MethodGet, "/workspaces/"+workspaceGID+"/custom_fields?"+q.Encode(), nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, page...)
+		if next == "" {
+			return fields, nil
+		}
+		offset = next
+	}
+}
+
+// CreateCustomField creates a custom field of the given type in the workspace. Enum fields are given the
+// options, and number fields two decimal places.
+func (c *Client) CreateCustomField(ctx context.Context, workspaceGID, name, subtype string, options []string) (*CustomField, error) {
+	body := map[string]interface{}{"workspace": workspaceGID, "name": name, "resource_subtype": subtype}
+	switch subtype {
+	case CustomFieldEnum:
+		opts := make([]map[string]string, 0, len(options))
+		for _, o := range options {
+			opts = append(opts, map[string]string{"name": o})
+		}
+		body["enum_options"] = opts
+	case CustomFieldNumber:
+		body["precision"] = 2
+	}
+	var f CustomField
+	if _, err := c.do(ctx, http.MethodPost, "/custom_fields?opt_fields=name,resource_subtype,enum_options.name", body, &f); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// CreateEnumOption adds an option with the given name to the enum custom field.
+func (c *Client) CreateEnumOption(ctx context.Context, fieldGID, name string) (*EnumOption, error) {
+	var o EnumOption
+	body := map[string]string{"name": name}
+	if _, err := c.do(ctx, http.MethodPost, "/custom_fields/"+fieldGID+"/enum_options", body, &o); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// AddCustomFieldSetting enables the custom field on the project.
+func (c *Client) AddCustomFieldSetting(ctx context.Context, projectGID, fieldGID string) error {
+	body := map[string]string{"custom_field": fieldGID}
+	_, err := c.do(ctx, http.MethodPost, "/projects/"+projectGID+"/addCustomFieldSetting", body, nil)
+	return err
+}
@@ -0,0 +1,180 @@
+package asana
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OAuth endpoints of Asana.
+const (
+	DefaultAuthURL  = "https://app.asana.com/-/oauth_authorize"
+	DefaultTokenURL = "https://app.asana.com/-/oauth_token"
+)
+
+// refreshMargin is how long before its expiry an access token is refreshed.
+const refreshMargin = time.Minute
+
+// OAuthConfig describes an Asana OAuth app.
+type OAuthConfig struct {
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the redirect URL registered for the app.
+	RedirectURL string
+	// AuthURL and TokenURL default to the Asana endpoints.
+	AuthURL  string
+	TokenURL string
+	// HTTP is the client used for token requests, defaulting to http.DefaultClient.
+	HTTP *http.Client
+}
+
+// Token is an OAuth token pair.
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	Expiry       time.Time `json:"expiry"`
+}
+
+// valid reports whether the access token can still be used.
+func (t *Token) valid() bool {
+	return t != nil && t.AccessToken != "" && time.Until(t.Expiry) > refreshMargin
+}
+
+// AuthCodeURL returns the URL of the consent page that starts the authorization code flow. state is returned
+// unchanged to the redirect URL and must be checked there.
+func (c *OAuthConfig) AuthCodeURL(state string) string {
+	u := c.AuthURL
+	if u == "" {
+		u = DefaultAuthURL
+	}
+	q := url.Values{
+		"client_id":     {c.ClientID},
+		"redirect_uri":  {c.RedirectURL},
+		"response_type": {"code"},
+		"state":         {state},
+	}
+	return u + "?" + q.Encode()
+}
+
+// Exchange trades the authorization code received at the redirect URL for a token.
+func (c *OAuthConfig) Exchange(ctx context.Context, code string) (*Token, error) {
+	return c.token(ctx, url.Values{"grant_type": {"authorization_code"}, "code": {code}})
+}
+
+// Refresh returns a new access token for the refresh token. The refresh token of the result is the one
+// returned by Asana, or refresh when none was returned.
+func (c *OAuthConfig) Refresh(ctx context.Context, refresh string) (*Token, error) {
+	t, err := c.token(ctx, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refresh}})
+	if err != nil {
+		return nil, err
+	}
+	if t.RefreshToken == "" {
+		t.RefreshToken = refresh
+	}
+	return t, nil
+}
+
+// token requests a token for the grant in form.
+func (c *OAuthConfig) token(ctx context.Context, form url.Values) (*Token, error) {
+	u := c.TokenURL
+	if u == "" {
+		u = DefaultTokenURL
+	}
+	form.Set("client_id", c.ClientID)
+	form.Set("client_secret", c.ClientSecret)
+	form.Set("redirect_uri", c.RedirectURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		AccessToken      string `json:"access_token"`
+		RefreshToken     string `json:"refresh_token"`
+		ExpiresIn        int    `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("asana: decoding token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		return nil, &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(body.Error + " " + body.ErrorDescription)}
+	}
+	return &Token{
+		AccessToken:  body.AccessToken,
+		RefreshToken: body.RefreshToken,
+		Expiry:       time.Now().Add(time.Duration(body.ExpiresIn) * time.Second),
+	}, nil
+}
+
+// ErrNoToken is returned by a TokenStore that holds no token.
+var ErrNoToken = errors.New("no oauth token, run the login command")
+
+// TokenStore persists an OAuth token.
+type TokenStore interface {
+	// LoadToken returns the persisted token, or ErrNoToken.
+	LoadToken(ctx context.Context) (*Token, error)
+	// SaveToken persists t.
+	SaveToken(ctx context.Context, t *Token) error
+}
+
+// OAuthSource is a TokenSource that refreshes the OAuth token persisted in a TokenStore before it expires,
+// saving every refreshed token. It is safe for concurrent use.
+type OAuthSource struct {
+	config *OAuthConfig
+	store  TokenStore
+
+	mu  sync.Mutex
+	tok *Token
+}
+
+// NewOAuthSource returns an OAuthSource for the token persisted in st.
+func NewOAuthSource(cfg *OAuthConfig, st TokenStore) *OAuthSource {
+	return &OAuthSource{config: cfg, store: st}
+}
+
+// Token implements TokenSource.
+func (s *OAuthSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tok.valid() {
+		return s.tok.AccessToken, nil
+	}
+	if s.tok == nil {
+		t, err := s.store.LoadToken(ctx)
+		if err != nil {
+			return "", err
+		}
+		s.tok = t
+		if t.valid() {
+			return t.AccessToken, nil
+		}
+	}
+	t, err := s.config.Refresh(ctx, s.tok.RefreshToken)
+	if err != nil {
+		return "", fmt.Errorf("refreshing oauth token: %w", err)
+	}
+	if err := s.store.SaveToken(ctx, t); err != nil {
+		return "", fmt.Errorf("saving oauth token: %w", err)
+	}
+	s.tok = t
+	return t.AccessToken, nil
+}
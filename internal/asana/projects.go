@@ -0,0 +1,139 @@
+package asana
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Project is an Asana project.
+type Project struct {
+	GID  string `json:"gid"`
+	Name string `json:"name"`
+}
+
+// Workspace is an Asana workspace or organization.
+type Workspace struct {
+	GID  string `json:"gid"`
+	Name string `json:"name"`
+}
+
+// GetWorkspace returns the workspace with the given GID.
+func (c *Client) GetWorkspace(ctx context.Context, gid string) (*Workspace, error) {
+	var w Workspace
+	if _, err := c.do(ctx, http.MethodGet, "/workspaces/"+gid+"?opt_fields=name", nil, &w); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// Workspaces returns the workspaces and organizations the user of the token belongs to.
+func (c *Client) Workspaces(ctx context.Context) ([]Workspace, error) {
+	var workspaces []Workspace
+	offset := ""
+	for {
+		q := url.Values{"opt_fields": {"name"}, "limit": {"100"}}
+		if offset != "" {
+			q.Set("offset", offset)
+		}
+		var page []Workspace
+		next, err := c.do(ctx, http.MethodGet, "/workspaces?"+q.Encode(), nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		workspaces = append(workspaces, page...)
+		if next == "" {
+			return workspaces, nil
+		}
+		offset = next
+	}
+}
+
+// WorkspaceProjects returns the projects of the workspace that are not archived.
+func (c *Client) WorkspaceProjects(ctx context.Context, workspaceGID string) ([]Project, error) {
+	var projects []Project
+	offset := ""
+	for {
+		q := url.Values{"workspace": {workspaceGID}, "archived": {"false"}, "opt_fields": {"name"}, "limit": {"100"}}
+		if offset != "" {
+			q.Set("offset", offset)
+		}
+		var page []Project
+		next, err := c.do(ctx, http.MethodGet, "/projects?"+q.Encode(), nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		projects = append(projects, page...)
+		if next == "" {
+			return projects, nil
+		}
+		offset = next
+	}
+}
+
+// GetProject returns the project with the given GID.
+func (c *Client) GetProject(ctx context.Context, gid string) (*Project, error) {
+	var p Project
+	if _, err := c.do(ctx, http.MethodGet, "/projects/"+gid+"?opt_fields=name", nil, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// ProjectRequest is the body of a project create request.
+type ProjectRequest struct {
+	Name      string `json:"name"`
+	Workspace string `json:"workspace"`
+	// Team is required for projects in an organization.
+	Team string `json:"team,omitempty"`
+}
+
+// CreateProject creates a project.
+func (c *Client) CreateProject(ctx context.Context, req ProjectRequest) (*Project, error) {
+	var p Project
+	if _, err := c.do(ctx, http.MethodPost, "/projects", req, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// AddPortfolioItem adds the project to the portfolio.
+func (c *Client) AddPortfolioItem(ctx context.Context, portfolioGID, projectGID string) error {
+	_, err := c.do(ctx, http.MethodPost, "/portfolios/"+portfolioGID+"/addItem", map[string]string{"item": projectGID}, nil)
+	return err
+}
+
+// ProjectMembers returns the users who are members of the project.
+func (c *Client) ProjectMembers(ctx context.Context, projectGID string) ([]User, error) {
+	var users []User
+	offset := ""
+	for {
+		q := url.Values{"opt_fields": {"user.name,user.email"}, "limit": {"100"}}
+		if offset != "" {
+			q.Set("offset", offset)
+		}
+		var page []struct {
+			User *User `json:"user"`
+		}
+		next, err := c.do(ctx, http.MethodGet, "/projects/"+projectGID+"/project_memberships?"+q.Encode(), nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range page {
+			if m.User != nil {
+				users = append(users, *m.User)
+			}
+		}
+		if next == "" {
+			return users, nil
+		}
+		offset = next
+	}
+}
+
+// AddProjectMembers makes the users with the given GIDs members of the project.
+func (c *Client) AddProjectMembers(ctx context.Context, projectGID string, users []string) error {
+	_, err := c.do(ctx, http.MethodPost, "/projects/"+projectGID+"/addMembers", map[string]string{"members": strings.Join(users, ",")}, nil)
+	return err
+}
@@ -0,0 +1,79 @@
+package asana
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Section is a section of an Asana project.
+type Section struct {
+	GID  string `json:"gid"`
+	Name string `json:"name"`
+}
+
+// Membership places a task in a project and, optionally, one of its sections.
+type Membership struct {
+	Project *Section `json:"project,omitempty"`
+	Section *Section `json:"section,omitempty"`
+}
+
+// SectionIn returns the section holding the task in the project, or nil when the task is not in a section
+// of the project.
+func (t *Task) SectionIn(projectGID string) *Section {
+	for _, m := range t.Memberships {
+		if m.Project != nil && m.Project.GID == projectGID {
+			return m.Section
+		}
+	}
+	return nil
+}
+
+// InProject reports whether the task is in the project.
+func (t *Task) InProject(projectGID string) bool {
+	for _, m := range t.Memberships {
+		if m.Project != nil && m.Project.GID == projectGID {
+			return true
+		}
+	}
+	return false
+}
+
+// ProjectSections returns the sections of the project in board order.
+func (c *Client) ProjectSections(ctx context.Context, projectGID string) ([]Section, error) {
+	var sections []Section
+	offset := ""
+	for {
+		q := url.Values{"opt_fields": {"name"}, "limit": {"100"}}
+		if offset != "" {
+			q.Set("offset", offset)
+		}
+		var page []Section
+		next, err := c.do(ctx, http.MethodGet, "/projects/"+projectGID+"/sections?"+q.Encode(), nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		sections = append(sections, page...)
+		if next == "" {
+			return sections, nil
+		}
+		offset = next
+	}
+}
+
+// CreateSection adds a section with the given name to the end of the project.
+func (c *Client) CreateSection(ctx context.Context, projectGID, name string) (*Section, error) {
+	var s Section
+	body := map[string]string{"name": name}
+	if _, err := c.do(ctx, http.MethodPost, "/projects/"+projectGID+"/sections", body, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// AddTaskToSection moves the task into the section, removing it from any other section of the same project.
+func (c *Client) AddTaskToSection(ctx context.Context, sectionGID, taskGID string) error {
+	body := map[string]string{"task": taskGID}
+	_, err := c.do(ctx, http.MethodPost, "/sections/"+sectionGID+"/addTask", body, nil)
+	return err
+}
@@ -0,0 +1,45 @@
+package asana
+
+import (
+	"context"
+	"net/http"
+)
+
+// Status types of a status update.
+const (
+	StatusOnTrack  = "on_track"
+	StatusAtRisk   = "at_risk"
+	StatusOffTrack = "off_track"
+)
+
+// StatusUpdate is a status update posted on a project.
+type StatusUpdate struct {
+	GID        string `json:"gid"`
+	Title      string `json:"title"`
+	StatusType string `json:"status_type"`
+}
+
+// StatusUpdateRequest is the body of a status update create request.
+type StatusUpdateRequest struct {
+	// Parent is the GID of the project the update is posted on.
+	Parent     string `json:"parent"`
+	Title      string `json:"title"`
+	StatusType string `json:"status_type"`
+	// HTMLText is the body of the update, wrapped in a body element.
+	HTMLText string `json:"html_text"`
+}
+
+// CreateStatusUpdate posts a status update.
+func (c *Client) CreateStatusUpdate(ctx context.Context, req StatusUpdateRequest) (*StatusUpdate, error) {
+	var s StatusUpdate
+	if _, err := c.do(ctx, http.MethodPost, "/status_updates?opt_fields=title,status_type", req, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// DeleteStatusUpdate deletes the status update with the given GID.
+func (c *Client) DeleteStatusUpdate(ctx context.Context, gid string) error {
+	_, err := c.do(ctx, http.MethodDelete, "/status_updates/"+gid, nil, nil)
+	return err
+}
@@ -0,0 +1,52 @@
+package asana
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Story is an entry in a task's activity feed, such as a comment.
+type Story struct {
+	GID             string    `json:"gid"`
+	Text            string    `json:"text"`
+	ResourceSubtype string    `json:"resource_subtype"`
+	CreatedBy       *User     `json:"created_by"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// TaskComments returns the comments on the task, oldest first. Other story types are omitted.
+func (c *Client) TaskComments(ctx context.Context, taskGID string) ([]Story, error) {
+	var comments []Story
+	offset := ""
+	for {
+		q := url.Values{"opt_fields": {"text,resource_subtype,created_at,created_by.name,created_by.email"}, "limit": {"100"}}
+		if offset != "" {
+			q.Set("offset", offset)
+		}
+		var page []Story
+		next, err := c.do(ctx, http.MethodGet, "/tasks/"+taskGID+"/stories?"+q.Encode(), nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range page {
+			if s.ResourceSubtype == "comment_added" {
+				comments = append(comments, s)
+			}
+		}
+		if next == "" {
+			return comments, nil
+		}
+		offset = next
+	}
+}
+
+// AddComment adds a plain text comment to the task.
+func (c *Client) AddComment(ctx context.Context, taskGID, text string) (*Story, error) {
+	var s Story
+	if _, err := c.do(ctx, http.MethodPost, "/tasks/"+taskGID+"/stories", map[string]string{"text": text}, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
@@ -0,0 +1,57 @@
+package asana
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Tag is an Asana tag.
+type Tag struct {
+	GID  string `json:"gid"`
+	Name string `json:"name"`
+}
+
+// WorkspaceTags returns all tags in the workspace.
+func (c *Client) WorkspaceTags(ctx context.Context, workspaceGID string) ([]Tag, error) {
+	var tags []Tag
+	offset := ""
+	for {
+		q := url.Values{"opt_fields": {"name"}, "limit": {"100"}}
+		if offset != "" {
+			q.Set("offset", offset)
+		}
+		var page []Tag
+		next, err := c.do(ctx, http.MethodGet, "/workspaces/"+workspaceGID+"/tags?"+q.Encode(), nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, page...)
+		if next == "" {
+			return tags, nil
+		}
+		offset = next
+	}
+}
+
+// CreateTag creates a tag with the given name in the workspace.
+func (c *Client) CreateTag(ctx context.Context, workspaceGID, name string) (*Tag, error) {
+	var t Tag
+	body := map[string]string{"name": name}
+	if _, err := c.do(ctx, http.MethodPost, "/workspaces/"+workspaceGID+"/tags", body, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// AddTag adds the tag to the task.
+func (c *Client) AddTag(ctx context.Context, taskGID, tagGID string) error {
+	_, err := c.do(ctx, http.MethodPost, "/tasks/"+taskGID+"/addTag", map[string]string{"tag": tagGID}, nil)
+	return err
+}
+
+// RemoveTag removes the tag from the task.
+func (c *Client) RemoveTag(ctx context.Context, taskGID, tagGID string) error {
+	_, err := c.do(ctx, http.MethodPost, "/tasks/"+taskGID+"/removeTag", map[string]string{"tag": tagGID}, nil)
+	return err
+}
@@ -0,0 +1,279 @@
+package asana
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// taskFields are the task fields requested from the API.
+const taskFields = "name,notes,completed,due_on,modified_at,permalink_url,assignee,assignee.email," +
+	"custom_fields.name,custom_fields.resource_subtype,custom_fields.text_value,custom_fields.number_value," +
+	"custom_fields.enum_value.name,custom_fields.date_value.date," +
+	"memberships.project.name,memberships.section.name,tags.name,parent.name,dependencies,external," +
+	"actual_time_minutes,followers,resource_subtype,approval_status"
+
+// Task subtypes.
+const (
+	SubtypeDefault   = "default_task"
+	SubtypeMilestone = "milestone"
+	SubtypeApproval  = "approval"
+)
+
+// Approval statuses of approval tasks. Approving or rejecting a task completes it.
+const (
+	ApprovalPending          = "pending"
+	ApprovalApproved         = "approved"
+	ApprovalRejected         = "rejected"
+	ApprovalChangesRequested = "changes_requested"
+)
+
+// User is an Asana user.
+type User struct {
+	GID   string `json:"gid"`
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+}
+
+// Task is an Asana task.
+type Task struct {
+	GID          string        `json:"gid"`
+	Name         string        `json:"name"`
+	Notes        string        `json:"notes"`
+	Completed    bool          `json:"completed"`
+	DueOn        string        `json:"due_on"`
+	ModifiedAt   time.Time     `json:"modified_at"`
+	PermalinkURL string        `json:"permalink_url"`
+	Assignee     *User         `json:"assignee"`
+	CustomFields []CustomField `json:"custom_fields,omitempty"`
+	Memberships  []Membership  `json:"memberships,omitempty"`
+	Tags         []Tag         `json:"tags,omitempty"`
+	// Parent is the task this task is a subtask of, or nil for top-level tasks.
+	Parent *Task `json:"parent,omitempty"`
+	// Dependencies are the tasks this task depends on, which block it until they are completed.
+	Dependencies []Task `json:"dependencies,omitempty"`
+	// ActualTimeMinutes is the time tracked on the task, nil when none was tracked or time tracking is not
+	// available. It is read-only.
+	ActualTimeMinutes *float64 `json:"actual_time_minutes,omitempty"`
+	// External is the metadata an app stored on the task. Only apps authenticated with OAuth can read and
+	// write it.
+	External *External `json:"external,omitempty"`
+	// Followers are the users notified of changes to the task.
+	Followers []User `json:"followers,omitempty"`
+	// ResourceSubtype is the subtype of the task: SubtypeDefault, SubtypeMilestone or SubtypeApproval.
+	ResourceSubtype string `json:"resource_subtype,omitempty"`
+	// ApprovalStatus is the status of an approval task, empty for other subtypes.
+	ApprovalStatus string `json:"approval_status,omitempty"`
+}
+
+// External is app specific metadata stored on a task.
+type External struct {
+	GID  string `json:"gid,omitempty"`
+	Data string `json:"data,omitempty"`
+}
+
+// TaskRequest holds the fields to set when creating or updating a task.
+// Nil fields are left unchanged.
+type TaskRequest struct {
+	Name  *string `json:"name,omitempty"`
+	Notes *string `json:"notes,omitempty"`
+	// HTMLNotes sets the notes as rich text, wrapped in a body element.
+	HTMLNotes *string  `json:"html_notes,omitempty"`
+	Completed *bool    `json:"completed,omitempty"`
+	Assignee  *string  `json:"assignee,omitempty"`
+	DueOn     *Date    `json:"due_on,omitempty"`
+	Projects  []string `json:"projects,omitempty"`
+	Workspace string   `json:"workspace,omitempty"`
+	// CustomFields maps custom field GIDs to their new value.
+	CustomFields map[string]interface{} `json:"custom_fields,omitempty"`
+	// External sets the app specific metadata of the task.
+	External *External `json:"external,omitempty"`
+	// ResourceSubtype changes the subtype of the task.
+	ResourceSubtype *string `json:"resource_subtype,omitempty"`
+	// ApprovalStatus sets the status of an approval task.
+	ApprovalStatus *string `json:"approval_status,omitempty"`
+}
+
+// ProjectTasks returns all tasks in the project.
+func (c *Client) ProjectTasks(ctx context.Context, projectGID string) ([]Task, error) {
+	return c.listTasks(ctx, "/projects/"+projectGID+"/tasks", url.Values{})
+}
+
+// ModifiedTasks returns the tasks in the project modified since the given time.
+func (c *Client) ModifiedTasks(ctx context.Context, projectGID string, since time.Time) ([]Task, error) {
+	q := url.Values{"project": {projectGID}, "modified_since": {since.UTC().Format(time.RFC3339)}}
+	return c.listTasks(ctx, "/tasks", q)
+}
+
+// AssignedTasks returns the tasks of the workspace assigned to the user, only those modified since the given
+// time unless it is zero.
+func (c *Client) AssignedTasks(ctx context.Context, workspaceGID, userGID string, since time.Time) ([]Task, error) {
+	q := url.Values{"workspace": {workspaceGID}, "assignee": {userGID}}
+	if !since.IsZero() {
+		q.Set("modified_since", since.UTC().Format(time.RFC3339))
+	}
+	return c.listTasks(ctx, "/tasks", q)
+}
+
+// listTasks returns every page of tasks listed by the endpoint at path with the filters in q.
+func (c *Client) listTasks(ctx context.Context, path string, q url.Values) ([]Task, error) {
+	var tasks []Task
+	offset := ""
+	q.Set("opt_fields", taskFields)
+	q.Set("limit", "100")
+	for {
+		if offset != "" {
+			q.Set("offset", offset)
+		}
+		var page []Task
+		next, err := c.do(ctx, http.MethodGet, path+"?"+q.Encode(), nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, page...)
+		if next == "" {
+			return tasks, nil
+		}
+		offset = next
+	}
+}
+
+// GetTask returns the task with the given GID.
+func (c *Client) GetTask(ctx context.Context, gid string) (*Task, error) {
+	var t Task
+	if _, err := c.do(ctx, http.MethodGet, "/tasks/"+gid+"?opt_fields="+taskFields, nil, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// TaskHTMLNotes returns the notes of the task as rich text, wrapped in a body element.
+func (c *Client) TaskHTMLNotes(ctx context.Context, gid string) (string, error) {
+	var t struct {
+		HTMLNotes string `json:"html_notes"`
+	}
+	if _, err := c.do(ctx, http.MethodGet, "/tasks/"+gid+"?opt_fields=html_notes", nil, &t); err != nil {
+		return "", err
+	}
+	return t.HTMLNotes, nil
+}
+
+// CreateTask creates a task and returns it.
+func (c *Client) CreateTask(ctx context.Context, req TaskRequest) (*Task, error) {
+	var t Task
+	if _, err := c.do(ctx, http.MethodPost, "/tasks?opt_fields="+taskFields, req, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// UpdateTask updates the task with the given GID and returns the updated task.
+func (c *Client) UpdateTask(ctx context.Context, gid string, req TaskRequest) (*Task, error) {
+	var t Task
+	if _, err := c.do(ctx, http.MethodPut, "/tasks/"+gid+"?opt_fields="+taskFields, req, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// DeleteTask deletes the task with the given GID.
+func (c *Client) DeleteTask(ctx context.Context, gid string) error {
+	_, err := c.do(ctx, http.MethodDelete, "/tasks/"+gid, nil, nil)
+	return err
+}
+
+// SetParent makes the task a subtask of the parent task. An empty parentGID makes it a top-level task.
+func (c *Client) SetParent(ctx context.Context, gid, parentGID string) error {
+	body := map[string]interface{}{"parent": nil}
+	if parentGID != "" {
+		body["parent"] = parentGID
+	}
+	_, err := c.do(ctx, http.MethodPost, "/tasks/"+gid+"/setParent", body, nil)
+	return err
+}
+
+// AddDependencies makes the task depend on the tasks with the given GIDs.
+func (c *Client) AddDependencies(ctx context.Context, gid string, dependencies []string) error {
+	_, err := c.do(ctx, http.MethodPost, "/tasks/"+gid+"/addDependencies", map[string][]string{"dependencies": dependencies}, nil)
+	return err
+}
+
+// RemoveDependencies removes the tasks with the given GIDs from the dependencies of the task.
+func (c *Client) RemoveDependencies(ctx context.Context, gid string, dependencies []string) error {
+	_, err := c.do(ctx, http.MethodPost, "/tasks/"+gid+"/removeDependencies", map[string][]string{"dependencies": dependencies}, nil)
+	return err
+}
+
+// AddProject adds the task to the project, keeping it in its other projects.
+func (c *Client) AddProject(ctx context.Context, gid, projectGID string) error {
+	_, err := c.do(ctx, http.MethodPost, "/tasks/"+gid+"/addProject", map[string]string{"project": projectGID}, nil)
+	return err
+}
+
+// RemoveProject removes the task from the project. The task itself is not deleted.
+func (c *Client) RemoveProject(ctx context.Context, gid, projectGID string) error {
+	_, err := c.do(ctx, http.MethodPost, "/tasks/"+gid+"/removeProject", map[string]string{"project": projectGID}, nil)
+	return err
+}
+
+// AddFollowers makes the users with the given GIDs followers of the task.
+func (c *Client) AddFollowers(ctx context.Context, gid string, followers []string) error {
+	_, err := c.do(ctx, http.MethodPost, "/tasks/"+gid+"/addFollowers", map[string][]string{"followers": followers}, nil)
+	return err
+}
+
+// Me returns the user the access token belongs to.
+func (c *Client) Me(ctx context.Context) (*User, error) {
+	var u User
+	if _, err := c.do(ctx, http.MethodGet, "/users/me?opt_fields=name,email", nil, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// WorkspaceUsers returns all users in the workspace.
+func (c *Client) WorkspaceUsers(ctx context.Context, workspaceGID string) ([]User, error) {
+	var users []User
+	offset := ""
+	for {
+		q := url.Values{"opt_fields": {"name,email"}, "limit": {"100"}}
+		if offset != "" {
+			q.Set("offset", offset)
+		}
+		var page []User
+		next, err := c.do(ctx, http.MethodGet, "/workspaces/"+workspaceGID+"/users?"+q.Encode(), nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, page...)
+		if next == "" {
+			return users, nil
+		}
+		offset = next
+	}
+}
+
+// Date is a date as YYYY-MM-DD. The empty Date encodes as null, clearing the date.
+type Date string
+
+// MarshalJSON encodes d as a string, or null when it is empty.
+func (d Date) MarshalJSON() ([]byte, error) {
+	if d == "" {
+		return []byte("null"), nil
+	}
+	return json.Marshal(string(d))
+}
+
+// DateOf returns a pointer to the Date d, for use in TaskRequest.
+func DateOf(d string) *Date {
+	v := Date(d)
+	return &v
+}
+
+// String returns a pointer to s, for use in TaskRequest.
+func String(s string) *string { return &s }
+
+// Bool returns a pointer to b, for use in TaskRequest.
+func Bool(b bool) *bool { return &b }
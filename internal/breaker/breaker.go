@@ -0,0 +1,227 @@
+// Package breaker stops sending requests to a provider that keeps failing, so an outage fails fast instead of
+// tying up every sync, and probes the provider until it recovers.
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/metrics"
+)
+
+// Defaults used by New for zero options.
+const (
+	DefaultThreshold = 5
+	DefaultCooldown  = 30 * time.Second
+)
+
+// maxCooldown caps the cooldown, which doubles with every failed probe.
+const maxCooldown = 10 * time.Minute
+
+// ErrOpen is returned for requests refused while the circuit of their provider is open.
+var ErrOpen = errors.New("circuit open")
+
+// State is the state of a circuit.
+type State int
+
+// Circuit states.
+const (
+	// Closed sends every request.
+	Closed State = iota
+	// HalfOpen lets a single probe request through to find out whether the provider recovered.
+	HalfOpen
+	// Open refuses every request until the cooldown ends.
+	Open
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "open"
+	}
+}
+
+// Options configures a Breaker.
+type Options struct {
+	// Threshold is the number of consecutive failed requests that opens the circuit.
+	Threshold int
+	// Cooldown is the time the circuit stays open before a probe request is let through. It doubles after
+	// every failed probe, up to ten minutes.
+	Cooldown time.Duration
+}
+
+// Breaker is the circuit breaker of a single provider. Requests fail when they get no response or a 5xx
+// one; rate limited responses are left to the rate limiter.
+type Breaker struct {
+	provider  string
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	// wait is the current cooldown and retryAt the time the next probe is let through.
+	wait    time.Duration
+	retryAt time.Time
+}
+
+// New returns a Breaker for provider, which labels its metrics.
+func New(provider string, opts Options) *Breaker {
+	if opts.Threshold <= 0 {
+		opts.Threshold = DefaultThreshold
+	}
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = DefaultCooldown
+	}
+	metrics.CircuitState.WithLabelValues(provider).Set(float64(Closed))
+	return &Breaker{provider: provider, threshold: opts.Threshold, cooldown: opts.Cooldown}
+}
+
+// State returns the state of the circuit.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Available reports whether the circuit is closed. It is false while the circuit is open and while a probe
+// finds out whether the provider recovered.
+func (b *Breaker) Available() bool {
+	return b.State() == Closed
+}
+
+// allow reports whether a request may be sent and whether it is the probe of a half open circuit.
+func (b *Breaker) allow(ctx context.Context) (probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case b.state == Closed:
+		return false, nil
+	case b.state == Open && !time.Now().Before(b.retryAt):
+		b.set(HalfOpen)
+		return true, nil
+	default:
+		return false, fmt.Errorf("%s: %w", b.provider, ErrOpen)
+	}
+}
+
+// done records the outcome of a request allowed by allow.
+func (b *Breaker) done(ctx context.Context, probe, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case !failed:
+		b.failures = 0
+		b.wait = 0
+		if b.state != Closed {
+			logging.From(ctx).Info("provider recovered, closing circuit", "provider", b.provider)
+			b.set(Closed)
+		}
+	case probe:
+		b.trip(ctx)
+	case b.state == Closed:
+		b.failures++
+		if b.failures >= b.threshold {
+			b.trip(ctx)
+		}
+	}
+}
+
+// trip opens the circuit for the next cooldown. The caller must hold b.mu.
+func (b *Breaker) trip(ctx context.Context) {
+	switch {
+	case b.wait == 0:
+		b.wait = b.cooldown
+	case b.wait < maxCooldown:
+		b.wait *= 2
+		if b.wait > maxCooldown {
+			b.wait = maxCooldown
+		}
+	}
+	b.retryAt = time.Now().Add(b.wait)
+	if b.state == Closed {
+		metrics.CircuitOpened.WithLabelValues(b.provider).Inc()
+		logging.From(ctx).Warn("provider failing, opening circuit", "provider", b.provider, "failures", b.failures, "retry_in", b.wait.String())
+	}
+	b.set(Open)
+}
+
+// set changes the state of the circuit. The caller must hold b.mu.
+func (b *Breaker) set(s State) {
+	b.state = s
+	metrics.CircuitState.WithLabelValues(b.provider).Set(float64(s))
+}
+
+// Transport wraps next, refusing requests with ErrOpen while the circuit is open. A nil next uses
+// http.DefaultTransport.
+func (b *Breaker) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &transport{breaker: b, next: next}
+}
+
+type transport struct {
+	breaker *Breaker
+	next    http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	probe, err := t.breaker.allow(ctx)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil && ctx.Err() != nil {
+		// A cancelled request says nothing about the provider. A probe gives its turn to the next request.
+		if probe {
+			t.breaker.reopen()
+		}
+		return resp, err
+	}
+	t.breaker.done(ctx, probe, err != nil || resp.StatusCode >= http.StatusInternalServerError)
+	return resp, err
+}
+
+// reopen returns a half open circuit whose probe was cancelled to the open state, letting the next request
+// probe right away.
+func (b *Breaker) reopen() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == HalfOpen {
+		b.set(Open)
+	}
+}
+
+// Probe calls check, which should make a cheap request through the breaker, whenever the circuit is due a
+// probe until ctx is done. The provider is then found to have recovered without waiting for a sync to try it.
+func (b *Breaker) Probe(ctx context.Context, check func(context.Context) error) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		b.mu.Lock()
+		due := b.state == Open && !time.Now().Before(b.retryAt)
+		b.mu.Unlock()
+		if due {
+			_ = check(ctx)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package breaker_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/breaker"
+)
+
+// provider is a server answering with the status it is set to, counting the requests that reach it.
+type provider struct {
+	status   atomic.Int32
+	requests atomic.Int32
+
+	mu sync.Mutex
+	// gate, when set, keeps each request waiting until it is closed.
+	gate chan struct{}
+}
+
+// hold keeps the requests that reach the provider waiting until release is called.
+func (p *provider) hold() (release func()) {
+	gate := make(chan struct{})
+	p.mu.Lock()
+	p.gate = gate
+	p.mu.Unlock()
+	return func() {
+		p.mu.Lock()
+		p.gate = nil
+		p.mu.Unlock()
+		close(gate)
+	}
+}
+
+func newProvider(t *testing.T) (*provider, *httptest.Server) {
+	p := &provider{}
+	p.status.Store(http.StatusOK)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.requests.Add(1)
+		p.mu.Lock()
+		gate := p.gate
+		p.mu.Unlock()
+		if gate != nil {
+			select {
+			case <-gate:
+			case <-r.Context().Done():
+			}
+		}
+		w.WriteHeader(int(p.status.Load()))
+	}))
+	t.Cleanup(srv.Close)
+	return p, srv
+}
+
+// get sends a request through the breaker and returns its status, or the error of the transport.
+func get(ctx context.Context, b *breaker.Breaker, url string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := (&http.Client{Transport: b.Transport(nil)}).Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func TestOpensAfterConsecutiveFailures(t *testing.T) {
+	p, srv := newProvider(t)
+	b := breaker.New("test", breaker.Options{Threshold: 3, Cooldown: time.Hour})
+	ctx := context.Background()
+
+	// Rate limits and client errors say nothing about the health of the provider, and a success starts the
+	// count again.
+	for _, status := range []int{500, 502, 429, 404, 200, 503, 500} {
+		p.status.Store(int32(status))
+		if _, err := get(ctx, b, srv.URL); err != nil {
+			t.Fatalf("status %d: want the request sent, got %v", status, err)
+		}
+	}
+	if !b.Available() {
+		t.Fatalf("want the circuit closed after two consecutive failures, got %s", b.State())
+	}
+	if _, err := get(ctx, b, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	if b.State() != breaker.Open || b.Available() {
+		t.Fatalf("want the circuit open after three consecutive failures, got %s", b.State())
+	}
+
+	sent := p.requests.Load()
+	_, err := get(ctx, b, srv.URL)
+	if !errors.Is(err, breaker.ErrOpen) {
+		t.Fatalf("want ErrOpen while the circuit is open, got %v", err)
+	}
+	if p.requests.Load() != sent {
+		t.Fatal("want no request sent while the circuit is open")
+	}
+}
+
+func TestProbesAfterCooldown(t *testing.T) {
+	p, srv := newProvider(t)
+	const cooldown = 50 * time.Millisecond
+	b := breaker.New("test", breaker.Options{Threshold: 1, Cooldown: cooldown})
+	ctx := context.Background()
+
+	p.status.Store(http.StatusInternalServerError)
+	if _, err := get(ctx, b, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	if b.State() != breaker.Open {
+		t.Fatalf("want the circuit open, got %s", b.State())
+	}
+
+	// A failed probe opens the circuit again for twice the cooldown.
+	time.Sleep(cooldown + 10*time.Millisecond)
+	if _, err := get(ctx, b, srv.URL); err != nil {
+		t.Fatalf("want the probe sent after the cooldown, got %v", err)
+	}
+	time.Sleep(cooldown + 10*time.Millisecond)
+	if _, err := get(ctx, b, srv.URL); !errors.Is(err, breaker.ErrOpen) {
+		t.Fatalf("want the cooldown doubled after a failed probe, got %v", err)
+	}
+	time.Sleep(cooldown + 10*time.Millisecond)
+
+	// While the probe is out, every other request is refused; once it succeeds the circuit closes.
+	p.status.Store(http.StatusOK)
+	release := p.hold()
+	done := make(chan error, 1)
+	go func() {
+		_, err := get(ctx, b, srv.URL)
+		done <- err
+	}()
+	for b.State() != breaker.HalfOpen {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := get(ctx, b, srv.URL); !errors.Is(err, breaker.ErrOpen) {
+		t.Fatalf("want requests refused while the probe is out, got %v", err)
+	}
+	release()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !b.Available() {
+		t.Fatalf("want the circuit closed by a successful probe, got %s", b.State())
+	}
+}
+
+func TestCancelledProbe(t *testing.T) {
+	p, srv := newProvider(t)
+	b := breaker.New("test", breaker.Options{Threshold: 1, Cooldown: 20 * time.Millisecond})
+	p.status.Store(http.StatusBadGateway)
+	if _, err := get(context.Background(), b, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	// A probe cancelled by its caller passes its turn to the next request, which probes right away.
+	release := p.hold()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := get(ctx, b, srv.URL)
+		done <- err
+	}()
+	for b.State() != breaker.HalfOpen {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("want the probe cancelled, got %v", err)
+	}
+	if b.State() != breaker.Open {
+		t.Fatalf("want the circuit open after the cancelled probe, got %s", b.State())
+	}
+	release()
+	p.status.Store(http.StatusOK)
+	if _, err := get(context.Background(), b, srv.URL); err != nil {
+		t.Fatalf("want the next request to probe, got %v", err)
+	}
+	if !b.Available() {
+		t.Fatalf("want the circuit closed, got %s", b.State())
+	}
+}
+
+func TestProbe(t *testing.T) {
+	p, srv := newProvider(t)
+	b := breaker.New("test", breaker.Options{Threshold: 1, Cooldown: 10 * time.Millisecond})
+	p.status.Store(http.StatusServiceUnavailable)
+	if _, err := get(context.Background(), b, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	p.status.Store(http.StatusOK)
+
+	// The provider is found to have recovered with no request of the sync.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go b.Probe(ctx, func(ctx context.Context) error {
+		_, err := get(ctx, b, srv.URL)
+		return err
+	})
+	for !b.Available() {
+		if ctx.Err() != nil {
+			t.Fatal("want the circuit closed by Probe")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
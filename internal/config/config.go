@@ -0,0 +1,868 @@
+// Package config loads the sync configuration file.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/egress"
+	"github.com/danstis/ado-asana-sync/internal/ratelimit"
+	"github.com/danstis/ado-asana-sync/internal/schedule"
+	"github.com/danstis/ado-asana-sync/internal/sync"
+	"github.com/danstis/ado-asana-sync/internal/transform"
+	"gopkg.in/yaml.v3"
+)
+
+// File is the contents of a configuration file.
+type File struct {
+	// Env holds settings named after their environment variable, such as SYNC_INTERVAL. Variables set in
+	// the environment take precedence.
+	Env map[string]string `json:"env,omitempty"`
+	// FieldMappings maps ADO work item fields onto Asana custom fields. They apply to every pair
+	// that does not list its own.
+	FieldMappings []sync.FieldMapping `json:"field_mappings"`
+	// Sections maps ADO states to Asana section names for every pair that does not list its own.
+	Sections map[string]string `json:"sections,omitempty"`
+	// Tags configures tag sync for every pair that does not configure its own.
+	Tags *sync.TagConfig `json:"tags,omitempty"`
+	// Users maps ADO unique names to Asana user GIDs for every pair that does not list its own.
+	Users map[string]string `json:"users,omitempty"`
+	// FuzzyUsers, when set, overrides SYNC_FUZZY_USERS.
+	FuzzyUsers *bool `json:"fuzzy_users,omitempty"`
+	// Removal configures the removal policy of every pair that does not configure its own.
+	Removal *sync.RemovalConfig `json:"removal,omitempty"`
+	// Closing configures how the tasks of closed work items are completed for every pair that does not
+	// configure its own.
+	Closing *sync.ClosingConfig `json:"closing,omitempty"`
+	// Members configures how assignees outside the Asana project are handled for every pair that does not
+	// configure its own.
+	Members *sync.MembersConfig `json:"members,omitempty"`
+	// Recurrence marks the recurring work items of every pair that does not configure its own.
+	Recurrence *sync.RecurrenceConfig `json:"recurrence,omitempty"`
+	// Transforms lists the transformers of every pair that does not list its own.
+	Transforms []transform.Spec `json:"transforms,omitempty"`
+	// Sprints configures the sprint sync of every pair that does not configure its own.
+	Sprints *sync.SprintConfig `json:"sprints,omitempty"`
+	// Board configures the board sync of every pair that does not configure its own.
+	Board *sync.BoardConfig `json:"board,omitempty"`
+	// Types holds the work item type rules of every pair that does not list its own.
+	Types []sync.TypeRule `json:"types,omitempty"`
+	// States maps ADO states onto Asana for every pair that does not configure its own.
+	States *sync.StateMap `json:"states,omitempty"`
+	// OptOut sets the opt-out marker of every pair that does not set its own.
+	OptOut *sync.OptOut `json:"opt_out,omitempty"`
+	// Anchor, when set, overrides SYNC_ANCHOR for every pair that does not set its own.
+	Anchor string `json:"anchor,omitempty"`
+	// BackLink, when set, overrides SYNC_BACK_LINK for every pair that does not set its own.
+	BackLink string `json:"back_link,omitempty"`
+	// Effort maps the effort fields of every pair that does not map its own.
+	Effort *sync.EffortConfig `json:"effort,omitempty"`
+	// Rollup sets the rollups of every pair that does not set its own.
+	Rollup *sync.RollupConfig `json:"rollup,omitempty"`
+	// StatusUpdates sets the status updates of every pair that does not set its own.
+	StatusUpdates *sync.StatusUpdateConfig `json:"status_updates,omitempty"`
+	// MyTasks sets the users mirrored into My Tasks of every pair that does not set its own.
+	MyTasks *sync.MyTasksConfig `json:"my_tasks,omitempty"`
+	// Priority sets the boosted work items of every pair that does not set its own.
+	Priority *sync.PriorityConfig `json:"priority,omitempty"`
+	// Links sets the sync of work item links of every pair that does not set its own.
+	Links *sync.LinkConfig `json:"links,omitempty"`
+	// Calendar sets the time zone and working calendar of every pair that does not set its own.
+	Calendar *sync.CalendarConfig `json:"calendar,omitempty"`
+	// Intake sets the task intake of every pair that does not set its own.
+	Intake *sync.IntakeConfig `json:"intake,omitempty"`
+	// NameTemplate and NotesTemplate render the Asana task name and notes of every pair that does not set
+	// its own.
+	NameTemplate  string `json:"name_template,omitempty"`
+	NotesTemplate string `json:"notes_template,omitempty"`
+	// NotesFormat, when set, overrides SYNC_NOTES_FORMAT.
+	NotesFormat string `json:"notes_format,omitempty"`
+	// NotesOverflow and NotesLimit, when set, override SYNC_NOTES_OVERFLOW and SYNC_NOTES_LIMIT.
+	NotesOverflow string `json:"notes_overflow,omitempty"`
+	NotesLimit    int    `json:"notes_limit,omitempty"`
+	// ADOConnections lists the Azure DevOps organizations pairs can sync with besides the one set by
+	// ADO_ORG_URL.
+	ADOConnections []ADOConnection `json:"ado_connections,omitempty"`
+	// AsanaConnections lists the Asana accounts pairs can sync with besides the one set by ASANA_TOKEN.
+	AsanaConnections []AsanaConnection `json:"asana_connections,omitempty"`
+	// Pairs lists the sync pairs. When empty, a single pair is configured from the environment.
+	Pairs []Pair `json:"pairs,omitempty"`
+}
+
+// ADOConnection is an Azure DevOps organization that pairs refer to by name.
+type ADOConnection struct {
+	Name string `json:"name"`
+	// OrgURL is the URL of the organization, for example https://dev.azure.com/fabrikam.
+	OrgURL string `json:"org_url"`
+	// PATEnv names the environment variable holding the PAT of the organization, or a secret reference to
+	// it. Connections without one use the Entra ID identity set by the AZURE_ variables.
+	PATEnv string `json:"pat_env,omitempty"`
+	// PAT is the PAT of the organization, or a secret reference to it, for the connection of a tenant, whose
+	// credentials come with its file rather than the environment.
+	PAT string `json:"-"`
+	// StoreURL is the mapping store of the connection's pairs. Work item IDs are only unique within an
+	// organization, so at most one organization in use may share the store set by STORE_URL.
+	StoreURL string `json:"store_url,omitempty"`
+	// CallBudget caps the API requests to the organization, for example "600/m,20000/h".
+	CallBudget string `json:"call_budget,omitempty"`
+	// Options are the proxy and TLS settings of the requests to the organization, each falling back to its
+	// environment variable.
+	egress.Options
+}
+
+// AsanaConnection is an Asana account, with a token of its own, that pairs refer to by name.
+type AsanaConnection struct {
+	Name string `json:"name"`
+	// TokenEnv names the environment variable holding the personal access token of the account, or a secret
+	// reference to it.
+	TokenEnv string `json:"token_env"`
+	// Token is the personal access token of the account, or a secret reference to it, for the connection of
+	// a tenant.
+	Token string `json:"-"`
+	// CallBudget caps the API requests of the account, for example "150/m".
+	CallBudget string `json:"call_budget,omitempty"`
+	// Options are the proxy and TLS settings of the requests of the account, each falling back to its
+	// environment variable.
+	egress.Options
+}
+
+// Pair configures one sync pair. Empty settings fall back to the values from the environment.
+type Pair struct {
+	// Name identifies the pair in logs, metrics and the mapping database.
+	Name           string `json:"name"`
+	ADOProject     string `json:"ado_project"`
+	AsanaWorkspace string `json:"asana_workspace,omitempty"`
+	AsanaProject   string `json:"asana_project"`
+	// ADOConnection names the connection of the organization the pair syncs with, ADO_ORG_URL when empty.
+	ADOConnection string `json:"ado_connection,omitempty"`
+	// AsanaConnection names the connection of the account the pair syncs with, ASANA_TOKEN when empty.
+	AsanaConnection string `json:"asana_connection,omitempty"`
+	// Routes send the tasks of work items to other Asana projects by area path, falling back to AsanaProject.
+	Routes []sync.Route `json:"routes,omitempty"`
+	// Provision configures the creation of the projects that routes refer to by name.
+	Provision *sync.ProvisionConfig `json:"provision,omitempty"`
+	// Query is the WIQL query selecting the work items to sync.
+	Query string `json:"query,omitempty"`
+	// Interval is the time between sync cycles, for example "10m".
+	Interval string `json:"interval,omitempty"`
+	// Schedule is a cron expression replacing Interval, for example "0 2 * * *".
+	Schedule string `json:"schedule,omitempty"`
+	// Jitter is the longest random delay of each cycle, for example "30s".
+	Jitter string `json:"jitter,omitempty"`
+	// Maintenance are the maintenance windows of the pair, replacing SYNC_MAINTENANCE, for example
+	// ["0 22 * * FRI for 10h"].
+	Maintenance []string `json:"maintenance,omitempty"`
+	// Workers is the number of work items synced concurrently.
+	Workers int `json:"workers,omitempty"`
+	// Prefetch is the number of pages of work items fetched ahead of the workers.
+	Prefetch int `json:"prefetch,omitempty"`
+	// QueryPage is the number of work item IDs read per query of the selection, at most 20000.
+	QueryPage int `json:"query_page,omitempty"`
+	// CallBudget caps the API requests of the pair, for example "300/m,5000/h".
+	CallBudget string `json:"call_budget,omitempty"`
+	// Incremental, when set, overrides SYNC_INCREMENTAL for the pair.
+	Incremental *bool `json:"incremental,omitempty"`
+	// FullSyncInterval is the time between full reconciliation cycles, for example "24h".
+	FullSyncInterval string `json:"full_sync_interval,omitempty"`
+	// DriftInterval is the time between drift checks of the pair's mappings, for example "6h". DriftRepair,
+	// when set, overrides SYNC_DRIFT_REPAIR for the pair.
+	DriftInterval string `json:"drift_interval,omitempty"`
+	DriftRepair   *bool  `json:"drift_repair,omitempty"`
+	// Direction, FieldDirections and ConflictStrategy use the same values as their environment variables.
+	Direction        string `json:"direction,omitempty"`
+	FieldDirections  string `json:"field_directions,omitempty"`
+	ConflictStrategy string `json:"conflict_strategy,omitempty"`
+	// Comments and Attachments set the mirroring direction; "none" disables mirroring for the pair.
+	Comments      string              `json:"comments,omitempty"`
+	Attachments   string              `json:"attachments,omitempty"`
+	FieldMappings []sync.FieldMapping `json:"field_mappings,omitempty"`
+	// ManageSchema, when set, overrides SYNC_MANAGE_SCHEMA for the pair.
+	ManageSchema *bool             `json:"manage_schema,omitempty"`
+	Sections     map[string]string `json:"sections,omitempty"`
+	Tags         *sync.TagConfig   `json:"tags,omitempty"`
+	// Sprints configures how the iterations of the pair's work items are mirrored.
+	Sprints *sync.SprintConfig `json:"sprints,omitempty"`
+	// Board configures how the column and swimlane of the pair's work items are mirrored.
+	Board *sync.BoardConfig `json:"board,omitempty"`
+	// Types changes how the work items of the listed types are synced.
+	Types []sync.TypeRule `json:"types,omitempty"`
+	// States maps the ADO states of the pair onto the completion and status of its tasks.
+	States *sync.StateMap `json:"states,omitempty"`
+	// OptOut is the marker that freezes the pair's work items and tasks carrying it.
+	OptOut *sync.OptOut `json:"opt_out,omitempty"`
+	// Anchor is where the pair's tasks record the ID of their work item.
+	Anchor string `json:"anchor,omitempty"`
+	// BackLink is where the pair's work items link to their task.
+	BackLink string `json:"back_link,omitempty"`
+	// Effort maps the effort fields of the pair's work items onto Asana.
+	Effort *sync.EffortConfig `json:"effort,omitempty"`
+	// Rollup sums the estimates of the children of the pair's work items onto the task of their parent.
+	Rollup *sync.RollupConfig `json:"rollup,omitempty"`
+	// StatusUpdates posts status updates on the pair's Asana project summarizing the progress of its items.
+	StatusUpdates *sync.StatusUpdateConfig `json:"status_updates,omitempty"`
+	// MyTasks mirrors the work items of a set of users into their My Tasks instead of an Asana project.
+	MyTasks *sync.MyTasksConfig `json:"my_tasks,omitempty"`
+	// Priority boosts work items ahead of the others in each cycle.
+	Priority *sync.PriorityConfig `json:"priority,omitempty"`
+	// Links configures how the related, duplicate and blocked by links of the pair's work items are synced.
+	Links *sync.LinkConfig `json:"links,omitempty"`
+	// Calendar is the time zone and working calendar the pair's due dates are translated with.
+	Calendar *sync.CalendarConfig `json:"calendar,omitempty"`
+	// Intake creates work items for the pair's Asana tasks marked for intake.
+	Intake *sync.IntakeConfig `json:"intake,omitempty"`
+	// Closing configures how the tasks of the pair's closed work items are completed.
+	Closing *sync.ClosingConfig `json:"closing,omitempty"`
+	// Members configures how the pair's assignees outside the Asana project are handled.
+	Members *sync.MembersConfig `json:"members,omitempty"`
+	// Recurrence marks the pair's recurring work items.
+	Recurrence *sync.RecurrenceConfig `json:"recurrence,omitempty"`
+	// Transforms lists the transformers rewriting the pair's work items and tasks, in order.
+	Transforms []transform.Spec `json:"transforms,omitempty"`
+	// Hierarchy, Dependencies, Development, TestCases and DueDates, when set, override SYNC_HIERARCHY,
+	// SYNC_DEPENDENCIES, SYNC_DEVELOPMENT, SYNC_TEST_CASES and SYNC_DUE_DATES for the pair.
+	Hierarchy    *bool             `json:"hierarchy,omitempty"`
+	Dependencies *bool             `json:"dependencies,omitempty"`
+	Development  *bool             `json:"development,omitempty"`
+	TestCases    *bool             `json:"test_cases,omitempty"`
+	DueDates     *bool             `json:"due_dates,omitempty"`
+	Users        map[string]string `json:"users,omitempty"`
+	// Removal configures what happens to the tasks of work items that leave the pair.
+	Removal       *sync.RemovalConfig `json:"removal,omitempty"`
+	NameTemplate  string              `json:"name_template,omitempty"`
+	NotesTemplate string              `json:"notes_template,omitempty"`
+	NotesFormat   string              `json:"notes_format,omitempty"`
+	NotesOverflow string              `json:"notes_overflow,omitempty"`
+	NotesLimit    int                 `json:"notes_limit,omitempty"`
+}
+
+// Load reads and validates the configuration file at path, which is YAML when its extension is .yaml or .yml
+// and JSON otherwise. Unknown keys are rejected so misspelt settings are not silently ignored.
+func Load(path string) (*File, error) {
+	var f File
+	if err := decode(path, &f); err != nil {
+		return nil, err
+	}
+	if err := f.Validate(); err != nil {
+		return nil, fmt.Errorf("config: %s: %w", path, err)
+	}
+	return &f, nil
+}
+
+// decode reads the file at path into v, a pointer to a configuration type, from YAML when its extension is
+// .yaml or .yml and JSON otherwise, rejecting unknown keys.
+func decode(path string, v interface{}) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yaml" || ext == ".yml" {
+		if b, err = yamlToJSON(b, reflect.TypeOf(v)); err != nil {
+			return fmt.Errorf("config: parsing %s: %w", path, err)
+		}
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("config: parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+// yamlToJSON converts a YAML file holding a value of type t to JSON.
+func yamlToJSON(b []byte, t reflect.Type) ([]byte, error) {
+	var n yaml.Node
+	if err := yaml.Unmarshal(b, &n); err != nil {
+		return nil, err
+	}
+	v, err := fromYAML(&n, t)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(v)
+}
+
+// envName matches the names of environment variables.
+var envName = regexp.MustCompile(`^[A-Z_][A-Z0-9_]*$`)
+
+// Credential reports whether the environment variable name holds a secret, such as a token or password.
+// Reloading the configuration file leaves credentials alone, as the clients using them are not recreated.
+func Credential(name string) bool {
+	for _, suffix := range []string{"_PAT", "_TOKEN", "_SECRET", "_PASSWORD", "_KEY", "_KEY_ID", "_USERNAME"} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the configuration for errors that can be detected without contacting either API.
+func (f *File) Validate() error {
+	for name := range f.Env {
+		if !envName.MatchString(name) {
+			return fmt.Errorf("env: invalid variable name %q", name)
+		}
+		if name == "CONFIG_FILE" {
+			return fmt.Errorf("env: CONFIG_FILE cannot be set in the configuration file")
+		}
+	}
+	for _, m := range f.FieldMappings {
+		if err := m.Validate(); err != nil {
+			return err
+		}
+	}
+	if _, err := transform.NewChain(f.Transforms); err != nil {
+		return err
+	}
+	if f.Tags != nil {
+		if err := validateTags(*f.Tags); err != nil {
+			return err
+		}
+	}
+	if err := validateUsers(f.Users); err != nil {
+		return err
+	}
+	if f.Removal != nil {
+		if _, err := sync.ParseRemovalPolicy(string(f.Removal.Policy)); err != nil {
+			return err
+		}
+	}
+	if f.Closing != nil {
+		if err := (sync.Config{Closing: *f.Closing}).ValidateClosing(); err != nil {
+			return err
+		}
+	}
+	if f.Sprints != nil {
+		if _, err := sync.ParseSprintMode(string(f.Sprints.Mode)); err != nil {
+			return err
+		}
+	}
+	if f.Board != nil {
+		if err := (sync.Config{Board: *f.Board}).ValidateBoard(); err != nil {
+			return err
+		}
+	}
+	conns := map[string]bool{}
+	for i, c := range f.ADOConnections {
+		switch {
+		case c.Name == "":
+			return fmt.Errorf("ado connection %d has no name", i+1)
+		case conns[c.Name]:
+			return fmt.Errorf("duplicate ado connection name %q", c.Name)
+		case c.OrgURL == "":
+			return fmt.Errorf("ado connection %q: org_url is required", c.Name)
+		case c.PATEnv != "" && !envName.MatchString(c.PATEnv):
+			return fmt.Errorf("ado connection %q: invalid pat_env variable name %q", c.Name, c.PATEnv)
+		}
+		if _, err := ratelimit.ParseCaps(c.CallBudget); err != nil {
+			return fmt.Errorf("ado connection %q: %w", c.Name, err)
+		}
+		conns[c.Name] = true
+	}
+	asanaConns := map[string]bool{}
+	for i, c := range f.AsanaConnections {
+		switch {
+		case c.Name == "":
+			return fmt.Errorf("asana connection %d has no name", i+1)
+		case asanaConns[c.Name]:
+			return fmt.Errorf("duplicate asana connection name %q", c.Name)
+		case !envName.MatchString(c.TokenEnv):
+			return fmt.Errorf("asana connection %q: token_env must name an environment variable", c.Name)
+		}
+		if _, err := ratelimit.ParseCaps(c.CallBudget); err != nil {
+			return fmt.Errorf("asana connection %q: %w", c.Name, err)
+		}
+		asanaConns[c.Name] = true
+	}
+	names := map[string]bool{}
+	for i, p := range f.Pairs {
+		if p.Name == "" {
+			return fmt.Errorf("pair %d has no name", i+1)
+		}
+		if names[p.Name] {
+			return fmt.Errorf("duplicate pair name %q", p.Name)
+		}
+		if p.ADOConnection != "" && !conns[p.ADOConnection] {
+			return fmt.Errorf("pair %q: unknown ado connection %q", p.Name, p.ADOConnection)
+		}
+		if p.AsanaConnection != "" && !asanaConns[p.AsanaConnection] {
+			return fmt.Errorf("pair %q: unknown asana connection %q", p.Name, p.AsanaConnection)
+		}
+		names[p.Name] = true
+		if _, err := p.apply(sync.DefaultConfig()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SyncPairs returns the configuration of every pair, starting each from base. When the file lists no
+// pairs, base is returned as the only pair with the file's field mappings.
+func (f *File) SyncPairs(base sync.Config) ([]sync.Config, error) {
+	if len(f.FieldMappings) > 0 {
+		base.FieldMappings = f.FieldMappings
+	}
+	if len(f.Sections) > 0 {
+		base.SectionMappings = f.Sections
+	}
+	if f.Tags != nil {
+		base.Tags = *f.Tags
+	}
+	if len(f.Users) > 0 {
+		base.UserMappings = f.Users
+	}
+	if f.FuzzyUsers != nil {
+		base.FuzzyUserMatching = *f.FuzzyUsers
+	}
+	if f.Removal != nil {
+		base.Removal = *f.Removal
+		base.Removal.Policy, _ = sync.ParseRemovalPolicy(string(f.Removal.Policy))
+	}
+	if f.Closing != nil {
+		base.Closing = *f.Closing
+		base.Closing.Action, _ = sync.ParseClosingAction(string(f.Closing.Action))
+	}
+	if f.Members != nil {
+		base.Members = *f.Members
+	}
+	if f.Recurrence != nil {
+		base.Recurrence = *f.Recurrence
+	}
+	if len(f.Transforms) > 0 {
+		base.Transforms = f.Transforms
+	}
+	if f.Sprints != nil {
+		base.Sprints = *f.Sprints
+		base.Sprints.Mode, _ = sync.ParseSprintMode(string(f.Sprints.Mode))
+	}
+	if f.Board != nil {
+		base.Board = *f.Board
+	}
+	if len(f.Types) > 0 {
+		base.Types = f.Types
+	}
+	if f.States != nil {
+		base.States = *f.States
+	}
+	if f.OptOut != nil {
+		base.OptOut = *f.OptOut
+	}
+	if f.Anchor != "" {
+		base.Anchor = f.Anchor
+	}
+	if f.BackLink != "" {
+		base.BackLink = f.BackLink
+	}
+	if f.Effort != nil {
+		base.Effort = *f.Effort
+	}
+	if f.Rollup != nil {
+		base.Rollup = *f.Rollup
+	}
+	if f.StatusUpdates != nil {
+		base.StatusUpdates = *f.StatusUpdates
+	}
+	if f.MyTasks != nil {
+		base.MyTasks = *f.MyTasks
+	}
+	if f.Priority != nil {
+		base.Priority = *f.Priority
+	}
+	if f.Links != nil {
+		base.Links = *f.Links
+	}
+	if f.Calendar != nil {
+		base.Calendar = *f.Calendar
+	}
+	if f.Intake != nil {
+		base.Intake = *f.Intake
+	}
+	if f.NameTemplate != "" {
+		base.NameTemplate = f.NameTemplate
+	}
+	if f.NotesTemplate != "" {
+		base.NotesTemplate = f.NotesTemplate
+	}
+	if f.NotesFormat != "" {
+		var err error
+		if base.NotesFormat, err = sync.ParseNotesFormat(f.NotesFormat); err != nil {
+			return nil, err
+		}
+	}
+	if f.NotesOverflow != "" {
+		var err error
+		if base.NotesOverflow, err = sync.ParseOverflowPolicy(f.NotesOverflow); err != nil {
+			return nil, err
+		}
+	}
+	if f.NotesLimit != 0 {
+		base.NotesLimit = f.NotesLimit
+	}
+	if len(f.Pairs) == 0 {
+		if err := base.ValidateTemplates(); err != nil {
+			return nil, err
+		}
+		if err := base.ValidateSprints(); err != nil {
+			return nil, err
+		}
+		if err := base.ValidateBoard(); err != nil {
+			return nil, err
+		}
+		if err := base.ValidateTypes(); err != nil {
+			return nil, err
+		}
+		if err := base.ValidateStates(); err != nil {
+			return nil, err
+		}
+		if err := base.ValidateEffort(); err != nil {
+			return nil, err
+		}
+		if err := base.ValidateRollup(); err != nil {
+			return nil, err
+		}
+		if err := base.ValidateLinks(); err != nil {
+			return nil, err
+		}
+		if err := base.ValidateCalendar(); err != nil {
+			return nil, err
+		}
+		if err := base.ValidateIntake(); err != nil {
+			return nil, err
+		}
+	}
+	if len(f.Pairs) == 0 {
+		return []sync.Config{base}, nil
+	}
+	pairs := make([]sync.Config, 0, len(f.Pairs))
+	for _, p := range f.Pairs {
+		cfg, err := p.apply(base)
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, cfg)
+	}
+	return pairs, nil
+}
+
+// apply returns base overridden with the settings of p.
+func (p Pair) apply(cfg sync.Config) (sync.Config, error) {
+	cfg.Name = p.Name
+	cfg.ADOConnection = p.ADOConnection
+	cfg.AsanaConnection = p.AsanaConnection
+	cfg.ADOProject = p.ADOProject
+	cfg.AsanaProject = p.AsanaProject
+	cfg.Routes = p.Routes
+	cfg.Query = p.Query
+	if p.ADOProject == "" {
+		return cfg, fmt.Errorf("pair %q: ado_project is required", p.Name)
+	}
+	if err := cfg.ValidateRoutes(); err != nil {
+		return cfg, fmt.Errorf("pair %q: %w", p.Name, err)
+	}
+	if p.Provision != nil {
+		cfg.Provision = *p.Provision
+	}
+	if p.AsanaWorkspace != "" {
+		cfg.AsanaWorkspace = p.AsanaWorkspace
+	}
+	if err := sync.ValidateQuery(p.Query); err != nil {
+		return cfg, fmt.Errorf("pair %q: %w", p.Name, err)
+	}
+
+	var err error
+	if p.Interval != "" {
+		if cfg.Interval, err = time.ParseDuration(p.Interval); err != nil {
+			return cfg, fmt.Errorf("pair %q: invalid interval: %w", p.Name, err)
+		}
+		// An interval set for the pair replaces a schedule from the environment.
+		cfg.Schedule = nil
+	}
+	if p.Schedule != "" {
+		if cfg.Schedule, err = schedule.Parse(p.Schedule); err != nil {
+			return cfg, fmt.Errorf("pair %q: %w", p.Name, err)
+		}
+	}
+	if p.Jitter != "" {
+		if cfg.Jitter, err = time.ParseDuration(p.Jitter); err != nil {
+			return cfg, fmt.Errorf("pair %q: invalid jitter: %w", p.Name, err)
+		}
+	}
+	if p.Maintenance != nil {
+		cfg.Maintenance = nil
+		for _, m := range p.Maintenance {
+			w, err := schedule.ParseWindow(m)
+			if err != nil {
+				return cfg, fmt.Errorf("pair %q: %w", p.Name, err)
+			}
+			cfg.Maintenance = append(cfg.Maintenance, w)
+		}
+	}
+	if p.Workers < 0 {
+		return cfg, fmt.Errorf("pair %q: workers must be positive", p.Name)
+	}
+	if p.Workers > 0 {
+		cfg.Workers = p.Workers
+	}
+	if p.Prefetch < 0 {
+		return cfg, fmt.Errorf("pair %q: prefetch must be positive", p.Name)
+	}
+	if p.Prefetch > 0 {
+		cfg.Prefetch = p.Prefetch
+	}
+	if p.QueryPage < 0 || p.QueryPage > ado.MaxQueryResults {
+		return cfg, fmt.Errorf("pair %q: query_page must be between 1 and %d", p.Name, ado.MaxQueryResults)
+	}
+	if p.QueryPage > 0 {
+		cfg.QueryPage = p.QueryPage
+	}
+	if p.CallBudget != "" {
+		if cfg.CallBudget, err = ratelimit.ParseCaps(p.CallBudget); err != nil {
+			return cfg, fmt.Errorf("pair %q: %w", p.Name, err)
+		}
+	}
+	if p.Incremental != nil {
+		cfg.Incremental = *p.Incremental
+	}
+	if p.FullSyncInterval != "" {
+		if cfg.FullSyncInterval, err = time.ParseDuration(p.FullSyncInterval); err != nil {
+			return cfg, fmt.Errorf("pair %q: invalid full_sync_interval: %w", p.Name, err)
+		}
+	}
+	if p.DriftInterval != "" {
+		if cfg.DriftInterval, err = time.ParseDuration(p.DriftInterval); err != nil {
+			return cfg, fmt.Errorf("pair %q: invalid drift_interval: %w", p.Name, err)
+		}
+	}
+	if p.DriftRepair != nil {
+		cfg.DriftRepair = *p.DriftRepair
+	}
+	if p.Direction != "" {
+		if cfg.Direction, err = sync.ParseDirection(p.Direction); err != nil {
+			return cfg, fmt.Errorf("pair %q: %w", p.Name, err)
+		}
+	}
+	if p.FieldDirections != "" {
+		if cfg.FieldDirections, err = sync.ParseFieldDirections(p.FieldDirections); err != nil {
+			return cfg, fmt.Errorf("pair %q: %w", p.Name, err)
+		}
+	}
+	if p.ConflictStrategy != "" {
+		if cfg.ConflictStrategy, err = sync.ParseConflictStrategy(p.ConflictStrategy); err != nil {
+			return cfg, fmt.Errorf("pair %q: %w", p.Name, err)
+		}
+	}
+	if cfg.CommentDirection, err = mirrorDirection(p.Comments, cfg.CommentDirection); err != nil {
+		return cfg, fmt.Errorf("pair %q: comments: %w", p.Name, err)
+	}
+	if cfg.AttachmentDirection, err = mirrorDirection(p.Attachments, cfg.AttachmentDirection); err != nil {
+		return cfg, fmt.Errorf("pair %q: attachments: %w", p.Name, err)
+	}
+	if len(p.FieldMappings) > 0 {
+		for _, m := range p.FieldMappings {
+			if err := m.Validate(); err != nil {
+				return cfg, fmt.Errorf("pair %q: %w", p.Name, err)
+			}
+		}
+		cfg.FieldMappings = p.FieldMappings
+	}
+	if p.ManageSchema != nil {
+		cfg.ManageSchema = *p.ManageSchema
+	}
+	if len(p.Sections) > 0 {
+		cfg.SectionMappings = p.Sections
+	}
+	if p.Tags != nil {
+		if err := validateTags(*p.Tags); err != nil {
+			return cfg, fmt.Errorf("pair %q: %w", p.Name, err)
+		}
+		cfg.Tags = *p.Tags
+	}
+	if p.Sprints != nil {
+		cfg.Sprints = *p.Sprints
+		if cfg.Sprints.Mode, err = sync.ParseSprintMode(string(p.Sprints.Mode)); err != nil {
+			return cfg, fmt.Errorf("pair %q: %w", p.Name, err)
+		}
+	}
+	if err := cfg.ValidateSprints(); err != nil {
+		return cfg, fmt.Errorf("pair %q: %w", p.Name, err)
+	}
+	if p.Board != nil {
+		cfg.Board = *p.Board
+	}
+	if err := cfg.ValidateBoard(); err != nil {
+		return cfg, fmt.Errorf("pair %q: %w", p.Name, err)
+	}
+	if p.Hierarchy != nil {
+		cfg.Hierarchy = *p.Hierarchy
+	}
+	if p.Dependencies != nil {
+		cfg.Dependencies = *p.Dependencies
+	}
+	if p.Development != nil {
+		cfg.Development = *p.Development
+	}
+	if p.TestCases != nil {
+		cfg.TestCases = *p.TestCases
+	}
+	if p.DueDates != nil {
+		cfg.DueDates = *p.DueDates
+	}
+	if err := validateUsers(p.Users); err != nil {
+		return cfg, fmt.Errorf("pair %q: %w", p.Name, err)
+	}
+	if len(p.Users) > 0 {
+		cfg.UserMappings = p.Users
+	}
+	if p.Removal != nil {
+		cfg.Removal = *p.Removal
+		if cfg.Removal.Policy, err = sync.ParseRemovalPolicy(string(p.Removal.Policy)); err != nil {
+			return cfg, fmt.Errorf("pair %q: %w", p.Name, err)
+		}
+	}
+	if p.Closing != nil {
+		cfg.Closing = *p.Closing
+		if cfg.Closing.Action, err = sync.ParseClosingAction(string(p.Closing.Action)); err != nil {
+			return cfg, fmt.Errorf("pair %q: %w", p.Name, err)
+		}
+	}
+	if err := cfg.ValidateClosing(); err != nil {
+		return cfg, fmt.Errorf("pair %q: %w", p.Name, err)
+	}
+	if p.Members != nil {
+		cfg.Members = *p.Members
+	}
+	if p.Recurrence != nil {
+		cfg.Recurrence = *p.Recurrence
+	}
+	if len(p.Transforms) > 0 {
+		if _, err := transform.NewChain(p.Transforms); err != nil {
+			return cfg, fmt.Errorf("pair %q: %w", p.Name, err)
+		}
+		cfg.Transforms = p.Transforms
+	}
+	if p.NameTemplate != "" {
+		cfg.NameTemplate = p.NameTemplate
+	}
+	if p.NotesTemplate != "" {
+		cfg.NotesTemplate = p.NotesTemplate
+	}
+	if p.NotesFormat != "" {
+		if cfg.NotesFormat, err = sync.ParseNotesFormat(p.NotesFormat); err != nil {
+			return cfg, fmt.Errorf("pair %q: %w", p.Name, err)
+		}
+	}
+	if p.NotesOverflow != "" {
+		if cfg.NotesOverflow, err = sync.ParseOverflowPolicy(p.NotesOverflow); err != nil {
+			return cfg, fmt.Errorf("pair %q: %w", p.Name, err)
+		}
+	}
+	if p.NotesLimit != 0 {
+		cfg.NotesLimit = p.NotesLimit
+	}
+	if err := cfg.ValidateTemplates(); err != nil {
+		return cfg, fmt.Errorf("pair %q: %w", p.Name, err)
+	}
+	if len(p.Types) > 0 {
+		cfg.Types = p.Types
+	}
+	if err := cfg.ValidateTypes(); err != nil {
+		return cfg, fmt.Errorf("pair %q: %w", p.Name, err)
+	}
+	if p.States != nil {
+		cfg.States = *p.States
+	}
+	if p.OptOut != nil {
+		cfg.OptOut = *p.OptOut
+	}
+	if p.Anchor != "" {
+		cfg.Anchor = p.Anchor
+	}
+	if p.BackLink != "" {
+		cfg.BackLink = p.BackLink
+	}
+	if p.Effort != nil {
+		cfg.Effort = *p.Effort
+	}
+	if p.Rollup != nil {
+		cfg.Rollup = *p.Rollup
+	}
+	if p.StatusUpdates != nil {
+		cfg.StatusUpdates = *p.StatusUpdates
+	}
+	if p.MyTasks != nil {
+		cfg.MyTasks = *p.MyTasks
+	}
+	if p.Priority != nil {
+		cfg.Priority = *p.Priority
+	}
+	if p.Links != nil {
+		cfg.Links = *p.Links
+	}
+	if p.Calendar != nil {
+		cfg.Calendar = *p.Calendar
+	}
+	if p.Intake != nil {
+		cfg.Intake = *p.Intake
+	}
+	if err := cfg.ValidateStates(); err != nil {
+		return cfg, fmt.Errorf("pair %q: %w", p.Name, err)
+	}
+	if err := cfg.ValidateEffort(); err != nil {
+		return cfg, fmt.Errorf("pair %q: %w", p.Name, err)
+	}
+	if err := cfg.ValidateRollup(); err != nil {
+		return cfg, fmt.Errorf("pair %q: %w", p.Name, err)
+	}
+	if err := cfg.ValidateLinks(); err != nil {
+		return cfg, fmt.Errorf("pair %q: %w", p.Name, err)
+	}
+	if err := cfg.ValidateCalendar(); err != nil {
+		return cfg, fmt.Errorf("pair %q: %w", p.Name, err)
+	}
+	if err := cfg.ValidateIntake(); err != nil {
+		return cfg, fmt.Errorf("pair %q: %w", p.Name, err)
+	}
+	return cfg, nil
+}
+
+// validateTags checks the direction and patterns of a tag configuration.
+func validateTags(t sync.TagConfig) error {
+	if t.Direction != "" {
+		if _, err := sync.ParseDirection(string(t.Direction)); err != nil {
+			return fmt.Errorf("tags: %w", err)
+		}
+	}
+	return t.Validate()
+}
+
+// validateUsers checks that every user mapping names an Asana user.
+func validateUsers(users map[string]string) error {
+	for name, gid := range users {
+		if name == "" || gid == "" {
+			return fmt.Errorf("users: invalid mapping %q=%q", name, gid)
+		}
+	}
+	return nil
+}
+
+// mirrorDirection parses the comment or attachment direction s, keeping def when s is empty.
+func mirrorDirection(s string, def sync.Direction) (sync.Direction, error) {
+	switch s {
+	case "":
+		return def, nil
+	case "none":
+		return "", nil
+	default:
+		return sync.ParseDirection(s)
+	}
+}
@@ -0,0 +1,192 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/danstis/ado-asana-sync/internal/ratelimit"
+	"github.com/danstis/ado-asana-sync/internal/secret"
+	"github.com/danstis/ado-asana-sync/internal/sync"
+	"github.com/danstis/ado-asana-sync/internal/transform"
+)
+
+// Tenant is the contents of the file of a tenant, a team whose pairs the service syncs with credentials and
+// a mapping store of their own. It is a configuration file without the settings that read the environment
+// or the file system of the service, plus the credentials of the tenant.
+type Tenant struct {
+	// ADOOrgURL is the URL of the organization of the tenant, for example https://dev.azure.com/fabrikam.
+	// It must be one of Azure DevOps Services.
+	ADOOrgURL string `json:"ado_org_url"`
+	// ADOPAT is the PAT of the organization, or a Vault reference to a secret of the tenant, see
+	// tenantSecret.
+	ADOPAT string `json:"ado_pat"`
+	// ADOCallBudget caps the API requests to the organization, for example "600/m".
+	ADOCallBudget string `json:"ado_call_budget,omitempty"`
+	// AsanaToken is the personal access token of the Asana account of the tenant, or a Vault reference to a
+	// secret of the tenant.
+	AsanaToken string `json:"asana_token"`
+	// AsanaCallBudget caps the API requests of the account, for example "150/m".
+	AsanaCallBudget string `json:"asana_call_budget,omitempty"`
+	// CallBudget caps the API requests of every pair of the tenant together, for example "1000/m,20000/h".
+	CallBudget string `json:"call_budget,omitempty"`
+	File
+}
+
+// tenantName is the form of the names of tenants, which name their file, their store and their metrics.
+var tenantName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// ValidTenantName reports whether name can name a tenant.
+func ValidTenantName(name string) bool {
+	return tenantName.MatchString(name)
+}
+
+// TenantName returns the name of the tenant whose file is at path, its base name without the extension.
+func TenantName(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+}
+
+// LoadTenant reads and validates the file of a tenant at path, read like Load reads configuration files.
+func LoadTenant(path string) (*Tenant, error) {
+	var t Tenant
+	if err := decode(path, &t); err != nil {
+		return nil, err
+	}
+	if err := t.Validate(TenantName(path)); err != nil {
+		return nil, fmt.Errorf("config: %s: %w", path, err)
+	}
+	return &t, nil
+}
+
+// ParseTenant parses and validates the JSON of the file of the tenant named name, rejecting unknown keys.
+func ParseTenant(name string, b []byte) (*Tenant, error) {
+	var t Tenant
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&t); err != nil {
+		return nil, fmt.Errorf("config: parsing tenant: %w", err)
+	}
+	if err := t.Validate(name); err != nil {
+		return nil, fmt.Errorf("config: tenant: %w", err)
+	}
+	return &t, nil
+}
+
+// Validate checks the file of the tenant named name. Besides the checks of File.Validate, it requires the
+// credentials and pairs of the tenant and rejects the settings the service must not take from a tenant: env,
+// which configures the service, the connections, whose credentials are read from its environment, the exec
+// transformer, which runs commands on its host, and organizations and secret references that would hand
+// the secrets of the service to the tenant.
+func (t *Tenant) Validate(name string) error {
+	switch {
+	case t.ADOOrgURL == "":
+		return errors.New("ado_org_url is required")
+	case !azureDevOpsURL(t.ADOOrgURL):
+		return fmt.Errorf("ado_org_url %q is not an organization of Azure DevOps Services", t.ADOOrgURL)
+	case t.ADOPAT == "":
+		return errors.New("ado_pat is required")
+	case t.AsanaToken == "":
+		return errors.New("asana_token is required")
+	case len(t.Env) > 0:
+		return errors.New("env cannot be set for a tenant")
+	case len(t.ADOConnections) > 0 || len(t.AsanaConnections) > 0:
+		return errors.New("a tenant syncs with its own organization and account, so it cannot list connections")
+	case len(t.Pairs) == 0:
+		return errors.New("a tenant must list its pairs")
+	}
+	if err := tenantSecret(name, "ado_pat", t.ADOPAT); err != nil {
+		return err
+	}
+	if err := tenantSecret(name, "asana_token", t.AsanaToken); err != nil {
+		return err
+	}
+	for _, budget := range []string{t.ADOCallBudget, t.AsanaCallBudget, t.CallBudget} {
+		if _, err := ratelimit.ParseCaps(budget); err != nil {
+			return err
+		}
+	}
+	if err := tenantTransforms(t.Transforms); err != nil {
+		return err
+	}
+	for _, p := range t.Pairs {
+		if err := tenantTransforms(p.Transforms); err != nil {
+			return fmt.Errorf("pair %q: %w", p.Name, err)
+		}
+	}
+	return t.File.Validate()
+}
+
+// azureDevOpsURL reports whether u is the URL of an organization of Azure DevOps Services, which the PAT of
+// a tenant is sent to.
+func azureDevOpsURL(u string) bool {
+	p, err := url.Parse(u)
+	if err != nil || p.Scheme != "https" || p.User != nil || p.Port() != "" {
+		return false
+	}
+	host := strings.ToLower(p.Hostname())
+	return host == "dev.azure.com" && strings.Trim(p.Path, "/") != "" || strings.HasSuffix(host, ".visualstudio.com")
+}
+
+// tenantSecret checks that the credential of the tenant named name, when it is a secret reference, refers
+// to a secret of the tenant. The service resolves references with its own identity, so a tenant could
+// otherwise read the secrets of the service and of other tenants. Only Vault references are allowed, to a
+// path under tenants/<name> of any mount; Key Vault references name the vault the service signs in to,
+// which the tenant would choose.
+func tenantSecret(name, key, value string) error {
+	if !secret.IsReference(value) {
+		return nil
+	}
+	ref, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	if ref.Scheme != secret.SchemeVault {
+		return fmt.Errorf("%s: a tenant can only refer to secrets in Vault", key)
+	}
+	segments := strings.Split(strings.TrimPrefix(ref.Path, "/"), "/")
+	for _, s := range segments {
+		if s == "" || s == "." || s == ".." {
+			return fmt.Errorf("%s: invalid secret path %q", key, ref.Path)
+		}
+	}
+	if len(segments) < 2 || segments[0] != "tenants" || segments[1] != name {
+		return fmt.Errorf("%s: a tenant can only refer to secrets under tenants/%s", key, name)
+	}
+	return nil
+}
+
+// tenantTransforms rejects the transformers a tenant cannot run.
+func tenantTransforms(specs []transform.Spec) error {
+	for _, s := range specs {
+		if s.Name == "exec" {
+			return errors.New("the exec transformer cannot be used by a tenant")
+		}
+	}
+	return nil
+}
+
+// SyncPairs returns the sync configuration of every pair of the tenant named name, as File.SyncPairs does,
+// syncing with the connections of the tenant. The names of the pairs are prefixed with that of the tenant,
+// so logs and metrics tell tenants apart.
+func (t *Tenant) SyncPairs(name string, base sync.Config) ([]sync.Config, error) {
+	pairs, err := t.File.SyncPairs(base)
+	if err != nil {
+		return nil, err
+	}
+	for i := range pairs {
+		pairs[i].Name = name + "/" + pairs[i].Name
+		pairs[i].ADOConnection, pairs[i].AsanaConnection = name, name
+	}
+	return pairs, nil
+}
+
+// Connections returns the ADO and Asana connections of the tenant named name, which are named after it.
+func (t *Tenant) Connections(name string) (ADOConnection, AsanaConnection) {
+	return ADOConnection{Name: name, OrgURL: t.ADOOrgURL, PAT: t.ADOPAT, CallBudget: t.ADOCallBudget},
+		AsanaConnection{Name: name, Token: t.AsanaToken, CallBudget: t.AsanaCallBudget}
+}
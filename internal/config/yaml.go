@@ -0,0 +1,114 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fromYAML converts the YAML node n into a value that encodes to the JSON of a value of type t, so YAML
+// files are read through the json tags of the configuration types. Scalars read into strings keep their
+// text, so GIDs need no quotes, and unknown keys are reported with their line.
+func fromYAML(n *yaml.Node, t reflect.Type) (interface{}, error) {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			return nil, nil
+		}
+		return fromYAML(n.Content[0], t)
+	case yaml.AliasNode:
+		return fromYAML(n.Alias, t)
+	}
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!null" {
+		return nil, nil
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if n.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("line %d: expected a mapping", n.Line)
+		}
+		fields := jsonFields(t)
+		m := make(map[string]interface{}, len(n.Content)/2)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k := n.Content[i]
+			ft, ok := fields[k.Value]
+			if !ok {
+				return nil, fmt.Errorf("line %d: unknown key %q", k.Line, k.Value)
+			}
+			v, err := fromYAML(n.Content[i+1], ft)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k.Value, err)
+			}
+			m[k.Value] = v
+		}
+		return m, nil
+	case reflect.Map:
+		if n.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("line %d: expected a mapping", n.Line)
+		}
+		m := make(map[string]interface{}, len(n.Content)/2)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k := n.Content[i]
+			v, err := fromYAML(n.Content[i+1], t.Elem())
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k.Value, err)
+			}
+			m[k.Value] = v
+		}
+		return m, nil
+	case reflect.Slice:
+		if n.Kind != yaml.SequenceNode {
+			return nil, fmt.Errorf("line %d: expected a list", n.Line)
+		}
+		s := make([]interface{}, 0, len(n.Content))
+		for i, c := range n.Content {
+			v, err := fromYAML(c, t.Elem())
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			s = append(s, v)
+		}
+		return s, nil
+	case reflect.String:
+		if n.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("line %d: expected a string", n.Line)
+		}
+		return n.Value, nil
+	default:
+		var v interface{}
+		if err := n.Decode(&v); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n.Line, err)
+		}
+		return v, nil
+	}
+}
+
+// jsonFields maps the JSON keys of the fields of the struct type t to their types.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if name == "" && f.Anonymous && f.Type.Kind() == reflect.Struct {
+			// The fields of embedded structs are read as those of t, as encoding/json does.
+			for n, ft := range jsonFields(f.Type) {
+				fields[n] = ft
+			}
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
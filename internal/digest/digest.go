@@ -0,0 +1,282 @@
+// Package digest emails periodic summaries of the sync activity of every pair: the tasks it created and
+// completed, the conflicts awaiting resolution and the work items that keep failing to sync.
+package digest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"sort"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/schedule"
+	"github.com/danstis/ado-asana-sync/internal/store"
+	syncer "github.com/danstis/ado-asana-sync/internal/sync"
+)
+
+// sentKey is the store setting holding the end of the period of the last digest sent, in RFC 3339.
+const sentKey = "digest_sent"
+
+// Pair is a sync pair summarized by digests, with the store holding its mappings.
+type Pair struct {
+	Name         string
+	ADOProject   string
+	AsanaProject string
+	Store        store.Store
+}
+
+// Task is a task the sync created or completed during the period of a digest.
+type Task struct {
+	ADOID    int
+	AsanaGID string
+	// Title is the title of the work item when it was last synced.
+	Title string
+	Time  time.Time
+}
+
+// Failure is a work item waiting for another attempt after failing to sync.
+type Failure struct {
+	ADOID    int
+	Title    string
+	Attempts int
+	Error    string
+	// Since is when the work item first failed.
+	Since time.Time
+}
+
+// PairReport is the activity of a pair during the period of a digest.
+type PairReport struct {
+	Name         string
+	ADOProject   string
+	AsanaProject string
+	// Cycles is the number of sync cycles recorded in the period, of which FailedCycles failed.
+	Cycles, FailedCycles int
+	Created              []Task
+	Completed            []Task
+	// Conflicts are the conflicts of the pair awaiting manual resolution, whenever they were found.
+	Conflicts []store.Conflict
+	// Failing are the work items of the pair waiting for a retry.
+	Failing []Failure
+}
+
+// Empty reports whether nothing happened to the pair during the period and nothing awaits attention.
+func (p PairReport) Empty() bool {
+	return len(p.Created) == 0 && len(p.Completed) == 0 && len(p.Conflicts) == 0 && len(p.Failing) == 0
+}
+
+// Report is the data given to the templates of a digest.
+type Report struct {
+	Since, Until time.Time
+	Pairs        []PairReport
+}
+
+// Build returns the report of the activity of the pairs between since and until. Created and completed
+// tasks are read from the audit log, so pairs that do not audit their writes show none.
+func Build(ctx context.Context, pairs []Pair, since, until time.Time) (*Report, error) {
+	r := &Report{Since: since, Until: until}
+	for _, p := range pairs {
+		pr, err := buildPair(ctx, p, since, until)
+		if err != nil {
+			return nil, fmt.Errorf("pair %q: %w", p.Name, err)
+		}
+		r.Pairs = append(r.Pairs, *pr)
+	}
+	return r, nil
+}
+
+func buildPair(ctx context.Context, p Pair, since, until time.Time) (*PairReport, error) {
+	pr := &PairReport{Name: p.Name, ADOProject: p.ADOProject, AsanaProject: p.AsanaProject}
+	cycles, err := syncer.RecentCycles(ctx, p.Store, p.Name, syncer.HistoryLength)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+	for _, c := range cycles {
+		if c.Started.Before(since) || !c.Started.Before(until) {
+			continue
+		}
+		pr.Cycles++
+		if c.Error != "" {
+			pr.FailedCycles++
+		}
+	}
+
+	titles := map[int]string{}
+	title := func(id int) string {
+		if t, ok := titles[id]; ok {
+			return t
+		}
+		if m, err := p.Store.Get(ctx, id); err == nil {
+			titles[id] = m.Title
+		}
+		return titles[id]
+	}
+	owned := func(id int) bool {
+		m, err := p.Store.Get(ctx, id)
+		return err == nil && (m.Pair == p.Name || m.Pair == "")
+	}
+
+	records, err := p.Store.Audit(ctx, store.AuditFilter{Pair: p.Name, Since: since})
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range records {
+		if a.System != syncer.SystemAsana || a.ADOID == 0 || a.AsanaGID == "" || !a.Time.Before(until) {
+			continue
+		}
+		t := Task{ADOID: a.ADOID, AsanaGID: a.AsanaGID, Title: title(a.ADOID), Time: a.Time}
+		switch syncer.Action(a.Action) {
+		case syncer.ActionCreate:
+			pr.Created = append(pr.Created, t)
+		case syncer.ActionClose:
+			pr.Completed = append(pr.Completed, t)
+		}
+	}
+
+	conflicts, err := p.Store.Conflicts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range conflicts {
+		if owned(c.ADOID) {
+			pr.Conflicts = append(pr.Conflicts, c)
+		}
+	}
+	sort.Slice(pr.Conflicts, func(i, j int) bool { return pr.Conflicts[i].DetectedAt.Before(pr.Conflicts[j].DetectedAt) })
+
+	retries, err := p.Store.Retries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range retries {
+		// Items queued by a pause or an outage have not failed.
+		if r.Pair != p.Name || r.Attempts == 0 {
+			continue
+		}
+		pr.Failing = append(pr.Failing, Failure{ADOID: r.ADOID, Title: title(r.ADOID), Attempts: r.Attempts, Error: r.LastError, Since: r.FirstFailed})
+	}
+	sort.Slice(pr.Failing, func(i, j int) bool { return pr.Failing[i].Since.Before(pr.Failing[j].Since) })
+	return pr, nil
+}
+
+// DefaultSubject is the template of the subject of digests that do not set one.
+const DefaultSubject = `Sync digest {{.Since.Format "2 Jan"}} to {{.Until.Format "2 Jan 2006"}}`
+
+// DefaultBody is the HTML template of the body of digests that do not set one.
+const DefaultBody = `<html><body>
+<h2>Sync digest</h2>
+<p>Activity from {{.Since.Format "Mon 2 Jan 15:04"}} to {{.Until.Format "Mon 2 Jan 2006 15:04 MST"}}.</p>
+{{range .Pairs}}
+<h3>{{.Name}}: {{.ADOProject}}</h3>
+<p>{{.Cycles}} sync cycles{{if .FailedCycles}}, {{.FailedCycles}} failed{{end}}.</p>
+{{if .Empty}}<p>Nothing to report.</p>{{end}}
+{{with .Created}}<h4>Created tasks ({{len .}})</h4><ul>{{range .}}<li><a href="https://app.asana.com/0/0/{{.AsanaGID}}">AB#{{.ADOID}} {{.Title}}</a></li>{{end}}</ul>{{end}}
+{{with .Completed}}<h4>Completed tasks ({{len .}})</h4><ul>{{range .}}<li><a href="https://app.asana.com/0/0/{{.AsanaGID}}">AB#{{.ADOID}} {{.Title}}</a></li>{{end}}</ul>{{end}}
+{{with .Conflicts}}<h4>Conflicts awaiting resolution ({{len .}})</h4><ul>{{range .}}<li>AB#{{.ADOID}} {{.Field}}: {{.ADOValue}} in ADO, {{.AsanaValue}} in Asana, since {{.DetectedAt.Format "2 Jan"}}</li>{{end}}</ul>{{end}}
+{{with .Failing}}<h4>Failing work items ({{len .}})</h4><ul>{{range .}}<li>AB#{{.ADOID}} {{.Title}}: {{.Attempts}} attempts since {{.Since.Format "2 Jan 15:04"}}: {{.Error}}</li>{{end}}</ul>{{end}}
+{{end}}
+</body></html>`
+
+// ParseSubject parses the text/template rendering the subject of digests from a Report.
+func ParseSubject(text string) (*texttemplate.Template, error) {
+	t, err := texttemplate.New("subject").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid digest subject template: %w", err)
+	}
+	return t, nil
+}
+
+// ParseBody parses the html/template rendering the body of digests from a Report.
+func ParseBody(text string) (*template.Template, error) {
+	t, err := template.New("body").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid digest template: %w", err)
+	}
+	return t, nil
+}
+
+// Digester sends the digests of the activity of pairs by email.
+type Digester struct {
+	Pairs  []Pair
+	Sender Sender
+	// From and To are the sender and recipients of the digests.
+	From string
+	To   []string
+	// Subject and Body render the digests, defaulting to DefaultSubject and DefaultBody.
+	Subject *texttemplate.Template
+	Body    *template.Template
+}
+
+// Render returns the message of the digest of the activity between since and until.
+func (d *Digester) Render(ctx context.Context, since, until time.Time) (Message, error) {
+	r, err := Build(ctx, d.Pairs, since, until)
+	if err != nil {
+		return Message{}, err
+	}
+	subject, body := d.Subject, d.Body
+	if subject == nil {
+		subject = texttemplate.Must(ParseSubject(DefaultSubject))
+	}
+	if body == nil {
+		body = template.Must(ParseBody(DefaultBody))
+	}
+	var s, b bytes.Buffer
+	if err := subject.Execute(&s, r); err != nil {
+		return Message{}, fmt.Errorf("rendering digest subject: %w", err)
+	}
+	if err := body.Execute(&b, r); err != nil {
+		return Message{}, fmt.Errorf("rendering digest: %w", err)
+	}
+	return Message{From: d.From, To: d.To, Subject: s.String(), HTML: b.String()}, nil
+}
+
+// Send sends the digest of the activity between since and until.
+func (d *Digester) Send(ctx context.Context, since, until time.Time) error {
+	msg, err := d.Render(ctx, since, until)
+	if err != nil {
+		return err
+	}
+	if err := d.Sender.Send(ctx, msg); err != nil {
+		return fmt.Errorf("sending digest: %w", err)
+	}
+	return nil
+}
+
+// Run sends a digest on every run of sched until ctx is cancelled, covering the time since the previous
+// digest. The end of the period of the last digest is kept in st, so a restart neither skips nor repeats
+// a period; the first digest covers the time since the previous run of sched. While active reports false,
+// as on a standby replica, no digest is sent.
+func (d *Digester) Run(ctx context.Context, sched schedule.Schedule, st store.Store, active func() bool) {
+	for {
+		now := time.Now()
+		next := sched.Next(now)
+		if next.IsZero() {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+		if active != nil && !active() {
+			continue
+		}
+		since := now.Add(-schedule.Gap(sched, now))
+		if v, err := st.Setting(ctx, sentKey); err == nil {
+			if t, err := time.Parse(time.RFC3339, v); err == nil {
+				since = t
+			}
+		}
+		if err := d.Send(ctx, since, next); err != nil {
+			slog.Error("failed to send sync digest", "error", err)
+			continue
+		}
+		slog.Info("sent sync digest", "recipients", len(d.To))
+		if err := st.SetSetting(ctx, sentKey, next.UTC().Format(time.RFC3339)); err != nil {
+			slog.Error("failed to record sync digest", "error", err)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package digest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/secret"
+)
+
+// sendTimeout bounds the sending of a digest.
+const sendTimeout = 30 * time.Second
+
+// Message is an HTML email.
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	HTML    string
+}
+
+// Sender sends emails.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SMTP sends emails through an SMTP server, upgrading the connection with STARTTLS when the server offers it.
+type SMTP struct {
+	// Addr is the host:port of the server.
+	Addr string
+	// Username and Password authenticate to the server with PLAIN authentication when Username is set.
+	// Passwords, when set, supplies the password instead, for passwords held in a secret manager.
+	Username  string
+	Password  string
+	Passwords secret.TokenSource
+}
+
+// Send sends msg through the server.
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		password := s.Password
+		if s.Passwords != nil {
+			var err error
+			if password, err = s.Passwords.Token(ctx); err != nil {
+				return err
+			}
+		}
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return fmt.Errorf("invalid SMTP address %q: %w", s.Addr, err)
+		}
+		auth = smtp.PlainAuth("", s.Username, password, host)
+	}
+	// smtp.SendMail takes no context, so a cancelled send is abandoned rather than interrupted.
+	done := make(chan error, 1)
+	go func() { done <- smtp.SendMail(s.Addr, auth, msg.From, msg.To, mimeMessage(msg)) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(sendTimeout):
+		return errors.New("SMTP server did not respond")
+	}
+}
+
+// mimeMessage returns msg as an RFC 5322 message with an HTML body.
+func mimeMessage(msg Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", msg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(msg.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\nContent-Type: text/html; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.HTML, "\r\n", "\n"), "\n", "\r\n"))
+	return b.Bytes()
+}
+
+// DefaultSendGridURL is the SendGrid API endpoint sending emails.
+const DefaultSendGridURL = "https://api.sendgrid.com/v3/mail/send"
+
+// SendGrid sends emails through the SendGrid API.
+type SendGrid struct {
+	// APIKey authenticates to SendGrid. APIKeys, when set, supplies it instead, for keys held in a secret
+	// manager.
+	APIKey  string
+	APIKeys secret.TokenSource
+	// URL is the endpoint posted to, defaulting to DefaultSendGridURL.
+	URL string
+	// Client posts the emails, defaulting to a client giving up after sendTimeout.
+	Client *http.Client
+}
+
+// Send sends msg through SendGrid.
+func (s *SendGrid) Send(ctx context.Context, msg Message) error {
+	key := s.APIKey
+	if s.APIKeys != nil {
+		var err error
+		if key, err = s.APIKeys.Token(ctx); err != nil {
+			return err
+		}
+	}
+	to := make([]map[string]string, 0, len(msg.To))
+	for _, addr := range msg.To {
+		to = append(to, map[string]string{"email": addr})
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"personalizations": []map[string]interface{}{{"to": to}},
+		"from":             map[string]string{"email": msg.From},
+		"subject":          msg.Subject,
+		"content":          []map[string]string{{"type": "text/html", "value": msg.HTML}},
+	})
+	if err != nil {
+		return err
+	}
+	url := s.URL
+	if url == "" {
+		url = DefaultSendGridURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+key)
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: sendTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("sendgrid responded %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
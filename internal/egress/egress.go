@@ -0,0 +1,132 @@
+// Package egress configures how requests leave the app on corporate networks: through an HTTP or HTTPS
+// proxy that may require credentials, trusting a private CA, and presenting a TLS client certificate.
+package egress
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// base is the transport of the standard library, from before Apply replaces it.
+var base = http.DefaultTransport.(*http.Transport).Clone()
+
+// Options are the network settings of a set of HTTP clients. Zero fields keep the behaviour of the
+// standard library.
+type Options struct {
+	// ProxyURL is the proxy requests are sent through, for example http://proxy.example.com:3128, with its
+	// credentials as user info when it requires them. HTTPS_PROXY, HTTP_PROXY and NO_PROXY are used when
+	// empty, and hosts listed in NO_PROXY bypass the proxy either way.
+	ProxyURL string `json:"proxy_url,omitempty"`
+	// ProxyPasswordEnv names the environment variable holding the password of the proxy user, which keeps
+	// the password out of ProxyURL.
+	ProxyPasswordEnv string `json:"proxy_password_env,omitempty"`
+	// CAFile is a PEM bundle of the CA certificates trusted in addition to the system roots, such as that of
+	// a proxy inspecting TLS.
+	CAFile string `json:"tls_ca_file,omitempty"`
+	// CertFile and KeyFile are the PEM certificate and key presented to servers asking for a client
+	// certificate.
+	CertFile string `json:"tls_cert_file,omitempty"`
+	KeyFile  string `json:"tls_key_file,omitempty"`
+}
+
+// Empty reports whether o changes nothing.
+func (o Options) Empty() bool {
+	return o == Options{}
+}
+
+// Or returns o with its empty settings taken from fallback. The certificate and key are taken together.
+func (o Options) Or(fallback Options) Options {
+	if o.ProxyURL == "" {
+		o.ProxyURL, o.ProxyPasswordEnv = fallback.ProxyURL, fallback.ProxyPasswordEnv
+	}
+	if o.CAFile == "" {
+		o.CAFile = fallback.CAFile
+	}
+	if o.CertFile == "" && o.KeyFile == "" {
+		o.CertFile, o.KeyFile = fallback.CertFile, fallback.KeyFile
+	}
+	return o
+}
+
+// Transport returns a transport of the standard library's settings with o applied.
+func (o Options) Transport() (*http.Transport, error) {
+	t := base.Clone()
+	if o.ProxyURL != "" {
+		u, err := url.Parse(o.ProxyURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("egress: invalid proxy url %q, want http://host:port or https://host:port", redact(o.ProxyURL))
+		}
+		if o.ProxyPasswordEnv != "" {
+			if u.User == nil {
+				return nil, fmt.Errorf("egress: proxy url %s has no user the password of %s belongs to", redact(o.ProxyURL), o.ProxyPasswordEnv)
+			}
+			u.User = url.UserPassword(u.User.Username(), os.Getenv(o.ProxyPasswordEnv))
+		}
+		cfg := httpproxy.Config{HTTPProxy: u.String(), HTTPSProxy: u.String(), NoProxy: os.Getenv("NO_PROXY")}
+		if cfg.NoProxy == "" {
+			cfg.NoProxy = os.Getenv("no_proxy")
+		}
+		proxy := cfg.ProxyFunc()
+		t.Proxy = func(req *http.Request) (*url.URL, error) { return proxy(req.URL) }
+	}
+	if o.CAFile == "" && o.CertFile == "" && o.KeyFile == "" {
+		return t, nil
+	}
+	tc := &tls.Config{MinVersion: tls.VersionTLS12}
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("egress: reading CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("egress: no PEM certificates in CA bundle %s", o.CAFile)
+		}
+		tc.RootCAs = pool
+	}
+	switch {
+	case o.CertFile != "" && o.KeyFile != "":
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("egress: loading client certificate: %w", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	case o.CertFile != "" || o.KeyFile != "":
+		return nil, errors.New("egress: a client certificate needs both a certificate and a key file")
+	}
+	t.TLSClientConfig = tc
+	return t, nil
+}
+
+// Apply makes the transport of o that of http.DefaultTransport, which every client without a transport
+// of its own sends its requests with.
+func Apply(o Options) error {
+	if o.Empty() {
+		return nil
+	}
+	t, err := o.Transport()
+	if err != nil {
+		return err
+	}
+	http.DefaultTransport = t
+	return nil
+}
+
+// redact hides the password of a proxy URL.
+func redact(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "<unparsable>"
+	}
+	return u.Redacted()
+}
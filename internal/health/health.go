@@ -0,0 +1,372 @@
+// Package health serves liveness and readiness endpoints for container orchestrators.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/store"
+	syncer "github.com/danstis/ado-asana-sync/internal/sync"
+)
+
+// DefaultCredentialTTL is how long the result of a credential check is reused. Checking on every probe
+// would spend the API rate limits.
+const DefaultCredentialTTL = 5 * time.Minute
+
+// checkTimeout bounds the time a probe waits for its checks.
+const checkTimeout = 10 * time.Second
+
+// Pair is a sync pair watched by the checker.
+type Pair struct {
+	Name string
+	// Staleness is the longest time allowed since the pair's last cycle. Liveness fails when no cycle finished
+	// within it, and readiness fails when no cycle succeeded within it.
+	Staleness time.Duration
+	// Store, when set, is the store the pair records its cycles in instead of the checker's.
+	Store store.Store
+}
+
+// Credential checks that a set of API credentials is valid.
+type Credential struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// Checker tracks the sync loop and reports the health of the app.
+type Checker struct {
+	store       store.Store
+	pairs       []Pair
+	credentials []Credential
+	// CredentialTTL is how long a credential check result is cached, defaulting to DefaultCredentialTTL.
+	CredentialTTL time.Duration
+
+	started time.Time
+
+	mu sync.Mutex
+	// standby is set while another replica is the leader, so no cycles are expected.
+	standby bool
+	// owned holds the time each pair was claimed when pairs are sharded across instances, and is nil when the
+	// instance syncs every pair.
+	owned map[string]time.Time
+	// finished holds the time each pair last finished a cycle, successful or not.
+	finished map[string]time.Time
+	checked  map[string]credentialResult
+}
+
+type credentialResult struct {
+	at  time.Time
+	err error
+}
+
+// New returns a Checker for the pairs syncing through st.
+func New(st store.Store, pairs []Pair, credentials ...Credential) *Checker {
+	return &Checker{
+		store:         st,
+		pairs:         pairs,
+		credentials:   credentials,
+		CredentialTTL: DefaultCredentialTTL,
+		started:       time.Now(),
+		finished:      map[string]time.Time{},
+		checked:       map[string]credentialResult{},
+	}
+}
+
+// CycleFinished records that a cycle of the named pair finished. It is the heartbeat of the sync loop.
+func (c *Checker) CycleFinished(pair string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.finished[pair] = time.Now()
+}
+
+// SetStandby records whether the app is a standby replica waiting for the leader lease. Standby replicas are
+// healthy without running cycles, and pairs get their staleness from when the replica became the leader to
+// complete their first cycle.
+func (c *Checker) SetStandby(standby bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.standby && !standby {
+		c.started = time.Now()
+		c.finished = map[string]time.Time{}
+	}
+	c.standby = standby
+}
+
+// SetOwned records whether the instance syncs the named pair when pairs are sharded across instances. Only
+// the pairs the instance owns are checked, and they get their staleness from when they were claimed to
+// complete their first cycle.
+func (c *Checker) SetOwned(pair string, owned bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.owned == nil {
+		c.owned = map[string]time.Time{}
+	}
+	delete(c.finished, pair)
+	delete(c.owned, pair)
+	if owned {
+		c.owned[pair] = time.Now()
+	}
+}
+
+// since returns when the checker started waiting for cycles of the named pair, and false when the instance
+// does not sync it. c.mu must be held.
+func (c *Checker) since(pair string) (time.Time, bool) {
+	if c.owned == nil {
+		return c.started, true
+	}
+	claimed, ok := c.owned[pair]
+	if claimed.Before(c.started) {
+		claimed = c.started
+	}
+	return claimed, ok
+}
+
+// Handler returns the HTTP handler serving /healthz and /readyz.
+func (c *Checker) Handler() http.Handler {
+	return handler(c.Live, c.Ready)
+}
+
+// handler returns the HTTP handler serving the results of live on /healthz and of ready on /readyz.
+func handler(live func() Result, ready func(context.Context) Result) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeResult(w, live())
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+		defer cancel()
+		writeResult(w, ready(ctx))
+	})
+	return mux
+}
+
+// Group reports the health of several checkers together, such as those of the tenants of the service,
+// which come and go while it runs. An empty group is healthy.
+type Group struct {
+	mu       sync.Mutex
+	checkers map[string]*Checker
+}
+
+// NewGroup returns an empty Group.
+func NewGroup() *Group {
+	return &Group{checkers: map[string]*Checker{}}
+}
+
+// Set adds the checker c under name, replacing the one added before. A nil c removes it.
+func (g *Group) Set(name string, c *Checker) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if c == nil {
+		delete(g.checkers, name)
+		return
+	}
+	g.checkers[name] = c
+}
+
+// each calls f with every checker of the group by name.
+func (g *Group) each(f func(name string, c *Checker)) {
+	g.mu.Lock()
+	checkers := make(map[string]*Checker, len(g.checkers))
+	for name, c := range g.checkers {
+		checkers[name] = c
+	}
+	g.mu.Unlock()
+	for name, c := range checkers {
+		f(name, c)
+	}
+}
+
+// Live merges the liveness results of the checkers.
+func (g *Group) Live() Result {
+	res := Result{}
+	g.each(func(_ string, c *Checker) {
+		for k, v := range c.Live() {
+			res[k] = v
+		}
+	})
+	return res
+}
+
+// Ready merges the readiness results of the checkers, with the store checks of each named store:<name>.
+// The other checks are named after pairs and credentials, which name their checker themselves.
+func (g *Group) Ready(ctx context.Context) Result {
+	res := Result{}
+	g.each(func(name string, c *Checker) {
+		for k, v := range c.Ready(ctx) {
+			if k == "store" {
+				k += ":" + name
+			}
+			res[k] = v
+		}
+	})
+	return res
+}
+
+// Handler returns the HTTP handler serving /healthz and /readyz for the group.
+func (g *Group) Handler() http.Handler {
+	return handler(g.Live, g.Ready)
+}
+
+// Result is the outcome of a probe: the error of every failed check, keyed by check name, and the checks
+// that passed with an empty message.
+type Result map[string]string
+
+// OK reports whether every check passed.
+func (r Result) OK() bool {
+	for _, msg := range r {
+		if msg != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Live checks that the sync loop of every pair is still cycling. A pair that has not finished a cycle within
+// its staleness since the app started, or since its previous cycle, is wedged.
+func (c *Checker) Live() Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := Result{}
+	if c.standby {
+		res["leader"] = ""
+		return res
+	}
+	for _, p := range c.pairs {
+		since, ok := c.since(p.Name)
+		if !ok {
+			continue
+		}
+		last, ok := c.finished[p.Name]
+		if !ok {
+			last = since
+		}
+		res["loop:"+p.Name] = ""
+		if age := time.Since(last); age > p.Staleness {
+			res["loop:"+p.Name] = fmt.Sprintf("no sync cycle finished for %s", age.Round(time.Second))
+		}
+	}
+	return res
+}
+
+// Ready checks the store connection, the credentials and that every pair synced successfully within its
+// staleness. Pairs are given their staleness from the start of the app to complete a first cycle.
+func (c *Checker) Ready(ctx context.Context) Result {
+	res := Result{"store": ""}
+	if _, err := c.store.Setting(ctx, "health"); err != nil && !errors.Is(err, store.ErrNotFound) {
+		res["store"] = err.Error()
+	}
+	for _, cred := range c.credentials {
+		res["credentials:"+cred.Name] = ""
+		if err := c.checkCredential(ctx, cred); err != nil {
+			res["credentials:"+cred.Name] = err.Error()
+		}
+	}
+	c.mu.Lock()
+	standby := c.standby
+	c.mu.Unlock()
+	if standby {
+		res["leader"] = ""
+		return res
+	}
+	for _, p := range c.pairs {
+		c.mu.Lock()
+		started, ok := c.since(p.Name)
+		c.mu.Unlock()
+		if !ok {
+			continue
+		}
+		res["sync:"+p.Name] = ""
+		if msg := c.syncFreshness(ctx, p, started); msg != "" {
+			res["sync:"+p.Name] = msg
+		}
+	}
+	return res
+}
+
+// checkCredential runs the credential check, reusing a recent result.
+func (c *Checker) checkCredential(ctx context.Context, cred Credential) error {
+	c.mu.Lock()
+	prev, ok := c.checked[cred.Name]
+	c.mu.Unlock()
+	if ok && time.Since(prev.at) < c.CredentialTTL {
+		return prev.err
+	}
+	err := cred.Check(ctx)
+	if ctx.Err() != nil {
+		// A probe that timed out says nothing about the credentials, so it is not cached.
+		return err
+	}
+	c.mu.Lock()
+	c.checked[cred.Name] = credentialResult{at: time.Now(), err: err}
+	c.mu.Unlock()
+	return err
+}
+
+// syncFreshness returns why the last successful cycle of p is too old, or an empty string when it is recent.
+func (c *Checker) syncFreshness(ctx context.Context, p Pair, started time.Time) string {
+	var last time.Time
+	var lastErr string
+	st := c.store
+	if p.Store != nil {
+		st = p.Store
+	}
+	switch s, err := syncer.LastCycle(ctx, st, p.Name); {
+	case err == nil:
+		last, lastErr = s.LastSuccess, s.Error
+	case !errors.Is(err, store.ErrNotFound):
+		return err.Error()
+	}
+	if last.Before(started) && time.Since(started) < p.Staleness {
+		// The pair has not synced since the app started, or the pair was claimed, and still has time for its
+		// first cycle.
+		return ""
+	}
+	if last.IsZero() {
+		return "no successful sync cycle"
+	}
+	if age := time.Since(last); age > p.Staleness {
+		msg := fmt.Sprintf("last successful sync cycle %s ago", age.Round(time.Second))
+		if lastErr != "" {
+			msg += ": " + lastErr
+		}
+		return msg
+	}
+	return ""
+}
+
+// writeResult writes res as JSON with status 200 when every check passed and 503 otherwise.
+func writeResult(w http.ResponseWriter, res Result) {
+	status, code := "ok", http.StatusOK
+	if !res.OK() {
+		status, code = "fail", http.StatusServiceUnavailable
+	}
+	names := make([]string, 0, len(res))
+	for n := range res {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	type check struct {
+		Name   string `json:"name"`
+		Status string `json:"status"`
+		Error  string `json:"error,omitempty"`
+	}
+	body := struct {
+		Status string  `json:"status"`
+		Checks []check `json:"checks"`
+	}{Status: status}
+	for _, n := range names {
+		ch := check{Name: n, Status: "ok", Error: res[n]}
+		if ch.Error != "" {
+			ch.Status = "fail"
+		}
+		body.Checks = append(body.Checks, ch)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,238 @@
+package leader
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Paths of the service account files mounted into every Kubernetes pod.
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	tokenFile         = serviceAccountDir + "/token"
+	caFile            = serviceAccountDir + "/ca.crt"
+	namespaceFile     = serviceAccountDir + "/namespace"
+)
+
+// microTime is the layout of the times of Kubernetes Leases.
+const microTime = "2006-01-02T15:04:05.000000Z07:00"
+
+// KubernetesLock is a coordination.k8s.io/v1 Lease, the lock the Kubernetes control plane elects its own
+// leaders with. The service account of the pod needs get, create and update on leases in its namespace.
+type KubernetesLock struct {
+	// BaseURL is the URL of the API server.
+	BaseURL   string
+	Namespace string
+	Name      string
+	// Token returns the bearer token requests are authenticated with. It is read on every request, as the
+	// projected tokens of service accounts are rotated.
+	Token func() (string, error)
+	HTTP  *http.Client
+}
+
+// InCluster returns the Lease of the given name in the namespace, or the namespace of the pod when empty,
+// reached with the service account of the pod the app runs in.
+func InCluster(namespace, name string) (*KubernetesLock, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("leader: not running in a Kubernetes pod, KUBERNETES_SERVICE_HOST is unset")
+	}
+	if namespace == "" {
+		b, err := os.ReadFile(namespaceFile)
+		if err != nil {
+			return nil, fmt.Errorf("leader: reading the pod namespace: %w", err)
+		}
+		namespace = strings.TrimSpace(string(b))
+	}
+	ca, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("leader: reading the cluster CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("leader: no certificate in the cluster CA")
+	}
+	return &KubernetesLock{
+		BaseURL:   "https://" + net.JoinHostPort(host, port),
+		Namespace: namespace,
+		Name:      name,
+		Token: func() (string, error) {
+			b, err := os.ReadFile(tokenFile)
+			return strings.TrimSpace(string(b)), err
+		},
+		HTTP: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}},
+		},
+	}, nil
+}
+
+// lease is the subset of a Lease the lock reads and writes.
+type lease struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name            string `json:"name"`
+		Namespace       string `json:"namespace,omitempty"`
+		ResourceVersion string `json:"resourceVersion,omitempty"`
+	} `json:"metadata"`
+	Spec struct {
+		HolderIdentity       string `json:"holderIdentity"`
+		LeaseDurationSeconds int    `json:"leaseDurationSeconds"`
+		AcquireTime          string `json:"acquireTime,omitempty"`
+		RenewTime            string `json:"renewTime,omitempty"`
+		LeaseTransitions     int    `json:"leaseTransitions"`
+	} `json:"spec"`
+}
+
+// expired reports whether the holder of l stopped renewing it long enough ago for it to be taken.
+func (l *lease) expired(now time.Time) bool {
+	if l.Spec.HolderIdentity == "" {
+		return true
+	}
+	renewed, err := time.Parse(microTime, l.Spec.RenewTime)
+	if err != nil {
+		return true
+	}
+	return now.After(renewed.Add(time.Duration(l.Spec.LeaseDurationSeconds) * time.Second))
+}
+
+// errConflict is returned when a Lease was changed or created by another replica since it was read.
+var errConflict = errors.New("leader: lease changed concurrently")
+
+// Acquire implements Lock. Updates carry the version of the Lease read, so of two replicas taking an expired
+// Lease at once only one succeeds.
+func (k *KubernetesLock) Acquire(ctx context.Context, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	l, err := k.get(ctx)
+	if err != nil {
+		return false, err
+	}
+	if l == nil {
+		l = &lease{APIVersion: "coordination.k8s.io/v1", Kind: "Lease"}
+		l.Metadata.Name, l.Metadata.Namespace = k.Name, k.Namespace
+	} else if l.Spec.HolderIdentity != holder && !l.expired(now) {
+		return false, nil
+	}
+	if l.Spec.HolderIdentity != holder {
+		if l.Metadata.ResourceVersion != "" {
+			l.Spec.LeaseTransitions++
+		}
+		l.Spec.HolderIdentity = holder
+		l.Spec.AcquireTime = now.UTC().Format(microTime)
+	}
+	l.Spec.LeaseDurationSeconds = int((ttl + time.Second - 1) / time.Second)
+	l.Spec.RenewTime = now.UTC().Format(microTime)
+	err = k.put(ctx, l)
+	if errors.Is(err, errConflict) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Release implements Lock. The Lease is kept, emptied of its holder, so its transitions are still counted.
+func (k *KubernetesLock) Release(ctx context.Context, holder string) error {
+	l, err := k.get(ctx)
+	if err != nil || l == nil || l.Spec.HolderIdentity != holder {
+		return err
+	}
+	l.Spec.HolderIdentity = ""
+	l.Spec.LeaseDurationSeconds = 1
+	l.Spec.RenewTime = time.Now().UTC().Format(microTime)
+	if err := k.put(ctx, l); err != nil && !errors.Is(err, errConflict) {
+		return err
+	}
+	return nil
+}
+
+func (k *KubernetesLock) path(name string) string {
+	p := fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases", url.PathEscape(k.Namespace))
+	if name != "" {
+		p += "/" + url.PathEscape(name)
+	}
+	return p
+}
+
+// get returns the Lease, or nil when it does not exist.
+func (k *KubernetesLock) get(ctx context.Context) (*lease, error) {
+	var l lease
+	switch err := k.do(ctx, http.MethodGet, k.path(k.Name), nil, &l); {
+	case errors.Is(err, errNotFound):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	return &l, nil
+}
+
+// put creates the Lease when it was not read from the API server and updates it otherwise.
+func (k *KubernetesLock) put(ctx context.Context, l *lease) error {
+	if l.Metadata.ResourceVersion == "" {
+		return k.do(ctx, http.MethodPost, k.path(""), l, nil)
+	}
+	return k.do(ctx, http.MethodPut, k.path(k.Name), l, nil)
+}
+
+var errNotFound = errors.New("leader: lease not found")
+
+func (k *KubernetesLock) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, k.BaseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if k.Token != nil {
+		token, err := k.Token()
+		if err != nil {
+			return fmt.Errorf("leader: reading the service account token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	client := k.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("leader: %w", err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return errNotFound
+	case resp.StatusCode == http.StatusConflict:
+		return errConflict
+	case resp.StatusCode >= 300:
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("leader: %s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(b)))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("leader: decoding lease: %w", err)
+	}
+	return nil
+}
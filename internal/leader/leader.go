@@ -0,0 +1,146 @@
+// Package leader elects the replica that syncs when several run against the same store, through a lease
+// in the shared store or a Kubernetes Lease.
+package leader
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/metrics"
+)
+
+// DefaultTTL is how long a lease is held without being renewed.
+const DefaultTTL = 15 * time.Second
+
+// Lock is a lease that one holder at a time can hold.
+type Lock interface {
+	// Acquire takes the lease for holder until ttl from now, or extends it when holder already holds it, and
+	// reports whether holder holds it.
+	Acquire(ctx context.Context, holder string, ttl time.Duration) (bool, error)
+	// Release gives up the lease if holder holds it.
+	Release(ctx context.Context, holder string) error
+}
+
+// Leaser is implemented by the stores that hold leases.
+type Leaser interface {
+	AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error)
+	ReleaseLease(ctx context.Context, name, holder string) error
+}
+
+// StoreLock is the lease of the given name in a store.
+type StoreLock struct {
+	Store Leaser
+	Name  string
+}
+
+// Acquire implements Lock.
+func (l StoreLock) Acquire(ctx context.Context, holder string, ttl time.Duration) (bool, error) {
+	return l.Store.AcquireLease(ctx, l.Name, holder, ttl)
+}
+
+// Release implements Lock.
+func (l StoreLock) Release(ctx context.Context, holder string) error {
+	return l.Store.ReleaseLease(ctx, l.Name, holder)
+}
+
+// Elector runs work only while its replica holds the lease. Standby replicas try to take the lease every
+// third of its TTL, so they take over within the TTL and a third of a leader that stopped renewing it.
+type Elector struct {
+	Lock Lock
+	// Identity names the replica in the lease. It must differ between replicas.
+	Identity string
+	// TTL is how long the lease is held without being renewed, DefaultTTL when zero.
+	TTL time.Duration
+	// OnChange, when not nil, is called when the replica becomes the leader and when it stops being one.
+	OnChange func(leading bool)
+}
+
+func (e *Elector) ttl() time.Duration {
+	if e.TTL > 0 {
+		return e.TTL
+	}
+	return DefaultTTL
+}
+
+// Run calls lead whenever the replica becomes the leader, cancelling its context when the lease is lost, until
+// ctx is cancelled. The lease is released when Run returns, so a standby takes over without waiting for it to
+// expire.
+func (e *Elector) Run(ctx context.Context, lead func(ctx context.Context)) {
+	retry := e.ttl() / 3
+	log := slog.With("identity", e.Identity)
+	for {
+		ok, err := e.Lock.Acquire(ctx, e.Identity, e.ttl())
+		switch {
+		case err != nil && ctx.Err() == nil:
+			log.Warn("failed to acquire the leader lease", "error", err)
+		case ok:
+			e.lead(ctx, lead)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retry):
+		}
+	}
+}
+
+// lead runs lead while the lease is renewed. It stops lead before the lease can expire when it cannot be
+// renewed, so two replicas never sync at once.
+func (e *Elector) lead(ctx context.Context, lead func(ctx context.Context)) {
+	log := slog.With("identity", e.Identity)
+	log.Info("became the leader, starting to sync")
+	metrics.Leader.Set(1)
+	if e.OnChange != nil {
+		e.OnChange(true)
+	}
+	lctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lead(lctx)
+	}()
+
+	ttl, retry := e.ttl(), e.ttl()/3
+	renewed := time.Now()
+	ticker := time.NewTicker(retry)
+	defer ticker.Stop()
+renew:
+	for {
+		select {
+		case <-done:
+			break renew
+		case <-ticker.C:
+		}
+		ok, err := e.Lock.Acquire(lctx, e.Identity, ttl)
+		switch {
+		case ok:
+			renewed = time.Now()
+			continue
+		case err == nil:
+			log.Warn("lost the leader lease to another replica")
+		case time.Since(renewed) < ttl-retry:
+			if lctx.Err() == nil {
+				log.Warn("failed to renew the leader lease", "error", err)
+			}
+			continue
+		default:
+			log.Error("could not renew the leader lease in time, stopping", "error", err)
+		}
+		break
+	}
+	cancel()
+	<-done
+
+	// ctx may be cancelled already as the app shuts down, and the lease must still be given up.
+	rctx, stop := context.WithTimeout(context.Background(), retry)
+	defer stop()
+	if err := e.Lock.Release(rctx, e.Identity); err != nil {
+		log.Warn("failed to release the leader lease", "error", err)
+	}
+	metrics.Leader.Set(0)
+	if e.OnChange != nil {
+		e.OnChange(false)
+	}
+	log.Info("stopped being the leader")
+}
@@ -0,0 +1,189 @@
+package leader
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/metrics"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// Prefixes of the names of the leases of the sharder.
+const (
+	memberPrefix = "member:"
+	pairPrefix   = "pair:"
+)
+
+// ShardStore is implemented by the stores that hold and list leases.
+type ShardStore interface {
+	Leaser
+	Leases(ctx context.Context) ([]store.Lease, error)
+}
+
+// Sharder spreads pairs across the instances sharing a store. Every instance holds a member lease, and claims
+// pairs with a lease each until it holds its share of them: the number of pairs divided by the number of
+// members, rounded up. Instances holding more than their share, as another joined, release the rest for it
+// to claim, and the pairs of an instance that left are claimed by the others once their leases expire.
+type Sharder struct {
+	Store ShardStore
+	// Identity names the instance in the leases. It must differ between instances.
+	Identity string
+	// TTL is how long the leases are held without being renewed, DefaultTTL when zero.
+	TTL time.Duration
+	// Pairs are the names of the pairs shared.
+	Pairs []string
+	// OnChange, when not nil, is called when the instance claims a pair and when it stops syncing one.
+	OnChange func(pair string, owned bool)
+}
+
+// claim is a pair the instance syncs.
+type claim struct {
+	cancel  context.CancelFunc
+	done    chan struct{}
+	renewed time.Time
+}
+
+func (s *Sharder) ttl() time.Duration {
+	if s.TTL > 0 {
+		return s.TTL
+	}
+	return DefaultTTL
+}
+
+// Run calls run for every pair the instance claims, cancelling its context when the pair is released or its
+// lease lost, until ctx is cancelled. The leases are released when Run returns, so the other instances take
+// the pairs over without waiting for them to expire.
+func (s *Sharder) Run(ctx context.Context, run func(ctx context.Context, pair string)) {
+	claims := map[string]*claim{}
+	ticker := time.NewTicker(s.ttl() / 3)
+	defer ticker.Stop()
+	for {
+		s.balance(ctx, claims, run)
+		select {
+		case <-ctx.Done():
+			for pair := range claims {
+				s.stop(claims, pair, true)
+			}
+			rctx, cancel := context.WithTimeout(context.Background(), s.ttl()/3)
+			_ = s.Store.ReleaseLease(rctx, memberPrefix+s.Identity, s.Identity)
+			cancel()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// balance renews the leases of the instance, then releases or claims pairs until it holds its share.
+func (s *Sharder) balance(ctx context.Context, claims map[string]*claim, run func(ctx context.Context, pair string)) {
+	log := slog.With("identity", s.Identity)
+	ttl, retry := s.ttl(), s.ttl()/3
+	if _, err := s.Store.AcquireLease(ctx, memberPrefix+s.Identity, s.Identity, ttl); err != nil && ctx.Err() == nil {
+		log.Warn("failed to renew the member lease", "error", err)
+	}
+	for _, pair := range sortedClaims(claims) {
+		c := claims[pair]
+		ok, err := s.Store.AcquireLease(ctx, pairPrefix+pair, s.Identity, ttl)
+		switch {
+		case ok:
+			c.renewed = time.Now()
+		case err == nil:
+			log.Warn("lost the lease of a pair to another instance", "pair", pair)
+			s.stop(claims, pair, false)
+		case time.Since(c.renewed) >= ttl-retry:
+			// The pair stops before its lease can expire, so two instances never sync it at once.
+			log.Error("could not renew the lease of a pair in time, stopping it", "pair", pair, "error", err)
+			s.stop(claims, pair, false)
+		}
+	}
+
+	leases, err := s.Store.Leases(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Warn("failed to list the leases", "error", err)
+		}
+		return
+	}
+	now := time.Now()
+	members, taken := 0, map[string]bool{}
+	self := false
+	for _, l := range leases {
+		if !now.Before(l.Expires) {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(l.Name, memberPrefix):
+			members++
+			self = self || l.Holder == s.Identity
+		case strings.HasPrefix(l.Name, pairPrefix) && l.Holder != s.Identity:
+			taken[strings.TrimPrefix(l.Name, pairPrefix)] = true
+		}
+	}
+	if !self {
+		members++
+	}
+	share := (len(s.Pairs) + members - 1) / members
+
+	held := sortedClaims(claims)
+	for len(held) > share {
+		pair := held[len(held)-1]
+		held = held[:len(held)-1]
+		log.Info("releasing a pair for another instance", "pair", pair, "members", members)
+		s.stop(claims, pair, true)
+	}
+	for _, pair := range s.Pairs {
+		if len(claims) >= share {
+			break
+		}
+		if claims[pair] != nil || taken[pair] {
+			continue
+		}
+		ok, err := s.Store.AcquireLease(ctx, pairPrefix+pair, s.Identity, ttl)
+		if err != nil || !ok {
+			continue
+		}
+		log.Info("claimed a pair", "pair", pair, "members", members)
+		pctx, cancel := context.WithCancel(ctx)
+		c := &claim{cancel: cancel, done: make(chan struct{}), renewed: time.Now()}
+		claims[pair] = c
+		metrics.PairOwned.WithLabelValues(pair).Set(1)
+		if s.OnChange != nil {
+			s.OnChange(pair, true)
+		}
+		go func(pair string) {
+			defer close(c.done)
+			run(pctx, pair)
+		}(pair)
+	}
+}
+
+// stop stops syncing the pair, and releases its lease when it is still held.
+func (s *Sharder) stop(claims map[string]*claim, pair string, release bool) {
+	c := claims[pair]
+	delete(claims, pair)
+	c.cancel()
+	<-c.done
+	if release {
+		ctx, cancel := context.WithTimeout(context.Background(), s.ttl()/3)
+		defer cancel()
+		if err := s.Store.ReleaseLease(ctx, pairPrefix+pair, s.Identity); err != nil {
+			slog.Warn("failed to release the lease of a pair", "identity", s.Identity, "pair", pair, "error", err)
+		}
+	}
+	metrics.PairOwned.WithLabelValues(pair).Set(0)
+	if s.OnChange != nil {
+		s.OnChange(pair, false)
+	}
+}
+
+// sortedClaims returns the names of the claimed pairs in order.
+func sortedClaims(claims map[string]*claim) []string {
+	names := make([]string, 0, len(claims))
+	for name := range claims {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
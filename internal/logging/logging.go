@@ -0,0 +1,66 @@
+// Package logging configures the structured logger of the app and carries loggers with per-item fields in
+// contexts.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Keys of the fields identifying what a log line is about.
+const (
+	KeyPair     = "sync_pair"
+	KeyWorkItem = "work_item_id"
+	KeyTask     = "asana_task_gid"
+	KeyCycle    = "cycle_id"
+)
+
+// Formats of the log output.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// ParseLevel parses s as a log level: debug, info, warn or error. An empty string returns info.
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", s)
+	}
+	return l, nil
+}
+
+// New returns a logger writing lines of at least level to w in the given format, text or json. An empty
+// format is text.
+func New(w io.Writer, level slog.Level, format string) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(format) {
+	case "", FormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+}
+
+type ctxKey struct{}
+
+// With returns a copy of ctx whose logger adds the given fields, as key-value pairs, to every line.
+func With(ctx context.Context, args ...interface{}) context.Context {
+	return context.WithValue(ctx, ctxKey{}, From(ctx).With(args...))
+}
+
+// From returns the logger of ctx, or the default logger.
+func From(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(ctxKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
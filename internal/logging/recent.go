@@ -0,0 +1,107 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Entry is a log line kept by Recent.
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"message"`
+	// Pair and WorkItem are the sync pair and work item the line is about, when it has their fields.
+	Pair     string `json:"pair,omitempty"`
+	WorkItem int64  `json:"work_item,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Recent is a slog handler keeping the last lines of at least a level, such as the recent errors shown by
+// the status endpoint, before handing every line on to the next handler.
+type Recent struct {
+	next  slog.Handler
+	level slog.Level
+	log   *recentLog
+	attrs []slog.Attr
+	// grouped is set once fields are added to a group, which entries do not read.
+	grouped bool
+}
+
+type recentLog struct {
+	mu      sync.Mutex
+	entries []Entry
+	size    int
+}
+
+// NewRecent returns a handler keeping the last size lines of at least level and passing every line to next.
+func NewRecent(next slog.Handler, level slog.Level, size int) *Recent {
+	return &Recent{next: next, level: level, log: &recentLog{size: size}}
+}
+
+// Entries returns the kept lines, oldest first.
+func (h *Recent) Entries() []Entry {
+	h.log.mu.Lock()
+	defer h.log.mu.Unlock()
+	return append([]Entry(nil), h.log.entries...)
+}
+
+// Enabled implements slog.Handler.
+func (h *Recent) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler.
+func (h *Recent) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= h.level {
+		e := Entry{Time: r.Time, Level: r.Level.String(), Message: r.Message}
+		add := func(a slog.Attr) bool {
+			switch a.Key {
+			case KeyPair:
+				e.Pair = a.Value.String()
+			case KeyWorkItem:
+				if a.Value.Kind() == slog.KindInt64 {
+					e.WorkItem = a.Value.Int64()
+				}
+			case "error":
+				e.Error = a.Value.String()
+			}
+			return true
+		}
+		for _, a := range h.attrs {
+			add(a)
+		}
+		if !h.grouped {
+			r.Attrs(add)
+		}
+		h.log.add(e)
+	}
+	return h.next.Handle(ctx, r)
+}
+
+// WithAttrs implements slog.Handler.
+func (h *Recent) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.next = h.next.WithAttrs(attrs)
+	if !h.grouped {
+		c.attrs = append(append([]slog.Attr(nil), h.attrs...), attrs...)
+	}
+	return &c
+}
+
+// WithGroup implements slog.Handler. The fields of groups are not read into entries.
+func (h *Recent) WithGroup(name string) slog.Handler {
+	c := *h
+	c.next = h.next.WithGroup(name)
+	c.grouped = true
+	return &c
+}
+
+func (l *recentLog) add(e Entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.entries = append(l.entries, e); len(l.entries) > l.size {
+		l.entries = l.entries[len(l.entries)-l.size:]
+	}
+}
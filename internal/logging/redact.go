@@ -0,0 +1,122 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces the values of redacted fields.
+const Redacted = "[redacted]"
+
+// RedactEmails, given as a field to ParseRedaction, masks the email addresses in every line.
+const RedactEmails = "emails"
+
+// email matches email addresses, keeping the first letter of the local part and the domain apart.
+var email = regexp.MustCompile(`\b([A-Za-z0-9])[A-Za-z0-9._%+-]*@([A-Za-z0-9.-]+\.[A-Za-z]{2,})\b`)
+
+// Redaction lists what is removed from log lines before they are written.
+type Redaction struct {
+	// Keys are the fields whose values are replaced by Redacted, whatever group they are in.
+	Keys map[string]bool
+	// Emails masks the email addresses in messages and field values, keeping their first letter and domain.
+	Emails bool
+}
+
+// ParseRedaction parses a comma separated list of fields to redact, in which RedactEmails masks email
+// addresses, for example "title,assignee,emails".
+func ParseRedaction(s string) Redaction {
+	r := Redaction{Keys: map[string]bool{}}
+	for _, k := range strings.Split(s, ",") {
+		switch k = strings.TrimSpace(k); k {
+		case "":
+		case RedactEmails:
+			r.Emails = true
+		default:
+			r.Keys[k] = true
+		}
+	}
+	return r
+}
+
+// Empty reports whether the redaction removes nothing.
+func (r Redaction) Empty() bool {
+	return len(r.Keys) == 0 && !r.Emails
+}
+
+// mask masks the email addresses in s when emails are redacted.
+func (r Redaction) mask(s string) string {
+	if !r.Emails || !strings.Contains(s, "@") {
+		return s
+	}
+	return email.ReplaceAllString(s, "$1***@$2")
+}
+
+// attr returns a with its value redacted.
+func (r Redaction) attr(a slog.Attr) slog.Attr {
+	if r.Keys[a.Key] {
+		return slog.String(a.Key, Redacted)
+	}
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindGroup:
+		group := v.Group()
+		attrs := make([]slog.Attr, len(group))
+		for i, g := range group {
+			attrs[i] = r.attr(g)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(attrs...)}
+	case slog.KindString:
+		return slog.String(a.Key, r.mask(v.String()))
+	case slog.KindAny:
+		// Errors and other values are written as text, so they are masked as text.
+		if r.Emails {
+			if s := fmt.Sprint(v.Any()); strings.Contains(s, "@") {
+				return slog.String(a.Key, r.mask(s))
+			}
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
+
+// Redact is a slog handler redacting lines before handing them on to the next handler.
+type Redact struct {
+	next slog.Handler
+	r    Redaction
+}
+
+// NewRedact returns a handler removing what r lists from every line and passing it to next.
+func NewRedact(next slog.Handler, r Redaction) *Redact {
+	return &Redact{next: next, r: r}
+}
+
+// Enabled implements slog.Handler.
+func (h *Redact) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler.
+func (h *Redact) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, h.r.mask(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(h.r.attr(a))
+		return true
+	})
+	return h.next.Handle(ctx, out)
+}
+
+// WithAttrs implements slog.Handler.
+func (h *Redact) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = h.r.attr(a)
+	}
+	return &Redact{next: h.next.WithAttrs(redacted), r: h.r}
+}
+
+// WithGroup implements slog.Handler.
+func (h *Redact) WithGroup(name string) slog.Handler {
+	return &Redact{next: h.next.WithGroup(name), r: h.r}
+}
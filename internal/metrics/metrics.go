@@ -0,0 +1,255 @@
+// Package metrics exposes Prometheus metrics describing sync activity.
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "ado_asana_sync"
+
+// Providers label API metrics with the system that was called.
+const (
+	ProviderADO   = "ado"
+	ProviderAsana = "asana"
+)
+
+var (
+	// ItemsScanned counts the work items examined by sync cycles and targeted syncs by pair.
+	ItemsScanned = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "items_scanned_total",
+		Help:      "Work items examined for changes.",
+	}, []string{"pair"})
+	// TasksCreated counts the Asana tasks created for work items.
+	TasksCreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_created_total",
+		Help:      "Asana tasks created for work items.",
+	}, []string{"pair"})
+	// TasksUpdated counts the updates made to existing Asana tasks.
+	TasksUpdated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_updated_total",
+		Help:      "Updates made to existing Asana tasks.",
+	}, []string{"pair"})
+	// WorkItemsUpdated counts the updates pushed back to Azure DevOps work items.
+	WorkItemsUpdated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "work_items_updated_total",
+		Help:      "Updates pushed back to Azure DevOps work items.",
+	}, []string{"pair"})
+	// WorkItemsCreated counts the Azure DevOps work items created for Asana tasks taken in.
+	WorkItemsCreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "work_items_created_total",
+		Help:      "Azure DevOps work items created for Asana tasks taken in.",
+	}, []string{"pair"})
+	// APIRequestDuration observes the latency of API calls by provider, method and status code.
+	APIRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "api_request_duration_seconds",
+		Help:      "Latency of API calls to Azure DevOps and Asana.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"provider", "method", "code"})
+	// RateLimited counts the API calls rejected by a provider's rate limit.
+	RateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "API calls rejected with 429 Too Many Requests.",
+	}, []string{"provider"})
+	// RateLimitWait observes how long a provider asked callers to wait after rate limiting them.
+	RateLimitWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_wait_seconds",
+		Help:      "Wait requested by the Retry-After header of rate limited API calls.",
+		Buckets:   []float64{1, 5, 10, 30, 60, 120, 300},
+	}, []string{"provider"})
+	// RateLimitRetries counts the rate limited API calls that were retried after waiting.
+	RateLimitRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_retries_total",
+		Help:      "Rate limited API calls retried after waiting.",
+	}, []string{"provider"})
+	// RateLimitPaused counts the time requests to a provider were held back to stay within its budget.
+	RateLimitPaused = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_paused_seconds_total",
+		Help:      "Time requests were paused to stay within the provider's rate limit.",
+	}, []string{"provider"})
+	// RateLimitRemaining is the remaining request quota last reported by a provider.
+	RateLimitRemaining = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_remaining",
+		Help:      "Remaining request quota reported by the X-RateLimit-Remaining header.",
+	}, []string{"provider"})
+	// RateLimitConcurrency is the number of concurrent requests currently allowed to a provider.
+	RateLimitConcurrency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_concurrency",
+		Help:      "Concurrent API requests currently allowed by the adaptive rate limiter.",
+	}, []string{"provider"})
+	// BudgetExhausted counts the requests refused because they would exceed a call budget, by budget.
+	BudgetExhausted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "call_budget_exhausted_total",
+		Help:      "API requests not sent because they would exceed a call budget.",
+	}, []string{"budget"})
+	// CircuitState is the state of the circuit breaker of a provider: 0 closed, 1 half open and 2 open.
+	CircuitState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "circuit_state",
+		Help:      "State of the circuit breaker of a provider: 0 closed, 1 half open, 2 open.",
+	}, []string{"provider"})
+	// CircuitOpened counts the times the circuit breaker of a provider opened.
+	CircuitOpened = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "circuit_opened_total",
+		Help:      "Times the circuit breaker of a provider opened after repeated failures.",
+	}, []string{"provider"})
+	// Degraded is 1 while a pair runs degraded cycles because a provider is unavailable.
+	Degraded = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "degraded",
+		Help:      "Whether the last cycle of a pair ran in degraded mode because a provider was unavailable.",
+	}, []string{"pair"})
+	// Paused is 1 while a pair is paused or in a maintenance window.
+	Paused = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "paused",
+		Help:      "Whether the last cycle of a pair was skipped because the pair was paused or in a maintenance window.",
+	}, []string{"pair"})
+	// Leader is 1 while the replica holds the leader lease, and always 1 without leader election.
+	Leader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "leader",
+		Help:      "Whether this replica is the leader that syncs.",
+	})
+	// PairOwned is 1 for the pairs this instance syncs when pairs are sharded across instances.
+	PairOwned = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pair_owned",
+		Help:      "Whether this instance holds the lease of a pair sharded across instances.",
+	}, []string{"pair"})
+	// TenantPairs is the number of pairs each tenant syncs in multi-tenant mode. The metrics of the pairs of a
+	// tenant are labelled <tenant>/<pair>, and those of its connections ado:<tenant> and asana:<tenant>.
+	TenantPairs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "tenant_pairs",
+		Help:      "Sync pairs of each tenant in multi-tenant mode.",
+	}, []string{"tenant"})
+	// CacheLookups counts the reads of cached Asana reference data by kind and result.
+	CacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_lookups_total",
+		Help:      "Reads of Asana reference data by kind, served from the cache (hit) or the API (miss).",
+	}, []string{"kind", "result"})
+	// CycleDuration observes the duration of full sync cycles by pair.
+	CycleDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "cycle_duration_seconds",
+		Help:      "Duration of full sync cycles.",
+		Buckets:   []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800},
+	}, []string{"pair"})
+	// DriftScore is the share of the mappings of a pair that drifted at its last drift check.
+	DriftScore = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "drift_score",
+		Help:      "Share of the mappings of a pair that no longer matched their work item or task at the last drift check.",
+	}, []string{"pair"})
+	// Drift counts the drifted mappings found by drift checks by pair and kind.
+	Drift = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "drift_total",
+		Help:      "Mappings found drifted by drift checks.",
+	}, []string{"pair", "kind"})
+	// Errors counts failed syncs by pair, error category, and the class and code of the error.
+	Errors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "errors_total",
+		Help:      "Failed sync cycles and item syncs by error category, class and code.",
+	}, []string{"pair", "category", "class", "code"})
+)
+
+// Registry holds every metric exported by the app along with the Go runtime and process collectors.
+var Registry = prometheus.NewRegistry()
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		ItemsScanned, TasksCreated, TasksUpdated, WorkItemsUpdated, WorkItemsCreated,
+		APIRequestDuration, RateLimited, RateLimitWait,
+		RateLimitRetries, RateLimitPaused, RateLimitRemaining, RateLimitConcurrency, BudgetExhausted,
+		CircuitState, CircuitOpened, Degraded, Paused, Leader, PairOwned, TenantPairs,
+		CacheLookups, CycleDuration, DriftScore, Drift, Errors,
+	)
+}
+
+// Handler returns the HTTP handler serving the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Transport wraps next, recording the latency and rate limiting of every request sent to provider.
+// A nil next uses http.DefaultTransport.
+func Transport(provider string, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &transport{provider: provider, next: next}
+}
+
+type transport struct {
+	provider string
+	next     http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if c, ok := req.Context().Value(callsKey{}).(*Calls); ok {
+		c.n.Add(1)
+	}
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+		if resp.StatusCode == http.StatusTooManyRequests {
+			t.rateLimited(resp)
+		}
+	}
+	APIRequestDuration.WithLabelValues(t.provider, req.Method, code).Observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+func (t *transport) rateLimited(resp *http.Response) {
+	RateLimited.WithLabelValues(t.provider).Inc()
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs >= 0 {
+		RateLimitWait.WithLabelValues(t.provider).Observe(float64(secs))
+	}
+}
+
+// Calls counts the API requests sent with a context returned by WithCalls, retries included.
+type Calls struct {
+	n atomic.Int64
+}
+
+type callsKey struct{}
+
+// WithCalls returns a context counting the API requests sent with it, and its count.
+func WithCalls(ctx context.Context) (context.Context, *Calls) {
+	c := &Calls{}
+	return context.WithValue(ctx, callsKey{}, c), c
+}
+
+// Count returns the number of requests sent so far.
+func (c *Calls) Count() int {
+	return int(c.n.Load())
+}
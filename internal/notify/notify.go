@@ -0,0 +1,342 @@
+// Package notify posts sync failures, conflicts and cycle summaries to a Slack or Microsoft Teams webhook.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/secret"
+	syncer "github.com/danstis/ado-asana-sync/internal/sync"
+)
+
+// Defaults of a Notifier.
+const (
+	// DefaultItemFailures is the number of cycles in a row a work item must fail in before it is notified.
+	DefaultItemFailures = 3
+	// DefaultCooldown is the time before a failure that was notified is notified again.
+	DefaultCooldown = time.Hour
+	// DefaultMaxPerHour is the largest number of messages posted in an hour.
+	DefaultMaxPerHour = 20
+)
+
+// postTimeout bounds the time a message takes to post, so a slow webhook does not hold up the sync.
+const postTimeout = 10 * time.Second
+
+// Kind is the kind of event notified.
+type Kind string
+
+// Kinds of events.
+const (
+	// CycleFailed is sent when a sync cycle fails as a whole.
+	CycleFailed Kind = "cycle_failed"
+	// ItemFailing is sent when a work item failed to sync in ItemFailures cycles in a row.
+	ItemFailing Kind = "item_failing"
+	// Conflicts is sent when a cycle queued conflicts for manual resolution.
+	Conflicts Kind = "conflicts"
+	// Summary is sent at the end of every cycle.
+	Summary Kind = "summary"
+)
+
+// DefaultKinds are the events sent when none are configured. Summaries are opt-in.
+var DefaultKinds = []Kind{CycleFailed, ItemFailing, Conflicts}
+
+// ParseKinds parses a comma separated list of event kinds.
+func ParseKinds(s string) ([]Kind, error) {
+	var kinds []Kind
+	for _, part := range strings.Split(s, ",") {
+		k := Kind(strings.ToLower(strings.TrimSpace(part)))
+		if k == "" {
+			continue
+		}
+		if _, ok := defaultTemplates[k]; !ok {
+			return nil, fmt.Errorf("invalid notification event %q, expected one of cycle_failed, item_failing, conflicts or summary", part)
+		}
+		kinds = append(kinds, k)
+	}
+	return kinds, nil
+}
+
+// Format is the message format of a webhook.
+type Format string
+
+// Formats of webhooks.
+const (
+	// Slack posts to a Slack incoming webhook.
+	Slack Format = "slack"
+	// Teams posts an Adaptive Card to a Microsoft Teams incoming webhook or workflow.
+	Teams Format = "teams"
+)
+
+// ParseFormat parses a webhook format. When s is empty the format is detected from the webhook URL.
+func ParseFormat(s, webhook string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case Slack, Teams:
+		return f, nil
+	case "":
+	default:
+		return "", fmt.Errorf("invalid notification format %q, expected slack or teams", s)
+	}
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return "", fmt.Errorf("invalid notification webhook URL: %w", err)
+	}
+	switch host := strings.ToLower(u.Hostname()); {
+	case host == "hooks.slack.com":
+		return Slack, nil
+	case strings.HasSuffix(host, ".webhook.office.com"), strings.HasSuffix(host, ".logic.azure.com"),
+		strings.HasSuffix(host, ".powerplatform.com"):
+		return Teams, nil
+	}
+	return "", fmt.Errorf("cannot tell the format of the notification webhook, set it to slack or teams")
+}
+
+// Event is the data given to the template of a message.
+type Event struct {
+	Kind Kind
+	Pair string
+	// Error is the error of a failed cycle or a failing work item.
+	Error string
+	// Code, Class and Remediation are the code of Error, its class and how to fix it.
+	Code        syncer.Code
+	Class       syncer.Class
+	Remediation string
+	// ADOID is the failing work item.
+	ADOID int
+	// Count is the number of cycles in a row a work item failed in, or the number of new conflicts.
+	Count int
+	// Conflicts is the number of unresolved conflicts.
+	Conflicts int
+	// Items, Created, Updated, Failed and Removed summarize a cycle.
+	Items, Created, Updated, Failed, Removed int
+	// Full is set for cycles that reconciled every item.
+	Full bool
+}
+
+// defaultTemplates render the messages of the events without a template of their own.
+var defaultTemplates = map[Kind]string{
+	CycleFailed: `Sync pair {{.Pair}}: the sync cycle failed: {{.Error}}{{if .Code}} [{{.Code}}] To fix: {{.Remediation}}{{end}}`,
+	ItemFailing: `Sync pair {{.Pair}}: work item {{.ADOID}} failed to sync in {{.Count}} cycles in a row: {{.Error}}{{if .Code}} [{{.Code}}] To fix: {{.Remediation}}{{end}}`,
+	Conflicts:   `Sync pair {{.Pair}}: {{.Count}} new conflicts await manual resolution, {{.Conflicts}} in total`,
+	Summary:     `Sync pair {{.Pair}}: {{.Items}} work items, {{.Created}} created, {{.Updated}} updated, {{.Failed}} failed`,
+}
+
+// ParseTemplate parses the text/template rendering the messages of an event kind from an Event.
+func ParseTemplate(k Kind, text string) (*template.Template, error) {
+	t, err := template.New(string(k)).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s notification template: %w", k, err)
+	}
+	return t, nil
+}
+
+// Notifier posts the events of sync cycles to a webhook. Failures are rate limited: a failure that was
+// notified is not notified again within the cooldown, and at most MaxPerHour messages are posted in an hour.
+type Notifier struct {
+	// URL is the webhook URL posted to. URLs, when set, supplies it instead, for URLs held in a secret
+	// manager.
+	URL  string
+	URLs secret.TokenSource
+	// Format is the message format of the webhook.
+	Format Format
+	// ItemFailures is the number of cycles in a row a work item must fail in before it is notified.
+	ItemFailures int
+	// Cooldown is the time before a failure that was notified is notified again.
+	Cooldown time.Duration
+	// MaxPerHour is the largest number of messages posted in an hour. Messages beyond it are dropped.
+	MaxPerHour int
+	// Client posts the messages, defaulting to a client giving up after postTimeout.
+	Client *http.Client
+
+	kinds     map[Kind]bool
+	templates map[Kind]*template.Template
+
+	mu sync.Mutex
+	// failing counts the cycles each work item of every pair has failed in since it last synced.
+	failing map[string]map[int]int
+	// notified holds when each failure was last notified.
+	notified map[string]time.Time
+	// posted holds the times of the messages posted in the last hour.
+	posted []time.Time
+}
+
+// New returns a Notifier posting the given kinds of events, or DefaultKinds when there are none, to the
+// webhook URL. templates replace the default templates of their kinds.
+func New(webhook string, format Format, kinds []Kind, templates map[Kind]*template.Template) *Notifier {
+	if len(kinds) == 0 {
+		kinds = DefaultKinds
+	}
+	n := &Notifier{
+		URL:          webhook,
+		Format:       format,
+		ItemFailures: DefaultItemFailures,
+		Cooldown:     DefaultCooldown,
+		MaxPerHour:   DefaultMaxPerHour,
+		kinds:        map[Kind]bool{},
+		templates:    map[Kind]*template.Template{},
+		failing:      map[string]map[int]int{},
+		notified:     map[string]time.Time{},
+	}
+	for _, k := range kinds {
+		n.kinds[k] = true
+	}
+	for k, text := range defaultTemplates {
+		n.templates[k] = template.Must(ParseTemplate(k, text))
+	}
+	for k, t := range templates {
+		n.templates[k] = t
+	}
+	return n
+}
+
+// Cycle notifies the events of a finished cycle of the pair, whose report is rep and error err. Interrupted
+// cycles, cycles skipped while the pair is paused and dry runs are not notified. Failing to post is logged
+// and does not affect the sync.
+func (n *Notifier) Cycle(ctx context.Context, pair string, rep *syncer.Report, err error) {
+	if errors.Is(err, syncer.ErrInterrupted) || errors.Is(err, syncer.ErrPaused) || (rep != nil && rep.Plan != nil) {
+		return
+	}
+	ctx = logging.With(ctx, logging.KeyPair, pair)
+	if err != nil {
+		n.send(ctx, "cycle:"+pair, failure(Event{Kind: CycleFailed, Pair: pair}, err))
+		return
+	}
+	for _, f := range n.failingItems(pair, rep) {
+		n.send(ctx, "item:"+pair+":"+strconv.Itoa(f.ADOID), failure(Event{Kind: ItemFailing, Pair: pair, ADOID: f.ADOID, Count: n.ItemFailures}, f.Err))
+	}
+	if rep.NewConflicts > 0 {
+		n.send(ctx, "", Event{Kind: Conflicts, Pair: pair, Count: rep.NewConflicts, Conflicts: len(rep.Conflicts)})
+	}
+	n.send(ctx, "", Event{
+		Kind: Summary, Pair: pair, Items: rep.Items, Created: rep.Created, Updated: rep.Updated,
+		Failed: len(rep.Failures), Removed: rep.Removed, Full: rep.Full,
+	})
+}
+
+// failure returns ev reporting err with its code.
+func failure(ev Event, err error) Event {
+	ev.Error, ev.Code = err.Error(), syncer.Classify(err)
+	ev.Class, ev.Remediation = ev.Code.Class(), ev.Code.Remediation()
+	return ev
+}
+
+// failingItems records the failures of a cycle of the pair and returns those that reached ItemFailures cycles
+// in a row. Work items that did not fail are forgotten.
+func (n *Notifier) failingItems(pair string, rep *syncer.Report) []syncer.Failure {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	prev, cur := n.failing[pair], map[int]int{}
+	var reached []syncer.Failure
+	for _, f := range rep.Failures {
+		cur[f.ADOID] = prev[f.ADOID] + 1
+		if cur[f.ADOID] == n.ItemFailures {
+			reached = append(reached, f)
+		}
+	}
+	n.failing[pair] = cur
+	return reached
+}
+
+// send posts the message of ev when its kind is notified. A non-empty key identifies a failure, which is
+// not notified again within the cooldown.
+func (n *Notifier) send(ctx context.Context, key string, ev Event) {
+	if !n.kinds[ev.Kind] {
+		return
+	}
+	var b bytes.Buffer
+	if err := n.templates[ev.Kind].Execute(&b, ev); err != nil {
+		logging.From(ctx).Warn("failed to render notification", "event", ev.Kind, "error", err)
+		return
+	}
+	if !n.allow(key, time.Now()) {
+		logging.From(ctx).Debug("notification suppressed by rate limit", "event", ev.Kind)
+		return
+	}
+	if err := n.post(ctx, b.String()); err != nil {
+		logging.From(ctx).Warn("failed to post notification", "event", ev.Kind, "error", err)
+	}
+}
+
+// allow reports whether a message may be posted at now, recording it when it may.
+func (n *Notifier) allow(key string, now time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if key != "" && now.Sub(n.notified[key]) < n.Cooldown {
+		return false
+	}
+	recent := n.posted[:0]
+	for _, t := range n.posted {
+		if now.Sub(t) < time.Hour {
+			recent = append(recent, t)
+		}
+	}
+	n.posted = recent
+	if n.MaxPerHour > 0 && len(n.posted) >= n.MaxPerHour {
+		return false
+	}
+	n.posted = append(n.posted, now)
+	if key != "" {
+		n.notified[key] = now
+	}
+	return true
+}
+
+// post posts text to the webhook in its format.
+func (n *Notifier) post(ctx context.Context, text string) error {
+	hook := n.URL
+	if n.URLs != nil {
+		var err error
+		if hook, err = n.URLs.Token(ctx); err != nil {
+			return err
+		}
+	}
+	var payload interface{} = map[string]string{"text": text}
+	if n.Format == Teams {
+		payload = map[string]interface{}{
+			"type": "message",
+			"attachments": []map[string]interface{}{{
+				"contentType": "application/vnd.microsoft.card.adaptive",
+				"content": map[string]interface{}{
+					"type":    "AdaptiveCard",
+					"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+					"version": "1.4",
+					"body":    []map[string]interface{}{{"type": "TextBlock", "text": text, "wrap": true}},
+				},
+			}},
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := n.Client
+	if client == nil {
+		client = &http.Client{Timeout: postTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook responded %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/metrics"
+)
+
+// ErrBudgetExhausted is returned, without sending it, for a request that would exceed a call budget.
+var ErrBudgetExhausted = errors.New("api call budget exhausted")
+
+// Cap allows at most Calls requests within any Window.
+type Cap struct {
+	Calls  int
+	Window time.Duration
+}
+
+func (c Cap) String() string {
+	switch c.Window {
+	case time.Minute:
+		return fmt.Sprintf("%d/m", c.Calls)
+	case time.Hour:
+		return fmt.Sprintf("%d/h", c.Calls)
+	case 24 * time.Hour:
+		return fmt.Sprintf("%d/d", c.Calls)
+	}
+	return fmt.Sprintf("%d/%s", c.Calls, c.Window)
+}
+
+// ParseCaps parses a comma separated list of caps, each a number of calls per minute (m), hour (h), day (d)
+// or a duration, for example "600/m,10000/h" or "500/15m". An empty list has no caps.
+func ParseCaps(s string) ([]Cap, error) {
+	var caps []Cap
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		calls, per, ok := strings.Cut(part, "/")
+		n, err := strconv.Atoi(strings.TrimSpace(calls))
+		if !ok || err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid call budget %q, want calls per m, h, d or a duration such as 600/m", part)
+		}
+		c := Cap{Calls: n}
+		switch per = strings.TrimSpace(per); per {
+		case "m":
+			c.Window = time.Minute
+		case "h":
+			c.Window = time.Hour
+		case "d":
+			c.Window = 24 * time.Hour
+		default:
+			if c.Window, err = time.ParseDuration(per); err != nil || c.Window <= 0 {
+				return nil, fmt.Errorf("invalid call budget %q, want calls per m, h, d or a duration such as 600/m", part)
+			}
+		}
+		caps = append(caps, c)
+	}
+	return caps, nil
+}
+
+// Allowance enforces caps on the requests sent in any sliding window, counting every attempt. Requests over
+// a cap fail with ErrBudgetExhausted rather than waiting, so the sync defers the rest of its work to its next
+// cycle. An Allowance without caps allows every request.
+type Allowance struct {
+	name string
+
+	mu   sync.Mutex
+	caps []Cap
+	// sent holds the times of the requests sent within the longest window, oldest first.
+	sent []time.Time
+}
+
+// NewAllowance returns an Allowance enforcing caps, named in its errors and metrics.
+func NewAllowance(name string, caps []Cap) *Allowance {
+	return &Allowance{name: name, caps: caps}
+}
+
+// SetCaps replaces the caps, keeping the requests already counted.
+func (a *Allowance) SetCaps(caps []Cap) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.caps = caps
+}
+
+// take counts a request sent at now, or returns why it would exceed a cap.
+func (a *Allowance) take(now time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.caps) == 0 {
+		return nil
+	}
+	var longest time.Duration
+	for _, c := range a.caps {
+		if c.Window > longest {
+			longest = c.Window
+		}
+	}
+	a.sent = a.sent[a.since(now.Add(-longest)):]
+	for _, c := range a.caps {
+		if len(a.sent)-a.since(now.Add(-c.Window)) >= c.Calls {
+			metrics.BudgetExhausted.WithLabelValues(a.name).Inc()
+			return fmt.Errorf("%w: %s allows %s", ErrBudgetExhausted, a.name, c)
+		}
+	}
+	a.sent = append(a.sent, now)
+	return nil
+}
+
+// since returns the index of the first request sent after t. The caller must hold a.mu.
+func (a *Allowance) since(t time.Time) int {
+	return sort.Search(len(a.sent), func(i int) bool { return a.sent[i].After(t) })
+}
+
+// refund forgets the request counted at t by take.
+func (a *Allowance) refund(t time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i := len(a.sent) - 1; i >= 0; i-- {
+		if a.sent[i].Equal(t) {
+			a.sent = append(a.sent[:i], a.sent[i+1:]...)
+			return
+		}
+	}
+}
+
+type allowancesKey struct{}
+
+// WithAllowance returns a context whose requests are also counted against a, such as the budget of a sync
+// pair. A nil a returns ctx.
+func WithAllowance(ctx context.Context, a *Allowance) context.Context {
+	if a == nil {
+		return ctx
+	}
+	prev, _ := ctx.Value(allowancesKey{}).([]*Allowance)
+	return context.WithValue(ctx, allowancesKey{}, append(append([]*Allowance(nil), prev...), a))
+}
+
+// spend counts a request against every allowance applying to it, or against none of them when it would
+// exceed one.
+func spend(now time.Time, allowances []*Allowance) error {
+	for i, a := range allowances {
+		if err := a.take(now); err != nil {
+			for _, taken := range allowances[:i] {
+				taken.refund(now)
+			}
+			return err
+		}
+	}
+	return nil
+}
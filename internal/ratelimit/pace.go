@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Pace spaces requests evenly at a fixed rate. Unlike an Allowance it makes requests wait rather than fail,
+// so work carried by a paced context, such as the warm-up after a restart, trickles out instead of bursting.
+type Pace struct {
+	interval time.Duration
+
+	mu sync.Mutex
+	// next is the earliest time the next request may be sent.
+	next time.Time
+}
+
+// NewPace returns a Pace sending perSecond requests a second, or nil, which does not pace, for a rate of
+// zero or less.
+func NewPace(perSecond float64) *Pace {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &Pace{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// wait blocks until the slot reserved for the request comes up, or ctx is done.
+func (p *Pace) wait(ctx context.Context) error {
+	p.mu.Lock()
+	now := time.Now()
+	at := p.next
+	if at.Before(now) {
+		at = now
+	}
+	p.next = at.Add(p.interval)
+	p.mu.Unlock()
+
+	d := time.Until(at)
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+type paceKey struct{}
+
+// WithPace returns a context whose requests are spaced by p, across every provider. A nil p returns ctx.
+func WithPace(ctx context.Context, p *Pace) context.Context {
+	if p == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, paceKey{}, p)
+}
+
+// pace waits for the slot of a request with ctx when ctx is paced.
+func pace(ctx context.Context) error {
+	if p, _ := ctx.Value(paceKey{}).(*Pace); p != nil {
+		return p.wait(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,287 @@
+// Package ratelimit shares the request budget of an API between every client calling it.
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/metrics"
+)
+
+// Defaults used by New for zero options.
+const (
+	DefaultConcurrency = 8
+	DefaultRetries     = 5
+)
+
+// Retry-After is missing from some 429 responses. The wait then starts at minBackoff and doubles with every
+// consecutive rate limited response up to maxBackoff.
+const (
+	minBackoff = time.Second
+	maxBackoff = 2 * time.Minute
+)
+
+// Options configures a Limiter.
+type Options struct {
+	// Concurrency is the most requests in flight at once. The limiter lowers it while the provider is
+	// rate limiting and raises it again as requests succeed.
+	Concurrency int
+	// Retries is the number of times a rate limited request is retried before its 429 is returned.
+	Retries int
+	// Caps are the hard call budgets of the provider.
+	Caps []Cap
+	// Global, when set, is a call budget shared with the limiters of other providers.
+	Global *Allowance
+}
+
+// Limiter paces the requests sent to a single provider. Every client of the provider should share one
+// Limiter so they draw from the same budget: a 429 or an exhausted quota pauses all of them.
+type Limiter struct {
+	provider string
+	max      int
+	retries  int
+	// allowances are the call budgets every request of the provider is counted against.
+	allowances []*Allowance
+
+	mu       sync.Mutex
+	limit    int
+	inflight int
+	// successes counts the responses since limit last changed, used to raise it one step per limit successes.
+	successes int
+	// until pauses every request until the provider's budget is expected to be available again.
+	until   time.Time
+	backoff time.Duration
+	// wake is closed and replaced whenever a request slot is released or the pause ends early.
+	wake chan struct{}
+	// remaining is the quota the provider last reported, or -1 when it never did.
+	remaining int
+}
+
+// Budget is the state of the request budget of a Limiter.
+type Budget struct {
+	// Concurrency is the number of requests allowed in flight at once, lowered from Max while the provider
+	// rate limits. InFlight is the number of requests in flight.
+	Concurrency int `json:"concurrency"`
+	Max         int `json:"max"`
+	InFlight    int `json:"in_flight"`
+	// Remaining is the quota the provider last reported, or -1 when it does not report one.
+	Remaining int `json:"remaining"`
+	// PausedUntil is when requests are sent again while the limiter pauses them, zero otherwise.
+	PausedUntil time.Time `json:"paused_until"`
+}
+
+// New returns a Limiter for provider, which labels its metrics.
+func New(provider string, opts Options) *Limiter {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultConcurrency
+	}
+	if opts.Retries < 0 {
+		opts.Retries = 0
+	}
+	l := &Limiter{
+		provider:  provider,
+		max:       opts.Concurrency,
+		retries:   opts.Retries,
+		limit:     opts.Concurrency,
+		wake:      make(chan struct{}),
+		remaining: -1,
+	}
+	if len(opts.Caps) > 0 {
+		l.allowances = append(l.allowances, NewAllowance(provider, opts.Caps))
+	}
+	if opts.Global != nil {
+		l.allowances = append(l.allowances, opts.Global)
+	}
+	metrics.RateLimitConcurrency.WithLabelValues(provider).Set(float64(l.limit))
+	return l
+}
+
+// Budget returns the state of the request budget.
+func (l *Limiter) Budget() Budget {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := Budget{Concurrency: l.limit, Max: l.max, InFlight: l.inflight, Remaining: l.remaining}
+	if l.until.After(time.Now()) {
+		b.PausedUntil = l.until
+	}
+	return b
+}
+
+// Transport wraps next, pacing its requests and retrying the ones that are rate limited. A nil next uses
+// http.DefaultTransport.
+func (l *Limiter) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &transport{limiter: l, next: next}
+}
+
+type transport struct {
+	limiter *Limiter
+	next    http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	l := t.limiter
+	for attempt := 0; ; attempt++ {
+		if err := pace(req.Context()); err != nil {
+			return nil, err
+		}
+		if err := l.acquire(req.Context()); err != nil {
+			return nil, err
+		}
+		if err := l.spend(req.Context()); err != nil {
+			l.release(nil, err)
+			return nil, err
+		}
+		resp, err := t.next.RoundTrip(req)
+		l.release(resp, err)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || attempt >= l.retries {
+			return resp, err
+		}
+
+		// The request is retried once the pause set by release ends, which needs a fresh body.
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return resp, nil
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return resp, nil
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+		resp.Body.Close()
+		metrics.RateLimitRetries.WithLabelValues(l.provider).Inc()
+	}
+}
+
+// acquire waits for the pause to end and a request slot to free up.
+func (l *Limiter) acquire(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		wait := time.Until(l.until)
+		if wait <= 0 && l.inflight < l.limit {
+			l.inflight++
+			l.mu.Unlock()
+			return nil
+		}
+		wake := l.wake
+		l.mu.Unlock()
+
+		var timer *time.Timer
+		var expired <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			expired = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			err := ctx.Err()
+			if timer != nil {
+				timer.Stop()
+			}
+			return err
+		case <-wake:
+		case <-expired:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// spend counts a request against the call budgets of the provider and those carried by ctx.
+func (l *Limiter) spend(ctx context.Context) error {
+	allowances := l.allowances
+	if extra, _ := ctx.Value(allowancesKey{}).([]*Allowance); len(extra) > 0 {
+		allowances = append(append([]*Allowance(nil), allowances...), extra...)
+	}
+	if len(allowances) == 0 {
+		return nil
+	}
+	return spend(time.Now(), allowances)
+}
+
+// release frees the request slot taken by acquire and adapts the budget to the response.
+func (l *Limiter) release(resp *http.Response, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight--
+	close(l.wake)
+	l.wake = make(chan struct{})
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		// Back off multiplicatively: halve the concurrency and pause everyone for as long as asked.
+		if l.limit > 1 {
+			l.limit /= 2
+		}
+		l.successes = 0
+		wait, ok := retryAfter(resp.Header, now)
+		if !ok {
+			if l.backoff == 0 {
+				l.backoff = minBackoff
+			} else if l.backoff *= 2; l.backoff > maxBackoff {
+				l.backoff = maxBackoff
+			}
+			wait = l.backoff
+		}
+		l.pause(now.Add(wait))
+	} else {
+		l.backoff = 0
+		if l.limit < l.max {
+			if l.successes++; l.successes >= l.limit {
+				l.limit++
+				l.successes = 0
+			}
+		}
+	}
+	metrics.RateLimitConcurrency.WithLabelValues(l.provider).Set(float64(l.limit))
+
+	// Providers that report their remaining quota are paused until it resets once it is used up, rather
+	// than waiting for the 429.
+	if remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining")); err == nil {
+		l.remaining = remaining
+		metrics.RateLimitRemaining.WithLabelValues(l.provider).Set(float64(remaining))
+		if remaining <= 0 {
+			if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+				l.pause(time.Unix(reset, 0))
+			}
+		}
+	}
+}
+
+// pause holds every request until t, unless an earlier pause already lasts longer. The caller must hold l.mu.
+func (l *Limiter) pause(t time.Time) {
+	from := time.Now()
+	if l.until.After(from) {
+		from = l.until
+	}
+	if t.After(from) {
+		metrics.RateLimitPaused.WithLabelValues(l.provider).Add(t.Sub(from).Seconds())
+		l.until = t
+	}
+}
+
+// retryAfter parses the Retry-After header, given either in seconds or as an HTTP date.
+func retryAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return t.Sub(now), true
+	}
+	return 0, false
+}
@@ -0,0 +1,345 @@
+// Package replay records the API traffic of sync cycles, with the mapping stores they started from, and
+// replays it, so a cycle seen in production can be run again offline against exactly the responses it got.
+// Recordings hold no credentials: request headers are not recorded, and JSON fields, query parameters and
+// store settings named like tokens, secrets or passwords are redacted.
+package replay
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// Version is the version of the recording format.
+const Version = 1
+
+// redacted replaces the values of redacted fields.
+const redacted = "REDACTED"
+
+// Recording is the API traffic of a run and the stores it started from.
+type Recording struct {
+	Version  int       `json:"version"`
+	Recorded time.Time `json:"recorded"`
+	// Stores holds the snapshot of each store at the start of the run, keyed by the name of the ADO
+	// connection whose pairs use it, or "" for the store of the app.
+	Stores map[string]*store.Snapshot `json:"stores"`
+	// Exchanges lists the requests of the run and their responses in the order they were sent.
+	Exchanges []Exchange `json:"exchanges"`
+}
+
+// Exchange is a recorded request and its response.
+type Exchange struct {
+	// Provider is the API the request was sent to, as metrics label it.
+	Provider string `json:"provider"`
+	Method   string `json:"method"`
+	// URL is the path and query of the request.
+	URL string `json:"url"`
+	// RequestBody is the body of a request with a JSON body.
+	RequestBody string      `json:"request_body,omitempty"`
+	Status      int         `json:"status"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        string      `json:"body,omitempty"`
+	// Binary is set when Body is not text, such as an attachment, and base64 encoded.
+	Binary bool `json:"binary,omitempty"`
+}
+
+// key returns the key requests are matched to the exchange by.
+func (x *Exchange) key() string {
+	return x.Provider + " " + x.Method + " " + x.URL
+}
+
+// recordedHeaders lists the response headers kept in recordings. The rest may carry secrets, such as the
+// webhook handshake, and the clients do not depend on them.
+var recordedHeaders = []string{"Content-Type", "Retry-After", "X-RateLimit-Remaining", "X-RateLimit-Reset"}
+
+// Recorder records the requests sent through its transports.
+type Recorder struct {
+	mu  sync.Mutex
+	rec Recording
+}
+
+// NewRecorder returns an empty Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{rec: Recording{Version: Version, Recorded: time.Now().UTC(), Stores: map[string]*store.Snapshot{}}}
+}
+
+// Snapshot records snap as the state the store named name started from. Settings holding secrets, such
+// as the OAuth token and the webhook secret, are left out.
+func (r *Recorder) Snapshot(name string, snap *store.Snapshot) {
+	c := *snap
+	c.Settings = make(map[string]string, len(snap.Settings))
+	for k, v := range snap.Settings {
+		if !sensitive(k) {
+			c.Settings[k] = v
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rec.Stores[name] = &c
+}
+
+// Transport returns a transport recording the requests sent to provider through next. A nil next uses
+// http.DefaultTransport.
+func (r *Recorder) Transport(provider string, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &recordTransport{rec: r, provider: provider, next: next}
+}
+
+// Save writes the recording to w.
+func (r *Recorder) Save(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(&r.rec)
+}
+
+type recordTransport struct {
+	rec      *Recorder
+	provider string
+	next     http.RoundTripper
+}
+
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	x := Exchange{Provider: t.provider, Method: req.Method, URL: redactURL(req.URL)}
+	if req.Body != nil && req.GetBody != nil && isJSON(req.Header.Get("Content-Type")) {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		b, err := io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return nil, err
+		}
+		x.RequestBody = string(redactJSON(b))
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+
+	x.Status = resp.StatusCode
+	for _, h := range recordedHeaders {
+		if v := resp.Header.Get(h); v != "" {
+			if x.Header == nil {
+				x.Header = http.Header{}
+			}
+			x.Header.Set(h, v)
+		}
+	}
+	switch {
+	case isJSON(resp.Header.Get("Content-Type")):
+		x.Body = string(redactJSON(b))
+	case utf8.Valid(b):
+		x.Body = string(b)
+	default:
+		x.Body, x.Binary = base64.StdEncoding.EncodeToString(b), true
+	}
+	t.rec.mu.Lock()
+	t.rec.rec.Exchanges = append(t.rec.rec.Exchanges, x)
+	t.rec.mu.Unlock()
+	return resp, nil
+}
+
+// Replayer answers requests with the responses of a recording.
+type Replayer struct {
+	rec *Recording
+
+	mu sync.Mutex
+	// queues lists the exchanges not replayed yet by the key of their request, in recorded order.
+	queues map[string][]*Exchange
+}
+
+// Load reads a recording saved by a Recorder.
+func Load(r io.Reader) (*Replayer, error) {
+	var rec Recording
+	if err := json.NewDecoder(r).Decode(&rec); err != nil {
+		return nil, fmt.Errorf("reading recording: %w", err)
+	}
+	if rec.Version != Version {
+		return nil, fmt.Errorf("unsupported recording version %d", rec.Version)
+	}
+	p := &Replayer{rec: &rec, queues: map[string][]*Exchange{}}
+	for i := range rec.Exchanges {
+		x := &rec.Exchanges[i]
+		p.queues[x.key()] = append(p.queues[x.key()], x)
+	}
+	return p, nil
+}
+
+// Recorded returns when the recording was made.
+func (p *Replayer) Recorded() time.Time {
+	return p.rec.Recorded
+}
+
+// Snapshot returns the recorded state of the store named name, or nil when it was not recorded.
+func (p *Replayer) Snapshot(name string) *store.Snapshot {
+	return p.rec.Stores[name]
+}
+
+// Unplayed returns the number of recorded exchanges no request was answered with. It is zero once a replay
+// sent the same requests as the recorded run.
+func (p *Replayer) Unplayed() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, q := range p.queues {
+		n += len(q)
+	}
+	return n
+}
+
+// Transport returns a transport answering the requests sent to provider from the recording. Requests are
+// matched by method, path and query, and each recorded response answers a single request, in the order
+// they were recorded; among the responses to the same URL, the first whose request had the same body is
+// preferred. A request the recording has no more responses to fails.
+func (p *Replayer) Transport(provider string) http.RoundTripper {
+	return &replayTransport{replayer: p, provider: provider}
+}
+
+type replayTransport struct {
+	replayer *Replayer
+	provider string
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body string
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if isJSON(req.Header.Get("Content-Type")) {
+			body = string(redactJSON(b))
+		}
+	}
+	key := (&Exchange{Provider: t.provider, Method: req.Method, URL: redactURL(req.URL)}).key()
+	x := t.replayer.take(key, body)
+	if x == nil {
+		return nil, fmt.Errorf("replay: no recorded response to %s %s", req.Method, redactURL(req.URL))
+	}
+	b := []byte(x.Body)
+	if x.Binary {
+		var err error
+		if b, err = base64.StdEncoding.DecodeString(x.Body); err != nil {
+			return nil, fmt.Errorf("replay: decoding recorded response: %w", err)
+		}
+	}
+	header := x.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", x.Status, http.StatusText(x.Status)),
+		StatusCode:    x.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(b)),
+		ContentLength: int64(len(b)),
+		Request:       req,
+	}, nil
+}
+
+// take removes and returns the next exchange recorded for key, preferring one whose request had body.
+func (p *Replayer) take(key, body string) *Exchange {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	q := p.queues[key]
+	if len(q) == 0 {
+		return nil
+	}
+	i := 0
+	for j, x := range q {
+		if x.RequestBody == body {
+			i = j
+			break
+		}
+	}
+	x := q[i]
+	p.queues[key] = append(q[:i:i], q[i+1:]...)
+	return x
+}
+
+// sensitive reports whether values named name may hold credentials. Continuation tokens only page lists.
+func sensitive(name string) bool {
+	n := strings.ToLower(name)
+	if strings.Contains(n, "continuation") {
+		return false
+	}
+	return strings.Contains(n, "token") || strings.Contains(n, "secret") || strings.Contains(n, "password")
+}
+
+// redactURL returns the path and query of u, with the values of sensitive query parameters redacted.
+func redactURL(u *url.URL) string {
+	q := u.Query()
+	for k := range q {
+		if sensitive(k) {
+			q.Set(k, redacted)
+		}
+	}
+	if len(q) == 0 {
+		return u.EscapedPath()
+	}
+	return u.EscapedPath() + "?" + q.Encode()
+}
+
+// redactJSON returns the JSON document b with the values of sensitive fields redacted, or b when it is not
+// valid JSON.
+func redactJSON(b []byte) []byte {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return b
+	}
+	out, err := json.Marshal(redactValue(v))
+	if err != nil {
+		return b
+	}
+	return out
+}
+
+func redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if sensitive(k) {
+				v[k] = redacted
+			} else {
+				v[k] = redactValue(e)
+			}
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = redactValue(e)
+		}
+	}
+	return v
+}
+
+// isJSON reports whether contentType is a JSON media type, such as application/json-patch+json.
+func isJSON(contentType string) bool {
+	return strings.Contains(strings.ToLower(contentType), "json")
+}
@@ -0,0 +1,431 @@
+// Package richtext converts Azure DevOps HTML into the rich text accepted by Asana's html_notes.
+package richtext
+
+import (
+	"html"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	xhtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// inline maps the inline elements that are kept to the Asana element they become.
+var inline = map[atom.Atom]string{
+	atom.Strong: "strong",
+	atom.B:      "strong",
+	atom.Em:     "em",
+	atom.I:      "em",
+	atom.U:      "u",
+	atom.Ins:    "u",
+	atom.S:      "s",
+	atom.Strike: "s",
+	atom.Del:    "s",
+	atom.Code:   "code",
+	atom.Tt:     "code",
+	atom.Kbd:    "code",
+}
+
+// blocks maps the block elements that are kept to the Asana element they become. Asana has two heading
+// levels, so smaller headings share the second.
+var blocks = map[atom.Atom]string{
+	atom.Ul:         "ul",
+	atom.Ol:         "ol",
+	atom.Li:         "li",
+	atom.Blockquote: "blockquote",
+	atom.H1:         "h1",
+	atom.H2:         "h2",
+	atom.H3:         "h2",
+	atom.H4:         "h2",
+	atom.H5:         "h2",
+	atom.H6:         "h2",
+}
+
+// breaks are the elements dropped while their content is kept on a line of its own.
+var breaks = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Br: true, atom.Tr: true, atom.Table: true,
+	atom.Section: true, atom.Article: true, atom.Header: true, atom.Footer: true,
+	atom.Dl: true, atom.Dt: true, atom.Dd: true,
+}
+
+// dropped are the elements removed along with their content.
+var dropped = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Head: true, atom.Title: true, atom.Iframe: true,
+	atom.Object: true, atom.Embed: true, atom.Noscript: true, atom.Template: true,
+}
+
+// Options controls a conversion.
+type Options struct {
+	// Image returns the GID of the Asana attachment an inline image with the given source is shown from. An
+	// image it returns no GID for, or every image when Image is nil, is replaced by its alt text.
+	Image func(src, alt string) string
+}
+
+// Convert returns the HTML fragment s as Asana rich text. Bold, italic, underline, strikethrough, inline
+// code, links, headings, lists, quotes, code blocks and rules are kept. Other elements are removed and their
+// text kept, paragraphs and table rows becoming lines; scripts and styles are removed entirely. Only http,
+// https and mailto links are kept. The result is well formed and safe to embed in html_notes.
+func Convert(s string, opts Options) string {
+	nodes, err := parse(s)
+	if err != nil {
+		return html.EscapeString(s)
+	}
+	w := &writer{opts: opts}
+	for _, n := range nodes {
+		w.node(n)
+	}
+	return strings.TrimSpace(w.b.String())
+}
+
+// Sanitize converts s without inline images.
+func Sanitize(s string) string {
+	return Convert(s, Options{})
+}
+
+// Images returns the sources of the inline images in s, in order and without duplicates.
+func Images(s string) []string {
+	nodes, err := parse(s)
+	if err != nil {
+		return nil
+	}
+	var srcs []string
+	seen := map[string]bool{}
+	var walk func(n *xhtml.Node)
+	walk = func(n *xhtml.Node) {
+		if n.Type == xhtml.ElementNode && n.DataAtom == atom.Img {
+			if src := attr(n, "src"); src != "" && !seen[src] {
+				seen[src] = true
+				srcs = append(srcs, src)
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	for _, n := range nodes {
+		walk(n)
+	}
+	return srcs
+}
+
+// PlainText returns the text of the HTML fragment s, with list items as "- " lines and links followed by
+// their URL.
+func PlainText(s string) string {
+	nodes, err := parse(s)
+	if err != nil {
+		return s
+	}
+	w := &writer{plain: true}
+	for _, n := range nodes {
+		w.node(n)
+	}
+	return strings.TrimSpace(w.b.String())
+}
+
+// Truncate returns the longest start of the rich text s, as returned by Convert, that is at most n bytes long
+// with the elements it leaves open closed, and whether anything was cut. Text is cut at the last space that
+// keeps most of it, and never within a character or an entity.
+func Truncate(s string, n int) (string, bool) {
+	if len(s) <= n {
+		return s, false
+	}
+	z := xhtml.NewTokenizer(strings.NewReader(s))
+	var b strings.Builder
+	var open []string
+	// closing is the length of the end tags of the open elements.
+	closing := 0
+	fits := func(extra int) bool { return b.Len()+extra+closing <= n }
+tokens:
+	for {
+		tt := z.Next()
+		raw := string(z.Raw())
+		switch tt {
+		case xhtml.ErrorToken:
+			break tokens
+		case xhtml.TextToken:
+			if fits(len(raw)) {
+				b.WriteString(raw)
+				continue
+			}
+			b.WriteString(cutText(raw, n-b.Len()-closing))
+			break tokens
+		case xhtml.StartTagToken:
+			name, _ := z.TagName()
+			if !fits(len(raw) + len(name) + 3) {
+				break tokens
+			}
+			b.WriteString(raw)
+			open = append(open, string(name))
+			closing += len(name) + 3
+		case xhtml.EndTagToken:
+			if len(open) > 0 {
+				b.WriteString(raw)
+				closing -= len(open[len(open)-1]) + 3
+				open = open[:len(open)-1]
+			}
+		default:
+			if !fits(len(raw)) {
+				break tokens
+			}
+			b.WriteString(raw)
+		}
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		b.WriteString("</" + open[i] + ">")
+	}
+	return b.String(), true
+}
+
+// cutText returns the start of the escaped text s that is at most n bytes long.
+func cutText(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if n >= len(s) {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	s = s[:n]
+	if amp := strings.LastIndex(s, "&"); amp > strings.LastIndex(s, ";") {
+		s = s[:amp]
+	}
+	if sp := strings.LastIndexAny(s, " \n"); sp > len(s)/2 {
+		s = s[:sp]
+	}
+	return strings.TrimRight(s, " \n")
+}
+
+// parse parses s as the content of a body element.
+func parse(s string) ([]*xhtml.Node, error) {
+	return xhtml.ParseFragment(strings.NewReader(s), &xhtml.Node{Type: xhtml.ElementNode, Data: "body", DataAtom: atom.Body})
+}
+
+// writer renders converted nodes.
+type writer struct {
+	b     strings.Builder
+	opts  Options
+	plain bool
+	// pre is set inside a code block, which holds text only.
+	pre bool
+	// block is set right after a block element tag or a list bullet, where no line break is needed.
+	block bool
+}
+
+// text writes the text s. Outside code blocks runs of white space collapse to a single space, which is
+// dropped at the start of a line.
+func (w *writer) text(s string) {
+	if !w.pre {
+		s = collapse(s)
+		if cur := w.b.String(); strings.HasPrefix(s, " ") && (cur == "" || w.block || strings.HasSuffix(cur, "\n") || strings.HasSuffix(cur, " ")) {
+			s = s[1:]
+		}
+	}
+	if s == "" {
+		return
+	}
+	w.block = false
+	if w.plain {
+		w.b.WriteString(s)
+		return
+	}
+	w.b.WriteString(html.EscapeString(s))
+}
+
+func (w *writer) node(n *xhtml.Node) {
+	switch n.Type {
+	case xhtml.TextNode:
+		w.text(n.Data)
+		return
+	case xhtml.ElementNode:
+	default:
+		w.children(n)
+		return
+	}
+
+	switch a := n.DataAtom; {
+	case dropped[a]:
+	case w.pre:
+		if a == atom.Br {
+			w.b.WriteString("\n")
+		}
+		w.children(n)
+	case a == atom.Pre:
+		w.pre = true
+		w.blockElem(n, "pre")
+		w.pre = false
+	case a == atom.A:
+		href := safeURL(attr(n, "href"))
+		switch {
+		case href == "":
+			w.children(n)
+		case w.plain:
+			w.children(n)
+			if text := textOf(n); text != href {
+				w.b.WriteString(" (" + href + ")")
+			}
+		default:
+			w.wrap(n, "a", ` href="`+html.EscapeString(href)+`"`)
+		}
+	case a == atom.Img:
+		w.image(n)
+	case a == atom.Hr:
+		if w.plain {
+			w.newline()
+			return
+		}
+		w.b.WriteString("<hr/>")
+		w.block = true
+	case a == atom.Li && w.plain:
+		w.newline()
+		w.b.WriteString("- ")
+		w.block = true
+		w.children(n)
+		w.newline()
+	case a == atom.Td || a == atom.Th:
+		if n.PrevSibling != nil {
+			w.b.WriteString(" | ")
+		}
+		w.children(n)
+	case inline[a] != "":
+		w.wrap(n, inline[a], "")
+	case blocks[a] != "":
+		w.blockElem(n, blocks[a])
+	case breaks[a]:
+		w.newline()
+		w.children(n)
+		w.newline()
+	default:
+		w.children(n)
+	}
+}
+
+// image renders an inline image from its Asana attachment, or its alt text.
+func (w *writer) image(n *xhtml.Node) {
+	alt := attr(n, "alt")
+	if !w.plain && w.opts.Image != nil {
+		if gid := w.opts.Image(attr(n, "src"), alt); gid != "" {
+			w.b.WriteString(`<img data-asana-gid="` + html.EscapeString(gid) + `"/>`)
+			w.block = false
+			return
+		}
+	}
+	if alt != "" {
+		w.text("[" + alt + "]")
+	}
+}
+
+// wrap renders n as the inline element name with the given attributes. Plain text has no elements.
+func (w *writer) wrap(n *xhtml.Node, name, attrs string) {
+	if w.plain {
+		w.children(n)
+		return
+	}
+	w.b.WriteString("<" + name + attrs + ">")
+	w.block = false
+	w.children(n)
+	w.b.WriteString("</" + name + ">")
+}
+
+// blockElem renders n as the block element name, or as lines of its own in plain text.
+func (w *writer) blockElem(n *xhtml.Node, name string) {
+	if w.plain {
+		w.newline()
+		w.children(n)
+		w.newline()
+		return
+	}
+	w.trimNewline()
+	w.b.WriteString("<" + name + ">")
+	w.block = true
+	w.children(n)
+	w.trimNewline()
+	w.b.WriteString("</" + name + ">")
+	w.block = true
+}
+
+func (w *writer) children(n *xhtml.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		w.node(c)
+	}
+}
+
+// newline ends the current line unless the output is empty, already ends one or ends with a block tag.
+// Trailing white space of the line is dropped.
+func (w *writer) newline() {
+	if w.block {
+		return
+	}
+	s := w.b.String()
+	if t := strings.TrimRight(s, " "); t != s {
+		w.b.Reset()
+		w.b.WriteString(t)
+		s = t
+	}
+	if s != "" && !strings.HasSuffix(s, "\n") {
+		w.b.WriteString("\n")
+	}
+}
+
+// trimNewline removes a line break ending the output, as block tags start and end lines of their own.
+func (w *writer) trimNewline() {
+	if s := w.b.String(); strings.HasSuffix(s, "\n") {
+		w.b.Reset()
+		w.b.WriteString(strings.TrimRight(s, "\n"))
+	}
+}
+
+// collapse replaces every run of white space in s with a single space.
+func collapse(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		if r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '\f' {
+			if !space {
+				b.WriteByte(' ')
+			}
+			space = true
+			continue
+		}
+		space = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// textOf returns the text content of n.
+func textOf(n *xhtml.Node) string {
+	if n.Type == xhtml.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(textOf(c))
+	}
+	return b.String()
+}
+
+// attr returns the value of the named attribute of n.
+func attr(n *xhtml.Node, name string) string {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// safeURL returns u when it is an absolute http, https or mailto URL, and an empty string otherwise.
+func safeURL(u string) string {
+	p, err := url.Parse(strings.TrimSpace(u))
+	if err != nil {
+		return ""
+	}
+	switch strings.ToLower(p.Scheme) {
+	case "http", "https", "mailto":
+		return p.String()
+	}
+	return ""
+}
@@ -0,0 +1,249 @@
+// Package schedule decides when the sync cycles of a pair run, from a fixed interval or a cron expression.
+package schedule
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Schedule returns the times a job runs.
+type Schedule interface {
+	// Next returns the first run after t, or the zero time when there is none.
+	Next(t time.Time) time.Time
+}
+
+// Every returns a Schedule running every d.
+func Every(d time.Duration) Schedule {
+	return interval(d)
+}
+
+type interval time.Duration
+
+func (i interval) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(i))
+}
+
+// descriptors are the shorthands accepted in place of the five cron fields.
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses a cron expression with the five fields minute, hour, day of month, month and day of week, for
+// example "*/15 8-18 * * MON-FRI". Fields are lists of values, ranges and steps; months and days of week may
+// be given by their English abbreviation, and Sunday is 0 or 7. As in cron, a run matches either day field
+// when both are restricted. The descriptors @hourly, @daily, @weekly, @monthly and @yearly are also accepted,
+// as is "@every <duration>". Times are in the local time zone unless the expression starts with
+// "CRON_TZ=<zone> ".
+func Parse(expr string) (Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	loc := time.Local
+	if rest := strings.TrimPrefix(expr, "CRON_TZ="); rest != expr {
+		zone, spec, _ := strings.Cut(rest, " ")
+		var err error
+		if loc, err = time.LoadLocation(zone); err != nil {
+			return nil, fmt.Errorf("schedule %q: %w", expr, err)
+		}
+		expr = strings.TrimSpace(spec)
+	}
+	if strings.HasPrefix(expr, "@every ") {
+		every, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(expr, "@every ")))
+		if err != nil || every <= 0 {
+			return nil, fmt.Errorf("schedule %q: invalid interval", expr)
+		}
+		return Every(every), nil
+	}
+	spec := expr
+	if d, ok := descriptors[strings.ToLower(expr)]; ok {
+		spec = d
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %q: expected 5 fields, found %d", expr, len(fields))
+	}
+	c := &cron{loc: loc}
+	var err error
+	for i, f := range []struct {
+		set        *bits
+		min, max   int
+		names      []string
+		restricted *bool
+	}{
+		{set: &c.minute, max: 59},
+		{set: &c.hour, max: 23},
+		{set: &c.dom, min: 1, max: 31, restricted: &c.domSet},
+		{set: &c.month, min: 1, max: 12, names: months},
+		{set: &c.dow, max: 7, names: days, restricted: &c.dowSet},
+	} {
+		if *f.set, err = parseField(fields[i], f.min, f.max, f.names); err != nil {
+			return nil, fmt.Errorf("schedule %q: %w", expr, err)
+		}
+		if f.restricted != nil {
+			*f.restricted = fields[i] != "*" && fields[i] != "?"
+		}
+	}
+	// Sunday may be given as 7.
+	if c.dow.has(7) {
+		c.dow |= 1
+	}
+	if c.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("schedule %q never runs", expr)
+	}
+	return c, nil
+}
+
+var (
+	months = []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	days   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// bits is a set of the values of a cron field.
+type bits uint64
+
+func (b bits) has(v int) bool { return b&(1<<uint(v)) != 0 }
+
+// parseField parses a comma separated list of values, ranges and steps between min and max.
+func parseField(s string, min, max int, names []string) (bits, error) {
+	var set bits
+	for _, part := range strings.Split(s, ",") {
+		r, step, hasStep := strings.Cut(part, "/")
+		lo, hi := min, max
+		switch {
+		case r == "*" || r == "?":
+		case strings.Contains(r, "-"):
+			a, b, _ := strings.Cut(r, "-")
+			var err error
+			if lo, err = value(a, min, max, names); err != nil {
+				return 0, err
+			}
+			if hi, err = value(b, min, max, names); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range %q", r)
+			}
+		default:
+			v, err := value(r, min, max, names)
+			if err != nil {
+				return 0, err
+			}
+			lo = v
+			if !hasStep {
+				hi = v
+			}
+		}
+		n := 1
+		if hasStep {
+			var err error
+			if n, err = strconv.Atoi(step); err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part)
+			}
+		}
+		for v := lo; v <= hi; v += n {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// value parses a single field value, either a number or one of names.
+func value(s string, min, max int, names []string) (int, error) {
+	for i, n := range names {
+		if n != "" && strings.EqualFold(s, n) {
+			return i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < min || v > max {
+		return 0, fmt.Errorf("invalid value %q, expected %d-%d", s, min, max)
+	}
+	return v, nil
+}
+
+// cron is a parsed cron expression.
+type cron struct {
+	minute, hour, dom, month, dow bits
+	// domSet and dowSet are set when the day fields are restricted.
+	domSet, dowSet bool
+	loc            *time.Location
+}
+
+// searchYears bounds the search for the next run of expressions that never match, such as "0 0 30 2 *".
+const searchYears = 5
+
+func (c *cron) Next(t time.Time) time.Time {
+	t = t.In(c.loc).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(searchYears, 0, 0)
+	for t.Before(limit) {
+		y, m, d := t.Date()
+		switch {
+		case !c.month.has(int(m)):
+			t = time.Date(y, m+1, 1, 0, 0, 0, 0, c.loc)
+		case !c.day(t):
+			t = time.Date(y, m, d+1, 0, 0, 0, 0, c.loc)
+		case !c.hour.has(t.Hour()):
+			t = time.Date(y, m, d, t.Hour()+1, 0, 0, 0, c.loc)
+		case !c.minute.has(t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// day reports whether the date of t matches the day fields.
+func (c *cron) day(t time.Time) bool {
+	dom, dow := c.dom.has(t.Day()), c.dow.has(int(t.Weekday()))
+	if c.domSet && c.dowSet {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// Gap returns the longest time between consecutive runs of s starting during the week following from, which
+// is how long a healthy job may go without running. It is zero when s runs at most once.
+func Gap(s Schedule, from time.Time) time.Duration {
+	var gap time.Duration
+	end := from.AddDate(0, 0, 7)
+	for prev := s.Next(from); !prev.IsZero(); {
+		next := s.Next(prev)
+		if next.IsZero() {
+			break
+		}
+		if d := next.Sub(prev); d > gap {
+			gap = d
+		}
+		if !next.Before(end) {
+			break
+		}
+		prev = next
+	}
+	return gap
+}
+
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// Jitter returns a random duration between zero and max, which delays a run so jobs sharing a schedule
+// do not all start at once.
+func Jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	return time.Duration(jitterRand.Int63n(int64(max)))
+}
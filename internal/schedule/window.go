@@ -0,0 +1,56 @@
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Window is a period recurring on a cron schedule, such as a maintenance window, that lasts a fixed duration
+// from each run.
+type Window struct {
+	expr     string
+	start    Schedule
+	duration time.Duration
+}
+
+// ParseWindow parses a window given as a cron expression accepted by Parse, followed by "for" and the
+// duration of the window, for example "CRON_TZ=Europe/London 0 22 * * FRI for 10h". Intervals such as
+// "@every 1h" are rejected, as their windows would never close.
+func ParseWindow(expr string) (Window, error) {
+	spec, length, ok := strings.Cut(strings.TrimSpace(expr), " for ")
+	if !ok {
+		return Window{}, fmt.Errorf("window %q: expected <schedule> for <duration>", expr)
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(length))
+	if err != nil || d <= 0 {
+		return Window{}, fmt.Errorf("window %q: invalid duration", expr)
+	}
+	start, err := Parse(spec)
+	if err != nil {
+		return Window{}, fmt.Errorf("window %q: %w", expr, err)
+	}
+	if _, ok := start.(*cron); !ok {
+		return Window{}, fmt.Errorf("window %q: expected a cron expression", expr)
+	}
+	return Window{expr: strings.TrimSpace(expr), start: start, duration: d}, nil
+}
+
+// Open returns the start and end of the window open at t, and false when none is open. Of overlapping windows
+// the one that opened first counts.
+func (w Window) Open(t time.Time) (start, end time.Time, ok bool) {
+	if w.start == nil {
+		return time.Time{}, time.Time{}, false
+	}
+	// The window open at t, if any, opened on the first run after t less the duration.
+	start = w.start.Next(t.Add(-w.duration))
+	if start.IsZero() || start.After(t) {
+		return time.Time{}, time.Time{}, false
+	}
+	return start, start.Add(w.duration), true
+}
+
+// String returns the expression the window was parsed from.
+func (w Window) String() string {
+	return w.expr
+}
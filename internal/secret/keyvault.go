@@ -0,0 +1,78 @@
+package secret
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// KeyVaultScope is the OAuth scope of Azure Key Vault.
+const KeyVaultScope = "https://vault.azure.net/.default"
+
+// keyVaultAPIVersion is the version of the Key Vault REST API used.
+const keyVaultAPIVersion = "7.4"
+
+// KeyVault reads secrets from Azure Key Vault. References have the form
+// keyvault://<vault>/<secret>[/<version>], where vault is the name of the vault or its host name for
+// sovereign clouds; without a version the current one is read.
+type KeyVault struct {
+	// Tokens supplies access tokens for KeyVaultScope.
+	Tokens TokenSource
+	// HTTP is the client used for requests, defaulting to http.DefaultClient.
+	HTTP *http.Client
+}
+
+// Secret implements Provider.
+func (k *KeyVault) Secret(ctx context.Context, ref *url.URL) (string, error) {
+	host := ref.Host
+	if !strings.Contains(host, ".") {
+		host += ".vault.azure.net"
+	}
+	name, version, _ := strings.Cut(strings.Trim(ref.Path, "/"), "/")
+	if name == "" {
+		return "", errors.New("reference names no secret")
+	}
+	u := "https://" + host + "/secrets/" + url.PathEscape(name)
+	if version != "" {
+		u += "/" + url.PathEscape(version)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u+"?api-version="+keyVaultAPIVersion, nil)
+	if err != nil {
+		return "", err
+	}
+	token, err := k.Tokens.Token(ctx)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+
+	client := k.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Value string `json:"value"`
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	derr := json.NewDecoder(resp.Body).Decode(&body)
+	switch {
+	case resp.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("key vault: %d %s: %s", resp.StatusCode, body.Error.Code, body.Error.Message)
+	case derr != nil:
+		return "", fmt.Errorf("decoding key vault response: %w", derr)
+	}
+	return body.Value, nil
+}
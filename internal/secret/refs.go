@@ -0,0 +1,122 @@
+package secret
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/logging"
+)
+
+// Reference schemes.
+const (
+	// SchemeKeyVault refers to a secret in Azure Key Vault, see KeyVault.
+	SchemeKeyVault = "keyvault"
+	// SchemeVault refers to a secret in HashiCorp Vault, see Vault.
+	SchemeVault = "vault"
+)
+
+// DefaultRefresh is how often a Source resolves its reference again.
+const DefaultRefresh = 15 * time.Minute
+
+// failedRefreshDelay is how long a Source whose refresh failed keeps its last secret before trying again.
+const failedRefreshDelay = time.Minute
+
+// Provider reads secrets from a secret manager.
+type Provider interface {
+	// Secret returns the secret ref refers to.
+	Secret(ctx context.Context, ref *url.URL) (string, error)
+}
+
+// TokenSource supplies the access tokens a Provider authenticates with.
+type TokenSource interface {
+	// Token returns a valid access token.
+	Token(ctx context.Context) (string, error)
+}
+
+// IsReference reports whether value refers to a secret held in a secret manager, such as
+// keyvault://contoso/ado-pat, rather than being the secret itself.
+func IsReference(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == SchemeKeyVault || u.Scheme == SchemeVault) && u.Host != ""
+}
+
+// Resolver resolves references with the Provider registered for their scheme.
+type Resolver map[string]Provider
+
+// Resolve returns the secret value refers to, or value itself when it is not a reference.
+func (r Resolver) Resolve(ctx context.Context, value string) (string, error) {
+	if !IsReference(value) {
+		return value, nil
+	}
+	u, _ := url.Parse(value)
+	p := r[u.Scheme]
+	if p == nil {
+		return "", fmt.Errorf("secret: %s references are not configured", u.Scheme)
+	}
+	s, err := p.Secret(ctx, u)
+	if err != nil {
+		return "", fmt.Errorf("secret: resolving %s: %w", redact(u), err)
+	}
+	if s == "" {
+		return "", fmt.Errorf("secret: %s is empty", redact(u))
+	}
+	return s, nil
+}
+
+// redact returns ref without any user info it may hold.
+func redact(ref *url.URL) string {
+	u := *ref
+	u.User = nil
+	return u.String()
+}
+
+// Source supplies a secret held in a secret manager, resolving its reference again every refresh so rotated
+// secrets are picked up. A failed refresh keeps the last secret. It is safe for concurrent use.
+type Source struct {
+	resolver Resolver
+	ref      string
+	refresh  time.Duration
+
+	mu    sync.Mutex
+	value string
+	next  time.Time
+}
+
+// NewSource returns a Source for ref, resolving it right away so a bad reference is reported at startup.
+// A zero refresh uses DefaultRefresh.
+func NewSource(ctx context.Context, r Resolver, ref string, refresh time.Duration) (*Source, error) {
+	if refresh <= 0 {
+		refresh = DefaultRefresh
+	}
+	s := &Source{resolver: r, ref: ref, refresh: refresh}
+	if _, err := s.Token(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Token returns the secret.
+func (s *Source) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.value != "" && time.Now().Before(s.next) {
+		return s.value, nil
+	}
+	v, err := s.resolver.Resolve(ctx, s.ref)
+	if err != nil {
+		if s.value == "" {
+			return "", err
+		}
+		logging.From(ctx).Warn("failed to refresh secret, keeping the last value", "error", err)
+		s.next = time.Now().Add(failedRefreshDelay)
+		return s.value, nil
+	}
+	if s.value != "" && v != s.value {
+		logging.From(ctx).Info("picked up rotated secret", "reference", s.ref)
+	}
+	s.value, s.next = v, time.Now().Add(s.refresh)
+	return v, nil
+}
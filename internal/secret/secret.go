@@ -0,0 +1,72 @@
+// Package secret encrypts credentials before they are persisted in the store, and reads the credentials
+// held in Azure Key Vault or HashiCorp Vault.
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// Box seals and opens values with AES-256-GCM.
+type Box struct {
+	aead cipher.AEAD
+	id   string
+}
+
+// New returns a Box using key. A key that decodes as 32 bytes of standard base64 is used as is; any other
+// key is treated as a passphrase and hashed with SHA-256.
+func New(key string) (*Box, error) {
+	if key == "" {
+		return nil, errors.New("secret: empty key")
+	}
+	k, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(k) != 32 {
+		sum := sha256.Sum256([]byte(key))
+		k = sum[:]
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, fmt.Errorf("secret: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("secret: %w", err)
+	}
+	id := sha256.Sum256(append([]byte("ado-asana-sync key id:"), k...))
+	return &Box{aead: aead, id: hex.EncodeToString(id[:4])}, nil
+}
+
+// ID identifies the key of the box without revealing it, so values can record which key sealed them.
+func (b *Box) ID() string { return b.id }
+
+// Seal encrypts plaintext and returns it base64 encoded with its nonce.
+func (b *Box) Seal(plaintext []byte) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("secret: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(b.aead.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+// Open decrypts a value returned by Seal.
+func (b *Box) Open(sealed string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("secret: %w", err)
+	}
+	n := b.aead.NonceSize()
+	if len(data) < n {
+		return nil, errors.New("secret: sealed value too short")
+	}
+	plaintext, err := b.aead.Open(nil, data[:n], data[n:], nil)
+	if err != nil {
+		return nil, errors.New("secret: decryption failed, check the key")
+	}
+	return plaintext, nil
+}
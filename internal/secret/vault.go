@@ -0,0 +1,81 @@
+package secret
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultVaultKey is the key read from a Vault secret when its reference names none.
+const DefaultVaultKey = "value"
+
+// Vault reads secrets from the KV version 2 secrets engine of HashiCorp Vault. References have the form
+// vault://<mount>/<path>#<key>, for example vault://secret/ado-asana-sync#ado_pat; the key defaults to
+// DefaultVaultKey.
+type Vault struct {
+	// Addr is the address of the Vault server, for example https://vault.example.com:8200.
+	Addr string
+	// Token authenticates requests.
+	Token string
+	// Namespace is the Vault Enterprise namespace of the secrets, if any.
+	Namespace string
+	// HTTP is the client used for requests, defaulting to http.DefaultClient.
+	HTTP *http.Client
+}
+
+// Secret implements Provider.
+func (v *Vault) Secret(ctx context.Context, ref *url.URL) (string, error) {
+	if v.Addr == "" {
+		return "", errors.New("vault address is not set")
+	}
+	path := strings.Trim(ref.Path, "/")
+	if path == "" {
+		return "", errors.New("reference names no secret")
+	}
+	key := ref.Fragment
+	if key == "" {
+		key = DefaultVaultKey
+	}
+	u := strings.TrimRight(v.Addr, "/") + "/v1/" + url.PathEscape(ref.Host) + "/data/" + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	client := v.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+		Errors []string `json:"errors"`
+	}
+	derr := json.NewDecoder(resp.Body).Decode(&body)
+	switch {
+	case resp.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("vault: %d %s", resp.StatusCode, strings.Join(body.Errors, "; "))
+	case derr != nil:
+		return "", fmt.Errorf("decoding vault response: %w", derr)
+	}
+	s, ok := body.Data.Data[key].(string)
+	if !ok {
+		return "", fmt.Errorf("vault secret has no %q key", key)
+	}
+	return s, nil
+}
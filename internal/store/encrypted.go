@@ -0,0 +1,595 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/danstis/ado-asana-sync/internal/secret"
+)
+
+// sealedPrefix starts the values sealed by Encrypted, followed by the ID of the key and a colon.
+const sealedPrefix = "enc:v1:"
+
+// Encrypted is a Store that encrypts the contents of the records it writes to another store with AES-256-GCM:
+// the titles and tags of mappings, the values of conflicts, the origins of comments, the URLs and names of
+// attachments, the errors of retries, the names of projects, the changes of audit records, the items and
+// tasks of journal entries, the fields of samples and the values of settings. IDs, times and the keys of
+// settings are stored as they are, so records can still be looked up and ordered. Values written before
+// encryption was enabled are read as they are until Rekey seals them.
+type Encrypted struct {
+	Store
+	key *secret.Box
+	// keys are the boxes values are opened with by key ID, including key.
+	keys map[string]*secret.Box
+}
+
+// NewEncrypted returns a store sealing the records written to s with key. Values sealed with one of the
+// previous keys are still read, so the key can be rotated without downtime.
+func NewEncrypted(s Store, key *secret.Box, previous ...*secret.Box) *Encrypted {
+	e := &Encrypted{Store: s, key: key, keys: map[string]*secret.Box{key.ID(): key}}
+	for _, p := range previous {
+		if _, ok := e.keys[p.ID()]; !ok {
+			e.keys[p.ID()] = p
+		}
+	}
+	return e
+}
+
+// seal encrypts v with the current key. Empty values are kept empty.
+func (e *Encrypted) seal(v string) (string, error) {
+	if v == "" {
+		return "", nil
+	}
+	sealed, err := e.key.Seal([]byte(v))
+	if err != nil {
+		return "", fmt.Errorf("store: %w", err)
+	}
+	return sealedPrefix + e.key.ID() + ":" + sealed, nil
+}
+
+// open decrypts a value returned by seal. Values that are not sealed are returned as they are.
+func (e *Encrypted) open(v string) (string, error) {
+	if !strings.HasPrefix(v, sealedPrefix) {
+		return v, nil
+	}
+	id, sealed, ok := strings.Cut(strings.TrimPrefix(v, sealedPrefix), ":")
+	if !ok {
+		return "", errors.New("store: malformed encrypted value")
+	}
+	box, ok := e.keys[id]
+	if !ok {
+		return "", fmt.Errorf("store: value encrypted with unknown key %s, set it as a previous key", id)
+	}
+	b, err := box.Open(sealed)
+	if err != nil {
+		return "", fmt.Errorf("store: %w", err)
+	}
+	return string(b), nil
+}
+
+// current reports whether v needs no rewrite to be sealed with the current key.
+func (e *Encrypted) current(v string) bool {
+	return v == "" || strings.HasPrefix(v, sealedPrefix+e.key.ID()+":")
+}
+
+// transform applies f to every encrypted value of snap in place, stopping at the first error.
+func transform(snap *Snapshot, f func(*string) error) error {
+	var vals []*string
+	for i := range snap.Mappings {
+		m := &snap.Mappings[i]
+		vals = append(vals, &m.Title)
+		for j := range m.Tags {
+			vals = append(vals, &m.Tags[j])
+		}
+	}
+	for i := range snap.Conflicts {
+		vals = append(vals, &snap.Conflicts[i].ADOValue, &snap.Conflicts[i].AsanaValue)
+	}
+	for i := range snap.Comments {
+		vals = append(vals, &snap.Comments[i].Origin)
+	}
+	for i := range snap.Attachments {
+		vals = append(vals, &snap.Attachments[i].ADOURL, &snap.Attachments[i].Name)
+	}
+	for i := range snap.Retries {
+		vals = append(vals, &snap.Retries[i].LastError)
+	}
+	for i := range snap.Projects {
+		vals = append(vals, &snap.Projects[i].Name)
+	}
+	for i := range snap.Audit {
+		for j := range snap.Audit[i].Changes {
+			c := &snap.Audit[i].Changes[j]
+			vals = append(vals, &c.Before, &c.After)
+		}
+	}
+	for i := range snap.Journal {
+		vals = append(vals, &snap.Journal[i].Item, &snap.Journal[i].Task)
+	}
+	for i := range snap.Samples {
+		s := &snap.Samples[i]
+		vals = append(vals, &s.Type, &s.State, &s.Iteration, &s.Area)
+	}
+	for _, v := range vals {
+		if err := f(v); err != nil {
+			return err
+		}
+	}
+	keys := make([]string, 0, len(snap.Settings))
+	for k := range snap.Settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := snap.Settings[k]
+		if err := f(&v); err != nil {
+			return err
+		}
+		snap.Settings[k] = v
+	}
+	return nil
+}
+
+// sealAll seals the encrypted values of snap in place.
+func (e *Encrypted) sealAll(snap *Snapshot) error {
+	return transform(snap, func(v *string) (err error) {
+		*v, err = e.seal(*v)
+		return err
+	})
+}
+
+// openAll opens the encrypted values of snap in place.
+func (e *Encrypted) openAll(snap *Snapshot) error {
+	return transform(snap, func(v *string) (err error) {
+		*v, err = e.open(*v)
+		return err
+	})
+}
+
+// sealed returns a copy of the records holding the encrypted values, sealed.
+func (e *Encrypted) sealed(snap Snapshot) (*Snapshot, error) {
+	c := copySnapshot(snap)
+	if err := e.sealAll(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// opened returns a copy of the records read from the underlying store, with the encrypted values opened.
+func (e *Encrypted) opened(snap Snapshot) (*Snapshot, error) {
+	c := copySnapshot(snap)
+	if err := e.openAll(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// copySnapshot copies the records of snap whose values are encrypted, so they can be changed without
+// changing the caller's.
+func copySnapshot(snap Snapshot) *Snapshot {
+	c := snap
+	c.Mappings = append([]Mapping(nil), snap.Mappings...)
+	for i := range c.Mappings {
+		c.Mappings[i].Tags = append([]string(nil), c.Mappings[i].Tags...)
+	}
+	c.Conflicts = append([]Conflict(nil), snap.Conflicts...)
+	c.Comments = append([]CommentMapping(nil), snap.Comments...)
+	c.Attachments = append([]AttachmentMapping(nil), snap.Attachments...)
+	c.Retries = append([]Retry(nil), snap.Retries...)
+	c.Projects = append([]Project(nil), snap.Projects...)
+	c.Audit = append([]AuditRecord(nil), snap.Audit...)
+	for i := range c.Audit {
+		c.Audit[i].Changes = append([]FieldChange(nil), c.Audit[i].Changes...)
+	}
+	c.Journal = append([]JournalEntry(nil), snap.Journal...)
+	c.Samples = append([]Sample(nil), snap.Samples...)
+	if snap.Settings != nil {
+		c.Settings = make(map[string]string, len(snap.Settings))
+		for k, v := range snap.Settings {
+			c.Settings[k] = v
+		}
+	}
+	return &c
+}
+
+// Get implements Store.
+func (e *Encrypted) Get(ctx context.Context, adoID int) (Mapping, error) {
+	m, err := e.Store.Get(ctx, adoID)
+	if err != nil {
+		return m, err
+	}
+	return e.mapping(m)
+}
+
+// ByAsanaGID implements Store.
+func (e *Encrypted) ByAsanaGID(ctx context.Context, gid string) (Mapping, error) {
+	m, err := e.Store.ByAsanaGID(ctx, gid)
+	if err != nil {
+		return m, err
+	}
+	return e.mapping(m)
+}
+
+// mapping opens a mapping read from the underlying store.
+func (e *Encrypted) mapping(m Mapping) (Mapping, error) {
+	snap, err := e.opened(Snapshot{Mappings: []Mapping{m}})
+	if err != nil {
+		return Mapping{}, err
+	}
+	return snap.Mappings[0], nil
+}
+
+// Put implements Store.
+func (e *Encrypted) Put(ctx context.Context, m Mapping) error {
+	snap, err := e.sealed(Snapshot{Mappings: []Mapping{m}})
+	if err != nil {
+		return err
+	}
+	return e.Store.Put(ctx, snap.Mappings[0])
+}
+
+// All implements Store.
+func (e *Encrypted) All(ctx context.Context) ([]Mapping, error) {
+	all, err := e.Store.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := e.opened(Snapshot{Mappings: all})
+	if err != nil {
+		return nil, err
+	}
+	return snap.Mappings, nil
+}
+
+// Conflict implements Store.
+func (e *Encrypted) Conflict(ctx context.Context, adoID int, field string) (Conflict, error) {
+	c, err := e.Store.Conflict(ctx, adoID, field)
+	if err != nil {
+		return c, err
+	}
+	snap, err := e.opened(Snapshot{Conflicts: []Conflict{c}})
+	if err != nil {
+		return Conflict{}, err
+	}
+	return snap.Conflicts[0], nil
+}
+
+// PutConflict implements Store.
+func (e *Encrypted) PutConflict(ctx context.Context, c Conflict) error {
+	snap, err := e.sealed(Snapshot{Conflicts: []Conflict{c}})
+	if err != nil {
+		return err
+	}
+	return e.Store.PutConflict(ctx, snap.Conflicts[0])
+}
+
+// Conflicts implements Store.
+func (e *Encrypted) Conflicts(ctx context.Context) ([]Conflict, error) {
+	all, err := e.Store.Conflicts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := e.opened(Snapshot{Conflicts: all})
+	if err != nil {
+		return nil, err
+	}
+	return snap.Conflicts, nil
+}
+
+// CommentByADO implements Store.
+func (e *Encrypted) CommentByADO(ctx context.Context, adoID, commentID int) (CommentMapping, error) {
+	c, err := e.Store.CommentByADO(ctx, adoID, commentID)
+	if err != nil {
+		return c, err
+	}
+	return e.comment(c)
+}
+
+// CommentByAsana implements Store.
+func (e *Encrypted) CommentByAsana(ctx context.Context, storyGID string) (CommentMapping, error) {
+	c, err := e.Store.CommentByAsana(ctx, storyGID)
+	if err != nil {
+		return c, err
+	}
+	return e.comment(c)
+}
+
+// comment opens a comment mapping read from the underlying store.
+func (e *Encrypted) comment(c CommentMapping) (CommentMapping, error) {
+	snap, err := e.opened(Snapshot{Comments: []CommentMapping{c}})
+	if err != nil {
+		return CommentMapping{}, err
+	}
+	return snap.Comments[0], nil
+}
+
+// PutComment implements Store.
+func (e *Encrypted) PutComment(ctx context.Context, c CommentMapping) error {
+	snap, err := e.sealed(Snapshot{Comments: []CommentMapping{c}})
+	if err != nil {
+		return err
+	}
+	return e.Store.PutComment(ctx, snap.Comments[0])
+}
+
+// Attachments implements Store.
+func (e *Encrypted) Attachments(ctx context.Context, adoID int) ([]AttachmentMapping, error) {
+	all, err := e.Store.Attachments(ctx, adoID)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := e.opened(Snapshot{Attachments: all})
+	if err != nil {
+		return nil, err
+	}
+	return snap.Attachments, nil
+}
+
+// PutAttachment implements Store.
+func (e *Encrypted) PutAttachment(ctx context.Context, a AttachmentMapping) error {
+	snap, err := e.sealed(Snapshot{Attachments: []AttachmentMapping{a}})
+	if err != nil {
+		return err
+	}
+	return e.Store.PutAttachment(ctx, snap.Attachments[0])
+}
+
+// Retry implements Store.
+func (e *Encrypted) Retry(ctx context.Context, adoID int) (Retry, error) {
+	r, err := e.Store.Retry(ctx, adoID)
+	if err != nil {
+		return r, err
+	}
+	snap, err := e.opened(Snapshot{Retries: []Retry{r}})
+	if err != nil {
+		return Retry{}, err
+	}
+	return snap.Retries[0], nil
+}
+
+// PutRetry implements Store.
+func (e *Encrypted) PutRetry(ctx context.Context, r Retry) error {
+	snap, err := e.sealed(Snapshot{Retries: []Retry{r}})
+	if err != nil {
+		return err
+	}
+	return e.Store.PutRetry(ctx, snap.Retries[0])
+}
+
+// Retries implements Store.
+func (e *Encrypted) Retries(ctx context.Context) ([]Retry, error) {
+	all, err := e.Store.Retries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := e.opened(Snapshot{Retries: all})
+	if err != nil {
+		return nil, err
+	}
+	return snap.Retries, nil
+}
+
+// Project implements Store. Sealed names cannot be looked up, so the project is found among those of the
+// pair.
+func (e *Encrypted) Project(ctx context.Context, pair, name string) (Project, error) {
+	p, _, err := e.project(ctx, pair, name)
+	return p, err
+}
+
+// project returns the project of the pair with the given name, and its name as the underlying store holds
+// it.
+func (e *Encrypted) project(ctx context.Context, pair, name string) (Project, string, error) {
+	all, err := e.Store.Projects(ctx)
+	if err != nil {
+		return Project{}, "", err
+	}
+	for _, p := range all {
+		if p.Pair != pair {
+			continue
+		}
+		stored := p.Name
+		if p.Name, err = e.open(stored); err != nil {
+			return Project{}, "", err
+		}
+		if p.Name == name {
+			return p, stored, nil
+		}
+	}
+	return Project{}, "", ErrNotFound
+}
+
+// PutProject implements Store. A project registered before keeps its name as stored, so it is replaced
+// rather than registered twice.
+func (e *Encrypted) PutProject(ctx context.Context, p Project) error {
+	_, stored, err := e.project(ctx, p.Pair, p.Name)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		if stored, err = e.seal(p.Name); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	}
+	p.Name = stored
+	return e.Store.PutProject(ctx, p)
+}
+
+// Projects implements Store.
+func (e *Encrypted) Projects(ctx context.Context) ([]Project, error) {
+	all, err := e.Store.Projects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := e.opened(Snapshot{Projects: all})
+	if err != nil {
+		return nil, err
+	}
+	// The underlying store ordered them by their sealed names.
+	sort.SliceStable(snap.Projects, func(i, j int) bool {
+		a, b := snap.Projects[i], snap.Projects[j]
+		return a.Pair < b.Pair || a.Pair == b.Pair && a.Name < b.Name
+	})
+	return snap.Projects, nil
+}
+
+// PutAudit implements Store.
+func (e *Encrypted) PutAudit(ctx context.Context, r AuditRecord) error {
+	snap, err := e.sealed(Snapshot{Audit: []AuditRecord{r}})
+	if err != nil {
+		return err
+	}
+	return e.Store.PutAudit(ctx, snap.Audit[0])
+}
+
+// Audit implements Store.
+func (e *Encrypted) Audit(ctx context.Context, f AuditFilter) ([]AuditRecord, error) {
+	all, err := e.Store.Audit(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := e.opened(Snapshot{Audit: all})
+	if err != nil {
+		return nil, err
+	}
+	return snap.Audit, nil
+}
+
+// PutJournal implements Store.
+func (e *Encrypted) PutJournal(ctx context.Context, j JournalEntry) error {
+	snap, err := e.sealed(Snapshot{Journal: []JournalEntry{j}})
+	if err != nil {
+		return err
+	}
+	return e.Store.PutJournal(ctx, snap.Journal[0])
+}
+
+// Journal implements Store.
+func (e *Encrypted) Journal(ctx context.Context, f JournalFilter) ([]JournalEntry, error) {
+	all, err := e.Store.Journal(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := e.opened(Snapshot{Journal: all})
+	if err != nil {
+		return nil, err
+	}
+	return snap.Journal, nil
+}
+
+// PutSample implements Store.
+func (e *Encrypted) PutSample(ctx context.Context, smp Sample) error {
+	snap, err := e.sealed(Snapshot{Samples: []Sample{smp}})
+	if err != nil {
+		return err
+	}
+	return e.Store.PutSample(ctx, snap.Samples[0])
+}
+
+// Samples implements Store.
+func (e *Encrypted) Samples(ctx context.Context, f SampleFilter) ([]Sample, error) {
+	all, err := e.Store.Samples(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := e.opened(Snapshot{Samples: all})
+	if err != nil {
+		return nil, err
+	}
+	return snap.Samples, nil
+}
+
+// Setting implements Store.
+func (e *Encrypted) Setting(ctx context.Context, key string) (string, error) {
+	v, err := e.Store.Setting(ctx, key)
+	if err != nil {
+		return v, err
+	}
+	return e.open(v)
+}
+
+// SetSetting implements Store.
+func (e *Encrypted) SetSetting(ctx context.Context, key, value string) error {
+	sealed, err := e.seal(value)
+	if err != nil {
+		return err
+	}
+	return e.Store.SetSetting(ctx, key, sealed)
+}
+
+// Export implements Store. The records are returned decrypted.
+func (e *Encrypted) Export(ctx context.Context) (*Snapshot, error) {
+	snap, err := e.Store.Export(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.openAll(snap); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// Import implements Store.
+func (e *Encrypted) Import(ctx context.Context, snap *Snapshot) error {
+	sealed, err := e.sealed(*snap)
+	if err != nil {
+		return err
+	}
+	return e.Store.Import(ctx, sealed)
+}
+
+// replacer is implemented by the stores whose records can be replaced at once.
+type replacer interface {
+	Replace(ctx context.Context, snap *Snapshot) error
+}
+
+// Replace seals the values of snap and replaces every record of the underlying store with its records.
+func (e *Encrypted) Replace(ctx context.Context, snap *Snapshot) error {
+	r, ok := e.Store.(replacer)
+	if !ok {
+		return fmt.Errorf("store: %T cannot be replaced", e.Store)
+	}
+	sealed, err := e.sealed(*snap)
+	if err != nil {
+		return err
+	}
+	return r.Replace(ctx, sealed)
+}
+
+// Rekey seals every value of the store that is not sealed with the current key, whether it is sealed with a
+// previous key or was written before encryption was enabled, and returns how many were rewritten. Once it
+// returns, the previous keys are no longer needed.
+func (e *Encrypted) Rekey(ctx context.Context) (int, error) {
+	r, ok := e.Store.(replacer)
+	if !ok {
+		return 0, fmt.Errorf("store: %T cannot be rekeyed", e.Store)
+	}
+	snap, err := e.Store.Export(ctx)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	err = transform(snap, func(v *string) error {
+		if e.current(*v) {
+			return nil
+		}
+		plain, err := e.open(*v)
+		if err != nil {
+			return err
+		}
+		if *v, err = e.seal(plain); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	if err != nil || n == 0 {
+		return 0, err
+	}
+	if err := r.Replace(ctx, snap); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,247 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Export formats.
+const (
+	// FormatJSON writes the snapshot as a single JSON document, as the file backend stores it.
+	FormatJSON = "json"
+	// FormatNDJSON writes a header line followed by one line per record, which suits large stores and
+	// line based tools.
+	FormatNDJSON = "ndjson"
+)
+
+// FormatFor returns the export format of path: NDJSON for .ndjson and .jsonl files, JSON otherwise.
+func FormatFor(path string) string {
+	if p := strings.ToLower(path); strings.HasSuffix(p, ".ndjson") || strings.HasSuffix(p, ".jsonl") {
+		return FormatNDJSON
+	}
+	return FormatJSON
+}
+
+// ndjsonKind is the kind of the header line of NDJSON exports.
+const ndjsonKind = "snapshot"
+
+// ndjsonHeader starts an NDJSON export.
+type ndjsonHeader struct {
+	Kind     string    `json:"kind"`
+	Version  int       `json:"version"`
+	Exported time.Time `json:"exported"`
+}
+
+// ndjsonRecord is a line of an NDJSON export holding a record of the given kind.
+type ndjsonRecord struct {
+	Kind string          `json:"kind"`
+	Data json.RawMessage `json:"data"`
+}
+
+// setting is a store setting in NDJSON exports.
+type setting struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// WriteSnapshot writes snap to w in the given format.
+func WriteSnapshot(w io.Writer, snap *Snapshot, format string) error {
+	version := snap.Version
+	if version == 0 {
+		version = SnapshotVersion
+	}
+	switch format {
+	case FormatJSON:
+		s := *snap
+		s.Version = version
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(&s)
+	case FormatNDJSON:
+	default:
+		return fmt.Errorf("store: unknown export format %q, expected %s or %s", format, FormatJSON, FormatNDJSON)
+	}
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	if err := enc.Encode(ndjsonHeader{Kind: ndjsonKind, Version: version, Exported: time.Now().UTC()}); err != nil {
+		return err
+	}
+	write := func(kind string, v interface{}) error {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return enc.Encode(ndjsonRecord{Kind: kind, Data: b})
+	}
+	for _, m := range snap.Mappings {
+		if err := write("mapping", m); err != nil {
+			return err
+		}
+	}
+	for _, c := range snap.Conflicts {
+		if err := write("conflict", c); err != nil {
+			return err
+		}
+	}
+	for _, c := range snap.Comments {
+		if err := write("comment", c); err != nil {
+			return err
+		}
+	}
+	for _, a := range snap.Attachments {
+		if err := write("attachment", a); err != nil {
+			return err
+		}
+	}
+	for _, r := range snap.Retries {
+		if err := write("retry", r); err != nil {
+			return err
+		}
+	}
+	for _, p := range snap.Projects {
+		if err := write("project", p); err != nil {
+			return err
+		}
+	}
+	for _, r := range snap.Audit {
+		if err := write("audit", r); err != nil {
+			return err
+		}
+	}
+	for _, j := range snap.Journal {
+		if err := write("journal", j); err != nil {
+			return err
+		}
+	}
+	for _, s := range snap.Samples {
+		if err := write("sample", s); err != nil {
+			return err
+		}
+	}
+	keys := make([]string, 0, len(snap.Settings))
+	for k := range snap.Settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := write("setting", setting{Key: k, Value: snap.Settings[k]}); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadSnapshot reads a snapshot written by WriteSnapshot in either format, or a file backend's file. It
+// rejects snapshots of a newer version than this build understands.
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	var first json.RawMessage
+	if err := dec.Decode(&first); err != nil {
+		return nil, fmt.Errorf("store: decoding snapshot: %w", err)
+	}
+	var head ndjsonHeader
+	if err := json.Unmarshal(first, &head); err != nil {
+		return nil, fmt.Errorf("store: decoding snapshot: %w", err)
+	}
+	if head.Kind != ndjsonKind {
+		var snap Snapshot
+		if err := json.Unmarshal(first, &snap); err != nil {
+			return nil, fmt.Errorf("store: decoding snapshot: %w", err)
+		}
+		return &snap, checkVersion(snap.Version)
+	}
+	if err := checkVersion(head.Version); err != nil {
+		return nil, err
+	}
+
+	snap := &Snapshot{Version: head.Version}
+	for line := 2; ; line++ {
+		var rec ndjsonRecord
+		if err := dec.Decode(&rec); errors.Is(err, io.EOF) {
+			return snap, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("store: decoding record %d: %w", line, err)
+		}
+		if err := snap.add(rec); err != nil {
+			return nil, fmt.Errorf("store: decoding record %d: %w", line, err)
+		}
+	}
+}
+
+// checkVersion fails for snapshot versions newer than SnapshotVersion.
+func checkVersion(v int) error {
+	if v > SnapshotVersion {
+		return fmt.Errorf("store: snapshot version %d is newer than the supported version %d", v, SnapshotVersion)
+	}
+	return nil
+}
+
+// add adds the record of an NDJSON export to snap.
+func (snap *Snapshot) add(rec ndjsonRecord) error {
+	var err error
+	switch rec.Kind {
+	case "mapping":
+		var m Mapping
+		if err = json.Unmarshal(rec.Data, &m); err == nil {
+			snap.Mappings = append(snap.Mappings, m)
+		}
+	case "conflict":
+		var c Conflict
+		if err = json.Unmarshal(rec.Data, &c); err == nil {
+			snap.Conflicts = append(snap.Conflicts, c)
+		}
+	case "comment":
+		var c CommentMapping
+		if err = json.Unmarshal(rec.Data, &c); err == nil {
+			snap.Comments = append(snap.Comments, c)
+		}
+	case "attachment":
+		var a AttachmentMapping
+		if err = json.Unmarshal(rec.Data, &a); err == nil {
+			snap.Attachments = append(snap.Attachments, a)
+		}
+	case "retry":
+		var r Retry
+		if err = json.Unmarshal(rec.Data, &r); err == nil {
+			snap.Retries = append(snap.Retries, r)
+		}
+	case "project":
+		var p Project
+		if err = json.Unmarshal(rec.Data, &p); err == nil {
+			snap.Projects = append(snap.Projects, p)
+		}
+	case "audit":
+		var r AuditRecord
+		if err = json.Unmarshal(rec.Data, &r); err == nil {
+			snap.Audit = append(snap.Audit, r)
+		}
+	case "journal":
+		var j JournalEntry
+		if err = json.Unmarshal(rec.Data, &j); err == nil {
+			snap.Journal = append(snap.Journal, j)
+		}
+	case "sample":
+		var s Sample
+		if err = json.Unmarshal(rec.Data, &s); err == nil {
+			snap.Samples = append(snap.Samples, s)
+		}
+	case "setting":
+		var s setting
+		if err = json.Unmarshal(rec.Data, &s); err == nil {
+			if snap.Settings == nil {
+				snap.Settings = map[string]string{}
+			}
+			snap.Settings[s.Key] = s.Value
+		}
+	default:
+		return fmt.Errorf("unknown record kind %q", rec.Kind)
+	}
+	return err
+}
@@ -0,0 +1,53 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// OpenFile opens the JSON file store at path, creating an empty store if the file does not exist.
+// Every change is written to disk immediately.
+func OpenFile(path string) (*Memory, error) {
+	s := NewMemory()
+	b, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("store: reading %s: %w", path, err)
+	default:
+		var snap Snapshot
+		if err := json.Unmarshal(b, &snap); err != nil {
+			return nil, fmt.Errorf("store: decoding %s: %w", path, err)
+		}
+		s.load(&snap)
+	}
+
+	s.writeThrough = true
+	s.save = func(_ context.Context, snap *Snapshot) error {
+		return writeFile(path, snap)
+	}
+	return s, nil
+}
+
+// writeFile writes snap to path atomically.
+func writeFile(path string, snap *Snapshot) error {
+	b, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+	return nil
+}
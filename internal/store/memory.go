@@ -0,0 +1,622 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// conflictKey identifies a conflict by work item and field.
+type conflictKey struct {
+	adoID int
+	field string
+}
+
+// projectKey identifies a provisioned project by pair and name.
+type projectKey struct {
+	pair string
+	name string
+}
+
+// commentKey identifies a work item comment.
+type commentKey struct {
+	adoID     int
+	commentID int
+}
+
+// Memory is a Store held entirely in memory. It backs the file and S3 stores, which persist it as a
+// Snapshot, and is used on its own for dry runs.
+type Memory struct {
+	mu              sync.RWMutex
+	mappings        map[int]Mapping
+	conflicts       map[conflictKey]Conflict
+	comments        map[commentKey]CommentMapping
+	commentsByStory map[string]CommentMapping
+	attachments     map[int][]AttachmentMapping
+	retries         map[int]Retry
+	projects        map[projectKey]Project
+	audit           []AuditRecord
+	journal         []JournalEntry
+	samples         []Sample
+	settings        map[string]string
+	// leases are only shared within the process, and are not part of snapshots.
+	leases map[string]lease
+
+	// save persists a snapshot of the store. When writeThrough is set it is called after every change,
+	// otherwise only by Flush once something changed.
+	save         func(ctx context.Context, snap *Snapshot) error
+	writeThrough bool
+	dirty        bool
+}
+
+// lease is the holder of a lease and when it expires.
+type lease struct {
+	holder  string
+	expires time.Time
+}
+
+// NewMemory returns an empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{
+		mappings:        map[int]Mapping{},
+		conflicts:       map[conflictKey]Conflict{},
+		comments:        map[commentKey]CommentMapping{},
+		commentsByStory: map[string]CommentMapping{},
+		attachments:     map[int][]AttachmentMapping{},
+		retries:         map[int]Retry{},
+		projects:        map[projectKey]Project{},
+		settings:        map[string]string{},
+		leases:          map[string]lease{},
+	}
+}
+
+// Get implements Store.
+func (s *Memory) Get(_ context.Context, adoID int) (Mapping, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m, ok := s.mappings[adoID]
+	if !ok {
+		return Mapping{}, ErrNotFound
+	}
+	return m, nil
+}
+
+// ByAsanaGID implements Store.
+func (s *Memory) ByAsanaGID(_ context.Context, gid string) (Mapping, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, m := range s.mappings {
+		if m.AsanaGID == gid {
+			return m, nil
+		}
+	}
+	return Mapping{}, ErrNotFound
+}
+
+// Put implements Store.
+func (s *Memory) Put(ctx context.Context, m Mapping) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mappings[m.ADOID] = m
+	return s.changed(ctx)
+}
+
+// Delete implements Store.
+func (s *Memory) Delete(ctx context.Context, adoID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.mappings, adoID)
+	return s.changed(ctx)
+}
+
+// All implements Store.
+func (s *Memory) All(_ context.Context) ([]Mapping, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sortedMappings(), nil
+}
+
+// Conflict implements Store.
+func (s *Memory) Conflict(_ context.Context, adoID int, field string) (Conflict, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.conflicts[conflictKey{adoID, field}]
+	if !ok {
+		return Conflict{}, ErrNotFound
+	}
+	return c, nil
+}
+
+// PutConflict implements Store.
+func (s *Memory) PutConflict(ctx context.Context, c Conflict) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conflicts[conflictKey{c.ADOID, c.Field}] = c
+	return s.changed(ctx)
+}
+
+// DeleteConflict implements Store.
+func (s *Memory) DeleteConflict(ctx context.Context, adoID int, field string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conflicts, conflictKey{adoID, field})
+	return s.changed(ctx)
+}
+
+// Conflicts implements Store.
+func (s *Memory) Conflicts(_ context.Context) ([]Conflict, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sortedConflicts(), nil
+}
+
+// CommentByADO implements Store.
+func (s *Memory) CommentByADO(_ context.Context, adoID, commentID int) (CommentMapping, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.comments[commentKey{adoID, commentID}]
+	if !ok {
+		return CommentMapping{}, ErrNotFound
+	}
+	return c, nil
+}
+
+// CommentByAsana implements Store.
+func (s *Memory) CommentByAsana(_ context.Context, storyGID string) (CommentMapping, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.commentsByStory[storyGID]
+	if !ok {
+		return CommentMapping{}, ErrNotFound
+	}
+	return c, nil
+}
+
+// PutComment implements Store.
+func (s *Memory) PutComment(ctx context.Context, c CommentMapping) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.putComment(c)
+	return s.changed(ctx)
+}
+
+func (s *Memory) putComment(c CommentMapping) {
+	s.comments[commentKey{c.ADOID, c.ADOCommentID}] = c
+	s.commentsByStory[c.AsanaStoryGID] = c
+}
+
+// Attachments implements Store.
+func (s *Memory) Attachments(_ context.Context, adoID int) ([]AttachmentMapping, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]AttachmentMapping(nil), s.attachments[adoID]...), nil
+}
+
+// PutAttachment implements Store.
+func (s *Memory) PutAttachment(ctx context.Context, a AttachmentMapping) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attachments[a.ADOID] = append(s.attachments[a.ADOID], a)
+	return s.changed(ctx)
+}
+
+// Retry implements Store.
+func (s *Memory) Retry(_ context.Context, adoID int) (Retry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, ok := s.retries[adoID]
+	if !ok {
+		return Retry{}, ErrNotFound
+	}
+	return r, nil
+}
+
+// PutRetry implements Store.
+func (s *Memory) PutRetry(ctx context.Context, r Retry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retries[r.ADOID] = r
+	return s.changed(ctx)
+}
+
+// DeleteRetry implements Store.
+func (s *Memory) DeleteRetry(ctx context.Context, adoID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.retries, adoID)
+	return s.changed(ctx)
+}
+
+// Retries implements Store.
+func (s *Memory) Retries(_ context.Context) ([]Retry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sortedRetries(), nil
+}
+
+// Project implements Store.
+func (s *Memory) Project(_ context.Context, pair, name string) (Project, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.projects[projectKey{pair, name}]
+	if !ok {
+		return Project{}, ErrNotFound
+	}
+	return p, nil
+}
+
+// PutProject implements Store.
+func (s *Memory) PutProject(ctx context.Context, p Project) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.projects[projectKey{p.Pair, p.Name}] = p
+	return s.changed(ctx)
+}
+
+// Projects implements Store.
+func (s *Memory) Projects(_ context.Context) ([]Project, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sortedProjects(), nil
+}
+
+// PutAudit implements Store.
+func (s *Memory) PutAudit(ctx context.Context, r AuditRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.audit = append(s.audit, r)
+	return s.changed(ctx)
+}
+
+// Audit implements Store.
+func (s *Memory) Audit(_ context.Context, f AuditFilter) ([]AuditRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var all []AuditRecord
+	for _, r := range s.audit {
+		if f.match(r) {
+			all = append(all, r)
+		}
+	}
+	if f.Limit > 0 && len(all) > f.Limit {
+		all = all[len(all)-f.Limit:]
+	}
+	return all, nil
+}
+
+// PruneAudit implements Store.
+func (s *Memory) PruneAudit(ctx context.Context, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.audit[:0]
+	for _, r := range s.audit {
+		if !r.Time.Before(before) {
+			kept = append(kept, r)
+		}
+	}
+	n := len(s.audit) - len(kept)
+	s.audit = kept
+	if n == 0 {
+		return 0, nil
+	}
+	return n, s.changed(ctx)
+}
+
+// PutJournal implements Store.
+func (s *Memory) PutJournal(ctx context.Context, j JournalEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.journal = append(s.journal, j)
+	return s.changed(ctx)
+}
+
+// Journal implements Store.
+func (s *Memory) Journal(_ context.Context, f JournalFilter) ([]JournalEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var all []JournalEntry
+	for _, j := range s.journal {
+		if f.match(j) {
+			all = append(all, j)
+		}
+	}
+	return all, nil
+}
+
+// PruneJournal implements Store.
+func (s *Memory) PruneJournal(ctx context.Context, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.journal[:0]
+	for _, j := range s.journal {
+		if !j.Time.Before(before) {
+			kept = append(kept, j)
+		}
+	}
+	n := len(s.journal) - len(kept)
+	s.journal = kept
+	if n == 0 {
+		return 0, nil
+	}
+	return n, s.changed(ctx)
+}
+
+// PutSample implements Store.
+func (s *Memory) PutSample(ctx context.Context, smp Sample) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.samples = append(s.samples, smp)
+	return s.changed(ctx)
+}
+
+// Samples implements Store.
+func (s *Memory) Samples(_ context.Context, f SampleFilter) ([]Sample, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var all []Sample
+	for _, smp := range s.samples {
+		if f.match(smp) {
+			all = append(all, smp)
+		}
+	}
+	return all, nil
+}
+
+// PruneSamples implements Store.
+func (s *Memory) PruneSamples(ctx context.Context, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.samples[:0]
+	for _, smp := range s.samples {
+		if !smp.Time.Before(before) {
+			kept = append(kept, smp)
+		}
+	}
+	n := len(s.samples) - len(kept)
+	s.samples = kept
+	if n == 0 {
+		return 0, nil
+	}
+	return n, s.changed(ctx)
+}
+
+// Setting implements Store.
+func (s *Memory) Setting(_ context.Context, key string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.settings[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return v, nil
+}
+
+// SetSetting implements Store.
+func (s *Memory) SetSetting(ctx context.Context, key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.settings[key] = value
+	return s.changed(ctx)
+}
+
+// Export implements Store.
+func (s *Memory) Export(_ context.Context) (*Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.snapshot(), nil
+}
+
+// Import implements Store.
+func (s *Memory) Import(ctx context.Context, snap *Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load(snap)
+	return s.changed(ctx)
+}
+
+// AcquireLease takes the named lease as SQL.AcquireLease does. Leases of memory stores are only shared by
+// the users of the same store value, such as the electors of end-to-end tests; the file and S3 stores cannot
+// elect a leader among processes.
+func (s *Memory) AcquireLease(_ context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if l, ok := s.leases[name]; ok && l.holder != holder && now.Before(l.expires) {
+		return false, nil
+	}
+	s.leases[name] = lease{holder: holder, expires: now.Add(ttl)}
+	return true, nil
+}
+
+// Leases returns every lease as SQL.Leases does.
+func (s *Memory) Leases(_ context.Context) ([]Lease, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	leases := make([]Lease, 0, len(s.leases))
+	for name, l := range s.leases {
+		leases = append(leases, Lease{Name: name, Holder: l.holder, Expires: l.expires})
+	}
+	sort.Slice(leases, func(i, j int) bool { return leases[i].Name < leases[j].Name })
+	return leases, nil
+}
+
+// ReleaseLease gives up the named lease as SQL.ReleaseLease does.
+func (s *Memory) ReleaseLease(_ context.Context, name, holder string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.leases[name].holder == holder {
+		delete(s.leases, name)
+	}
+	return nil
+}
+
+// Replace removes every record from the store and loads snap in their place.
+func (s *Memory) Replace(ctx context.Context, snap *Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	empty := NewMemory()
+	s.mappings, s.conflicts, s.comments, s.commentsByStory = empty.mappings, empty.conflicts, empty.comments, empty.commentsByStory
+	s.attachments, s.retries, s.projects, s.audit, s.journal, s.samples, s.settings = empty.attachments, empty.retries, empty.projects, nil, nil, nil, empty.settings
+	s.load(snap)
+	return s.changed(ctx)
+}
+
+// Flush implements Store.
+func (s *Memory) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty || s.save == nil {
+		return nil
+	}
+	if err := s.save(ctx, s.snapshot()); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// Close implements Store.
+func (s *Memory) Close() error {
+	return s.Flush(context.Background())
+}
+
+// load adds the records in snap. The caller must hold s.mu.
+func (s *Memory) load(snap *Snapshot) {
+	for _, m := range snap.Mappings {
+		s.mappings[m.ADOID] = m
+	}
+	for _, c := range snap.Conflicts {
+		s.conflicts[conflictKey{c.ADOID, c.Field}] = c
+	}
+	for _, c := range snap.Comments {
+		s.putComment(c)
+	}
+	for _, a := range snap.Attachments {
+		s.attachments[a.ADOID] = append(s.attachments[a.ADOID], a)
+	}
+	for _, r := range snap.Retries {
+		s.retries[r.ADOID] = r
+	}
+	for _, p := range snap.Projects {
+		s.projects[projectKey{p.Pair, p.Name}] = p
+	}
+	s.audit = append(s.audit, snap.Audit...)
+	s.journal = append(s.journal, snap.Journal...)
+	s.samples = append(s.samples, snap.Samples...)
+	for k, v := range snap.Settings {
+		s.settings[k] = v
+	}
+}
+
+// changed records that the store was modified, saving it immediately in write-through mode.
+// The caller must hold s.mu.
+func (s *Memory) changed(ctx context.Context) error {
+	s.dirty = true
+	if !s.writeThrough || s.save == nil {
+		return nil
+	}
+	if err := s.save(ctx, s.snapshot()); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// snapshot returns the contents of the store in a stable order. The caller must hold s.mu.
+func (s *Memory) snapshot() *Snapshot {
+	comments := make([]CommentMapping, 0, len(s.comments))
+	for _, c := range s.comments {
+		comments = append(comments, c)
+	}
+	sort.Slice(comments, func(i, j int) bool {
+		if comments[i].ADOID != comments[j].ADOID {
+			return comments[i].ADOID < comments[j].ADOID
+		}
+		return comments[i].ADOCommentID < comments[j].ADOCommentID
+	})
+
+	attachmentIDs := make([]int, 0, len(s.attachments))
+	for id := range s.attachments {
+		attachmentIDs = append(attachmentIDs, id)
+	}
+	sort.Ints(attachmentIDs)
+	var attachments []AttachmentMapping
+	for _, id := range attachmentIDs {
+		attachments = append(attachments, s.attachments[id]...)
+	}
+
+	settings := make(map[string]string, len(s.settings))
+	for k, v := range s.settings {
+		settings[k] = v
+	}
+
+	return &Snapshot{
+		Version:     SnapshotVersion,
+		Mappings:    s.sortedMappings(),
+		Conflicts:   s.sortedConflicts(),
+		Comments:    comments,
+		Attachments: attachments,
+		Retries:     s.sortedRetries(),
+		Projects:    s.sortedProjects(),
+		Audit:       append([]AuditRecord(nil), s.audit...),
+		Journal:     append([]JournalEntry(nil), s.journal...),
+		Samples:     append([]Sample(nil), s.samples...),
+		Settings:    settings,
+	}
+}
+
+// sortedMappings returns the mappings ordered by work item ID. The caller must hold s.mu.
+func (s *Memory) sortedMappings() []Mapping {
+	all := make([]Mapping, 0, len(s.mappings))
+	for _, m := range s.mappings {
+		all = append(all, m)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].ADOID < all[j].ADOID })
+	return all
+}
+
+// sortedConflicts returns the conflicts ordered by work item ID and field. The caller must hold s.mu.
+func (s *Memory) sortedConflicts() []Conflict {
+	all := make([]Conflict, 0, len(s.conflicts))
+	for _, c := range s.conflicts {
+		all = append(all, c)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].ADOID != all[j].ADOID {
+			return all[i].ADOID < all[j].ADOID
+		}
+		return all[i].Field < all[j].Field
+	})
+	return all
+}
+
+// sortedRetries returns the retries ordered by next attempt and work item ID. The caller must hold s.mu.
+func (s *Memory) sortedRetries() []Retry {
+	all := make([]Retry, 0, len(s.retries))
+	for _, r := range s.retries {
+		all = append(all, r)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if !all[i].NextAttempt.Equal(all[j].NextAttempt) {
+			return all[i].NextAttempt.Before(all[j].NextAttempt)
+		}
+		return all[i].ADOID < all[j].ADOID
+	})
+	return all
+}
+
+// sortedProjects returns the provisioned projects ordered by pair and name. The caller must hold s.mu.
+func (s *Memory) sortedProjects() []Project {
+	all := make([]Project, 0, len(s.projects))
+	for _, p := range s.projects {
+		all = append(all, p)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Pair != all[j].Pair {
+			return all[i].Pair < all[j].Pair
+		}
+		return all[i].Name < all[j].Name
+	})
+	return all
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces the identities scrubbed from a store by Scrub.
+const Redacted = "[redacted]"
+
+// Scrub replaces every occurrence of the identities, such as the email address and display name of a
+// user, compared without regard to case, in the text held by s: the titles and tags of mappings, the values
+// of conflicts, the names of attachments, the errors of retries, the changes of audit records, the work
+// items and tasks of journal entries and the records held in settings, such as the errors of past cycles.
+// It returns how many values changed. The records themselves are kept, so the sync carries on unaffected.
+func Scrub(ctx context.Context, s Store, identities ...string) (int, error) {
+	var quoted []string
+	for _, id := range identities {
+		if id = strings.TrimSpace(id); id != "" {
+			quoted = append(quoted, regexp.QuoteMeta(id))
+		}
+	}
+	if len(quoted) == 0 {
+		return 0, nil
+	}
+	r, ok := s.(replacer)
+	if !ok {
+		return 0, fmt.Errorf("store: %T cannot be scrubbed", s)
+	}
+	pattern := regexp.MustCompile(`(?i)` + strings.Join(quoted, "|"))
+
+	snap, err := s.Export(ctx)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	scrub := func(v *string) error {
+		if scrubbed := pattern.ReplaceAllString(*v, Redacted); scrubbed != *v {
+			*v = scrubbed
+			n++
+		}
+		return nil
+	}
+	_ = transform(snap, scrub)
+	// Only the settings holding JSON records are scrubbed: the others hold watermarks and secrets, whose
+	// random text could happen to contain an identity.
+	for k, v := range snap.Settings {
+		if strings.HasPrefix(v, "{") || strings.HasPrefix(v, "[") {
+			_ = scrub(&v)
+			snap.Settings[k] = v
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	if err := r.Replace(ctx, snap); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,242 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// OpenS3 opens a store kept as a JSON snapshot in an S3 object. The location has the form
+//
+//	s3://bucket/path/to/key.json?region=eu-west-1&endpoint=https://minio.example.com
+//
+// where region defaults to AWS_REGION and endpoint to the regional AWS endpoint. Credentials are read
+// from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN. Changes are held in memory
+// and uploaded on Flush and Close.
+func OpenS3(ctx context.Context, location string) (*Memory, error) {
+	obj, err := parseS3(location)
+	if err != nil {
+		return nil, err
+	}
+
+	s := NewMemory()
+	b, err := obj.get(ctx)
+	switch {
+	case err == ErrNotFound:
+	case err != nil:
+		return nil, err
+	default:
+		var snap Snapshot
+		if err := json.Unmarshal(b, &snap); err != nil {
+			return nil, fmt.Errorf("store: decoding %s: %w", location, err)
+		}
+		s.load(&snap)
+	}
+
+	s.save = func(ctx context.Context, snap *Snapshot) error {
+		b, err := json.Marshal(snap)
+		if err != nil {
+			return err
+		}
+		return obj.put(ctx, b)
+	}
+	return s, nil
+}
+
+// PutS3 uploads body as the S3 object at location, which takes the form and credentials OpenS3 does.
+func PutS3(ctx context.Context, location, contentType string, body []byte) error {
+	obj, err := parseS3(location)
+	if err != nil {
+		return err
+	}
+	obj.contentType = contentType
+	return obj.put(ctx, body)
+}
+
+// s3Object addresses a single object using path-style requests.
+type s3Object struct {
+	endpoint     *url.URL
+	bucket, key  string
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	// contentType is that of the object uploaded, JSON unless set.
+	contentType string
+	http        *http.Client
+}
+
+// parseS3 parses an s3:// location and reads the credentials from the environment.
+func parseS3(location string) (*s3Object, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("store: invalid s3 location: %w", err)
+	}
+	o := &s3Object{
+		bucket:       u.Host,
+		key:          strings.TrimPrefix(u.Path, "/"),
+		region:       u.Query().Get("region"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		http:         http.DefaultClient,
+	}
+	if o.bucket == "" || o.key == "" {
+		return nil, fmt.Errorf("store: s3 location %q must name a bucket and key", location)
+	}
+	if o.region == "" {
+		o.region = os.Getenv("AWS_REGION")
+	}
+	if o.region == "" {
+		o.region = "us-east-1"
+	}
+	endpoint := u.Query().Get("endpoint")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", o.region)
+	}
+	if o.endpoint, err = url.Parse(endpoint); err != nil {
+		return nil, fmt.Errorf("store: invalid s3 endpoint: %w", err)
+	}
+	if o.accessKey == "" || o.secretKey == "" {
+		return nil, fmt.Errorf("store: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set for s3 stores")
+	}
+	return o, nil
+}
+
+// get downloads the object, returning ErrNotFound when it does not exist.
+func (o *s3Object) get(ctx context.Context) ([]byte, error) {
+	resp, err := o.do(ctx, http.MethodGet, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("store: s3 get %s/%s: %d %s", o.bucket, o.key, resp.StatusCode, msg)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// put uploads body as the object.
+func (o *s3Object) put(ctx context.Context, body []byte) error {
+	resp, err := o.do(ctx, http.MethodPut, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("store: s3 put %s/%s: %d %s", o.bucket, o.key, resp.StatusCode, msg)
+	}
+	return nil
+}
+
+// do sends a request for the object signed with AWS Signature Version 4.
+func (o *s3Object) do(ctx context.Context, method string, body []byte) (*http.Response, error) {
+	u := *o.endpoint
+	u.Path = "/" + o.bucket + "/" + o.key
+	u.RawPath = awsEscapePath(u.Path)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		contentType := o.contentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		req.Header.Set("Content-Type", contentType)
+	}
+	o.sign(req, body, time.Now().UTC())
+	return o.http.Do(req)
+}
+
+// sign adds the AWS Signature Version 4 headers to req.
+func (o *s3Object) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if o.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", o.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(req.Header.Get(k))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + o.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+o.secretKey), date)
+	key = hmacSHA256(key, o.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		o.accessKey, scope, signedHeaders, signature))
+}
+
+// awsEscapePath percent-encodes every byte of p except unreserved characters and slashes,
+// as required by Signature Version 4.
+func awsEscapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if c == '/' || c == '-' || c == '_' || c == '.' || c == '~' ||
+			('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,1037 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// Database drivers for the SQL backends.
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// Dialect identifies a supported SQL database.
+type Dialect string
+
+// Supported SQL dialects.
+const (
+	DialectSQLite   Dialect = "sqlite"
+	DialectPostgres Dialect = "pgx"
+)
+
+// migrations are the schema changes applied to SQL databases in order; the position of a migration
+// is its version. Times are stored as RFC 3339 text and booleans as integers so the same statements
+// work on every dialect. Existing migrations must never be edited, only appended to.
+var migrations = [][]string{{
+	`CREATE TABLE IF NOT EXISTS mappings (
+		ado_id BIGINT PRIMARY KEY,
+		ado_rev BIGINT NOT NULL,
+		ado_changed TEXT NOT NULL,
+		asana_gid TEXT NOT NULL,
+		asana_modified TEXT NOT NULL,
+		title TEXT NOT NULL,
+		completed INTEGER NOT NULL,
+		last_synced TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS mappings_asana_gid ON mappings (asana_gid)`,
+	`CREATE TABLE IF NOT EXISTS conflicts (
+		ado_id BIGINT NOT NULL,
+		field TEXT NOT NULL,
+		asana_gid TEXT NOT NULL,
+		ado_value TEXT NOT NULL,
+		asana_value TEXT NOT NULL,
+		ado_changed TEXT NOT NULL,
+		asana_modified TEXT NOT NULL,
+		detected_at TEXT NOT NULL,
+		PRIMARY KEY (ado_id, field)
+	)`,
+	`CREATE TABLE IF NOT EXISTS comments (
+		ado_id BIGINT NOT NULL,
+		ado_comment_id BIGINT NOT NULL,
+		asana_story_gid TEXT NOT NULL,
+		origin TEXT NOT NULL,
+		PRIMARY KEY (ado_id, ado_comment_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS comments_asana_story_gid ON comments (asana_story_gid)`,
+	`CREATE TABLE IF NOT EXISTS attachments (
+		ado_id BIGINT NOT NULL,
+		ado_url TEXT NOT NULL,
+		asana_gid TEXT NOT NULL,
+		name TEXT NOT NULL,
+		sha256 TEXT NOT NULL,
+		origin TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS attachments_ado_id ON attachments (ado_id)`,
+	`CREATE TABLE IF NOT EXISTS settings (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	)`,
+}, {
+	`ALTER TABLE mappings ADD COLUMN pair TEXT NOT NULL DEFAULT ''`,
+}, {
+	`ALTER TABLE mappings ADD COLUMN tags TEXT NOT NULL DEFAULT ''`,
+}, {
+	`CREATE TABLE IF NOT EXISTS audit (
+		recorded_at TEXT NOT NULL,
+		pair TEXT NOT NULL,
+		cycle_id TEXT NOT NULL,
+		system TEXT NOT NULL,
+		action TEXT NOT NULL,
+		ado_id BIGINT NOT NULL,
+		asana_gid TEXT NOT NULL,
+		changes TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS audit_recorded_at ON audit (recorded_at)`,
+	`CREATE INDEX IF NOT EXISTS audit_ado_id ON audit (ado_id)`,
+	`CREATE INDEX IF NOT EXISTS audit_asana_gid ON audit (asana_gid)`,
+}, {
+	`CREATE TABLE IF NOT EXISTS retries (
+		ado_id BIGINT PRIMARY KEY,
+		pair TEXT NOT NULL,
+		attempts INTEGER NOT NULL,
+		next_attempt TEXT NOT NULL,
+		last_error TEXT NOT NULL,
+		first_failed TEXT NOT NULL
+	)`,
+}, {
+	`CREATE TABLE IF NOT EXISTS projects (
+		pair TEXT NOT NULL,
+		name TEXT NOT NULL,
+		gid TEXT NOT NULL,
+		created TEXT NOT NULL,
+		PRIMARY KEY (pair, name)
+	)`,
+}, {
+	`ALTER TABLE mappings ADD COLUMN frozen INTEGER NOT NULL DEFAULT 0`,
+}, {
+	`ALTER TABLE mappings ADD COLUMN notes_hash TEXT NOT NULL DEFAULT ''`,
+}, {
+	// Lease expiries are Unix milliseconds, so they compare as numbers on every dialect.
+	`CREATE TABLE IF NOT EXISTS leases (
+		name TEXT PRIMARY KEY,
+		holder TEXT NOT NULL,
+		expires BIGINT NOT NULL
+	)`,
+}, {
+	// The sequence keeps the entries of a cycle in the order they were written when their times collide.
+	`CREATE TABLE IF NOT EXISTS journal (
+		seq INTEGER NOT NULL,
+		recorded_at TEXT NOT NULL,
+		pair TEXT NOT NULL,
+		cycle_id TEXT NOT NULL,
+		sources TEXT NOT NULL,
+		ado_id BIGINT NOT NULL,
+		asana_gid TEXT NOT NULL,
+		item TEXT NOT NULL,
+		task TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS journal_recorded_at ON journal (recorded_at, seq)`,
+	`CREATE INDEX IF NOT EXISTS journal_cycle_id ON journal (cycle_id)`,
+}, {
+	`ALTER TABLE mappings ADD COLUMN pending INTEGER NOT NULL DEFAULT 0`,
+}, {
+	`CREATE TABLE IF NOT EXISTS samples (
+		seq INTEGER NOT NULL,
+		recorded_at TEXT NOT NULL,
+		pair TEXT NOT NULL,
+		cycle_id TEXT NOT NULL,
+		ado_id BIGINT NOT NULL,
+		rev BIGINT NOT NULL,
+		type TEXT NOT NULL,
+		state TEXT NOT NULL,
+		done INTEGER NOT NULL,
+		iteration TEXT NOT NULL,
+		area TEXT NOT NULL,
+		points DOUBLE PRECISION,
+		remaining DOUBLE PRECISION,
+		created TEXT NOT NULL,
+		changed TEXT NOT NULL,
+		state_changed TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS samples_recorded_at ON samples (recorded_at, seq)`,
+}}
+
+// SQL is a Store backed by a SQLite or PostgreSQL database.
+type SQL struct {
+	db *sql.DB
+	// conn runs the statements of the store: db, or the transaction of Replace.
+	conn    conn
+	dialect Dialect
+}
+
+// conn is implemented by *sql.DB and *sql.Tx.
+type conn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// OpenSQL connects to the database described by dsn and migrates its schema to the latest version.
+func OpenSQL(ctx context.Context, dialect Dialect, dsn string) (*SQL, error) {
+	if dialect == DialectSQLite {
+		// The directory of the database is created, as that of a file store is.
+		if file, _, _ := strings.Cut(strings.TrimPrefix(dsn, "file:"), "?"); file != "" && file != ":memory:" {
+			if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+				return nil, fmt.Errorf("store: %w", err)
+			}
+		}
+	}
+	db, err := sql.Open(string(dialect), dsn)
+	if err != nil {
+		return nil, fmt.Errorf("store: %w", err)
+	}
+	if dialect == DialectSQLite {
+		// SQLite allows a single writer; serializing connections avoids "database is locked" errors.
+		db.SetMaxOpenConns(1)
+	}
+	s := &SQL{db: db, conn: db, dialect: dialect}
+	if schema := searchPath(dialect, dsn); schema != "" {
+		// The schema of a tenant is created with its store.
+		if err := s.exec(ctx, "CREATE SCHEMA IF NOT EXISTS "+quoteIdent(schema)); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	if err := s.migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// searchPath returns the schema set by the search_path of a PostgreSQL URL, or "" when it sets none or
+// several.
+func searchPath(dialect Dialect, dsn string) string {
+	if dialect != DialectPostgres {
+		return ""
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return ""
+	}
+	schema := strings.TrimSpace(u.Query().Get("search_path"))
+	if strings.Contains(schema, ",") {
+		return ""
+	}
+	return schema
+}
+
+// quoteIdent quotes a PostgreSQL identifier.
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// migrate applies the migrations the database has not seen yet.
+func (s *SQL) migrate(ctx context.Context) error {
+	if err := s.exec(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)"); err != nil {
+		return err
+	}
+	current, err := s.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	for i := current; i < len(migrations); i++ {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("store: %w", err)
+		}
+		for _, stmt := range migrations[i] {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("store: applying schema version %d: %w", i+1, err)
+			}
+		}
+		if _, err := tx.ExecContext(ctx, s.rebind("INSERT INTO schema_migrations (version) VALUES (?)"), i+1); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("store: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("store: %w", err)
+		}
+	}
+	return nil
+}
+
+// SchemaVersion returns the version of the database schema, which is the number of migrations applied.
+func (s *SQL) SchemaVersion(ctx context.Context) (int, error) {
+	var v int
+	if err := s.conn.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&v); err != nil {
+		return 0, fmt.Errorf("store: reading schema version: %w", err)
+	}
+	return v, nil
+}
+
+// rebind rewrites ? placeholders into the form used by the dialect.
+func (s *SQL) rebind(query string) string {
+	if s.dialect != DialectPostgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (s *SQL) exec(ctx context.Context, query string, args ...interface{}) error {
+	if _, err := s.conn.ExecContext(ctx, s.rebind(query), args...); err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+	return nil
+}
+
+func (s *SQL) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	rows, err := s.conn.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("store: %w", err)
+	}
+	return rows, nil
+}
+
+// notFound translates sql.ErrNoRows into ErrNotFound.
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+	return nil
+}
+
+func formatTime(t time.Time) string { return t.UTC().Format(time.RFC3339Nano) }
+
+func parseTime(s string) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, s)
+	return t
+}
+
+// encodeTags stores tags as a JSON array, or an empty string when there are none.
+func encodeTags(tags []string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(tags)
+	return string(b)
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+const mappingColumns = "ado_id, ado_rev, ado_changed, asana_gid, asana_modified, title, completed, last_synced, pair, tags, frozen, notes_hash, pending"
+
+// scanner is implemented by *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanMapping(r scanner) (Mapping, error) {
+	var m Mapping
+	var changed, modified, synced, tags string
+	var completed, frozen, pending int
+	if err := r.Scan(&m.ADOID, &m.ADORev, &changed, &m.AsanaGID, &modified, &m.Title, &completed, &synced, &m.Pair, &tags, &frozen, &m.NotesHash, &pending); err != nil {
+		return Mapping{}, err
+	}
+	if tags != "" {
+		if err := json.Unmarshal([]byte(tags), &m.Tags); err != nil {
+			return Mapping{}, err
+		}
+	}
+	m.ADOChanged, m.AsanaModified, m.LastSynced = parseTime(changed), parseTime(modified), parseTime(synced)
+	m.Completed, m.Frozen, m.Pending = completed != 0, frozen != 0, pending != 0
+	return m, nil
+}
+
+// Get implements Store.
+func (s *SQL) Get(ctx context.Context, adoID int) (Mapping, error) {
+	m, err := scanMapping(s.conn.QueryRowContext(ctx, s.rebind("SELECT "+mappingColumns+" FROM mappings WHERE ado_id = ?"), adoID))
+	return m, notFound(err)
+}
+
+// ByAsanaGID implements Store.
+func (s *SQL) ByAsanaGID(ctx context.Context, gid string) (Mapping, error) {
+	m, err := scanMapping(s.conn.QueryRowContext(ctx, s.rebind("SELECT "+mappingColumns+" FROM mappings WHERE asana_gid = ?"), gid))
+	return m, notFound(err)
+}
+
+// Put implements Store.
+func (s *SQL) Put(ctx context.Context, m Mapping) error {
+	return s.exec(ctx, `INSERT INTO mappings (`+mappingColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (ado_id) DO UPDATE SET ado_rev = excluded.ado_rev, ado_changed = excluded.ado_changed,
+		asana_gid = excluded.asana_gid, asana_modified = excluded.asana_modified, title = excluded.title,
+		completed = excluded.completed, last_synced = excluded.last_synced, pair = excluded.pair, tags = excluded.tags,
+		frozen = excluded.frozen, notes_hash = excluded.notes_hash, pending = excluded.pending`,
+		m.ADOID, m.ADORev, formatTime(m.ADOChanged), m.AsanaGID, formatTime(m.AsanaModified), m.Title,
+		boolInt(m.Completed), formatTime(m.LastSynced), m.Pair, encodeTags(m.Tags), boolInt(m.Frozen), m.NotesHash,
+		boolInt(m.Pending))
+}
+
+// Delete implements Store.
+func (s *SQL) Delete(ctx context.Context, adoID int) error {
+	return s.exec(ctx, "DELETE FROM mappings WHERE ado_id = ?", adoID)
+}
+
+// All implements Store.
+func (s *SQL) All(ctx context.Context) ([]Mapping, error) {
+	rows, err := s.query(ctx, "SELECT "+mappingColumns+" FROM mappings ORDER BY ado_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var all []Mapping
+	for rows.Next() {
+		m, err := scanMapping(rows)
+		if err != nil {
+			return nil, fmt.Errorf("store: %w", err)
+		}
+		all = append(all, m)
+	}
+	return all, notFound(rows.Err())
+}
+
+const conflictColumns = "ado_id, field, asana_gid, ado_value, asana_value, ado_changed, asana_modified, detected_at"
+
+func scanConflict(r scanner) (Conflict, error) {
+	var c Conflict
+	var changed, modified, detected string
+	if err := r.Scan(&c.ADOID, &c.Field, &c.AsanaGID, &c.ADOValue, &c.AsanaValue, &changed, &modified, &detected); err != nil {
+		return Conflict{}, err
+	}
+	c.ADOChanged, c.AsanaModified, c.DetectedAt = parseTime(changed), parseTime(modified), parseTime(detected)
+	return c, nil
+}
+
+// Conflict implements Store.
+func (s *SQL) Conflict(ctx context.Context, adoID int, field string) (Conflict, error) {
+	c, err := scanConflict(s.conn.QueryRowContext(ctx, s.rebind("SELECT "+conflictColumns+" FROM conflicts WHERE ado_id = ? AND field = ?"), adoID, field))
+	return c, notFound(err)
+}
+
+// PutConflict implements Store.
+func (s *SQL) PutConflict(ctx context.Context, c Conflict) error {
+	return s.exec(ctx, `INSERT INTO conflicts (`+conflictColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (ado_id, field) DO UPDATE SET asana_gid = excluded.asana_gid, ado_value = excluded.ado_value,
+		asana_value = excluded.asana_value, ado_changed = excluded.ado_changed,
+		asana_modified = excluded.asana_modified, detected_at = excluded.detected_at`,
+		c.ADOID, c.Field, c.AsanaGID, c.ADOValue, c.AsanaValue, formatTime(c.ADOChanged), formatTime(c.AsanaModified), formatTime(c.DetectedAt))
+}
+
+// DeleteConflict implements Store.
+func (s *SQL) DeleteConflict(ctx context.Context, adoID int, field string) error {
+	return s.exec(ctx, "DELETE FROM conflicts WHERE ado_id = ? AND field = ?", adoID, field)
+}
+
+// Conflicts implements Store.
+func (s *SQL) Conflicts(ctx context.Context) ([]Conflict, error) {
+	rows, err := s.query(ctx, "SELECT "+conflictColumns+" FROM conflicts ORDER BY ado_id, field")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var all []Conflict
+	for rows.Next() {
+		c, err := scanConflict(rows)
+		if err != nil {
+			return nil, fmt.Errorf("store: %w", err)
+		}
+		all = append(all, c)
+	}
+	return all, notFound(rows.Err())
+}
+
+// CommentByADO implements Store.
+func (s *SQL) CommentByADO(ctx context.Context, adoID, commentID int) (CommentMapping, error) {
+	c := CommentMapping{ADOID: adoID, ADOCommentID: commentID}
+	err := s.conn.QueryRowContext(ctx, s.rebind("SELECT asana_story_gid, origin FROM comments WHERE ado_id = ? AND ado_comment_id = ?"), adoID, commentID).
+		Scan(&c.AsanaStoryGID, &c.Origin)
+	return c, notFound(err)
+}
+
+// CommentByAsana implements Store.
+func (s *SQL) CommentByAsana(ctx context.Context, storyGID string) (CommentMapping, error) {
+	c := CommentMapping{AsanaStoryGID: storyGID}
+	err := s.conn.QueryRowContext(ctx, s.rebind("SELECT ado_id, ado_comment_id, origin FROM comments WHERE asana_story_gid = ?"), storyGID).
+		Scan(&c.ADOID, &c.ADOCommentID, &c.Origin)
+	return c, notFound(err)
+}
+
+// PutComment implements Store.
+func (s *SQL) PutComment(ctx context.Context, c CommentMapping) error {
+	return s.exec(ctx, `INSERT INTO comments (ado_id, ado_comment_id, asana_story_gid, origin) VALUES (?, ?, ?, ?)
+		ON CONFLICT (ado_id, ado_comment_id) DO UPDATE SET asana_story_gid = excluded.asana_story_gid, origin = excluded.origin`,
+		c.ADOID, c.ADOCommentID, c.AsanaStoryGID, c.Origin)
+}
+
+// Attachments implements Store.
+func (s *SQL) Attachments(ctx context.Context, adoID int) ([]AttachmentMapping, error) {
+	rows, err := s.query(ctx, "SELECT ado_id, ado_url, asana_gid, name, sha256, origin FROM attachments WHERE ado_id = ?", adoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var all []AttachmentMapping
+	for rows.Next() {
+		var a AttachmentMapping
+		if err := rows.Scan(&a.ADOID, &a.ADOURL, &a.AsanaGID, &a.Name, &a.SHA256, &a.Origin); err != nil {
+			return nil, fmt.Errorf("store: %w", err)
+		}
+		all = append(all, a)
+	}
+	return all, notFound(rows.Err())
+}
+
+// PutAttachment implements Store.
+func (s *SQL) PutAttachment(ctx context.Context, a AttachmentMapping) error {
+	return s.exec(ctx, "INSERT INTO attachments (ado_id, ado_url, asana_gid, name, sha256, origin) VALUES (?, ?, ?, ?, ?, ?)",
+		a.ADOID, a.ADOURL, a.AsanaGID, a.Name, a.SHA256, a.Origin)
+}
+
+const retryColumns = "ado_id, pair, attempts, next_attempt, last_error, first_failed"
+
+func scanRetry(r scanner) (Retry, error) {
+	var rt Retry
+	var next, first string
+	if err := r.Scan(&rt.ADOID, &rt.Pair, &rt.Attempts, &next, &rt.LastError, &first); err != nil {
+		return Retry{}, err
+	}
+	rt.NextAttempt, rt.FirstFailed = parseTime(next), parseTime(first)
+	return rt, nil
+}
+
+// Retry implements Store.
+func (s *SQL) Retry(ctx context.Context, adoID int) (Retry, error) {
+	r, err := scanRetry(s.conn.QueryRowContext(ctx, s.rebind("SELECT "+retryColumns+" FROM retries WHERE ado_id = ?"), adoID))
+	return r, notFound(err)
+}
+
+// PutRetry implements Store.
+func (s *SQL) PutRetry(ctx context.Context, r Retry) error {
+	return s.exec(ctx, `INSERT INTO retries (`+retryColumns+`) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (ado_id) DO UPDATE SET pair = excluded.pair, attempts = excluded.attempts,
+		next_attempt = excluded.next_attempt, last_error = excluded.last_error, first_failed = excluded.first_failed`,
+		r.ADOID, r.Pair, r.Attempts, formatTime(r.NextAttempt), r.LastError, formatTime(r.FirstFailed))
+}
+
+// DeleteRetry implements Store.
+func (s *SQL) DeleteRetry(ctx context.Context, adoID int) error {
+	return s.exec(ctx, "DELETE FROM retries WHERE ado_id = ?", adoID)
+}
+
+// Retries implements Store.
+func (s *SQL) Retries(ctx context.Context) ([]Retry, error) {
+	rows, err := s.query(ctx, "SELECT "+retryColumns+" FROM retries")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var all []Retry
+	for rows.Next() {
+		r, err := scanRetry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("store: %w", err)
+		}
+		all = append(all, r)
+	}
+	// Times are sorted here since their text form does not sort in time order.
+	sort.Slice(all, func(i, j int) bool {
+		if !all[i].NextAttempt.Equal(all[j].NextAttempt) {
+			return all[i].NextAttempt.Before(all[j].NextAttempt)
+		}
+		return all[i].ADOID < all[j].ADOID
+	})
+	return all, notFound(rows.Err())
+}
+
+// Project implements Store.
+func (s *SQL) Project(ctx context.Context, pair, name string) (Project, error) {
+	p := Project{Pair: pair, Name: name}
+	var created string
+	err := s.conn.QueryRowContext(ctx, s.rebind("SELECT gid, created FROM projects WHERE pair = ? AND name = ?"), pair, name).
+		Scan(&p.GID, &created)
+	if err != nil {
+		return Project{}, notFound(err)
+	}
+	p.Created = parseTime(created)
+	return p, nil
+}
+
+// PutProject implements Store.
+func (s *SQL) PutProject(ctx context.Context, p Project) error {
+	return s.exec(ctx, `INSERT INTO projects (pair, name, gid, created) VALUES (?, ?, ?, ?)
+		ON CONFLICT (pair, name) DO UPDATE SET gid = excluded.gid, created = excluded.created`,
+		p.Pair, p.Name, p.GID, formatTime(p.Created))
+}
+
+// Projects implements Store.
+func (s *SQL) Projects(ctx context.Context) ([]Project, error) {
+	rows, err := s.query(ctx, "SELECT pair, name, gid, created FROM projects ORDER BY pair, name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var all []Project
+	for rows.Next() {
+		var p Project
+		var created string
+		if err := rows.Scan(&p.Pair, &p.Name, &p.GID, &created); err != nil {
+			return nil, fmt.Errorf("store: %w", err)
+		}
+		p.Created = parseTime(created)
+		all = append(all, p)
+	}
+	return all, notFound(rows.Err())
+}
+
+// auditTimeLayout stores audit times with a fixed number of fractional digits so they sort as text.
+const auditTimeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+const auditColumns = "recorded_at, pair, cycle_id, system, action, ado_id, asana_gid, changes"
+
+// PutAudit implements Store.
+func (s *SQL) PutAudit(ctx context.Context, r AuditRecord) error {
+	changes := ""
+	if len(r.Changes) > 0 {
+		b, err := json.Marshal(r.Changes)
+		if err != nil {
+			return fmt.Errorf("store: %w", err)
+		}
+		changes = string(b)
+	}
+	return s.exec(ctx, "INSERT INTO audit ("+auditColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		r.Time.UTC().Format(auditTimeLayout), r.Pair, r.CycleID, r.System, r.Action, r.ADOID, r.AsanaGID, changes)
+}
+
+// Audit implements Store.
+func (s *SQL) Audit(ctx context.Context, f AuditFilter) ([]AuditRecord, error) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		where = append(where, cond)
+		args = append(args, arg)
+	}
+	if f.Pair != "" {
+		add("pair = ?", f.Pair)
+	}
+	if f.CycleID != "" {
+		add("cycle_id = ?", f.CycleID)
+	}
+	if f.ADOID != 0 {
+		add("ado_id = ?", f.ADOID)
+	}
+	if f.AsanaGID != "" {
+		add("asana_gid = ?", f.AsanaGID)
+	}
+	if !f.Since.IsZero() {
+		add("recorded_at >= ?", f.Since.UTC().Format(auditTimeLayout))
+	}
+	query := "SELECT " + auditColumns + " FROM audit"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	// The most recent records are selected first so the limit keeps them, then returned oldest first.
+	query += " ORDER BY recorded_at DESC"
+	if f.Limit > 0 {
+		query += " LIMIT " + strconv.Itoa(f.Limit)
+	}
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var all []AuditRecord
+	for rows.Next() {
+		var r AuditRecord
+		var recorded, changes string
+		if err := rows.Scan(&recorded, &r.Pair, &r.CycleID, &r.System, &r.Action, &r.ADOID, &r.AsanaGID, &changes); err != nil {
+			return nil, fmt.Errorf("store: %w", err)
+		}
+		if changes != "" {
+			if err := json.Unmarshal([]byte(changes), &r.Changes); err != nil {
+				return nil, fmt.Errorf("store: %w", err)
+			}
+		}
+		r.Time = parseTime(recorded)
+		all = append(all, r)
+	}
+	for i, j := 0, len(all)-1; i < j; i, j = i+1, j-1 {
+		all[i], all[j] = all[j], all[i]
+	}
+	return all, notFound(rows.Err())
+}
+
+// PruneAudit implements Store.
+func (s *SQL) PruneAudit(ctx context.Context, before time.Time) (int, error) {
+	res, err := s.conn.ExecContext(ctx, s.rebind("DELETE FROM audit WHERE recorded_at < ?"), before.UTC().Format(auditTimeLayout))
+	if err != nil {
+		return 0, fmt.Errorf("store: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("store: %w", err)
+	}
+	return int(n), nil
+}
+
+const journalColumns = "recorded_at, pair, cycle_id, sources, ado_id, asana_gid, item, task"
+
+// PutJournal implements Store.
+func (s *SQL) PutJournal(ctx context.Context, j JournalEntry) error {
+	return s.exec(ctx, "INSERT INTO journal (seq, "+journalColumns+") SELECT COALESCE(MAX(seq), 0) + 1, ?, ?, ?, ?, ?, ?, ?, ? FROM journal",
+		j.Time.UTC().Format(auditTimeLayout), j.Pair, j.CycleID, strings.Join(j.Sources, ","), j.ADOID, j.AsanaGID, j.Item, j.Task)
+}
+
+// Journal implements Store.
+func (s *SQL) Journal(ctx context.Context, f JournalFilter) ([]JournalEntry, error) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		where = append(where, cond)
+		args = append(args, arg)
+	}
+	if f.Pair != "" {
+		add("pair = ?", f.Pair)
+	}
+	if f.CycleID != "" {
+		add("cycle_id = ?", f.CycleID)
+	}
+	if f.ADOID != 0 {
+		add("ado_id = ?", f.ADOID)
+	}
+	if !f.Since.IsZero() {
+		add("recorded_at >= ?", f.Since.UTC().Format(auditTimeLayout))
+	}
+	query := "SELECT " + journalColumns + " FROM journal"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	rows, err := s.query(ctx, query+" ORDER BY seq", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var all []JournalEntry
+	for rows.Next() {
+		var j JournalEntry
+		var recorded, sources string
+		if err := rows.Scan(&recorded, &j.Pair, &j.CycleID, &sources, &j.ADOID, &j.AsanaGID, &j.Item, &j.Task); err != nil {
+			return nil, fmt.Errorf("store: %w", err)
+		}
+		if sources != "" {
+			j.Sources = strings.Split(sources, ",")
+		}
+		j.Time = parseTime(recorded)
+		all = append(all, j)
+	}
+	return all, notFound(rows.Err())
+}
+
+// PruneJournal implements Store.
+func (s *SQL) PruneJournal(ctx context.Context, before time.Time) (int, error) {
+	res, err := s.conn.ExecContext(ctx, s.rebind("DELETE FROM journal WHERE recorded_at < ?"), before.UTC().Format(auditTimeLayout))
+	if err != nil {
+		return 0, fmt.Errorf("store: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("store: %w", err)
+	}
+	return int(n), nil
+}
+
+const sampleColumns = "recorded_at, pair, cycle_id, ado_id, rev, type, state, done, iteration, area, points, remaining, created, changed, state_changed"
+
+// PutSample implements Store.
+func (s *SQL) PutSample(ctx context.Context, smp Sample) error {
+	return s.exec(ctx, "INSERT INTO samples (seq, "+sampleColumns+") SELECT COALESCE(MAX(seq), 0) + 1, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ? FROM samples",
+		smp.Time.UTC().Format(auditTimeLayout), smp.Pair, smp.CycleID, smp.ADOID, smp.Rev, smp.Type, smp.State, boolInt(smp.Done),
+		smp.Iteration, smp.Area, smp.Points, smp.Remaining, formatTime(smp.Created), formatTime(smp.Changed), formatTime(smp.StateChanged))
+}
+
+// Samples implements Store.
+func (s *SQL) Samples(ctx context.Context, f SampleFilter) ([]Sample, error) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		where = append(where, cond)
+		args = append(args, arg)
+	}
+	if f.Pair != "" {
+		add("pair = ?", f.Pair)
+	}
+	if f.ADOID != 0 {
+		add("ado_id = ?", f.ADOID)
+	}
+	if !f.Since.IsZero() {
+		add("recorded_at >= ?", f.Since.UTC().Format(auditTimeLayout))
+	}
+	query := "SELECT " + sampleColumns + " FROM samples"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	rows, err := s.query(ctx, query+" ORDER BY seq", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var all []Sample
+	for rows.Next() {
+		var smp Sample
+		var recorded, created, changed, stateChanged string
+		var done int
+		var points, remaining sql.NullFloat64
+		if err := rows.Scan(&recorded, &smp.Pair, &smp.CycleID, &smp.ADOID, &smp.Rev, &smp.Type, &smp.State, &done,
+			&smp.Iteration, &smp.Area, &points, &remaining, &created, &changed, &stateChanged); err != nil {
+			return nil, fmt.Errorf("store: %w", err)
+		}
+		smp.Time, smp.Done = parseTime(recorded), done != 0
+		smp.Created, smp.Changed, smp.StateChanged = parseTime(created), parseTime(changed), parseTime(stateChanged)
+		if points.Valid {
+			smp.Points = &points.Float64
+		}
+		if remaining.Valid {
+			smp.Remaining = &remaining.Float64
+		}
+		all = append(all, smp)
+	}
+	return all, notFound(rows.Err())
+}
+
+// PruneSamples implements Store.
+func (s *SQL) PruneSamples(ctx context.Context, before time.Time) (int, error) {
+	res, err := s.conn.ExecContext(ctx, s.rebind("DELETE FROM samples WHERE recorded_at < ?"), before.UTC().Format(auditTimeLayout))
+	if err != nil {
+		return 0, fmt.Errorf("store: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("store: %w", err)
+	}
+	return int(n), nil
+}
+
+// AcquireLease takes the named lease for holder until ttl from now, or extends it when holder already holds
+// it, and reports whether holder holds it. A lease held by another holder is only taken once it expired.
+func (s *SQL) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	res, err := s.conn.ExecContext(ctx, s.rebind(`INSERT INTO leases (name, holder, expires) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET holder = excluded.holder, expires = excluded.expires
+		WHERE leases.holder = excluded.holder OR leases.expires < ?`), name, holder, now.Add(ttl).UnixMilli(), now.UnixMilli())
+	if err != nil {
+		return false, fmt.Errorf("store: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("store: %w", err)
+	}
+	return n > 0, nil
+}
+
+// Leases returns every lease, expired or not, ordered by name.
+func (s *SQL) Leases(ctx context.Context) ([]Lease, error) {
+	rows, err := s.query(ctx, "SELECT name, holder, expires FROM leases ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var leases []Lease
+	for rows.Next() {
+		var l Lease
+		var expires int64
+		if err := rows.Scan(&l.Name, &l.Holder, &expires); err != nil {
+			return nil, fmt.Errorf("store: %w", err)
+		}
+		l.Expires = time.UnixMilli(expires)
+		leases = append(leases, l)
+	}
+	return leases, notFound(rows.Err())
+}
+
+// ReleaseLease gives up the named lease if holder holds it, so another holder can take it straight away.
+func (s *SQL) ReleaseLease(ctx context.Context, name, holder string) error {
+	return s.exec(ctx, "DELETE FROM leases WHERE name = ? AND holder = ?", name, holder)
+}
+
+// Setting implements Store.
+func (s *SQL) Setting(ctx context.Context, key string) (string, error) {
+	var v string
+	err := s.conn.QueryRowContext(ctx, s.rebind("SELECT value FROM settings WHERE key = ?"), key).Scan(&v)
+	return v, notFound(err)
+}
+
+// SetSetting implements Store.
+func (s *SQL) SetSetting(ctx context.Context, key, value string) error {
+	return s.exec(ctx, "INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value", key, value)
+}
+
+// Export implements Store.
+func (s *SQL) Export(ctx context.Context) (*Snapshot, error) {
+	snap := &Snapshot{Version: SnapshotVersion, Settings: map[string]string{}}
+	var err error
+	if snap.Mappings, err = s.All(ctx); err != nil {
+		return nil, err
+	}
+	if snap.Conflicts, err = s.Conflicts(ctx); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.query(ctx, "SELECT ado_id, ado_comment_id, asana_story_gid, origin FROM comments ORDER BY ado_id, ado_comment_id")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var c CommentMapping
+		if err := rows.Scan(&c.ADOID, &c.ADOCommentID, &c.AsanaStoryGID, &c.Origin); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("store: %w", err)
+		}
+		snap.Comments = append(snap.Comments, c)
+	}
+	rows.Close()
+
+	rows, err = s.query(ctx, "SELECT ado_id, ado_url, asana_gid, name, sha256, origin FROM attachments ORDER BY ado_id")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var a AttachmentMapping
+		if err := rows.Scan(&a.ADOID, &a.ADOURL, &a.AsanaGID, &a.Name, &a.SHA256, &a.Origin); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("store: %w", err)
+		}
+		snap.Attachments = append(snap.Attachments, a)
+	}
+	rows.Close()
+
+	if snap.Retries, err = s.Retries(ctx); err != nil {
+		return nil, err
+	}
+	if snap.Projects, err = s.Projects(ctx); err != nil {
+		return nil, err
+	}
+	if snap.Audit, err = s.Audit(ctx, AuditFilter{}); err != nil {
+		return nil, err
+	}
+	if snap.Journal, err = s.Journal(ctx, JournalFilter{}); err != nil {
+		return nil, err
+	}
+	if snap.Samples, err = s.Samples(ctx, SampleFilter{}); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.query(ctx, "SELECT key, value FROM settings")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var k, v string
+		if err := rows.Scan(&k, &v); err != nil {
+			return nil, fmt.Errorf("store: %w", err)
+		}
+		snap.Settings[k] = v
+	}
+	return snap, notFound(rows.Err())
+}
+
+// Import implements Store.
+func (s *SQL) Import(ctx context.Context, snap *Snapshot) error {
+	for _, m := range snap.Mappings {
+		if err := s.Put(ctx, m); err != nil {
+			return err
+		}
+	}
+	for _, c := range snap.Conflicts {
+		if err := s.PutConflict(ctx, c); err != nil {
+			return err
+		}
+	}
+	for _, c := range snap.Comments {
+		if err := s.PutComment(ctx, c); err != nil {
+			return err
+		}
+	}
+	for _, a := range snap.Attachments {
+		if err := s.PutAttachment(ctx, a); err != nil {
+			return err
+		}
+	}
+	for _, r := range snap.Retries {
+		if err := s.PutRetry(ctx, r); err != nil {
+			return err
+		}
+	}
+	for _, p := range snap.Projects {
+		if err := s.PutProject(ctx, p); err != nil {
+			return err
+		}
+	}
+	for _, r := range snap.Audit {
+		if err := s.PutAudit(ctx, r); err != nil {
+			return err
+		}
+	}
+	for _, j := range snap.Journal {
+		if err := s.PutJournal(ctx, j); err != nil {
+			return err
+		}
+	}
+	for _, smp := range snap.Samples {
+		if err := s.PutSample(ctx, smp); err != nil {
+			return err
+		}
+	}
+	for k, v := range snap.Settings {
+		if err := s.SetSetting(ctx, k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Replace removes every record from the store and imports snap in their place, in a single transaction.
+func (s *SQL) Replace(ctx context.Context, snap *Snapshot) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+	t := &SQL{db: s.db, conn: tx, dialect: s.dialect}
+	for _, table := range []string{"mappings", "conflicts", "comments", "attachments", "retries", "projects", "audit", "journal", "samples", "settings"} {
+		if err := t.exec(ctx, "DELETE FROM "+table); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := t.Import(ctx, snap); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+	return nil
+}
+
+// Flush implements Store. Every change is written immediately, so there is nothing to flush.
+func (s *SQL) Flush(context.Context) error { return nil }
+
+// Close implements Store.
+func (s *SQL) Close() error { return s.db.Close() }
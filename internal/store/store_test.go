@@ -0,0 +1,370 @@
+package store_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// backend opens the same store each time it is called, so a test can check what a reopened store still
+// holds.
+type backend struct {
+	name string
+	open func(t *testing.T) func() store.Store
+}
+
+// backends returns the backends the tests run against. PostgreSQL is only tested when
+// STORE_TEST_POSTGRES holds the URL of a database the test may empty.
+func backends() []backend {
+	return []backend{
+		{"memory", func(t *testing.T) func() store.Store {
+			s := store.NewMemory()
+			return func() store.Store { return s }
+		}},
+		{"file", func(t *testing.T) func() store.Store {
+			return opener(t, filepath.Join(t.TempDir(), "mappings.json"))
+		}},
+		{"sqlite", func(t *testing.T) func() store.Store {
+			return opener(t, "sqlite://"+filepath.Join(t.TempDir(), "sync.db"))
+		}},
+		{"s3", func(t *testing.T) func() store.Store {
+			srv := newFakeS3(t)
+			t.Setenv("AWS_ACCESS_KEY_ID", "access")
+			t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+			return opener(t, "s3://state/sync/mappings.json?region=eu-west-1&endpoint="+srv.URL)
+		}},
+		{"postgres", func(t *testing.T) func() store.Store {
+			location := os.Getenv("STORE_TEST_POSTGRES")
+			if location == "" {
+				t.Skip("STORE_TEST_POSTGRES is not set")
+			}
+			open := opener(t, location)
+			s := open()
+			if err := s.(interface {
+				Replace(context.Context, *store.Snapshot) error
+			}).Replace(context.Background(), &store.Snapshot{}); err != nil {
+				t.Fatal(err)
+			}
+			return open
+		}},
+	}
+}
+
+// opener returns a function opening the store at location, closing the one it opened before.
+func opener(t *testing.T, location string) func() store.Store {
+	var s store.Store
+	t.Cleanup(func() {
+		if s != nil {
+			s.Close()
+		}
+	})
+	return func() store.Store {
+		if s != nil {
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var err error
+		if s, err = store.Open(context.Background(), location); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+}
+
+// fakeS3 serves the objects of an S3 compatible service, rejecting the requests that are not signed or
+// whose payload does not match its signed hash.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newFakeS3(t *testing.T) *httptest.Server {
+	f := &fakeS3{objects: map[string][]byte{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	sum := sha256.Sum256(body)
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/") ||
+		r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+		http.Error(w, "SignatureDoesNotMatch", http.StatusForbidden)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+		b, ok := f.objects[r.URL.Path]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(b)
+	case http.MethodPut:
+		f.objects[r.URL.Path] = body
+	default:
+		http.Error(w, "MethodNotAllowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// due is the time the retries of the records are due around, the retry of a higher ID first.
+var due = time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+
+// records returns a record of every kind for the work item, at times every backend stores exactly.
+func records(id int, at time.Time) *store.Snapshot {
+	points := 5.0
+	return &store.Snapshot{
+		Mappings:    []store.Mapping{{ADOID: id, ADORev: 3, ADOChanged: at, AsanaGID: gid(id), AsanaModified: at, Title: "Item", LastSynced: at, Pair: "board", Tags: []string{"api"}, NotesHash: "hash"}},
+		Conflicts:   []store.Conflict{{ADOID: id, AsanaGID: gid(id), Field: "title", ADOValue: "From ADO", AsanaValue: "From Asana", ADOChanged: at, AsanaModified: at, DetectedAt: at}},
+		Comments:    []store.CommentMapping{{ADOID: id, ADOCommentID: 7, AsanaStoryGID: "story" + gid(id), Origin: store.OriginADO}},
+		Attachments: []store.AttachmentMapping{{ADOID: id, ADOURL: "https://ado.example/" + gid(id), AsanaGID: "file" + gid(id), Name: "spec.txt", SHA256: "sum", Origin: store.OriginAsana}},
+		Retries:     []store.Retry{{ADOID: id, Pair: "board", Attempts: 2, NextAttempt: due.Add(-time.Duration(id) * time.Hour), LastError: "timeout", FirstFailed: at}},
+		Projects:    []store.Project{{Pair: "board", Name: "Project " + gid(id), GID: gid(id), Created: at}},
+		Audit:       []store.AuditRecord{{Time: at, Pair: "board", CycleID: "cycle", System: store.OriginAsana, Action: "update", ADOID: id, AsanaGID: gid(id), Changes: []store.FieldChange{{Field: "name", Before: "Old", After: "New"}}}},
+		Journal:     []store.JournalEntry{{Time: at, Pair: "board", CycleID: "cycle", Sources: []string{store.OriginADO}, ADOID: id, AsanaGID: gid(id), Item: "{}", Task: "{}"}},
+		Samples:     []store.Sample{{Time: at, Pair: "board", CycleID: "cycle", ADOID: id, Rev: 3, Type: "Bug", State: "Active", Iteration: "Sprint 1", Area: "Web", Points: &points, Created: at, Changed: at, StateChanged: at}},
+		Settings:    map[string]string{"setting:" + gid(id): "value"},
+	}
+}
+
+func gid(id int) string { return string(rune('a'+id)) + "1" }
+
+// same fails the test when got and want differ, compared as JSON.
+func same(t *testing.T, what string, got, want interface{}) {
+	t.Helper()
+	g, _ := json.Marshal(got)
+	w, _ := json.Marshal(want)
+	if string(g) != string(w) {
+		t.Errorf("%s: want %s, got %s", what, w, g)
+	}
+}
+
+// TestBackends writes records of every kind to each backend and reads them back, also after reopening it.
+func TestBackends(t *testing.T) {
+	for _, b := range backends() {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			ctx := context.Background()
+			open := b.open(t)
+			s := open()
+			old := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+			at := old.Add(24 * time.Hour)
+			one, two := records(1, old), records(2, at)
+			if err := s.Import(ctx, one); err != nil {
+				t.Fatal(err)
+			}
+			put(t, s, two)
+			if err := s.Flush(ctx); err != nil {
+				t.Fatal(err)
+			}
+			check(t, s, one, two)
+			s = open()
+			check(t, s, one, two)
+
+			// Deletes, replacements and prunes.
+			m := two.Mappings[0]
+			m.Title = "Renamed"
+			if err := s.Put(ctx, m); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := s.Get(ctx, 2); err != nil || got.Title != "Renamed" {
+				t.Errorf("want the mapping replaced, got %+v, %v", got, err)
+			}
+			if err := s.Delete(ctx, 1); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.Get(ctx, 1); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("want the deleted mapping gone, got %v", err)
+			}
+			if err := s.DeleteConflict(ctx, 1, "title"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.Conflict(ctx, 1, "title"); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("want the deleted conflict gone, got %v", err)
+			}
+			if err := s.DeleteRetry(ctx, 1); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.Retry(ctx, 1); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("want the deleted retry gone, got %v", err)
+			}
+			for name, prune := range map[string]func(context.Context, time.Time) (int, error){
+				"audit records": s.PruneAudit, "journal entries": s.PruneJournal, "samples": s.PruneSamples,
+			} {
+				if n, err := prune(ctx, at); err != nil || n != 1 {
+					t.Errorf("want the older of the %s pruned, got %d, %v", name, n, err)
+				}
+			}
+			if a, err := s.Audit(ctx, store.AuditFilter{}); err != nil || len(a) != 1 || a[0].ADOID != 2 {
+				t.Errorf("want the newer audit record kept, got %+v, %v", a, err)
+			}
+			if err := s.Flush(ctx); err != nil {
+				t.Fatal(err)
+			}
+			s = open()
+			if all, err := s.All(ctx); err != nil || len(all) != 1 || all[0].Title != "Renamed" {
+				t.Errorf("want the changes kept by the reopened store, got %+v, %v", all, err)
+			}
+		})
+	}
+}
+
+// put writes the records of snap one at a time.
+func put(t *testing.T, s store.Store, snap *store.Snapshot) {
+	t.Helper()
+	ctx := context.Background()
+	for _, err := range []error{
+		s.Put(ctx, snap.Mappings[0]),
+		s.PutConflict(ctx, snap.Conflicts[0]),
+		s.PutComment(ctx, snap.Comments[0]),
+		s.PutAttachment(ctx, snap.Attachments[0]),
+		s.PutRetry(ctx, snap.Retries[0]),
+		s.PutProject(ctx, snap.Projects[0]),
+		s.PutAudit(ctx, snap.Audit[0]),
+		s.PutJournal(ctx, snap.Journal[0]),
+		s.PutSample(ctx, snap.Samples[0]),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for k, v := range snap.Settings {
+		if err := s.SetSetting(ctx, k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// check reads back the records of one and two, written in that order.
+func check(t *testing.T, s store.Store, one, two *store.Snapshot) {
+	t.Helper()
+	ctx := context.Background()
+	for _, want := range []*store.Snapshot{one, two} {
+		m := want.Mappings[0]
+		got, err := s.Get(ctx, m.ADOID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		same(t, "mapping", got, m)
+		got, err = s.ByAsanaGID(ctx, m.AsanaGID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		same(t, "mapping by task", got, m)
+		c, err := s.Conflict(ctx, m.ADOID, "title")
+		if err != nil {
+			t.Fatal(err)
+		}
+		same(t, "conflict", c, want.Conflicts[0])
+		cm, err := s.CommentByADO(ctx, m.ADOID, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		same(t, "comment", cm, want.Comments[0])
+		if cm, err = s.CommentByAsana(ctx, want.Comments[0].AsanaStoryGID); err != nil {
+			t.Fatal(err)
+		}
+		same(t, "comment by story", cm, want.Comments[0])
+		atts, err := s.Attachments(ctx, m.ADOID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		same(t, "attachments", atts, want.Attachments)
+		r, err := s.Retry(ctx, m.ADOID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		same(t, "retry", r, want.Retries[0])
+		p, err := s.Project(ctx, "board", want.Projects[0].Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		same(t, "project", p, want.Projects[0])
+		audit, err := s.Audit(ctx, store.AuditFilter{ADOID: m.ADOID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		same(t, "audit", audit, want.Audit)
+		journal, err := s.Journal(ctx, store.JournalFilter{ADOID: m.ADOID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		same(t, "journal", journal, want.Journal)
+		samples, err := s.Samples(ctx, store.SampleFilter{ADOID: m.ADOID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		same(t, "samples", samples, want.Samples)
+		for k, v := range want.Settings {
+			if got, err := s.Setting(ctx, k); err != nil || got != v {
+				t.Errorf("setting %s: want %q, got %q, %v", k, v, got, err)
+			}
+		}
+	}
+	if _, err := s.Get(ctx, 3); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("want ErrNotFound for a missing mapping, got %v", err)
+	}
+	if _, err := s.Setting(ctx, "missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("want ErrNotFound for a missing setting, got %v", err)
+	}
+
+	// Lists are ordered: mappings and conflicts by work item, retries by their next attempt, projects by
+	// name and audit records oldest first, of which a limit keeps the most recent.
+	all, err := s.All(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	same(t, "all mappings", all, []store.Mapping{one.Mappings[0], two.Mappings[0]})
+	conflicts, err := s.Conflicts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	same(t, "conflicts", conflicts, []store.Conflict{one.Conflicts[0], two.Conflicts[0]})
+	retries, err := s.Retries(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	same(t, "retries", retries, []store.Retry{two.Retries[0], one.Retries[0]})
+	projects, err := s.Projects(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	same(t, "projects", projects, []store.Project{one.Projects[0], two.Projects[0]})
+	audit, err := s.Audit(ctx, store.AuditFilter{Pair: "board", Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	same(t, "latest audit", audit, two.Audit)
+	samples, err := s.Samples(ctx, store.SampleFilter{Since: two.Samples[0].Time})
+	if err != nil {
+		t.Fatal(err)
+	}
+	same(t, "recent samples", samples, two.Samples)
+
+	snap, err := s.Export(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Mappings) != 2 || len(snap.Conflicts) != 2 || len(snap.Comments) != 2 || len(snap.Attachments) != 2 ||
+		len(snap.Retries) != 2 || len(snap.Projects) != 2 || len(snap.Audit) != 2 || len(snap.Journal) != 2 ||
+		len(snap.Samples) != 2 || len(snap.Settings) != 2 {
+		t.Errorf("want two records of every kind exported, got %+v", snap)
+	}
+}
@@ -0,0 +1,60 @@
+package store
+
+import (
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// TenantLocation returns the location of the store of the named tenant, kept apart from the store at
+// location and those of other tenants:
+//
+//   - files and SQLite databases move to a tenants/<name> directory next to the file,
+//   - S3 objects move under a tenants/<name>/ prefix of their key, and
+//   - PostgreSQL databases use the schema tenant_<name>, created when the store is opened.
+//
+// The name must be safe in a path and a schema name, as those config.ValidTenantName accepts are.
+func TenantLocation(location, name string) string {
+	switch {
+	case strings.HasPrefix(location, "sqlite://"):
+		dsn, query, _ := strings.Cut(strings.TrimPrefix(location, "sqlite://"), "?")
+		location = "sqlite://" + tenantPath(dsn, name)
+		if query != "" {
+			location += "?" + query
+		}
+		return location
+	case strings.HasPrefix(location, "postgres://"), strings.HasPrefix(location, "postgresql://"):
+		u, err := url.Parse(location)
+		if err != nil {
+			return location
+		}
+		q := u.Query()
+		q.Set("search_path", tenantSchema(name))
+		u.RawQuery = q.Encode()
+		return u.String()
+	case strings.HasPrefix(location, "s3://"):
+		u, err := url.Parse(location)
+		if err != nil {
+			return location
+		}
+		dir, file := path.Split(u.Path)
+		u.Path = path.Join(dir, "tenants", name, file)
+		return u.String()
+	case strings.HasPrefix(location, "file://"):
+		return "file://" + tenantPath(strings.TrimPrefix(location, "file://"), name)
+	default:
+		return tenantPath(location, name)
+	}
+}
+
+// tenantPath returns the file path p in the directory of the named tenant.
+func tenantPath(p, name string) string {
+	dir, file := filepath.Split(p)
+	return filepath.Join(dir, "tenants", name, file)
+}
+
+// tenantSchema returns the PostgreSQL schema of the named tenant.
+func tenantSchema(name string) string {
+	return "tenant_" + strings.ReplaceAll(name, "-", "_")
+}
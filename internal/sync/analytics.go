@@ -0,0 +1,68 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// DefaultAnalyticsRetention is how long samples are kept by pairs that do not set AnalyticsRetention.
+const DefaultAnalyticsRetention = 400 * 24 * time.Hour
+
+// sample records the state of item for analytics when it is new to the pair or changed since its last
+// sync. Nothing is sampled in dry runs or while replaying the journal.
+func (e *Engine) sample(ctx context.Context, item ado.WorkItem) error {
+	if !e.cfg.Analytics || e.plan != nil {
+		return nil
+	}
+	if _, ok := replaying(ctx); ok {
+		return nil
+	}
+	switch m, err := e.store.Get(ctx, item.ID); {
+	case err == nil && m.ADORev == item.Rev:
+		return nil
+	case err != nil && !errors.Is(err, store.ErrNotFound):
+		return err
+	}
+	s := store.Sample{
+		Time:         time.Now().UTC(),
+		Pair:         e.cfg.Name,
+		CycleID:      cycleID(ctx),
+		ADOID:        item.ID,
+		Rev:          item.Rev,
+		Type:         item.Type(),
+		State:        item.State(),
+		Done:         e.cfg.taskState(item.State()).completed,
+		Iteration:    item.IterationPath(),
+		Area:         item.String(ado.FieldAreaPath),
+		Points:       hours(item.Fields[ado.FieldStoryPoints]),
+		Remaining:    hours(item.Fields[ado.FieldRemainingWork]),
+		Created:      item.CreatedDate(),
+		Changed:      item.ChangedDate(),
+		StateChanged: item.StateChangeDate(),
+	}
+	if err := e.store.PutSample(ctx, s); err != nil {
+		return fmt.Errorf("sampling work item: %w", err)
+	}
+	return nil
+}
+
+// pruneSamples removes the samples older than the retention period.
+func (e *Engine) pruneSamples(ctx context.Context) {
+	if !e.cfg.Analytics || e.cfg.AnalyticsRetention <= 0 {
+		return
+	}
+	n, err := e.store.PruneSamples(ctx, time.Now().Add(-e.cfg.AnalyticsRetention))
+	if err != nil {
+		logging.From(ctx).Error("failed to prune analytics samples", "error", err)
+		return
+	}
+	if n > 0 {
+		logging.From(ctx).Info("pruned analytics samples", "removed", n)
+	}
+}
@@ -0,0 +1,170 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// AnchorExternal anchors tasks to their work item in the external field of the task, which only Asana
+// OAuth apps can read and write.
+const AnchorExternal = "external"
+
+// externalPrefix starts the external data of anchored tasks, followed by the work item ID.
+const externalPrefix = "ado:"
+
+// anchorsKey is the store setting recording the anchor the tasks of a pair were migrated to, whose name is
+// appended.
+const anchorsKey = "anchors:"
+
+// anchorField is the anchor custom field resolved on an Asana project.
+type anchorField struct {
+	gid string
+	// number is set for number fields, which hold the ID as a number rather than as text.
+	number bool
+}
+
+// anchoredField reports whether the anchor is a custom field.
+func (c Config) anchoredField() bool {
+	return c.Anchor != "" && c.Anchor != AnchorExternal
+}
+
+// resolveAnchorField resolves the anchor custom field on the Asana project, which must be a text or a
+// number field.
+func (e *Engine) resolveAnchorField(ctx context.Context, project string) (*anchorField, error) {
+	if !e.cfg.anchoredField() {
+		return nil, nil
+	}
+	fields, err := e.asana.ProjectCustomFields(ctx, project)
+	if err != nil {
+		return nil, fmt.Errorf("listing asana custom fields: %w", err)
+	}
+	cf, ok := findCustomField(fields, e.cfg.Anchor)
+	if !ok {
+		return nil, withCode(CodeFieldMissing, fmt.Errorf("anchor field %q not found on asana project %s", e.cfg.Anchor, project))
+	}
+	switch FieldType(cf.ResourceSubtype) {
+	case TypeText, TypeNumber:
+	default:
+		return nil, fmt.Errorf("anchor field %q is %s, not text or number", e.cfg.Anchor, cf.ResourceSubtype)
+	}
+	return &anchorField{gid: cf.GID, number: FieldType(cf.ResourceSubtype) == TypeNumber}, nil
+}
+
+// anchorOf returns the work item the task is anchored to, or false when it has no anchor.
+func (c Config) anchorOf(task *asana.Task) (int, bool) {
+	switch {
+	case c.Anchor == "":
+		return 0, false
+	case c.Anchor == AnchorExternal:
+		if task.External == nil || !strings.HasPrefix(task.External.Data, externalPrefix) {
+			return 0, false
+		}
+		id, err := strconv.Atoi(strings.TrimPrefix(task.External.Data, externalPrefix))
+		return id, err == nil && id > 0
+	}
+	cf, ok := findCustomField(task.CustomFields, c.Anchor)
+	switch {
+	case !ok:
+		return 0, false
+	case cf.NumberValue != nil:
+		return int(*cf.NumberValue), *cf.NumberValue > 0
+	case cf.TextValue != nil:
+		id, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(*cf.TextValue), "AB#"))
+		return id, err == nil && id > 0
+	}
+	return 0, false
+}
+
+// taskID returns the work item the task belongs to: the one it is anchored to or, for tasks without an
+// anchor, the one referenced in its name.
+func (c Config) taskID(task *asana.Task) (int, bool) {
+	if id, ok := c.anchorOf(task); ok {
+		return id, true
+	}
+	return parseTaskID(task.Name)
+}
+
+// anchor adds the anchor of the work item id to req, which writes task in project, unless task is already
+// anchored to it. task is nil for new tasks. It reports whether req changed.
+func (e *Engine) anchor(project string, id int, task *asana.Task, req *asana.TaskRequest) bool {
+	if task != nil {
+		if anchored, ok := e.cfg.anchorOf(task); ok && anchored == id {
+			return false
+		}
+	}
+	switch {
+	case e.cfg.Anchor == "":
+		return false
+	case e.cfg.Anchor == AnchorExternal:
+		req.External = &asana.External{Data: externalPrefix + strconv.Itoa(id)}
+		return true
+	}
+	f := e.target(project).anchorField
+	if f == nil {
+		return false
+	}
+	if req.CustomFields == nil {
+		req.CustomFields = map[string]interface{}{}
+	}
+	if f.number {
+		req.CustomFields[f.gid] = float64(id)
+	} else {
+		req.CustomFields[f.gid] = strconv.Itoa(id)
+	}
+	return true
+}
+
+// migrateAnchors anchors the tasks of the pair that predate the anchor, once for every anchor the pair is
+// configured with. Tasks are matched to their work item by mapping, or by the reference in their name.
+func (e *Engine) migrateAnchors(ctx context.Context) error {
+	if e.cfg.Anchor == "" {
+		return nil
+	}
+	switch done, err := e.store.Setting(ctx, anchorsKey+e.cfg.Name); {
+	case err == nil && done == e.cfg.Anchor:
+		return nil
+	case err != nil && !errors.Is(err, store.ErrNotFound):
+		return err
+	}
+	tasks, err := e.listTasks(ctx, time.Time{})
+	if err != nil {
+		return fmt.Errorf("listing asana tasks: %w", err)
+	}
+	anchored := 0
+	for i := range tasks {
+		t := &tasks[i]
+		if _, ok := e.cfg.anchorOf(t); ok {
+			continue
+		}
+		id, ok := parseTaskID(t.Name)
+		switch m, err := e.store.ByAsanaGID(ctx, t.GID); {
+		case err == nil:
+			id, ok = m.ADOID, e.owns(m)
+		case !errors.Is(err, store.ErrNotFound):
+			return err
+		}
+		var req asana.TaskRequest
+		if !ok || !e.anchor(e.projectIn(t), id, t, &req) {
+			continue
+		}
+		if _, err := e.updateTask(ctx, t.GID, req); err != nil {
+			return fmt.Errorf("anchoring asana task %s: %w", t.GID, err)
+		}
+		anchored++
+	}
+	if anchored > 0 {
+		logging.From(ctx).Info("anchored existing asana tasks to their work items", "tasks", anchored, "anchor", e.cfg.Anchor)
+	}
+	if err := e.store.SetSetting(ctx, anchorsKey+e.cfg.Name, e.cfg.Anchor); err != nil {
+		return fmt.Errorf("saving anchor migration: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package sync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// DefaultMaxAttachmentSize is the largest attachment mirrored when no limit is configured.
+const DefaultMaxAttachmentSize = 100 << 20
+
+// syncAttachments mirrors file attachments between item and task according to the configured attachment direction.
+// Files are deduplicated per work item by SHA-256, so the same file is never uploaded to a side that already has it.
+func (e *Engine) syncAttachments(ctx context.Context, item ado.WorkItem, task *asana.Task, ch changes) error {
+	dir := e.cfg.AttachmentDirection
+	if dir == "" {
+		return nil
+	}
+	max := e.cfg.MaxAttachmentSize
+	if max <= 0 {
+		max = DefaultMaxAttachmentSize
+	}
+
+	known, err := e.store.Attachments(ctx, item.ID)
+	if err != nil {
+		return err
+	}
+	byADO := map[string]bool{}
+	byAsana := map[string]bool{}
+	bySum := map[string]bool{}
+	for _, a := range known {
+		byADO[a.ADOURL] = true
+		byAsana[a.AsanaGID] = true
+		bySum[a.SHA256] = true
+	}
+
+	if dir != AsanaToADO && ch.ado {
+		for _, rel := range item.Attachments() {
+			if byADO[rel.URL] {
+				continue
+			}
+			if size := rel.Size(); size > max {
+				logging.From(ctx).Warn("skipping attachment: exceeds the size limit", "name", rel.Name(), "size", size, "limit", max)
+				continue
+			}
+			data, err := e.ado.DownloadAttachment(ctx, rel.URL, max)
+			if errors.Is(err, ado.ErrTooLarge) {
+				logging.From(ctx).Warn("skipping attachment: exceeds the size limit", "name", rel.Name(), "limit", max)
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("downloading attachment %q: %w", rel.Name(), err)
+			}
+			m := store.AttachmentMapping{ADOID: item.ID, ADOURL: rel.URL, Name: rel.Name(), SHA256: checksum(data), Origin: store.OriginADO}
+			if !bySum[m.SHA256] {
+				att, err := e.asana.UploadAttachment(ctx, task.GID, rel.Name(), data)
+				if err != nil {
+					return fmt.Errorf("uploading attachment %q: %w", rel.Name(), err)
+				}
+				m.AsanaGID = att.GID
+				logging.From(ctx).Info("mirrored attachment to asana", "name", rel.Name())
+			}
+			if err := e.store.PutAttachment(ctx, m); err != nil {
+				return err
+			}
+			byAsana[m.AsanaGID] = true
+			bySum[m.SHA256] = true
+		}
+	}
+
+	if dir != ADOToAsana {
+		atts, err := e.asana.Attachments(ctx, task.GID)
+		if err != nil {
+			return fmt.Errorf("listing asana attachments: %w", err)
+		}
+		var ops []ado.PatchOperation
+		for _, a := range atts {
+			if byAsana[a.GID] {
+				continue
+			}
+			data, err := e.asana.DownloadAttachment(ctx, a, max)
+			if errors.Is(err, asana.ErrTooLarge) {
+				logging.From(ctx).Warn("skipping asana attachment: exceeds the size limit", "name", a.Name, "limit", max)
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("downloading asana attachment %q: %w", a.Name, err)
+			}
+			m := store.AttachmentMapping{ADOID: item.ID, AsanaGID: a.GID, Name: a.Name, SHA256: checksum(data), Origin: store.OriginAsana}
+			if !bySum[m.SHA256] {
+				u, err := e.ado.UploadAttachment(ctx, e.cfg.ADOProject, a.Name, data)
+				if err != nil {
+					return fmt.Errorf("uploading attachment %q: %w", a.Name, err)
+				}
+				m.ADOURL = u
+				ops = append(ops, ado.AddRelation(ado.RelAttachedFile, u, map[string]interface{}{"comment": "Synced from Asana"}))
+			}
+			if err := e.store.PutAttachment(ctx, m); err != nil {
+				return err
+			}
+			bySum[m.SHA256] = true
+		}
+		if len(ops) > 0 {
+			if _, err := e.ado.UpdateWorkItem(ctx, item.ID, ops); err != nil {
+				return fmt.Errorf("linking attachments: %w", err)
+			}
+			logging.From(ctx).Info("mirrored asana attachments to ado", "count", len(ops))
+		}
+	}
+	return nil
+}
+
+// checksum returns the hex encoded SHA-256 of data.
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,598 @@
+package sync
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/ratelimit"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// DefaultAuditRetention is how long audit records are kept by pairs that do not set AuditRetention.
+const DefaultAuditRetention = 90 * 24 * time.Hour
+
+type (
+	// cycleKey is the context key of the ID of the sync cycle making writes.
+	cycleKey struct{}
+	// subjectKey is the context key of the subject of the writes.
+	subjectKey struct{}
+)
+
+// subject is the state of the work item and task being synced before the writes of the sync, which the
+// audit log records as the previous values. Either may be nil.
+type subject struct {
+	item *ado.WorkItem
+	task *asana.Task
+}
+
+// withCycle returns a context carrying a new cycle ID, which also identifies the log lines of the cycle.
+func withCycle(ctx context.Context) context.Context {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	id := time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(b)
+	ctx = context.WithValue(ctx, cycleKey{}, id)
+	return logging.With(ctx, logging.KeyCycle, id)
+}
+
+// begin returns the context of a cycle or targeted sync of the pair, carrying a new cycle ID and counting
+// its API requests against the call budget of the pair.
+func (e *Engine) begin(ctx context.Context) context.Context {
+	return ratelimit.WithAllowance(withCycle(logging.With(ctx, logging.KeyPair, e.cfg.Name)), e.budget)
+}
+
+// cycleID returns the ID of the cycle carried by ctx.
+func cycleID(ctx context.Context) string {
+	id, _ := ctx.Value(cycleKey{}).(string)
+	return id
+}
+
+func withSubject(ctx context.Context, item *ado.WorkItem, task *asana.Task) context.Context {
+	return context.WithValue(ctx, subjectKey{}, subject{item: item, task: task})
+}
+
+func subjectOf(ctx context.Context) subject {
+	s, _ := ctx.Value(subjectKey{}).(subject)
+	return s
+}
+
+// auditor appends the writes of an engine to the audit log in its store.
+type auditor struct {
+	store store.Store
+	cfg   Config
+
+	mu gosync.Mutex
+	// names maps the GIDs of sections, tags, custom fields and enum options to their names, so records show
+	// names rather than GIDs.
+	names map[string]string
+}
+
+// record stores r, completing it with the time, pair, cycle and whichever of the work item and task is
+// missing. A failure is logged rather than failing the write, which has already happened.
+func (a *auditor) record(ctx context.Context, r store.AuditRecord) {
+	r.Time, r.Pair, r.CycleID = time.Now().UTC(), a.cfg.Name, cycleID(ctx)
+	switch {
+	case r.ADOID == 0 && r.AsanaGID != "":
+		if m, err := a.store.ByAsanaGID(ctx, r.AsanaGID); err == nil {
+			r.ADOID = m.ADOID
+		}
+	case r.AsanaGID == "" && r.ADOID != 0:
+		if m, err := a.store.Get(ctx, r.ADOID); err == nil {
+			r.AsanaGID = m.AsanaGID
+		}
+	}
+	if err := a.store.PutAudit(ctx, r); err != nil {
+		logging.From(ctx).Error("failed to record audit entry", "action", r.Action, "error", err)
+	}
+}
+
+func (a *auditor) setName(gid, name string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.names == nil {
+		a.names = map[string]string{}
+	}
+	a.names[gid] = name
+}
+
+// name returns the name of the record with the given GID, or the GID when it is not known.
+func (a *auditor) name(gid string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if n, ok := a.names[gid]; ok {
+		return n
+	}
+	return gid
+}
+
+// prune removes the audit records older than the retention period.
+func (a *auditor) prune(ctx context.Context) {
+	if a.cfg.AuditRetention <= 0 {
+		return
+	}
+	n, err := a.store.PruneAudit(ctx, time.Now().Add(-a.cfg.AuditRetention))
+	if err != nil {
+		logging.From(ctx).Error("failed to prune audit log", "error", err)
+		return
+	}
+	if n > 0 {
+		logging.From(ctx).Info("pruned audit log", "removed", n)
+	}
+}
+
+// auditADO records the ADO writes it performs in the audit log.
+type auditADO struct {
+	ADO
+	audit *auditor
+}
+
+func (a *auditADO) UpdateWorkItem(ctx context.Context, id int, ops []ado.PatchOperation) (*ado.WorkItem, error) {
+	wi, err := a.ADO.UpdateWorkItem(ctx, id, ops)
+	if err != nil {
+		return nil, err
+	}
+	r := store.AuditRecord{System: SystemADO, Action: string(ActionUpdate), ADOID: id}
+	before := subjectOf(ctx).item
+	if before != nil && before.ID != id {
+		before = nil
+	}
+	for _, op := range ops {
+		if op.Op == "test" {
+			continue
+		}
+		field := strings.TrimPrefix(op.Path, "/fields/")
+		if field == op.Path {
+			r.Changes = append(r.Changes, store.FieldChange{Field: strings.Trim(op.Path, "/-"), After: auditValue(op.Value)})
+			continue
+		}
+		c := store.FieldChange{Field: field, After: auditValue(op.Value)}
+		if before != nil {
+			c.Before = text(before.Fields[field])
+		}
+		r.Changes = append(r.Changes, c)
+		if s, ok := op.Value.(string); ok && field == ado.FieldState && a.audit.cfg.isClosed(s) {
+			r.Action = string(ActionClose)
+		}
+	}
+	a.audit.record(ctx, r)
+	return wi, nil
+}
+
+func (a *auditADO) CreateWorkItem(ctx context.Context, project, typ string, ops []ado.PatchOperation) (*ado.WorkItem, error) {
+	wi, err := a.ADO.CreateWorkItem(ctx, project, typ, ops)
+	if err != nil {
+		return nil, err
+	}
+	r := store.AuditRecord{System: SystemADO, Action: string(ActionCreate), ADOID: wi.ID,
+		Changes: []store.FieldChange{{Field: ado.FieldWorkItemType, After: typ}}}
+	for _, op := range ops {
+		r.Changes = append(r.Changes, store.FieldChange{Field: strings.TrimPrefix(op.Path, "/fields/"), After: auditValue(op.Value)})
+	}
+	if t := subjectOf(ctx).task; t != nil {
+		r.AsanaGID = t.GID
+	}
+	a.audit.record(ctx, r)
+	return wi, nil
+}
+
+func (a *auditADO) AddComment(ctx context.Context, project string, id int, text string) (*ado.Comment, error) {
+	c, err := a.ADO.AddComment(ctx, project, id, text)
+	if err != nil {
+		return nil, err
+	}
+	a.audit.record(ctx, store.AuditRecord{System: SystemADO, Action: string(ActionComment), ADOID: id,
+		Changes: []store.FieldChange{{Field: "comment", After: text}}})
+	return c, nil
+}
+
+func (a *auditADO) UploadAttachment(ctx context.Context, project, name string, data []byte) (string, error) {
+	u, err := a.ADO.UploadAttachment(ctx, project, name, data)
+	if err != nil {
+		return "", err
+	}
+	r := store.AuditRecord{System: SystemADO, Action: string(ActionAttach),
+		Changes: []store.FieldChange{{Field: "attachment", After: name}}}
+	if item := subjectOf(ctx).item; item != nil {
+		r.ADOID = item.ID
+	}
+	a.audit.record(ctx, r)
+	return u, nil
+}
+
+// auditAsana records the Asana writes it performs in the audit log.
+type auditAsana struct {
+	Asana
+	audit *auditor
+}
+
+// before returns the state of the task with the given GID before the sync, or nil when it is not known.
+func (a *auditAsana) before(ctx context.Context, gid string) *asana.Task {
+	if t := subjectOf(ctx).task; t != nil && t.GID == gid {
+		return t
+	}
+	return nil
+}
+
+func (a *auditAsana) CreateTask(ctx context.Context, req asana.TaskRequest) (*asana.Task, error) {
+	t, err := a.Asana.CreateTask(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	r := store.AuditRecord{System: SystemAsana, Action: string(ActionCreate), AsanaGID: t.GID, Changes: a.taskChanges(nil, req)}
+	if item := subjectOf(ctx).item; item != nil {
+		r.ADOID = item.ID
+	}
+	a.audit.record(ctx, r)
+	return t, nil
+}
+
+func (a *auditAsana) UpdateTask(ctx context.Context, gid string, req asana.TaskRequest) (*asana.Task, error) {
+	t, err := a.Asana.UpdateTask(ctx, gid, req)
+	if err != nil {
+		return nil, err
+	}
+	r := store.AuditRecord{System: SystemAsana, Action: string(ActionUpdate), AsanaGID: gid, Changes: a.taskChanges(a.before(ctx, gid), req)}
+	if req.Completed != nil && *req.Completed {
+		r.Action = string(ActionClose)
+	}
+	a.audit.record(ctx, r)
+	return t, nil
+}
+
+func (a *auditAsana) DeleteTask(ctx context.Context, gid string) error {
+	if err := a.Asana.DeleteTask(ctx, gid); err != nil {
+		return err
+	}
+	a.audit.record(ctx, store.AuditRecord{System: SystemAsana, Action: string(ActionDelete), AsanaGID: gid})
+	return nil
+}
+
+func (a *auditAsana) AddComment(ctx context.Context, gid, text string) (*asana.Story, error) {
+	s, err := a.Asana.AddComment(ctx, gid, text)
+	if err != nil {
+		return nil, err
+	}
+	a.audit.record(ctx, store.AuditRecord{System: SystemAsana, Action: string(ActionComment), AsanaGID: gid,
+		Changes: []store.FieldChange{{Field: "comment", After: text}}})
+	return s, nil
+}
+
+func (a *auditAsana) UploadAttachment(ctx context.Context, gid, name string, data []byte) (*asana.Attachment, error) {
+	att, err := a.Asana.UploadAttachment(ctx, gid, name, data)
+	if err != nil {
+		return nil, err
+	}
+	a.audit.record(ctx, store.AuditRecord{System: SystemAsana, Action: string(ActionAttach), AsanaGID: gid,
+		Changes: []store.FieldChange{{Field: "attachment", After: name}}})
+	return att, nil
+}
+
+func (a *auditAsana) ProjectCustomFields(ctx context.Context, projectGID string) ([]asana.CustomField, error) {
+	fields, err := a.Asana.ProjectCustomFields(ctx, projectGID)
+	for _, f := range fields {
+		a.audit.setName(f.GID, f.Name)
+		for _, o := range f.EnumOptions {
+			a.audit.setName(o.GID, o.Name)
+		}
+	}
+	return fields, err
+}
+
+func (a *auditAsana) ProjectSections(ctx context.Context, projectGID string) ([]asana.Section, error) {
+	sections, err := a.Asana.ProjectSections(ctx, projectGID)
+	for _, s := range sections {
+		a.audit.setName(s.GID, s.Name)
+	}
+	return sections, err
+}
+
+func (a *auditAsana) CreateProject(ctx context.Context, req asana.ProjectRequest) (*asana.Project, error) {
+	p, err := a.Asana.CreateProject(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	a.audit.setName(p.GID, req.Name)
+	a.audit.record(ctx, store.AuditRecord{System: SystemAsana, Action: string(ActionCreate),
+		Changes: []store.FieldChange{{Field: "project", After: req.Name}}})
+	return p, nil
+}
+
+func (a *auditAsana) AddCustomFieldSetting(ctx context.Context, projectGID, fieldGID string) error {
+	if err := a.Asana.AddCustomFieldSetting(ctx, projectGID, fieldGID); err != nil {
+		return err
+	}
+	a.audit.record(ctx, store.AuditRecord{System: SystemAsana, Action: string(ActionUpdate),
+		Changes: []store.FieldChange{{Field: "project", After: a.audit.name(projectGID)}, {Field: "custom_field", After: a.audit.name(fieldGID)}}})
+	return nil
+}
+
+func (a *auditAsana) AddPortfolioItem(ctx context.Context, portfolioGID, projectGID string) error {
+	if err := a.Asana.AddPortfolioItem(ctx, portfolioGID, projectGID); err != nil {
+		return err
+	}
+	a.audit.record(ctx, store.AuditRecord{System: SystemAsana, Action: string(ActionUpdate),
+		Changes: []store.FieldChange{{Field: "project", After: a.audit.name(projectGID)}, {Field: "portfolio", After: portfolioGID}}})
+	return nil
+}
+
+func (a *auditAsana) AddProjectMembers(ctx context.Context, projectGID string, users []string) error {
+	if err := a.Asana.AddProjectMembers(ctx, projectGID, users); err != nil {
+		return err
+	}
+	changes := []store.FieldChange{{Field: "project", After: a.audit.name(projectGID)}}
+	for _, u := range users {
+		changes = append(changes, store.FieldChange{Field: "member", After: u})
+	}
+	a.audit.record(ctx, store.AuditRecord{System: SystemAsana, Action: string(ActionUpdate), Changes: changes})
+	return nil
+}
+
+func (a *auditAsana) AddFollowers(ctx context.Context, taskGID string, followers []string) error {
+	if err := a.Asana.AddFollowers(ctx, taskGID, followers); err != nil {
+		return err
+	}
+	changes := make([]store.FieldChange, 0, len(followers))
+	for _, f := range followers {
+		changes = append(changes, store.FieldChange{Field: "follower", After: f})
+	}
+	a.audit.record(ctx, store.AuditRecord{System: SystemAsana, Action: string(ActionUpdate), AsanaGID: taskGID, Changes: changes})
+	return nil
+}
+
+func (a *auditAsana) CreateStatusUpdate(ctx context.Context, req asana.StatusUpdateRequest) (*asana.StatusUpdate, error) {
+	u, err := a.Asana.CreateStatusUpdate(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	a.audit.record(ctx, store.AuditRecord{System: SystemAsana, Action: string(ActionCreate),
+		Changes: []store.FieldChange{{Field: "project", After: a.audit.name(req.Parent)}, {Field: "status_update", After: req.Title}}})
+	return u, nil
+}
+
+func (a *auditAsana) DeleteStatusUpdate(ctx context.Context, gid string) error {
+	if err := a.Asana.DeleteStatusUpdate(ctx, gid); err != nil {
+		return err
+	}
+	a.audit.record(ctx, store.AuditRecord{System: SystemAsana, Action: string(ActionDelete),
+		Changes: []store.FieldChange{{Field: "status_update", Before: gid}}})
+	return nil
+}
+
+func (a *auditAsana) CreateSection(ctx context.Context, projectGID, name string) (*asana.Section, error) {
+	s, err := a.Asana.CreateSection(ctx, projectGID, name)
+	if err != nil {
+		return nil, err
+	}
+	a.audit.setName(s.GID, name)
+	a.audit.record(ctx, store.AuditRecord{System: SystemAsana, Action: string(ActionCreate),
+		Changes: []store.FieldChange{{Field: "section", After: name}}})
+	return s, nil
+}
+
+func (a *auditAsana) CreateEnumOption(ctx context.Context, fieldGID, name string) (*asana.EnumOption, error) {
+	o, err := a.Asana.CreateEnumOption(ctx, fieldGID, name)
+	if err != nil {
+		return nil, err
+	}
+	a.audit.setName(o.GID, name)
+	a.audit.record(ctx, store.AuditRecord{System: SystemAsana, Action: string(ActionCreate),
+		Changes: []store.FieldChange{{Field: a.audit.name(fieldGID), After: name}}})
+	return o, nil
+}
+
+func (a *auditAsana) CreateCustomField(ctx context.Context, workspaceGID, name, subtype string, options []string) (*asana.CustomField, error) {
+	f, err := a.Asana.CreateCustomField(ctx, workspaceGID, name, subtype, options)
+	if err != nil {
+		return nil, err
+	}
+	a.audit.setName(f.GID, name)
+	a.audit.record(ctx, store.AuditRecord{System: SystemAsana, Action: string(ActionCreate),
+		Changes: []store.FieldChange{{Field: "custom_field", After: name}}})
+	return f, nil
+}
+
+func (a *auditAsana) AddTaskToSection(ctx context.Context, sectionGID, taskGID string) error {
+	if err := a.Asana.AddTaskToSection(ctx, sectionGID, taskGID); err != nil {
+		return err
+	}
+	c := store.FieldChange{Field: "section", After: a.audit.name(sectionGID)}
+	if t := a.before(ctx, taskGID); t != nil {
+		for _, m := range t.Memberships {
+			if m.Section != nil {
+				c.Before = m.Section.Name
+				break
+			}
+		}
+	}
+	a.audit.record(ctx, store.AuditRecord{System: SystemAsana, Action: string(ActionUpdate), AsanaGID: taskGID, Changes: []store.FieldChange{c}})
+	return nil
+}
+
+func (a *auditAsana) WorkspaceTags(ctx context.Context, workspaceGID string) ([]asana.Tag, error) {
+	tags, err := a.Asana.WorkspaceTags(ctx, workspaceGID)
+	for _, t := range tags {
+		a.audit.setName(t.GID, t.Name)
+	}
+	return tags, err
+}
+
+func (a *auditAsana) CreateTag(ctx context.Context, workspaceGID, name string) (*asana.Tag, error) {
+	t, err := a.Asana.CreateTag(ctx, workspaceGID, name)
+	if err != nil {
+		return nil, err
+	}
+	a.audit.setName(t.GID, name)
+	a.audit.record(ctx, store.AuditRecord{System: SystemAsana, Action: string(ActionCreate),
+		Changes: []store.FieldChange{{Field: "tag", After: name}}})
+	return t, nil
+}
+
+func (a *auditAsana) AddTag(ctx context.Context, taskGID, tagGID string) error {
+	if err := a.Asana.AddTag(ctx, taskGID, tagGID); err != nil {
+		return err
+	}
+	a.audit.record(ctx, store.AuditRecord{System: SystemAsana, Action: string(ActionUpdate), AsanaGID: taskGID,
+		Changes: []store.FieldChange{{Field: "tag", After: a.audit.name(tagGID)}}})
+	return nil
+}
+
+func (a *auditAsana) RemoveTag(ctx context.Context, taskGID, tagGID string) error {
+	if err := a.Asana.RemoveTag(ctx, taskGID, tagGID); err != nil {
+		return err
+	}
+	a.audit.record(ctx, store.AuditRecord{System: SystemAsana, Action: string(ActionUpdate), AsanaGID: taskGID,
+		Changes: []store.FieldChange{{Field: "tag", Before: a.audit.name(tagGID)}}})
+	return nil
+}
+
+func (a *auditAsana) SetParent(ctx context.Context, taskGID, parentGID string) error {
+	if err := a.Asana.SetParent(ctx, taskGID, parentGID); err != nil {
+		return err
+	}
+	c := store.FieldChange{Field: "parent", After: parentGID}
+	if t := a.before(ctx, taskGID); t != nil && t.Parent != nil {
+		c.Before = t.Parent.GID
+	}
+	a.audit.record(ctx, store.AuditRecord{System: SystemAsana, Action: string(ActionUpdate), AsanaGID: taskGID, Changes: []store.FieldChange{c}})
+	return nil
+}
+
+func (a *auditAsana) AddDependencies(ctx context.Context, taskGID string, dependencies []string) error {
+	if err := a.Asana.AddDependencies(ctx, taskGID, dependencies); err != nil {
+		return err
+	}
+	changes := make([]store.FieldChange, 0, len(dependencies))
+	for _, d := range dependencies {
+		changes = append(changes, store.FieldChange{Field: "dependency", After: d})
+	}
+	a.audit.record(ctx, store.AuditRecord{System: SystemAsana, Action: string(ActionUpdate), AsanaGID: taskGID, Changes: changes})
+	return nil
+}
+
+func (a *auditAsana) RemoveDependencies(ctx context.Context, taskGID string, dependencies []string) error {
+	if err := a.Asana.RemoveDependencies(ctx, taskGID, dependencies); err != nil {
+		return err
+	}
+	changes := make([]store.FieldChange, 0, len(dependencies))
+	for _, d := range dependencies {
+		changes = append(changes, store.FieldChange{Field: "dependency", Before: d})
+	}
+	a.audit.record(ctx, store.AuditRecord{System: SystemAsana, Action: string(ActionUpdate), AsanaGID: taskGID, Changes: changes})
+	return nil
+}
+
+func (a *auditAsana) AddProject(ctx context.Context, taskGID, projectGID string) error {
+	if err := a.Asana.AddProject(ctx, taskGID, projectGID); err != nil {
+		return err
+	}
+	a.audit.record(ctx, store.AuditRecord{System: SystemAsana, Action: string(ActionUpdate), AsanaGID: taskGID,
+		Changes: []store.FieldChange{{Field: "project", After: a.audit.name(projectGID)}}})
+	return nil
+}
+
+func (a *auditAsana) RemoveProject(ctx context.Context, taskGID, projectGID string) error {
+	if err := a.Asana.RemoveProject(ctx, taskGID, projectGID); err != nil {
+		return err
+	}
+	a.audit.record(ctx, store.AuditRecord{System: SystemAsana, Action: string(ActionUpdate), AsanaGID: taskGID,
+		Changes: []store.FieldChange{{Field: "project", Before: a.audit.name(projectGID)}}})
+	return nil
+}
+
+// taskChanges lists the fields set by req with their values in t, which is nil for new tasks. Rich text and
+// plain notes are both recorded as the notes field.
+func (a *auditAsana) taskChanges(t *asana.Task, req asana.TaskRequest) []store.FieldChange {
+	if t == nil {
+		t = &asana.Task{}
+	}
+	var changes []store.FieldChange
+	add := func(field, before, after string) {
+		changes = append(changes, store.FieldChange{Field: field, Before: before, After: after})
+	}
+	if req.Name != nil {
+		add("name", t.Name, *req.Name)
+	}
+	if req.HTMLNotes != nil {
+		add("notes", t.Notes, *req.HTMLNotes)
+	}
+	if req.Notes != nil {
+		add("notes", t.Notes, *req.Notes)
+	}
+	if req.Completed != nil {
+		add("completed", strconv.FormatBool(t.Completed), strconv.FormatBool(*req.Completed))
+	}
+	if req.Assignee != nil {
+		before := ""
+		if t.Assignee != nil {
+			before = t.Assignee.GID
+		}
+		add("assignee", before, *req.Assignee)
+	}
+	if req.DueOn != nil {
+		add("due_on", t.DueOn, string(*req.DueOn))
+	}
+	if req.ResourceSubtype != nil {
+		add("resource_subtype", t.ResourceSubtype, *req.ResourceSubtype)
+	}
+	if req.ApprovalStatus != nil {
+		add("approval_status", t.ApprovalStatus, *req.ApprovalStatus)
+	}
+	gids := make([]string, 0, len(req.CustomFields))
+	for gid := range req.CustomFields {
+		gids = append(gids, gid)
+	}
+	sort.Strings(gids)
+	for _, gid := range gids {
+		v := req.CustomFields[gid]
+		before := ""
+		for _, cf := range t.CustomFields {
+			if cf.GID == gid {
+				before = customFieldText(cf)
+			}
+		}
+		after := auditValue(v)
+		if s, ok := v.(string); ok {
+			// Enum values are option GIDs.
+			after = a.audit.name(s)
+		}
+		add(a.audit.name(gid), before, after)
+	}
+	return changes
+}
+
+// customFieldText renders the value of a custom field of a task as text.
+func customFieldText(cf asana.CustomField) string {
+	switch {
+	case cf.TextValue != nil:
+		return *cf.TextValue
+	case cf.NumberValue != nil:
+		return strconv.FormatFloat(*cf.NumberValue, 'f', -1, 64)
+	case cf.EnumValue != nil:
+		return cf.EnumValue.Name
+	case cf.DateValue != nil:
+		return cf.DateValue.Date
+	}
+	return ""
+}
+
+// auditValue renders a value written to either system as text.
+func auditValue(v interface{}) string {
+	switch t := v.(type) {
+	case asana.DateValue:
+		return t.Date
+	case map[string]interface{}:
+		// Relations are recorded as the URL they link to.
+		if u, ok := t["url"].(string); ok {
+			return u
+		}
+	}
+	return text(v)
+}
@@ -0,0 +1,181 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/store"
+	"github.com/danstis/ado-asana-sync/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// backfillKey is the store setting holding the checkpoint of the backfill of a pair, whose name is appended.
+const backfillKey = "backfill:"
+
+// DefaultBackfillPage is the number of work items a backfill syncs between checkpoints when its options
+// do not set one.
+const DefaultBackfillPage = 500
+
+// BackfillOptions configures a backfill.
+type BackfillOptions struct {
+	// PageSize is the number of work items synced between checkpoints, defaulting to DefaultBackfillPage.
+	PageSize int
+	// Restart discards the checkpoint of an interrupted backfill and starts over.
+	Restart bool
+	// Progress, when set, is called after every page.
+	Progress func(BackfillProgress)
+}
+
+// BackfillProgress reports how far a backfill got.
+type BackfillProgress struct {
+	// Done is the number of work items synced, including those of earlier runs of a resumed backfill, and
+	// Failed the number of those that failed.
+	Done, Failed int
+	// Total is the number of work items the pair selects.
+	Total int
+	// Remaining estimates the time left from the pace of the current run. It is zero before the first page.
+	Remaining time.Duration
+}
+
+// checkpoint is the progress of a backfill saved in the store after every page.
+type checkpoint struct {
+	// Started is when the backfill first started; it becomes the watermark of incremental pairs.
+	Started time.Time `json:"started"`
+	// LastID is the highest work item ID synced. Items are synced in ID order.
+	LastID int `json:"last_id"`
+	Done   int `json:"done"`
+	Failed int `json:"failed"`
+}
+
+// Backfill syncs every work item the pair selects, in ID order and a page at a time, saving a checkpoint
+// after each page so an interrupted backfill resumes after the last page it finished. Removed items are
+// left to sync cycles. Once every item is synced the checkpoint is discarded and, when no item failed, the
+// watermark of incremental pairs is set to the start of the backfill.
+func (e *Engine) Backfill(ctx context.Context, opts BackfillOptions) (*Report, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ctx = e.begin(ctx)
+	ctx, span := tracing.Tracer().Start(ctx, "sync.backfill", trace.WithAttributes(attribute.String("sync.pair", e.cfg.Name)))
+	rep, err := e.backfill(ctx, opts)
+	tracing.End(span, err)
+	return rep, err
+}
+
+func (e *Engine) backfill(ctx context.Context, opts BackfillOptions) (*Report, error) {
+	if e.plan != nil {
+		return nil, fmt.Errorf("a backfill cannot run as a dry run")
+	}
+	if opts.PageSize <= 0 {
+		opts.PageSize = DefaultBackfillPage
+	}
+	rep := &Report{Plan: e.plan, Full: true}
+	if err := e.prepare(ctx); err != nil {
+		return nil, err
+	}
+	e.orphans, e.blocked, e.rollups = nil, nil, nil
+
+	cp, err := e.checkpoint(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Restart || cp.Started.IsZero() {
+		cp = checkpoint{Started: time.Now().UTC()}
+	} else {
+		logging.From(ctx).Info("resuming backfill", "started", cp.Started.Format(time.RFC3339), "done", cp.Done, "after_id", cp.LastID)
+	}
+
+	// Items selected since an earlier run with an ID below the checkpoint are left to sync cycles.
+	todo, err := e.selectIDs(ctx, e.cfg.ADOProject, e.cfg.WIQL(), cp.LastID)
+	if err != nil {
+		return nil, fmt.Errorf("querying work items: %w", err)
+	}
+	total := cp.Done + len(todo)
+	idx, err := e.indexTasks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	for i := 0; i < len(todo); i += opts.PageSize {
+		end := i + opts.PageSize
+		if end > len(todo) {
+			end = len(todo)
+		}
+		failed := len(rep.Failures)
+		n, err := e.syncAll(ctx, newCycleOrder(todo[i:end]), idx, rep)
+		rep.Items += n
+		if err != nil {
+			return nil, err
+		}
+		cp.LastID, cp.Done, cp.Failed = todo[end-1], cp.Done+n, cp.Failed+len(rep.Failures)-failed
+		if err := e.saveCheckpoint(ctx, cp); err != nil {
+			return nil, err
+		}
+		if opts.Progress != nil {
+			opts.Progress(BackfillProgress{Done: cp.Done, Failed: cp.Failed, Total: total, Remaining: remaining(time.Since(start), end, len(todo))})
+		}
+	}
+
+	if err := e.linkOrphans(ctx); err != nil {
+		return nil, err
+	}
+	if err := e.linkBlocked(ctx); err != nil {
+		return nil, err
+	}
+	if err := e.rollUp(ctx, rep); err != nil {
+		return nil, err
+	}
+	if err := e.store.SetSetting(ctx, backfillKey+e.cfg.Name, ""); err != nil {
+		return nil, fmt.Errorf("clearing backfill checkpoint: %w", err)
+	}
+	if cp.Failed == 0 {
+		if err := e.advance(ctx, cp.Started, false, rep); err != nil {
+			return nil, err
+		}
+	}
+	logging.From(ctx).Info("backfill finished", "synced", cp.Done-cp.Failed, "failed", cp.Failed, "duration", time.Since(start).Round(time.Second).String())
+	return e.finish(ctx, rep)
+}
+
+// remaining estimates the time left to sync total items when done of them took elapsed.
+func remaining(elapsed time.Duration, done, total int) time.Duration {
+	if done == 0 {
+		return 0
+	}
+	return time.Duration(float64(elapsed) / float64(done) * float64(total-done)).Round(time.Second)
+}
+
+// checkpoint returns the checkpoint of an interrupted backfill, or the zero checkpoint when there is none.
+func (e *Engine) checkpoint(ctx context.Context) (checkpoint, error) {
+	var cp checkpoint
+	v, err := e.store.Setting(ctx, backfillKey+e.cfg.Name)
+	switch {
+	case errors.Is(err, store.ErrNotFound) || (err == nil && v == ""):
+		return cp, nil
+	case err != nil:
+		return cp, err
+	}
+	if err := json.Unmarshal([]byte(v), &cp); err != nil {
+		logging.From(ctx).Warn("ignoring invalid backfill checkpoint", "value", v)
+		return checkpoint{}, nil
+	}
+	return cp, nil
+}
+
+// saveCheckpoint saves cp and flushes the store, so the progress survives the process being killed.
+func (e *Engine) saveCheckpoint(ctx context.Context, cp checkpoint) error {
+	b, _ := json.Marshal(cp)
+	if err := e.store.SetSetting(ctx, backfillKey+e.cfg.Name, string(b)); err != nil {
+		return fmt.Errorf("saving backfill checkpoint: %w", err)
+	}
+	if err := e.store.Flush(ctx); err != nil {
+		return fmt.Errorf("saving mappings: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package sync
+
+import (
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+)
+
+// BackLinkHyperlink links work items to their task with a hyperlink relation.
+const BackLinkHyperlink = "hyperlink"
+
+// backLinkComment is the comment of the hyperlinks added to work items, which tells them apart from
+// hyperlinks added by people.
+const backLinkComment = "Asana task"
+
+// backLinkOps returns the operations linking item to the permalink of task, replacing the link to a task
+// it was mapped to before, such as one that was deleted and created again.
+func (e *Engine) backLinkOps(item ado.WorkItem, task *asana.Task) []ado.PatchOperation {
+	link := task.PermalinkURL
+	switch {
+	case e.cfg.BackLink == "" || link == "":
+		return nil
+	case e.cfg.BackLink != BackLinkHyperlink:
+		if item.String(e.cfg.BackLink) == link {
+			return nil
+		}
+		return []ado.PatchOperation{ado.SetField(e.cfg.BackLink, link)}
+	}
+
+	var ops []ado.PatchOperation
+	linked := false
+	for i := len(item.Relations) - 1; i >= 0; i-- {
+		r := item.Relations[i]
+		if r.Rel != ado.RelHyperlink || r.Attributes["comment"] != backLinkComment {
+			continue
+		}
+		if r.URL == link && !linked {
+			linked = true
+			continue
+		}
+		ops = append(ops, ado.RemoveRelation(i))
+	}
+	if !linked {
+		ops = append(ops, ado.AddRelation(ado.RelHyperlink, link, map[string]interface{}{"comment": backLinkComment}))
+	}
+	return ops
+}
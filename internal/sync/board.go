@@ -0,0 +1,233 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+)
+
+// DefaultBoard is the board whose columns are synced when none is configured, that of the requirements
+// backlog of the Agile process. Scrum names it "Backlog items" and Basic "Issues".
+const DefaultBoard = "Stories"
+
+// BoardConfig mirrors the position of work items on a Kanban board in Asana: their column as the section of
+// their task or a custom field, and their swimlane as a custom field. Boards belong to a team, so the
+// position is read from the fields of the board of Team.
+type BoardConfig struct {
+	// Team owns the board, defaulting to the default team of the project, "<project> Team".
+	Team string `json:"team,omitempty"`
+	// Board is the backlog level or ID of the board, defaulting to DefaultBoard.
+	Board string `json:"board,omitempty"`
+	// Sections moves tasks into the section named after their column, or the one Columns maps it to.
+	Sections bool `json:"sections,omitempty"`
+	// Columns maps board columns to the sections of their tasks. It implies Sections.
+	Columns map[string]string `json:"columns,omitempty"`
+	// ColumnField and LaneField are the names or GIDs of the enum or text custom fields set to the column and
+	// the swimlane. Enum options are added as new columns and lanes appear.
+	ColumnField string `json:"column_field,omitempty"`
+	LaneField   string `json:"lane_field,omitempty"`
+}
+
+// enabled reports whether anything of the board is synced.
+func (b BoardConfig) enabled() bool {
+	return b.sections() || b.ColumnField != "" || b.LaneField != ""
+}
+
+// sections reports whether tasks are moved into the sections of their columns.
+func (b BoardConfig) sections() bool {
+	return b.Sections || len(b.Columns) > 0
+}
+
+// ValidateBoard checks the board settings name something to sync, and that column sections are not
+// combined with sprint sections.
+func (c Config) ValidateBoard() error {
+	b := c.Board
+	if !b.enabled() && (b.Team != "" || b.Board != "") {
+		return fmt.Errorf("board sync needs sections, columns, column_field or lane_field")
+	}
+	if b.sections() && c.Sprints.Mode == SprintsSection {
+		return fmt.Errorf("board column sections cannot be combined with sprint sections")
+	}
+	for column, section := range b.Columns {
+		if strings.TrimSpace(column) == "" || strings.TrimSpace(section) == "" {
+			return fmt.Errorf("invalid board column mapping %q=%q", column, section)
+		}
+	}
+	return nil
+}
+
+// board holds the fields of the board resolved by Validate.
+type board struct {
+	// column and lane are the reference names of the work item fields holding the column and the lane.
+	column, lane string
+}
+
+// resolveBoard reads the board of the pair and checks the mapped columns are on it. It returns nil when
+// the board is not synced.
+func (e *Engine) resolveBoard(ctx context.Context) (*board, error) {
+	cfg := e.cfg.Board
+	if !cfg.enabled() {
+		return nil, nil
+	}
+	team, name := cfg.Team, cfg.Board
+	if team == "" {
+		team = e.cfg.ADOProject + " Team"
+	}
+	if name == "" {
+		name = DefaultBoard
+	}
+	b, err := e.ado.Board(ctx, e.cfg.ADOProject, team, name)
+	if err != nil {
+		return nil, fmt.Errorf("reading board %q of team %q: %w", name, team, err)
+	}
+	columns := make(map[string]bool, len(b.Columns))
+	for _, c := range b.Columns {
+		columns[strings.ToLower(c.Name)] = true
+	}
+	for column := range cfg.Columns {
+		if !columns[strings.ToLower(column)] {
+			return nil, withCode(CodeBoardMismatch, fmt.Errorf("board %q of team %q has no column %q", name, team, column))
+		}
+	}
+	if b.Fields.ColumnField.ReferenceName == "" {
+		return nil, withCode(CodeBoardMismatch, fmt.Errorf("board %q of team %q has no column field", name, team))
+	}
+	return &board{column: b.Fields.ColumnField.ReferenceName, lane: b.Fields.RowField.ReferenceName}, nil
+}
+
+// boardColumn returns the column of item on the board of the pair, empty when it is not on the board.
+func (e *Engine) boardColumn(item ado.WorkItem) string {
+	if e.board == nil {
+		return ""
+	}
+	return strings.TrimSpace(item.String(e.board.column))
+}
+
+// boardLane returns the swimlane of item on the board of the pair, empty in the default lane.
+func (e *Engine) boardLane(item ado.WorkItem) string {
+	if e.board == nil || e.board.lane == "" {
+		return ""
+	}
+	return strings.TrimSpace(item.String(e.board.lane))
+}
+
+// boardSection returns the section of the column of item, or false when columns are not synced to sections
+// or item is not on the board.
+func (e *Engine) boardSection(item ado.WorkItem) (string, bool) {
+	column := e.boardColumn(item)
+	if !e.cfg.Board.sections() || column == "" {
+		return "", false
+	}
+	for c, section := range e.cfg.Board.Columns {
+		if strings.EqualFold(c, column) {
+			return section, true
+		}
+	}
+	return column, true
+}
+
+// boardFields are the custom fields holding the column and the lane of tasks on one Asana project.
+type boardFields struct {
+	column, lane *choiceField
+}
+
+// resolveBoardFields looks up the column and lane custom fields on the Asana project.
+func (e *Engine) resolveBoardFields(ctx context.Context, project string) (boardFields, error) {
+	var f boardFields
+	cfg := e.cfg.Board
+	if cfg.ColumnField == "" && cfg.LaneField == "" {
+		return f, nil
+	}
+	fields, err := e.asana.ProjectCustomFields(ctx, project)
+	if err != nil {
+		return f, fmt.Errorf("listing asana custom fields: %w", err)
+	}
+	resolve := func(what, target string) (*choiceField, error) {
+		if target == "" {
+			return nil, nil
+		}
+		cf, ok := findCustomField(fields, target)
+		if !ok {
+			return nil, withCode(CodeFieldMissing, fmt.Errorf("board %s field %q not found on asana project %s", what, target, project))
+		}
+		r, ok := newChoiceField(cf)
+		if !ok {
+			return nil, fmt.Errorf("board %s field %q is %s, not enum or text", what, target, cf.ResourceSubtype)
+		}
+		return r, nil
+	}
+	if f.column, err = resolve("column", cfg.ColumnField); err != nil {
+		return f, err
+	}
+	if f.lane, err = resolve("lane", cfg.LaneField); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+// boardValues adds the column and the lane of item to values, the custom field values written to task in
+// project, when the fields of task differ. task is nil for new tasks.
+func (e *Engine) boardValues(ctx context.Context, project string, item ado.WorkItem, task *asana.Task, values map[string]interface{}) (map[string]interface{}, error) {
+	f := e.target(project).board
+	var err error
+	if values, err = e.boardValue(ctx, f.column, e.boardColumn(item), task, values); err != nil {
+		return nil, err
+	}
+	return e.boardValue(ctx, f.lane, e.boardLane(item), task, values)
+}
+
+// boardValue adds v to values as the value of the custom field f when the field of task differs. The enum
+// option of a new value is created on first use.
+func (e *Engine) boardValue(ctx context.Context, f *choiceField, v string, task *asana.Task, values map[string]interface{}) (map[string]interface{}, error) {
+	if f == nil {
+		return values, nil
+	}
+	cur := ""
+	if task != nil {
+		for _, cf := range task.CustomFields {
+			if cf.GID == f.gid {
+				cur = customFieldText(cf)
+			}
+		}
+	}
+	if strings.EqualFold(cur, v) {
+		return values, nil
+	}
+	var value interface{}
+	if v != "" {
+		value = v
+		if f.enum {
+			gid, err := e.boardOption(ctx, f, v)
+			if err != nil {
+				return nil, err
+			}
+			value = gid
+		}
+	}
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	values[f.gid] = value
+	return values, nil
+}
+
+// boardOption returns the GID of the enum option of f named name, creating it when the field does not have
+// it yet.
+func (e *Engine) boardOption(ctx context.Context, f *choiceField, name string) (string, error) {
+	e.state.Lock()
+	defer e.state.Unlock()
+	if gid, ok := f.options[strings.ToLower(name)]; ok {
+		return gid, nil
+	}
+	o, err := e.asana.CreateEnumOption(ctx, f.gid, name)
+	if err != nil {
+		return "", fmt.Errorf("creating board option %q: %w", name, err)
+	}
+	logging.From(ctx).Info("created board option", "option", name)
+	f.options[strings.ToLower(name)] = o.GID
+	return o.GID, nil
+}
@@ -0,0 +1,221 @@
+package sync
+
+import (
+	"context"
+	gosync "sync"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/metrics"
+)
+
+// DefaultCacheTTL is how long reference data read from Asana is served from memory.
+const DefaultCacheTTL = 10 * time.Minute
+
+// Kinds of reference data held by the cache of NewCachedAsana.
+const (
+	cacheUsers   = "users"
+	cacheTags    = "tags"
+	cacheFields  = "custom_fields"
+	cacheSection = "sections"
+	cacheMembers = "members"
+)
+
+// NewCachedAsana returns client with its reference data, the users and tags of workspaces and the custom
+// fields, sections and members of projects, read through a cache holding it for ttl. Engines sharing the
+// client share the cache, so their cycles and validations list the data once per ttl. The data a write
+// adds, such as a new tag or section, is listed again on its next read, and a write failing with not
+// found drops every entry of the data it refers to, so deleted users, tags, fields and sections are
+// noticed before ttl passes. A ttl of zero or less returns client.
+func NewCachedAsana(client Asana, ttl time.Duration) Asana {
+	if ttl <= 0 {
+		return client
+	}
+	return &cachedAsana{Asana: client, ttl: ttl, entries: map[cacheKey]cacheEntry{}}
+}
+
+// cachedAsana is an Asana client reading reference data through a cache.
+type cachedAsana struct {
+	Asana
+	ttl time.Duration
+
+	mu      gosync.Mutex
+	entries map[cacheKey]cacheEntry
+	// gen counts invalidations, so lists read while data was invalidated are not cached.
+	gen uint64
+}
+
+type cacheKey struct {
+	kind string
+	// gid is the workspace or project the data belongs to.
+	gid string
+}
+
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// get returns the cached data of key, reading it with load when it is not cached or expired.
+func (c *cachedAsana) get(key cacheKey, load func() (interface{}, error)) (interface{}, error) {
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok && time.Now().Before(e.expires) {
+		c.mu.Unlock()
+		metrics.CacheLookups.WithLabelValues(key.kind, "hit").Inc()
+		return e.value, nil
+	}
+	gen := c.gen
+	c.mu.Unlock()
+	metrics.CacheLookups.WithLabelValues(key.kind, "miss").Inc()
+
+	v, err := load()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case isNotFound(err):
+		delete(c.entries, key)
+	case err == nil && gen == c.gen:
+		c.entries[key] = cacheEntry{value: v, expires: time.Now().Add(c.ttl)}
+	}
+	return v, err
+}
+
+// invalidate drops the cached data of key.
+func (c *cachedAsana) invalidate(key cacheKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+	c.gen++
+}
+
+// notFound drops every cached entry of the kinds when err is a not found error, as the GIDs a write
+// referred to may have been deleted.
+func (c *cachedAsana) notFound(err error, kinds ...string) {
+	if isNotFound(err) {
+		c.invalidateKinds(kinds...)
+	}
+}
+
+// invalidateKinds drops every cached entry of the kinds.
+func (c *cachedAsana) invalidateKinds(kinds ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		for _, k := range kinds {
+			if key.kind == k {
+				delete(c.entries, key)
+			}
+		}
+	}
+	c.gen++
+}
+
+func (c *cachedAsana) WorkspaceUsers(ctx context.Context, workspaceGID string) ([]asana.User, error) {
+	v, err := c.get(cacheKey{cacheUsers, workspaceGID}, func() (interface{}, error) {
+		return c.Asana.WorkspaceUsers(ctx, workspaceGID)
+	})
+	users, _ := v.([]asana.User)
+	return append([]asana.User(nil), users...), err
+}
+
+func (c *cachedAsana) WorkspaceTags(ctx context.Context, workspaceGID string) ([]asana.Tag, error) {
+	v, err := c.get(cacheKey{cacheTags, workspaceGID}, func() (interface{}, error) {
+		return c.Asana.WorkspaceTags(ctx, workspaceGID)
+	})
+	tags, _ := v.([]asana.Tag)
+	return append([]asana.Tag(nil), tags...), err
+}
+
+func (c *cachedAsana) ProjectCustomFields(ctx context.Context, projectGID string) ([]asana.CustomField, error) {
+	v, err := c.get(cacheKey{cacheFields, projectGID}, func() (interface{}, error) {
+		return c.Asana.ProjectCustomFields(ctx, projectGID)
+	})
+	fields, _ := v.([]asana.CustomField)
+	return append([]asana.CustomField(nil), fields...), err
+}
+
+func (c *cachedAsana) ProjectSections(ctx context.Context, projectGID string) ([]asana.Section, error) {
+	v, err := c.get(cacheKey{cacheSection, projectGID}, func() (interface{}, error) {
+		return c.Asana.ProjectSections(ctx, projectGID)
+	})
+	sections, _ := v.([]asana.Section)
+	return append([]asana.Section(nil), sections...), err
+}
+
+func (c *cachedAsana) ProjectMembers(ctx context.Context, projectGID string) ([]asana.User, error) {
+	v, err := c.get(cacheKey{cacheMembers, projectGID}, func() (interface{}, error) {
+		return c.Asana.ProjectMembers(ctx, projectGID)
+	})
+	members, _ := v.([]asana.User)
+	return append([]asana.User(nil), members...), err
+}
+
+func (c *cachedAsana) CreateTag(ctx context.Context, workspaceGID, name string) (*asana.Tag, error) {
+	t, err := c.Asana.CreateTag(ctx, workspaceGID, name)
+	c.invalidate(cacheKey{cacheTags, workspaceGID})
+	return t, err
+}
+
+func (c *cachedAsana) CreateSection(ctx context.Context, projectGID, name string) (*asana.Section, error) {
+	s, err := c.Asana.CreateSection(ctx, projectGID, name)
+	c.invalidate(cacheKey{cacheSection, projectGID})
+	return s, err
+}
+
+// CreateEnumOption drops the custom fields of every project, as the field may be shared by several.
+func (c *cachedAsana) CreateEnumOption(ctx context.Context, fieldGID, name string) (*asana.EnumOption, error) {
+	o, err := c.Asana.CreateEnumOption(ctx, fieldGID, name)
+	c.invalidateKinds(cacheFields)
+	return o, err
+}
+
+func (c *cachedAsana) AddCustomFieldSetting(ctx context.Context, projectGID, fieldGID string) error {
+	err := c.Asana.AddCustomFieldSetting(ctx, projectGID, fieldGID)
+	c.invalidate(cacheKey{cacheFields, projectGID})
+	return err
+}
+
+func (c *cachedAsana) AddProjectMembers(ctx context.Context, projectGID string, users []string) error {
+	err := c.Asana.AddProjectMembers(ctx, projectGID, users)
+	c.notFound(err, cacheUsers)
+	c.invalidate(cacheKey{cacheMembers, projectGID})
+	return err
+}
+
+// CreateTask and UpdateTask refer to users, custom fields and sections, any of which may be the one that
+// was deleted when they fail with not found.
+func (c *cachedAsana) CreateTask(ctx context.Context, req asana.TaskRequest) (*asana.Task, error) {
+	t, err := c.Asana.CreateTask(ctx, req)
+	c.notFound(err, cacheUsers, cacheFields, cacheSection)
+	return t, err
+}
+
+func (c *cachedAsana) UpdateTask(ctx context.Context, gid string, req asana.TaskRequest) (*asana.Task, error) {
+	t, err := c.Asana.UpdateTask(ctx, gid, req)
+	c.notFound(err, cacheUsers, cacheFields, cacheSection)
+	return t, err
+}
+
+func (c *cachedAsana) AddTag(ctx context.Context, taskGID, tagGID string) error {
+	err := c.Asana.AddTag(ctx, taskGID, tagGID)
+	c.notFound(err, cacheTags)
+	return err
+}
+
+func (c *cachedAsana) RemoveTag(ctx context.Context, taskGID, tagGID string) error {
+	err := c.Asana.RemoveTag(ctx, taskGID, tagGID)
+	c.notFound(err, cacheTags)
+	return err
+}
+
+func (c *cachedAsana) AddTaskToSection(ctx context.Context, sectionGID, taskGID string) error {
+	err := c.Asana.AddTaskToSection(ctx, sectionGID, taskGID)
+	c.notFound(err, cacheSection)
+	return err
+}
+
+func (c *cachedAsana) AddFollowers(ctx context.Context, taskGID string, followers []string) error {
+	err := c.Asana.AddFollowers(ctx, taskGID, followers)
+	c.notFound(err, cacheUsers)
+	return err
+}
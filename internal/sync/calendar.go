@@ -0,0 +1,154 @@
+package sync
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CalendarConfig is the time zone and working calendar due dates are translated with.
+type CalendarConfig struct {
+	// TimeZone is the IANA time zone, such as Australia/Sydney, in which the dates of work items fall. ADO
+	// dates are rounded to the nearest UTC midnight when it is empty.
+	TimeZone string `json:"time_zone,omitempty"`
+	// Snap moves due dates read from ADO that fall on a day off to the nearest working day, the earlier one
+	// when two are as near.
+	Snap bool `json:"snap,omitempty"`
+	// WorkingDays are the days of the week worked, as English names or their first three letters. Monday to
+	// Friday are worked when empty.
+	WorkingDays []string `json:"working_days,omitempty"`
+	// Holidays are the dates, as YYYY-MM-DD, that are not worked.
+	Holidays []string `json:"holidays,omitempty"`
+}
+
+// ParseDays parses a comma separated list of working days or holidays.
+func ParseDays(s string) []string {
+	var values []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// ValidateCalendar checks the time zone, working days and holidays of the calendar.
+func (c Config) ValidateCalendar() error {
+	if _, err := c.Calendar.parse(); err != nil {
+		return fmt.Errorf("calendar: %w", err)
+	}
+	return nil
+}
+
+// calendar is a parsed CalendarConfig. A nil calendar rounds dates to UTC midnight and never snaps them.
+type calendar struct {
+	loc *time.Location
+	// working holds the days of the week worked, indexed by time.Weekday, when due dates are snapped.
+	working  [7]bool
+	holidays map[string]bool
+	snap     bool
+}
+
+// parse parses the calendar, returning nil when it is the default one.
+func (c CalendarConfig) parse() (*calendar, error) {
+	if c.TimeZone == "" && !c.Snap && len(c.WorkingDays) == 0 && len(c.Holidays) == 0 {
+		return nil, nil
+	}
+	cal := &calendar{snap: c.Snap, holidays: make(map[string]bool, len(c.Holidays))}
+	if c.TimeZone != "" {
+		loc, err := time.LoadLocation(c.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone %q: %w", c.TimeZone, err)
+		}
+		cal.loc = loc
+	}
+	days := c.WorkingDays
+	if len(days) == 0 {
+		days = []string{"mon", "tue", "wed", "thu", "fri"}
+	}
+	for _, name := range days {
+		d, ok := parseWeekday(name)
+		if !ok {
+			return nil, fmt.Errorf("invalid working day %q", name)
+		}
+		cal.working[d] = true
+	}
+	for _, h := range c.Holidays {
+		if _, err := time.Parse(dateLayout, h); err != nil {
+			return nil, fmt.Errorf("invalid holiday %q, expected YYYY-MM-DD", h)
+		}
+		cal.holidays[h] = true
+	}
+	if cal.snap && !cal.worksAtAll() {
+		return nil, fmt.Errorf("due dates cannot be snapped without working days")
+	}
+	return cal, nil
+}
+
+// parseWeekday parses the English name of a day of the week or its first three letters, ignoring case.
+func parseWeekday(name string) (time.Weekday, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		full := strings.ToLower(d.String())
+		if name == full || (len(name) == 3 && strings.HasPrefix(full, name)) {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// worksAtAll reports whether a day of the week is worked.
+func (cal *calendar) worksAtAll() bool {
+	for _, w := range cal.working {
+		if w {
+			return true
+		}
+	}
+	return false
+}
+
+// day returns the date of t in the time zone of the calendar. Without one, ADO stores the dates picked in
+// its web UI as midnight in the user's time zone, so t is rounded to the nearest UTC midnight.
+func (cal *calendar) day(t time.Time) string {
+	if cal == nil || cal.loc == nil {
+		return t.UTC().Add(12 * time.Hour).Format(dateLayout)
+	}
+	return t.In(cal.loc).Format(dateLayout)
+}
+
+// midnight returns the start of date in the time zone of the calendar, in UTC as ADO stores dates.
+func (cal *calendar) midnight(date string) string {
+	if cal == nil || cal.loc == nil {
+		return date + "T00:00:00Z"
+	}
+	t, err := time.ParseInLocation(dateLayout, date, cal.loc)
+	if err != nil {
+		return date + "T00:00:00Z"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// snapDate returns date, or the nearest working day when it is a day off and due dates are snapped.
+func (cal *calendar) snapDate(date string) string {
+	if cal == nil || !cal.snap || date == "" {
+		return date
+	}
+	t, err := time.Parse(dateLayout, date)
+	if err != nil {
+		return date
+	}
+	// A year of holidays is the furthest a working day can be.
+	for i := 0; i <= 366; i++ {
+		for _, d := range []time.Time{t.AddDate(0, 0, -i), t.AddDate(0, 0, i)} {
+			if cal.works(d) {
+				return d.Format(dateLayout)
+			}
+		}
+	}
+	return date
+}
+
+// works reports whether the date of t is a working day.
+func (cal *calendar) works(t time.Time) bool {
+	return cal.working[t.Weekday()] && !cal.holidays[t.Format(dateLayout)]
+}
@@ -0,0 +1,261 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// CanaryOptions selects the work items a candidate configuration is tried on.
+type CanaryOptions struct {
+	// Percent is the share of the work items selected by the query of the candidate, from 0 to 100, that is
+	// sampled. The sample is stable: an item sampled at a percentage is sampled at every higher one.
+	Percent float64
+	// Items are work items tried whether or not they are sampled.
+	Items []int
+	// Apply syncs the sampled items with the candidate configuration once they were compared.
+	Apply bool
+}
+
+// CanaryReport compares what the current and a candidate configuration of a pair would write for a sample
+// of its work items.
+type CanaryReport struct {
+	Pair string `json:"pair"`
+	// Items lists every sampled work item, ordered by ID.
+	Items []CanaryItem `json:"items"`
+	// Applied is the number of items synced with the candidate configuration.
+	Applied int `json:"applied"`
+}
+
+// CanaryItem is the outcome of a work item under both configurations.
+type CanaryItem struct {
+	ADOID int `json:"ado_id"`
+	// Current and Candidate are the writes the sync of the item would make under each configuration.
+	Current   []Change `json:"current,omitempty"`
+	Candidate []Change `json:"candidate,omitempty"`
+	// Diffs are the writes that differ between the configurations. The item syncs the same way when empty.
+	Diffs []CanaryDiff `json:"diffs,omitempty"`
+	// Error is the reason the item could not be compared, or synced when the canary was applied.
+	Error string `json:"error,omitempty"`
+}
+
+// CanaryDiff is a write, given as its system, action and field, that only one configuration makes or that
+// they make with different values. The value is empty for the configuration that does not make it.
+type CanaryDiff struct {
+	Change    string `json:"change"`
+	Current   string `json:"current,omitempty"`
+	Candidate string `json:"candidate,omitempty"`
+}
+
+// Differing returns the number of items that would sync differently under the candidate configuration.
+func (r *CanaryReport) Differing() int {
+	n := 0
+	for _, it := range r.Items {
+		if len(it.Diffs) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// WriteText writes the differences of the report to w as a table, followed by a summary.
+func (r *CanaryReport) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "WORK ITEM\tCHANGE\tCURRENT\tCANDIDATE")
+	failed := 0
+	for _, it := range r.Items {
+		if it.Error != "" {
+			failed++
+			fmt.Fprintf(tw, "%d\terror\t%s\t\n", it.ADOID, it.Error)
+		}
+		for _, d := range it.Diffs {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", it.ADOID, d.Change, d.Current, d.Candidate)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\n%s: %d of %d sampled work items would sync differently, %d failed, %d synced with the candidate.\n",
+		r.Pair, r.Differing(), len(r.Items), failed, r.Applied)
+	return err
+}
+
+// WriteJSON writes the report to w as JSON.
+func (r *CanaryReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// Canary compares the writes the current configuration of the pair named by candidate and candidate itself
+// would make for a sample of its work items, in dry runs against copies of the store, so nothing is written
+// while comparing. With opts.Apply the sampled items are then synced with candidate, while the rest of the
+// pair keeps its current configuration until it is rolled out.
+func (m *Manager) Canary(ctx context.Context, candidate Config, opts CanaryOptions) (*CanaryReport, error) {
+	e := m.byName[candidate.Name]
+	if e == nil {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownPair, candidate.Name)
+	}
+	if candidate.ADOConnection != e.config().ADOConnection || candidate.AsanaConnection != e.config().AsanaConnection {
+		return nil, fmt.Errorf("sync pair %q changed its connections, which a canary cannot compare", candidate.Name)
+	}
+	current := e.config()
+	current.DryRun, candidate.DryRun = true, true
+	before, err := m.copyEngine(ctx, current)
+	if err != nil {
+		return nil, err
+	}
+	after, err := m.copyEngine(ctx, candidate)
+	if err != nil {
+		return nil, err
+	}
+	defer before.Close()
+	defer after.Close()
+
+	ids, err := after.canarySample(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	rep := &CanaryReport{Pair: candidate.Name}
+	for _, id := range ids {
+		it := CanaryItem{ADOID: id}
+		if it.Current, err = before.plannedChanges(ctx, id); err == nil {
+			it.Candidate, err = after.plannedChanges(ctx, id)
+		}
+		if err != nil {
+			it.Error = err.Error()
+		} else {
+			it.Diffs = diffChanges(it.Current, it.Candidate)
+		}
+		rep.Items = append(rep.Items, it)
+	}
+	if !opts.Apply {
+		return rep, nil
+	}
+
+	candidate.DryRun = false
+	live, err := m.newEngine(candidate)
+	if err != nil {
+		return nil, err
+	}
+	defer live.Close()
+	for i, it := range rep.Items {
+		if it.Error != "" {
+			continue
+		}
+		if _, err := live.SyncItem(ctx, it.ADOID); err != nil {
+			rep.Items[i].Error = err.Error()
+			continue
+		}
+		rep.Applied++
+	}
+	return rep, nil
+}
+
+// copyEngine returns a dry run engine for the pair, syncing against a copy of the store of its connection.
+func (m *Manager) copyEngine(ctx context.Context, cfg Config) (*Engine, error) {
+	c := m.conns[cfg.ADOConnection]
+	st, err := store.Copy(ctx, c.Store)
+	if err != nil {
+		return nil, fmt.Errorf("copying store: %w", err)
+	}
+	return m.newEngineOn(cfg, st)
+}
+
+// canarySample returns the IDs of the work items selected by the query of the pair that opts samples, along
+// with the items it names, in ascending order.
+func (e *Engine) canarySample(ctx context.Context, opts CanaryOptions) ([]int, error) {
+	sampled := map[int]bool{}
+	for _, id := range opts.Items {
+		sampled[id] = true
+	}
+	if opts.Percent > 0 {
+		ids, err := e.selectIDs(e.begin(ctx), e.cfg.ADOProject, e.cfg.WIQL(), 0)
+		if err != nil {
+			return nil, fmt.Errorf("querying work items: %w", err)
+		}
+		for _, id := range ids {
+			if inSample(id, opts.Percent) {
+				sampled[id] = true
+			}
+		}
+	}
+	ids := make([]int, 0, len(sampled))
+	for id := range sampled {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// inSample reports whether the work item with the given ID falls in a sample of percent of the items. Items
+// are hashed into 10000 buckets, so the sample does not depend on the order or number of items.
+func inSample(id int, percent float64) bool {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(strconv.Itoa(id)))
+	return float64(h.Sum32()%10000) < percent*100
+}
+
+// plannedChanges syncs the work item with the given ID in the dry run of e and returns the writes it planned.
+func (e *Engine) plannedChanges(ctx context.Context, adoID int) ([]Change, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ctx = e.begin(ctx)
+	e.plan.mu.Lock()
+	start := len(e.plan.Changes)
+	e.plan.mu.Unlock()
+	if _, err := e.syncOne(ctx, adoID); err != nil {
+		return nil, err
+	}
+	e.plan.mu.Lock()
+	defer e.plan.mu.Unlock()
+	return append([]Change(nil), e.plan.Changes[start:]...), nil
+}
+
+// diffChanges returns the writes of current and candidate that differ, keyed by system, action and field.
+// The GIDs of the tasks written are left out, as those of planned tasks differ between dry runs.
+func diffChanges(current, candidate []Change) []CanaryDiff {
+	a, b := changeValues(current), changeValues(candidate)
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var diffs []CanaryDiff
+	for _, k := range keys {
+		if a[k] != b[k] {
+			diffs = append(diffs, CanaryDiff{Change: k, Current: a[k], Candidate: b[k]})
+		}
+	}
+	return diffs
+}
+
+// changeValues flattens changes into their values keyed by system, action and field. Writes setting no
+// fields, such as deletes, have the value "yes". Later writes of a field replace earlier ones.
+func changeValues(changes []Change) map[string]string {
+	values := map[string]string{}
+	for _, c := range changes {
+		prefix := c.System + " " + string(c.Action)
+		if len(c.Fields) == 0 {
+			values[prefix] = "yes"
+			continue
+		}
+		for f, v := range c.Fields {
+			b, _ := json.Marshal(v)
+			values[prefix+" "+f] = string(b)
+		}
+	}
+	return values
+}
@@ -0,0 +1,227 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// ClosingAction decides what happens to the task of a work item that enters a completed state.
+type ClosingAction string
+
+// Supported closing actions.
+const (
+	// CloseComplete completes the task straight away.
+	CloseComplete ClosingAction = "complete"
+	// CloseDelay completes the task once the grace period has passed.
+	CloseDelay ClosingAction = "delay"
+	// CloseSection moves the task to the closing section and leaves it open, completing it once the grace
+	// period has passed when one is set.
+	CloseSection ClosingAction = "section"
+)
+
+// DefaultClosingSection is the section CloseSection moves tasks to.
+const DefaultClosingSection = "Done"
+
+// closingKey is the store setting holding, as JSON, the work items of a pair whose task is held open until
+// their grace period passes, with the time it does. The pair name is appended.
+const closingKey = "closing:"
+
+// ClosingConfig controls how the tasks of closed work items are completed.
+type ClosingConfig struct {
+	// Action is applied to the tasks of closed items, defaulting to CloseComplete.
+	Action ClosingAction `json:"action,omitempty"`
+	// Grace is how long tasks stay open after their item closed, as a duration such as "36h" or a number
+	// of days such as "3d".
+	Grace string `json:"grace,omitempty"`
+	// Section is the section CloseSection moves tasks to, defaulting to DefaultClosingSection.
+	Section string `json:"section,omitempty"`
+}
+
+// ParseClosingAction parses s as a ClosingAction. An empty string returns CloseComplete.
+func ParseClosingAction(s string) (ClosingAction, error) {
+	switch a := ClosingAction(strings.ToLower(strings.TrimSpace(s))); a {
+	case "":
+		return CloseComplete, nil
+	case CloseComplete, CloseDelay, CloseSection:
+		return a, nil
+	default:
+		return "", fmt.Errorf("unknown closing action %q, expected complete, delay or section", s)
+	}
+}
+
+// ParseGrace parses a grace period given as a duration, such as "36h", or a number of days, such as "3d".
+func ParseGrace(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid grace period %q", s)
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid grace period %q", s)
+	}
+	return d, nil
+}
+
+// ValidateClosing checks the closing action and that delayed closing has a grace period.
+func (c Config) ValidateClosing() error {
+	action, err := ParseClosingAction(string(c.Closing.Action))
+	if err != nil {
+		return err
+	}
+	grace, err := ParseGrace(c.Closing.Grace)
+	switch {
+	case err != nil:
+		return err
+	case action == CloseDelay && grace == 0:
+		return fmt.Errorf("the delay closing action needs a grace period")
+	case action == CloseComplete && grace > 0:
+		return fmt.Errorf("a closing grace period needs the delay or section closing action")
+	}
+	return nil
+}
+
+// holds reports whether the tasks of closed items may be held open.
+func (c ClosingConfig) holds() bool {
+	return c.Action == CloseDelay || c.Action == CloseSection
+}
+
+// grace returns the grace period, which ValidateClosing checked.
+func (c ClosingConfig) grace() time.Duration {
+	d, _ := ParseGrace(c.Grace)
+	return d
+}
+
+// section returns the section CloseSection moves tasks to.
+func (c ClosingConfig) section() string {
+	if c.Section == "" {
+		return DefaultClosingSection
+	}
+	return c.Section
+}
+
+// closingSection returns the section the task of item moves to because the item closed.
+func (c Config) closingSection(item ado.WorkItem) (string, bool) {
+	if c.Closing.Action != CloseSection || !c.taskState(item.State()).completed {
+		return "", false
+	}
+	return c.Closing.section(), true
+}
+
+// pendingCloses holds the work items whose task is held open, keyed by ID, with the time their grace period
+// passes. It is loaded from the store on first use.
+type pendingCloses struct {
+	mu     gosync.Mutex
+	loaded bool
+	due    map[int]time.Time
+}
+
+// closingState returns want, the state the task of item shows, with the task held open while the item is
+// in its grace period, or for good when closed items move to a section without one. Tasks that are already
+// completed are left completed. task is nil for new tasks.
+func (e *Engine) closingState(ctx context.Context, item ado.WorkItem, task *asana.Task, want taskState) (taskState, error) {
+	c := e.cfg.Closing
+	if !want.completed || !c.holds() || task != nil && task.Completed {
+		return want, e.holdOpen(ctx, item.ID, time.Time{})
+	}
+	grace := c.grace()
+	if grace == 0 {
+		want.completed = false
+		return want, e.holdOpen(ctx, item.ID, time.Time{})
+	}
+	until := item.StateChangeDate().Add(grace)
+	if !time.Now().Before(until) {
+		return want, e.holdOpen(ctx, item.ID, time.Time{})
+	}
+	want.completed = false
+	return want, e.holdOpen(ctx, item.ID, until)
+}
+
+// holdOpen records that the task of the work item id is held open until the given time, or that it is not
+// held when until is zero. The store is only written when that changes.
+func (e *Engine) holdOpen(ctx context.Context, id int, until time.Time) error {
+	p := &e.closes
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := e.loadCloses(ctx); err != nil {
+		return err
+	}
+	switch prev, ok := p.due[id]; {
+	case until.IsZero() && !ok:
+		return nil
+	case until.IsZero():
+		delete(p.due, id)
+	case ok && prev.Equal(until):
+		return nil
+	default:
+		if !ok {
+			logging.From(ctx).Info("holding asana task open after its work item closed", "until", until.Format(time.RFC3339))
+		}
+		p.due[id] = until.UTC()
+	}
+	b, err := json.Marshal(p.due)
+	if err != nil {
+		return err
+	}
+	if err := e.store.SetSetting(ctx, closingKey+e.cfg.Name, string(b)); err != nil {
+		return fmt.Errorf("saving pending closes: %w", err)
+	}
+	return nil
+}
+
+// loadCloses loads the pending closes of the pair from the store once. The caller holds their lock.
+func (e *Engine) loadCloses(ctx context.Context) error {
+	p := &e.closes
+	if p.loaded {
+		return nil
+	}
+	p.due = map[int]time.Time{}
+	switch v, err := e.store.Setting(ctx, closingKey+e.cfg.Name); {
+	case errors.Is(err, store.ErrNotFound) || err == nil && v == "":
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal([]byte(v), &p.due); err != nil {
+			logging.From(ctx).Warn("ignoring invalid pending closes", "setting", closingKey+e.cfg.Name, "error", err)
+			p.due = map[int]time.Time{}
+		}
+	}
+	p.loaded = true
+	return nil
+}
+
+// dueCloses returns the work items whose grace period has passed, so incremental cycles complete their task
+// even though they did not change.
+func (e *Engine) dueCloses(ctx context.Context) ([]int, error) {
+	p := &e.closes
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := e.loadCloses(ctx); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var ids []int
+	for id, until := range p.due {
+		if !now.Before(until) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,214 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/metrics"
+	"github.com/danstis/ado-asana-sync/internal/ratelimit"
+)
+
+// Class is the category of a sync error that alerts are routed by.
+type Class string
+
+// Classes of sync errors.
+const (
+	// ClassAuth errors are credentials ADO or Asana rejected.
+	ClassAuth Class = "auth"
+	// ClassRateLimit errors are requests refused for exceeding a rate limit or call budget.
+	ClassRateLimit Class = "rate_limit"
+	// ClassConfig errors are configuration or mappings referring to something that is not there.
+	ClassConfig Class = "mapping_config"
+	// ClassValidation errors are values that could not be converted, transformed or written.
+	ClassValidation Class = "data_validation"
+	// ClassOutage errors are ADO or Asana failing or out of reach.
+	ClassOutage Class = "provider_outage"
+	// ClassInternal errors are those of no other class.
+	ClassInternal Class = "internal"
+)
+
+// Code identifies the kind of a sync error. Codes do not change between releases, so alert rules and
+// runbooks can refer to them.
+type Code string
+
+// Codes of sync errors.
+const (
+	CodeADOAuth             Code = "ADO_AUTH"
+	CodeAsanaAuth           Code = "ASANA_AUTH"
+	CodeADORateLimit        Code = "ADO_RATE_LIMIT"
+	CodeAsanaRateLimit      Code = "ASANA_RATE_LIMIT"
+	CodeCallBudget          Code = "CALL_BUDGET"
+	CodeNotFound            Code = "NOT_FOUND"
+	CodeFieldMissing        Code = "ASANA_FIELD_MISSING"
+	CodeADOFieldMissing     Code = "ADO_FIELD_MISSING"
+	CodeFieldTypeMismatch   Code = "FIELD_TYPE_MISMATCH"
+	CodeBoardMismatch       Code = "BOARD_MISMATCH"
+	CodeRejected            Code = "REJECTED"
+	CodeInvalidValue        Code = "INVALID_VALUE"
+	CodeTransformFailed     Code = "TRANSFORM_FAILED"
+	CodeStale               Code = "STALE"
+	CodeADOOutage           Code = "ADO_OUTAGE"
+	CodeAsanaOutage         Code = "ASANA_OUTAGE"
+	CodeProviderUnavailable Code = "PROVIDER_UNAVAILABLE"
+	CodeNetwork             Code = "NETWORK"
+	CodeUnknown             Code = "UNKNOWN"
+)
+
+// codeInfo is the class of a code and what fixes its errors.
+type codeInfo struct {
+	class       Class
+	remediation string
+}
+
+var codes = map[Code]codeInfo{
+	CodeADOAuth:             {ClassAuth, "check that ADO_PAT or the Entra ID credentials are valid, not expired, and may read and write the work items of the project"},
+	CodeAsanaAuth:           {ClassAuth, "check that ASANA_TOKEN or the OAuth grant is valid and that its user is a member of the workspace and projects of the pair"},
+	CodeADORateLimit:        {ClassRateLimit, "lower SYNC_WORKERS or RATE_LIMIT_CONCURRENCY, or lengthen SYNC_INTERVAL; rate limited work items are retried"},
+	CodeAsanaRateLimit:      {ClassRateLimit, "lower SYNC_WORKERS or RATE_LIMIT_CONCURRENCY, or lengthen SYNC_INTERVAL; rate limited work items are retried"},
+	CodeCallBudget:          {ClassRateLimit, "raise CALL_BUDGET, ADO_CALL_BUDGET, ASANA_CALL_BUDGET or SYNC_CALL_BUDGET, or lengthen SYNC_INTERVAL; the remaining work items sync in the next cycle"},
+	CodeNotFound:            {ClassConfig, "a project, section, field, user or work item the configuration or a mapping refers to was deleted or is not shared with the credentials; fix the configuration or its access"},
+	CodeFieldMissing:        {ClassConfig, "add the custom field or enum option to the Asana project, fix the field mapping, or set SYNC_MANAGE_SCHEMA to create it"},
+	CodeADOFieldMissing:     {ClassConfig, "use the reference or display name of a field of the project, as listed by discover ado-fields"},
+	CodeFieldTypeMismatch:   {ClassConfig, "give the field mapping a type the ado field's values convert to, or leave the type out to take that of the ado field"},
+	CodeBoardMismatch:       {ClassConfig, "map the columns of the board as they are named on the team's board now"},
+	CodeRejected:            {ClassValidation, "ADO or Asana refused the values written; check that field mappings and transforms produce values the field allows, such as a known state or iteration path"},
+	CodeInvalidValue:        {ClassValidation, "a work item field holds a value its custom field cannot take; fix the value or the type of the field mapping"},
+	CodeTransformFailed:     {ClassValidation, "a transform failed on the item; check the transform and its log output"},
+	CodeStale:               {ClassValidation, "the item changed while it was syncing and is retried; nothing needs fixing unless it keeps happening"},
+	CodeADOOutage:           {ClassOutage, "Azure DevOps is failing; check its status page, the sync resumes by itself once it recovers"},
+	CodeAsanaOutage:         {ClassOutage, "Asana is failing; check its status page, the sync resumes by itself once it recovers"},
+	CodeProviderUnavailable: {ClassOutage, "requests are held back while ADO or Asana recovers; the sync resumes by itself, check the provider's status page if it lasts"},
+	CodeNetwork:             {ClassOutage, "ADO or Asana could not be reached; check DNS, firewalls, PROXY_URL and TLS_CA_FILE"},
+	CodeUnknown:             {ClassInternal, "check the log of the error, and report it when it persists"},
+}
+
+// Class returns the class of the errors of c.
+func (c Code) Class() Class {
+	return codes[c].class
+}
+
+// Remediation suggests how to fix the errors of c.
+func (c Code) Remediation() string {
+	return codes[c].remediation
+}
+
+// codedError gives an error the engine detected itself the code it is reported with.
+type codedError struct {
+	code Code
+	err  error
+}
+
+func (e *codedError) Error() string { return e.err.Error() }
+func (e *codedError) Unwrap() error { return e.err }
+
+// withCode returns err reported with code, or nil when err is nil.
+func withCode(code Code, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &codedError{code: code, err: err}
+}
+
+// Classify returns the code of err. It is empty for nil and for errors that are not failures: cancelled
+// cycles and paused pairs.
+func Classify(err error) Code {
+	var ae *asana.Error
+	var ce *codedError
+	switch errorCategory(err) {
+	case "canceled", "paused":
+		return ""
+	case "auth":
+		if errors.As(err, &ae) {
+			return CodeAsanaAuth
+		}
+		return CodeADOAuth
+	case "rate_limit":
+		if errors.As(err, &ae) {
+			return CodeAsanaRateLimit
+		}
+		return CodeADORateLimit
+	case "budget":
+		return CodeCallBudget
+	case "not_found":
+		return CodeNotFound
+	case "server":
+		if errors.As(err, &ae) {
+			return CodeAsanaOutage
+		}
+		return CodeADOOutage
+	case "unavailable":
+		return CodeProviderUnavailable
+	case "network":
+		return CodeNetwork
+	case "stale":
+		return CodeStale
+	case "request":
+		return CodeRejected
+	}
+	if errors.As(err, &ce) {
+		return ce.code
+	}
+	if err == nil {
+		return ""
+	}
+	return CodeUnknown
+}
+
+// ErrorAttrs returns err as log attributes, with its class, code and remediation.
+func ErrorAttrs(err error) []interface{} {
+	a := []interface{}{"error", err}
+	if code := Classify(err); code != "" {
+		a = append(a, "error_class", code.Class(), "error_code", code, "remediation", code.Remediation())
+	}
+	return a
+}
+
+// countError counts err in the error metrics of the pair.
+func (e *Engine) countError(err error) {
+	code := Classify(err)
+	metrics.Errors.WithLabelValues(e.cfg.Name, errorCategory(err), string(code.Class()), string(code)).Inc()
+}
+
+// errorCategory classifies err for the error metrics.
+func errorCategory(err error) string {
+	var status int
+	var ae *asana.Error
+	var de *ado.Error
+	var ue *url.Error
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	case errors.Is(err, errStale):
+		return "stale"
+	case errors.Is(err, ratelimit.ErrBudgetExhausted):
+		return "budget"
+	case errors.Is(err, ErrPaused):
+		return "paused"
+	case isOpen(err):
+		return "unavailable"
+	case errors.As(err, &ae):
+		status = ae.StatusCode
+	case errors.As(err, &de):
+		status = de.StatusCode
+	case errors.As(err, &ue):
+		return "network"
+	default:
+		return "other"
+	}
+	switch {
+	case status == http.StatusUnauthorized, status == http.StatusForbidden:
+		return "auth"
+	case status == http.StatusTooManyRequests:
+		return "rate_limit"
+	case status == http.StatusNotFound:
+		return "not_found"
+	case status >= 500:
+		return "server"
+	default:
+		return "request"
+	}
+}
@@ -0,0 +1,109 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// syncComments mirrors discussion comments between item and task according to the configured comment direction.
+// Comments already recorded in the store, including those written by the sync itself, are never mirrored twice.
+func (e *Engine) syncComments(ctx context.Context, item ado.WorkItem, task *asana.Task, ch changes) error {
+	dir := e.cfg.CommentDirection
+	if dir == "" {
+		return nil
+	}
+
+	// Asana does not bump modified_at for new comments, so stories are always checked,
+	// whereas ADO comments are only fetched when the work item changed.
+	if dir != AsanaToADO && ch.ado {
+		comments, err := e.ado.Comments(ctx, e.cfg.ADOProject, item.ID)
+		if err != nil {
+			return fmt.Errorf("listing work item comments: %w", err)
+		}
+		for _, c := range comments {
+			if _, err := e.store.CommentByADO(ctx, item.ID, c.ID); err == nil {
+				continue
+			} else if !errors.Is(err, store.ErrNotFound) {
+				return err
+			}
+			story, err := e.asana.AddComment(ctx, task.GID, adoCommentText(c))
+			if err != nil {
+				return fmt.Errorf("mirroring comment %d: %w", c.ID, err)
+			}
+			if err := e.store.PutComment(ctx, store.CommentMapping{ADOID: item.ID, ADOCommentID: c.ID, AsanaStoryGID: story.GID, Origin: store.OriginADO}); err != nil {
+				return err
+			}
+			logging.From(ctx).Info("mirrored comment to asana", "comment_id", c.ID)
+		}
+	}
+
+	if dir != ADOToAsana {
+		stories, err := e.asana.TaskComments(ctx, task.GID)
+		if err != nil {
+			return fmt.Errorf("listing asana comments: %w", err)
+		}
+		for _, s := range stories {
+			if strings.HasPrefix(s.Text, overflowComment) {
+				// The rest of a description cut from the notes is already in the work item.
+				continue
+			}
+			if _, err := e.store.CommentByAsana(ctx, s.GID); err == nil {
+				continue
+			} else if !errors.Is(err, store.ErrNotFound) {
+				return err
+			}
+			c, err := e.ado.AddComment(ctx, e.cfg.ADOProject, item.ID, asanaCommentHTML(s))
+			if err != nil {
+				return fmt.Errorf("mirroring asana comment %s: %w", s.GID, err)
+			}
+			if err := e.store.PutComment(ctx, store.CommentMapping{ADOID: item.ID, ADOCommentID: c.ID, AsanaStoryGID: s.GID, Origin: store.OriginAsana}); err != nil {
+				return err
+			}
+			logging.From(ctx).Info("mirrored asana comment to ado", "story_gid", s.GID)
+		}
+	}
+	return nil
+}
+
+// adoCommentText renders a work item comment as an attributed plain text Asana comment.
+func adoCommentText(c ado.Comment) string {
+	author := c.CreatedBy.DisplayName
+	if author == "" {
+		author = c.CreatedBy.UniqueName
+	}
+	return fmt.Sprintf("%s commented in Azure DevOps:\n\n%s", author, plainText(c.Text))
+}
+
+// asanaCommentHTML renders an Asana comment as an attributed HTML work item comment.
+func asanaCommentHTML(s asana.Story) string {
+	author := "Someone"
+	if s.CreatedBy != nil && s.CreatedBy.Name != "" {
+		author = s.CreatedBy.Name
+	}
+	text := strings.ReplaceAll(html.EscapeString(s.Text), "\n", "<br>")
+	return fmt.Sprintf("<b>%s</b> commented in Asana:<br><br>%s", html.EscapeString(author), text)
+}
+
+var (
+	breakTags = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|li|h[1-6])>`)
+	anyTag    = regexp.MustCompile(`<[^>]*>`)
+	blankRuns = regexp.MustCompile(`\n{3,}`)
+)
+
+// plainText reduces an HTML fragment to plain text, keeping line breaks.
+func plainText(s string) string {
+	s = breakTags.ReplaceAllString(s, "\n")
+	s = anyTag.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+	s = blankRuns.ReplaceAllString(s, "\n\n")
+	return strings.TrimSpace(s)
+}
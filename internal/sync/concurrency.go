@@ -0,0 +1,132 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+)
+
+// errStale is returned by syncItem when the work item or its task changed after they were read, so writing
+// them would overwrite someone's edit.
+var errStale = errors.New("changed by someone else while syncing")
+
+// staleAttempts is how often an item is read and synced again when its writes find it changed.
+const staleAttempts = 3
+
+// syncFresh syncs item with task, reading both again and syncing once more when a write finds them changed
+// since they were read. The second attempt sees the edits, so they go through the conflict resolution
+// instead of being overwritten.
+func (e *Engine) syncFresh(ctx context.Context, item ado.WorkItem, task *asana.Task, user *asana.User, rep *Report) error {
+	for attempt := 1; ; attempt++ {
+		err := e.syncItem(ctx, item, task, user, rep)
+		if !errors.Is(err, errStale) || attempt == staleAttempts {
+			return err
+		}
+		logging.From(ctx).Info("work item or task changed while syncing, reading them again", "attempt", attempt)
+		items, err := e.ado.GetWorkItems(ctx, []int{item.ID})
+		if err != nil {
+			return fmt.Errorf("fetching work item: %w", err)
+		}
+		if len(items) == 0 {
+			return nil
+		}
+		if task != nil {
+			if task, err = e.asana.GetTask(ctx, task.GID); err != nil {
+				return fmt.Errorf("fetching asana task: %w", err)
+			}
+		}
+		if item, task, err = e.transform(ctx, items[0], task); err != nil {
+			return err
+		}
+		user, _ = e.users.match(item.AssignedTo())
+	}
+}
+
+// updateWorkItem applies ops to item unless it changed since it was read, returning errStale when it did.
+func (e *Engine) updateWorkItem(ctx context.Context, item ado.WorkItem, ops []ado.PatchOperation) (*ado.WorkItem, error) {
+	updated, err := e.ado.UpdateWorkItem(ctx, item.ID, append([]ado.PatchOperation{ado.TestRev(item.Rev)}, ops...))
+	if ado.IsConflict(err) {
+		return nil, fmt.Errorf("updating work item at revision %d: %w", item.Rev, errStale)
+	}
+	return updated, err
+}
+
+// checkFresh reads task again before req is written to it and returns errStale when a field req writes
+// changed since task was read. Asana has no conditional updates, so the fields are compared instead.
+func (e *Engine) checkFresh(ctx context.Context, task *asana.Task, req asana.TaskRequest) error {
+	fresh, err := e.asana.GetTask(ctx, task.GID)
+	if err != nil {
+		return fmt.Errorf("fetching asana task: %w", err)
+	}
+	// task was transformed, so fresh is too before they are compared.
+	if fresh, err = e.transformTask(ctx, fresh); err != nil {
+		return err
+	}
+	if fields := staleFields(task, fresh, req); len(fields) > 0 {
+		logging.From(ctx).Debug("asana task changed since it was read", "fields", fields)
+		return fmt.Errorf("updating asana task: %w", errStale)
+	}
+	return nil
+}
+
+// staleFields returns the fields written by req that differ between task, as read, and fresh, as it is now.
+func staleFields(task, fresh *asana.Task, req asana.TaskRequest) []string {
+	var stale []string
+	if req.Name != nil && fresh.Name != task.Name {
+		stale = append(stale, "name")
+	}
+	if (req.Notes != nil || req.HTMLNotes != nil) && fresh.Notes != task.Notes {
+		stale = append(stale, "notes")
+	}
+	if req.Completed != nil && fresh.Completed != task.Completed {
+		stale = append(stale, "completed")
+	}
+	if req.Assignee != nil && userGID(fresh.Assignee) != userGID(task.Assignee) {
+		stale = append(stale, "assignee")
+	}
+	if req.DueOn != nil && fresh.DueOn != task.DueOn {
+		stale = append(stale, "due_on")
+	}
+	if req.ApprovalStatus != nil && fresh.ApprovalStatus != task.ApprovalStatus {
+		stale = append(stale, "approval_status")
+	}
+	for gid := range req.CustomFields {
+		if customValue(fresh.CustomFields, gid) != customValue(task.CustomFields, gid) {
+			stale = append(stale, "custom_fields")
+			break
+		}
+	}
+	return stale
+}
+
+// userGID returns the GID of u, or an empty string when it is nil.
+func userGID(u *asana.User) string {
+	if u == nil {
+		return ""
+	}
+	return u.GID
+}
+
+// customValue renders the value of the custom field gid among fields for comparison.
+func customValue(fields []asana.CustomField, gid string) string {
+	for _, cf := range fields {
+		if cf.GID != gid {
+			continue
+		}
+		switch {
+		case cf.EnumValue != nil:
+			return "enum:" + cf.EnumValue.GID
+		case cf.NumberValue != nil:
+			return fmt.Sprintf("number:%v", *cf.NumberValue)
+		case cf.DateValue != nil:
+			return "date:" + cf.DateValue.Date
+		case cf.TextValue != nil:
+			return "text:" + *cf.TextValue
+		}
+	}
+	return ""
+}
@@ -0,0 +1,118 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// ConflictStrategy decides which side wins when a bidirectional field changed on both sides since the last sync.
+type ConflictStrategy string
+
+// Supported conflict strategies.
+const (
+	ADOWins     ConflictStrategy = "ado-wins"
+	AsanaWins   ConflictStrategy = "asana-wins"
+	NewestWins  ConflictStrategy = "newest-wins"
+	ManualQueue ConflictStrategy = "manual-queue"
+)
+
+// ParseConflictStrategy parses s as a ConflictStrategy. An empty string returns ADOWins.
+func ParseConflictStrategy(s string) (ConflictStrategy, error) {
+	switch cs := ConflictStrategy(strings.ToLower(strings.TrimSpace(s))); cs {
+	case "":
+		return ADOWins, nil
+	case ADOWins, AsanaWins, NewestWins, ManualQueue:
+		return cs, nil
+	default:
+		return "", fmt.Errorf("unknown conflict strategy %q", s)
+	}
+}
+
+// changes describes which sides of a mapping changed since the last sync.
+type changes struct {
+	ado, asana bool
+}
+
+// pick returns the side whose value wins for field f. ok is false when the field must be left untouched,
+// either because a conflict for it is already queued or because it was just queued for manual resolution.
+func (e *Engine) pick(ctx context.Context, f Field, item ado.WorkItem, task *asana.Task, ch changes, adoValue, asanaValue string, rep *Report) (s side, ok bool) {
+	return e.pickIn(ctx, f, e.cfg.DirectionFor(f), item, task, ch, adoValue, asanaValue, rep)
+}
+
+// pickIn is pick for a field syncing in direction d rather than the one configured for f.
+func (e *Engine) pickIn(ctx context.Context, f Field, d Direction, item ado.WorkItem, task *asana.Task, ch changes, adoValue, asanaValue string, rep *Report) (s side, ok bool) {
+	if s, ok := resolved(ctx, item.ID, f); ok {
+		// A conflict the operator resolved leaves the queue as the winning value is written.
+		if err := e.store.DeleteConflict(ctx, item.ID, string(f)); err != nil && !errors.Is(err, store.ErrNotFound) {
+			logging.From(ctx).Error("failed to clear resolved conflict", "field", f, "error", err)
+			return sideADO, false
+		}
+		logging.From(ctx).Info("conflict resolved by the operator", "field", f)
+		return s, true
+	}
+	switch _, err := e.store.Conflict(ctx, item.ID, string(f)); {
+	case err == nil:
+		return sideADO, false
+	case !errors.Is(err, store.ErrNotFound):
+		logging.From(ctx).Error("failed to look up conflict", "field", f, "error", err)
+		return sideADO, false
+	}
+
+	s, conflict := source(d, ch.ado, ch.asana)
+	if !conflict {
+		return s, true
+	}
+
+	switch e.cfg.ConflictStrategy {
+	case AsanaWins:
+		return sideAsana, true
+	case NewestWins:
+		if task.ModifiedAt.After(item.ChangedDate()) {
+			return sideAsana, true
+		}
+		return sideADO, true
+	case ManualQueue:
+		c := store.Conflict{
+			ADOID:         item.ID,
+			AsanaGID:      task.GID,
+			Field:         string(f),
+			ADOValue:      adoValue,
+			AsanaValue:    asanaValue,
+			ADOChanged:    item.ChangedDate(),
+			AsanaModified: task.ModifiedAt,
+			DetectedAt:    time.Now().UTC(),
+		}
+		if err := e.store.PutConflict(ctx, c); err != nil {
+			logging.From(ctx).Error("failed to queue conflict", "field", f, "error", err)
+		} else {
+			logging.From(ctx).Warn("queued conflict", "field", f, "ado_value", adoValue, "asana_value", asanaValue)
+			rep.conflictQueued()
+		}
+		return sideADO, false
+	default:
+		return sideADO, true
+	}
+}
+
+// clearConflict drops a queued conflict for field f once both sides agree again.
+func (e *Engine) clearConflict(ctx context.Context, adoID int, f Field) {
+	if _, err := e.store.Conflict(ctx, adoID, string(f)); err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			logging.From(ctx).Error("failed to look up conflict", "field", f, "error", err)
+		}
+		return
+	}
+	if err := e.store.DeleteConflict(ctx, adoID, string(f)); err != nil {
+		logging.From(ctx).Error("failed to clear resolved conflict", "field", f, "error", err)
+		return
+	}
+	logging.From(ctx).Info("conflict resolved: both sides now agree", "field", f)
+}
@@ -0,0 +1,290 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/store"
+	"github.com/danstis/ado-asana-sync/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultDuplicateScore is the title similarity from which FindDuplicates proposes a task when none is given.
+const DefaultDuplicateScore = 0.8
+
+// Reasons a task is proposed as the duplicate of a work item.
+const (
+	// DuplicateByID is a task whose name or notes refer to the work item by ID or link.
+	DuplicateByID = "id"
+	// DuplicateByTitle is a task whose name is like the title of the work item.
+	DuplicateByTitle = "title"
+)
+
+// Duplicate is an unmapped Asana task proposed as the task of an unmapped work item, such as one made by
+// hand before the pair synced.
+type Duplicate struct {
+	ADOID    int
+	Title    string
+	AsanaGID string
+	TaskName string
+	// Score is how alike the title and the task name are, from 0 to 1. Tasks matched by ID score 1.
+	Score float64
+	// Reason is DuplicateByID or DuplicateByTitle.
+	Reason string
+}
+
+// itemRef finds references to work items in task names and notes: AB#123, #123 or a work item link.
+var itemRef = regexp.MustCompile(`(?i)(?:\bAB)?#(\d+)\b|/_workitems/edit/(\d+)\b`)
+
+// FindDuplicates proposes a task for every work item the pair selects that has no task yet, among the
+// tasks of the projects of the pair that belong to no work item. A task is proposed when it refers to the
+// work item by ID, or when its name is at least min alike the title; min defaults to DefaultDuplicateScore.
+// Every work item and task is proposed once, for its best match. Nothing is written.
+func (e *Engine) FindDuplicates(ctx context.Context, min float64) ([]Duplicate, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ctx = e.begin(ctx)
+	ctx, span := tracing.Tracer().Start(ctx, "sync.dedupe", trace.WithAttributes(attribute.String("sync.pair", e.cfg.Name)))
+	dups, err := e.findDuplicates(ctx, min)
+	tracing.End(span, err)
+	return dups, err
+}
+
+func (e *Engine) findDuplicates(ctx context.Context, min float64) ([]Duplicate, error) {
+	if min <= 0 {
+		min = DefaultDuplicateScore
+	}
+	if err := e.prepare(ctx); err != nil {
+		return nil, err
+	}
+	ids, err := e.selectIDs(ctx, e.cfg.ADOProject, e.cfg.WIQL(), 0)
+	if err != nil {
+		return nil, fmt.Errorf("querying work items: %w", err)
+	}
+	idx, err := e.indexTasks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var unmapped []int
+	for _, id := range ids {
+		if _, err := e.store.Get(ctx, id); err == nil {
+			continue
+		} else if !errors.Is(err, store.ErrNotFound) {
+			return nil, err
+		}
+		if idx.byADOID[id] == nil {
+			unmapped = append(unmapped, id)
+		}
+	}
+	var items []ado.WorkItem
+	for start := 0; start < len(unmapped); start += pageSize {
+		end := start + pageSize
+		if end > len(unmapped) {
+			end = len(unmapped)
+		}
+		page, err := e.ado.GetWorkItems(ctx, unmapped[start:end])
+		if err != nil {
+			return nil, fmt.Errorf("fetching work items: %w", err)
+		}
+		items = append(items, page...)
+	}
+	var tasks []*asana.Task
+	for _, t := range idx.byGID {
+		if _, ok := parseTaskID(t.Name); ok {
+			continue
+		}
+		if _, ok := e.cfg.anchorOf(t); ok {
+			continue
+		}
+		if _, err := e.store.ByAsanaGID(ctx, t.GID); err == nil {
+			continue
+		} else if !errors.Is(err, store.ErrNotFound) {
+			return nil, err
+		}
+		tasks = append(tasks, t)
+	}
+
+	var cands []Duplicate
+	for _, t := range tasks {
+		refs := referencedItems(t.Name + "\n" + t.Notes)
+		for _, item := range items {
+			d := Duplicate{ADOID: item.ID, Title: item.Title(), AsanaGID: t.GID, TaskName: t.Name}
+			if refs[item.ID] {
+				d.Score, d.Reason = 1, DuplicateByID
+			} else if d.Score = similarity(d.Title, t.Name); d.Score >= min {
+				d.Reason = DuplicateByTitle
+			} else {
+				continue
+			}
+			cands = append(cands, d)
+		}
+	}
+	// The best matches are taken first, so each work item and task goes to its best remaining match.
+	sort.Slice(cands, func(i, j int) bool {
+		a, b := cands[i], cands[j]
+		switch {
+		case a.Score != b.Score:
+			return a.Score > b.Score
+		case a.Reason != b.Reason:
+			return a.Reason == DuplicateByID
+		case a.ADOID != b.ADOID:
+			return a.ADOID < b.ADOID
+		}
+		return a.AsanaGID < b.AsanaGID
+	})
+	var dups []Duplicate
+	takenItems, takenTasks := map[int]bool{}, map[string]bool{}
+	for _, d := range cands {
+		if takenItems[d.ADOID] || takenTasks[d.AsanaGID] {
+			continue
+		}
+		takenItems[d.ADOID], takenTasks[d.AsanaGID] = true, true
+		dups = append(dups, d)
+	}
+	sort.Slice(dups, func(i, j int) bool { return dups[i].ADOID < dups[j].ADOID })
+	logging.From(ctx).Info("found duplicate tasks", "unmapped_items", len(items), "unmapped_tasks", len(tasks), "proposed", len(dups))
+	return dups, nil
+}
+
+// referencedItems returns the IDs of the work items s refers to.
+func referencedItems(s string) map[int]bool {
+	refs := map[int]bool{}
+	for _, m := range itemRef.FindAllStringSubmatch(s, -1) {
+		for _, g := range m[1:] {
+			if id, err := strconv.Atoi(g); err == nil {
+				refs[id] = true
+			}
+		}
+	}
+	return refs
+}
+
+// similarity returns the Dice coefficient of the letter pairs of the words of a and b, ignoring case and
+// punctuation: 1 for the same words and 0 for nothing in common.
+func similarity(a, b string) float64 {
+	pa, pb := letterPairs(a), letterPairs(b)
+	if len(pa) == 0 || len(pb) == 0 {
+		return 0
+	}
+	common := 0
+	for p, n := range pa {
+		if m := pb[p]; m < n {
+			common += m
+		} else {
+			common += n
+		}
+	}
+	return 2 * float64(common) / float64(pairCount(pa)+pairCount(pb))
+}
+
+// letterPairs returns how often each pair of adjacent letters occurs within the words of s.
+func letterPairs(s string) map[string]int {
+	pairs := map[string]int{}
+	for _, w := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		r := []rune(w)
+		if len(r) == 1 {
+			pairs[w]++
+		}
+		for i := 0; i+1 < len(r); i++ {
+			pairs[string(r[i:i+2])]++
+		}
+	}
+	return pairs
+}
+
+func pairCount(pairs map[string]int) int {
+	n := 0
+	for _, c := range pairs {
+		n += c
+	}
+	return n
+}
+
+// Adopt makes the task of every duplicate the task of its work item instead of creating a new one, then
+// syncs them as it would any mapped pair. Duplicates whose work item or task is mapped by now fail, as do
+// those the pair no longer selects.
+func (e *Engine) Adopt(ctx context.Context, dups []Duplicate) (*Report, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ctx = e.begin(ctx)
+	ctx, span := tracing.Tracer().Start(ctx, "sync.adopt", trace.WithAttributes(attribute.String("sync.pair", e.cfg.Name)))
+	rep, err := e.adopt(ctx, dups)
+	tracing.End(span, err)
+	return rep, err
+}
+
+func (e *Engine) adopt(ctx context.Context, dups []Duplicate) (*Report, error) {
+	rep := &Report{Plan: e.plan}
+	if err := e.prepare(ctx); err != nil {
+		return nil, err
+	}
+	selected, err := e.selectIDs(ctx, e.cfg.ADOProject, e.cfg.WIQL(), 0)
+	if err != nil {
+		return nil, fmt.Errorf("querying work items: %w", err)
+	}
+	e.orphans, e.blocked, e.rollups = nil, nil, nil
+	for _, d := range dups {
+		ctx := logging.With(ctx, logging.KeyWorkItem, d.ADOID, logging.KeyTask, d.AsanaGID)
+		if !selected.has(d.ADOID) {
+			err = fmt.Errorf("work item %d is not selected by the pair", d.ADOID)
+		} else {
+			err = e.adoptOne(ctx, d, rep)
+		}
+		if err != nil {
+			logging.From(ctx).Error("failed to adopt task", "error", err)
+			rep.fail(d.ADOID, err)
+			continue
+		}
+		rep.Items++
+		logging.From(ctx).Info("adopted asana task")
+	}
+	if err := e.linkOrphans(ctx); err != nil {
+		return nil, err
+	}
+	if err := e.linkBlocked(ctx); err != nil {
+		return nil, err
+	}
+	if err := e.rollUp(ctx, rep); err != nil {
+		return nil, err
+	}
+	return e.finish(ctx, rep)
+}
+
+// adoptOne syncs the work item of d with its task.
+func (e *Engine) adoptOne(ctx context.Context, d Duplicate, rep *Report) error {
+	if _, err := e.store.Get(ctx, d.ADOID); err == nil {
+		return fmt.Errorf("work item %d already has a task", d.ADOID)
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+	if m, err := e.store.ByAsanaGID(ctx, d.AsanaGID); err == nil {
+		return fmt.Errorf("asana task %s already belongs to work item %d", d.AsanaGID, m.ADOID)
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+	items, err := e.ado.GetWorkItems(ctx, []int{d.ADOID})
+	if err != nil {
+		return fmt.Errorf("fetching work item %d: %w", d.ADOID, err)
+	}
+	if len(items) == 0 {
+		return fmt.Errorf("work item %d not found", d.ADOID)
+	}
+	task, err := e.asana.GetTask(ctx, d.AsanaGID)
+	if err != nil {
+		return fmt.Errorf("fetching asana task %s: %w", d.AsanaGID, err)
+	}
+	return e.process(ctx, items[0], task, rep)
+}
@@ -0,0 +1,154 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/breaker"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/metrics"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// ErrDegraded is returned by cycles that ran degraded because ADO or Asana was unavailable.
+var ErrDegraded = errors.New("cycle ran degraded")
+
+// Circuit is the circuit breaker of the API client of ADO or Asana.
+type Circuit interface {
+	// Available reports whether requests are sent to the API.
+	Available() bool
+}
+
+// Unavailable reports whether err means that ADO or Asana could not be reached or is failing, rather than
+// rejecting the request.
+func Unavailable(err error) bool {
+	switch errorCategory(err) {
+	case "unavailable", "server", "network":
+		return true
+	}
+	return false
+}
+
+// unavailable returns the provider whose circuit is open, or an empty string when both are available.
+func (e *Engine) unavailable() string {
+	switch {
+	case e.adoCircuit != nil && !e.adoCircuit.Available():
+		return metrics.ProviderADO
+	case e.asanaCircuit != nil && !e.asanaCircuit.Available():
+		return metrics.ProviderAsana
+	}
+	return ""
+}
+
+// degraded runs a cycle while provider is unavailable, doing only the reads the other side allows. Nothing is
+// written to either side: the items changed on the healthy side since the last successful cycle are queued
+// for a retry that is due as soon as provider recovers, and the watermark of incremental pairs is left alone.
+func (e *Engine) degraded(ctx context.Context, provider string) error {
+	metrics.Degraded.WithLabelValues(e.cfg.Name).Set(1)
+	logging.From(ctx).Warn("provider unavailable, running degraded cycle", "provider", provider)
+
+	var since time.Time
+	switch prev, err := LastCycle(ctx, e.store, e.cfg.Name); {
+	case err == nil:
+		since = prev.LastSuccess
+	case !errors.Is(err, store.ErrNotFound):
+		return err
+	}
+	if since.IsZero() {
+		return fmt.Errorf("%s unavailable before the first successful cycle: %w", provider, ErrDegraded)
+	}
+
+	var ids []int
+	var err error
+	if provider == metrics.ProviderAsana {
+		if ids, err = e.selectIDs(ctx, e.cfg.ADOProject, changedSince(e.cfg.WIQL(), since), 0); err != nil {
+			return fmt.Errorf("querying changed work items: %w", err)
+		}
+	} else if ids, err = e.modifiedItems(ctx, since); err != nil {
+		return err
+	}
+	queued := e.queue(ctx, ids, provider+" unavailable")
+	logging.From(ctx).Info("degraded cycle finished", "provider", provider, "changed", len(ids), "queued", queued)
+	return fmt.Errorf("%s unavailable, %d changed work items pending: %w", provider, len(ids), ErrDegraded)
+}
+
+// modifiedItems returns the IDs of the mapped work items of the pair whose task changed in Asana since the
+// given time.
+func (e *Engine) modifiedItems(ctx context.Context, since time.Time) ([]int, error) {
+	// Before Validate the projects of the pair are those of its configuration.
+	projects := e.projects()
+	if !e.validated {
+		projects = e.cfg.projects()
+	}
+	var tasks []asana.Task
+	for _, p := range projects {
+		modified, err := e.asana.ModifiedTasks(ctx, p, since)
+		if err != nil {
+			return nil, fmt.Errorf("listing modified asana tasks: %w", err)
+		}
+		tasks = append(tasks, modified...)
+	}
+	mine, err := e.mirrorTasks(ctx, since)
+	if err != nil {
+		return nil, fmt.Errorf("listing modified asana tasks: %w", err)
+	}
+	tasks = append(tasks, mine...)
+	var ids []int
+	seen := map[int]bool{}
+	for _, t := range tasks {
+		m, err := e.store.ByAsanaGID(ctx, t.GID)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			continue
+		case err != nil:
+			return nil, err
+		}
+		if m.Pair == e.cfg.Name && !seen[m.ADOID] {
+			seen[m.ADOID] = true
+			ids = append(ids, m.ADOID)
+		}
+	}
+	return ids, nil
+}
+
+// queue queues the work items with the given IDs for a retry that is due right away, as reason kept them
+// from syncing. Items already queued keep their attempts. It returns the number of items queued, which is
+// zero when retries are disabled.
+func (e *Engine) queue(ctx context.Context, ids []int, reason string) int {
+	if e.plan != nil || e.cfg.Retry.MaxAttempts <= 0 {
+		return 0
+	}
+	now := time.Now().UTC()
+	n := 0
+	for _, id := range ids {
+		r, err := e.store.Retry(ctx, id)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			r = store.Retry{ADOID: id, Pair: e.cfg.Name, FirstFailed: now}
+		case err != nil:
+			logging.From(ctx).Error("failed to look up retry", logging.KeyWorkItem, id, "error", err)
+			continue
+		}
+		if r.Pair != e.cfg.Name {
+			continue
+		}
+		r.LastError = reason
+		if r.NextAttempt.IsZero() || r.NextAttempt.After(now) {
+			r.NextAttempt = now
+		}
+		if err := e.store.PutRetry(ctx, r); err != nil {
+			logging.From(ctx).Error("failed to queue retry", logging.KeyWorkItem, id, "error", err)
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+// isOpen reports whether err was returned by a circuit breaker refusing a request, or by a degraded cycle.
+func isOpen(err error) bool {
+	return errors.Is(err, breaker.ErrOpen) || errors.Is(err, ErrDegraded)
+}
@@ -0,0 +1,127 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// blocked is a task whose work item has predecessors that were not mapped to a task when the item was synced.
+type blocked struct {
+	taskGID      string
+	predecessors []int
+}
+
+// syncDependencies makes task depend on the tasks mapped to the predecessors of item, and to the items
+// blocking it when blocked by links make dependencies, and drops its dependencies on synced tasks whose work
+// item is no longer linked. Dependencies on tasks that are not synced are left alone. Successor links need
+// no sync of their own, as ADO records each of them as a predecessor link on the successor. Predecessors
+// that are not mapped yet are remembered so a cycle can link them once they have been synced.
+func (e *Engine) syncDependencies(ctx context.Context, item ado.WorkItem, task *asana.Task) error {
+	rels := e.cfg.dependencyTypes()
+	if len(rels) == 0 {
+		return nil
+	}
+	var linked []int
+	for _, rel := range rels {
+		linked = append(linked, item.Linked(rel)...)
+	}
+	want := map[string]bool{}
+	var pending []int
+	for _, id := range linked {
+		m, err := e.store.Get(ctx, id)
+		switch {
+		case errors.Is(err, store.ErrNotFound), err == nil && m.AsanaGID == "":
+			// A pending mapping has no task to depend on yet.
+			pending = append(pending, id)
+			continue
+		case err != nil:
+			return err
+		}
+		want[m.AsanaGID] = true
+	}
+
+	cur := map[string]bool{}
+	var remove []string
+	for _, d := range task.Dependencies {
+		cur[d.GID] = true
+		if want[d.GID] {
+			continue
+		}
+		if _, err := e.store.ByAsanaGID(ctx, d.GID); errors.Is(err, store.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		remove = append(remove, d.GID)
+	}
+	var add []string
+	for gid := range want {
+		if !cur[gid] {
+			add = append(add, gid)
+		}
+	}
+	sort.Strings(add)
+	if err := e.addDependencies(ctx, task.GID, add); err != nil {
+		return err
+	}
+	if len(remove) > 0 {
+		if err := e.asana.RemoveDependencies(ctx, task.GID, remove); err != nil {
+			return fmt.Errorf("removing dependencies of asana task %s: %w", task.GID, err)
+		}
+		logging.From(ctx).Info("removed dependencies from asana task", "dependencies", remove)
+	}
+
+	if len(pending) > 0 {
+		e.state.Lock()
+		defer e.state.Unlock()
+		if e.blocked == nil {
+			e.blocked = map[int]blocked{}
+		}
+		e.blocked[item.ID] = blocked{taskGID: task.GID, predecessors: pending}
+	}
+	return nil
+}
+
+// linkBlocked adds the dependencies remembered by syncDependencies whose predecessor has since been mapped.
+// It runs once the workers of a cycle are done.
+func (e *Engine) linkBlocked(ctx context.Context) error {
+	for id, b := range e.blocked {
+		ctx := logging.With(ctx, logging.KeyWorkItem, id, logging.KeyTask, b.taskGID)
+		var add []string
+		for _, p := range b.predecessors {
+			m, err := e.store.Get(ctx, p)
+			if errors.Is(err, store.ErrNotFound) || err == nil && m.AsanaGID == "" {
+				logging.From(ctx).Info("not linking task to its predecessor: predecessor is not synced", "predecessor_id", p)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			add = append(add, m.AsanaGID)
+		}
+		if err := e.addDependencies(ctx, b.taskGID, add); err != nil {
+			return err
+		}
+	}
+	e.blocked = nil
+	return nil
+}
+
+// addDependencies makes the task depend on the tasks with the given GIDs.
+func (e *Engine) addDependencies(ctx context.Context, taskGID string, dependencies []string) error {
+	if len(dependencies) == 0 {
+		return nil
+	}
+	if err := e.asana.AddDependencies(ctx, taskGID, dependencies); err != nil {
+		return fmt.Errorf("adding dependencies to asana task %s: %w", taskGID, err)
+	}
+	logging.From(ctx).Info("added dependencies to asana task", "dependencies", dependencies)
+	return nil
+}
@@ -0,0 +1,127 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+)
+
+// developmentHeading starts the Development block of task notes.
+const developmentHeading = "<strong>Development</strong>"
+
+// developmentBlock matches the Development block of task notes, as written by withDevelopment and
+// reformatted by Asana.
+var developmentBlock = regexp.MustCompile(`(?s)\s*<hr\s*/?>\s*` + developmentHeading + `\s*<ul>.*?</ul>`)
+
+// development renders the Development block listing the pull requests, commits and branches linked to
+// item, or an empty string when it has none. Pull requests show their status; a pull request whose status
+// cannot be read is listed without it.
+func (e *Engine) development(ctx context.Context, item ado.WorkItem) string {
+	links := item.DevelopmentLinks()
+	if len(links) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("<hr/>" + developmentHeading + "<ul>")
+	for _, l := range links {
+		text := l.Kind + " " + l.ID
+		switch l.Kind {
+		case ado.LinkPullRequest:
+			text = "Pull request " + l.ID
+			if pr := e.pullRequest(ctx, l); pr != nil {
+				status := pr.Status
+				if pr.IsDraft && status == "active" {
+					status = "draft"
+				}
+				text = fmt.Sprintf("Pull request %d: %s (%s)", pr.ID, pr.Title, status)
+			}
+		case ado.LinkCommit:
+			text = "Commit " + shortSHA(l.ID)
+		case ado.LinkBranch:
+			text = "Branch " + l.ID
+		}
+		b.WriteString("<li>")
+		if l.URL != "" {
+			b.WriteString(`<a href="` + html.EscapeString(l.URL) + `">` + html.EscapeString(text) + "</a>")
+		} else {
+			b.WriteString(html.EscapeString(text))
+		}
+		b.WriteString("</li>")
+	}
+	b.WriteString("</ul>")
+	return b.String()
+}
+
+// pullRequest returns the pull request of the link, or nil when it cannot be read.
+func (e *Engine) pullRequest(ctx context.Context, l ado.DevelopmentLink) *ado.PullRequest {
+	id, err := strconv.Atoi(l.ID)
+	if err != nil {
+		return nil
+	}
+	pr, err := e.ado.PullRequest(ctx, l.Project, id)
+	if err != nil {
+		logging.From(ctx).Warn("failed to read linked pull request", "pull_request", id, "error", err)
+		return nil
+	}
+	return pr
+}
+
+// shortSHA abbreviates a commit SHA as Git does.
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+// withDevelopment returns the html_notes with their Development block replaced by block, which is appended
+// when the notes have none. An empty block removes it.
+func withDevelopment(notes, block string) string {
+	return withBlock(developmentBlock, notes, block)
+}
+
+// withBlock returns the html_notes with the block matched by pattern replaced by block, which is appended
+// when the notes have none. An empty block removes it.
+func withBlock(pattern *regexp.Regexp, notes, block string) string {
+	notes = pattern.ReplaceAllString(notes, "")
+	if block == "" {
+		return notes
+	}
+	if i := strings.LastIndex(notes, "</body>"); i >= 0 {
+		return notes[:i] + block + notes[i:]
+	}
+	return "<body>" + notes + block + "</body>"
+}
+
+// developmentNotes returns the notes of task with the Development block of item brought up to date. rendered
+// holds the notes rendered by the notes template when they are rewritten, otherwise the current notes are
+// read from Asana. ok is false when the notes need no update.
+func (e *Engine) developmentNotes(ctx context.Context, item ado.WorkItem, task *asana.Task, rendered *string, ch changes) (notes string, ok bool, err error) {
+	if !e.cfg.Development {
+		return "", false, nil
+	}
+	block := e.development(ctx, item)
+	if rendered != nil {
+		return withDevelopment(*rendered, block), true, nil
+	}
+	// Without links there is only a block to remove when links were removed, which changed the item.
+	if block == "" && !ch.ado {
+		return "", false, nil
+	}
+	cur, err := e.asana.TaskHTMLNotes(ctx, task.GID)
+	if err != nil {
+		return "", false, fmt.Errorf("reading asana task notes: %w", err)
+	}
+	// Asana reformats the HTML it stores, so blocks are compared by their text.
+	if plainText(developmentBlock.FindString(cur)) == plainText(block) {
+		return "", false, nil
+	}
+	return withDevelopment(cur, block), true, nil
+}
@@ -0,0 +1,134 @@
+package sync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+
+	"github.com/danstis/ado-asana-sync/internal/asana"
+)
+
+// notesHash returns the hash of the notes written to a task, which the mapping stores so unchanged notes
+// are not written again. Asana reformats the HTML it stores, so the task's notes cannot be compared.
+func notesHash(notes string) string {
+	sum := sha256.Sum256([]byte(notes))
+	return hex.EncodeToString(sum[:])
+}
+
+// pruneRequest drops the fields of req that task already holds, as well as notes hashing to notesHash, and
+// returns the names of the fields left to write, sorted. Writing unchanged fields adds noise to the
+// activity feed of the task.
+func pruneRequest(task *asana.Task, req *asana.TaskRequest, notes string) []string {
+	var changed []string
+	if req.Name != nil {
+		if *req.Name == task.Name {
+			req.Name = nil
+		} else {
+			changed = append(changed, "name")
+		}
+	}
+	if req.HTMLNotes != nil {
+		if notes != "" && notesHash(*req.HTMLNotes) == notes {
+			req.HTMLNotes = nil
+		} else {
+			changed = append(changed, "notes")
+		}
+	}
+	if req.Notes != nil {
+		if *req.Notes == task.Notes {
+			req.Notes = nil
+		} else {
+			changed = append(changed, "notes")
+		}
+	}
+	if req.Completed != nil {
+		if *req.Completed == task.Completed {
+			req.Completed = nil
+		} else {
+			changed = append(changed, "completed")
+		}
+	}
+	if req.Assignee != nil {
+		if task.Assignee != nil && task.Assignee.GID == *req.Assignee {
+			req.Assignee = nil
+		} else {
+			changed = append(changed, "assignee")
+		}
+	}
+	if req.DueOn != nil {
+		if string(*req.DueOn) == task.DueOn {
+			req.DueOn = nil
+		} else {
+			changed = append(changed, "due_on")
+		}
+	}
+	if req.External != nil {
+		if task.External != nil && task.External.Data == req.External.Data {
+			req.External = nil
+		} else {
+			changed = append(changed, "external")
+		}
+	}
+	if req.ResourceSubtype != nil {
+		if *req.ResourceSubtype == task.ResourceSubtype {
+			req.ResourceSubtype = nil
+		} else {
+			changed = append(changed, "resource_subtype")
+		}
+	}
+	if req.ApprovalStatus != nil {
+		if *req.ApprovalStatus == task.ApprovalStatus && req.ResourceSubtype == nil {
+			req.ApprovalStatus = nil
+		} else {
+			changed = append(changed, "approval_status")
+		}
+	}
+	for gid, v := range req.CustomFields {
+		if holds(task.CustomFields, gid, v) {
+			delete(req.CustomFields, gid)
+		} else {
+			changed = append(changed, "custom_fields")
+		}
+	}
+	if len(req.CustomFields) == 0 {
+		req.CustomFields = nil
+	}
+	sort.Strings(changed)
+	return dedupe(changed)
+}
+
+// holds reports whether the custom field gid among fields holds the value v, as written in a TaskRequest.
+// Values of unknown types are never held, so they are written.
+func holds(fields []asana.CustomField, gid string, v interface{}) bool {
+	for _, cf := range fields {
+		if cf.GID != gid {
+			continue
+		}
+		switch v := v.(type) {
+		case nil:
+			return cf.NumberValue == nil && cf.DateValue == nil && cf.EnumValue == nil && (cf.TextValue == nil || *cf.TextValue == "")
+		case float64:
+			return cf.NumberValue != nil && *cf.NumberValue == v
+		case asana.DateValue:
+			return cf.DateValue != nil && cf.DateValue.Date == v.Date
+		case string:
+			if cf.ResourceSubtype == asana.CustomFieldEnum {
+				return cf.EnumValue != nil && cf.EnumValue.GID == v
+			}
+			return cf.TextValue != nil && *cf.TextValue == v
+		}
+		return false
+	}
+	return false
+}
+
+// dedupe removes the repeats from the sorted list.
+func dedupe(sorted []string) []string {
+	out := sorted[:0]
+	for i, s := range sorted {
+		if i == 0 || s != sorted[i-1] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
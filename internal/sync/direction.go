@@ -0,0 +1,105 @@
+package sync
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Direction controls which side of a sync pair is allowed to write to the other.
+type Direction string
+
+// Supported sync directions.
+const (
+	ADOToAsana    Direction = "ado-to-asana"
+	AsanaToADO    Direction = "asana-to-ado"
+	Bidirectional Direction = "bidirectional"
+)
+
+// ParseDirection parses s as a Direction. An empty string returns ADOToAsana.
+func ParseDirection(s string) (Direction, error) {
+	switch d := Direction(strings.ToLower(strings.TrimSpace(s))); d {
+	case "":
+		return ADOToAsana, nil
+	case ADOToAsana, AsanaToADO, Bidirectional:
+		return d, nil
+	default:
+		return "", fmt.Errorf("unknown sync direction %q", s)
+	}
+}
+
+// Field is a task attribute kept in sync between the two systems.
+type Field string
+
+// Synced fields.
+const (
+	FieldTitle   Field = "title"
+	FieldState   Field = "state"
+	FieldDueDate Field = "due"
+	// FieldCompleted, FieldRemaining and FieldEstimate are the effort fields of EffortConfig.
+	FieldCompleted Field = "completed"
+	FieldRemaining Field = "remaining"
+	FieldEstimate  Field = "estimate"
+)
+
+// knownFields lists every field that supports a direction override.
+var knownFields = []Field{FieldTitle, FieldState, FieldDueDate, FieldCompleted, FieldRemaining, FieldEstimate}
+
+// ParseFieldDirections parses a comma separated list of field=direction overrides,
+// for example "title=ado-to-asana,state=bidirectional".
+func ParseFieldDirections(s string) (map[Field]Direction, error) {
+	overrides := map[Field]Direction{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid field direction %q, expected field=direction", part)
+		}
+		f := Field(strings.ToLower(strings.TrimSpace(name)))
+		if !isKnownField(f) {
+			return nil, fmt.Errorf("unknown sync field %q", name)
+		}
+		d, err := ParseDirection(value)
+		if err != nil {
+			return nil, err
+		}
+		overrides[f] = d
+	}
+	return overrides, nil
+}
+
+func isKnownField(f Field) bool {
+	for _, k := range knownFields {
+		if k == f {
+			return true
+		}
+	}
+	return false
+}
+
+// side identifies which system a field value is taken from.
+type side int
+
+const (
+	sideADO side = iota
+	sideAsana
+)
+
+// source returns the side whose value should win for a field syncing in direction d, given which sides
+// changed since the last sync. conflict is true when d is Bidirectional and both sides changed.
+func source(d Direction, adoChanged, asanaChanged bool) (s side, conflict bool) {
+	switch d {
+	case AsanaToADO:
+		return sideAsana, false
+	case Bidirectional:
+		if adoChanged && asanaChanged {
+			return sideADO, true
+		}
+		if asanaChanged {
+			return sideAsana, false
+		}
+	}
+	return sideADO, false
+}
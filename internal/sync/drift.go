@@ -0,0 +1,272 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/metrics"
+	"github.com/danstis/ado-asana-sync/internal/store"
+	"github.com/danstis/ado-asana-sync/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DriftKind is a way a stored mapping no longer matches its work item or task.
+type DriftKind string
+
+// Kinds of drift found by CheckDrift.
+const (
+	// DriftMissingTask is a mapping whose Asana task was deleted.
+	DriftMissingTask DriftKind = "missing_task"
+	// DriftMissingItem is a mapping whose work item was deleted or can no longer be read.
+	DriftMissingItem DriftKind = "missing_item"
+	// DriftDetached is a task that was moved out of every Asana project of the pair.
+	DriftDetached DriftKind = "detached"
+	// DriftMismatch is a task that refers to another work item than the one it is mapped to.
+	DriftMismatch DriftKind = "mismatch"
+	// DriftStale is a mapping with a change on either side that the last cycle should have synced but did not.
+	DriftStale DriftKind = "stale"
+)
+
+// Drift is a stored mapping that no longer matches its work item or task.
+type Drift struct {
+	Kind     DriftKind
+	ADOID    int
+	AsanaGID string
+	// Repaired is set when the drift was repaired. Err is why the repair failed.
+	Repaired bool
+	Err      error
+}
+
+// DriftReport is the outcome of a drift check of a pair.
+type DriftReport struct {
+	// Checked is the number of mappings checked.
+	Checked int
+	Drift   []Drift
+}
+
+// Score returns the share of the checked mappings that drifted, from 0 to 1.
+func (r *DriftReport) Score() float64 {
+	if r.Checked == 0 {
+		return 0
+	}
+	return float64(len(r.Drift)) / float64(r.Checked)
+}
+
+// Repaired returns the number of drifted mappings that were repaired.
+func (r *DriftReport) Repaired() int {
+	n := 0
+	for _, d := range r.Drift {
+		if d.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+// CheckDrift walks every mapping of the pair and checks both its work item and its task still exist and
+// match, catching what incremental cycles miss, such as tasks deleted or moved by hand. With repair, lost
+// tasks are recreated, tasks moved out of the pair are moved back, mappings of deleted items are forgotten
+// under the removal policy and stale items are synced again; otherwise drift is only reported. Frozen
+// mappings, and those recorded before pairs were named, are left alone. Dry runs never repair.
+func (e *Engine) CheckDrift(ctx context.Context, repair bool) (*DriftReport, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ctx = e.begin(ctx)
+	ctx, span := tracing.Tracer().Start(ctx, "sync.drift", trace.WithAttributes(attribute.String("sync.pair", e.cfg.Name)))
+	if repair && errors.Is(e.checkPaused(ctx), ErrPaused) {
+		logging.From(ctx).Info("checking drift without repairing it while the sync pair is paused")
+		repair = false
+	}
+	rep, err := e.checkDrift(ctx, repair && e.plan == nil)
+	if err != nil {
+		e.countError(err)
+	} else {
+		metrics.DriftScore.WithLabelValues(e.cfg.Name).Set(rep.Score())
+		for _, d := range rep.Drift {
+			metrics.Drift.WithLabelValues(e.cfg.Name, string(d.Kind)).Inc()
+		}
+		logging.From(ctx).Info("drift check summary", "checked", rep.Checked, "drifted", len(rep.Drift),
+			"repaired", rep.Repaired(), "score", rep.Score())
+	}
+	tracing.End(span, err)
+	return rep, err
+}
+
+func (e *Engine) checkDrift(ctx context.Context, repair bool) (*DriftReport, error) {
+	if err := e.prepare(ctx); err != nil {
+		return nil, err
+	}
+	all, err := e.store.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var mappings []store.Mapping
+	var ids []int
+	for _, m := range all {
+		if m.Pair == e.cfg.Name && !m.Frozen && m.AsanaGID != "" {
+			mappings = append(mappings, m)
+			ids = append(ids, m.ADOID)
+		}
+	}
+	cutoff, err := e.driftCutoff(ctx)
+	if err != nil {
+		return nil, err
+	}
+	selected, err := e.selectIDs(ctx, e.cfg.ADOProject, e.cfg.WIQL(), 0)
+	if err != nil {
+		return nil, fmt.Errorf("querying work items: %w", err)
+	}
+	items := make(map[int]*ado.WorkItem, len(ids))
+	for start := 0; start < len(ids); start += pageSize {
+		end := start + pageSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		page, err := e.ado.GetWorkItems(ctx, ids[start:end])
+		if err != nil {
+			return nil, fmt.Errorf("fetching work items: %w", err)
+		}
+		for i := range page {
+			items[page[i].ID] = &page[i]
+		}
+	}
+	idx, err := e.indexTasks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rep := &DriftReport{Checked: len(mappings)}
+	synced := &Report{Plan: e.plan}
+	e.orphans, e.blocked, e.rollups = nil, nil, nil
+	for _, m := range mappings {
+		ctx := logging.With(ctx, logging.KeyWorkItem, m.ADOID, logging.KeyTask, m.AsanaGID)
+		task := idx.byGID[m.AsanaGID]
+		if task == nil {
+			// Tasks missing from the projects of the pair were deleted or moved elsewhere.
+			if task, err = e.asana.GetTask(ctx, m.AsanaGID); err != nil && !isNotFound(err) {
+				return nil, fmt.Errorf("fetching asana task %s: %w", m.AsanaGID, err)
+			}
+		}
+		item := items[m.ADOID]
+		kind, ok := e.driftOf(m, item, task, selected.has(m.ADOID), cutoff)
+		if !ok {
+			continue
+		}
+		d := Drift{Kind: kind, ADOID: m.ADOID, AsanaGID: m.AsanaGID}
+		if repair {
+			d.Err = e.repairDrift(ctx, d, m, item, task, selected.has(m.ADOID), idx, synced)
+			d.Repaired = d.Err == nil
+		}
+		if d.Err != nil {
+			logging.From(ctx).Error("failed to repair drifted mapping", "drift", kind, "error", d.Err)
+		} else {
+			logging.From(ctx).Warn("mapping drifted", "drift", kind, "repaired", d.Repaired)
+		}
+		rep.Drift = append(rep.Drift, d)
+	}
+	if err := e.linkOrphans(ctx); err != nil {
+		return nil, err
+	}
+	if err := e.linkBlocked(ctx); err != nil {
+		return nil, err
+	}
+	if err := e.rollUp(ctx, synced); err != nil {
+		return nil, err
+	}
+	if _, err := e.finish(ctx, synced); err != nil {
+		return nil, err
+	}
+	return rep, nil
+}
+
+// driftCutoff returns the start of the last cycle of the pair when it completed. Changes older than that
+// should have been synced by it. It is the zero time when the last cycle failed or none ran yet.
+func (e *Engine) driftCutoff(ctx context.Context) (time.Time, error) {
+	s, err := LastCycle(ctx, e.store, e.cfg.Name)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return time.Time{}, nil
+	case err != nil:
+		return time.Time{}, err
+	case s.Error != "":
+		return time.Time{}, nil
+	}
+	return s.Started, nil
+}
+
+// driftOf returns how m drifted from item and task, which are nil when they no longer exist, or false when
+// it did not. selected is set when the query of the pair selects the item; only those are synced by
+// cycles, so only they can be stale.
+func (e *Engine) driftOf(m store.Mapping, item *ado.WorkItem, task *asana.Task, selected bool, cutoff time.Time) (DriftKind, bool) {
+	switch {
+	case task == nil:
+		return DriftMissingTask, true
+	case item == nil:
+		return DriftMissingItem, true
+	case !e.inPair(task):
+		return DriftDetached, true
+	}
+	id, ok := e.cfg.anchorOf(task)
+	if !ok {
+		id, ok = parseTaskID(task.Name)
+	}
+	if ok && id != m.ADOID {
+		return DriftMismatch, true
+	}
+	if !selected || cutoff.IsZero() {
+		return "", false
+	}
+	adoStale := item.Rev != m.ADORev && item.ChangedDate().Before(cutoff)
+	asanaStale := task.ModifiedAt.After(m.AsanaModified) && task.ModifiedAt.Before(cutoff)
+	if adoStale || asanaStale {
+		return DriftStale, true
+	}
+	return "", false
+}
+
+// inPair reports whether task is in one of the Asana projects of the pair, or the My Tasks it mirrors into.
+func (e *Engine) inPair(task *asana.Task) bool {
+	if e.cfg.MyTasks.enabled() {
+		return e.inMyTasks(task)
+	}
+	for _, p := range e.projects() {
+		if task.InProject(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// repairDrift repairs the drift d of m. A mapping whose task is gone or belongs to another item is
+// forgotten, and its item synced again to find its task by its ID or create a new one. Items the query no
+// longer selects are left to the removal policy.
+func (e *Engine) repairDrift(ctx context.Context, d Drift, m store.Mapping, item *ado.WorkItem, task *asana.Task, selected bool, idx *taskIndex, rep *Report) error {
+	switch d.Kind {
+	case DriftMissingItem:
+		if e.cfg.Removal.active() {
+			return e.remove(ctx, m)
+		}
+		return e.store.Delete(ctx, m.ADOID)
+	case DriftMissingTask, DriftMismatch:
+		if err := e.store.Delete(ctx, m.ADOID); err != nil {
+			return err
+		}
+		if task = idx.byADOID[m.ADOID]; task != nil && task.GID == m.AsanaGID {
+			task = nil
+		}
+	}
+	if !selected || item == nil {
+		if d.Kind == DriftDetached {
+			return fmt.Errorf("work item %d is no longer selected by the pair", m.ADOID)
+		}
+		return nil
+	}
+	return e.process(ctx, *item, task, rep)
+}
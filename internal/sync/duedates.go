@@ -0,0 +1,48 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+)
+
+// dateLayout is the layout of Asana due dates.
+const dateLayout = "2006-01-02"
+
+// loadIterations records the end date of every iteration of the ADO project, keyed by lower case path.
+func (e *Engine) loadIterations(ctx context.Context) (map[string]string, error) {
+	its, err := e.ado.Iterations(ctx, e.cfg.ADOProject)
+	if err != nil {
+		return nil, fmt.Errorf("listing iterations: %w", err)
+	}
+	ends := make(map[string]string, len(its))
+	for _, it := range its {
+		if it.FinishDate != nil {
+			ends[strings.ToLower(it.Path)] = e.calendar.day(*it.FinishDate)
+		}
+	}
+	return ends, nil
+}
+
+// dueDate returns the due date of item: its target date or, when it has none, the end date of its iteration,
+// snapped to a working day by the calendar of the pair. It is empty when neither is set.
+func (e *Engine) dueDate(item ado.WorkItem) string {
+	if t, ok := item.TargetDate(); ok {
+		return e.calendar.snapDate(e.calendar.day(t))
+	}
+	return e.calendar.snapDate(e.iterationEnds[strings.ToLower(item.IterationPath())])
+}
+
+// targetDateOp returns the patch operation writing the Asana due date due back to item. Without a target
+// date the due date of item is that of its iteration, which cannot be cleared, so ok is false when due is empty.
+func (e *Engine) targetDateOp(item ado.WorkItem, due string) (op ado.PatchOperation, ok bool) {
+	if due != "" {
+		return ado.SetField(ado.FieldTargetDate, e.calendar.midnight(due)), true
+	}
+	if _, set := item.TargetDate(); set {
+		return ado.RemoveField(ado.FieldTargetDate), true
+	}
+	return ado.PatchOperation{}, false
+}
@@ -0,0 +1,234 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+)
+
+// EffortActual is the completed work target reading the actual time Asana's native time tracking records
+// on tasks. It is read-only, so it is only synced to ADO.
+const EffortActual = "actual"
+
+// EffortConfig maps the effort fields of work items, in hours, onto Asana. Every target is the name or GID
+// of a number custom field holding hours, or minutes when the field is shown as a duration, as Asana's
+// Estimated time is. Fields without a target are not synced.
+type EffortConfig struct {
+	// Completed receives Completed Work. It may be EffortActual.
+	Completed string `json:"completed,omitempty"`
+	// Remaining receives Remaining Work.
+	Remaining string `json:"remaining,omitempty"`
+	// Estimate receives Original Estimate.
+	Estimate string `json:"estimate,omitempty"`
+}
+
+// effortSource is an effort field with the ADO field it is read from and its target.
+type effortSource struct {
+	field  Field
+	source string
+	target string
+}
+
+// sources returns the effort fields that have a target.
+func (c EffortConfig) sources() []effortSource {
+	var s []effortSource
+	for _, f := range []effortSource{
+		{FieldCompleted, ado.FieldCompletedWork, c.Completed},
+		{FieldRemaining, ado.FieldRemainingWork, c.Remaining},
+		{FieldEstimate, ado.FieldOriginalEstimate, c.Estimate},
+	} {
+		if f.target != "" {
+			s = append(s, f)
+		}
+	}
+	return s
+}
+
+// ParseEffort parses a comma separated list of field=target effort mappings, for example
+// "completed=actual,remaining=Remaining hours,estimate=Estimated time".
+func ParseEffort(s string) (EffortConfig, error) {
+	var c EffortConfig
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, target, ok := strings.Cut(part, "=")
+		target = strings.TrimSpace(target)
+		if !ok || target == "" {
+			return c, fmt.Errorf("invalid effort mapping %q, expected field=asana field", part)
+		}
+		switch Field(strings.ToLower(strings.TrimSpace(name))) {
+		case FieldCompleted:
+			c.Completed = target
+		case FieldRemaining:
+			c.Remaining = target
+		case FieldEstimate:
+			c.Estimate = target
+		default:
+			return c, fmt.Errorf("unknown effort field %q, expected completed, remaining or estimate", name)
+		}
+	}
+	return c, nil
+}
+
+// ValidateEffort checks that only completed work reads the actual time, and that it then syncs from Asana.
+func (c Config) ValidateEffort() error {
+	if strings.EqualFold(c.Effort.Remaining, EffortActual) || strings.EqualFold(c.Effort.Estimate, EffortActual) {
+		return fmt.Errorf("only completed work can sync with the actual time of tasks")
+	}
+	if strings.EqualFold(c.Effort.Completed, EffortActual) && c.DirectionFor(FieldCompleted) != AsanaToADO {
+		return fmt.Errorf("the actual time of tasks is read-only, set the completed field direction to %s", AsanaToADO)
+	}
+	return nil
+}
+
+// effortField is an effort field resolved on an Asana project.
+type effortField struct {
+	effortSource
+	// gid is the custom field holding the effort, empty for the actual time.
+	gid string
+	// minutes is set for duration fields, which hold minutes rather than hours.
+	minutes bool
+}
+
+// resolveEffortFields resolves the targets of the effort fields on the Asana project.
+func (e *Engine) resolveEffortFields(ctx context.Context, project string) ([]effortField, error) {
+	sources := e.cfg.Effort.sources()
+	if len(sources) == 0 {
+		return nil, nil
+	}
+	fields, err := e.asana.ProjectCustomFields(ctx, project)
+	if err != nil {
+		return nil, fmt.Errorf("listing asana custom fields: %w", err)
+	}
+	resolved := make([]effortField, 0, len(sources))
+	for _, s := range sources {
+		if strings.EqualFold(s.target, EffortActual) {
+			resolved = append(resolved, effortField{effortSource: s, minutes: true})
+			continue
+		}
+		cf, ok := findCustomField(fields, s.target)
+		if !ok {
+			return nil, withCode(CodeFieldMissing, fmt.Errorf("effort field %s: custom field %q not found on asana project %s", s.field, s.target, project))
+		}
+		if FieldType(cf.ResourceSubtype) != TypeNumber {
+			return nil, fmt.Errorf("effort field %s: asana field %q is %s, not number", s.field, s.target, cf.ResourceSubtype)
+		}
+		resolved = append(resolved, effortField{effortSource: s, gid: cf.GID, minutes: cf.RepresentationType == "duration"})
+	}
+	return resolved, nil
+}
+
+// asanaValue returns the effort the task holds in hours, or nil when it holds none.
+func (f effortField) asanaValue(task *asana.Task) *float64 {
+	var v *float64
+	if f.gid == "" {
+		v = task.ActualTimeMinutes
+	} else {
+		for _, cf := range task.CustomFields {
+			if cf.GID == f.gid {
+				v = cf.NumberValue
+			}
+		}
+	}
+	if v == nil {
+		return nil
+	}
+	h := *v
+	if f.minutes {
+		h /= 60
+	}
+	return &h
+}
+
+// value returns the custom field value holding h hours, or nil to clear the field.
+func (f effortField) value(h *float64) interface{} {
+	if h == nil {
+		return nil
+	}
+	if f.minutes {
+		return math.Round(*h * 60)
+	}
+	return *h
+}
+
+// hours returns the effort an ADO field holds, or nil when it is unset.
+func hours(v interface{}) *float64 {
+	switch n := v.(type) {
+	case float64:
+		return &n
+	case string:
+		if f, err := strconv.ParseFloat(n, 64); err == nil {
+			return &f
+		}
+	}
+	return nil
+}
+
+// sameEffort reports whether a and b are the same effort, to the minute.
+func sameEffort(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return math.Round(*a*60) == math.Round(*b*60)
+}
+
+// formatHours renders an effort for conflicts.
+func formatHours(h *float64) string {
+	if h == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*h, 'f', -1, 64)
+}
+
+// effortValues adds the effort of item to values, the custom field values of a new task in project.
+func (e *Engine) effortValues(project string, item ado.WorkItem, values map[string]interface{}) map[string]interface{} {
+	for _, f := range e.target(project).effort {
+		h := hours(item.Fields[f.source])
+		if f.gid == "" || h == nil {
+			continue
+		}
+		if values == nil {
+			values = map[string]interface{}{}
+		}
+		values[f.gid] = f.value(h)
+	}
+	return values
+}
+
+// syncEffort brings the effort fields of item and its task in project into step, adding the Asana side to
+// req and returning the ADO side as patch operations. It reports whether req changed.
+func (e *Engine) syncEffort(ctx context.Context, project string, item ado.WorkItem, task *asana.Task, ch changes, req *asana.TaskRequest, rep *Report) ([]ado.PatchOperation, bool) {
+	var ops []ado.PatchOperation
+	changed := false
+	for _, f := range e.target(project).effort {
+		have, want := f.asanaValue(task), hours(item.Fields[f.source])
+		if sameEffort(have, want) {
+			e.clearConflict(ctx, item.ID, f.field)
+			continue
+		}
+		switch s, ok := e.pick(ctx, f.field, item, task, ch, formatHours(want), formatHours(have), rep); {
+		case !ok:
+		case s == sideADO:
+			if f.gid == "" {
+				continue
+			}
+			if req.CustomFields == nil {
+				req.CustomFields = map[string]interface{}{}
+			}
+			req.CustomFields[f.gid] = f.value(want)
+			changed = true
+		case have == nil:
+			ops = append(ops, ado.RemoveField(f.source))
+		default:
+			ops = append(ops, ado.SetField(f.source, math.Round(*have*100)/100))
+		}
+	}
+	return ops, changed
+}
@@ -0,0 +1,1662 @@
+// Package sync keeps Azure DevOps work items and Asana tasks in step.
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/metrics"
+	"github.com/danstis/ado-asana-sync/internal/ratelimit"
+	"github.com/danstis/ado-asana-sync/internal/schedule"
+	"github.com/danstis/ado-asana-sync/internal/store"
+	"github.com/danstis/ado-asana-sync/internal/tracing"
+	"github.com/danstis/ado-asana-sync/internal/transform"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ADO is the subset of the Azure DevOps client used by the engine.
+type ADO interface {
+	Query(ctx context.Context, project, wiql string) ([]int, error)
+	QueryTop(ctx context.Context, project, wiql string, top int) ([]int, error)
+	GetWorkItems(ctx context.Context, ids []int) ([]ado.WorkItem, error)
+	UpdateWorkItem(ctx context.Context, id int, ops []ado.PatchOperation) (*ado.WorkItem, error)
+	CreateWorkItem(ctx context.Context, project, typ string, ops []ado.PatchOperation) (*ado.WorkItem, error)
+	Comments(ctx context.Context, project string, id int) ([]ado.Comment, error)
+	AddComment(ctx context.Context, project string, id int, text string) (*ado.Comment, error)
+	DownloadAttachment(ctx context.Context, attachmentURL string, max int64) ([]byte, error)
+	UploadAttachment(ctx context.Context, project, name string, data []byte) (string, error)
+	Iterations(ctx context.Context, project string) ([]ado.Iteration, error)
+	WorkItemTypes(ctx context.Context, project string) ([]ado.WorkItemType, error)
+	Fields(ctx context.Context, project string) ([]ado.Field, error)
+	Picklist(ctx context.Context, id string) ([]string, error)
+	PullRequest(ctx context.Context, project string, id int) (*ado.PullRequest, error)
+	Board(ctx context.Context, project, team, board string) (*ado.Board, error)
+	ValidateUpdate(ctx context.Context, id int, ops []ado.PatchOperation) error
+	Repositories(ctx context.Context, project string) ([]ado.Repository, error)
+	TestPoints(ctx context.Context, project string, testCases []int) ([]ado.TestPoint, error)
+	TestResult(ctx context.Context, project string, run, result int) (*ado.TestResult, error)
+}
+
+// Asana is the subset of the Asana client used by the engine.
+type Asana interface {
+	GetWorkspace(ctx context.Context, gid string) (*asana.Workspace, error)
+	GetProject(ctx context.Context, gid string) (*asana.Project, error)
+	ProjectTasks(ctx context.Context, projectGID string) ([]asana.Task, error)
+	ModifiedTasks(ctx context.Context, projectGID string, since time.Time) ([]asana.Task, error)
+	AssignedTasks(ctx context.Context, workspaceGID, userGID string, since time.Time) ([]asana.Task, error)
+	GetTask(ctx context.Context, gid string) (*asana.Task, error)
+	TaskHTMLNotes(ctx context.Context, gid string) (string, error)
+	CreateTask(ctx context.Context, req asana.TaskRequest) (*asana.Task, error)
+	UpdateTask(ctx context.Context, gid string, req asana.TaskRequest) (*asana.Task, error)
+	DeleteTask(ctx context.Context, gid string) error
+	WorkspaceUsers(ctx context.Context, workspaceGID string) ([]asana.User, error)
+	TaskComments(ctx context.Context, taskGID string) ([]asana.Story, error)
+	AddComment(ctx context.Context, taskGID, text string) (*asana.Story, error)
+	Attachments(ctx context.Context, taskGID string) ([]asana.Attachment, error)
+	DownloadAttachment(ctx context.Context, a asana.Attachment, max int64) ([]byte, error)
+	UploadAttachment(ctx context.Context, taskGID, name string, data []byte) (*asana.Attachment, error)
+	ProjectCustomFields(ctx context.Context, projectGID string) ([]asana.CustomField, error)
+	CreateEnumOption(ctx context.Context, fieldGID, name string) (*asana.EnumOption, error)
+	WorkspaceCustomFields(ctx context.Context, workspaceGID string) ([]asana.CustomField, error)
+	CreateCustomField(ctx context.Context, workspaceGID, name, subtype string, options []string) (*asana.CustomField, error)
+	ProjectSections(ctx context.Context, projectGID string) ([]asana.Section, error)
+	CreateSection(ctx context.Context, projectGID, name string) (*asana.Section, error)
+	AddTaskToSection(ctx context.Context, sectionGID, taskGID string) error
+	WorkspaceTags(ctx context.Context, workspaceGID string) ([]asana.Tag, error)
+	CreateTag(ctx context.Context, workspaceGID, name string) (*asana.Tag, error)
+	AddTag(ctx context.Context, taskGID, tagGID string) error
+	RemoveTag(ctx context.Context, taskGID, tagGID string) error
+	SetParent(ctx context.Context, taskGID, parentGID string) error
+	AddDependencies(ctx context.Context, taskGID string, dependencies []string) error
+	RemoveDependencies(ctx context.Context, taskGID string, dependencies []string) error
+	AddProject(ctx context.Context, taskGID, projectGID string) error
+	RemoveProject(ctx context.Context, taskGID, projectGID string) error
+	CreateProject(ctx context.Context, req asana.ProjectRequest) (*asana.Project, error)
+	AddCustomFieldSetting(ctx context.Context, projectGID, fieldGID string) error
+	AddPortfolioItem(ctx context.Context, portfolioGID, projectGID string) error
+	ProjectMembers(ctx context.Context, projectGID string) ([]asana.User, error)
+	AddProjectMembers(ctx context.Context, projectGID string, users []string) error
+	AddFollowers(ctx context.Context, taskGID string, followers []string) error
+	CreateStatusUpdate(ctx context.Context, req asana.StatusUpdateRequest) (*asana.StatusUpdate, error)
+	DeleteStatusUpdate(ctx context.Context, gid string) error
+}
+
+// Config describes a single ADO project to Asana project sync pair.
+type Config struct {
+	// Name identifies the pair in logs, metrics and the mapping database.
+	Name string
+	// Interval is the time between scheduled sync cycles of the pair when it has no Schedule.
+	Interval time.Duration
+	// Schedule, when set, runs the cycles of the pair on a cron schedule instead of every Interval.
+	Schedule schedule.Schedule
+	// Jitter is the longest random delay added to each scheduled cycle, so pairs sharing a schedule do not
+	// all hit the APIs at once.
+	Jitter time.Duration
+	// Maintenance are the windows during which the pair is paused as by Engine.Pause.
+	Maintenance []schedule.Window
+	// Workers is the number of work items synced concurrently during a cycle.
+	Workers int
+	// Prefetch is the number of pages of work items fetched ahead of the workers. It bounds the items a
+	// cycle holds at once, however many its query selects.
+	Prefetch int
+	// QueryPage is the number of work item IDs read per query of the selection of the pair, at most
+	// ado.MaxQueryResults. Selections larger than a page are read a page at a time.
+	QueryPage int
+	// CallBudget caps the API requests of the pair to both providers. A cycle that reaches it stops and the
+	// next one resumes it.
+	CallBudget []ratelimit.Cap
+	// Incremental limits cycles to the items changed on either side since the last successful cycle.
+	Incremental bool
+	// FullSyncInterval is the time between the full cycles that reconcile every item of an incremental pair.
+	FullSyncInterval time.Duration
+	// DriftInterval is the time between the drift checks of the pair's mappings. They do not run when it is
+	// zero. DriftRepair repairs the drift they find instead of only reporting it.
+	DriftInterval time.Duration
+	DriftRepair   bool
+
+	// ADOConnection names the Azure DevOps organization the pair syncs with, DefaultConnection when empty.
+	ADOConnection  string
+	ADOProject     string
+	AsanaWorkspace string
+	// AsanaProject is the project tasks are created in when no route matches their work item.
+	AsanaProject string
+	// AsanaConnection names the Asana account the pair syncs with, DefaultConnection when empty.
+	AsanaConnection string
+	// Routes send the tasks of work items to other Asana projects by area path. The first matching route
+	// wins, and tasks are moved when the area path of their item changes.
+	Routes []Route
+	// Provision creates the Asana projects that routes refer to by name.
+	Provision ProvisionConfig
+	// Priority orders the work items of a cycle, boosting some ahead of those most recently changed.
+	Priority PriorityConfig
+	// MyTasks mirrors the work items of a set of users into their Asana My Tasks instead of a project.
+	MyTasks MyTasksConfig
+
+	// Direction is the default sync direction for every field.
+	Direction Direction
+	// FieldDirections overrides Direction for individual fields.
+	FieldDirections map[Field]Direction
+	// ConflictStrategy resolves bidirectional fields that changed on both sides.
+	ConflictStrategy ConflictStrategy
+	// CommentDirection controls comment mirroring. Comments are not synced when empty.
+	CommentDirection Direction
+	// AttachmentDirection controls attachment mirroring. Attachments are not synced when empty.
+	AttachmentDirection Direction
+	// MaxAttachmentSize is the largest attachment in bytes that is mirrored.
+	MaxAttachmentSize int64
+
+	// FieldMappings maps ADO fields onto Asana custom fields.
+	FieldMappings []FieldMapping
+	// ManageSchema creates the custom fields and enum options that field mappings refer to when a project
+	// lacks them, and leaves out the mappings of fields of another type with a warning instead of failing.
+	ManageSchema bool
+	// SectionMappings maps ADO states to the Asana section their tasks are moved into.
+	SectionMappings map[string]string
+	// Tags controls tag synchronization.
+	Tags TagConfig
+	// Sprints mirrors the iteration of work items in Asana.
+	Sprints SprintConfig
+	// Types holds the rules of the work item types synced differently from the rest of the pair.
+	Types []TypeRule
+	// Board mirrors the column and swimlane of work items on a Kanban board.
+	Board BoardConfig
+	// Hierarchy makes the tasks of child work items subtasks of the task of their parent.
+	Hierarchy bool
+	// Dependencies makes the tasks of work items depend on the tasks of their ADO predecessors.
+	Dependencies bool
+	// Links syncs the related, duplicate and blocked by links of work items.
+	Links LinkConfig
+	// Development adds the pull requests, commits and branches linked to work items to the notes of their
+	// task.
+	Development bool
+	// TestCases adds the steps of test cases, and the test cases linked to other work items, to the notes of
+	// their task as a checklist marked with the outcomes of the latest test run.
+	TestCases bool
+	// DueDates syncs the target date of work items, or the end date of their iteration, to the due date of
+	// their task. Asana edits are written back to the target date when the due field syncs from Asana.
+	DueDates bool
+	// Calendar is the time zone and working calendar due dates are translated with.
+	Calendar CalendarConfig
+	// Effort maps the completed and remaining work and the original estimate of work items onto Asana.
+	Effort EffortConfig
+	// Rollup sums the story points and remaining work of the children of work items onto the task of their
+	// parent.
+	Rollup RollupConfig
+	// StatusUpdates posts a status update on the Asana project summarizing the progress of the pair's work
+	// items.
+	StatusUpdates StatusUpdateConfig
+	// Retry controls the retries of work items whose sync failed with a transient error.
+	Retry RetryConfig
+	// ShutdownTimeout is how long the work items in flight when a cycle is stopped may take to finish,
+	// defaulting to DefaultShutdownTimeout.
+	ShutdownTimeout time.Duration
+	// Removal is applied to the tasks of work items that were deleted or no longer match the query.
+	Removal RemovalConfig
+	// Closing controls when the tasks of work items entering a completed state are completed.
+	Closing ClosingConfig
+	// Members controls how assignees who are not members of the Asana project of their task are handled.
+	Members MembersConfig
+	// Recurrence marks the work items whose every reopening gets a new task.
+	Recurrence RecurrenceConfig
+	// Transforms lists the transformers rewriting work items and tasks before they are synced, in order.
+	Transforms []transform.Spec
+	// UserMappings maps ADO unique names to Asana user GIDs for assignees whose email differs between the
+	// systems.
+	UserMappings map[string]string
+	// FuzzyUserMatching matches assignees without a mapping or equal email by mailbox name or display name.
+	FuzzyUserMatching bool
+
+	// NameTemplate is a text/template rendering the Asana task name from a TaskData. When empty, tasks are
+	// named "[AB#<id>] <title>".
+	NameTemplate string
+	// NotesTemplate is an html/template rendering the Asana task notes from a TaskData. When empty, the
+	// notes are left alone.
+	NotesTemplate string
+	// NotesFormat is how the description is given to the notes template, defaulting to NotesRich.
+	NotesFormat NotesFormat
+	// NotesOverflow is what is done with the part of a description that does not fit within NotesLimit,
+	// defaulting to OverflowTruncate.
+	NotesOverflow OverflowPolicy
+	// NotesLimit is the size in bytes of the largest html_notes written, defaulting to DefaultNotesLimit.
+	NotesLimit int
+
+	// Query is the WIQL query selecting the work items to sync. When empty, every work item
+	// assigned to a matching Asana user is synced. Its ORDER BY is replaced, since items are synced most
+	// recently changed first.
+	Query string
+
+	// DryRun records every write in the report's plan instead of performing it.
+	DryRun bool
+	// Audit records every write in the audit log of the store.
+	Audit bool
+	// AuditRetention is how long audit records are kept. They are kept forever when it is zero.
+	AuditRetention time.Duration
+	// Journal appends every change found on a work item or task to the change journal of the store before it
+	// is synced, so the changes can be replayed after a faulty mapping is repaired.
+	Journal bool
+	// JournalRetention is how long journal entries are kept. They are kept forever when it is zero.
+	JournalRetention time.Duration
+	// Analytics samples the state, points and dates of every new or changed work item into the store, for
+	// burndown and cycle time analytics exported without calling either API.
+	Analytics bool
+	// AnalyticsRetention is how long samples are kept. They are kept forever when it is zero.
+	AnalyticsRetention time.Duration
+
+	// States maps ADO states onto the completion and status of tasks. When it is empty, ClosedStates,
+	// ADOClosedState and ADOActiveState are used.
+	States StateMap
+	// ClosedStates are the ADO states treated as completed in Asana.
+	ClosedStates []string
+	// ADOClosedState is the state set on a work item when its Asana task is completed.
+	ADOClosedState string
+	// ADOActiveState is the state set on a work item when its Asana task is reopened.
+	ADOActiveState string
+
+	// OptOut is the marker that freezes the mapping of work items and tasks that carry it.
+	OptOut OptOut
+	// Intake creates work items for the Asana tasks marked for intake that have none.
+	Intake IntakeConfig
+	// Anchor is where tasks record the ID of their work item, so they are matched even when their name
+	// is edited: AnchorExternal, or the name or GID of a text or number custom field. Tasks are matched by
+	// the reference in their name when it is empty, and tasks without an anchor always are.
+	Anchor string
+	// BackLink is where work items link to their task: BackLinkHyperlink, or the reference name of an ADO
+	// field set to the permalink of the task. Work items are not linked when it is empty.
+	BackLink string
+}
+
+// DefaultConfig returns a Config with the default name, interval, direction and state names populated.
+func DefaultConfig() Config {
+	return Config{
+		Name:             "default",
+		Interval:         DefaultInterval,
+		Workers:          DefaultWorkers,
+		Prefetch:         DefaultPrefetch,
+		QueryPage:        DefaultQueryPage,
+		Direction:        ADOToAsana,
+		ConflictStrategy: ADOWins,
+		ClosedStates:     []string{"Closed", "Done", "Resolved", "Removed"},
+		ADOClosedState:   "Closed",
+		ADOActiveState:   "Active",
+
+		FuzzyUserMatching:  true,
+		MaxAttachmentSize:  DefaultMaxAttachmentSize,
+		Audit:              true,
+		AuditRetention:     DefaultAuditRetention,
+		JournalRetention:   DefaultJournalRetention,
+		AnalyticsRetention: DefaultAnalyticsRetention,
+		Retry: RetryConfig{
+			MaxAttempts: DefaultRetryAttempts,
+			Backoff:     DefaultRetryBackoff,
+			MaxBackoff:  DefaultRetryMaxBackoff,
+		},
+	}
+}
+
+// DirectionFor returns the direction used to sync field f.
+func (c Config) DirectionFor(f Field) Direction {
+	if d, ok := c.FieldDirections[f]; ok {
+		return d
+	}
+	if c.Direction == "" {
+		return ADOToAsana
+	}
+	return c.Direction
+}
+
+// isClosed reports whether state is one of the configured closed states.
+func (c Config) isClosed(state string) bool {
+	if len(c.States.States) > 0 {
+		return c.taskState(state).completed
+	}
+	for _, s := range c.ClosedStates {
+		if strings.EqualFold(s, state) {
+			return true
+		}
+	}
+	return false
+}
+
+// Engine runs sync cycles for a single sync pair.
+type Engine struct {
+	ado   ADO
+	asana Asana
+	store store.Store
+	// name is the name of the pair, which unlike the rest of cfg never changes.
+	name string
+	cfg  Config
+
+	// mu serializes sync cycles and single item syncs.
+	mu gosync.Mutex
+	// state guards targets, projects and orphans, which the workers of a cycle update concurrently.
+	state gosync.Mutex
+
+	// targets holds what was resolved on each Asana project of the pair, keyed by project GID.
+	targets map[string]*target
+	// sources lists the fields of the ADO project that field mappings are resolved against.
+	sources []sourceField
+	// projectGIDs lists the Asana projects of the pair, and provisioned maps the names of the projects it
+	// provisioned to their GIDs.
+	projectGIDs []string
+	provisioned map[string]string
+	// provision serializes the provisioning of projects.
+	provision gosync.Mutex
+	// tags caches the Asana tags of the workspace.
+	tags *tagCache
+	// templates holds the task templates parsed by Validate, and typeTemplates those of the work item types
+	// whose rule sets any, keyed by lower case type.
+	templates     *taskTemplates
+	typeTemplates map[string]*taskTemplates
+	// transforms holds the transformers built by Validate.
+	transforms transform.Chain
+	// board holds the fields of the board resolved by Validate, nil when the board is not synced.
+	board     *board
+	validated bool
+	// recovered is set once the mappings left pending by an earlier run have been reconciled.
+	recovered bool
+	// orphans holds the items of the current cycle whose parent was not mapped when they were synced.
+	orphans map[int]orphan
+	// blocked holds the items of the current cycle with predecessors that were not mapped when they were
+	// synced.
+	blocked map[int]blocked
+	// rollups holds the items of the current cycle whose children's sums are to be rolled up onto their task.
+	rollups map[int]bool
+
+	// users matches assignees to the Asana users of the workspace.
+	users *userDirectory
+	// iterationEnds maps lower case iteration paths to their end date when cfg.DueDates is set.
+	iterationEnds map[string]string
+	// calendar holds the calendar of the pair parsed by Validate.
+	calendar *calendar
+	// closes holds the work items whose task is held open after they closed.
+	closes pendingCloses
+
+	// adoCircuit and asanaCircuit are the circuit breakers of the API clients, when they have one.
+	adoCircuit, asanaCircuit Circuit
+
+	// budget counts the API requests of the pair against cfg.CallBudget.
+	budget *ratelimit.Allowance
+	// live tracks the progress of the running cycle.
+	live progress
+	// wake starts the next cycle of the loop running the engine early, see Manager.Trigger.
+	wake chan struct{}
+
+	// plan collects skipped writes when cfg.DryRun is set.
+	plan *Plan
+	// audit records the writes of the engine when cfg.Audit is set outside dry runs.
+	audit *auditor
+}
+
+// New returns an Engine syncing the pair described by cfg. In dry run mode writes to either system are
+// recorded in a plan instead, and st should be a copy such as one returned by store.Copy so the mappings
+// a run would create are visible to the rest of the run but never saved. Otherwise writes are recorded in
+// the audit log of st when cfg.Audit is set.
+func New(cfg Config, adoClient ADO, asanaClient Asana, st store.Store) *Engine {
+	e := &Engine{ado: adoClient, asana: asanaClient, store: st, name: cfg.Name, cfg: cfg, tags: newTagCache(),
+		wake: make(chan struct{}, 1), budget: ratelimit.NewAllowance(cfg.Name, cfg.CallBudget)}
+	switch {
+	case cfg.DryRun:
+		e.plan = &Plan{pair: cfg.Name}
+		e.ado = &planADO{ADO: adoClient, plan: e.plan, cfg: cfg}
+		e.asana = &planAsana{Asana: asanaClient, plan: e.plan, store: st, prefix: plannedPrefix + cfg.Name + "-"}
+	case cfg.Audit:
+		e.audit = &auditor{store: st, cfg: cfg}
+		e.ado = &auditADO{ADO: adoClient, audit: e.audit}
+		e.asana = &auditAsana{Asana: asanaClient, audit: e.audit}
+	}
+	return e
+}
+
+// Name returns the name of the engine's sync pair.
+func (e *Engine) Name() string {
+	return e.name
+}
+
+// Config returns the current configuration of the pair.
+func (e *Engine) Config() Config {
+	return e.config()
+}
+
+// config returns the configuration of the pair, for use outside cycles.
+func (e *Engine) config() Config {
+	e.state.Lock()
+	defer e.state.Unlock()
+	return e.cfg
+}
+
+// reload makes e sync with the configuration of n, a new engine of the same pair, once its current cycle
+// finished. The next cycle validates the configuration again.
+func (e *Engine) reload(n *Engine) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.state.Lock()
+	defer e.state.Unlock()
+	e.cfg, e.ado, e.asana, e.plan, e.audit = n.cfg, n.ado, n.asana, n.plan, n.audit
+	e.budget.SetCaps(n.cfg.CallBudget)
+	e.validated = false
+}
+
+// Close stops the transform plugins of the pair. The engine starts them again when it is used afterwards.
+func (e *Engine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.transforms.Close()
+}
+
+// WIQL returns the query selecting the work items of the pair.
+func (c Config) WIQL() string {
+	q := c.Query
+	if q == "" {
+		q = defaultQuery
+	}
+	if c.MyTasks.enabled() {
+		q = andWhere(q, c.MyTasks.condition())
+	}
+	return q
+}
+
+// defaultQuery selects every assigned work item in the project.
+const defaultQuery = "SELECT [System.Id] FROM WorkItems WHERE [System.TeamProject] = @project AND [System.AssignedTo] <> '' ORDER BY [System.ChangedDate] DESC"
+
+// pageSize is the number of work items fetched at a time.
+const pageSize = 200
+
+// DefaultWorkers is the number of work items synced concurrently by pairs that do not set Workers.
+const DefaultWorkers = 4
+
+// DefaultPrefetch is the number of pages of work items fetched ahead of the workers by pairs that do not set
+// Prefetch.
+const DefaultPrefetch = 2
+
+// DefaultQueryPage is the number of work item IDs read per query by pairs that do not set QueryPage, the most
+// ADO returns.
+const DefaultQueryPage = ado.MaxQueryResults
+
+// ValidateQuery checks that q looks like a WIQL work item query.
+func ValidateQuery(q string) error {
+	if q == "" {
+		return nil
+	}
+	if f := strings.Fields(q); len(f) == 0 || !strings.EqualFold(f[0], "SELECT") {
+		return fmt.Errorf("work item query must be a WIQL SELECT statement")
+	}
+	if !strings.Contains(strings.ToUpper(q), "FROM WORKITEMS") {
+		return fmt.Errorf("work item query must select FROM WorkItems")
+	}
+	return nil
+}
+
+// Run performs a single sync cycle and reports its outcome.
+func (e *Engine) Run(ctx context.Context) (*Report, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ctx, cancel := graceful(ctx, e.cfg.ShutdownTimeout)
+	defer cancel()
+	ctx = e.begin(ctx)
+	ctx, span := tracing.Tracer().Start(ctx, "sync.cycle", trace.WithAttributes(
+		attribute.String("sync.pair", e.cfg.Name),
+		attribute.String("ado.project", e.cfg.ADOProject),
+		attribute.String("asana.project", e.cfg.AsanaProject),
+	))
+	ctx, calls := metrics.WithCalls(ctx)
+	start := time.Now()
+	e.live.start()
+	rep, err := e.run(ctx)
+	e.live.stop()
+	metrics.CycleDuration.WithLabelValues(e.cfg.Name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		e.countError(err)
+	}
+	s := e.cycleStatus(ctx, start, rep, calls.Count(), err)
+	logging.From(ctx).Info("sync cycle summary", s.attrs()...)
+	if serr := e.saveStatus(ctx, s); serr != nil {
+		logging.From(ctx).Error("failed to record cycle status", "error", serr)
+	}
+	if e.audit != nil {
+		e.audit.prune(ctx)
+	}
+	e.pruneJournal(ctx)
+	e.pruneSamples(ctx)
+	tracing.End(span, err)
+	return rep, err
+}
+
+func (e *Engine) run(ctx context.Context) (*Report, error) {
+	rep := &Report{Plan: e.plan}
+	// Paused pairs neither read nor write, as their maintenance may be that of ADO or Asana.
+	if err := e.checkPaused(ctx); err != nil {
+		return nil, err
+	}
+	if provider := e.unavailable(); provider != "" {
+		return nil, e.degraded(ctx, provider)
+	}
+	metrics.Degraded.WithLabelValues(e.cfg.Name).Set(0)
+	if err := e.prepare(ctx); err != nil {
+		return nil, err
+	}
+	e.orphans, e.blocked, e.rollups = nil, nil, nil
+	if err := e.migrateAnchors(ctx); err != nil {
+		return nil, err
+	}
+	if err := e.recoverPending(ctx); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	since, full, err := e.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// A cycle interrupted by a shutdown is resumed: its pending items are synced with those that changed
+	// since it started.
+	cp, err := e.loadCycleCheckpoint(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if cp != nil {
+		start, since, full = cp.Started, cp.Started.Add(-watermarkSkew), cp.Full
+		logging.From(ctx).Info("resuming interrupted sync cycle", "started", cp.Started.Format(time.RFC3339), "pending", len(cp.Pending))
+	}
+	rep.Full = full
+	// Work items taken in from Asana are created first, so the query selects them for this cycle.
+	if rep.Intake, err = e.intake(ctx, since, full); err != nil {
+		return nil, err
+	}
+	query := byRecency(e.cfg.WIQL())
+	page := e.queryPage()
+
+	var idx *taskIndex
+	var order *cycleOrder
+	var sw *sweep
+	if full && cp == nil {
+		recent, err := e.ado.QueryTop(ctx, e.cfg.ADOProject, query, page)
+		if err != nil {
+			return nil, fmt.Errorf("querying work items: %w", err)
+		}
+		if sw, err = e.newSweep(ctx); err != nil {
+			return nil, err
+		}
+		if idx, err = e.indexTasks(ctx); err != nil {
+			return nil, err
+		}
+		order = newCycleOrder(e.prioritize(ctx, query, recent, recent))
+		if len(recent) < page {
+			ids := append([]int(nil), recent...)
+			sort.Ints(ids)
+			sw.pass(ids)
+			sw.end()
+		} else {
+			// A selection larger than a page syncs its most recently changed page first, then the rest by ID
+			// as it is read.
+			order.then(e.idPages(e.cfg.ADOProject, query, 0, sw))
+		}
+	} else {
+		changedQuery := changedSince(query, since)
+		changed, err := e.ado.QueryTop(ctx, e.cfg.ADOProject, changedQuery, page)
+		if err != nil {
+			return nil, fmt.Errorf("querying changed work items: %w", err)
+		}
+		tasks, err := e.listTasks(ctx, since)
+		if err != nil {
+			return nil, fmt.Errorf("listing modified asana tasks: %w", err)
+		}
+		modified, err := e.taskItems(ctx, tasks)
+		if err != nil {
+			return nil, err
+		}
+		// Items whose grace period passed have not changed, but their task is due to be completed.
+		due, err := e.dueCloses(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var pending []int
+		if cp != nil {
+			pending = cp.Pending
+		}
+		// The whole selection is followed, by ID, to tell which of the items changed in Asana or left over
+		// it holds, and which mapped items left it.
+		if sw, err = e.newSweep(ctx, modified, pending, due); err != nil {
+			return nil, err
+		}
+		if err := e.idPages(e.cfg.ADOProject, query, 0, sw).walk(ctx); err != nil {
+			return nil, fmt.Errorf("querying work items: %w", err)
+		}
+		ids := resumed(nil, changed, nil)
+		for _, more := range [][]int{modified, pending, due} {
+			ids = resumed(ids, more, sw)
+		}
+		idx = newTaskIndex(tasks, e.cfg)
+		idx.partial = true
+		logging.From(ctx).Info("incremental sync", "changed", len(ids), "since", since.Format(time.RFC3339))
+		order = newCycleOrder(e.prioritize(ctx, query, ids, changed))
+		if cp != nil && cp.Unread {
+			// The items the interrupted cycle did not read are all synced, so only those changed below them
+			// are read from the changed ones.
+			changedQuery = andWhere(changedQuery, fmt.Sprintf("[System.Id] <= %d", cp.After))
+		}
+		if len(changed) == page {
+			order.then(e.idPages(e.cfg.ADOProject, changedQuery, 0, nil))
+		}
+		if cp != nil && cp.Unread {
+			order.then(e.idPages(e.cfg.ADOProject, query, cp.After, nil))
+		}
+	}
+
+	if rep.Items, err = e.syncAll(ctx, order, idx, rep); err != nil {
+		var in *interruption
+		if errors.As(err, &in) {
+			return nil, e.interrupt(ctx, start, full, in, rep)
+		}
+		return nil, err
+	}
+
+	if err := e.linkOrphans(ctx); err != nil {
+		return nil, err
+	}
+	if err := e.linkBlocked(ctx); err != nil {
+		return nil, err
+	}
+	if err := e.rollUp(ctx, rep); err != nil {
+		return nil, err
+	}
+	if rep.Removed, err = e.reconcile(ctx, sw); err != nil {
+		return nil, err
+	}
+	// The status update only reports on the cycle, so failing to post it does not fail the cycle.
+	if err := e.postStatus(ctx); err != nil {
+		logging.From(ctx).Error("failed to post asana status update", "error", err)
+	}
+	if err := e.advance(ctx, start, full, rep); err != nil {
+		return nil, err
+	}
+	if cp != nil {
+		if err := e.store.SetSetting(ctx, cycleCheckpointKey+e.cfg.Name, ""); err != nil {
+			return nil, fmt.Errorf("clearing cycle checkpoint: %w", err)
+		}
+	}
+	return e.finish(ctx, rep)
+}
+
+// resumed adds the items of more that sw found selected to ids, unless ids holds them already. A nil sw
+// takes every item of more as selected.
+func resumed(ids, more []int, sw *sweep) []int {
+	seen := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		seen[id] = true
+	}
+	for _, id := range more {
+		if (sw == nil || sw.has(id)) && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// syncAll syncs the work items with the IDs of order, whose tasks are looked up in idx, and returns the
+// number of items synced. Pages are fetched ahead while a pool of workers syncs their items. A failed item
+// is recorded in the report and does not stop the others.
+func (e *Engine) syncAll(ctx context.Context, order *cycleOrder, idx *taskIndex, rep *Report) (int, error) {
+	workers := e.cfg.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	prefetch := e.cfg.Prefetch
+	if prefetch <= 0 {
+		prefetch = DefaultPrefetch
+	}
+	queue := make(chan ado.WorkItem)
+	// Items that ran out of call budget are left to the next cycle, as are those not dispatched yet.
+	exhausted := make(chan struct{})
+	var once gosync.Once
+	var mu gosync.Mutex
+	var deferred []int
+	var wg gosync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range queue {
+				err := e.syncListed(ctx, item, idx, rep)
+				if errors.Is(err, ratelimit.ErrBudgetExhausted) {
+					mu.Lock()
+					deferred = append(deferred, item.ID)
+					mu.Unlock()
+					once.Do(func() {
+						logging.From(ctx).Warn("api call budget exhausted, leaving the remaining work items to the next cycle", "error", err)
+						close(exhausted)
+					})
+					continue
+				}
+				if err != nil {
+					logging.From(ctx).With(ErrorAttrs(err)...).Error("failed to sync work item", logging.KeyWorkItem, item.ID)
+					e.countError(err)
+					rep.fail(item.ID, err)
+					e.failed(ctx, item.ID, err)
+					e.live.done(true)
+					continue
+				}
+				e.succeeded(ctx, item.ID)
+				e.live.done(false)
+			}
+		}()
+	}
+	n, err := e.enqueue(ctx, order, prefetch, queue, exhausted)
+	close(queue)
+	wg.Wait()
+	if len(deferred) == 0 {
+		return n, err
+	}
+	var in *interruption
+	if !errors.As(err, &in) {
+		if err != nil {
+			return n, err
+		}
+		in = &interruption{cause: ratelimit.ErrBudgetExhausted}
+	}
+	in.pending = append(in.pending, deferred...)
+	return n, in
+}
+
+// page is a page of the work items of a cycle, or the error fetching it.
+type page struct {
+	ids   []int
+	items []ado.WorkItem
+	err   error
+}
+
+// fetchPages fetches the work items with the IDs of order a page at a time and sends the pages to the
+// returned channel, which holds at most prefetch pages, so fetching waits for the workers and the items of a
+// cycle are never all held at once. It stops after a page that failed to be read or fetched, or once done is
+// closed, and then closes the channel.
+func (e *Engine) fetchPages(ctx context.Context, order *cycleOrder, prefetch int, done <-chan struct{}) <-chan page {
+	pages := make(chan page, prefetch)
+	go func() {
+		defer close(pages)
+		for {
+			ids, err := order.next(ctx, pageSize)
+			if err != nil {
+				err = fmt.Errorf("querying work items: %w", err)
+			} else if len(ids) == 0 {
+				return
+			}
+			e.live.add(len(ids))
+			var items []ado.WorkItem
+			if err == nil {
+				if items, err = e.ado.GetWorkItems(ctx, ids); err == nil {
+					metrics.ItemsScanned.WithLabelValues(e.cfg.Name).Add(float64(len(items)))
+				} else {
+					err = fmt.Errorf("fetching work items: %w", err)
+				}
+			}
+			select {
+			case pages <- page{ids: ids, items: items, err: err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return pages
+}
+
+// enqueue sends the work items with the IDs of order to queue as fetchPages fetches them, up to prefetch
+// pages ahead. It returns the number of items sent, stopping with an interruption listing the items left when
+// the cycle is stopped or exhausted is closed. Pages fetched but not sent are left with them.
+func (e *Engine) enqueue(ctx context.Context, order *cycleOrder, prefetch int, queue chan<- ado.WorkItem, exhausted <-chan struct{}) (n int, err error) {
+	done := make(chan struct{})
+	pages := e.fetchPages(ctx, order, prefetch, done)
+	// stop ends the fetching and waits for the fetch in flight, so no request of the cycle outlives it. It
+	// adds the IDs of the pages fetched but not sent to in, followed by those read but not fetched, and
+	// records where the order stopped reading the rest.
+	stopped := false
+	stop := func(in *interruption) *interruption {
+		stopped = true
+		close(done)
+		for p := range pages {
+			in.pending = append(in.pending, p.ids...)
+		}
+		in.pending = append(in.pending, order.remaining()...)
+		in.after, in.unread = order.unread()
+		return in
+	}
+	defer func() {
+		if !stopped {
+			stop(&interruption{})
+		}
+	}()
+	for p := range pages {
+		if errors.Is(p.err, ratelimit.ErrBudgetExhausted) {
+			return n, stop(&interruption{pending: append([]int(nil), p.ids...), cause: p.err})
+		}
+		if p.err != nil {
+			return n, p.err
+		}
+		for i, item := range p.items {
+			var in *interruption
+			select {
+			case queue <- item:
+				n++
+				continue
+			case <-ctx.Done():
+				return n, ctx.Err()
+			case <-stopping(ctx):
+				in = &interruption{}
+			case <-exhausted:
+				in = &interruption{cause: ratelimit.ErrBudgetExhausted}
+			}
+			for _, item := range p.items[i:] {
+				in.pending = append(in.pending, item.ID)
+			}
+			return n, stop(in)
+		}
+	}
+	return n, nil
+}
+
+// syncListed syncs an item selected by the cycle's query, unless another pair owns it.
+func (e *Engine) syncListed(ctx context.Context, item ado.WorkItem, idx *taskIndex, rep *Report) error {
+	m, err := e.store.Get(ctx, item.ID)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+	case err != nil:
+		return err
+	case !e.owns(m):
+		logging.From(ctx).Debug("skipping work item synced by another pair", logging.KeyWorkItem, item.ID, "owner", m.Pair)
+		return nil
+	}
+	task := idx.find(m, item.ID)
+	if task == nil && idx.partial && m.AsanaGID != "" {
+		// Incremental cycles only list modified tasks, so the task of an item changed in ADO is fetched.
+		if task, err = e.asana.GetTask(ctx, m.AsanaGID); err != nil && !isNotFound(err) {
+			return fmt.Errorf("fetching asana task %s: %w", m.AsanaGID, err)
+		}
+	}
+	return e.process(ctx, item, task, rep)
+}
+
+// SyncItem syncs the single work item with the given ID without running a full cycle.
+func (e *Engine) SyncItem(ctx context.Context, adoID int) (*Report, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ctx = e.begin(ctx)
+	ctx, span := tracing.Tracer().Start(ctx, "sync.targeted", trace.WithAttributes(
+		attribute.String("sync.pair", e.cfg.Name),
+		attribute.Int("ado.id", adoID),
+	))
+	if err := e.checkPaused(ctx); err != nil {
+		// The item syncs once the pause ends, as its retry is only due then.
+		if errors.Is(err, ErrPaused) {
+			e.queue(ctx, []int{adoID}, ErrPaused.Error())
+		}
+		tracing.End(span, err)
+		return nil, err
+	}
+	rep, err := e.syncOne(ctx, adoID)
+	if err != nil {
+		e.countError(err)
+		e.failed(ctx, adoID, err)
+	} else {
+		e.succeeded(ctx, adoID)
+	}
+	tracing.End(span, err)
+	return rep, err
+}
+
+// recreateKey is the context key marking a targeted sync that creates a new task for an unmapped work item.
+type recreateKey struct{}
+
+// Recreate syncs the work item with the given ID like SyncItem, except that an unmapped item gets a new task
+// rather than adopting the one carrying its ID, such as the task of a mapping that was deleted on purpose.
+func (e *Engine) Recreate(ctx context.Context, adoID int) (*Report, error) {
+	return e.SyncItem(context.WithValue(ctx, recreateKey{}, true), adoID)
+}
+
+func (e *Engine) syncOne(ctx context.Context, adoID int) (*Report, error) {
+	rep := &Report{Plan: e.plan}
+	if err := e.prepare(ctx); err != nil {
+		return nil, err
+	}
+	e.orphans, e.blocked, e.rollups = nil, nil, nil
+	// A targeted sync may be the first of the engine, so mappings left pending by a crash are settled before
+	// they are read.
+	if err := e.recoverPending(ctx); err != nil {
+		return nil, err
+	}
+	if err := e.syncID(ctx, adoID, rep); err != nil {
+		return nil, err
+	}
+	if err := e.linkOrphans(ctx); err != nil {
+		return nil, err
+	}
+	if err := e.linkBlocked(ctx); err != nil {
+		return nil, err
+	}
+	if err := e.rollUp(ctx, rep); err != nil {
+		return nil, err
+	}
+	return e.finish(ctx, rep)
+}
+
+// syncID fetches the work item with the given ID and its task and syncs them.
+func (e *Engine) syncID(ctx context.Context, adoID int, rep *Report) error {
+	items, err := e.ado.GetWorkItems(ctx, []int{adoID})
+	if err != nil {
+		return fmt.Errorf("fetching work item %d: %w", adoID, err)
+	}
+	if len(items) == 0 {
+		// A deleted work item has its task handled by the removal policy.
+		if m, err := e.store.Get(ctx, adoID); err == nil && m.Pair == e.cfg.Name && e.cfg.Removal.active() {
+			if err := e.remove(ctx, m); err != nil {
+				return fmt.Errorf("removing work item %d: %w", adoID, err)
+			}
+			rep.Removed++
+			return nil
+		}
+		return fmt.Errorf("work item %d not found", adoID)
+	}
+	metrics.ItemsScanned.WithLabelValues(e.cfg.Name).Inc()
+
+	var task *asana.Task
+	switch m, err := e.store.Get(ctx, adoID); {
+	case errors.Is(err, store.ErrNotFound), err == nil && m.AsanaGID == "":
+	case err != nil:
+		return err
+	default:
+		if task, err = e.asana.GetTask(ctx, m.AsanaGID); err != nil && !isNotFound(err) {
+			return fmt.Errorf("fetching asana task %s: %w", m.AsanaGID, err)
+		}
+	}
+	if task == nil && ctx.Value(recreateKey{}) == nil {
+		// Unmapped items may still have a legacy task carrying their ID in its name.
+		idx, err := e.indexTasks(ctx)
+		if err != nil {
+			return err
+		}
+		task = idx.byADOID[adoID]
+	}
+
+	if err := e.process(ctx, items[0], task, rep); err != nil {
+		return fmt.Errorf("syncing work item %d: %w", adoID, err)
+	}
+	return nil
+}
+
+// finish persists the store and completes rep with the queued conflicts.
+func (e *Engine) finish(ctx context.Context, rep *Report) (*Report, error) {
+	if err := e.store.Flush(ctx); err != nil {
+		return nil, fmt.Errorf("saving mappings: %w", err)
+	}
+	conflicts, err := e.store.Conflicts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rep.Conflicts = conflicts
+	return rep, nil
+}
+
+// ErrNotMapped is returned by SyncTask for tasks that are not mapped to a work item.
+var ErrNotMapped = errors.New("not mapped to a work item")
+
+// SyncTask syncs the work item mapped to the Asana task with the given GID.
+func (e *Engine) SyncTask(ctx context.Context, taskGID string) (*Report, error) {
+	m, err := e.store.ByAsanaGID(ctx, taskGID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, fmt.Errorf("asana task %s: %w", taskGID, ErrNotMapped)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e.SyncItem(ctx, m.ADOID)
+}
+
+// prepare validates the configuration on first use and refreshes the Asana user directory and the iteration
+// dates.
+func (e *Engine) prepare(ctx context.Context) error {
+	if !e.validated {
+		if err := e.Validate(ctx); err != nil {
+			return err
+		}
+	}
+	users, err := e.asana.WorkspaceUsers(ctx, e.cfg.AsanaWorkspace)
+	if err != nil {
+		return fmt.Errorf("listing asana users: %w", err)
+	}
+	e.users = newUserDirectory(ctx, users, e.cfg)
+	if err := e.validateFallback(); err != nil {
+		return err
+	}
+	e.checkMirrors(ctx)
+	if e.cfg.DueDates {
+		if e.iterationEnds, err = e.loadIterations(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// process syncs item with task, which is nil when the item has no Asana task yet.
+func (e *Engine) process(ctx context.Context, item ado.WorkItem, task *asana.Task, rep *Report) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "sync.item", trace.WithAttributes(attribute.Int("ado.id", item.ID)))
+	ctx = logging.With(ctx, logging.KeyWorkItem, item.ID)
+	if task != nil {
+		span.SetAttributes(attribute.String("asana.gid", task.GID))
+		ctx = logging.With(ctx, logging.KeyTask, task.GID)
+	}
+	ctx = withSubject(ctx, &item, task)
+	defer func() { tracing.End(span, err) }()
+
+	if err := e.journal(ctx, item, task); err != nil {
+		return err
+	}
+	if item, task, err = e.transform(ctx, item, task); err != nil {
+		return err
+	}
+	if e.cfg.skipped(item) {
+		logging.From(ctx).Debug("skipping work item: its type is not synced", "type", item.Type())
+		rep.count(&rep.Skipped)
+		return nil
+	}
+	if out, err := e.optOut(ctx, item, task); out || err != nil {
+		if err == nil {
+			rep.count(&rep.Skipped)
+		}
+		return err
+	}
+	// With the default query only items assigned to a known Asana user are synced, unless a fallback
+	// assignee takes their tasks. A custom query selects items itself, so unmatched items are synced
+	// without an assignee, except into My Tasks, which need one.
+	user, _ := e.users.match(item.AssignedTo())
+	if user == nil && ((e.cfg.Query == "" && e.cfg.Members.Fallback == "") || e.cfg.MyTasks.enabled()) {
+		if assignee := item.AssignedTo(); assignee != nil {
+			logging.From(ctx).Info("skipping work item: no asana user matches the assignee", "assignee", assignee.UniqueName)
+		}
+		rep.count(&rep.Skipped)
+		return nil
+	}
+	if err := e.sample(ctx, item); err != nil {
+		return err
+	}
+	return e.syncFresh(ctx, item, task, user, rep)
+}
+
+// taskIndex looks up the tasks of the Asana projects.
+type taskIndex struct {
+	byGID   map[string]*asana.Task
+	byADOID map[int]*asana.Task
+	// partial is set when the index holds only the tasks modified since the last cycle.
+	partial bool
+}
+
+// indexTasks lists the tasks of the Asana projects and indexes them by GID and referenced work item.
+func (e *Engine) indexTasks(ctx context.Context) (*taskIndex, error) {
+	tasks, err := e.listTasks(ctx, time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("listing asana tasks: %w", err)
+	}
+	return newTaskIndex(tasks, e.cfg), nil
+}
+
+// newTaskIndex indexes tasks by GID and the work item they belong to under c. A task anchored to a work
+// item wins over one that only references it in its name.
+func newTaskIndex(tasks []asana.Task, c Config) *taskIndex {
+	idx := &taskIndex{byGID: make(map[string]*asana.Task, len(tasks)), byADOID: map[int]*asana.Task{}}
+	for i := range tasks {
+		t := &tasks[i]
+		idx.byGID[t.GID] = t
+		if _, ok := c.anchorOf(t); ok {
+			continue
+		}
+		if id, ok := parseTaskID(t.Name); ok {
+			preferOpen(idx.byADOID, id, t)
+		}
+	}
+	anchored := map[int]*asana.Task{}
+	for i := range tasks {
+		if id, ok := c.anchorOf(&tasks[i]); ok {
+			preferOpen(anchored, id, &tasks[i])
+		}
+	}
+	for id, t := range anchored {
+		idx.byADOID[id] = t
+	}
+	return idx
+}
+
+// preferOpen indexes t in byADOID as the task of the work item id, unless it is completed and an open task
+// already is, such as when t is the task of an earlier occurrence of a recurring item.
+func preferOpen(byADOID map[int]*asana.Task, id int, t *asana.Task) {
+	if prev := byADOID[id]; prev == nil || prev.Completed || !t.Completed {
+		byADOID[id] = t
+	}
+}
+
+// find returns the task of mapping m, falling back to a task referencing the work item by name.
+// m is the zero Mapping when the work item is not mapped.
+func (idx *taskIndex) find(m store.Mapping, adoID int) *asana.Task {
+	if t := idx.byGID[m.AsanaGID]; m.AsanaGID != "" && t != nil {
+		return t
+	}
+	return idx.byADOID[adoID]
+}
+
+// owns reports whether m belongs to the engine's pair. Mappings recorded before pairs were named belong to every pair.
+func (e *Engine) owns(m store.Mapping) bool {
+	return m.Pair == "" || m.Pair == e.cfg.Name
+}
+
+// isNotFound reports whether err is a 404 from either API.
+func isNotFound(err error) bool {
+	var ae *asana.Error
+	if errors.As(err, &ae) {
+		return ae.StatusCode == http.StatusNotFound
+	}
+	var de *ado.Error
+	if errors.As(err, &de) {
+		return de.StatusCode == http.StatusNotFound
+	}
+	return false
+}
+
+// syncItem brings a single work item and its Asana task into step, creating the task when it does not exist.
+// A nil user leaves the task unassigned.
+func (e *Engine) syncItem(ctx context.Context, item ado.WorkItem, task *asana.Task, user *asana.User, rep *Report) error {
+	project, err := e.projectFor(ctx, item)
+	if err != nil {
+		return err
+	}
+	want, err := e.closingState(ctx, item, task, e.wantState(project, item))
+	if err != nil {
+		return err
+	}
+	want = e.duplicateState(item, want)
+	if task, err = e.occurrence(ctx, item, task, want); err != nil {
+		return err
+	}
+
+	if task == nil {
+		if project == "" && !e.cfg.MyTasks.enabled() {
+			logging.From(ctx).Info("skipping work item: no route matches its area", "area", item.String(ado.FieldAreaPath))
+			return nil
+		}
+		values, err := e.customFieldValues(project, item, nil)
+		if err != nil {
+			return err
+		}
+		if values, err = e.sprintValue(ctx, project, item, nil, values); err != nil {
+			return err
+		}
+		if values, err = e.boardValues(ctx, project, item, nil, values); err != nil {
+			return err
+		}
+		values = e.statusValue(project, want, values)
+		values = e.effortValues(project, item, values)
+		name, err := e.templatesFor(item).taskName(item)
+		if err != nil {
+			return err
+		}
+		req := asana.TaskRequest{
+			Name:         asana.String(name),
+			Completed:    asana.Bool(want.completed),
+			Projects:     []string{project},
+			CustomFields: values,
+		}
+		e.anchor(project, item.ID, nil, &req)
+		var cut bool
+		if e.templatesFor(item).customNotes() {
+			notes, truncated, err := e.renderNotes(ctx, item, "")
+			if err != nil {
+				return err
+			}
+			req.HTMLNotes = asana.String(notes)
+			cut = truncated && e.cfg.NotesOverflow != OverflowTruncate
+		}
+		var development string
+		if e.cfg.Development {
+			if development = e.development(ctx, item); development != "" {
+				notes := ""
+				if req.HTMLNotes != nil {
+					notes = *req.HTMLNotes
+				}
+				req.HTMLNotes = asana.String(withDevelopment(notes, development))
+			}
+		}
+		var tests string
+		if e.cfg.TestCases {
+			if tests = e.tests(ctx, item); tests != "" {
+				notes := ""
+				if req.HTMLNotes != nil {
+					notes = *req.HTMLNotes
+				}
+				req.HTMLNotes = asana.String(withTests(notes, tests))
+			}
+		}
+		links, err := e.links(ctx, item)
+		if err != nil {
+			return err
+		}
+		if links != "" {
+			notes := ""
+			if req.HTMLNotes != nil {
+				notes = *req.HTMLNotes
+			}
+			req.HTMLNotes = asana.String(withLinks(notes, links))
+		}
+		a, err := e.assign(ctx, project, item, user)
+		if err != nil {
+			return err
+		}
+		if a.user != nil {
+			req.Assignee = asana.String(a.user.GID)
+		}
+		if e.cfg.DueDates && e.dueDate(item) != "" {
+			req.DueOn = asana.DateOf(e.dueDate(item))
+		}
+		e.cfg.subtypeRequest(item, &req)
+		if e.cfg.MyTasks.enabled() {
+			// A task in no project lands in the My Tasks of its assignee.
+			req.Projects, req.Workspace = nil, e.cfg.AsanaWorkspace
+		}
+		if err := e.beginCreate(ctx, item); err != nil {
+			return err
+		}
+		created, err := e.createTask(ctx, req)
+		if err != nil {
+			return fmt.Errorf("creating asana task: %w", err)
+		}
+		if err := e.created(ctx, item, created); err != nil {
+			return err
+		}
+		ctx = withSubject(logging.With(ctx, logging.KeyTask, created.GID), &item, created)
+		logging.From(ctx).Info("created asana task")
+		metrics.TasksCreated.WithLabelValues(e.cfg.Name).Inc()
+		rep.count(&rep.Created)
+		if ops := e.backLinkOps(item, created); len(ops) > 0 {
+			updated, err := e.updateWorkItem(ctx, item, ops)
+			if err != nil {
+				return fmt.Errorf("linking work item to asana task: %w", err)
+			}
+			item = *updated
+		}
+		if e.templatesFor(item).customNotes() && (e.hasImages(item) || cut) {
+			// Inline images and the rest of a cut description are added to the task once it exists.
+			notes, err := e.notes(ctx, item, created.GID)
+			if err != nil {
+				return err
+			}
+			notes = withLinks(withTests(withDevelopment(notes, development), tests), links)
+			if created, err = e.updateTask(ctx, created.GID, asana.TaskRequest{HTMLNotes: asana.String(notes)}); err != nil {
+				return fmt.Errorf("updating asana task notes: %w", err)
+			}
+			req.HTMLNotes = asana.String(notes)
+		}
+		if err := e.assigned(ctx, item, created, a); err != nil {
+			return err
+		}
+		if err := e.syncSection(ctx, project, item, created); err != nil {
+			return err
+		}
+		if err := e.syncParent(ctx, item, created); err != nil {
+			return err
+		}
+		if err := e.syncDependencies(ctx, item, created); err != nil {
+			return err
+		}
+		e.noteRollup(project, item, created)
+		_, tags, err := e.syncTags(ctx, item, created, nil, true)
+		if err != nil {
+			return err
+		}
+		if err := e.syncTypeTags(ctx, item, created); err != nil {
+			return err
+		}
+		notes := ""
+		if req.HTMLNotes != nil {
+			notes = notesHash(*req.HTMLNotes)
+		}
+		if err := e.record(ctx, item, created, tags, notes); err != nil {
+			return err
+		}
+		return e.syncExtras(ctx, item, created, changes{ado: true})
+	}
+
+	m, err := e.store.Get(ctx, item.ID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+	mapped := err == nil
+	var prev *store.Mapping
+	if project == "" {
+		// Items routed nowhere keep their task where it is.
+		project = e.projectIn(task)
+	} else if err := e.rehome(ctx, item, task, project); err != nil {
+		return err
+	}
+	if mapped {
+		prev = &m
+	}
+	ch := changes{
+		ado:   !mapped || item.Rev != m.ADORev,
+		asana: mapped && task.ModifiedAt.After(m.AsanaModified),
+	}
+	if r, ok := replaying(ctx); ok {
+		// A replayed journal entry syncs the changes it recorded, whatever the mapping holds now.
+		ch = r
+	}
+
+	var req asana.TaskRequest
+	var ops []ado.PatchOperation
+	taskChanged := false
+
+	name, err := e.templatesFor(item).taskName(item)
+	if err != nil {
+		return err
+	}
+	switch title := taskTitle(task.Name); {
+	case e.templatesFor(item).customName():
+		// A rendered name cannot be turned back into a title, so it is only synced from ADO.
+		if task.Name != name {
+			req.Name = asana.String(name)
+			taskChanged = true
+		}
+	case title != item.Title():
+		switch s, ok := e.pick(ctx, FieldTitle, item, task, ch, item.Title(), title, rep); {
+		case !ok:
+		case s == sideADO:
+			req.Name = asana.String(name)
+			taskChanged = true
+		default:
+			ops = append(ops, ado.SetField(ado.FieldTitle, title))
+		}
+	default:
+		e.clearConflict(ctx, item.ID, FieldTitle)
+		if task.Name == name {
+			break
+		}
+		// A task taken in from Asana, or one whose reference was edited away, gets the work item reference
+		// when its title syncs from ADO.
+		if s, ok := e.pick(ctx, FieldTitle, item, task, ch, name, task.Name, rep); ok && s == sideADO {
+			req.Name = asana.String(name)
+			taskChanged = true
+		}
+	}
+
+	// The notes are rendered from the work item, so they are only rewritten when it changed.
+	if ch.ado && e.templatesFor(item).customNotes() {
+		notes, err := e.notes(ctx, item, task.GID)
+		if err != nil {
+			return err
+		}
+		req.HTMLNotes = asana.String(notes)
+		taskChanged = true
+	}
+	switch notes, ok, err := e.developmentNotes(ctx, item, task, req.HTMLNotes, ch); {
+	case err != nil:
+		return err
+	case ok:
+		req.HTMLNotes = asana.String(notes)
+		taskChanged = true
+	}
+	switch notes, ok, err := e.testNotes(ctx, item, task, req.HTMLNotes, ch); {
+	case err != nil:
+		return err
+	case ok:
+		req.HTMLNotes = asana.String(notes)
+		taskChanged = true
+	}
+	switch notes, ok, err := e.linkNotes(ctx, item, task, req.HTMLNotes, ch); {
+	case err != nil:
+		return err
+	case ok:
+		req.HTMLNotes = asana.String(notes)
+		taskChanged = true
+	}
+
+	if e.syncSubtype(ctx, item, task, &req) {
+		taskChanged = true
+	}
+	// The status is written with the custom fields below.
+	var status taskState
+	if approvals := e.cfg.approvals(item); len(approvals) > 0 {
+		// The approval status of approval tasks stands for their completion and status.
+		approvalOps, approvalChanged := e.syncApproval(ctx, approvals, item, task, ch, &req, rep)
+		ops = append(ops, approvalOps...)
+		taskChanged = taskChanged || approvalChanged
+	} else if have := e.taskState(project, task); !want.equal(have) {
+		switch s, ok := e.pick(ctx, FieldState, item, task, ch, want.String(), have.String(), rep); {
+		case !ok:
+		case s == sideADO:
+			if want.completed != have.completed {
+				req.Completed = asana.Bool(want.completed)
+				taskChanged = true
+			}
+			if !strings.EqualFold(want.status, have.status) {
+				status = want
+			}
+		default:
+			if state := e.cfg.adoState(have); state != "" && !strings.EqualFold(state, item.State()) {
+				ops = append(ops, ado.SetField(ado.FieldState, state))
+			}
+		}
+	} else {
+		e.clearConflict(ctx, item.ID, FieldState)
+	}
+
+	if e.cfg.DueDates {
+		if due := e.dueDate(item); due != task.DueOn {
+			switch s, ok := e.pick(ctx, FieldDueDate, item, task, ch, due, task.DueOn, rep); {
+			case !ok:
+			case s == sideADO:
+				req.DueOn = asana.DateOf(due)
+				taskChanged = true
+			default:
+				if op, ok := e.targetDateOp(item, task.DueOn); ok {
+					ops = append(ops, op)
+				}
+			}
+		} else {
+			e.clearConflict(ctx, item.ID, FieldDueDate)
+		}
+	}
+
+	a, err := e.assign(ctx, project, item, user)
+	if err != nil {
+		return err
+	}
+	if a.user != nil && (task.Assignee == nil || task.Assignee.GID != a.user.GID) {
+		req.Assignee = asana.String(a.user.GID)
+		taskChanged = true
+	} else {
+		// The intended owner was named when the task was assigned to the fallback assignee.
+		a.note = ""
+	}
+
+	values, fieldOps, err := e.syncFieldMappings(ctx, project, item, task, ch, rep)
+	if err != nil {
+		return err
+	}
+	ops = append(ops, fieldOps...)
+	if values, err = e.sprintValue(ctx, project, item, task, values); err != nil {
+		return err
+	}
+	if values, err = e.boardValues(ctx, project, item, task, values); err != nil {
+		return err
+	}
+	values = e.statusValue(project, status, values)
+	if len(values) > 0 {
+		req.CustomFields = values
+		taskChanged = true
+	}
+	effortOps, effortChanged := e.syncEffort(ctx, project, item, task, ch, &req, rep)
+	ops = append(ops, effortOps...)
+	taskChanged = taskChanged || effortChanged
+	if e.anchor(project, item.ID, task, &req) {
+		taskChanged = true
+	}
+
+	tagOps, tags, err := e.syncTags(ctx, item, task, prev, false)
+	if err != nil {
+		return err
+	}
+	if err := e.syncTypeTags(ctx, item, task); err != nil {
+		return err
+	}
+	ops = append(ops, tagOps...)
+	ops = append(ops, e.backLinkOps(item, task)...)
+
+	// Only the fields that differ from the task are written.
+	notes := ""
+	if prev != nil {
+		notes = prev.NotesHash
+	}
+	var fields []string
+	if taskChanged {
+		fields = pruneRequest(task, &req, notes)
+		taskChanged = len(fields) > 0
+	}
+	if req.HTMLNotes != nil {
+		notes = notesHash(*req.HTMLNotes)
+	}
+	// Neither side is written when the other changed since it was read.
+	if taskChanged {
+		if err := e.checkFresh(ctx, task, req); err != nil {
+			return err
+		}
+	}
+	if len(ops) > 0 {
+		updated, err := e.updateWorkItem(ctx, item, ops)
+		if err != nil {
+			return fmt.Errorf("updating work item: %w", err)
+		}
+		logging.From(ctx).Info("updated work item from asana task")
+		metrics.WorkItemsUpdated.WithLabelValues(e.cfg.Name).Inc()
+		item = *updated
+	}
+	if taskChanged {
+		updated, err := e.updateTask(ctx, task.GID, req)
+		if err != nil {
+			return fmt.Errorf("updating asana task: %w", err)
+		}
+		logging.From(ctx).Info("updated asana task from work item", "fields", fields)
+		metrics.TasksUpdated.WithLabelValues(e.cfg.Name).Inc()
+		task = updated
+	}
+	if len(ops) > 0 || taskChanged {
+		rep.count(&rep.Updated)
+	}
+	if err := e.assigned(ctx, item, task, a); err != nil {
+		return err
+	}
+	if err := e.syncSection(ctx, project, item, task); err != nil {
+		return err
+	}
+	if err := e.syncParent(ctx, item, task); err != nil {
+		return err
+	}
+	if err := e.syncDependencies(ctx, item, task); err != nil {
+		return err
+	}
+	e.noteRollup(project, item, task)
+	if err := e.record(ctx, item, task, tags, notes); err != nil {
+		return err
+	}
+	return e.syncExtras(ctx, item, task, ch)
+}
+
+// syncExtras mirrors the comments and attachments of a mapped item.
+func (e *Engine) syncExtras(ctx context.Context, item ado.WorkItem, task *asana.Task, ch changes) error {
+	if err := e.syncComments(ctx, item, task, ch); err != nil {
+		return err
+	}
+	return e.syncAttachments(ctx, item, task, ch)
+}
+
+// record stores the mapping between item and task as of now, along with the synced tags and the hash of the
+// notes last written to the task.
+func (e *Engine) record(ctx context.Context, item ado.WorkItem, task *asana.Task, tags []string, notes string) error {
+	return e.store.Put(ctx, store.Mapping{
+		ADOID:         item.ID,
+		ADORev:        item.Rev,
+		ADOChanged:    item.ChangedDate(),
+		AsanaGID:      task.GID,
+		AsanaModified: task.ModifiedAt,
+		Title:         item.Title(),
+		Completed:     task.Completed,
+		LastSynced:    time.Now().UTC(),
+		Pair:          e.cfg.Name,
+		Tags:          tags,
+		NotesHash:     notes,
+	})
+}
+
+// taskPrefix matches the work item reference at the start of an Asana task name.
+var taskPrefix = regexp.MustCompile(`^\[AB#(\d+)\]\s*`)
+
+// taskName returns the Asana task name for item.
+func taskName(item ado.WorkItem) string {
+	return fmt.Sprintf("[AB#%d] %s", item.ID, item.Title())
+}
+
+// taskTitle returns the task name without its work item reference.
+func taskTitle(name string) string {
+	return taskPrefix.ReplaceAllString(name, "")
+}
+
+// parseTaskID returns the work item ID referenced in an Asana task name.
+func parseTaskID(name string) (int, bool) {
+	m := taskPrefix.FindStringSubmatch(name)
+	if m == nil {
+		return 0, false
+	}
+	id, err := strconv.Atoi(m[1])
+	return id, err == nil
+}
+
+// transform returns item and task as rewritten by the transformers of the pair. They work on copies, so the
+// work items and tasks listed for the cycle are left as read. task may be nil.
+func (e *Engine) transform(ctx context.Context, item ado.WorkItem, task *asana.Task) (ado.WorkItem, *asana.Task, error) {
+	if len(e.transforms) == 0 {
+		return item, task, nil
+	}
+	fields := make(map[string]interface{}, len(item.Fields))
+	for k, v := range item.Fields {
+		fields[k] = v
+	}
+	item.Fields = fields
+	if err := e.transforms.WorkItem(ctx, &item); err != nil {
+		return item, task, withCode(CodeTransformFailed, fmt.Errorf("transforming work item: %w", err))
+	}
+	task, err := e.transformTask(ctx, task)
+	return item, task, err
+}
+
+// transformTask returns a copy of task rewritten by the transformers of the pair, or nil when task is nil.
+func (e *Engine) transformTask(ctx context.Context, task *asana.Task) (*asana.Task, error) {
+	if len(e.transforms) == 0 || task == nil {
+		return task, nil
+	}
+	t := *task
+	t.Tags = append([]asana.Tag(nil), task.Tags...)
+	t.CustomFields = append([]asana.CustomField(nil), task.CustomFields...)
+	t.Memberships = append([]asana.Membership(nil), task.Memberships...)
+	if err := e.transforms.Task(ctx, &t); err != nil {
+		return task, withCode(CodeTransformFailed, fmt.Errorf("transforming asana task: %w", err))
+	}
+	return &t, nil
+}
@@ -0,0 +1,445 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/richtext"
+	"github.com/danstis/ado-asana-sync/internal/transform"
+)
+
+// FieldType is the type a mapped value is coerced to before it is written to Asana.
+type FieldType string
+
+// Supported field types.
+const (
+	TypeText   FieldType = "text"
+	TypeNumber FieldType = "number"
+	TypeEnum   FieldType = "enum"
+	TypeDate   FieldType = "date"
+)
+
+// FieldMapping maps an ADO work item field onto an Asana custom field.
+type FieldMapping struct {
+	// Source is the ADO field reference name, for example Microsoft.VSTS.Scheduling.StoryPoints or
+	// Custom.ReleaseTrain, or its display name.
+	Source string `json:"source"`
+	// Target is the name or GID of the Asana custom field.
+	Target string `json:"target"`
+	// Type is the Asana custom field type. When empty it follows the ADO field: picklists are enums, integer
+	// and double fields numbers, dateTime fields dates and the others text.
+	Type FieldType `json:"type,omitempty"`
+	// Values translates ADO values to Asana enum option names. Unlisted values are used as is.
+	Values map[string]string `json:"values,omitempty"`
+	// Default is the enum option of ADO values that match no option.
+	Default string `json:"default,omitempty"`
+	// Unknown is what happens to ADO values that match no enum option when there is no Default, UnknownFail
+	// when empty.
+	Unknown UnknownValue `json:"unknown,omitempty"`
+	// Direction is the sync direction of the field, ADOToAsana when empty. Fields syncing from Asana write
+	// the task's value back to the work item.
+	Direction Direction `json:"direction,omitempty"`
+	// Reverse translates Asana enum option names to the ADO values written back. Options it does not list
+	// get the ADO value that Values maps to them, or their name when none does.
+	Reverse map[string]string `json:"reverse,omitempty"`
+}
+
+// Validate checks the mapping is complete and uses a supported type.
+func (m FieldMapping) Validate() error {
+	if m.Source == "" {
+		return fmt.Errorf("field mapping to %q has no source field", m.Target)
+	}
+	if m.Target == "" {
+		return fmt.Errorf("field mapping from %q has no target field", m.Source)
+	}
+	switch m.Type {
+	case "", TypeText, TypeNumber, TypeEnum, TypeDate:
+	default:
+		return fmt.Errorf("field mapping %s -> %s has unsupported type %q", m.Source, m.Target, m.Type)
+	}
+	if (len(m.Values) > 0 || len(m.Reverse) > 0 || m.Default != "" || m.Unknown != "") && m.Type != TypeEnum && m.Type != "" {
+		return fmt.Errorf("field mapping %s -> %s: values, reverse, default and unknown are only supported for enum fields", m.Source, m.Target)
+	}
+	if _, err := ParseUnknownValue(string(m.Unknown)); err != nil {
+		return fmt.Errorf("field mapping %s -> %s: %w", m.Source, m.Target, err)
+	}
+	d, err := ParseDirection(string(m.Direction))
+	if err != nil {
+		return fmt.Errorf("field mapping %s -> %s: %w", m.Source, m.Target, err)
+	}
+	if d != ADOToAsana && m.Type == TypeDate {
+		return fmt.Errorf("field mapping %s -> %s: date fields only sync ado-to-asana", m.Source, m.Target)
+	}
+	return nil
+}
+
+// resolvedField is a field mapping bound to an Asana custom field on the target project.
+type resolvedField struct {
+	FieldMapping
+	// adoType is the type of the values of the ADO field.
+	adoType string
+	gid     string
+	options map[string]string // lower case option name -> option GID
+	// reverse maps lower case option names to the ADO values written back, for enum fields syncing from
+	// Asana.
+	reverse map[string]string
+}
+
+// Validate resolves the field mappings, the sprint field and mapped sections against each Asana project of
+// the pair and parses the task templates, failing when a target field is missing or its type does not match
+// the mapping.
+func (e *Engine) Validate(ctx context.Context) error {
+	if err := e.cfg.ValidateRoutes(); err != nil {
+		return err
+	}
+	if err := e.cfg.ValidateProvision(); err != nil {
+		return err
+	}
+	if err := e.cfg.ValidateSprints(); err != nil {
+		return err
+	}
+	if err := e.cfg.ValidateTypes(); err != nil {
+		return err
+	}
+	if err := e.cfg.ValidateStates(); err != nil {
+		return err
+	}
+	if err := e.cfg.ValidateEffort(); err != nil {
+		return err
+	}
+	if err := e.cfg.ValidateRollup(); err != nil {
+		return err
+	}
+	if err := e.cfg.ValidateClosing(); err != nil {
+		return err
+	}
+	if err := e.cfg.ValidateBoard(); err != nil {
+		return err
+	}
+	if err := e.cfg.ValidateIntake(); err != nil {
+		return err
+	}
+	if err := e.cfg.ValidateMyTasks(); err != nil {
+		return err
+	}
+	cal, err := e.cfg.Calendar.parse()
+	if err != nil {
+		return fmt.Errorf("calendar: %w", err)
+	}
+	if err := e.validateReachable(ctx); err != nil {
+		return err
+	}
+	if e.sources, err = e.sourceFields(ctx); err != nil {
+		return err
+	}
+	projects := e.cfg.projects()
+	provisioned := map[string]string{}
+	if e.cfg.Provision.Enabled {
+		registered, err := e.store.Projects(ctx)
+		if err != nil {
+			return fmt.Errorf("listing provisioned projects: %w", err)
+		}
+		for _, p := range registered {
+			if p.Pair == e.cfg.Name {
+				provisioned[p.Name] = p.GID
+				projects = append(projects, p.GID)
+			}
+		}
+	}
+	targets := make(map[string]*target, len(projects))
+	var resolved []*target
+	for _, p := range projects {
+		t, err := e.resolveTarget(ctx, p)
+		if err != nil {
+			return err
+		}
+		t.share(resolved)
+		targets[p] = t
+		resolved = append(resolved, t)
+	}
+	templates, err := parseTemplates(e.cfg)
+	if err != nil {
+		return err
+	}
+	typeTemplates, err := e.parseTypeTemplates()
+	if err != nil {
+		return err
+	}
+	transforms, err := transform.NewChain(e.cfg.Transforms)
+	if err != nil {
+		return err
+	}
+	board, err := e.resolveBoard(ctx)
+	if err != nil {
+		return err
+	}
+	e.targets, e.projectGIDs, e.provisioned, e.validated = targets, projects, provisioned, true
+	e.calendar = cal
+	// Plugins of the previous configuration are stopped, so reloads do not leave processes behind.
+	old := e.transforms
+	e.templates, e.typeTemplates, e.transforms, e.board = templates, typeTemplates, transforms, board
+	if err := old.Close(); err != nil {
+		logging.From(ctx).Warn("failed to stop transform plugins", "error", err)
+	}
+	return nil
+}
+
+// resolveTarget resolves the field mappings, the sprint, status, anchor, effort and board fields, the
+// sections and the members of the Asana project.
+func (e *Engine) resolveTarget(ctx context.Context, project string) (*target, error) {
+	fields, typed, err := e.resolveFields(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+	sprint, err := e.resolveSprintField(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+	status, err := e.resolveStatusField(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+	anchor, err := e.resolveAnchorField(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+	effort, err := e.resolveEffortFields(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+	rollup, err := e.resolveRollupFields(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+	sections, err := e.loadSections(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+	members, err := e.resolveMembers(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+	board, err := e.resolveBoardFields(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+	return &target{fields: fields, typeFields: typed, sections: sections, sprintField: sprint, statusField: status, anchorField: anchor, effort: effort, rollup: rollup,
+		members: members, board: board}, nil
+}
+
+// resolveFields resolves every field mapping, those of the pair and those of its type rules, against the
+// custom fields of the Asana project, first managing them when the pair manages the schema.
+func (e *Engine) resolveFields(ctx context.Context, project string) ([]resolvedField, map[string][]resolvedField, error) {
+	if len(e.cfg.FieldMappings) == 0 && !e.cfg.typedFields() {
+		return nil, nil, nil
+	}
+	fields, err := e.asana.ProjectCustomFields(ctx, project)
+	if err != nil {
+		return nil, nil, fmt.Errorf("listing asana custom fields: %w", err)
+	}
+	var mismatched map[string]bool
+	if e.cfg.ManageSchema {
+		if fields, mismatched, err = e.manageSchema(ctx, project, fields); err != nil {
+			return nil, nil, err
+		}
+	}
+	resolved, err := resolveMappings(withoutTargets(e.cfg.FieldMappings, mismatched), e.sources, fields, project)
+	if err != nil {
+		return nil, nil, err
+	}
+	typed, err := e.resolveTypeFields(fields, project, mismatched)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resolved, typed, nil
+}
+
+// resolveMappings resolves the field mappings against sources, the fields of the ADO project, and fields,
+// the custom fields of the Asana project.
+func resolveMappings(mappings []FieldMapping, sources []sourceField, fields []asana.CustomField, project string) ([]resolvedField, error) {
+	if len(mappings) == 0 {
+		return nil, nil
+	}
+	resolved := make([]resolvedField, 0, len(mappings))
+	for _, m := range mappings {
+		m, source, err := m.bind(sources)
+		if err != nil {
+			return nil, err
+		}
+		cf, ok := findCustomField(fields, m.Target)
+		if !ok {
+			return nil, withCode(CodeFieldMissing, fmt.Errorf("field mapping %s -> %s: custom field not found on asana project %s", m.Source, m.Target, project))
+		}
+		if cf.ResourceSubtype != string(m.Type) {
+			return nil, withCode(CodeFieldMissing, fmt.Errorf("field mapping %s -> %s: asana field is %s, not %s", m.Source, m.Target, cf.ResourceSubtype, m.Type))
+		}
+		r := resolvedField{FieldMapping: m, adoType: source.Type, gid: cf.GID}
+		if m.Type == TypeEnum {
+			r.options = make(map[string]string, len(cf.EnumOptions))
+			for _, o := range cf.EnumOptions {
+				r.options[strings.ToLower(o.Name)] = o.GID
+			}
+			for from, to := range m.Values {
+				if _, ok := r.options[strings.ToLower(to)]; !ok {
+					return nil, withCode(CodeFieldMissing, fmt.Errorf("field mapping %s -> %s: value %q maps to unknown option %q", m.Source, m.Target, from, to))
+				}
+			}
+			if _, ok := r.options[strings.ToLower(m.Default)]; m.Default != "" && !ok {
+				return nil, withCode(CodeFieldMissing, fmt.Errorf("field mapping %s -> %s: default is the unknown option %q", m.Source, m.Target, m.Default))
+			}
+			reverse, err := reverseValues(m, r.options)
+			if err != nil {
+				return nil, err
+			}
+			r.reverse = reverse
+		}
+		resolved = append(resolved, r)
+	}
+	return resolved, nil
+}
+
+// findCustomField returns the custom field whose GID or name matches target.
+func findCustomField(fields []asana.CustomField, target string) (asana.CustomField, bool) {
+	for _, f := range fields {
+		if f.GID == target {
+			return f, true
+		}
+	}
+	for _, f := range fields {
+		if strings.EqualFold(f.Name, target) {
+			return f, true
+		}
+	}
+	return asana.CustomField{}, false
+}
+
+// customFieldValues returns the values of the custom fields of project for item, keyed by custom field GID.
+// Only fields whose value differs from the one on task are returned; task may be nil.
+func (e *Engine) customFieldValues(project string, item ado.WorkItem, task *asana.Task) (map[string]interface{}, error) {
+	var values map[string]interface{}
+	for _, f := range e.target(project).fieldsFor(item) {
+		v, err := f.coerce(item.Fields[f.Source])
+		if err != nil && !errors.Is(err, errKeep) {
+			return nil, withCode(CodeInvalidValue, fmt.Errorf("field %s: %w", f.Source, err))
+		}
+		if errors.Is(err, errKeep) || task != nil && f.equal(task.CustomFields, v) {
+			continue
+		}
+		if values == nil {
+			values = map[string]interface{}{}
+		}
+		values[f.gid] = v
+	}
+	return values, nil
+}
+
+// coerce converts an ADO field value into the value written to the Asana custom field.
+// A nil result clears the field, and errKeep leaves it as it is.
+func (f resolvedField) coerce(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	switch f.Type {
+	case TypeNumber:
+		switch n := v.(type) {
+		case float64:
+			return n, nil
+		case bool:
+			if n {
+				return 1.0, nil
+			}
+			return 0.0, nil
+		case string:
+			if n == "" {
+				return nil, nil
+			}
+			return strconv.ParseFloat(n, 64)
+		}
+		return nil, fmt.Errorf("cannot convert %v to a number", v)
+	case TypeDate:
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot convert %v to a date", v)
+		}
+		if s == "" {
+			return nil, nil
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, err
+		}
+		return asana.DateValue{Date: t.Format("2006-01-02")}, nil
+	case TypeEnum:
+		s := text(v)
+		if name, ok := f.Values[s]; ok {
+			s = name
+		}
+		gid, ok := f.options[strings.ToLower(s)]
+		if !ok && f.Default != "" {
+			gid, ok = f.options[strings.ToLower(f.Default)]
+		}
+		if ok {
+			return gid, nil
+		}
+		switch f.Unknown {
+		case UnknownClear:
+			return nil, nil
+		case UnknownKeep:
+			return nil, errKeep
+		}
+		return nil, fmt.Errorf("no enum option matches %q", s)
+	default:
+		if f.adoType == ado.FieldTypeHTML {
+			return richtext.PlainText(text(v)), nil
+		}
+		return text(v), nil
+	}
+}
+
+// equal reports whether the task already holds value v for the field.
+func (f resolvedField) equal(fields []asana.CustomField, v interface{}) bool {
+	for _, cf := range fields {
+		if cf.GID != f.gid {
+			continue
+		}
+		switch f.Type {
+		case TypeNumber:
+			n, _ := v.(float64)
+			return (v == nil && cf.NumberValue == nil) || (cf.NumberValue != nil && v != nil && *cf.NumberValue == n)
+		case TypeDate:
+			d, _ := v.(asana.DateValue)
+			return (v == nil && cf.DateValue == nil) || (cf.DateValue != nil && v != nil && cf.DateValue.Date == d.Date)
+		case TypeEnum:
+			gid, _ := v.(string)
+			return (v == nil && cf.EnumValue == nil) || (cf.EnumValue != nil && cf.EnumValue.GID == gid)
+		default:
+			s, _ := v.(string)
+			return (cf.TextValue == nil && s == "") || (cf.TextValue != nil && *cf.TextValue == s)
+		}
+	}
+	return false
+}
+
+// text renders an ADO field value as text. Identity fields render as the display name.
+func text(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case map[string]interface{}:
+		if name, ok := t["displayName"].(string); ok {
+			return name
+		}
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,99 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// orphan is a task whose work item has a parent that was not mapped to a task when the item was synced.
+type orphan struct {
+	taskGID   string
+	parentID  int
+	curParent string
+}
+
+// syncParent makes task a subtask of the task mapped to the parent of item, following re-parenting in ADO.
+// Tasks whose work item lost its parent are moved back to the top level, unless their current parent is not
+// a synced task. When the parent is not mapped yet the item is remembered so a cycle can link it once the
+// parent has been synced.
+func (e *Engine) syncParent(ctx context.Context, item ado.WorkItem, task *asana.Task) error {
+	if !e.cfg.Hierarchy {
+		return nil
+	}
+	cur := ""
+	if task.Parent != nil {
+		cur = task.Parent.GID
+	}
+
+	parentID, ok := item.ParentID()
+	if !ok {
+		if cur == "" {
+			return nil
+		}
+		if _, err := e.store.ByAsanaGID(ctx, cur); errors.Is(err, store.ErrNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return e.setParent(ctx, task.GID, cur, "")
+	}
+
+	m, err := e.store.Get(ctx, parentID)
+	if errors.Is(err, store.ErrNotFound) || err == nil && m.AsanaGID == "" {
+		// A parent with a pending mapping has no task to move under yet.
+		e.state.Lock()
+		defer e.state.Unlock()
+		if e.orphans == nil {
+			e.orphans = map[int]orphan{}
+		}
+		e.orphans[item.ID] = orphan{taskGID: task.GID, parentID: parentID, curParent: cur}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return e.setParent(ctx, task.GID, cur, m.AsanaGID)
+}
+
+// linkOrphans links the tasks remembered by syncParent whose parent has since been mapped. It runs once the
+// workers of a cycle are done.
+func (e *Engine) linkOrphans(ctx context.Context) error {
+	for id, o := range e.orphans {
+		ctx := logging.With(ctx, logging.KeyWorkItem, id, logging.KeyTask, o.taskGID)
+		m, err := e.store.Get(ctx, o.parentID)
+		if errors.Is(err, store.ErrNotFound) || err == nil && m.AsanaGID == "" {
+			logging.From(ctx).Info("not linking task to its parent: parent is not synced", "parent_id", o.parentID)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := e.setParent(ctx, o.taskGID, o.curParent, m.AsanaGID); err != nil {
+			return err
+		}
+	}
+	e.orphans = nil
+	return nil
+}
+
+// setParent moves the task from the parent cur to the parent want.
+func (e *Engine) setParent(ctx context.Context, taskGID, cur, want string) error {
+	if cur == want {
+		return nil
+	}
+	if err := e.asana.SetParent(ctx, taskGID, want); err != nil {
+		return fmt.Errorf("setting parent of asana task %s: %w", taskGID, err)
+	}
+	if want == "" {
+		logging.From(ctx).Info("moved asana task to the top level")
+	} else {
+		logging.From(ctx).Info("made asana task a subtask", "parent_gid", want)
+	}
+	return nil
+}
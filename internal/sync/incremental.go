@@ -0,0 +1,130 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// DefaultFullSyncInterval is the time between full reconciliation cycles of incremental pairs that do not
+// set FullSyncInterval.
+const DefaultFullSyncInterval = 24 * time.Hour
+
+// Store settings holding the incremental sync state of a pair. The pair name is appended to each key.
+const (
+	// watermarkKey holds the time changes were last fetched from, in RFC 3339 format.
+	watermarkKey = "sync_watermark:"
+	// fullSyncKey holds the start time of the last successful full cycle.
+	fullSyncKey = "sync_full:"
+)
+
+// watermarkSkew is subtracted from the start of a cycle when it becomes the watermark, so changes saved
+// while the cycle was listing items and small clock differences between the systems are not missed.
+const watermarkSkew = time.Minute
+
+// scope returns the time an incremental cycle fetches changes from. full is set when the cycle must
+// reconcile every item instead: incremental sync is disabled, the pair has no watermark yet, or its last
+// full cycle is older than the full sync interval.
+func (e *Engine) scope(ctx context.Context) (since time.Time, full bool, err error) {
+	if !e.cfg.Incremental {
+		return time.Time{}, true, nil
+	}
+	since, err = e.timeSetting(ctx, watermarkKey)
+	if err != nil || since.IsZero() {
+		return time.Time{}, true, err
+	}
+	last, err := e.timeSetting(ctx, fullSyncKey)
+	if err != nil {
+		return time.Time{}, true, err
+	}
+	interval := e.cfg.FullSyncInterval
+	if interval <= 0 {
+		interval = DefaultFullSyncInterval
+	}
+	if time.Since(last) >= interval {
+		return time.Time{}, true, nil
+	}
+	return since, false, nil
+}
+
+// advance records the watermark of a cycle that started at start. Cycles with failed items keep the previous
+// watermark so those items are fetched again.
+func (e *Engine) advance(ctx context.Context, start time.Time, full bool, rep *Report) error {
+	if !e.cfg.Incremental || len(rep.Failures) > 0 {
+		return nil
+	}
+	if err := e.store.SetSetting(ctx, watermarkKey+e.cfg.Name, start.Add(-watermarkSkew).UTC().Format(time.RFC3339)); err != nil {
+		return fmt.Errorf("saving sync watermark: %w", err)
+	}
+	if full {
+		if err := e.store.SetSetting(ctx, fullSyncKey+e.cfg.Name, start.UTC().Format(time.RFC3339)); err != nil {
+			return fmt.Errorf("saving full sync time: %w", err)
+		}
+	}
+	return nil
+}
+
+// timeSetting returns the time stored in the pair's setting key, or the zero time when it is not set.
+func (e *Engine) timeSetting(ctx context.Context, key string) (time.Time, error) {
+	v, err := e.store.Setting(ctx, key+e.cfg.Name)
+	if errors.Is(err, store.ErrNotFound) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		logging.From(ctx).Warn("ignoring invalid setting", "setting", key+e.cfg.Name, "value", v)
+		return time.Time{}, nil
+	}
+	return t, nil
+}
+
+// orderBy matches the ORDER BY clause ending a WIQL query.
+var orderBy = regexp.MustCompile(`(?is)\s+ORDER\s+BY\s.*$`)
+
+// whereClause matches the WHERE keyword of a WIQL query.
+var whereClause = regexp.MustCompile(`(?i)\sWHERE\s+`)
+
+// changedSince restricts the WIQL query q to work items changed since the given time.
+func changedSince(q string, since time.Time) string {
+	return andWhere(q, fmt.Sprintf("[System.ChangedDate] >= '%s'", since.UTC().Format(time.RFC3339)))
+}
+
+// andWhere restricts the WIQL query q to the work items cond holds for.
+func andWhere(q, cond string) string {
+	order := orderBy.FindString(q)
+	q = q[:len(q)-len(order)]
+	if i := whereClause.FindStringIndex(q); i != nil {
+		q = q[:i[1]] + "(" + q[i[1]:] + ") AND " + cond
+	} else {
+		q += " WHERE " + cond
+	}
+	return q + order
+}
+
+// taskItems returns the IDs of the work items whose task is in tasks, which changed in Asana, in the order
+// of the tasks.
+func (e *Engine) taskItems(ctx context.Context, tasks []asana.Task) ([]int, error) {
+	var ids []int
+	for _, t := range tasks {
+		id, ok := e.cfg.taskID(&t)
+		switch m, err := e.store.ByAsanaGID(ctx, t.GID); {
+		case err == nil:
+			id, ok = m.ADOID, true
+		case !errors.Is(err, store.ErrNotFound):
+			return nil, err
+		}
+		if ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
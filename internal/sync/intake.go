@@ -0,0 +1,160 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/metrics"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// DefaultIntakeType is the type of the work items created for Asana tasks when intake does not set one.
+const DefaultIntakeType = "Task"
+
+// IntakeConfig creates work items in ADO for the Asana tasks marked for intake that are not mapped to one,
+// after which they sync like any other. Intake is off when neither Tag nor Section is set.
+type IntakeConfig struct {
+	// Tag marks the tasks taken in by the Asana tag they carry.
+	Tag string `json:"tag,omitempty"`
+	// Section marks the tasks taken in by the section they are in, in any project of the pair.
+	Section string `json:"section,omitempty"`
+	// Type is the type of the work items created, DefaultIntakeType when empty.
+	Type string `json:"type,omitempty"`
+	// AreaPath and IterationPath are set on the work items created. ADO uses the project defaults when
+	// they are empty.
+	AreaPath      string `json:"area_path,omitempty"`
+	IterationPath string `json:"iteration_path,omitempty"`
+	// Fields maps the names of Asana custom fields onto the reference names of the ADO fields their value is
+	// written to, such as System.AreaPath. A field mapped to System.WorkItemType picks the type of the item.
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// enabled reports whether tasks are taken in.
+func (c IntakeConfig) enabled() bool {
+	return c.Tag != "" || c.Section != ""
+}
+
+// marks reports whether the task is marked for intake.
+func (c IntakeConfig) marks(task asana.Task) bool {
+	if c.Tag != "" && hasTag(&task, c.Tag) {
+		return true
+	}
+	if c.Section != "" {
+		for _, m := range task.Memberships {
+			if m.Section != nil && strings.EqualFold(m.Section.Name, c.Section) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ValidateIntake checks the field mappings of intake.
+func (c Config) ValidateIntake() error {
+	for name, field := range c.Intake.Fields {
+		if strings.TrimSpace(name) == "" || strings.TrimSpace(field) == "" {
+			return fmt.Errorf("intake field mapping %q -> %q needs both an asana and an ado field", name, field)
+		}
+	}
+	return nil
+}
+
+// intake creates a work item for every task of the pair marked for intake that has no work item, listing
+// the tasks modified since the given time unless full is set, and maps them to each other. Tasks whose work
+// item cannot be created are logged and tried again in the next cycle.
+func (e *Engine) intake(ctx context.Context, since time.Time, full bool) (int, error) {
+	if !e.cfg.Intake.enabled() {
+		return 0, nil
+	}
+	if full {
+		since = time.Time{}
+	}
+	tasks, err := e.listTasks(ctx, since)
+	if err != nil {
+		return 0, fmt.Errorf("listing asana tasks for intake: %w", err)
+	}
+	n := 0
+	for _, task := range tasks {
+		if !e.cfg.Intake.marks(task) || task.Completed {
+			continue
+		}
+		if _, ok := parseTaskID(task.Name); ok {
+			continue
+		}
+		if _, ok := e.cfg.anchorOf(&task); ok {
+			continue
+		}
+		switch _, err := e.store.ByAsanaGID(ctx, task.GID); {
+		case err == nil:
+			continue
+		case !errors.Is(err, store.ErrNotFound):
+			return n, err
+		}
+		task := task
+		tctx := logging.With(ctx, logging.KeyTask, task.GID)
+		if err := e.takeIn(tctx, &task); err != nil {
+			logging.From(tctx).With(ErrorAttrs(err)...).Error("failed to create work item for asana task")
+			e.countError(err)
+			continue
+		}
+		n++
+	}
+	return n, nil
+}
+
+// takeIn creates the work item of task and records their mapping.
+func (e *Engine) takeIn(ctx context.Context, task *asana.Task) error {
+	in := e.cfg.Intake
+	typ := in.Type
+	if typ == "" {
+		typ = DefaultIntakeType
+	}
+	ops := []ado.PatchOperation{ado.SetField(ado.FieldTitle, taskTitle(task.Name))}
+	if task.Notes != "" {
+		ops = append(ops, ado.SetField(ado.FieldDescription, strings.ReplaceAll(html.EscapeString(task.Notes), "\n", "<br>")))
+	}
+	if in.AreaPath != "" {
+		ops = append(ops, ado.SetField(ado.FieldAreaPath, in.AreaPath))
+	}
+	if in.IterationPath != "" {
+		ops = append(ops, ado.SetField(ado.FieldIterationPath, in.IterationPath))
+	}
+	if task.Assignee != nil && task.Assignee.Email != "" {
+		ops = append(ops, ado.SetField(ado.FieldAssignedTo, task.Assignee.Email))
+	}
+	for _, cf := range task.CustomFields {
+		field, ok := lookupFold(in.Fields, cf.Name)
+		v := customFieldText(cf)
+		switch {
+		case !ok || v == "":
+		case strings.EqualFold(field, ado.FieldWorkItemType):
+			typ = v
+		default:
+			ops = append(ops, ado.SetField(field, v))
+		}
+	}
+	item, err := e.ado.CreateWorkItem(ctx, e.cfg.ADOProject, typ, ops)
+	if err != nil {
+		return fmt.Errorf("creating %s work item: %w", typ, err)
+	}
+	logging.From(ctx).Info("created work item for asana task", logging.KeyWorkItem, item.ID, "type", typ)
+	metrics.WorkItemsCreated.WithLabelValues(e.cfg.Name).Inc()
+	return e.record(ctx, *item, task, nil, "")
+}
+
+// lookupFold returns the value of the key of m equal to key ignoring case.
+func lookupFold(m map[string]string, key string) (string, bool) {
+	for k, v := range m {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return "", false
+}
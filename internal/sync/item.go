@@ -0,0 +1,186 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// ItemState is a work item and the task mapped to it as read from both systems, so the effect of a sync
+// can be shown by comparing the states from before and after it.
+type ItemState struct {
+	// Pair is the name of the pair syncing the work item.
+	Pair     string
+	WorkItem *ado.WorkItem
+	// Task is the mapped task, nil when the work item is not mapped or its task is gone.
+	Task *asana.Task
+}
+
+// ItemChange is a field of a work item or its task that differs between two states.
+type ItemChange struct {
+	// System is SystemADO or SystemAsana.
+	System string
+	store.FieldChange
+}
+
+// ItemState reads the state of the work item with the given ID in the organization at orgURL, and of its
+// task, through the clients of the pair responsible for it. orgURL is resolved as by SyncItem.
+func (m *Manager) ItemState(ctx context.Context, orgURL string, adoID int) (*ItemState, error) {
+	e, err := m.itemEngine(ctx, orgURL, adoID)
+	if err != nil {
+		return nil, err
+	}
+	return e.ItemState(ctx, adoID)
+}
+
+// TaskItem returns the organization URL and ID of the work item mapped to the Asana task with the given GID.
+func (m *Manager) TaskItem(ctx context.Context, taskGID string) (string, int, error) {
+	for _, name := range m.connections("") {
+		mp, err := m.conns[name].Store.ByAsanaGID(ctx, taskGID)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return "", 0, err
+		}
+		return m.conns[name].OrgURL, mp.ADOID, nil
+	}
+	return "", 0, fmt.Errorf("asana task %s: %w", taskGID, ErrNotMapped)
+}
+
+// ItemState reads the state of the work item with the given ID and of its task.
+func (e *Engine) ItemState(ctx context.Context, adoID int) (*ItemState, error) {
+	items, err := e.ado.GetWorkItems(ctx, []int{adoID})
+	if err != nil {
+		return nil, fmt.Errorf("fetching work item %d: %w", adoID, err)
+	}
+	s := &ItemState{Pair: e.Name()}
+	if len(items) > 0 {
+		s.WorkItem = &items[0]
+	}
+	switch mp, err := e.store.Get(ctx, adoID); {
+	case errors.Is(err, store.ErrNotFound):
+	case err != nil:
+		return nil, err
+	default:
+		t, err := e.asana.GetTask(ctx, mp.AsanaGID)
+		if err != nil && !isNotFound(err) {
+			return nil, fmt.Errorf("fetching task %s: %w", mp.AsanaGID, err)
+		}
+		s.Task = t
+	}
+	return s, nil
+}
+
+// Diff returns the fields of the work item and task that differ between s and after, ADO fields first, each
+// system's sorted by name. A task created in between shows every field it has set.
+func (s *ItemState) Diff(after *ItemState) []ItemChange {
+	var changes []ItemChange
+	add := func(system string, before, after map[string]string) {
+		names := make([]string, 0, len(before)+len(after))
+		for name := range before {
+			names = append(names, name)
+		}
+		for name := range after {
+			if _, ok := before[name]; !ok {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if before[name] != after[name] {
+				changes = append(changes, ItemChange{System: system, FieldChange: store.FieldChange{Field: name, Before: before[name], After: after[name]}})
+			}
+		}
+	}
+	add(SystemADO, itemFields(s.WorkItem), itemFields(after.WorkItem))
+	add(SystemAsana, taskFields(s.Task), taskFields(after.Task))
+	return changes
+}
+
+// itemFields renders the fields and links of a work item as text by name.
+func itemFields(wi *ado.WorkItem) map[string]string {
+	fields := map[string]string{}
+	if wi == nil {
+		return fields
+	}
+	for name, v := range wi.Fields {
+		fields[name] = text(v)
+	}
+	links := map[string][]string{}
+	for _, r := range wi.Relations {
+		links[r.Rel] = append(links[r.Rel], r.URL)
+	}
+	for rel, urls := range links {
+		sort.Strings(urls)
+		fields["relation:"+rel] = strings.Join(urls, ", ")
+	}
+	return fields
+}
+
+// taskFields renders the synced fields of a task as text by name.
+func taskFields(t *asana.Task) map[string]string {
+	fields := map[string]string{}
+	if t == nil {
+		return fields
+	}
+	set := func(name, v string) {
+		if v != "" {
+			fields[name] = v
+		}
+	}
+	set("gid", t.GID)
+	set("name", t.Name)
+	set("notes", t.Notes)
+	set("completed", strconv.FormatBool(t.Completed))
+	set("due_on", t.DueOn)
+	set("resource_subtype", t.ResourceSubtype)
+	set("approval_status", t.ApprovalStatus)
+	if t.Assignee != nil {
+		set("assignee", userText(*t.Assignee))
+	}
+	if t.Parent != nil {
+		set("parent", t.Parent.GID)
+	}
+	for _, m := range t.Memberships {
+		if m.Project != nil && m.Section != nil {
+			set("section:"+m.Project.GID, m.Section.Name)
+		}
+	}
+	for _, cf := range t.CustomFields {
+		set("custom_field:"+cf.Name, customFieldText(cf))
+	}
+	var tags, deps, followers []string
+	for _, tag := range t.Tags {
+		tags = append(tags, tag.Name)
+	}
+	for _, d := range t.Dependencies {
+		deps = append(deps, d.GID)
+	}
+	for _, u := range t.Followers {
+		followers = append(followers, userText(u))
+	}
+	for name, values := range map[string][]string{"tags": tags, "dependencies": deps, "followers": followers} {
+		sort.Strings(values)
+		set(name, strings.Join(values, ", "))
+	}
+	return fields
+}
+
+// userText renders an Asana user as text, by email when it is known.
+func userText(u asana.User) string {
+	if u.Email != "" {
+		return u.Email
+	}
+	if u.Name != "" {
+		return u.Name
+	}
+	return u.GID
+}
@@ -0,0 +1,198 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/store"
+	"github.com/danstis/ado-asana-sync/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultJournalRetention is how long journal entries are kept by pairs that do not set JournalRetention.
+const DefaultJournalRetention = 30 * 24 * time.Hour
+
+// ErrNoJournal is returned by ReplayJournal when the pair journaled no change in the cycle to replay from.
+var ErrNoJournal = errors.New("no journal entries")
+
+// replayKey is the context key of the changes of the journal entry being replayed.
+type replayKey struct{}
+
+// replaying returns the changes of the journal entry ctx replays, if it replays one.
+func replaying(ctx context.Context) (changes, bool) {
+	ch, ok := ctx.Value(replayKey{}).(changes)
+	return ch, ok
+}
+
+// journal appends the changes found on item and task to the journal before they are synced: a new or
+// changed work item, a task modified since the last sync, or both. Nothing is journaled in dry runs, while
+// replaying the journal, or when neither side changed.
+func (e *Engine) journal(ctx context.Context, item ado.WorkItem, task *asana.Task) error {
+	if !e.cfg.Journal || e.plan != nil {
+		return nil
+	}
+	if _, ok := replaying(ctx); ok {
+		return nil
+	}
+	m, err := e.store.Get(ctx, item.ID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+	mapped := err == nil && task != nil && m.AsanaGID == task.GID
+	var sources []string
+	if !mapped || item.Rev != m.ADORev {
+		sources = append(sources, store.OriginADO)
+	}
+	if mapped && task.ModifiedAt.After(m.AsanaModified) {
+		sources = append(sources, store.OriginAsana)
+	}
+	if len(sources) == 0 {
+		return nil
+	}
+	j := store.JournalEntry{Time: time.Now().UTC(), Pair: e.cfg.Name, CycleID: cycleID(ctx), Sources: sources, ADOID: item.ID}
+	b, err := json.Marshal(item)
+	if err != nil {
+		return fmt.Errorf("encoding work item: %w", err)
+	}
+	j.Item = string(b)
+	if task != nil {
+		if b, err = json.Marshal(task); err != nil {
+			return fmt.Errorf("encoding asana task: %w", err)
+		}
+		j.AsanaGID, j.Task = task.GID, string(b)
+	}
+	if err := e.store.PutJournal(ctx, j); err != nil {
+		return fmt.Errorf("journaling change: %w", err)
+	}
+	return nil
+}
+
+// pruneJournal removes the journal entries older than the retention period.
+func (e *Engine) pruneJournal(ctx context.Context) {
+	if !e.cfg.Journal || e.cfg.JournalRetention <= 0 {
+		return
+	}
+	n, err := e.store.PruneJournal(ctx, time.Now().Add(-e.cfg.JournalRetention))
+	if err != nil {
+		logging.From(ctx).Error("failed to prune change journal", "error", err)
+		return
+	}
+	if n > 0 {
+		logging.From(ctx).Info("pruned change journal", "removed", n)
+	}
+}
+
+// ReplayJournal applies every change journaled by the pair from the start of the cycle with the given ID
+// onwards again, oldest first, with the current configuration. Each change is synced from the side that
+// changed as it was journaled onto the other side as it is now, so tasks and work items written by a faulty
+// mapping are rewritten by the repaired one. A side that changed since is read again and synced as a cycle
+// would. It returns ErrNoJournal when the pair journaled nothing in that cycle.
+func (e *Engine) ReplayJournal(ctx context.Context, from string) (*Report, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ctx = e.begin(ctx)
+	ctx, span := tracing.Tracer().Start(ctx, "sync.replay", trace.WithAttributes(
+		attribute.String("sync.pair", e.cfg.Name),
+		attribute.String("sync.replay_from", from),
+	))
+	rep, err := e.replayJournal(ctx, from)
+	tracing.End(span, err)
+	return rep, err
+}
+
+func (e *Engine) replayJournal(ctx context.Context, from string) (*Report, error) {
+	first, err := e.store.Journal(ctx, store.JournalFilter{Pair: e.cfg.Name, CycleID: from})
+	if err != nil {
+		return nil, err
+	}
+	if len(first) == 0 {
+		return nil, fmt.Errorf("cycle %s: %w", from, ErrNoJournal)
+	}
+	entries, err := e.store.Journal(ctx, store.JournalFilter{Pair: e.cfg.Name, Since: first[0].Time})
+	if err != nil {
+		return nil, err
+	}
+	rep := &Report{Plan: e.plan}
+	if err := e.prepare(ctx); err != nil {
+		return nil, err
+	}
+	e.orphans, e.blocked, e.rollups = nil, nil, nil
+	logging.From(ctx).Info("replaying change journal", "from", from, "entries", len(entries))
+	for _, j := range entries {
+		ctx := logging.With(ctx, logging.KeyWorkItem, j.ADOID)
+		rep.Items++
+		if err := e.replayEntry(ctx, j, rep); err != nil {
+			logging.From(ctx).Error("failed to replay journal entry", "cycle_id", j.CycleID, "error", err)
+			rep.fail(j.ADOID, err)
+		}
+	}
+	if err := e.linkOrphans(ctx); err != nil {
+		return nil, err
+	}
+	if err := e.linkBlocked(ctx); err != nil {
+		return nil, err
+	}
+	if err := e.rollUp(ctx, rep); err != nil {
+		return nil, err
+	}
+	return e.finish(ctx, rep)
+}
+
+// replayEntry syncs the change of j again. The side that changed is taken from the entry and the other is
+// read as it is now; a task that no longer exists is created again.
+func (e *Engine) replayEntry(ctx context.Context, j store.JournalEntry, rep *Report) error {
+	var ch changes
+	for _, s := range j.Sources {
+		switch s {
+		case store.OriginADO:
+			ch.ado = true
+		case store.OriginAsana:
+			ch.asana = true
+		}
+	}
+	var item ado.WorkItem
+	if err := json.Unmarshal([]byte(j.Item), &item); err != nil {
+		return fmt.Errorf("decoding journaled work item: %w", err)
+	}
+	if !ch.ado {
+		items, err := e.ado.GetWorkItems(ctx, []int{j.ADOID})
+		if err != nil {
+			return fmt.Errorf("fetching work item: %w", err)
+		}
+		if len(items) == 0 {
+			logging.From(ctx).Info("skipping journal entry: the work item no longer exists")
+			return nil
+		}
+		item = items[0]
+	}
+
+	var task *asana.Task
+	if ch.asana && j.Task != "" {
+		task = new(asana.Task)
+		if err := json.Unmarshal([]byte(j.Task), task); err != nil {
+			return fmt.Errorf("decoding journaled asana task: %w", err)
+		}
+	} else {
+		gid := j.AsanaGID
+		if m, err := e.store.Get(ctx, j.ADOID); err == nil {
+			gid = m.AsanaGID
+		} else if !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+		if gid != "" {
+			var err error
+			if task, err = e.asana.GetTask(ctx, gid); err != nil && !isNotFound(err) {
+				return fmt.Errorf("fetching asana task %s: %w", gid, err)
+			}
+		}
+	}
+	return e.process(context.WithValue(ctx, replayKey{}, ch), item, task, rep)
+}
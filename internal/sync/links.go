@@ -0,0 +1,211 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// How a kind of work item link is synced.
+const (
+	// LinkNotes lists the tasks of the linked work items in the Links block of the task notes.
+	LinkNotes = "notes"
+	// LinkComplete, for duplicate links, also completes the task of the duplicate.
+	LinkComplete = "complete"
+	// LinkDependency, for blocked by links, makes the task depend on the tasks of the items blocking it.
+	LinkDependency = "dependency"
+)
+
+// LinkConfig configures the sync of the links between work items other than parent and predecessor links.
+// A kind of link without a mode is not synced.
+type LinkConfig struct {
+	// Related is how related links are synced: LinkNotes or nothing.
+	Related string `json:"related,omitempty"`
+	// Duplicate is how the links of duplicates to the work item they duplicate are synced: LinkNotes, or
+	// LinkComplete to also complete the task of the duplicate whatever the state of its work item.
+	Duplicate string `json:"duplicate,omitempty"`
+	// BlockedBy is how the links to the work items blocking an item are synced: LinkNotes or LinkDependency.
+	BlockedBy string `json:"blocked_by,omitempty"`
+	// BlockedByType is the relation type of blocked by links, such as that of a custom link type. Predecessor
+	// links are used when it is empty.
+	BlockedByType string `json:"blocked_by_type,omitempty"`
+}
+
+// blockedByType returns the relation type of blocked by links.
+func (c LinkConfig) blockedByType() string {
+	if c.BlockedByType != "" {
+		return c.BlockedByType
+	}
+	return ado.RelPredecessor
+}
+
+// linkKind is a kind of link listed in the Links block.
+type linkKind struct {
+	label string
+	rel   string
+}
+
+// listed returns the kinds of links listed in the Links block, in the order they are listed.
+func (c LinkConfig) listed() []linkKind {
+	var kinds []linkKind
+	if c.Related == LinkNotes {
+		kinds = append(kinds, linkKind{"Related", ado.RelRelated})
+	}
+	if c.Duplicate != "" {
+		kinds = append(kinds, linkKind{"Duplicate of", ado.RelDuplicateOf})
+	}
+	if c.BlockedBy == LinkNotes {
+		kinds = append(kinds, linkKind{"Blocked by", c.blockedByType()})
+	}
+	return kinds
+}
+
+// ParseLinks parses a comma separated list of kind=mode link settings, for example
+// "related=notes,duplicate=complete,blocked_by=dependency".
+func ParseLinks(s string) (LinkConfig, error) {
+	var c LinkConfig
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kind, mode, ok := strings.Cut(part, "=")
+		mode = strings.ToLower(strings.TrimSpace(mode))
+		if !ok || mode == "" {
+			return c, fmt.Errorf("invalid link setting %q, expected kind=mode", part)
+		}
+		switch strings.ToLower(strings.TrimSpace(kind)) {
+		case "related":
+			c.Related = mode
+		case "duplicate":
+			c.Duplicate = mode
+		case "blocked_by":
+			c.BlockedBy = mode
+		default:
+			return c, fmt.Errorf("unknown link kind %q, expected related, duplicate or blocked_by", kind)
+		}
+	}
+	return c, nil
+}
+
+// ValidateLinks checks the mode of every kind of link.
+func (c Config) ValidateLinks() error {
+	for _, k := range []struct {
+		name, mode string
+		modes      []string
+	}{
+		{"related", c.Links.Related, []string{LinkNotes}},
+		{"duplicate", c.Links.Duplicate, []string{LinkNotes, LinkComplete}},
+		{"blocked_by", c.Links.BlockedBy, []string{LinkNotes, LinkDependency}},
+	} {
+		if k.mode == "" {
+			continue
+		}
+		found := false
+		for _, m := range k.modes {
+			found = found || k.mode == m
+		}
+		if !found {
+			return fmt.Errorf("invalid mode %q of %s links, expected %s", k.mode, k.name, strings.Join(k.modes, " or "))
+		}
+	}
+	return nil
+}
+
+// dependencyTypes returns the relation types of the links making tasks depend on the tasks of the linked
+// work items.
+func (c Config) dependencyTypes() []string {
+	var rels []string
+	if c.Dependencies {
+		rels = append(rels, ado.RelPredecessor)
+	}
+	if c.Links.BlockedBy == LinkDependency && (!c.Dependencies || c.Links.blockedByType() != ado.RelPredecessor) {
+		rels = append(rels, c.Links.blockedByType())
+	}
+	return rels
+}
+
+// linksHeading starts the Links block of task notes.
+const linksHeading = "<strong>Links</strong>"
+
+// linksBlock matches the Links block of task notes, as written by withLinks and reformatted by Asana.
+var linksBlock = regexp.MustCompile(`(?s)\s*<hr\s*/?>\s*` + linksHeading + `\s*<ul>.*?</ul>`)
+
+// links renders the Links block listing the work items item links to, linking to their task when they are
+// synced, or an empty string when it links to none.
+func (e *Engine) links(ctx context.Context, item ado.WorkItem) (string, error) {
+	var b strings.Builder
+	for _, k := range e.cfg.Links.listed() {
+		for _, id := range item.Linked(k.rel) {
+			m, err := e.store.Get(ctx, id)
+			switch {
+			case errors.Is(err, store.ErrNotFound), err == nil && m.AsanaGID == "":
+				// A pending mapping has no task to link to yet.
+				b.WriteString("<li>" + html.EscapeString(fmt.Sprintf("%s: work item %d (not synced)", k.label, id)) + "</li>")
+				continue
+			case err != nil:
+				return "", err
+			}
+			text := fmt.Sprintf("[AB#%d] %s", id, m.Title)
+			b.WriteString("<li>" + html.EscapeString(k.label) + `: <a href="` + html.EscapeString(taskURL(m.AsanaGID)) + `">` + html.EscapeString(text) + "</a></li>")
+		}
+	}
+	if b.Len() == 0 {
+		return "", nil
+	}
+	return "<hr/>" + linksHeading + "<ul>" + b.String() + "</ul>", nil
+}
+
+// taskURL returns the URL of the Asana task with the given GID.
+func taskURL(gid string) string {
+	return "https://app.asana.com/0/0/" + gid
+}
+
+// withLinks returns the html_notes with their Links block replaced by block, which is appended when the notes
+// have none. An empty block removes it.
+func withLinks(notes, block string) string {
+	return withBlock(linksBlock, notes, block)
+}
+
+// linkNotes returns the notes of task with the Links block of item brought up to date, as developmentNotes
+// does for the Development block. A block with entries is compared on every sync, as the linked items may
+// have started syncing or been renamed since.
+func (e *Engine) linkNotes(ctx context.Context, item ado.WorkItem, task *asana.Task, rendered *string, ch changes) (notes string, ok bool, err error) {
+	if len(e.cfg.Links.listed()) == 0 {
+		return "", false, nil
+	}
+	block, err := e.links(ctx, item)
+	if err != nil {
+		return "", false, err
+	}
+	if rendered != nil {
+		return withLinks(*rendered, block), true, nil
+	}
+	if block == "" && !ch.ado {
+		return "", false, nil
+	}
+	cur, err := e.asana.TaskHTMLNotes(ctx, task.GID)
+	if err != nil {
+		return "", false, fmt.Errorf("reading asana task notes: %w", err)
+	}
+	if plainText(linksBlock.FindString(cur)) == plainText(block) {
+		return "", false, nil
+	}
+	return withLinks(cur, block), true, nil
+}
+
+// duplicateState returns want, the state the task of item shows, completed when item duplicates another
+// work item and duplicates are completed.
+func (e *Engine) duplicateState(item ado.WorkItem, want taskState) taskState {
+	if e.cfg.Links.Duplicate == LinkComplete && len(item.Linked(ado.RelDuplicateOf)) > 0 {
+		want.completed = true
+	}
+	return want
+}
@@ -0,0 +1,434 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/schedule"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// DefaultInterval is the time between sync cycles of pairs that do not set an interval.
+const DefaultInterval = 5 * time.Minute
+
+// ErrNoPair is returned by Manager.SyncItem for work items in a project no pair syncs.
+var ErrNoPair = errors.New("not part of any sync pair")
+
+// ErrUnknownPair is returned by Manager.Trigger, Pause, Resume and Canary for names no pair has.
+var ErrUnknownPair = errors.New("no such sync pair")
+
+// DefaultConnection is the name of the connection of pairs that do not name one.
+const DefaultConnection = ""
+
+// ADOConnection is an Azure DevOps organization pairs sync with, and the store holding the mappings of its
+// work items. Work item IDs are only unique within an organization, so connections must not share a store.
+type ADOConnection struct {
+	// OrgURL is the URL of the organization, which routes webhook events to its pairs.
+	OrgURL string
+	ADO    ADO
+	Store  store.Store
+	// Circuit is the circuit breaker of the ADO client, when it has one.
+	Circuit Circuit
+}
+
+// AsanaConnection is an Asana account pairs sync with, such as a guest account in a client's workspace.
+type AsanaConnection struct {
+	Asana Asana
+	// Circuit is the circuit breaker of the Asana client, when it has one.
+	Circuit Circuit
+}
+
+// Manager runs the engines of several sync pairs, each using the clients and store of its connections.
+type Manager struct {
+	conns      map[string]ADOConnection
+	asanaConns map[string]AsanaConnection
+	engines    []*Engine
+	byName     map[string]*Engine
+}
+
+// NewManager returns a Manager with an engine for each pair, syncing with the ADO and Asana connections by
+// name. Pair names must be unique and every pair must use connections of both.
+func NewManager(pairs []Config, conns map[string]ADOConnection, asanaConns map[string]AsanaConnection) (*Manager, error) {
+	if len(pairs) == 0 {
+		return nil, fmt.Errorf("no sync pairs configured")
+	}
+	m := &Manager{conns: conns, asanaConns: asanaConns, byName: make(map[string]*Engine, len(pairs))}
+	tags := newTagCache()
+	for _, cfg := range pairs {
+		if cfg.Name == "" {
+			return nil, fmt.Errorf("sync pair %s -> %s has no name", cfg.ADOProject, cfg.AsanaProject)
+		}
+		if _, ok := m.byName[cfg.Name]; ok {
+			return nil, fmt.Errorf("duplicate sync pair name %q", cfg.Name)
+		}
+		e, err := m.newEngine(cfg)
+		if err != nil {
+			return nil, err
+		}
+		e.tags = tags
+		m.engines = append(m.engines, e)
+		m.byName[cfg.Name] = e
+	}
+	return m, nil
+}
+
+// newEngine returns an engine for the pair using the clients and store of its connections.
+func (m *Manager) newEngine(cfg Config) (*Engine, error) {
+	return m.newEngineOn(cfg, nil)
+}
+
+// newEngineOn returns an engine for the pair using the clients of its connections and st, or the store of
+// its ADO connection when st is nil.
+func (m *Manager) newEngineOn(cfg Config, st store.Store) (*Engine, error) {
+	c, ok := m.conns[cfg.ADOConnection]
+	if !ok {
+		return nil, fmt.Errorf("sync pair %q uses unknown ado connection %q", cfg.Name, cfg.ADOConnection)
+	}
+	a, ok := m.asanaConns[cfg.AsanaConnection]
+	if !ok {
+		return nil, fmt.Errorf("sync pair %q uses unknown asana connection %q", cfg.Name, cfg.AsanaConnection)
+	}
+	if st == nil {
+		st = c.Store
+	}
+	e := New(cfg, c.ADO, a.Asana, st)
+	e.adoCircuit, e.asanaCircuit = c.Circuit, a.Circuit
+	return e, nil
+}
+
+// Engines returns the engine of every pair in configuration order.
+func (m *Manager) Engines() []*Engine {
+	return m.engines
+}
+
+// Reload replaces the configuration of the pairs with pairs, which must name the same pairs as before. Each
+// engine picks up its configuration once its current cycle finished and validates it in its next cycle.
+func (m *Manager) Reload(pairs []Config) error {
+	if len(pairs) != len(m.engines) {
+		return fmt.Errorf("adding or removing sync pairs requires a restart")
+	}
+	engines := make([]*Engine, 0, len(pairs))
+	for _, cfg := range pairs {
+		e := m.byName[cfg.Name]
+		switch {
+		case e == nil:
+			return fmt.Errorf("sync pair %q is new, adding or removing sync pairs requires a restart", cfg.Name)
+		case cfg.ADOConnection != e.config().ADOConnection:
+			return fmt.Errorf("sync pair %q changed its ado connection, which requires a restart", cfg.Name)
+		case cfg.AsanaConnection != e.config().AsanaConnection:
+			return fmt.Errorf("sync pair %q changed its asana connection, which requires a restart", cfg.Name)
+		}
+		n, err := m.newEngine(cfg)
+		if err != nil {
+			return err
+		}
+		engines = append(engines, n)
+	}
+	for _, n := range engines {
+		m.byName[n.name].reload(n)
+	}
+	return nil
+}
+
+// Close stops the transform plugins of every pair.
+func (m *Manager) Close() error {
+	var errs []error
+	for _, e := range m.engines {
+		errs = append(errs, e.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Validate validates the configuration of every pair.
+func (m *Manager) Validate(ctx context.Context) error {
+	for _, e := range m.engines {
+		if err := e.Validate(ctx); err != nil {
+			return fmt.Errorf("sync pair %q: %w", e.Name(), err)
+		}
+	}
+	return nil
+}
+
+// Run syncs every pair on its own schedule until ctx is cancelled. Pairs syncing on an interval run their
+// first cycle immediately and pairs with a cron schedule at its first run. Failed items queued for a retry
+// are retried in between, and pairs with a drift interval check their mappings for drift. onCycle, when not
+// nil, is called after every cycle with its outcome.
+func (m *Manager) Run(ctx context.Context, onCycle func(e *Engine, rep *Report, err error)) {
+	var wg gosync.WaitGroup
+	for _, e := range m.engines {
+		m.start(ctx, &wg, e, onCycle)
+	}
+	wg.Wait()
+}
+
+// RunPair syncs the named pair as Run does until ctx is cancelled, for instances sharing the pairs between
+// them.
+func (m *Manager) RunPair(ctx context.Context, name string, onCycle func(e *Engine, rep *Report, err error)) error {
+	e := m.byName[name]
+	if e == nil {
+		return fmt.Errorf("sync pair %q: %w", name, ErrUnknownPair)
+	}
+	var wg gosync.WaitGroup
+	m.start(ctx, &wg, e, onCycle)
+	wg.Wait()
+	return nil
+}
+
+// start starts the loops of e in wg.
+func (m *Manager) start(ctx context.Context, wg *gosync.WaitGroup, e *Engine, onCycle func(e *Engine, rep *Report, err error)) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		m.loop(ctx, e, onCycle)
+	}()
+	if !e.cfg.DryRun {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.retryLoop(ctx, e)
+		}()
+	}
+	if e.cfg.DriftInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.driftLoop(ctx, e)
+		}()
+	}
+}
+
+// retryLoop retries the queued items of e as they become due until ctx is cancelled.
+func (m *Manager) retryLoop(ctx context.Context, e *Engine) {
+	ticker := time.NewTicker(retryPoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		rep, err := e.RetryDue(ctx)
+		switch {
+		case err != nil:
+			logging.From(ctx).Error("retrying failed work items", logging.KeyPair, e.Name(), "error", err)
+		case rep != nil:
+			logging.From(ctx).Info("retried failed work items", logging.KeyPair, e.Name(), "retried", rep.Items, "failed", len(rep.Failures))
+		}
+	}
+}
+
+// driftLoop checks the mappings of e for drift every drift interval until ctx is cancelled. The interval is
+// read again after every check, and the checks stop when a reloaded configuration disables them.
+func (m *Manager) driftLoop(ctx context.Context, e *Engine) {
+	for {
+		cfg := e.config()
+		if cfg.DriftInterval <= 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(cfg.DriftInterval):
+		}
+		if _, err := e.CheckDrift(ctx, cfg.DriftRepair); err != nil {
+			logging.From(ctx).Error("checking mappings for drift", logging.KeyPair, e.Name(), "error", err)
+		}
+	}
+}
+
+// loop runs the cycles of e on its schedule until ctx is cancelled, delaying each by up to the pair's jitter.
+// A pair never overlaps itself: the next cycle is scheduled once the previous one finished, so runs missed
+// while a cycle was still going are skipped.
+// A cycle triggered by Trigger runs at once, or right after the running cycle when there is one.
+// The schedule is read again after every cycle, so a reloaded configuration applies from the next one.
+// A pause ending before the next run brings the next cycle forward to its end.
+func (m *Manager) loop(ctx context.Context, e *Engine, onCycle func(e *Engine, rep *Report, err error)) {
+	cfg := e.config()
+	sched, next := cfg.cycleSchedule(), time.Now()
+	if cfg.Schedule != nil {
+		next = sched.Next(next)
+	}
+	for !next.IsZero() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.wake:
+		case <-time.After(time.Until(next) + schedule.Jitter(cfg.Jitter)):
+		}
+		rep, err := e.Run(ctx)
+		if onCycle != nil {
+			onCycle(e, rep, err)
+		}
+		now := time.Now()
+		if missed := sched.Next(next); cfg.Schedule != nil && missed.Before(now) {
+			logging.From(ctx).Warn("cycle overran its schedule, skipping missed runs", logging.KeyPair, e.Name(), "missed_since", missed.Format(time.RFC3339))
+		}
+		cfg = e.config()
+		sched = cfg.cycleSchedule()
+		next = sched.Next(now)
+		// The changes held back by a pause are synced as soon as it ends.
+		if p, _ := e.Paused(ctx); p != nil && !p.Until.IsZero() && p.Until.Before(next) {
+			next = p.Until
+		}
+	}
+}
+
+// Trigger runs a cycle of the named pair now rather than at its next scheduled run, once its running cycle
+// finished when there is one. Triggers made while a cycle waits to start share it. The cycle runs in the
+// loops of Run, so it is only started while they run.
+func (m *Manager) Trigger(name string) error {
+	e := m.byName[name]
+	if e == nil {
+		return fmt.Errorf("sync pair %q: %w", name, ErrUnknownPair)
+	}
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Pause pauses the named pair until the given time, or until Resume when it is zero. See Engine.Pause.
+func (m *Manager) Pause(ctx context.Context, name string, until time.Time, reason string) error {
+	e := m.byName[name]
+	if e == nil {
+		return fmt.Errorf("sync pair %q: %w", name, ErrUnknownPair)
+	}
+	return e.Pause(ctx, until, reason)
+}
+
+// Resume resumes the named pair and triggers a cycle, which syncs the changes held back by the pause.
+func (m *Manager) Resume(ctx context.Context, name string) error {
+	e := m.byName[name]
+	if e == nil {
+		return fmt.Errorf("sync pair %q: %w", name, ErrUnknownPair)
+	}
+	if err := e.Resume(ctx); err != nil {
+		return err
+	}
+	return m.Trigger(name)
+}
+
+// cycleSchedule returns the schedule of the cycles of the pair: Schedule, or every Interval.
+func (c Config) cycleSchedule() schedule.Schedule {
+	if c.Schedule != nil {
+		return c.Schedule
+	}
+	interval := c.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return schedule.Every(interval)
+}
+
+// SyncItem syncs the work item with the given ID in the organization at orgURL using the pair that owns it.
+// Unmapped items are synced by the first pair reading from the item's project. An empty orgURL, or one no
+// connection has, such as the legacy visualstudio.com URL of an organization, stands for every organization
+// and the first one mapping or holding the item wins.
+func (m *Manager) SyncItem(ctx context.Context, orgURL string, adoID int) (*Report, error) {
+	e, err := m.itemEngine(ctx, orgURL, adoID)
+	if err != nil {
+		return nil, err
+	}
+	return e.SyncItem(ctx, adoID)
+}
+
+// Recreate syncs the work item with the given ID in the organization at orgURL like SyncItem, creating a new
+// task for it when it is not mapped, see Engine.Recreate.
+func (m *Manager) Recreate(ctx context.Context, orgURL string, adoID int) (*Report, error) {
+	e, err := m.itemEngine(ctx, orgURL, adoID)
+	if err != nil {
+		return nil, err
+	}
+	return e.Recreate(ctx, adoID)
+}
+
+// SyncTask syncs the work item mapped to the Asana task with the given GID using the pair that owns it.
+func (m *Manager) SyncTask(ctx context.Context, taskGID string) (*Report, error) {
+	for _, name := range m.connections("") {
+		mp, err := m.conns[name].Store.ByAsanaGID(ctx, taskGID)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if e := m.byName[mp.Pair]; e != nil {
+			return e.SyncItem(ctx, mp.ADOID)
+		}
+		return m.SyncItem(ctx, m.conns[name].OrgURL, mp.ADOID)
+	}
+	return nil, fmt.Errorf("asana task %s: %w", taskGID, ErrNotMapped)
+}
+
+// connections returns the names of the connections in use by a pair whose organization is at orgURL, or of
+// every connection in use when orgURL is empty, in the order of the pairs.
+func (m *Manager) connections(orgURL string) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, e := range m.engines {
+		name := e.config().ADOConnection
+		if seen[name] || (orgURL != "" && !sameOrg(m.conns[name].OrgURL, orgURL)) {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// sameOrg reports whether the organization URLs a and b are the same, ignoring case and trailing slashes.
+func sameOrg(a, b string) bool {
+	return strings.EqualFold(strings.TrimRight(a, "/"), strings.TrimRight(b, "/"))
+}
+
+// itemEngine returns the engine of the pair responsible for the work item with the given ID in the
+// organization at orgURL.
+func (m *Manager) itemEngine(ctx context.Context, orgURL string, adoID int) (*Engine, error) {
+	conns := m.connections(orgURL)
+	if len(conns) == 0 {
+		conns = m.connections("")
+	}
+	for _, name := range conns {
+		mp, err := m.conns[name].Store.Get(ctx, adoID)
+		switch {
+		case err == nil:
+			if e := m.byName[mp.Pair]; e != nil && e.config().ADOConnection == name {
+				return e, nil
+			}
+		case !errors.Is(err, store.ErrNotFound):
+			return nil, err
+		}
+	}
+	if len(m.engines) == 1 {
+		return m.engines[0], nil
+	}
+
+	found, project := false, ""
+	for _, name := range conns {
+		items, err := m.conns[name].ADO.GetWorkItems(ctx, []int{adoID})
+		if err != nil {
+			return nil, fmt.Errorf("fetching work item %d: %w", adoID, err)
+		}
+		if len(items) == 0 {
+			continue
+		}
+		found = true
+		project, _ = items[0].Fields[ado.FieldTeamProject].(string)
+		for _, e := range m.engines {
+			if cfg := e.config(); cfg.ADOConnection == name && strings.EqualFold(cfg.ADOProject, project) {
+				return e, nil
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("work item %d not found", adoID)
+	}
+	return nil, fmt.Errorf("work item %d in project %q: %w", adoID, project, ErrNoPair)
+}
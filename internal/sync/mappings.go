@@ -0,0 +1,216 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+)
+
+// UnknownValue is what happens to the values of an enum field mapping that its translation tables and the
+// enum options do not cover.
+type UnknownValue string
+
+// Supported handling of unknown values.
+const (
+	// UnknownFail fails the sync of the work item, so the mapping can be fixed.
+	UnknownFail UnknownValue = "fail"
+	// UnknownClear clears the field.
+	UnknownClear UnknownValue = "clear"
+	// UnknownKeep leaves the field as it is.
+	UnknownKeep UnknownValue = "keep"
+)
+
+// ParseUnknownValue parses s as an UnknownValue. An empty string returns UnknownFail.
+func ParseUnknownValue(s string) (UnknownValue, error) {
+	switch u := UnknownValue(strings.ToLower(strings.TrimSpace(s))); u {
+	case "":
+		return UnknownFail, nil
+	case UnknownFail, UnknownClear, UnknownKeep:
+		return u, nil
+	default:
+		return "", fmt.Errorf("unknown value handling %q, expected fail, clear or keep", s)
+	}
+}
+
+// errKeep is returned for values that leave the field they would be written to as it is.
+var errKeep = errors.New("unknown value, field kept")
+
+// field returns the name conflicts of the mapping are queued under.
+func (m FieldMapping) field() Field {
+	return Field("field:" + m.Source)
+}
+
+// direction returns the sync direction of the mapping.
+func (m FieldMapping) direction() Direction {
+	if m.Direction == "" {
+		return ADOToAsana
+	}
+	return m.Direction
+}
+
+// reverseValues returns the ADO values of the enum options of m, keyed by lower case option name, when m syncs
+// from Asana. An option that several ADO values map to must be listed in Reverse, as it cannot be told which
+// of them to write back.
+func reverseValues(m FieldMapping, options map[string]string) (map[string]string, error) {
+	if m.direction() == ADOToAsana {
+		return nil, nil
+	}
+	reverse := map[string]string{}
+	from := map[string][]string{}
+	for v, option := range m.Values {
+		from[strings.ToLower(option)] = append(from[strings.ToLower(option)], v)
+	}
+	for option, values := range from {
+		if len(values) == 1 {
+			reverse[option] = values[0]
+		}
+	}
+	for option, v := range m.Reverse {
+		if _, ok := options[strings.ToLower(option)]; !ok {
+			return nil, fmt.Errorf("field mapping %s -> %s: reverse lists the unknown option %q", m.Source, m.Target, option)
+		}
+		reverse[strings.ToLower(option)] = v
+	}
+	for option, values := range from {
+		if _, ok := reverse[option]; !ok {
+			sort.Strings(values)
+			return nil, fmt.Errorf("field mapping %s -> %s: option %q is mapped from %s, list the value to write back in reverse",
+				m.Source, m.Target, option, strings.Join(values, ", "))
+		}
+	}
+	return reverse, nil
+}
+
+// syncFieldMappings returns the custom field values of project written to task for item, and the operations
+// writing the values of the mappings syncing from Asana back to item. Fields holding the same value on both
+// sides are left out.
+func (e *Engine) syncFieldMappings(ctx context.Context, project string, item ado.WorkItem, task *asana.Task, ch changes, rep *Report) (map[string]interface{}, []ado.PatchOperation, error) {
+	var values map[string]interface{}
+	var ops []ado.PatchOperation
+	for _, f := range e.target(project).fieldsFor(item) {
+		v, err := f.coerce(item.Fields[f.Source])
+		keep := errors.Is(err, errKeep)
+		if err != nil && !keep {
+			return nil, nil, withCode(CodeInvalidValue, fmt.Errorf("field %s: %w", f.Source, err))
+		}
+		twoWay := f.direction() != ADOToAsana
+		if !keep && f.equal(task.CustomFields, v) {
+			if twoWay {
+				e.clearConflict(ctx, item.ID, f.field())
+			}
+			continue
+		}
+		s := sideADO
+		if twoWay {
+			var ok bool
+			if s, ok = e.pickIn(ctx, f.field(), f.direction(), item, task, ch, text(item.Fields[f.Source]), f.asanaText(task), rep); !ok {
+				continue
+			}
+		}
+		switch {
+		case s == sideAsana:
+			op, err := f.writeBack(item, task)
+			if errors.Is(err, errKeep) {
+				continue
+			}
+			if err != nil {
+				return nil, nil, withCode(CodeInvalidValue, fmt.Errorf("field %s: %w", f.Source, err))
+			}
+			ops = append(ops, op)
+		case !keep:
+			if values == nil {
+				values = map[string]interface{}{}
+			}
+			values[f.gid] = v
+		}
+	}
+	return values, ops, nil
+}
+
+// asanaText renders the value of the field on task as text.
+func (f resolvedField) asanaText(task *asana.Task) string {
+	for _, cf := range task.CustomFields {
+		if cf.GID == f.gid {
+			return customFieldText(cf)
+		}
+	}
+	return ""
+}
+
+// writeBack returns the operation writing the value of the field on task to item. Enum options are
+// translated by the reverse table; with translation tables, options they do not cover are unknown values.
+func (f resolvedField) writeBack(item ado.WorkItem, task *asana.Task) (ado.PatchOperation, error) {
+	var s string
+	for _, cf := range task.CustomFields {
+		if cf.GID != f.gid {
+			continue
+		}
+		switch {
+		case cf.NumberValue != nil:
+			return f.adoValue(item, strconv.FormatFloat(*cf.NumberValue, 'f', -1, 64))
+		case cf.EnumValue != nil:
+			s = cf.EnumValue.Name
+			if v, ok := f.reverse[strings.ToLower(s)]; ok {
+				s = v
+			} else if len(f.Values) > 0 || len(f.Reverse) > 0 {
+				switch f.Unknown {
+				case UnknownClear:
+					return ado.RemoveField(f.Source), nil
+				case UnknownKeep:
+					return ado.PatchOperation{}, errKeep
+				}
+				return ado.PatchOperation{}, fmt.Errorf("no ado value matches option %q", s)
+			}
+		case cf.TextValue != nil:
+			s = *cf.TextValue
+		}
+	}
+	if s == "" {
+		return ado.RemoveField(f.Source), nil
+	}
+	return f.adoValue(item, s)
+}
+
+// adoValue returns the operation writing s to the field of item as the type of the ADO field: integers are
+// rounded, and booleans parsed. Without the type, numeric fields such as Priority are written as numbers.
+func (f resolvedField) adoValue(item ado.WorkItem, s string) (ado.PatchOperation, error) {
+	switch f.adoType {
+	case ado.FieldTypeInteger, ado.FieldTypeDouble:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return ado.PatchOperation{}, fmt.Errorf("cannot convert %q to a number", s)
+		}
+		if f.adoType == ado.FieldTypeInteger {
+			return ado.SetField(f.Source, int64(math.Round(n))), nil
+		}
+		return ado.SetField(f.Source, n), nil
+	case ado.FieldTypeBoolean:
+		switch strings.ToLower(s) {
+		case "true", "yes", "1":
+			return ado.SetField(f.Source, true), nil
+		case "false", "no", "0":
+			return ado.SetField(f.Source, false), nil
+		}
+		return ado.PatchOperation{}, fmt.Errorf("cannot convert %q to a boolean", s)
+	case "":
+		if cur := item.Fields[f.Source]; cur == nil || isNumber(cur) {
+			if n, err := strconv.ParseFloat(s, 64); err == nil {
+				return ado.SetField(f.Source, n), nil
+			}
+		}
+	}
+	return ado.SetField(f.Source, s), nil
+}
+
+// isNumber reports whether v is a number as decoded from JSON.
+func isNumber(v interface{}) bool {
+	_, ok := v.(float64)
+	return ok
+}
@@ -0,0 +1,172 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// MembersConfig controls how the assignees of work items get access to their task. Asana does not assign
+// tasks to users who cannot see their project.
+type MembersConfig struct {
+	// Add makes assignees who are not members of the Asana project of their task members of it.
+	Add bool `json:"add,omitempty"`
+	// Follow makes assignees followers of their task, which gives those who are not members of the project
+	// access to it when the fallback assignee stands in for them.
+	Follow bool `json:"follow,omitempty"`
+	// Fallback is the email or GID of the Asana user assigned the task instead when the assignee has no
+	// Asana user or is not a member of the project, with a comment naming the intended owner.
+	Fallback string `json:"fallback,omitempty"`
+}
+
+// tracked reports whether the members of projects are looked up.
+func (c MembersConfig) tracked() bool {
+	return c.Add || c.Fallback != ""
+}
+
+// assignment is the Asana user the task of a work item is assigned to.
+type assignment struct {
+	user *asana.User
+	// owner is the Asana user matched to the assignee of the work item, nil when there is none.
+	owner *asana.User
+	// note is the comment naming the intended owner when user is the fallback assignee standing in.
+	note string
+}
+
+// lookup returns the workspace user with the given GID or email, or nil.
+func (d *userDirectory) lookup(s string) *asana.User {
+	if u, ok := d.byGID[s]; ok {
+		return &u
+	}
+	if u, ok := d.byEmail[strings.ToLower(s)]; ok {
+		return &u
+	}
+	return nil
+}
+
+// validateFallback checks that the fallback assignee is a user of the workspace.
+func (e *Engine) validateFallback() error {
+	if f := e.cfg.Members.Fallback; f != "" && e.users.lookup(f) == nil {
+		return fmt.Errorf("fallback assignee %q is not a user of the asana workspace", f)
+	}
+	return nil
+}
+
+// resolveMembers lists the members of the Asana project, keyed by GID, when assignees are checked against
+// them.
+func (e *Engine) resolveMembers(ctx context.Context, project string) (map[string]bool, error) {
+	if !e.cfg.Members.tracked() {
+		return nil, nil
+	}
+	users, err := e.asana.ProjectMembers(ctx, project)
+	if err != nil {
+		return nil, fmt.Errorf("listing asana project members: %w", err)
+	}
+	members := make(map[string]bool, len(users))
+	for _, u := range users {
+		members[u.GID] = true
+	}
+	return members, nil
+}
+
+// assign returns who the task of item in project is assigned to. user is the Asana user matched to the
+// assignee of item, nil when there is none. Assignees who are not members of the project are added to it
+// or replaced by the fallback assignee.
+func (e *Engine) assign(ctx context.Context, project string, item ado.WorkItem, user *asana.User) (assignment, error) {
+	id := item.AssignedTo()
+	if id == nil || project == "" {
+		return assignment{user: user, owner: user}, nil
+	}
+	if user == nil {
+		return e.fallback(id, nil, "has no Asana account"), nil
+	}
+	if e.member(project, user.GID) {
+		return assignment{user: user, owner: user}, nil
+	}
+	if e.cfg.Members.Add {
+		err := e.asana.AddProjectMembers(ctx, project, []string{user.GID})
+		if err == nil {
+			t := e.target(project)
+			e.state.Lock()
+			t.members[user.GID] = true
+			e.state.Unlock()
+			logging.From(ctx).Info("added assignee to asana project", "asana_user_gid", user.GID, "asana_project", project)
+			return assignment{user: user, owner: user}, nil
+		}
+		if e.cfg.Members.Fallback == "" {
+			return assignment{}, fmt.Errorf("adding assignee to asana project: %w", err)
+		}
+		logging.From(ctx).Warn("failed to add assignee to asana project, assigning the fallback assignee", "asana_user_gid", user.GID, "error", err)
+	}
+	if e.cfg.Members.Fallback == "" {
+		return assignment{user: user, owner: user}, nil
+	}
+	return e.fallback(id, user, "is not a member of the Asana project"), nil
+}
+
+// member reports whether the user is a member of project, or whether members are not tracked.
+func (e *Engine) member(project, gid string) bool {
+	t := e.target(project)
+	e.state.Lock()
+	defer e.state.Unlock()
+	return t.members == nil || t.members[gid]
+}
+
+// fallback returns the assignment of the fallback assignee standing in for the ADO assignee id, matched to
+// owner, or an unassigned task when there is no fallback assignee.
+func (e *Engine) fallback(id *ado.Identity, owner *asana.User, reason string) assignment {
+	u := e.users.lookup(e.cfg.Members.Fallback)
+	if e.cfg.Members.Fallback == "" || u == nil {
+		return assignment{owner: owner}
+	}
+	name := id.DisplayName
+	if name == "" {
+		name = id.UniqueName
+	} else if id.UniqueName != "" {
+		name += " (" + id.UniqueName + ")"
+	}
+	return assignment{user: u, owner: owner, note: fmt.Sprintf("This work item is assigned to %s in Azure DevOps, who %s, so the task is assigned to %s instead.", name, reason, u.Name)}
+}
+
+// assigned comments on task, just assigned as a, when the fallback assignee stands in for the intended
+// owner, and makes the owner a follower of the task when assignees follow their tasks.
+func (e *Engine) assigned(ctx context.Context, item ado.WorkItem, task *asana.Task, a assignment) error {
+	if a.note != "" {
+		story, err := e.asana.AddComment(ctx, task.GID, a.note)
+		if err != nil {
+			return fmt.Errorf("commenting on the fallback assignment: %w", err)
+		}
+		// The comment has no ADO counterpart. Mapping it to a negative ID derived from the story keeps it
+		// from being mirrored to the work item.
+		if n, err := strconv.Atoi(story.GID); err == nil {
+			if err := e.store.PutComment(ctx, store.CommentMapping{ADOID: item.ID, ADOCommentID: -n, AsanaStoryGID: story.GID, Origin: store.OriginADO}); err != nil {
+				return err
+			}
+		}
+		logging.From(ctx).Info("assigned asana task to the fallback assignee", "assignee", item.AssignedTo().UniqueName)
+	}
+	return e.follow(ctx, task, a)
+}
+
+// follow makes the owner of task a follower of it when assignees follow their tasks and they do not yet.
+func (e *Engine) follow(ctx context.Context, task *asana.Task, a assignment) error {
+	if !e.cfg.Members.Follow || a.owner == nil {
+		return nil
+	}
+	for _, f := range task.Followers {
+		if f.GID == a.owner.GID {
+			return nil
+		}
+	}
+	if err := e.asana.AddFollowers(ctx, task.GID, []string{a.owner.GID}); err != nil {
+		return fmt.Errorf("adding follower to asana task: %w", err)
+	}
+	logging.From(ctx).Info("made assignee a follower of asana task", "asana_user_gid", a.owner.GID)
+	return nil
+}
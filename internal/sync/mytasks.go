@@ -0,0 +1,118 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+)
+
+// MyTasksConfig mirrors the work items assigned to a set of users into the My Tasks of their Asana account
+// instead of a shared project, for engineers keeping a personal copy of their ADO work.
+type MyTasksConfig struct {
+	// Users lists the ADO unique names, usually email addresses, of the users whose work items are mirrored.
+	// Only their items are selected, and their tasks belong to no project. A task follows its item when it
+	// is reassigned to another of the users; an item reassigned to anybody else is removed as by the removal
+	// policy.
+	Users []string `json:"users,omitempty"`
+}
+
+// ParseMyTasks parses a comma separated list of the ADO unique names of the users mirrored into My Tasks.
+func ParseMyTasks(s string) MyTasksConfig {
+	var c MyTasksConfig
+	for _, u := range strings.Split(s, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			c.Users = append(c.Users, u)
+		}
+	}
+	return c
+}
+
+// enabled reports whether the pair mirrors into My Tasks.
+func (c MyTasksConfig) enabled() bool {
+	return len(c.Users) > 0
+}
+
+// condition returns the WIQL condition selecting the work items assigned to the users.
+func (c MyTasksConfig) condition() string {
+	quoted := make([]string, len(c.Users))
+	for i, u := range c.Users {
+		quoted[i] = "'" + strings.ReplaceAll(u, "'", "''") + "'"
+	}
+	return "[System.AssignedTo] IN (" + strings.Join(quoted, ", ") + ")"
+}
+
+// ValidateMyTasks checks that a pair mirroring into My Tasks has a workspace for its tasks and nothing that
+// needs a project.
+func (c Config) ValidateMyTasks() error {
+	if !c.MyTasks.enabled() {
+		return nil
+	}
+	switch {
+	case c.AsanaWorkspace == "":
+		return errors.New("my tasks requires an asana workspace")
+	case c.AsanaProject != "" || len(c.Routes) > 0:
+		return errors.New("my tasks cannot be combined with an asana project or routes")
+	case c.StatusUpdates.Enabled:
+		return errors.New("status updates need an asana project, which my tasks does not have")
+	case c.Removal.Policy == RemoveArchive:
+		return errors.New("the archive removal policy needs a project section, which my tasks does not have")
+	}
+	return nil
+}
+
+// mirrored returns the GIDs of the Asana users whose work items the pair mirrors into their My Tasks.
+func (e *Engine) mirrored() []string {
+	var gids []string
+	for _, name := range e.cfg.MyTasks.Users {
+		if u, _ := e.users.match(&ado.Identity{UniqueName: name}); u != nil {
+			gids = append(gids, u.GID)
+		}
+	}
+	return gids
+}
+
+// checkMirrors warns of the users mirrored into My Tasks that have no Asana account, whose items are skipped.
+func (e *Engine) checkMirrors(ctx context.Context) {
+	for _, name := range e.cfg.MyTasks.Users {
+		if u, _ := e.users.match(&ado.Identity{UniqueName: name}); u == nil {
+			logging.From(ctx).Warn("no asana user matches a user mirrored into my tasks", "assignee", name)
+		}
+	}
+}
+
+// mirrorTasks lists the tasks the users mirrored into My Tasks have outside any project, only those modified
+// since the given time unless it is zero.
+func (e *Engine) mirrorTasks(ctx context.Context, since time.Time) ([]asana.Task, error) {
+	var tasks []asana.Task
+	for _, gid := range e.mirrored() {
+		assigned, err := e.asana.AssignedTasks(ctx, e.cfg.AsanaWorkspace, gid, since)
+		if err != nil {
+			return nil, fmt.Errorf("listing my tasks of asana user %s: %w", gid, err)
+		}
+		for _, t := range assigned {
+			if len(t.Memberships) == 0 {
+				tasks = append(tasks, t)
+			}
+		}
+	}
+	return tasks, nil
+}
+
+// inMyTasks reports whether task is in the My Tasks of a mirrored user rather than a project.
+func (e *Engine) inMyTasks(task *asana.Task) bool {
+	if len(task.Memberships) > 0 || task.Assignee == nil {
+		return false
+	}
+	for _, gid := range e.mirrored() {
+		if gid == task.Assignee.GID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,368 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	htmltemplate "html/template"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/richtext"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// NotesFormat is how the work item description is given to the notes template.
+type NotesFormat string
+
+// Supported notes formats.
+const (
+	// NotesRich converts the description to Asana rich text, re-uploading its inline images.
+	NotesRich NotesFormat = "rich"
+	// NotesPlain reduces the description to plain text.
+	NotesPlain NotesFormat = "plain"
+)
+
+// ParseNotesFormat parses s as a NotesFormat. An empty string returns NotesRich.
+func ParseNotesFormat(s string) (NotesFormat, error) {
+	switch f := NotesFormat(strings.ToLower(strings.TrimSpace(s))); f {
+	case "":
+		return NotesRich, nil
+	case NotesRich, NotesPlain:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown notes format %q", s)
+	}
+}
+
+// OverflowPolicy is what is done with the part of a description that does not fit in the task notes.
+type OverflowPolicy string
+
+// Supported overflow policies.
+const (
+	// OverflowTruncate cuts the description, ending it with a link to the work item.
+	OverflowTruncate OverflowPolicy = "truncate"
+	// OverflowComment cuts the description and posts the rest of it as comments on the task.
+	OverflowComment OverflowPolicy = "comment"
+	// OverflowAttach cuts the description and attaches the full description to the task as an HTML file.
+	OverflowAttach OverflowPolicy = "attach"
+)
+
+// ParseOverflowPolicy parses s as an OverflowPolicy. An empty string returns OverflowTruncate.
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch p := OverflowPolicy(strings.ToLower(strings.TrimSpace(s))); p {
+	case "":
+		return OverflowTruncate, nil
+	case OverflowTruncate, OverflowComment, OverflowAttach:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown notes overflow policy %q", s)
+	}
+}
+
+// DefaultNotesLimit is the size in bytes of the largest html_notes written when no limit is configured,
+// somewhat below the size Asana accepts.
+const DefaultNotesLimit = 60000
+
+// overflowComment starts the comments holding the rest of the description of a work item, whose ID follows.
+const overflowComment = "Description of AB#"
+
+// notesLimit returns the size in bytes of the largest html_notes written.
+func (e *Engine) notesLimit() int {
+	if e.cfg.NotesLimit > 0 {
+		return e.cfg.NotesLimit
+	}
+	return DefaultNotesLimit
+}
+
+// notes renders the html_notes of the task of item. taskGID is the task the description's inline images are
+// attached to; they are left out while it is empty.
+func (e *Engine) notes(ctx context.Context, item ado.WorkItem, taskGID string) (string, error) {
+	notes, _, err := e.renderNotes(ctx, item, taskGID)
+	return notes, err
+}
+
+// renderNotes renders the html_notes of the task of item like notes, and reports whether the description
+// was cut to keep them within the notes limit. The rest of a cut description is handed to the overflow
+// policy once taskGID is known.
+func (e *Engine) renderNotes(ctx context.Context, item ado.WorkItem, taskGID string) (string, bool, error) {
+	desc := item.String(ado.FieldDescription)
+	d := newTaskData(item)
+	t := e.templatesFor(item)
+	if e.cfg.NotesFormat == NotesPlain {
+		d.Description = htmltemplate.HTML(html.EscapeString(richtext.PlainText(desc)))
+	} else {
+		var imgErr error
+		opts := richtext.Options{}
+		if taskGID != "" {
+			opts.Image = func(src, alt string) string {
+				if imgErr != nil {
+					return ""
+				}
+				gid, err := e.inlineImage(ctx, item, taskGID, src, alt)
+				imgErr = err
+				return gid
+			}
+		}
+		d.Description = htmltemplate.HTML(richtext.Convert(desc, opts))
+		if imgErr != nil {
+			return "", false, imgErr
+		}
+	}
+	notes, err := t.htmlNotes(d)
+	limit := e.notesLimit()
+	if err != nil || len(notes) <= limit {
+		return notes, false, err
+	}
+
+	full := string(d.Description)
+	footer := overflowFooter(item, e.cfg.NotesOverflow)
+	// The template may show the description more than once, so the room left for it is narrowed until the
+	// notes fit.
+	room := limit - (len(notes) - len(full)) - len(footer)
+	for {
+		head, _ := richtext.Truncate(full, room)
+		d.Description = htmltemplate.HTML(head + footer)
+		if notes, err = t.htmlNotes(d); err != nil {
+			return "", false, err
+		}
+		if len(notes) <= limit || room <= 0 {
+			logging.From(ctx).Warn("description exceeds the notes limit, cutting it", "limit", limit, "policy", string(e.cfg.NotesOverflow))
+			if taskGID != "" {
+				if err := e.overflow(ctx, item, taskGID, full, head); err != nil {
+					return "", false, err
+				}
+			}
+			return notes, true, nil
+		}
+		room -= len(notes) - limit
+	}
+}
+
+// overflowFooter returns the rich text ending a description cut by policy.
+func overflowFooter(item ado.WorkItem, policy OverflowPolicy) string {
+	text := "Description truncated."
+	switch policy {
+	case OverflowComment:
+		text = "The rest of the description is in the comments of this task."
+	case OverflowAttach:
+		text = "The full description is attached as " + overflowFile(item) + "."
+	}
+	if link := item.Link(); link != "" {
+		text = html.EscapeString(text) + ` <a href="` + html.EscapeString(link) + `">View the full work item</a>`
+	} else {
+		text = html.EscapeString(text)
+	}
+	return "\n<em>" + text + "</em>"
+}
+
+// overflowFile returns the name of the file holding the full description of item.
+func overflowFile(item ado.WorkItem) string {
+	return fmt.Sprintf("AB%d-description.html", item.ID)
+}
+
+// overflow hands the rest of the description full of item, of which the task notes show head, to the
+// overflow policy. Each version of the description is only commented or attached once.
+func (e *Engine) overflow(ctx context.Context, item ado.WorkItem, taskGID, full, head string) error {
+	sum := checksum([]byte(item.String(ado.FieldDescription)))
+	switch e.cfg.NotesOverflow {
+	case OverflowComment:
+		return e.overflowComments(ctx, item, taskGID, sum[:8], full, head)
+	case OverflowAttach:
+		return e.overflowAttachment(ctx, item, taskGID, sum)
+	}
+	return nil
+}
+
+// overflowComments posts the text of full after head as comments on the task, each within the notes limit.
+func (e *Engine) overflowComments(ctx context.Context, item ado.WorkItem, taskGID, ref, full, head string) error {
+	stories, err := e.asana.TaskComments(ctx, taskGID)
+	if err != nil {
+		return fmt.Errorf("listing asana comments: %w", err)
+	}
+	marker := fmt.Sprintf("%s%d continued (ref %s", overflowComment, item.ID, ref)
+	for _, s := range stories {
+		if strings.HasPrefix(s.Text, marker) {
+			return nil
+		}
+	}
+
+	rest := richtext.PlainText(full)
+	if h := richtext.PlainText(head); strings.HasPrefix(rest, h) {
+		rest = strings.TrimSpace(rest[len(h):])
+	}
+	var parts []string
+	for size := e.notesLimit() - len(marker) - 32; rest != ""; {
+		part := cutText(rest, size)
+		if part == "" {
+			part = rest
+		}
+		parts = append(parts, part)
+		rest = strings.TrimSpace(rest[len(part):])
+	}
+	for i, part := range parts {
+		text := fmt.Sprintf("%s, part %d of %d):\n\n%s", marker, i+1, len(parts), part)
+		if _, err := e.asana.AddComment(ctx, taskGID, text); err != nil {
+			return fmt.Errorf("commenting the rest of the description: %w", err)
+		}
+	}
+	logging.From(ctx).Info("posted the rest of the description as comments", "count", len(parts))
+	return nil
+}
+
+// cutText returns the start of the plain text s that is at most n bytes long, cut at a line end or space
+// when there is one in its second half.
+func cutText(s string, n int) string {
+	if n >= len(s) {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	s = s[:n]
+	if i := strings.LastIndex(s, "\n"); i > len(s)/2 {
+		return s[:i]
+	}
+	if i := strings.LastIndex(s, " "); i > len(s)/2 {
+		return s[:i]
+	}
+	return s
+}
+
+// overflowAttachment attaches the description of item, whose checksum is sum, to the task as an HTML file.
+func (e *Engine) overflowAttachment(ctx context.Context, item ado.WorkItem, taskGID, sum string) error {
+	known, err := e.store.Attachments(ctx, item.ID)
+	if err != nil {
+		return err
+	}
+	// The file is recorded like a mirrored attachment, so it is not mirrored back to the work item.
+	m := store.AttachmentMapping{ADOID: item.ID, ADOURL: "description:" + sum, Name: overflowFile(item), Origin: store.OriginADO}
+	for _, a := range known {
+		if a.ADOURL == m.ADOURL && a.AsanaGID != "" {
+			return nil
+		}
+	}
+	doc := fmt.Sprintf("<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>AB#%d %s</title></head>\n<body>%s</body></html>\n",
+		item.ID, html.EscapeString(item.String(ado.FieldTitle)), item.String(ado.FieldDescription))
+	m.SHA256 = checksum([]byte(doc))
+	att, err := e.asana.UploadAttachment(ctx, taskGID, m.Name, []byte(doc))
+	if err != nil {
+		return fmt.Errorf("attaching the full description: %w", err)
+	}
+	m.AsanaGID = att.GID
+	logging.From(ctx).Info("attached the full description", "name", m.Name)
+	return e.store.PutAttachment(ctx, m)
+}
+
+// hasImages reports whether the description of item shows inline images that are re-uploaded to its task.
+func (e *Engine) hasImages(item ado.WorkItem) bool {
+	if e.cfg.NotesFormat == NotesPlain {
+		return false
+	}
+	for _, src := range richtext.Images(item.String(ado.FieldDescription)) {
+		if isADOAttachment(src) {
+			return true
+		}
+	}
+	return false
+}
+
+// inlineImage returns the GID of the Asana attachment of task showing the inline image src of item, uploading
+// it on first use. Only images stored as ADO attachments are uploaded, as others need no credentials to view;
+// they and images over the attachment size limit are shown by their alt text.
+func (e *Engine) inlineImage(ctx context.Context, item ado.WorkItem, taskGID, src, alt string) (string, error) {
+	if !isADOAttachment(src) {
+		return "", nil
+	}
+	known, err := e.store.Attachments(ctx, item.ID)
+	if err != nil {
+		return "", err
+	}
+	for _, a := range known {
+		if a.ADOURL == src && a.AsanaGID != "" {
+			return a.AsanaGID, nil
+		}
+	}
+
+	max := e.cfg.MaxAttachmentSize
+	if max <= 0 {
+		max = DefaultMaxAttachmentSize
+	}
+	data, err := e.ado.DownloadAttachment(ctx, src, max)
+	if errors.Is(err, ado.ErrTooLarge) {
+		logging.From(ctx).Warn("skipping inline image: exceeds the attachment size limit", "limit", max)
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("downloading inline image: %w", err)
+	}
+	m := store.AttachmentMapping{ADOID: item.ID, ADOURL: src, Name: imageName(src, alt), SHA256: checksum(data), Origin: store.OriginADO}
+	for _, a := range known {
+		if a.SHA256 == m.SHA256 && a.AsanaGID != "" {
+			m.AsanaGID = a.AsanaGID
+		}
+	}
+	if m.AsanaGID == "" {
+		att, err := e.asana.UploadAttachment(ctx, taskGID, m.Name, data)
+		if err != nil {
+			return "", fmt.Errorf("uploading inline image: %w", err)
+		}
+		m.AsanaGID = att.GID
+		logging.From(ctx).Info("uploaded inline image", "name", m.Name)
+	}
+	if err := e.store.PutAttachment(ctx, m); err != nil {
+		return "", err
+	}
+	return m.AsanaGID, nil
+}
+
+// isADOAttachment reports whether src is a work item attachment URL.
+func isADOAttachment(src string) bool {
+	return strings.Contains(strings.ToLower(src), "/_apis/wit/attachments/")
+}
+
+// imageName returns the file name of an inline image, taken from the fileName parameter of its URL.
+func imageName(src, alt string) string {
+	if u, err := url.Parse(src); err == nil {
+		if name := u.Query().Get("fileName"); name != "" {
+			return path.Base(name)
+		}
+	}
+	if alt != "" {
+		return alt
+	}
+	return "image.png"
+}
+
+// updateTask updates the task, falling back to plain text notes when Asana rejects the rich text ones.
+func (e *Engine) updateTask(ctx context.Context, gid string, req asana.TaskRequest) (*asana.Task, error) {
+	t, err := e.asana.UpdateTask(ctx, gid, req)
+	var ae *asana.Error
+	if req.HTMLNotes == nil || !errors.As(err, &ae) || ae.StatusCode != http.StatusBadRequest {
+		return t, err
+	}
+	logging.From(ctx).Warn("asana rejected the rich text notes, writing plain text instead", "error", err)
+	req.Notes = asana.String(richtext.PlainText(*req.HTMLNotes))
+	req.HTMLNotes = nil
+	return e.asana.UpdateTask(ctx, gid, req)
+}
+
+// createTask creates a task, falling back to plain text notes when Asana rejects the rich text ones.
+func (e *Engine) createTask(ctx context.Context, req asana.TaskRequest) (*asana.Task, error) {
+	t, err := e.asana.CreateTask(ctx, req)
+	var ae *asana.Error
+	if req.HTMLNotes == nil || !errors.As(err, &ae) || ae.StatusCode != http.StatusBadRequest {
+		return t, err
+	}
+	logging.From(ctx).Warn("asana rejected the rich text notes of a new task, writing plain text instead", "error", err)
+	req.Notes = asana.String(richtext.PlainText(*req.HTMLNotes))
+	req.HTMLNotes = nil
+	return e.asana.CreateTask(ctx, req)
+}
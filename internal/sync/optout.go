@@ -0,0 +1,79 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// OptOut configures the marker that stops work items and tasks from syncing.
+type OptOut struct {
+	// Tag opts out the work items and tasks carrying an ADO or Asana tag of that name, matched ignoring case.
+	Tag string `json:"tag,omitempty"`
+	// Field is the name or GID of an Asana custom field that opts out the tasks on which it has a value.
+	Field string `json:"field,omitempty"`
+}
+
+// optedOut returns why item or its task, which may be nil, is opted out of the sync, or an empty string when
+// neither carries the marker.
+func (c Config) optedOut(item ado.WorkItem, task *asana.Task) string {
+	o := c.OptOut
+	if o.Tag != "" {
+		for _, t := range item.Tags() {
+			if strings.EqualFold(t, o.Tag) {
+				return "the work item has the ado tag " + t
+			}
+		}
+		if task != nil && hasTag(task, o.Tag) {
+			return "the task has the asana tag " + o.Tag
+		}
+	}
+	if o.Field != "" && task != nil {
+		if f, ok := findCustomField(task.CustomFields, o.Field); ok && hasValue(f) {
+			return "the task has a value in the asana field " + f.Name
+		}
+	}
+	return ""
+}
+
+// hasValue reports whether the custom field of a task is set.
+func hasValue(f asana.CustomField) bool {
+	return (f.TextValue != nil && *f.TextValue != "") || f.NumberValue != nil || f.EnumValue != nil ||
+		(f.DateValue != nil && f.DateValue.Date != "")
+}
+
+// optOut reports whether item is opted out of the sync, freezing its mapping when it is and thawing it once
+// the marker is gone. An opted out item without a mapping is simply not given a task.
+func (e *Engine) optOut(ctx context.Context, item ado.WorkItem, task *asana.Task) (bool, error) {
+	if e.cfg.OptOut.Tag == "" && e.cfg.OptOut.Field == "" {
+		return false, nil
+	}
+	reason := e.cfg.optedOut(item, task)
+	m, err := e.store.Get(ctx, item.ID)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		if reason != "" {
+			logging.From(ctx).Info("skipping work item: opted out of the sync", "reason", reason)
+		}
+		return reason != "", nil
+	case err != nil:
+		return false, err
+	case reason == "":
+		if m.Frozen {
+			// The mapping is written again, unfrozen, once the item has synced.
+			logging.From(ctx).Info("thawing mapping: the opt-out marker was removed")
+		}
+		return false, nil
+	case m.Frozen:
+		logging.From(ctx).Debug("skipping work item: its mapping is frozen", "reason", reason)
+		return true, nil
+	}
+	logging.From(ctx).Info("freezing mapping: opted out of the sync", "reason", reason)
+	m.Frozen = true
+	return true, e.store.Put(ctx, m)
+}
@@ -0,0 +1,136 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/metrics"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// ErrPaused is returned by the cycles and targeted syncs of a pair skipped because it is paused.
+var ErrPaused = errors.New("sync pair paused")
+
+// pauseKey is the store setting holding the JSON encoded Pause of a pair. The pair name is appended.
+const pauseKey = "sync_pause:"
+
+// Pause is a period during which a pair writes nothing to either side. Its cycles are skipped without moving
+// the watermark, so the first cycle after the pause syncs everything that changed meanwhile, and the work
+// items of targeted syncs are queued for a retry once it ends.
+type Pause struct {
+	// Since is when the pair was paused, or when its maintenance window opened.
+	Since time.Time `json:"since"`
+	// Until is when the pause ends by itself, zero for a pause lasting until the pair is resumed.
+	Until time.Time `json:"until,omitempty"`
+	// Reason is the reason given for the pause.
+	Reason string `json:"reason,omitempty"`
+	// Window is the maintenance window the pair is in, empty for pauses made by Engine.Pause.
+	Window string `json:"window,omitempty"`
+}
+
+// String describes how long the pause lasts.
+func (p *Pause) String() string {
+	s := "until resumed"
+	if !p.Until.IsZero() {
+		s = "until " + p.Until.Format(time.RFC3339)
+	}
+	if p.Window != "" {
+		s = "in maintenance window " + p.Window + " " + s
+	}
+	return s
+}
+
+// PausedPair returns the pause of the named pair recorded in st by Engine.Pause or SetPause, or nil when it
+// is not paused. Maintenance windows, which are part of the configuration, are not included.
+func PausedPair(ctx context.Context, st store.Store, pair string) (*Pause, error) {
+	v, err := st.Setting(ctx, pauseKey+pair)
+	switch {
+	case errors.Is(err, store.ErrNotFound) || (err == nil && v == ""):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	var p Pause
+	if err := json.Unmarshal([]byte(v), &p); err != nil {
+		return nil, fmt.Errorf("decoding pause of pair %q: %w", pair, err)
+	}
+	if !p.Until.IsZero() && !p.Until.After(time.Now()) {
+		return nil, nil
+	}
+	return &p, nil
+}
+
+// SetPause records p as the pause of the named pair in st, so its engine pauses from its next cycle on even
+// when it runs in another process sharing the store. A nil p resumes the pair.
+func SetPause(ctx context.Context, st store.Store, pair string, p *Pause) error {
+	v := ""
+	if p != nil {
+		b, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		v = string(b)
+	}
+	if err := st.SetSetting(ctx, pauseKey+pair, v); err != nil {
+		return fmt.Errorf("saving pause of pair %q: %w", pair, err)
+	}
+	return st.Flush(ctx)
+}
+
+// Pause pauses the pair until the given time, or until Resume when it is zero.
+func (e *Engine) Pause(ctx context.Context, until time.Time, reason string) error {
+	p := &Pause{Since: time.Now().UTC(), Reason: reason}
+	if !until.IsZero() {
+		p.Until = until.UTC()
+	}
+	if err := SetPause(ctx, e.store, e.Name(), p); err != nil {
+		return err
+	}
+	metrics.Paused.WithLabelValues(e.Name()).Set(1)
+	logging.From(ctx).Info("sync pair paused", logging.KeyPair, e.Name(), "pause", p.String(), "reason", reason)
+	return nil
+}
+
+// Resume ends the pause made by Pause. A pair in a maintenance window stays paused until the window closes.
+func (e *Engine) Resume(ctx context.Context) error {
+	if err := SetPause(ctx, e.store, e.Name(), nil); err != nil {
+		return err
+	}
+	logging.From(ctx).Info("sync pair resumed", logging.KeyPair, e.Name())
+	return nil
+}
+
+// Paused returns the pause the pair is in now: the one made by Pause, or else that of the maintenance window
+// it is in. It returns nil when the pair is not paused.
+func (e *Engine) Paused(ctx context.Context) (*Pause, error) {
+	p, err := PausedPair(ctx, e.store, e.Name())
+	if err != nil || p != nil {
+		return p, err
+	}
+	now := time.Now()
+	for _, w := range e.config().Maintenance {
+		if start, end, ok := w.Open(now); ok {
+			return &Pause{Since: start.UTC(), Until: end.UTC(), Window: w.String()}, nil
+		}
+	}
+	return nil, nil
+}
+
+// checkPaused returns an error wrapping ErrPaused when the pair is paused, and records the state in the
+// paused metric.
+func (e *Engine) checkPaused(ctx context.Context) error {
+	p, err := e.Paused(ctx)
+	if err != nil {
+		return fmt.Errorf("reading pause: %w", err)
+	}
+	if p == nil {
+		metrics.Paused.WithLabelValues(e.cfg.Name).Set(0)
+		return nil
+	}
+	metrics.Paused.WithLabelValues(e.cfg.Name).Set(1)
+	return fmt.Errorf("%s: %w", p, ErrPaused)
+}
@@ -0,0 +1,93 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// The creation of a task is made transactional with a pending mapping: it is recorded before the task is
+// created, given the GID of the task straight after, and cleared by the first full sync of the item. A
+// cycle that fails in between leaves the mapping pending, and recoverPending settles it next time instead of
+// a second task being created.
+
+// beginCreate records the pending mapping of item, whose task is about to be created.
+func (e *Engine) beginCreate(ctx context.Context, item ado.WorkItem) error {
+	if err := e.store.Put(ctx, store.Mapping{ADOID: item.ID, Title: item.Title(), Pair: e.cfg.Name, Pending: true}); err != nil {
+		return fmt.Errorf("recording pending mapping: %w", err)
+	}
+	return nil
+}
+
+// created records the GID of the task just created for item in its pending mapping. Should that fail, the
+// task is deleted again so the next cycle creates it afresh; a task left behind is found by recoverPending.
+func (e *Engine) created(ctx context.Context, item ado.WorkItem, task *asana.Task) error {
+	// The revision is left unset, so a sync of the item that did not finish is completed by the next one.
+	err := e.store.Put(ctx, store.Mapping{ADOID: item.ID, AsanaGID: task.GID, AsanaModified: task.ModifiedAt, Title: item.Title(),
+		LastSynced: time.Now().UTC(), Pair: e.cfg.Name, Pending: true})
+	if err == nil {
+		return nil
+	}
+	if derr := e.asana.DeleteTask(ctx, task.GID); derr != nil {
+		logging.From(ctx).Warn("failed to delete asana task whose mapping could not be recorded", "error", derr)
+	}
+	return fmt.Errorf("recording mapping of asana task %s: %w", task.GID, err)
+}
+
+// recoverPending settles the pair's mappings left pending by an earlier run, once per engine. A mapping
+// whose task exists is kept, so the next sync of its item completes it. One whose create had no known
+// outcome is given the task anchored to its item, when there is one. The others are forgotten, so their
+// item gets a task again.
+func (e *Engine) recoverPending(ctx context.Context) error {
+	if e.recovered {
+		return nil
+	}
+	all, err := e.store.All(ctx)
+	if err != nil {
+		return err
+	}
+	var pending []store.Mapping
+	for _, m := range all {
+		if m.Pending && e.owns(m) {
+			pending = append(pending, m)
+		}
+	}
+	var idx *taskIndex
+	for _, m := range pending {
+		ctx := logging.With(ctx, logging.KeyWorkItem, m.ADOID)
+		if m.AsanaGID != "" {
+			_, err := e.asana.GetTask(ctx, m.AsanaGID)
+			if err == nil {
+				continue
+			}
+			if !isNotFound(err) {
+				return fmt.Errorf("fetching asana task %s: %w", m.AsanaGID, err)
+			}
+		} else {
+			if idx == nil {
+				if idx, err = e.indexTasks(ctx); err != nil {
+					return err
+				}
+			}
+			if t := idx.byADOID[m.ADOID]; t != nil {
+				m.AsanaGID = t.GID
+				if err := e.store.Put(ctx, m); err != nil {
+					return err
+				}
+				logging.From(ctx).Info("recovered asana task of pending mapping", logging.KeyTask, t.GID)
+				continue
+			}
+		}
+		if err := e.store.Delete(ctx, m.ADOID); err != nil {
+			return err
+		}
+		logging.From(ctx).Info("forgot pending mapping without asana task")
+	}
+	e.recovered = true
+	return nil
+}
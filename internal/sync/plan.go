@@ -0,0 +1,576 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	gosync "sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// Action is the kind of write a planned change would perform.
+type Action string
+
+// Planned actions.
+const (
+	ActionCreate  Action = "create"
+	ActionUpdate  Action = "update"
+	ActionClose   Action = "close"
+	ActionDelete  Action = "delete"
+	ActionComment Action = "comment"
+	ActionAttach  Action = "attach"
+	// ActionResolve is an operator resolving a queued conflict, which is only audited.
+	ActionResolve Action = "resolve"
+)
+
+// Systems a change can target.
+const (
+	SystemADO   = "ado"
+	SystemAsana = "asana"
+)
+
+// Change is a single write that a dry run skipped.
+type Change struct {
+	// Pair is the name of the sync pair that planned the change.
+	Pair     string                 `json:"pair,omitempty"`
+	Action   Action                 `json:"action"`
+	System   string                 `json:"system"`
+	ADOID    int                    `json:"ado_id,omitempty"`
+	AsanaGID string                 `json:"asana_gid,omitempty"`
+	Fields   map[string]interface{} `json:"fields,omitempty"`
+}
+
+// Plan lists the writes a dry run would have made, in the order they would have happened.
+type Plan struct {
+	mu      gosync.Mutex
+	pair    string
+	Changes []Change `json:"changes"`
+}
+
+func (p *Plan) add(c Change) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c.Pair = p.pair
+	p.Changes = append(p.Changes, c)
+}
+
+// Merge returns a plan holding the changes of every plan in order.
+func Merge(plans ...*Plan) *Plan {
+	merged := &Plan{}
+	for _, p := range plans {
+		p.mu.Lock()
+		merged.Changes = append(merged.Changes, p.Changes...)
+		p.mu.Unlock()
+	}
+	return merged
+}
+
+// Counts returns the number of planned changes per action.
+func (p *Plan) Counts() map[Action]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	counts := map[Action]int{}
+	for _, c := range p.Changes {
+		counts[c.Action]++
+	}
+	return counts
+}
+
+// WriteText writes a human readable summary of the plan to w.
+func (p *Plan) WriteText(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.Changes) == 0 {
+		_, err := fmt.Fprintln(w, "No changes.")
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PAIR\tACTION\tSYSTEM\tWORK ITEM\tTASK\tFIELDS")
+	for _, c := range p.Changes {
+		id := ""
+		if c.ADOID != 0 {
+			id = fmt.Sprint(c.ADOID)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", c.Pair, c.Action, c.System, id, c.AsanaGID, formatFields(c.Fields))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\n%d changes planned.\n", len(p.Changes))
+	return err
+}
+
+// WriteJSON writes the plan to w as JSON.
+func (p *Plan) WriteJSON(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
+
+// formatFields renders changed fields as a sorted key=value list.
+func formatFields(fields map[string]interface{}) string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		b, _ := json.Marshal(fields[k])
+		parts = append(parts, k+"="+string(b))
+	}
+	return strings.Join(parts, " ")
+}
+
+// plannedPrefix marks the GIDs of tasks that a dry run pretended to create.
+const plannedPrefix = "planned-"
+
+// planADO records ADO writes in a plan instead of performing them.
+type planADO struct {
+	ADO
+	plan *Plan
+	cfg  Config
+
+	mu    gosync.Mutex
+	count int
+}
+
+// next returns a new number for a planned record.
+func (p *planADO) next() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.count++
+	return p.count
+}
+
+func (p *planADO) UpdateWorkItem(ctx context.Context, id int, ops []ado.PatchOperation) (*ado.WorkItem, error) {
+	items, err := p.ADO.GetWorkItems(ctx, []int{id})
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("work item %d not found", id)
+	}
+	wi := items[0]
+	c := Change{Action: ActionUpdate, System: SystemADO, ADOID: id, Fields: map[string]interface{}{}}
+	for _, op := range ops {
+		if op.Op == "test" {
+			continue
+		}
+		field := strings.TrimPrefix(op.Path, "/fields/")
+		if field == op.Path {
+			c.Fields[op.Path] = op.Value
+			continue
+		}
+		wi.Fields[field] = op.Value
+		c.Fields[field] = op.Value
+		if s, ok := op.Value.(string); ok && field == ado.FieldState && p.cfg.isClosed(s) {
+			c.Action = ActionClose
+		}
+	}
+	p.plan.add(c)
+	wi.Rev++
+	return &wi, nil
+}
+
+func (p *planADO) CreateWorkItem(_ context.Context, project, typ string, ops []ado.PatchOperation) (*ado.WorkItem, error) {
+	n := p.next()
+	wi := ado.WorkItem{ID: -n, Rev: 1, Fields: map[string]interface{}{ado.FieldWorkItemType: typ, ado.FieldTeamProject: project}}
+	c := Change{Action: ActionCreate, System: SystemADO, ADOID: -n, Fields: map[string]interface{}{ado.FieldWorkItemType: typ}}
+	for _, op := range ops {
+		field := strings.TrimPrefix(op.Path, "/fields/")
+		wi.Fields[field] = op.Value
+		c.Fields[field] = op.Value
+	}
+	p.plan.add(c)
+	return &wi, nil
+}
+
+func (p *planADO) AddComment(_ context.Context, _ string, id int, text string) (*ado.Comment, error) {
+	n := p.next()
+	p.plan.add(Change{Action: ActionComment, System: SystemADO, ADOID: id, Fields: map[string]interface{}{"text": text}})
+	return &ado.Comment{ID: -n, Text: text, CreatedDate: time.Now().UTC()}, nil
+}
+
+func (p *planADO) UploadAttachment(_ context.Context, _, name string, data []byte) (string, error) {
+	n := p.next()
+	p.plan.add(Change{Action: ActionAttach, System: SystemADO, Fields: map[string]interface{}{"name": name, "size": len(data)}})
+	return fmt.Sprintf("%s%d", plannedPrefix, n), nil
+}
+
+// planAsana records Asana writes in a plan instead of performing them.
+type planAsana struct {
+	Asana
+	plan  *Plan
+	store store.Store
+	// prefix starts the GIDs of planned records. It includes the pair name so plans of different pairs
+	// sharing a store never reuse a GID.
+	prefix string
+
+	mu    gosync.Mutex
+	count int
+	// sections maps section GIDs to names so planned moves show the section name.
+	sections map[string]string
+	// fields holds the custom fields listed so far by GID, and planned the custom fields and sections
+	// added to planned projects, which the rest of the run reads back.
+	fields  map[string]asana.CustomField
+	planned map[string]*plannedProject
+}
+
+// plannedProject is a project a dry run would create.
+type plannedProject struct {
+	fields   []asana.CustomField
+	sections []asana.Section
+}
+
+// gid returns a new GID for a planned record.
+func (p *planAsana) gid() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.count++
+	return fmt.Sprintf("%s%d", p.prefix, p.count)
+}
+
+func (p *planAsana) CreateTask(_ context.Context, req asana.TaskRequest) (*asana.Task, error) {
+	t := &asana.Task{GID: p.gid(), ModifiedAt: time.Now().UTC()}
+	applyTaskRequest(t, req)
+	c := Change{Action: ActionCreate, System: SystemAsana, Fields: requestFields(req)}
+	if id, ok := parseTaskID(t.Name); ok {
+		c.ADOID = id
+	}
+	p.plan.add(c)
+	return t, nil
+}
+
+func (p *planAsana) UpdateTask(ctx context.Context, gid string, req asana.TaskRequest) (*asana.Task, error) {
+	t, err := p.GetTask(ctx, gid)
+	if err != nil {
+		return nil, err
+	}
+	applyTaskRequest(t, req)
+	c := Change{Action: ActionUpdate, System: SystemAsana, AsanaGID: gid, Fields: requestFields(req)}
+	if req.Completed != nil && *req.Completed {
+		c.Action = ActionClose
+	}
+	if m, err := p.store.ByAsanaGID(ctx, gid); err == nil {
+		c.ADOID = m.ADOID
+	}
+	p.plan.add(c)
+	return t, nil
+}
+
+func (p *planAsana) DeleteTask(ctx context.Context, gid string) error {
+	c := Change{Action: ActionDelete, System: SystemAsana, AsanaGID: gid}
+	if m, err := p.store.ByAsanaGID(ctx, gid); err == nil {
+		c.ADOID = m.ADOID
+	}
+	p.plan.add(c)
+	return nil
+}
+
+func (p *planAsana) GetTask(ctx context.Context, gid string) (*asana.Task, error) {
+	if strings.HasPrefix(gid, plannedPrefix) {
+		return &asana.Task{GID: gid}, nil
+	}
+	return p.Asana.GetTask(ctx, gid)
+}
+
+func (p *planAsana) TaskComments(ctx context.Context, gid string) ([]asana.Story, error) {
+	if strings.HasPrefix(gid, plannedPrefix) {
+		return nil, nil
+	}
+	return p.Asana.TaskComments(ctx, gid)
+}
+
+func (p *planAsana) AddComment(_ context.Context, gid, text string) (*asana.Story, error) {
+	p.plan.add(Change{Action: ActionComment, System: SystemAsana, AsanaGID: gid, Fields: map[string]interface{}{"text": text}})
+	return &asana.Story{GID: p.gid(), Text: text}, nil
+}
+
+func (p *planAsana) Attachments(ctx context.Context, gid string) ([]asana.Attachment, error) {
+	if strings.HasPrefix(gid, plannedPrefix) {
+		return nil, nil
+	}
+	return p.Asana.Attachments(ctx, gid)
+}
+
+func (p *planAsana) UploadAttachment(_ context.Context, gid, name string, data []byte) (*asana.Attachment, error) {
+	p.plan.add(Change{Action: ActionAttach, System: SystemAsana, AsanaGID: gid, Fields: map[string]interface{}{"name": name, "size": len(data)}})
+	return &asana.Attachment{GID: p.gid(), Name: name}, nil
+}
+
+func (p *planAsana) ProjectTasks(ctx context.Context, projectGID string) ([]asana.Task, error) {
+	if strings.HasPrefix(projectGID, plannedPrefix) {
+		return nil, nil
+	}
+	return p.Asana.ProjectTasks(ctx, projectGID)
+}
+
+func (p *planAsana) ModifiedTasks(ctx context.Context, projectGID string, since time.Time) ([]asana.Task, error) {
+	if strings.HasPrefix(projectGID, plannedPrefix) {
+		return nil, nil
+	}
+	return p.Asana.ModifiedTasks(ctx, projectGID, since)
+}
+
+func (p *planAsana) ProjectCustomFields(ctx context.Context, projectGID string) ([]asana.CustomField, error) {
+	p.mu.Lock()
+	if pp, ok := p.planned[projectGID]; ok {
+		defer p.mu.Unlock()
+		return append([]asana.CustomField(nil), pp.fields...), nil
+	}
+	p.mu.Unlock()
+	fields, err := p.Asana.ProjectCustomFields(ctx, projectGID)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.fields == nil {
+		p.fields = map[string]asana.CustomField{}
+	}
+	for _, f := range fields {
+		p.fields[f.GID] = f
+	}
+	return fields, err
+}
+
+func (p *planAsana) ProjectSections(ctx context.Context, projectGID string) ([]asana.Section, error) {
+	p.mu.Lock()
+	if pp, ok := p.planned[projectGID]; ok {
+		defer p.mu.Unlock()
+		return append([]asana.Section(nil), pp.sections...), nil
+	}
+	p.mu.Unlock()
+	sections, err := p.Asana.ProjectSections(ctx, projectGID)
+	for _, s := range sections {
+		p.sectionName(s.GID, s.Name)
+	}
+	return sections, err
+}
+
+func (p *planAsana) CreateProject(_ context.Context, req asana.ProjectRequest) (*asana.Project, error) {
+	pr := &asana.Project{GID: p.gid(), Name: req.Name}
+	p.mu.Lock()
+	if p.planned == nil {
+		p.planned = map[string]*plannedProject{}
+	}
+	p.planned[pr.GID] = &plannedProject{}
+	p.mu.Unlock()
+	p.plan.add(Change{Action: ActionCreate, System: SystemAsana, Fields: map[string]interface{}{"project": req.Name, "team": req.Team}})
+	return pr, nil
+}
+
+func (p *planAsana) AddCustomFieldSetting(_ context.Context, projectGID, fieldGID string) error {
+	p.mu.Lock()
+	f, ok := p.fields[fieldGID]
+	if !ok {
+		f = asana.CustomField{GID: fieldGID}
+	}
+	if pp, ok := p.planned[projectGID]; ok {
+		pp.fields = append(pp.fields, f)
+	}
+	p.mu.Unlock()
+	p.plan.add(Change{Action: ActionUpdate, System: SystemAsana, Fields: map[string]interface{}{"project": projectGID, "add_custom_field": f.Name}})
+	return nil
+}
+
+func (p *planAsana) AddPortfolioItem(_ context.Context, portfolioGID, projectGID string) error {
+	p.plan.add(Change{Action: ActionUpdate, System: SystemAsana, Fields: map[string]interface{}{"portfolio": portfolioGID, "add_project": projectGID}})
+	return nil
+}
+
+func (p *planAsana) ProjectMembers(ctx context.Context, projectGID string) ([]asana.User, error) {
+	p.mu.Lock()
+	_, planned := p.planned[projectGID]
+	p.mu.Unlock()
+	if planned {
+		return nil, nil
+	}
+	return p.Asana.ProjectMembers(ctx, projectGID)
+}
+
+func (p *planAsana) AddProjectMembers(_ context.Context, projectGID string, users []string) error {
+	p.plan.add(Change{Action: ActionUpdate, System: SystemAsana, Fields: map[string]interface{}{"project": projectGID, "add_members": users}})
+	return nil
+}
+
+func (p *planAsana) AddFollowers(ctx context.Context, taskGID string, followers []string) error {
+	c := Change{Action: ActionUpdate, System: SystemAsana, AsanaGID: taskGID, Fields: map[string]interface{}{"add_followers": followers}}
+	if m, err := p.store.ByAsanaGID(ctx, taskGID); err == nil {
+		c.ADOID = m.ADOID
+	}
+	p.plan.add(c)
+	return nil
+}
+
+func (p *planAsana) CreateStatusUpdate(_ context.Context, req asana.StatusUpdateRequest) (*asana.StatusUpdate, error) {
+	p.plan.add(Change{Action: ActionCreate, System: SystemAsana, Fields: map[string]interface{}{"project": req.Parent, "status_update": req.Title, "status_type": req.StatusType}})
+	return &asana.StatusUpdate{GID: p.gid(), Title: req.Title, StatusType: req.StatusType}, nil
+}
+
+func (p *planAsana) DeleteStatusUpdate(_ context.Context, gid string) error {
+	p.plan.add(Change{Action: ActionDelete, System: SystemAsana, Fields: map[string]interface{}{"status_update": gid}})
+	return nil
+}
+
+func (p *planAsana) CreateSection(_ context.Context, projectGID, name string) (*asana.Section, error) {
+	s := &asana.Section{GID: p.gid(), Name: name}
+	p.sectionName(s.GID, name)
+	p.mu.Lock()
+	if pp, ok := p.planned[projectGID]; ok {
+		pp.sections = append(pp.sections, *s)
+	}
+	p.mu.Unlock()
+	p.plan.add(Change{Action: ActionCreate, System: SystemAsana, Fields: map[string]interface{}{"section": name, "project": projectGID}})
+	return s, nil
+}
+
+func (p *planAsana) CreateEnumOption(_ context.Context, fieldGID, name string) (*asana.EnumOption, error) {
+	p.plan.add(Change{Action: ActionCreate, System: SystemAsana, Fields: map[string]interface{}{"enum_option": name, "custom_field": fieldGID}})
+	return &asana.EnumOption{GID: p.gid(), Name: name}, nil
+}
+
+func (p *planAsana) CreateCustomField(_ context.Context, _, name, subtype string, options []string) (*asana.CustomField, error) {
+	f := asana.CustomField{GID: p.gid(), Name: name, ResourceSubtype: subtype}
+	for _, o := range options {
+		f.EnumOptions = append(f.EnumOptions, asana.EnumOption{GID: p.gid(), Name: o})
+	}
+	p.mu.Lock()
+	if p.fields == nil {
+		p.fields = map[string]asana.CustomField{}
+	}
+	p.fields[f.GID] = f
+	p.mu.Unlock()
+	p.plan.add(Change{Action: ActionCreate, System: SystemAsana, Fields: map[string]interface{}{"custom_field": name, "type": subtype}})
+	return &f, nil
+}
+
+func (p *planAsana) AddTaskToSection(ctx context.Context, sectionGID, taskGID string) error {
+	p.mu.Lock()
+	name := p.sections[sectionGID]
+	p.mu.Unlock()
+	c := Change{Action: ActionUpdate, System: SystemAsana, AsanaGID: taskGID, Fields: map[string]interface{}{"section": name}}
+	if m, err := p.store.ByAsanaGID(ctx, taskGID); err == nil {
+		c.ADOID = m.ADOID
+	}
+	p.plan.add(c)
+	return nil
+}
+
+func (p *planAsana) CreateTag(_ context.Context, workspaceGID, name string) (*asana.Tag, error) {
+	p.plan.add(Change{Action: ActionCreate, System: SystemAsana, Fields: map[string]interface{}{"tag": name, "workspace": workspaceGID}})
+	return &asana.Tag{GID: p.gid(), Name: name}, nil
+}
+
+func (p *planAsana) AddTag(ctx context.Context, taskGID, tagGID string) error {
+	return p.tagChange(ctx, taskGID, "add_tag", tagGID)
+}
+
+func (p *planAsana) RemoveTag(ctx context.Context, taskGID, tagGID string) error {
+	return p.tagChange(ctx, taskGID, "remove_tag", tagGID)
+}
+
+func (p *planAsana) tagChange(ctx context.Context, taskGID, field, tagGID string) error {
+	c := Change{Action: ActionUpdate, System: SystemAsana, AsanaGID: taskGID, Fields: map[string]interface{}{field: tagGID}}
+	if m, err := p.store.ByAsanaGID(ctx, taskGID); err == nil {
+		c.ADOID = m.ADOID
+	}
+	p.plan.add(c)
+	return nil
+}
+
+func (p *planAsana) SetParent(ctx context.Context, taskGID, parentGID string) error {
+	c := Change{Action: ActionUpdate, System: SystemAsana, AsanaGID: taskGID, Fields: map[string]interface{}{"parent": parentGID}}
+	if m, err := p.store.ByAsanaGID(ctx, taskGID); err == nil {
+		c.ADOID = m.ADOID
+	}
+	p.plan.add(c)
+	return nil
+}
+
+func (p *planAsana) AddDependencies(ctx context.Context, taskGID string, dependencies []string) error {
+	return p.dependencyChange(ctx, taskGID, "add_dependencies", dependencies)
+}
+
+func (p *planAsana) RemoveDependencies(ctx context.Context, taskGID string, dependencies []string) error {
+	return p.dependencyChange(ctx, taskGID, "remove_dependencies", dependencies)
+}
+
+func (p *planAsana) dependencyChange(ctx context.Context, taskGID, field string, dependencies []string) error {
+	c := Change{Action: ActionUpdate, System: SystemAsana, AsanaGID: taskGID, Fields: map[string]interface{}{field: dependencies}}
+	if m, err := p.store.ByAsanaGID(ctx, taskGID); err == nil {
+		c.ADOID = m.ADOID
+	}
+	p.plan.add(c)
+	return nil
+}
+
+func (p *planAsana) AddProject(ctx context.Context, taskGID, projectGID string) error {
+	return p.projectChange(ctx, taskGID, "add_project", projectGID)
+}
+
+func (p *planAsana) RemoveProject(ctx context.Context, taskGID, projectGID string) error {
+	return p.projectChange(ctx, taskGID, "remove_project", projectGID)
+}
+
+func (p *planAsana) projectChange(ctx context.Context, taskGID, field, projectGID string) error {
+	c := Change{Action: ActionUpdate, System: SystemAsana, AsanaGID: taskGID, Fields: map[string]interface{}{field: projectGID}}
+	if m, err := p.store.ByAsanaGID(ctx, taskGID); err == nil {
+		c.ADOID = m.ADOID
+	}
+	p.plan.add(c)
+	return nil
+}
+
+func (p *planAsana) sectionName(gid, name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.sections == nil {
+		p.sections = map[string]string{}
+	}
+	p.sections[gid] = name
+}
+
+// applyTaskRequest applies the fields set in req to t.
+func applyTaskRequest(t *asana.Task, req asana.TaskRequest) {
+	if req.Name != nil {
+		t.Name = *req.Name
+	}
+	if req.Notes != nil {
+		t.Notes = *req.Notes
+	}
+	if req.Completed != nil {
+		t.Completed = *req.Completed
+	}
+	if req.Assignee != nil {
+		t.Assignee = &asana.User{GID: *req.Assignee}
+	}
+	if req.DueOn != nil {
+		t.DueOn = string(*req.DueOn)
+	}
+	if req.External != nil {
+		t.External = req.External
+	}
+	if req.ResourceSubtype != nil {
+		t.ResourceSubtype = *req.ResourceSubtype
+	}
+	if req.ApprovalStatus != nil {
+		t.ApprovalStatus = *req.ApprovalStatus
+	}
+}
+
+// requestFields returns the fields set in req as a map.
+func requestFields(req asana.TaskRequest) map[string]interface{} {
+	b, _ := json.Marshal(req)
+	var fields map[string]interface{}
+	_ = json.Unmarshal(b, &fields)
+	return fields
+}
@@ -0,0 +1,80 @@
+package sync
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/danstis/ado-asana-sync/internal/logging"
+)
+
+// PriorityConfig orders the work items a cycle syncs, so the updates that matter most land first in cycles
+// with many items. Items are synced most recently changed first, after those boosted.
+type PriorityConfig struct {
+	// Boosts are WIQL conditions, such as "[System.WorkItemType] = 'Bug' AND [Microsoft.VSTS.Common.Priority]
+	// = 1", whose items are synced before the others. Items matching an earlier boost come before those
+	// matching a later one.
+	Boosts []string `json:"boosts,omitempty"`
+}
+
+// ParsePriority parses a semicolon separated list of the WIQL conditions boosting work items.
+func ParsePriority(s string) PriorityConfig {
+	var c PriorityConfig
+	for _, b := range strings.Split(s, ";") {
+		if b = strings.TrimSpace(b); b != "" {
+			c.Boosts = append(c.Boosts, b)
+		}
+	}
+	return c
+}
+
+// byRecency orders the results of the WIQL query q most recently changed first, replacing its own order.
+func byRecency(q string) string {
+	return q[:len(q)-len(orderBy.FindString(q))] + " ORDER BY [System.ChangedDate] DESC"
+}
+
+// prioritize orders the work items with the given IDs of a cycle as they are synced: those a boost selects
+// among the results of query first, in the order of the boosts, then by their position in recent, a page of
+// the items the cycle syncs most recently changed first. Items outside the page keep their order after it,
+// and only the first page of the items of each boost is boosted. A boost that cannot be queried is
+// skipped, since the order only speeds the cycle up.
+func (e *Engine) prioritize(ctx context.Context, query string, ids, recent []int) []int {
+	pos := make(map[int]int, len(recent))
+	for i, id := range recent {
+		pos[id] = i
+	}
+	rank := map[int]int{}
+	for i, b := range e.cfg.Priority.Boosts {
+		boosted, err := e.ado.QueryTop(ctx, e.cfg.ADOProject, andWhere(query, "("+b+")"), e.queryPage())
+		if err != nil {
+			logging.From(ctx).Warn("failed to query boosted work items, leaving them unboosted", "boost", b, "error", err)
+			continue
+		}
+		for _, id := range boosted {
+			if _, ok := rank[id]; !ok {
+				rank[id] = i
+			}
+		}
+	}
+	key := func(id int) (int, int) {
+		r, ok := rank[id]
+		if !ok {
+			r = len(e.cfg.Priority.Boosts)
+		}
+		p, ok := pos[id]
+		if !ok {
+			p = len(recent)
+		}
+		return r, p
+	}
+	ordered := append([]int(nil), ids...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		ri, pi := key(ordered[i])
+		rj, pj := key(ordered[j])
+		return ri < rj || (ri == rj && pi < pj)
+	})
+	if len(rank) > 0 {
+		logging.From(ctx).Debug("boosted work items to the front of the cycle", "boosted", len(rank))
+	}
+	return ordered
+}
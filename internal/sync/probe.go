@@ -0,0 +1,205 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// CheckResult is the outcome of a check.
+type CheckResult string
+
+// Outcomes of a check.
+const (
+	CheckPass CheckResult = "pass"
+	CheckFail CheckResult = "fail"
+	// CheckSkip is a check that was not run, because an earlier one failed or it was not asked for.
+	CheckSkip CheckResult = "skip"
+)
+
+// ProbeTaskName is the name of the task a write probe creates and deletes again.
+const ProbeTaskName = "ado-asana-sync permission check"
+
+// Check is one probe of the credentials and configuration of a pair.
+type Check struct {
+	// Name says what was checked, for example "ado query".
+	Name string
+	// Target is what it was checked on, such as a project.
+	Target string
+	Result CheckResult
+	// Detail is what the check found, or the error it failed with.
+	Detail string
+	// Hint suggests how to fix a failed check, when the failure is a known one.
+	Hint string
+}
+
+// Probe checks that the pair can do its work: that the ADO project exists and its query runs, that the
+// credentials may edit work items and, for development links, read repositories, that the Asana workspace
+// and projects exist, and that the configuration resolves against them as Validate does. With write a
+// task is created and deleted in every project to show the Asana token may write there. Nothing else is
+// written.
+func (e *Engine) Probe(ctx context.Context, write bool) []Check {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ctx = e.begin(ctx)
+	ctx, span := tracing.Tracer().Start(ctx, "sync.probe", trace.WithAttributes(attribute.String("sync.pair", e.cfg.Name)))
+	checks := e.probe(ctx, write)
+	tracing.End(span, nil)
+	return checks
+}
+
+func (e *Engine) probe(ctx context.Context, write bool) []Check {
+	var checks []Check
+	add := func(name, target string, err error, detail, hint string) bool {
+		c := Check{Name: name, Target: target, Result: CheckPass, Detail: detail}
+		if err != nil {
+			c.Result, c.Detail, c.Hint = CheckFail, err.Error(), hint
+		}
+		checks = append(checks, c)
+		return err == nil
+	}
+	skip := func(name, target, detail string) {
+		checks = append(checks, Check{Name: name, Target: target, Result: CheckSkip, Detail: detail})
+	}
+
+	project := e.cfg.ADOProject
+	_, err := e.ado.WorkItemTypes(ctx, project)
+	adoOK := add("ado project", project, err, "found", adoHint(err,
+		"the PAT needs the Work Items (Read) scope",
+		"check ADO_PROJECT or the pair's ado_project, no such project is visible to the PAT"))
+	var ids []int
+	if adoOK {
+		ids, err = e.selectIDs(ctx, project, e.cfg.WIQL(), 0)
+		adoOK = add("ado query", project, err, fmt.Sprintf("%d work items", len(ids)), adoHint(err,
+			"the PAT needs the Work Items (Read) scope",
+			"check the project the query refers to"))
+		if err != nil && statusOf(err) == http.StatusBadRequest {
+			checks[len(checks)-1].Hint = "the query is not valid WIQL, check ADO_QUERY or the pair's query"
+		}
+	} else {
+		skip("ado query", project, "the project could not be read")
+	}
+	switch {
+	case !adoOK:
+		skip("ado write", project, "the query could not be run")
+	case len(ids) == 0:
+		skip("ado write", project, "the query selects no work item to probe with")
+	default:
+		var items []ado.WorkItem
+		if items, err = e.ado.GetWorkItems(ctx, ids[:1]); err == nil && len(items) == 0 {
+			err = fmt.Errorf("work item %d not found", ids[0])
+		}
+		if err == nil {
+			err = e.ado.ValidateUpdate(ctx, items[0].ID, []ado.PatchOperation{ado.TestRev(items[0].Rev)})
+		}
+		add("ado write", fmt.Sprintf("work item %d", ids[0]), err, "may edit work items", adoHint(err,
+			"the PAT needs the Work Items (Read & write) scope, and its user the permission to edit work items in the area path",
+			""))
+	}
+	if e.cfg.Development {
+		_, err := e.ado.Repositories(ctx, project)
+		add("ado code", project, err, "may read repositories", adoHint(err,
+			"the PAT needs the Code (Read) scope to read the pull requests of development links", ""))
+	}
+	if e.cfg.TestCases {
+		_, err := e.ado.TestPoints(ctx, project, ids[:min(len(ids), 1)])
+		add("ado test", project, err, "may read test results", adoHint(err,
+			"the PAT needs the Test Management (Read) scope to read the outcomes of test runs", ""))
+	}
+
+	ws := e.cfg.AsanaWorkspace
+	w, err := e.asana.GetWorkspace(ctx, ws)
+	detail := ""
+	if err == nil {
+		detail = w.Name
+	}
+	asanaOK := add("asana workspace", ws, err, detail, asanaHint(err,
+		"check ASANA_WORKSPACE or the pair's asana_workspace, the token's user must be a member of it"))
+	for _, gid := range e.cfg.projects() {
+		if !asanaOK {
+			skip("asana project", gid, "the workspace could not be read")
+			continue
+		}
+		p, err := e.asana.GetProject(ctx, gid)
+		name := ""
+		if err == nil {
+			name = p.Name
+		}
+		if !add("asana project", gid, err, name, asanaHint(err,
+			"check ASANA_PROJECT, the pair's asana_project and its routes, the token's user must be able to see the project")) {
+			skip("asana write", gid, "the project could not be read")
+			continue
+		}
+		if !write {
+			skip("asana write", gid, "run with -write to create and delete a task")
+			continue
+		}
+		err = e.probeWrite(ctx, gid)
+		add("asana write", gid, err, "may create and delete tasks", asanaHint(err, "the token's user needs edit access to the project"))
+	}
+
+	if adoOK && asanaOK {
+		add("configuration", e.cfg.Name, e.Validate(ctx), "field mappings, sections, states and templates resolve", "")
+	} else {
+		skip("configuration", e.cfg.Name, "the projects could not be read")
+	}
+	return checks
+}
+
+// probeWrite creates a task in the project and deletes it again.
+func (e *Engine) probeWrite(ctx context.Context, project string) error {
+	t, err := e.asana.CreateTask(ctx, asana.TaskRequest{Name: asana.String(ProbeTaskName), Projects: []string{project}})
+	if err != nil {
+		return fmt.Errorf("creating a task: %w", err)
+	}
+	if err := e.asana.DeleteTask(ctx, t.GID); err != nil {
+		return fmt.Errorf("deleting probe task %s: %w", t.GID, err)
+	}
+	return nil
+}
+
+// adoHint returns the fix for an ADO error: the PAT for 401s, forbidden for 403s and notFound for 404s.
+func adoHint(err error, forbidden, notFound string) string {
+	switch statusOf(err) {
+	case http.StatusUnauthorized:
+		return "the credentials were refused, check that the PAT of the connection is set and has not expired"
+	case http.StatusForbidden:
+		return forbidden
+	case http.StatusNotFound:
+		return notFound
+	}
+	return ""
+}
+
+// asanaHint returns the fix for an Asana error: the token for 401s, and notFound for 403s and 404s, which
+// Asana returns for objects the token cannot see.
+func asanaHint(err error, notFound string) string {
+	switch statusOf(err) {
+	case http.StatusUnauthorized:
+		return "the token was refused, check that the token of the connection is set and has not expired"
+	case http.StatusForbidden, http.StatusNotFound:
+		return notFound
+	}
+	return ""
+}
+
+// statusOf returns the HTTP status of an API error, or 0 for other errors.
+func statusOf(err error) int {
+	var ae *asana.Error
+	if errors.As(err, &ae) {
+		return ae.StatusCode
+	}
+	var de *ado.Error
+	if errors.As(err, &de) {
+		return de.StatusCode
+	}
+	return 0
+}
@@ -0,0 +1,60 @@
+package sync
+
+import (
+	gosync "sync"
+	"time"
+)
+
+// Progress is how far the running cycle of a pair got.
+type Progress struct {
+	// Running is set while a cycle runs. Otherwise the other fields describe the last cycle.
+	Running bool      `json:"running"`
+	Started time.Time `json:"started"`
+	// Total is the number of work items the cycle read to sync so far, which grows as it reads its selection
+	// a page at a time. Done counts the items synced so far and Failed those of them that failed.
+	Total  int `json:"total"`
+	Done   int `json:"done"`
+	Failed int `json:"failed"`
+}
+
+// progress tracks the Progress of the cycles of an engine. It is read without waiting for the cycle.
+type progress struct {
+	mu gosync.Mutex
+	p  Progress
+}
+
+func (p *progress) start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.p = Progress{Running: true, Started: time.Now()}
+}
+
+func (p *progress) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.p.Running = false
+}
+
+// add adds n items to the total of the cycle.
+func (p *progress) add(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.p.Total += n
+}
+
+// done counts a synced item.
+func (p *progress) done(failed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.p.Done++
+	if failed {
+		p.p.Failed++
+	}
+}
+
+// Progress returns how far the running cycle of the pair got, or the outcome of the last one.
+func (e *Engine) Progress() Progress {
+	e.live.mu.Lock()
+	defer e.live.mu.Unlock()
+	return e.live.p
+}
@@ -0,0 +1,159 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// ProvisionConfig controls the creation of the Asana projects that routes refer to by name.
+type ProvisionConfig struct {
+	// Enabled lets routes name Asana projects instead of giving their GID. A project is created the first
+	// time an item is routed to it and registered in the store, so it is found again by name.
+	Enabled bool `json:"enabled"`
+	// Template is the GID of a project whose sections and custom fields are copied to new projects.
+	Template string `json:"template,omitempty"`
+	// Team is the GID of the team new projects belong to, which organizations require.
+	Team string `json:"team,omitempty"`
+	// Portfolio, when set, is the GID of a portfolio new projects are added to.
+	Portfolio string `json:"portfolio,omitempty"`
+}
+
+// ValidateProvision checks that routes only name projects by name when provisioning is enabled, and that
+// the workspace new projects are created in is known.
+func (c Config) ValidateProvision() error {
+	for _, r := range c.Routes {
+		if !isGID(r.Project) && !c.Provision.Enabled {
+			return fmt.Errorf("route %q: project %q is not a GID and provisioning is disabled", r.Area, r.Project)
+		}
+	}
+	if c.Provision.Enabled && c.AsanaWorkspace == "" {
+		return fmt.Errorf("provisioning projects requires an asana workspace")
+	}
+	return nil
+}
+
+// projectFor returns the GID of the Asana project of item, provisioning the project when its route names
+// one that does not exist yet. It is empty when the item is routed nowhere.
+func (e *Engine) projectFor(ctx context.Context, item ado.WorkItem) (string, error) {
+	project := e.cfg.projectFor(item)
+	if project == "" || isGID(project) {
+		return project, nil
+	}
+	return e.provisionProject(ctx, project)
+}
+
+// provisionProject returns the GID of the project the pair provisioned under name, creating it from the
+// template when the store has no record of it.
+func (e *Engine) provisionProject(ctx context.Context, name string) (string, error) {
+	e.provision.Lock()
+	defer e.provision.Unlock()
+	e.state.Lock()
+	gid, ok := e.provisioned[name]
+	e.state.Unlock()
+	if ok {
+		return gid, nil
+	}
+
+	// A project registered after Validate, or whose setup failed part way, is picked up again by name.
+	p, err := e.store.Project(ctx, e.cfg.Name, name)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		created, err := e.asana.CreateProject(ctx, asana.ProjectRequest{Name: name, Workspace: e.cfg.AsanaWorkspace, Team: e.cfg.Provision.Team})
+		if err != nil {
+			return "", fmt.Errorf("creating asana project %q: %w", name, err)
+		}
+		p = store.Project{Pair: e.cfg.Name, Name: name, GID: created.GID, Created: time.Now().UTC()}
+		if err := e.store.PutProject(ctx, p); err != nil {
+			return "", err
+		}
+		logging.From(ctx).Info("provisioned asana project", "name", name, "asana_project", p.GID)
+	case err != nil:
+		return "", err
+	}
+
+	if err := e.applyTemplate(ctx, p.GID); err != nil {
+		return "", err
+	}
+	if e.cfg.Provision.Portfolio != "" {
+		if err := e.asana.AddPortfolioItem(ctx, e.cfg.Provision.Portfolio, p.GID); err != nil {
+			return "", fmt.Errorf("adding asana project %q to portfolio: %w", name, err)
+		}
+	}
+	t, err := e.resolveTarget(ctx, p.GID)
+	if err != nil {
+		return "", err
+	}
+
+	e.state.Lock()
+	defer e.state.Unlock()
+	others := make([]*target, 0, len(e.targets))
+	for _, o := range e.targets {
+		others = append(others, o)
+	}
+	t.share(others)
+	if e.targets == nil {
+		e.targets = map[string]*target{}
+	}
+	if e.provisioned == nil {
+		e.provisioned = map[string]string{}
+	}
+	e.targets[p.GID] = t
+	e.provisioned[name] = p.GID
+	e.projectGIDs = append(e.projectGIDs, p.GID)
+	return p.GID, nil
+}
+
+// applyTemplate adds the sections and custom fields of the template project that the project does not have
+// yet.
+func (e *Engine) applyTemplate(ctx context.Context, project string) error {
+	tmpl := e.cfg.Provision.Template
+	if tmpl == "" {
+		return nil
+	}
+	fields, err := e.asana.ProjectCustomFields(ctx, tmpl)
+	if err != nil {
+		return fmt.Errorf("listing template custom fields: %w", err)
+	}
+	have, err := e.asana.ProjectCustomFields(ctx, project)
+	if err != nil {
+		return fmt.Errorf("listing asana custom fields: %w", err)
+	}
+	for _, f := range fields {
+		if _, ok := findCustomField(have, f.GID); ok {
+			continue
+		}
+		if err := e.asana.AddCustomFieldSetting(ctx, project, f.GID); err != nil {
+			return fmt.Errorf("adding custom field %q to asana project: %w", f.Name, err)
+		}
+	}
+
+	sections, err := e.asana.ProjectSections(ctx, tmpl)
+	if err != nil {
+		return fmt.Errorf("listing template sections: %w", err)
+	}
+	existing, err := e.asana.ProjectSections(ctx, project)
+	if err != nil {
+		return fmt.Errorf("listing asana sections: %w", err)
+	}
+	names := make(map[string]bool, len(existing))
+	for _, s := range existing {
+		names[strings.ToLower(s.Name)] = true
+	}
+	for _, s := range sections {
+		if names[strings.ToLower(s.Name)] {
+			continue
+		}
+		if _, err := e.asana.CreateSection(ctx, project, s.Name); err != nil {
+			return fmt.Errorf("creating asana section %q: %w", s.Name, err)
+		}
+	}
+	return nil
+}
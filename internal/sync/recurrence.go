@@ -0,0 +1,119 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// occurrencesKey is the store setting holding, as JSON, the tasks of the earlier occurrences of a recurring
+// work item, oldest first. The pair name and the work item ID are appended.
+const occurrencesKey = "occurrences:"
+
+// RecurrenceConfig marks the work items that recur, such as chores reopened every sprint. A recurring work
+// item reopened after its task was completed gets a new task for the occurrence, and the completed task is
+// kept as the record of the earlier one.
+type RecurrenceConfig struct {
+	// Tag marks the work items carrying an ADO tag of that name, matched ignoring case.
+	Tag string `json:"tag,omitempty"`
+	// Field is the reference name of an ADO field marking the work items on which it has a value other than
+	// false.
+	Field string `json:"field,omitempty"`
+}
+
+// enabled reports whether any work item can recur.
+func (c RecurrenceConfig) enabled() bool {
+	return c.Tag != "" || c.Field != ""
+}
+
+// recurs reports whether item carries the recurrence marker.
+func (c RecurrenceConfig) recurs(item ado.WorkItem) bool {
+	if c.Tag != "" {
+		for _, t := range item.Tags() {
+			if strings.EqualFold(t, c.Tag) {
+				return true
+			}
+		}
+	}
+	if c.Field == "" {
+		return false
+	}
+	switch v := item.Fields[c.Field].(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != "" && !strings.EqualFold(v, "false")
+	default:
+		return true
+	}
+}
+
+// occurrence returns the task that the current occurrence of item syncs with. When item recurs and was
+// reopened since its task was completed, the task is recorded as that of an earlier occurrence and nil is
+// returned, so a task is created for the new one. want is the state the task of item should show.
+func (e *Engine) occurrence(ctx context.Context, item ado.WorkItem, task *asana.Task, want taskState) (*asana.Task, error) {
+	c := e.cfg.Recurrence
+	if task == nil || !task.Completed || want.completed || !c.enabled() || !c.recurs(item) {
+		return task, nil
+	}
+	earlier, err := e.Occurrences(ctx, item.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, gid := range earlier {
+		// The task of an earlier occurrence found again, as when creating the task of the new one failed.
+		if gid == task.GID {
+			return nil, nil
+		}
+	}
+	switch m, err := e.store.Get(ctx, item.ID); {
+	case errors.Is(err, store.ErrNotFound):
+		return task, nil
+	case err != nil:
+		return nil, err
+	case m.AsanaGID != task.GID || !m.Completed || !item.StateChangeDate().After(m.LastSynced):
+		// The task was completed in Asana while the work item stayed open, or reopened by it in Asana.
+		return task, nil
+	}
+	b, err := json.Marshal(append(earlier, task.GID))
+	if err != nil {
+		return nil, err
+	}
+	if err := e.store.SetSetting(ctx, e.occurrencesKey(item.ID), string(b)); err != nil {
+		return nil, fmt.Errorf("recording earlier occurrence: %w", err)
+	}
+	logging.From(ctx).Info("recurring work item reopened, creating a task for its new occurrence", "occurrence", len(earlier)+2)
+	return nil, nil
+}
+
+// Occurrences returns the GIDs of the tasks of the earlier occurrences of the recurring work item with the
+// given ID, oldest first. The task of the current occurrence is that of its mapping.
+func (e *Engine) Occurrences(ctx context.Context, adoID int) ([]string, error) {
+	v, err := e.store.Setting(ctx, e.occurrencesKey(adoID))
+	switch {
+	case errors.Is(err, store.ErrNotFound) || err == nil && v == "":
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	var gids []string
+	if err := json.Unmarshal([]byte(v), &gids); err != nil {
+		return nil, fmt.Errorf("reading earlier occurrences of work item %d: %w", adoID, err)
+	}
+	return gids, nil
+}
+
+// occurrencesKey returns the store setting holding the earlier occurrences of the work item with the given ID.
+func (e *Engine) occurrencesKey(adoID int) string {
+	return occurrencesKey + e.cfg.Name + ":" + strconv.Itoa(adoID)
+}
@@ -0,0 +1,141 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// RemovalPolicy decides what happens to the task of a work item that was deleted or no longer matches the
+// pair's query.
+type RemovalPolicy string
+
+// Supported removal policies.
+const (
+	// RemoveKeep leaves the task and its mapping alone.
+	RemoveKeep     RemovalPolicy = "keep"
+	RemoveComplete RemovalPolicy = "complete"
+	RemoveArchive  RemovalPolicy = "archive"
+	RemoveDelete   RemovalPolicy = "delete"
+	RemoveTag      RemovalPolicy = "tag"
+)
+
+// Defaults of the archive section and removal tag.
+const (
+	DefaultArchiveSection = "Archive"
+	DefaultRemovalTag     = "removed-from-ado"
+)
+
+// RemovalConfig controls the handling of tasks whose work item left the pair.
+type RemovalConfig struct {
+	// Policy is applied to removed items. Nothing is done when it is empty or RemoveKeep.
+	Policy RemovalPolicy `json:"policy,omitempty"`
+	// Section is the section RemoveArchive moves tasks to, defaulting to DefaultArchiveSection.
+	Section string `json:"section,omitempty"`
+	// Tag is the tag RemoveTag adds to tasks, defaulting to DefaultRemovalTag.
+	Tag string `json:"tag,omitempty"`
+}
+
+// ParseRemovalPolicy parses s as a RemovalPolicy. An empty string returns RemoveKeep.
+func ParseRemovalPolicy(s string) (RemovalPolicy, error) {
+	switch p := RemovalPolicy(strings.ToLower(strings.TrimSpace(s))); p {
+	case "":
+		return RemoveKeep, nil
+	case RemoveKeep, RemoveComplete, RemoveArchive, RemoveDelete, RemoveTag:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown removal policy %q", s)
+	}
+}
+
+// active reports whether removed items are handled at all.
+func (c RemovalConfig) active() bool {
+	return c.Policy != "" && c.Policy != RemoveKeep
+}
+
+// reconcile applies the removal policy to the mappings of the pair whose work item the selection of the pair's
+// query left, as sw found once it followed the whole selection, and returns how many were removed. Mappings without a pair are left alone, as
+// another pair may own them. A query selecting nothing is more likely broken than empty, so nothing is removed.
+func (e *Engine) reconcile(ctx context.Context, sw *sweep) (int, error) {
+	if !e.cfg.Removal.active() {
+		return 0, nil
+	}
+	if sw.seen == 0 {
+		logging.From(ctx).Warn("query selected no work items, skipping removal of unselected tasks")
+		return 0, nil
+	}
+	removed := 0
+	for _, id := range sw.gone {
+		m, err := e.store.Get(ctx, id)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		if m.Pair != e.cfg.Name || m.Frozen {
+			continue
+		}
+		if m.AsanaGID == "" {
+			// A pending mapping whose task may not exist has nothing to remove.
+			if err := e.store.Delete(ctx, m.ADOID); err != nil {
+				return removed, err
+			}
+			continue
+		}
+		if err := e.remove(logging.With(ctx, logging.KeyWorkItem, m.ADOID, logging.KeyTask, m.AsanaGID), m); err != nil {
+			return removed, fmt.Errorf("removing work item %d: %w", m.ADOID, err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// remove applies the removal policy to the task of m and forgets the mapping. Should the work item return, the
+// task is found again by the work item ID in its name.
+func (e *Engine) remove(ctx context.Context, m store.Mapping) error {
+	r := e.cfg.Removal
+	var err error
+	switch r.Policy {
+	case RemoveComplete:
+		if !m.Completed {
+			_, err = e.asana.UpdateTask(ctx, m.AsanaGID, asana.TaskRequest{Completed: asana.Bool(true)})
+		}
+	case RemoveArchive:
+		name := r.Section
+		if name == "" {
+			name = DefaultArchiveSection
+		}
+		var project, gid string
+		if project, err = e.projectOf(ctx, m.AsanaGID); err != nil {
+			break
+		}
+		if gid, err = e.section(ctx, project, name); err == nil {
+			err = e.asana.AddTaskToSection(ctx, gid, m.AsanaGID)
+		}
+	case RemoveDelete:
+		err = e.asana.DeleteTask(ctx, m.AsanaGID)
+	case RemoveTag:
+		name := r.Tag
+		if name == "" {
+			name = DefaultRemovalTag
+		}
+		var tag asana.Tag
+		if tag, err = e.tags.get(ctx, e.asana, e.cfg.AsanaWorkspace, name); err == nil {
+			err = e.asana.AddTag(ctx, m.AsanaGID, tag.GID)
+		}
+	}
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	if err := e.store.Delete(ctx, m.ADOID); err != nil {
+		return err
+	}
+	logging.From(ctx).Info("work item left the pair, applied removal policy", "policy", r.Policy)
+	return nil
+}
@@ -0,0 +1,71 @@
+package sync
+
+import (
+	"fmt"
+	"io"
+	gosync "sync"
+	"text/tabwriter"
+
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// Report summarizes the outcome of a sync cycle.
+type Report struct {
+	mu gosync.Mutex
+	// NewConflicts is the number of conflicts queued for manual resolution during the cycle.
+	NewConflicts int
+	// Failures lists the work items that could not be synced. They do not stop the rest of the cycle.
+	Failures []Failure
+	// Full is set when the cycle reconciled every item rather than only the changed ones.
+	Full bool
+	// Items is the number of work items the cycle fetched for syncing.
+	Items int
+	// Created is the number of tasks created, and Updated the number of work items whose task or work item
+	// was updated.
+	Created, Updated int
+	// Intake is the number of work items created for Asana tasks marked for intake.
+	Intake int
+	// Removed is the number of work items whose task the removal policy was applied to.
+	Removed int
+	// Skipped is the number of work items left alone: those of types that are not synced, those opted out
+	// or frozen, and those assigned to nobody in Asana.
+	Skipped int
+	// Conflicts lists every unresolved conflict at the end of the cycle.
+	Conflicts []store.Conflict
+	// Plan lists the skipped writes of a dry run. It is nil for normal runs.
+	Plan *Plan
+}
+
+// WriteConflicts writes a table of the unresolved conflicts to w.
+func (r *Report) WriteConflicts(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "WORK ITEM\tTASK\tFIELD\tADO VALUE\tASANA VALUE\tDETECTED")
+	for _, c := range r.Conflicts {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%q\t%q\t%s\n", c.ADOID, c.AsanaGID, c.Field, c.ADOValue, c.AsanaValue, c.DetectedAt.Format("2006-01-02 15:04"))
+	}
+	return tw.Flush()
+}
+
+// Failure records a work item whose sync failed.
+type Failure struct {
+	ADOID int
+	Err   error
+}
+
+func (r *Report) conflictQueued() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.NewConflicts++
+}
+
+func (r *Report) count(n *int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	*n++
+}
+
+func (r *Report) fail(adoID int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Failures = append(r.Failures, Failure{ADOID: adoID, Err: err})
+}
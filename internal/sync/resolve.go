@@ -0,0 +1,172 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// Resolution is how an operator resolves a conflict queued for manual resolution.
+type Resolution struct {
+	// Winner is the side whose value both sides are given, SystemADO or SystemAsana.
+	Winner string
+	// Merged, when set, is a value written to both sides instead of either side's value. It is written to
+	// the work item, which then wins.
+	Merged *string
+}
+
+// resolveKey is the context key of the conflict being resolved.
+type resolveKey struct{}
+
+// resolving is the conflict being resolved by a targeted sync and the side that wins it.
+type resolving struct {
+	adoID int
+	field Field
+	side  side
+}
+
+// resolved returns the side that wins the conflict of field f of the work item with the given ID, when ctx
+// resolves it.
+func resolved(ctx context.Context, adoID int, f Field) (side, bool) {
+	r, ok := ctx.Value(resolveKey{}).(resolving)
+	if !ok || r.adoID != adoID || r.field != f {
+		return sideADO, false
+	}
+	return r.side, true
+}
+
+// Conflicts returns the conflicts queued for manual resolution by the work items of the pair.
+func (e *Engine) Conflicts(ctx context.Context) ([]store.Conflict, error) {
+	all, err := e.store.Conflicts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var conflicts []store.Conflict
+	for _, c := range all {
+		if m, err := e.store.Get(ctx, c.ADOID); err == nil && m.Pair == e.cfg.Name {
+			conflicts = append(conflicts, c)
+		}
+	}
+	return conflicts, nil
+}
+
+// ResolveConflict resolves the queued conflict of field of the work item with the given ID as r says,
+// syncing the item so the winning value is written to the other side, and records the resolution in the
+// audit log.
+func (e *Engine) ResolveConflict(ctx context.Context, adoID int, field string, r Resolution) (*Report, error) {
+	c, err := e.store.Conflict(ctx, adoID, field)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, fmt.Errorf("no conflict of field %s is queued for work item %d", field, adoID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s, kept := sideADO, c.ADOValue
+	switch {
+	case r.Merged != nil:
+		if err := e.writeMerged(ctx, c, *r.Merged); err != nil {
+			return nil, err
+		}
+		kept = *r.Merged
+	case r.Winner == SystemAsana:
+		s, kept = sideAsana, c.AsanaValue
+	case r.Winner != SystemADO:
+		return nil, fmt.Errorf("unknown winner %q, expected %s or %s", r.Winner, SystemADO, SystemAsana)
+	}
+
+	rep, err := e.SyncItem(context.WithValue(ctx, resolveKey{}, resolving{adoID: adoID, field: Field(field), side: s}), adoID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := e.store.Conflict(ctx, adoID, field); err == nil {
+		return nil, fmt.Errorf("conflict of field %s of work item %d is still queued, the work item was not synced", field, adoID)
+	}
+
+	now := time.Now().UTC()
+	for _, w := range []struct{ system, before string }{{SystemADO, c.ADOValue}, {SystemAsana, c.AsanaValue}} {
+		if w.before == kept {
+			continue
+		}
+		record := store.AuditRecord{
+			Time: now, Pair: e.cfg.Name, System: w.system, Action: string(ActionResolve), ADOID: c.ADOID, AsanaGID: c.AsanaGID,
+			Changes: []store.FieldChange{{Field: field, Before: w.before, After: kept}},
+		}
+		if err := e.store.PutAudit(ctx, record); err != nil {
+			return rep, fmt.Errorf("recording the resolution: %w", err)
+		}
+	}
+	return rep, nil
+}
+
+// writeMerged writes the merged value of the conflict c to its work item.
+func (e *Engine) writeMerged(ctx context.Context, c store.Conflict, value string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.prepare(ctx); err != nil {
+		return err
+	}
+	items, err := e.ado.GetWorkItems(ctx, []int{c.ADOID})
+	if err != nil {
+		return fmt.Errorf("fetching work item %d: %w", c.ADOID, err)
+	}
+	if len(items) == 0 {
+		return fmt.Errorf("work item %d not found", c.ADOID)
+	}
+	op, ok, err := e.mergedOp(items[0], Field(c.Field), value)
+	if err != nil || !ok {
+		return err
+	}
+	if _, err := e.updateWorkItem(ctx, items[0], []ado.PatchOperation{op}); err != nil {
+		return fmt.Errorf("writing the merged value: %w", err)
+	}
+	return nil
+}
+
+// mergedOp returns the operation setting field f of item to value. ok is false when there is nothing to write.
+func (e *Engine) mergedOp(item ado.WorkItem, f Field, value string) (op ado.PatchOperation, ok bool, err error) {
+	switch f {
+	case FieldTitle:
+		return ado.SetField(ado.FieldTitle, value), true, nil
+	case FieldDueDate:
+		if value != "" {
+			if _, err := time.Parse("2006-01-02", value); err != nil {
+				return op, false, fmt.Errorf("invalid due date %q, expected YYYY-MM-DD", value)
+			}
+		}
+		op, ok = e.targetDateOp(item, value)
+		return op, ok, nil
+	}
+	for _, s := range e.cfg.Effort.sources() {
+		if s.field != f {
+			continue
+		}
+		if value == "" {
+			return ado.RemoveField(s.source), true, nil
+		}
+		h, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return op, false, fmt.Errorf("invalid number of hours %q", value)
+		}
+		return ado.SetField(s.source, math.Round(h*100)/100), true, nil
+	}
+	if strings.HasPrefix(string(f), "field:") {
+		e.state.Lock()
+		defer e.state.Unlock()
+		for _, t := range e.targets {
+			for _, rf := range t.fieldsFor(item) {
+				if rf.field() == f {
+					op, err = rf.adoValue(item, value)
+					return op, err == nil, err
+				}
+			}
+		}
+	}
+	return op, false, fmt.Errorf("the %s field cannot take a merged value, pick the value of either side", f)
+}
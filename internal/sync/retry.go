@@ -0,0 +1,194 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/ratelimit"
+	"github.com/danstis/ado-asana-sync/internal/store"
+	"github.com/danstis/ado-asana-sync/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Retry defaults.
+const (
+	DefaultRetryAttempts   = 8
+	DefaultRetryBackoff    = time.Minute
+	DefaultRetryMaxBackoff = time.Hour
+)
+
+// retryPoll is how often the manager looks for retries that are due.
+const retryPoll = 30 * time.Second
+
+// RetryConfig controls the retries of work items whose sync failed with a transient error.
+type RetryConfig struct {
+	// MaxAttempts is the number of retries before an item is given up on until it changes again. Failed
+	// items are not retried when it is zero.
+	MaxAttempts int
+	// Backoff is the delay before the first retry. It doubles after every failed retry, up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// delay returns the time to wait after the given number of failed attempts.
+func (c RetryConfig) delay(attempts int) time.Duration {
+	d, max := c.Backoff, c.MaxBackoff
+	if d <= 0 {
+		d = DefaultRetryBackoff
+	}
+	if max <= 0 {
+		max = DefaultRetryMaxBackoff
+	}
+	for i := 1; i < attempts && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
+
+// retryable reports whether a sync that failed with err may succeed when attempted again.
+func retryable(err error) bool {
+	switch errorCategory(err) {
+	case "server", "rate_limit", "network", "unavailable", "stale":
+		return true
+	}
+	return false
+}
+
+// failed queues the work item with the given ID for a retry after its sync failed with err. Items that
+// failed for good, or more often than the configured attempts, are dropped from the queue.
+func (e *Engine) failed(ctx context.Context, adoID int, err error) {
+	if e.plan != nil || e.cfg.Retry.MaxAttempts <= 0 {
+		return
+	}
+	ctx = logging.With(ctx, logging.KeyWorkItem, adoID)
+	r, gerr := e.store.Retry(ctx, adoID)
+	switch {
+	case errors.Is(gerr, store.ErrNotFound):
+		if !retryable(err) {
+			return
+		}
+		r = store.Retry{ADOID: adoID, Pair: e.cfg.Name, FirstFailed: time.Now().UTC()}
+	case gerr != nil:
+		logging.From(ctx).Error("failed to look up retry", "error", gerr)
+		return
+	}
+	r.Attempts++
+	r.LastError = err.Error()
+	if !retryable(err) || r.Attempts > e.cfg.Retry.MaxAttempts {
+		logging.From(ctx).With(ErrorAttrs(err)...).Error("giving up retrying work item", "attempts", r.Attempts)
+		if derr := e.store.DeleteRetry(ctx, adoID); derr != nil {
+			logging.From(ctx).Error("failed to remove retry", "error", derr)
+		}
+		return
+	}
+	r.NextAttempt = time.Now().Add(e.cfg.Retry.delay(r.Attempts)).UTC()
+	if perr := e.store.PutRetry(ctx, r); perr != nil {
+		logging.From(ctx).Error("failed to queue retry", "error", perr)
+		return
+	}
+	logging.From(ctx).Info("queued work item for retry", "attempts", r.Attempts, "next_attempt", r.NextAttempt.Format(time.RFC3339))
+}
+
+// succeeded removes the work item with the given ID from the retry queue once it synced.
+func (e *Engine) succeeded(ctx context.Context, adoID int) {
+	if e.plan != nil || e.cfg.Retry.MaxAttempts <= 0 {
+		return
+	}
+	ctx = logging.With(ctx, logging.KeyWorkItem, adoID)
+	r, err := e.store.Retry(ctx, adoID)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			logging.From(ctx).Error("failed to look up retry", "error", err)
+		}
+		return
+	}
+	if r.Pair != e.cfg.Name {
+		return
+	}
+	if err := e.store.DeleteRetry(ctx, adoID); err != nil {
+		logging.From(ctx).Error("failed to remove retry", "error", err)
+		return
+	}
+	logging.From(ctx).Info("work item synced after failing", "attempts", r.Attempts)
+}
+
+// RetryDue syncs the queued work items of the pair whose next retry is due. It returns a nil report when
+// none is due, when retries are disabled, or while ADO or Asana is unavailable.
+func (e *Engine) RetryDue(ctx context.Context) (*Report, error) {
+	if e.config().Retry.MaxAttempts <= 0 || e.unavailable() != "" {
+		return nil, nil
+	}
+	if p, err := e.Paused(ctx); err != nil || p != nil {
+		return nil, err
+	}
+	retries, err := e.store.Retries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var due []int
+	for _, r := range retries {
+		if r.Pair == e.Name() && !r.NextAttempt.After(now) {
+			due = append(due, r.ADOID)
+		}
+	}
+	if len(due) == 0 {
+		return nil, nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ctx = e.begin(ctx)
+	ctx, span := tracing.Tracer().Start(ctx, "sync.retry", trace.WithAttributes(
+		attribute.String("sync.pair", e.cfg.Name),
+		attribute.Int("sync.retries", len(due)),
+	))
+	rep, err := e.retry(ctx, due)
+	if err != nil {
+		e.countError(err)
+	}
+	tracing.End(span, err)
+	return rep, err
+}
+
+func (e *Engine) retry(ctx context.Context, ids []int) (*Report, error) {
+	rep := &Report{Plan: e.plan}
+	if err := e.prepare(ctx); err != nil {
+		return nil, err
+	}
+	e.orphans, e.blocked, e.rollups = nil, nil, nil
+	for _, id := range ids {
+		rep.Items++
+		err := e.syncID(ctx, id, rep)
+		if errors.Is(err, ratelimit.ErrBudgetExhausted) {
+			// The items left stay queued, without counting an attempt, until the budget allows them.
+			logging.From(ctx).Warn("api call budget exhausted, leaving the remaining retries queued", "error", err)
+			rep.Items--
+			break
+		}
+		if err != nil {
+			logging.From(ctx).With(ErrorAttrs(err)...).Error("failed to retry work item", logging.KeyWorkItem, id)
+			e.countError(err)
+			rep.fail(id, err)
+			e.failed(ctx, id, err)
+			continue
+		}
+		e.succeeded(ctx, id)
+	}
+	if err := e.linkOrphans(ctx); err != nil {
+		return nil, err
+	}
+	if err := e.linkBlocked(ctx); err != nil {
+		return nil, err
+	}
+	if err := e.rollUp(ctx, rep); err != nil {
+		return nil, err
+	}
+	return e.finish(ctx, rep)
+}
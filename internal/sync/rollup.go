@@ -0,0 +1,257 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// RollupConfig sums the estimates of the children of work items onto the task of their parent, so the
+// effort of a parent reflects the work broken down under it. Every target is the name or GID of a number
+// custom field; remaining work is held in hours, or minutes when the field is shown as a duration. Sums
+// without a target are not rolled up.
+type RollupConfig struct {
+	// Points receives the summed Story Points of the children.
+	Points string `json:"points,omitempty"`
+	// Remaining receives the summed Remaining Work of the children.
+	Remaining string `json:"remaining,omitempty"`
+}
+
+// rollupSource is a rolled up field with the ADO field it sums and its target.
+type rollupSource struct {
+	name   string
+	source string
+	target string
+}
+
+// sources returns the rolled up fields that have a target.
+func (c RollupConfig) sources() []rollupSource {
+	var s []rollupSource
+	for _, f := range []rollupSource{
+		{"points", ado.FieldStoryPoints, c.Points},
+		{"remaining", ado.FieldRemainingWork, c.Remaining},
+	} {
+		if f.target != "" {
+			s = append(s, f)
+		}
+	}
+	return s
+}
+
+// ParseRollup parses a comma separated list of field=target rollups, for example
+// "points=Total points,remaining=Remaining hours".
+func ParseRollup(s string) (RollupConfig, error) {
+	var c RollupConfig
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, target, ok := strings.Cut(part, "=")
+		target = strings.TrimSpace(target)
+		if !ok || target == "" {
+			return c, fmt.Errorf("invalid rollup %q, expected field=asana field", part)
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "points":
+			c.Points = target
+		case "remaining":
+			c.Remaining = target
+		default:
+			return c, fmt.Errorf("unknown rollup field %q, expected points or remaining", name)
+		}
+	}
+	return c, nil
+}
+
+// ValidateRollup checks that no rollup writes the field an effort mapping syncs, which would have the two
+// overwrite each other.
+func (c Config) ValidateRollup() error {
+	for _, r := range c.Rollup.sources() {
+		if strings.EqualFold(r.target, EffortActual) {
+			return fmt.Errorf("rollup %s: the actual time of tasks is read-only", r.name)
+		}
+		for _, f := range c.Effort.sources() {
+			if strings.EqualFold(r.target, f.target) {
+				return fmt.Errorf("rollup %s: asana field %q is also the target of effort field %s", r.name, r.target, f.field)
+			}
+		}
+	}
+	return nil
+}
+
+// rollupField is a rolled up field resolved on an Asana project.
+type rollupField struct {
+	rollupSource
+	gid string
+	// minutes is set for remaining work held by a duration field.
+	minutes bool
+}
+
+// resolveRollupFields resolves the targets of the rollups on the Asana project.
+func (e *Engine) resolveRollupFields(ctx context.Context, project string) ([]rollupField, error) {
+	sources := e.cfg.Rollup.sources()
+	if len(sources) == 0 {
+		return nil, nil
+	}
+	fields, err := e.asana.ProjectCustomFields(ctx, project)
+	if err != nil {
+		return nil, fmt.Errorf("listing asana custom fields: %w", err)
+	}
+	resolved := make([]rollupField, 0, len(sources))
+	for _, s := range sources {
+		cf, ok := findCustomField(fields, s.target)
+		if !ok {
+			return nil, withCode(CodeFieldMissing, fmt.Errorf("rollup %s: custom field %q not found on asana project %s", s.name, s.target, project))
+		}
+		if FieldType(cf.ResourceSubtype) != TypeNumber {
+			return nil, fmt.Errorf("rollup %s: asana field %q is %s, not number", s.name, s.target, cf.ResourceSubtype)
+		}
+		resolved = append(resolved, rollupField{rollupSource: s, gid: cf.GID, minutes: s.source == ado.FieldRemainingWork && cf.RepresentationType == "duration"})
+	}
+	return resolved, nil
+}
+
+// asanaValue returns the sum the task holds, or nil when it holds none.
+func (f rollupField) asanaValue(task *asana.Task) *float64 {
+	for _, cf := range task.CustomFields {
+		if cf.GID == f.gid && cf.NumberValue != nil {
+			v := *cf.NumberValue
+			if f.minutes {
+				v /= 60
+			}
+			return &v
+		}
+	}
+	return nil
+}
+
+// value returns the custom field value holding the sum v, or nil to clear the field.
+func (f rollupField) value(v *float64) interface{} {
+	return effortField{minutes: f.minutes}.value(v)
+}
+
+// noteRollup remembers the work items whose sums item may have changed: its parent, and item itself when
+// it has children or its task holds a sum, so its sums follow children that were added or removed. They are
+// rolled up once the workers of a cycle are done.
+func (e *Engine) noteRollup(project string, item ado.WorkItem, task *asana.Task) {
+	fields := e.target(project).rollup
+	if len(fields) == 0 {
+		return
+	}
+	var ids []int
+	if id, ok := item.ParentID(); ok {
+		ids = append(ids, id)
+	}
+	self := len(item.Linked(ado.RelChild)) > 0
+	for _, f := range fields {
+		self = self || f.asanaValue(task) != nil
+	}
+	if self {
+		ids = append(ids, item.ID)
+	}
+	if len(ids) == 0 {
+		return
+	}
+	e.state.Lock()
+	defer e.state.Unlock()
+	if e.rollups == nil {
+		e.rollups = map[int]bool{}
+	}
+	for _, id := range ids {
+		e.rollups[id] = true
+	}
+}
+
+// rollUp sets the sums of the children of the work items remembered by noteRollup on their tasks. Parents
+// without a task are left out. It runs once the workers of a cycle are done.
+func (e *Engine) rollUp(ctx context.Context, rep *Report) error {
+	var ids []int
+	for id := range e.rollups {
+		if m, err := e.store.Get(ctx, id); errors.Is(err, store.ErrNotFound) || err == nil && m.AsanaGID == "" {
+			continue
+		} else if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	e.rollups = nil
+	sort.Ints(ids)
+	for start := 0; start < len(ids); start += pageSize {
+		end := start + pageSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		items, err := e.ado.GetWorkItems(ctx, ids[start:end])
+		if err != nil {
+			return fmt.Errorf("fetching work items: %w", err)
+		}
+		for _, item := range items {
+			ctx := logging.With(ctx, logging.KeyWorkItem, item.ID)
+			if err := e.rollUpItem(ctx, item); err != nil {
+				logging.From(ctx).Error("failed to roll up children", "error", err)
+				rep.fail(item.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// rollUpItem sets the sums of the children of item on its task.
+func (e *Engine) rollUpItem(ctx context.Context, item ado.WorkItem) error {
+	m, err := e.store.Get(ctx, item.ID)
+	if err != nil {
+		return err
+	}
+	ctx = logging.With(ctx, logging.KeyTask, m.AsanaGID)
+	var children []ado.WorkItem
+	ids := item.Linked(ado.RelChild)
+	for start := 0; start < len(ids); start += pageSize {
+		end := start + pageSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		page, err := e.ado.GetWorkItems(ctx, ids[start:end])
+		if err != nil {
+			return fmt.Errorf("fetching children: %w", err)
+		}
+		children = append(children, page...)
+	}
+	task, err := e.asana.GetTask(ctx, m.AsanaGID)
+	if err != nil {
+		return fmt.Errorf("fetching asana task: %w", err)
+	}
+	req := asana.TaskRequest{CustomFields: map[string]interface{}{}}
+	sums := map[string]string{}
+	for _, f := range e.target(e.projectIn(task)).rollup {
+		var sum *float64
+		for _, c := range children {
+			if v := hours(c.Fields[f.source]); v != nil {
+				if sum == nil {
+					sum = new(float64)
+				}
+				*sum += *v
+			}
+		}
+		if sameEffort(f.asanaValue(task), sum) {
+			continue
+		}
+		req.CustomFields[f.gid] = f.value(sum)
+		sums[f.name] = formatHours(sum)
+	}
+	if len(req.CustomFields) == 0 {
+		return nil
+	}
+	if _, err := e.updateTask(ctx, task.GID, req); err != nil {
+		return fmt.Errorf("updating asana task: %w", err)
+	}
+	logging.From(ctx).Info("rolled up children onto asana task", "children", len(children), "sums", sums)
+	return nil
+}
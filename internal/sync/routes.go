@@ -0,0 +1,302 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+)
+
+// Route sends the tasks of the work items under an area path to an Asana project.
+type Route struct {
+	// Area is matched against the area path of work items one segment at a time, ignoring case, and
+	// supports the wildcards of path.Match within a segment. It also matches the areas below it, so
+	// `Fabrikam\Web*` matches `Fabrikam\Website\Checkout`.
+	Area string `json:"area"`
+	// Project is the GID of the Asana project. With provisioning enabled it may instead be the name of a
+	// project, created on first use, in which `{area}` stands for the segment of the area path matched by
+	// the last segment of Area.
+	Project string `json:"project"`
+}
+
+// ParseRoutes parses a comma separated list of area=project pairs, for example
+// `Fabrikam\Web=1201,Fabrikam\Mobile*=1202`. Routes are evaluated in order.
+func ParseRoutes(s string) ([]Route, error) {
+	var routes []Route
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		area, project, ok := strings.Cut(part, "=")
+		r := Route{Area: strings.TrimSpace(area), Project: strings.TrimSpace(project)}
+		if !ok || r.Area == "" || r.Project == "" {
+			return nil, fmt.Errorf("invalid route %q, expected area=project", part)
+		}
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+// segments splits an area path, or an area pattern, into its lower case segments.
+func segments(area string) []string {
+	parts := strings.Split(strings.ToLower(strings.Trim(area, `\`)), `\`)
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	return parts
+}
+
+// matches reports whether r routes items in the given area path.
+func (r Route) matches(area string) bool {
+	pattern, parts := segments(r.Area), segments(area)
+	if len(parts) < len(pattern) {
+		return false
+	}
+	for i, p := range pattern {
+		if ok, _ := path.Match(p, parts[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// projectName returns the name of the project r routes items in the given area path to.
+func (r Route) projectName(area string) string {
+	if !strings.Contains(r.Project, "{area}") {
+		return r.Project
+	}
+	parts := strings.Split(strings.Trim(area, `\`), `\`)
+	i := len(segments(r.Area)) - 1
+	if i < 0 || i >= len(parts) {
+		return r.Project
+	}
+	return strings.ReplaceAll(r.Project, "{area}", strings.TrimSpace(parts[i]))
+}
+
+// isGID reports whether s is an Asana GID rather than a name.
+func isGID(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// ValidateRoutes checks that the pair has a default Asana project or routes, unless it mirrors into My
+// Tasks, and that every route names an area and a project.
+func (c Config) ValidateRoutes() error {
+	if c.AsanaProject == "" && len(c.Routes) == 0 && !c.MyTasks.enabled() {
+		return fmt.Errorf("an asana project or routes are required")
+	}
+	for _, r := range c.Routes {
+		if r.Area == "" || r.Project == "" {
+			return fmt.Errorf("route %q -> %q: area and project are required", r.Area, r.Project)
+		}
+		for _, p := range segments(r.Area) {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("route %q: %w", r.Area, err)
+			}
+		}
+	}
+	return nil
+}
+
+// projectFor returns the Asana project of item: that of the first route matching its area path, otherwise
+// AsanaProject. It is the GID of the project, or its name when it is provisioned, and empty when the item
+// matches no route and the pair has no default project.
+func (c Config) projectFor(item ado.WorkItem) string {
+	area := item.String(ado.FieldAreaPath)
+	for _, r := range c.Routes {
+		if r.matches(area) {
+			return r.projectName(area)
+		}
+	}
+	return c.AsanaProject
+}
+
+// projects returns the GIDs of the Asana projects the pair is configured with, the default project first.
+// Provisioned projects are not included.
+func (c Config) projects() []string {
+	var projects []string
+	seen := map[string]bool{}
+	for _, p := range append([]string{c.AsanaProject}, routeProjects(c.Routes)...) {
+		if isGID(p) && !seen[p] {
+			seen[p] = true
+			projects = append(projects, p)
+		}
+	}
+	return projects
+}
+
+// routeProjects returns the project of every route.
+func routeProjects(routes []Route) []string {
+	projects := make([]string, len(routes))
+	for i, r := range routes {
+		projects[i] = r.Project
+	}
+	return projects
+}
+
+// projects returns the GIDs of the Asana projects of the pair, including those it provisioned.
+func (e *Engine) projects() []string {
+	e.state.Lock()
+	defer e.state.Unlock()
+	return append([]string(nil), e.projectGIDs...)
+}
+
+// target holds what Validate resolved on one Asana project of the pair.
+type target struct {
+	// fields holds the resolved field mappings.
+	fields []resolvedField
+	// typeFields holds the resolved field mappings of the work item types with rules that have any, keyed
+	// by lower case type.
+	typeFields map[string][]resolvedField
+	// sections maps lower case section names to their GIDs.
+	sections map[string]string
+	// sprintField is the custom field set to the sprint of items in SprintsField mode.
+	sprintField *choiceField
+	// board holds the custom fields set to the column and the lane of items on the board.
+	board boardFields
+	// statusField is the custom field holding the status of tasks, when the state map sets one.
+	statusField *statusField
+	// anchorField is the custom field anchoring tasks to their work item, when the anchor is one.
+	anchorField *anchorField
+	// effort holds the resolved effort fields.
+	effort []effortField
+	// rollup holds the resolved rollup fields.
+	rollup []rollupField
+	// members holds the GIDs of the project members when assignees are checked against them, guarded by
+	// the state lock.
+	members map[string]bool
+}
+
+// target returns what was resolved on the given project.
+func (e *Engine) target(project string) *target {
+	e.state.Lock()
+	defer e.state.Unlock()
+	if t, ok := e.targets[project]; ok {
+		return t
+	}
+	return &target{}
+}
+
+// share makes t use the sprint and board fields of other targets holding the same fields, so options added
+// for one project are known to the others.
+func (t *target) share(others []*target) {
+	for _, o := range others {
+		shareField(&t.sprintField, o.sprintField)
+		shareField(&t.board.column, o.board.column)
+		shareField(&t.board.lane, o.board.lane)
+	}
+}
+
+// shareField replaces *f with o when both are the same custom field.
+func shareField(f **choiceField, o *choiceField) {
+	if *f != nil && o != nil && (*f).gid == o.gid {
+		*f = o
+	}
+}
+
+// listTasks lists the tasks of every Asana project of the pair, and those in the My Tasks it mirrors into,
+// only those modified since the given time unless it is zero. Tasks in more than one project are listed
+// once.
+func (e *Engine) listTasks(ctx context.Context, since time.Time) ([]asana.Task, error) {
+	var all []asana.Task
+	seen := map[string]bool{}
+	for _, p := range e.projects() {
+		var tasks []asana.Task
+		var err error
+		if since.IsZero() {
+			tasks, err = e.asana.ProjectTasks(ctx, p)
+		} else {
+			tasks, err = e.asana.ModifiedTasks(ctx, p, since)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tasks {
+			if !seen[t.GID] {
+				seen[t.GID] = true
+				all = append(all, t)
+			}
+		}
+	}
+	mine, err := e.mirrorTasks(ctx, since)
+	if err != nil {
+		return nil, err
+	}
+	return append(all, mine...), nil
+}
+
+// projectOf returns the project of the pair the task with the given GID is in.
+func (e *Engine) projectOf(ctx context.Context, taskGID string) (string, error) {
+	projects := e.projects()
+	if len(projects) == 1 {
+		return projects[0], nil
+	}
+	t, err := e.asana.GetTask(ctx, taskGID)
+	if err != nil {
+		return "", err
+	}
+	return e.projectIn(t), nil
+}
+
+// projectIn returns the first project of the pair task is in, or the first project of the pair when the
+// memberships of task are unknown.
+func (e *Engine) projectIn(task *asana.Task) string {
+	projects := e.projects()
+	for _, p := range projects {
+		if task.InProject(p) {
+			return p
+		}
+	}
+	if len(projects) == 0 {
+		return ""
+	}
+	return projects[0]
+}
+
+// rehome moves task into project, the one its item is routed to, when the area path of the item changed.
+// The task is removed from the other projects of the pair, while projects the pair does not sync are left
+// alone.
+func (e *Engine) rehome(ctx context.Context, item ado.WorkItem, task *asana.Task, project string) error {
+	if task.InProject(project) {
+		return nil
+	}
+	if err := e.asana.AddProject(ctx, task.GID, project); err != nil {
+		return fmt.Errorf("adding asana task to project %s: %w", project, err)
+	}
+	kept := []asana.Membership{{Project: &asana.Section{GID: project}}}
+	for _, m := range task.Memberships {
+		if m.Project == nil {
+			continue
+		}
+		if e.routed(m.Project.GID) {
+			if err := e.asana.RemoveProject(ctx, task.GID, m.Project.GID); err != nil {
+				return fmt.Errorf("removing asana task from project %s: %w", m.Project.GID, err)
+			}
+			continue
+		}
+		kept = append(kept, m)
+	}
+	task.Memberships = kept
+	logging.From(ctx).Info("moved asana task to the project of its area", "area", item.String(ado.FieldAreaPath), "asana_project", project)
+	return nil
+}
+
+// routed reports whether project is one of the projects of the pair.
+func (e *Engine) routed(project string) bool {
+	for _, p := range e.projects() {
+		if p == project {
+			return true
+		}
+	}
+	return false
+}
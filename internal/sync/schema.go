@@ -0,0 +1,163 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+)
+
+// schemaMappings returns the field mappings of the pair and of its type rules, once per target field.
+func (c Config) schemaMappings() []FieldMapping {
+	mappings := append([]FieldMapping(nil), c.FieldMappings...)
+	for _, r := range c.Types {
+		if len(r.FieldMappings) > 0 {
+			mappings = append(mappings, c.forType(r).FieldMappings...)
+		}
+	}
+	seen := map[string]bool{}
+	out := mappings[:0]
+	for _, m := range mappings {
+		if !seen[strings.ToLower(m.Target)] {
+			seen[strings.ToLower(m.Target)] = true
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// manageSchema brings the custom fields of the Asana project in line with the field mappings: a missing
+// field is enabled on the project, taken from the workspace when it has a field of that name and created
+// otherwise, and enum fields are given the options the mappings refer to and those of ADO picklists. Fields whose type differs from
+// their mapping are logged and returned by lower case target, so their mappings are left out. It returns
+// the custom fields of the project as they are then.
+func (e *Engine) manageSchema(ctx context.Context, project string, fields []asana.CustomField) ([]asana.CustomField, map[string]bool, error) {
+	mismatched := map[string]bool{}
+	var workspace []asana.CustomField
+	for _, m := range e.cfg.schemaMappings() {
+		m, source, err := m.bind(e.sources)
+		if err != nil {
+			return nil, nil, err
+		}
+		options := enumOptions(m)
+		if m.Type == TypeEnum {
+			options = append(options, picklistOptions(m, source)...)
+		}
+		cf, ok := findCustomField(fields, m.Target)
+		if !ok {
+			if isGID(m.Target) {
+				// A field given by GID cannot be created, so resolving the mapping reports it missing.
+				continue
+			}
+			if workspace == nil {
+				var err error
+				if workspace, err = e.asana.WorkspaceCustomFields(ctx, e.cfg.AsanaWorkspace); err != nil {
+					return nil, nil, fmt.Errorf("listing asana workspace custom fields: %w", err)
+				}
+			}
+			if cf, ok = findCustomField(workspace, m.Target); !ok {
+				created, err := e.asana.CreateCustomField(ctx, e.cfg.AsanaWorkspace, m.Target, string(m.Type), dedupeOptions(options))
+				if err != nil {
+					return nil, nil, fmt.Errorf("field mapping %s -> %s: creating asana custom field: %w", m.Source, m.Target, err)
+				}
+				cf = *created
+				workspace = append(workspace, cf)
+				logging.From(ctx).Info("created asana custom field", "field", m.Target, "type", string(m.Type))
+			}
+			if cf.ResourceSubtype == string(m.Type) {
+				if err := e.asana.AddCustomFieldSetting(ctx, project, cf.GID); err != nil {
+					return nil, nil, fmt.Errorf("field mapping %s -> %s: adding the custom field to asana project %s: %w", m.Source, m.Target, project, err)
+				}
+				fields = append(fields, cf)
+				logging.From(ctx).Info("added custom field to asana project", "field", cf.Name, "asana_project", project)
+			}
+		}
+		if cf.ResourceSubtype != string(m.Type) {
+			logging.From(ctx).Warn("custom field type does not match its field mapping, leaving the mapping out", "field", m.Target,
+				"asana_type", cf.ResourceSubtype, "mapping_type", string(m.Type), "asana_project", project)
+			mismatched[strings.ToLower(m.Target)] = true
+			continue
+		}
+		if m.Type != TypeEnum {
+			continue
+		}
+		have := make(map[string]bool, len(cf.EnumOptions))
+		for _, o := range cf.EnumOptions {
+			have[strings.ToLower(o.Name)] = true
+		}
+		for _, name := range dedupeOptions(options) {
+			if have[strings.ToLower(name)] {
+				continue
+			}
+			o, err := e.asana.CreateEnumOption(ctx, cf.GID, name)
+			if err != nil {
+				return nil, nil, fmt.Errorf("field mapping %s -> %s: creating option %q: %w", m.Source, m.Target, name, err)
+			}
+			cf.EnumOptions = append(cf.EnumOptions, *o)
+			have[strings.ToLower(name)] = true
+			logging.From(ctx).Info("created custom field option", "field", cf.Name, "option", name)
+		}
+		for i := range fields {
+			if fields[i].GID == cf.GID {
+				fields[i] = cf
+			}
+		}
+	}
+	return fields, mismatched, nil
+}
+
+// enumOptions returns the Asana options an enum field mapping refers to, in order.
+func enumOptions(m FieldMapping) []string {
+	seen := map[string]bool{}
+	var options []string
+	add := func(names ...string) {
+		sort.Strings(names)
+		for _, n := range names {
+			if n != "" && !seen[strings.ToLower(n)] {
+				seen[strings.ToLower(n)] = true
+				options = append(options, n)
+			}
+		}
+	}
+	var values, reverse []string
+	for _, v := range m.Values {
+		values = append(values, v)
+	}
+	for o := range m.Reverse {
+		reverse = append(reverse, o)
+	}
+	add(values...)
+	add(reverse...)
+	add(m.Default)
+	return options
+}
+
+// dedupeOptions returns the option names without those repeating an earlier one, ignoring case.
+func dedupeOptions(names []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, n := range names {
+		if n != "" && !seen[strings.ToLower(n)] {
+			seen[strings.ToLower(n)] = true
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// withoutTargets returns mappings without those whose lower case target is in targets.
+func withoutTargets(mappings []FieldMapping, targets map[string]bool) []FieldMapping {
+	if len(targets) == 0 {
+		return mappings
+	}
+	var out []FieldMapping
+	for _, m := range mappings {
+		if !targets[strings.ToLower(m.Target)] {
+			out = append(out, m)
+		}
+	}
+	return out
+}
@@ -0,0 +1,120 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+)
+
+// ParseSectionMappings parses a comma separated list of state=section pairs, for example
+// "New=To Do,Active=In Progress,Closed=Done".
+func ParseSectionMappings(s string) (map[string]string, error) {
+	sections := map[string]string{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		state, section, ok := strings.Cut(part, "=")
+		state, section = strings.TrimSpace(state), strings.TrimSpace(section)
+		if !ok || state == "" || section == "" {
+			return nil, fmt.Errorf("invalid section mapping %q, expected state=section", part)
+		}
+		sections[state] = section
+	}
+	return sections, nil
+}
+
+// sectionFor returns the Asana section of item: the closing section once it closed, the one the rule of its
+// type sets, the section of its board column when columns sync to sections, the section named after its
+// sprint in SprintsSection mode, otherwise the one mapped to its state.
+func (e *Engine) sectionFor(item ado.WorkItem) (string, bool) {
+	c := e.cfg
+	if section, ok := c.closingSection(item); ok {
+		return section, true
+	}
+	if section, ruled, ok := c.typeSection(item); ruled {
+		return section, ok
+	}
+	if section, ok := e.boardSection(item); ok {
+		return section, true
+	}
+	if c.Sprints.Mode == SprintsSection {
+		if sprint := sprintName(item); sprint != "" {
+			return sprint, true
+		}
+		return c.Sprints.Backlog, c.Sprints.Backlog != ""
+	}
+	for s, section := range c.SectionMappings {
+		if strings.EqualFold(s, item.State()) {
+			return section, true
+		}
+	}
+	return "", false
+}
+
+// loadSections lists the sections of the Asana project, keyed by lower case name. Nothing is listed
+// when tasks are not moved between sections, not even once their item closed, and removed items are not
+// archived.
+func (e *Engine) loadSections(ctx context.Context, project string) (map[string]string, error) {
+	if len(e.cfg.SectionMappings) == 0 && !e.cfg.typedSections() && e.cfg.Sprints.Mode != SprintsSection && e.cfg.Removal.Policy != RemoveArchive &&
+		e.cfg.Closing.Action != CloseSection && !e.cfg.Board.sections() {
+		return nil, nil
+	}
+	sections, err := e.asana.ProjectSections(ctx, project)
+	if err != nil {
+		return nil, fmt.Errorf("listing asana sections: %w", err)
+	}
+	byName := make(map[string]string, len(sections))
+	for _, s := range sections {
+		byName[strings.ToLower(s.Name)] = s.GID
+	}
+	return byName, nil
+}
+
+// syncSection moves task into the section of item in project, creating the section when the project does
+// not have it yet. Tasks of items without a section are left where they are.
+func (e *Engine) syncSection(ctx context.Context, project string, item ado.WorkItem, task *asana.Task) error {
+	name, ok := e.sectionFor(item)
+	if !ok || project == "" {
+		return nil
+	}
+	if cur := task.SectionIn(project); cur != nil && strings.EqualFold(cur.Name, name) {
+		return nil
+	}
+
+	gid, err := e.section(ctx, project, name)
+	if err != nil {
+		return err
+	}
+	if err := e.asana.AddTaskToSection(ctx, gid, task.GID); err != nil {
+		return fmt.Errorf("moving asana task to section %q: %w", name, err)
+	}
+	logging.From(ctx).Info("moved asana task to section", "section", name, "state", item.State(), "iteration", item.IterationPath(), "column", e.boardColumn(item))
+	return nil
+}
+
+// section returns the GID of the named section of project, creating it when the project does not have it
+// yet.
+func (e *Engine) section(ctx context.Context, project, name string) (string, error) {
+	t := e.target(project)
+	e.state.Lock()
+	defer e.state.Unlock()
+	if gid, ok := t.sections[strings.ToLower(name)]; ok {
+		return gid, nil
+	}
+	s, err := e.asana.CreateSection(ctx, project, name)
+	if err != nil {
+		return "", fmt.Errorf("creating asana section %q: %w", name, err)
+	}
+	logging.From(ctx).Info("created asana section", "section", name, "asana_project", project)
+	if t.sections == nil {
+		t.sections = map[string]string{}
+	}
+	t.sections[strings.ToLower(name)] = s.GID
+	return s.GID, nil
+}
@@ -0,0 +1,258 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+)
+
+// selection holds the IDs of the work items selected by a query in ascending order.
+type selection []int
+
+// has reports whether the work item with the given ID is selected.
+func (s selection) has(id int) bool {
+	i := sort.SearchInts(s, id)
+	return i < len(s) && s[i] == id
+}
+
+// queryPage returns the number of work item IDs read per query.
+func (e *Engine) queryPage() int {
+	if n := e.cfg.QueryPage; n > 0 && n <= ado.MaxQueryResults {
+		return n
+	}
+	return DefaultQueryPage
+}
+
+// afterID restricts the WIQL query q to the work items with an ID above id and orders them by ID, replacing
+// its own order.
+func afterID(q string, id int) string {
+	q = andWhere(q, fmt.Sprintf("[System.Id] > %d", id))
+	return q[:len(q)-len(orderBy.FindString(q))] + " ORDER BY [System.Id]"
+}
+
+// idPages reads the IDs of the work items a WIQL query selects in a project a page at a time, in ascending
+// order, each page starting after the last ID of the one before. Selections above the ado.MaxQueryResults
+// items a single query returns are read too, and only a page of their IDs is held at once.
+type idPages struct {
+	e       *Engine
+	project string
+	q       string
+	// after is the last ID read, and done is set once the last page was read.
+	after int
+	done  bool
+	// sweep, when set, is passed every page read, and ended after the last one.
+	sweep *sweep
+}
+
+// idPages returns the pages of the IDs above after of the work items the WIQL query q selects in project.
+func (e *Engine) idPages(project, q string, after int, sw *sweep) *idPages {
+	return &idPages{e: e, project: project, q: q, after: after, sweep: sw}
+}
+
+// next returns the next page of IDs, or none once they ran out.
+func (p *idPages) next(ctx context.Context) ([]int, error) {
+	if p.done {
+		return nil, nil
+	}
+	top := p.e.queryPage()
+	ids, err := p.e.ado.QueryTop(ctx, p.project, afterID(p.q, p.after), top)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) > 0 {
+		p.after = ids[len(ids)-1]
+	}
+	if p.sweep != nil {
+		p.sweep.pass(ids)
+	}
+	if len(ids) < top {
+		p.done = true
+		if p.sweep != nil {
+			p.sweep.end()
+		}
+	}
+	return ids, nil
+}
+
+// walk reads every page left, for its sweep.
+func (p *idPages) walk(ctx context.Context) error {
+	for !p.done {
+		if _, err := p.next(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectIDs returns the IDs above after of the work items the WIQL query q selects in project, read by
+// idPages. Cycles stream the pages instead; this holds them all, for the commands that need the whole
+// selection at once.
+func (e *Engine) selectIDs(ctx context.Context, project, q string, after int) (selection, error) {
+	pages := e.idPages(project, q, after, nil)
+	var ids selection
+	for {
+		page, err := pages.next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(page) == 0 {
+			return ids, nil
+		}
+		ids = append(ids, page...)
+	}
+}
+
+// sweep follows the IDs of the selection of a cycle as they pass in ascending order, keeping only what the
+// cycle needs to know of them: which work items the pair maps that the selection no longer holds, and which
+// of the candidates the cycle asks about it does hold.
+type sweep struct {
+	// mapped are the IDs of the work items the pair maps that were not passed yet, ascending.
+	mapped []int
+	// gone are the mapped IDs the selection went past without holding them. It is complete once ended.
+	gone  []int
+	ended bool
+	// candidates record whether the selection holds each of the work items asked about.
+	candidates map[int]bool
+	// seen counts the IDs passed.
+	seen int
+}
+
+// newSweep returns the sweep of a cycle of the pair asking about the candidates. The mappings of the pair
+// are only followed when the removal policy needs the work items that left the selection.
+func (e *Engine) newSweep(ctx context.Context, candidates ...[]int) (*sweep, error) {
+	s := &sweep{candidates: map[int]bool{}}
+	for _, ids := range candidates {
+		for _, id := range ids {
+			s.candidates[id] = false
+		}
+	}
+	if !e.cfg.Removal.active() {
+		return s, nil
+	}
+	mappings, err := e.store.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range mappings {
+		if m.Pair == e.cfg.Name && !m.Frozen {
+			s.mapped = append(s.mapped, m.ADOID)
+		}
+	}
+	sort.Ints(s.mapped)
+	return s, nil
+}
+
+// pass follows the IDs of the next page of the selection, which are above those of the pages before.
+func (s *sweep) pass(ids []int) {
+	for _, id := range ids {
+		s.seen++
+		for len(s.mapped) > 0 && s.mapped[0] < id {
+			s.gone = append(s.gone, s.mapped[0])
+			s.mapped = s.mapped[1:]
+		}
+		if len(s.mapped) > 0 && s.mapped[0] == id {
+			s.mapped = s.mapped[1:]
+		}
+		if _, ok := s.candidates[id]; ok {
+			s.candidates[id] = true
+		}
+	}
+}
+
+// end records that the selection ended, so the mapped work items not passed left it too.
+func (s *sweep) end() {
+	s.gone = append(s.gone, s.mapped...)
+	s.mapped, s.ended = nil, true
+}
+
+// has reports whether the selection holds the candidate with the given ID.
+func (s *sweep) has(id int) bool {
+	return s.candidates[id]
+}
+
+// cycleOrder yields the IDs of the work items of a cycle in the order they are synced: those of first, then
+// those the pages of rest read, one after the other, that are not among first. The pages are only read as
+// the IDs are asked for, so a cycle never holds more than a page of them.
+type cycleOrder struct {
+	first []int
+	rest  []*idPages
+	// page holds the IDs of rest read but not yielded yet.
+	page []int
+	skip map[int]bool
+}
+
+// newCycleOrder returns the order syncing first, then the items the pages added by then read.
+func newCycleOrder(first []int) *cycleOrder {
+	o := &cycleOrder{first: first, skip: make(map[int]bool, len(first))}
+	for _, id := range first {
+		o.skip[id] = true
+	}
+	return o
+}
+
+// then adds the items read by pages after those of the order.
+func (o *cycleOrder) then(pages *idPages) *cycleOrder {
+	o.rest = append(o.rest, pages)
+	return o
+}
+
+// next returns the next n IDs, or fewer once the order runs out. The IDs returned with an error are still
+// to be synced.
+func (o *cycleOrder) next(ctx context.Context, n int) ([]int, error) {
+	ids := make([]int, 0, n)
+	for len(ids) < n && len(o.first) > 0 {
+		k := n - len(ids)
+		if k > len(o.first) {
+			k = len(o.first)
+		}
+		ids = append(ids, o.first[:k]...)
+		o.first = o.first[k:]
+	}
+	for len(ids) < n {
+		if len(o.page) == 0 {
+			if len(o.rest) == 0 {
+				break
+			}
+			page, err := o.rest[0].next(ctx)
+			if err != nil {
+				return ids, err
+			}
+			if len(page) == 0 {
+				o.rest = o.rest[1:]
+				continue
+			}
+			o.page = page
+		}
+		id := o.page[0]
+		o.page = o.page[1:]
+		if !o.skip[id] {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// remaining returns the IDs read but not yielded yet, without reading any more of them.
+func (o *cycleOrder) remaining() []int {
+	ids := append([]int(nil), o.first...)
+	for _, id := range o.page {
+		if !o.skip[id] {
+			ids = append(ids, id)
+		}
+	}
+	o.first, o.page = nil, nil
+	return ids
+}
+
+// unread returns the ID above which the order did not read the items of rest yet, and false when it read
+// them all.
+func (o *cycleOrder) unread() (int, bool) {
+	for _, p := range o.rest {
+		if !p.done {
+			return p.after, true
+		}
+	}
+	return 0, false
+}
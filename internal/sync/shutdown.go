@@ -0,0 +1,134 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// DefaultShutdownTimeout is how long the work items in flight when a cycle is stopped may take to finish.
+const DefaultShutdownTimeout = 20 * time.Second
+
+// cycleCheckpointKey is the store setting holding the checkpoint of the interrupted cycle of a pair, whose
+// name is appended.
+const cycleCheckpointKey = "sync_checkpoint:"
+
+// ErrInterrupted is returned by cycles stopped before every work item was synced. The next cycle of the
+// pair resumes it.
+var ErrInterrupted = errors.New("sync cycle interrupted")
+
+// interruption is the error of an interrupted cycle, listing the work items it did not dispatch.
+type interruption struct {
+	pending []int
+	// unread is set when the cycle stopped before reading the work items its query selects above after.
+	after  int
+	unread bool
+	// cause is why the cycle stopped, when it was not stopped by a shutdown.
+	cause error
+}
+
+func (i *interruption) Error() string {
+	if i.cause != nil {
+		return ErrInterrupted.Error() + ": " + i.cause.Error()
+	}
+	return ErrInterrupted.Error()
+}
+
+func (i *interruption) Is(target error) bool { return target == ErrInterrupted }
+
+func (i *interruption) Unwrap() error { return i.cause }
+
+type stopKey struct{}
+
+// graceful returns the context a cycle works in. It is not cancelled with ctx but timeout later, so the work
+// items in flight when ctx is cancelled can finish, while ctx stops the dispatch of new ones.
+func graceful(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	work, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	go func() {
+		select {
+		case <-work.Done():
+			return
+		case <-ctx.Done():
+		}
+		logging.From(ctx).Info("stopping sync cycle, finishing the work items in flight", "timeout", timeout.String())
+		select {
+		case <-work.Done():
+		case <-time.After(timeout):
+			logging.From(ctx).Warn("shutdown timeout reached, cancelling the work items in flight")
+			cancel()
+		}
+	}()
+	return context.WithValue(work, stopKey{}, ctx.Done()), cancel
+}
+
+// stopping returns the channel closed when the cycle of ctx must stop dispatching work items. It is nil, and
+// so never ready, outside cycles started by graceful.
+func stopping(ctx context.Context) <-chan struct{} {
+	ch, _ := ctx.Value(stopKey{}).(<-chan struct{})
+	return ch
+}
+
+// cycleCheckpoint is recorded by an interrupted cycle so the next one can resume it.
+type cycleCheckpoint struct {
+	// Started is when the interrupted cycle started. Items it synced are synced again only when they
+	// changed since.
+	Started time.Time `json:"started"`
+	// Full is set when the interrupted cycle reconciled every item.
+	Full bool `json:"full"`
+	// Pending are the work items the cycle did not dispatch or failed to sync.
+	Pending []int `json:"pending"`
+	// Unread is set when the cycle stopped before reading the work items its query selects above After,
+	// which are all synced by the cycle resuming it.
+	Unread bool `json:"unread,omitempty"`
+	After  int  `json:"after,omitempty"`
+}
+
+// loadCycleCheckpoint returns the checkpoint of the interrupted cycle of the pair, or nil when there is none.
+func (e *Engine) loadCycleCheckpoint(ctx context.Context) (*cycleCheckpoint, error) {
+	v, err := e.store.Setting(ctx, cycleCheckpointKey+e.cfg.Name)
+	switch {
+	case errors.Is(err, store.ErrNotFound) || (err == nil && v == ""):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	var cp cycleCheckpoint
+	if err := json.Unmarshal([]byte(v), &cp); err != nil || cp.Started.IsZero() {
+		logging.From(ctx).Warn("ignoring invalid setting", "setting", cycleCheckpointKey+e.cfg.Name, "value", v)
+		return nil, nil
+	}
+	return &cp, nil
+}
+
+// interrupt records the checkpoint of a cycle that started at start and was interrupted before dispatching
+// the pending items, flushes the store and returns the interruption. The flush outlives the cancellation of
+// ctx, which may have been what interrupted the cycle.
+func (e *Engine) interrupt(ctx context.Context, start time.Time, full bool, in *interruption, rep *Report) error {
+	if e.plan != nil {
+		return in
+	}
+	ctx = context.WithoutCancel(ctx)
+	cp := cycleCheckpoint{Started: start.UTC(), Full: full, Pending: in.pending, Unread: in.unread, After: in.after}
+	rep.mu.Lock()
+	for _, f := range rep.Failures {
+		cp.Pending = append(cp.Pending, f.ADOID)
+	}
+	rep.mu.Unlock()
+	b, _ := json.Marshal(cp)
+	if err := e.store.SetSetting(ctx, cycleCheckpointKey+e.cfg.Name, string(b)); err != nil {
+		return fmt.Errorf("saving cycle checkpoint: %w", err)
+	}
+	if err := e.store.Flush(ctx); err != nil {
+		return fmt.Errorf("saving mappings: %w", err)
+	}
+	logging.From(ctx).Info("saved checkpoint of interrupted sync cycle", "synced", rep.Items-len(rep.Failures), "pending", len(cp.Pending))
+	return in
+}
@@ -0,0 +1,141 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+)
+
+// sourceField is an ADO field that field mappings read, as the process of the project defines it.
+type sourceField struct {
+	ado.Field
+	// values are the values of a picklist field.
+	values []string
+}
+
+// sourceFields lists the work item fields of the ADO project, the custom fields of its inherited process
+// included, with the values of the picklists enum mappings read. It returns nil when the pair maps no fields.
+func (e *Engine) sourceFields(ctx context.Context) ([]sourceField, error) {
+	mappings := append([]FieldMapping(nil), e.cfg.FieldMappings...)
+	for _, r := range e.cfg.Types {
+		mappings = append(mappings, r.FieldMappings...)
+	}
+	if len(mappings) == 0 {
+		return nil, nil
+	}
+	listed, err := e.ado.Fields(ctx, e.cfg.ADOProject)
+	if err != nil {
+		return nil, fmt.Errorf("listing ado fields: %w", err)
+	}
+	fields := make([]sourceField, 0, len(listed))
+	for _, f := range listed {
+		fields = append(fields, sourceField{Field: f})
+	}
+	picklists := map[string][]string{}
+	for _, m := range mappings {
+		i, ok := findSourceField(fields, m.Source)
+		if !ok || !fields[i].IsPicklist || fields[i].PicklistID == "" || (m.Type != "" && m.Type != TypeEnum) {
+			continue
+		}
+		values, ok := picklists[fields[i].PicklistID]
+		if !ok {
+			if values, err = e.ado.Picklist(ctx, fields[i].PicklistID); err != nil {
+				return nil, fmt.Errorf("listing the values of ado field %s: %w", fields[i].ReferenceName, err)
+			}
+			picklists[fields[i].PicklistID] = values
+		}
+		fields[i].values = values
+	}
+	return fields, nil
+}
+
+// findSourceField returns the index of the field whose reference name or display name is source.
+func findSourceField(fields []sourceField, source string) (int, bool) {
+	for i, f := range fields {
+		if strings.EqualFold(f.ReferenceName, source) {
+			return i, true
+		}
+	}
+	for i, f := range fields {
+		if strings.EqualFold(f.Name, source) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// bind resolves the source of m against the fields of the ADO project: a display name such as Release Train
+// is replaced by its reference name, and a mapping without a type takes the type of the field. Mappings
+// whose types cannot hold each other's values are rejected. Without fields m is only validated.
+func (m FieldMapping) bind(fields []sourceField) (FieldMapping, sourceField, error) {
+	if fields == nil {
+		if m.Type == "" {
+			return m, sourceField{}, fmt.Errorf("field mapping %s -> %s has no type", m.Source, m.Target)
+		}
+		return m, sourceField{}, m.Validate()
+	}
+	i, ok := findSourceField(fields, m.Source)
+	if !ok {
+		return m, sourceField{}, withCode(CodeADOFieldMissing, fmt.Errorf("field mapping %s -> %s: no ado field %q in the project", m.Source, m.Target, m.Source))
+	}
+	f := fields[i]
+	m.Source = f.ReferenceName
+	if m.Type == "" {
+		m.Type = sourceType(f.Field)
+	}
+	if err := m.Validate(); err != nil {
+		return m, f, err
+	}
+	if !convertible(f.Field, m.Type) {
+		return m, f, withCode(CodeFieldTypeMismatch, fmt.Errorf("field mapping %s -> %s: ado %s field cannot be synced with a %s field", m.Source, m.Target, f.Type, m.Type))
+	}
+	if m.direction() != ADOToAsana && f.ReadOnly {
+		return m, f, withCode(CodeFieldTypeMismatch, fmt.Errorf("field mapping %s -> %s: ado field is read-only, so it cannot sync from asana", m.Source, m.Target))
+	}
+	return m, f, nil
+}
+
+// sourceType returns the Asana field type matching the ADO field: picklists are enums, numbers numbers,
+// dates dates and everything else text.
+func sourceType(f ado.Field) FieldType {
+	switch {
+	case f.IsPicklist:
+		return TypeEnum
+	case f.Type == ado.FieldTypeInteger, f.Type == ado.FieldTypeDouble:
+		return TypeNumber
+	case f.Type == ado.FieldTypeDateTime:
+		return TypeDate
+	default:
+		return TypeText
+	}
+}
+
+// convertible reports whether the values of the ADO field can be synced with an Asana field of type t.
+func convertible(f ado.Field, t FieldType) bool {
+	switch t {
+	case TypeNumber:
+		switch f.Type {
+		case ado.FieldTypeInteger, ado.FieldTypeDouble, ado.FieldTypeBoolean, ado.FieldTypeString:
+			return true
+		}
+		return false
+	case TypeDate:
+		return f.Type == ado.FieldTypeDateTime || f.Type == ado.FieldTypeString
+	default:
+		return true
+	}
+}
+
+// picklistOptions returns the Asana options the values of the picklist of f map to under m.
+func picklistOptions(m FieldMapping, f sourceField) []string {
+	options := make([]string, 0, len(f.values))
+	for _, v := range f.values {
+		if name, ok := m.Values[v]; ok {
+			v = name
+		}
+		options = append(options, v)
+	}
+	return options
+}
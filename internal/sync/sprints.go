@@ -0,0 +1,183 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+)
+
+// SprintMode is how the iteration of a work item is mirrored in Asana.
+type SprintMode string
+
+// Supported sprint modes.
+const (
+	// SprintsOff leaves iterations unsynced.
+	SprintsOff SprintMode = "off"
+	// SprintsSection moves each task into a section named after its sprint.
+	SprintsSection SprintMode = "section"
+	// SprintsField sets a custom field of each task to its sprint.
+	SprintsField SprintMode = "field"
+)
+
+// DefaultSprintField is the custom field set in SprintsField mode when none is configured.
+const DefaultSprintField = "Sprint"
+
+// SprintConfig controls how iterations are mirrored in Asana.
+type SprintConfig struct {
+	// Mode selects sections or a custom field. Iterations are not synced when it is empty or SprintsOff.
+	Mode SprintMode `json:"mode,omitempty"`
+	// Field is the name or GID of the enum or text custom field set in SprintsField mode, defaulting to
+	// DefaultSprintField. Enum options are added as new sprints appear.
+	Field string `json:"field,omitempty"`
+	// Backlog is the section of items in the root iteration in SprintsSection mode. Their tasks are left
+	// where they are when it is empty.
+	Backlog string `json:"backlog,omitempty"`
+}
+
+// ParseSprintMode parses s as a SprintMode. An empty string returns SprintsOff.
+func ParseSprintMode(s string) (SprintMode, error) {
+	switch m := SprintMode(strings.ToLower(strings.TrimSpace(s))); m {
+	case "":
+		return SprintsOff, nil
+	case SprintsOff, SprintsSection, SprintsField:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown sprint mode %q", s)
+	}
+}
+
+// ValidateSprints checks the sprint mode, and that sprint sections are not combined with section mappings as
+// a task is in a single section of the project.
+func (c Config) ValidateSprints() error {
+	if _, err := ParseSprintMode(string(c.Sprints.Mode)); err != nil {
+		return err
+	}
+	if c.Sprints.Mode == SprintsSection && len(c.SectionMappings) > 0 {
+		return fmt.Errorf("sprint sections cannot be combined with section mappings")
+	}
+	return nil
+}
+
+// sprintName returns the sprint of item, the last segment of its iteration path. It is empty for items in
+// the root iteration of the project.
+func sprintName(item ado.WorkItem) string {
+	path := item.IterationPath()
+	i := strings.LastIndex(path, `\`)
+	if i < 0 {
+		return ""
+	}
+	return strings.TrimSpace(path[i+1:])
+}
+
+// choiceField is an enum or text custom field, such as the one holding the sprint of a task. The options of
+// enum fields are added as new values appear.
+type choiceField struct {
+	gid  string
+	enum bool
+	// options maps lower case enum option names to their GIDs.
+	options map[string]string
+}
+
+// newChoiceField returns cf as a choiceField, or false when it is neither an enum nor a text field.
+func newChoiceField(cf asana.CustomField) (*choiceField, bool) {
+	f := &choiceField{gid: cf.GID}
+	switch FieldType(cf.ResourceSubtype) {
+	case TypeEnum:
+		f.enum = true
+		f.options = make(map[string]string, len(cf.EnumOptions))
+		for _, o := range cf.EnumOptions {
+			f.options[strings.ToLower(o.Name)] = o.GID
+		}
+	case TypeText:
+	default:
+		return nil, false
+	}
+	return f, true
+}
+
+// resolveSprintField looks up the sprint custom field on the Asana project in SprintsField mode.
+func (e *Engine) resolveSprintField(ctx context.Context, project string) (*choiceField, error) {
+	if e.cfg.Sprints.Mode != SprintsField {
+		return nil, nil
+	}
+	target := e.cfg.Sprints.Field
+	if target == "" {
+		target = DefaultSprintField
+	}
+	fields, err := e.asana.ProjectCustomFields(ctx, project)
+	if err != nil {
+		return nil, fmt.Errorf("listing asana custom fields: %w", err)
+	}
+	cf, ok := findCustomField(fields, target)
+	if !ok {
+		return nil, withCode(CodeFieldMissing, fmt.Errorf("sprint field %q not found on asana project %s", target, project))
+	}
+	f, ok := newChoiceField(cf)
+	if !ok {
+		return nil, fmt.Errorf("sprint field %q is %s, not enum or text", target, cf.ResourceSubtype)
+	}
+	return f, nil
+}
+
+// sprintValue adds the sprint of item to values, the custom field values written to task in project, when
+// the sprint field of task differs. task is nil for new tasks. The enum option of a new sprint is created on
+// first use.
+func (e *Engine) sprintValue(ctx context.Context, project string, item ado.WorkItem, task *asana.Task, values map[string]interface{}) (map[string]interface{}, error) {
+	f := e.target(project).sprintField
+	if f == nil {
+		return values, nil
+	}
+	sprint := sprintName(item)
+	cur := ""
+	if task != nil {
+		for _, cf := range task.CustomFields {
+			if cf.GID == f.gid {
+				cur = customFieldText(cf)
+			}
+		}
+	}
+	if task != nil && strings.EqualFold(cur, sprint) {
+		return values, nil
+	}
+	if task == nil && sprint == "" {
+		return values, nil
+	}
+
+	var v interface{}
+	if sprint != "" {
+		v = sprint
+		if f.enum {
+			gid, err := e.sprintOption(ctx, f, sprint)
+			if err != nil {
+				return nil, err
+			}
+			v = gid
+		}
+	}
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	values[f.gid] = v
+	return values, nil
+}
+
+// sprintOption returns the GID of the enum option of the sprint field f named sprint, creating it when the
+// field does not have it yet.
+func (e *Engine) sprintOption(ctx context.Context, f *choiceField, sprint string) (string, error) {
+	e.state.Lock()
+	defer e.state.Unlock()
+	if gid, ok := f.options[strings.ToLower(sprint)]; ok {
+		return gid, nil
+	}
+	o, err := e.asana.CreateEnumOption(ctx, f.gid, sprint)
+	if err != nil {
+		return "", fmt.Errorf("creating sprint option %q: %w", sprint, err)
+	}
+	logging.From(ctx).Info("created sprint option", "sprint", sprint)
+	f.options[strings.ToLower(sprint)] = o.GID
+	return o.GID, nil
+}
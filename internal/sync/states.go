@@ -0,0 +1,284 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+)
+
+// StateMap maps the ADO states of work items onto the completion and status of their Asana task, and back.
+// When it lists no states, ClosedStates, ADOClosedState and ADOActiveState are used instead.
+type StateMap struct {
+	// StatusField is the name or GID of the Asana enum custom field holding the status of tasks. Statuses
+	// are not synced when empty.
+	StatusField string `json:"status_field,omitempty"`
+	// States maps every ADO state to the task it shows as. A task completed, reopened or given another
+	// status in Asana sets its work item to the first state listed with that completion and status, or
+	// failing that the first listed with that completion.
+	States []StateRule `json:"states,omitempty"`
+	// Types are the work item types whose states must all be mapped. Every enabled type of the ADO project
+	// that type rules do not skip is checked when empty.
+	Types []string `json:"types,omitempty"`
+}
+
+// StateRule maps an ADO state onto Asana.
+type StateRule struct {
+	// State is the ADO state, matched ignoring case.
+	State string `json:"state"`
+	// Completed marks the tasks of items in the state completed.
+	Completed bool `json:"completed,omitempty"`
+	// Status is the option of the status field set on the tasks of items in the state.
+	Status string `json:"status,omitempty"`
+}
+
+// ParseStateMap parses a comma separated list of state=open or state=completed entries, each optionally
+// followed by :status, for example "New=open:To Do,Closed=completed:Done".
+func ParseStateMap(s string) ([]StateRule, error) {
+	var rules []StateRule
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		state, target, ok := strings.Cut(part, "=")
+		completion, status, _ := strings.Cut(target, ":")
+		r := StateRule{State: strings.TrimSpace(state), Status: strings.TrimSpace(status)}
+		switch strings.ToLower(strings.TrimSpace(completion)) {
+		case "completed":
+			r.Completed = true
+		case "open":
+		default:
+			ok = false
+		}
+		if !ok || r.State == "" {
+			return nil, fmt.Errorf("invalid state mapping %q, expected state=open|completed[:status]", part)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// ValidateStates checks that every state is mapped once, that tasks can be both completed and reopened, and
+// that every state has a status when statuses are synced.
+func (c Config) ValidateStates() error {
+	m := c.States
+	if len(m.States) == 0 {
+		if m.StatusField != "" {
+			return fmt.Errorf("a status field needs a state map")
+		}
+		return nil
+	}
+	seen := map[string]bool{}
+	var completed, open bool
+	for _, r := range m.States {
+		if r.State == "" {
+			return fmt.Errorf("state mapping without a state")
+		}
+		if seen[strings.ToLower(r.State)] {
+			return fmt.Errorf("duplicate state mapping for %q", r.State)
+		}
+		seen[strings.ToLower(r.State)] = true
+		switch {
+		case m.StatusField != "" && r.Status == "":
+			return fmt.Errorf("state %q has no status", r.State)
+		case m.StatusField == "" && r.Status != "":
+			return fmt.Errorf("state %q has a status but no status field is set", r.State)
+		}
+		completed = completed || r.Completed
+		open = open || !r.Completed
+	}
+	switch {
+	case !completed:
+		return fmt.Errorf("the state map has no completed state, so completing a task cannot be synced")
+	case !open:
+		return fmt.Errorf("the state map has no open state, so reopening a task cannot be synced")
+	}
+	for _, f := range c.FieldMappings {
+		if m.StatusField != "" && strings.EqualFold(f.Target, m.StatusField) {
+			return fmt.Errorf("field mapping %s -> %s writes the status field", f.Source, f.Target)
+		}
+	}
+	return nil
+}
+
+// taskState is the completion and status of an Asana task. Status is empty when statuses are not synced.
+type taskState struct {
+	completed bool
+	status    string
+}
+
+func (s taskState) equal(o taskState) bool {
+	return s.completed == o.completed && strings.EqualFold(s.status, o.status)
+}
+
+// String returns the value recorded in conflicts for the state.
+func (s taskState) String() string {
+	v := "open"
+	if s.completed {
+		v = "completed"
+	}
+	if s.status != "" {
+		v += " (" + s.status + ")"
+	}
+	return v
+}
+
+// stateRule returns the rule of the ADO state, or nil when the state map does not list it.
+func (c Config) stateRule(state string) *StateRule {
+	for i := range c.States.States {
+		if strings.EqualFold(c.States.States[i].State, state) {
+			return &c.States.States[i]
+		}
+	}
+	return nil
+}
+
+// taskState returns the completion and status the task of an item in the ADO state shows.
+func (c Config) taskState(state string) taskState {
+	if len(c.States.States) == 0 {
+		return taskState{completed: c.isClosed(state)}
+	}
+	if r := c.stateRule(state); r != nil {
+		return taskState{completed: r.Completed, status: r.Status}
+	}
+	return taskState{}
+}
+
+// adoState returns the ADO state set on a work item whose task shows s.
+func (c Config) adoState(s taskState) string {
+	if len(c.States.States) == 0 {
+		if s.completed {
+			return c.ADOClosedState
+		}
+		return c.ADOActiveState
+	}
+	for _, r := range c.States.States {
+		if r.Completed == s.completed && strings.EqualFold(r.Status, s.status) {
+			return r.State
+		}
+	}
+	for _, r := range c.States.States {
+		if r.Completed == s.completed {
+			return r.State
+		}
+	}
+	return ""
+}
+
+// statusField is the status field of the state map resolved on an Asana project.
+type statusField struct {
+	gid string
+	// options maps lower case option names to their GIDs.
+	options map[string]string
+}
+
+// resolveStatusField resolves the status field of the state map on the Asana project, checking that it
+// has an option for every mapped status.
+func (e *Engine) resolveStatusField(ctx context.Context, project string) (*statusField, error) {
+	name := e.cfg.States.StatusField
+	if name == "" {
+		return nil, nil
+	}
+	fields, err := e.asana.ProjectCustomFields(ctx, project)
+	if err != nil {
+		return nil, fmt.Errorf("listing asana custom fields: %w", err)
+	}
+	cf, ok := findCustomField(fields, name)
+	if !ok {
+		return nil, withCode(CodeFieldMissing, fmt.Errorf("status field %q not found on asana project %s", name, project))
+	}
+	if FieldType(cf.ResourceSubtype) != TypeEnum {
+		return nil, fmt.Errorf("status field %q is %s, not enum", name, cf.ResourceSubtype)
+	}
+	f := &statusField{gid: cf.GID, options: make(map[string]string, len(cf.EnumOptions))}
+	for _, o := range cf.EnumOptions {
+		f.options[strings.ToLower(o.Name)] = o.GID
+	}
+	for _, r := range e.cfg.States.States {
+		if _, ok := f.options[strings.ToLower(r.Status)]; !ok {
+			return nil, fmt.Errorf("status field %q has no option %q for state %q", name, r.Status, r.State)
+		}
+	}
+	return f, nil
+}
+
+// validateReachable checks that the state map covers every state of the work item types of the pair.
+func (e *Engine) validateReachable(ctx context.Context) error {
+	if len(e.cfg.States.States) == 0 {
+		return nil
+	}
+	types, err := e.ado.WorkItemTypes(ctx, e.cfg.ADOProject)
+	if err != nil {
+		return fmt.Errorf("listing work item types: %w", err)
+	}
+	var missing []string
+	for _, t := range types {
+		if !e.checkedType(t) {
+			continue
+		}
+		for _, s := range t.States {
+			if e.cfg.stateRule(s.Name) == nil {
+				missing = append(missing, t.Name+"/"+s.Name)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("the state map does not map %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// checkedType reports whether every state of t must be mapped.
+func (e *Engine) checkedType(t ado.WorkItemType) bool {
+	if len(e.cfg.States.Types) > 0 {
+		for _, name := range e.cfg.States.Types {
+			if strings.EqualFold(name, t.Name) {
+				return true
+			}
+		}
+		return false
+	}
+	r := e.cfg.typeRule(t.Name)
+	return !t.Disabled && (r == nil || !r.Skip)
+}
+
+// wantState returns the completion and status the task of item shows in project. The status is left out
+// on projects without the status field.
+func (e *Engine) wantState(project string, item ado.WorkItem) taskState {
+	s := e.cfg.taskState(item.State())
+	if e.target(project).statusField == nil {
+		s.status = ""
+	}
+	return s
+}
+
+// taskState returns the completion and status of task in project.
+func (e *Engine) taskState(project string, task *asana.Task) taskState {
+	s := taskState{completed: task.Completed}
+	if f := e.target(project).statusField; f != nil {
+		for _, cf := range task.CustomFields {
+			if cf.GID == f.gid && cf.EnumValue != nil {
+				s.status = cf.EnumValue.Name
+			}
+		}
+	}
+	return s
+}
+
+// statusValue adds the status of s to values, the custom field values written to a task in project.
+func (e *Engine) statusValue(project string, s taskState, values map[string]interface{}) map[string]interface{} {
+	f := e.target(project).statusField
+	if f == nil || s.status == "" {
+		return values
+	}
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	values[f.gid] = f.options[strings.ToLower(s.status)]
+	return values
+}
@@ -0,0 +1,168 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// statusKey is the store setting holding the JSON encoded CycleStatus of a pair. The pair name is appended.
+const statusKey = "sync_status:"
+
+// historyKey is the store setting holding the JSON encoded statuses of the recent cycles of a pair, newest
+// first. The pair name is appended.
+const historyKey = "sync_history:"
+
+// HistoryLength is the number of cycles whose status is kept for every pair.
+const HistoryLength = 100
+
+// CycleStatus describes a sync cycle of a pair.
+type CycleStatus struct {
+	// ID identifies the writes of the cycle in the audit log.
+	ID       string    `json:"id,omitempty"`
+	Pair     string    `json:"pair"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	// Full is set when the cycle reconciled every item rather than only the changed ones.
+	Full bool `json:"full"`
+	// Items is the number of work items the cycle synced.
+	Items int `json:"items"`
+	// Failed is the number of work items that could not be synced.
+	Failed int `json:"failed"`
+	// Conflicts is the number of unresolved conflicts at the end of the cycle.
+	Conflicts int `json:"conflicts"`
+	// Error is the reason the cycle failed, or empty when it completed.
+	Error string `json:"error,omitempty"`
+	// ErrorCode is the code of Error.
+	ErrorCode Code `json:"error_code,omitempty"`
+	// LastSuccess is the time the most recent cycle that completed finished. It is carried over from earlier
+	// cycles when this one failed.
+	LastSuccess time.Time `json:"last_success,omitempty"`
+	// Created, Updated and Skipped count the work items whose task was created, those updated on either
+	// side and those left alone.
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Skipped int `json:"skipped"`
+	// APICalls is the number of requests the cycle sent to ADO and Asana, retries included.
+	APICalls int `json:"api_calls"`
+}
+
+// Duration returns how long the cycle ran.
+func (s CycleStatus) Duration() time.Duration {
+	return s.Finished.Sub(s.Started)
+}
+
+// attrs returns the summary of the cycle as log attributes.
+func (s CycleStatus) attrs() []interface{} {
+	a := []interface{}{"full", s.Full, "scanned", s.Items, "created", s.Created, "updated", s.Updated, "skipped", s.Skipped,
+		"conflicts", s.Conflicts, "failed", s.Failed, "api_calls", s.APICalls, "duration", s.Duration().Round(time.Millisecond)}
+	if s.Error != "" {
+		a = append(a, "error", s.Error, "error_code", s.ErrorCode)
+	}
+	return a
+}
+
+// LastCycle returns the status of the most recent sync cycle of the named pair recorded in st. It returns
+// store.ErrNotFound when the pair has not completed a cycle yet.
+func LastCycle(ctx context.Context, st store.Store, pair string) (*CycleStatus, error) {
+	v, err := st.Setting(ctx, statusKey+pair)
+	if err != nil {
+		return nil, err
+	}
+	var s CycleStatus
+	if err := json.Unmarshal([]byte(v), &s); err != nil {
+		return nil, fmt.Errorf("decoding status of pair %q: %w", pair, err)
+	}
+	return &s, nil
+}
+
+// RecentCycles returns the statuses of the last n sync cycles of the named pair recorded in st, newest first.
+// Stores written before the history was kept hold only the last cycle. It returns store.ErrNotFound when the
+// pair has not completed a cycle yet.
+func RecentCycles(ctx context.Context, st store.Store, pair string, n int) ([]CycleStatus, error) {
+	history, err := cycleHistory(ctx, st, pair)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) == 0 {
+		s, err := LastCycle(ctx, st, pair)
+		if err != nil {
+			return nil, err
+		}
+		history = []CycleStatus{*s}
+	}
+	if n > 0 && len(history) > n {
+		history = history[:n]
+	}
+	return history, nil
+}
+
+// cycleHistory returns the statuses of the recent cycles of the named pair, or none when no history was
+// recorded.
+func cycleHistory(ctx context.Context, st store.Store, pair string) ([]CycleStatus, error) {
+	v, err := st.Setting(ctx, historyKey+pair)
+	if errors.Is(err, store.ErrNotFound) || err == nil && v == "" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var history []CycleStatus
+	if err := json.Unmarshal([]byte(v), &history); err != nil {
+		return nil, fmt.Errorf("decoding cycle history of pair %q: %w", pair, err)
+	}
+	return history, nil
+}
+
+// cycleStatus summarizes the cycle that started at start, sent calls API requests and ended with rep or
+// cycleErr.
+func (e *Engine) cycleStatus(ctx context.Context, start time.Time, rep *Report, calls int, cycleErr error) CycleStatus {
+	s := CycleStatus{ID: cycleID(ctx), Pair: e.cfg.Name, Started: start.UTC(), Finished: time.Now().UTC(), APICalls: calls}
+	if cycleErr != nil {
+		s.Error, s.ErrorCode = cycleErr.Error(), Classify(cycleErr)
+		return s
+	}
+	s.Full, s.Items, s.Failed, s.Conflicts = rep.Full, rep.Items, len(rep.Failures), len(rep.Conflicts)
+	s.Created, s.Updated, s.Skipped = rep.Created, rep.Updated, rep.Skipped
+	s.LastSuccess = s.Finished
+	return s
+}
+
+// saveStatus records s as the outcome of the last cycle and adds it to the history of the pair. Failed
+// cycles carry over the last success of the previous one.
+func (e *Engine) saveStatus(ctx context.Context, s CycleStatus) error {
+	if s.Error != "" {
+		switch prev, err := LastCycle(ctx, e.store, e.cfg.Name); {
+		case err == nil:
+			s.LastSuccess = prev.LastSuccess
+		case !errors.Is(err, store.ErrNotFound):
+			return err
+		}
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := e.store.SetSetting(ctx, statusKey+e.cfg.Name, string(b)); err != nil {
+		return fmt.Errorf("saving cycle status: %w", err)
+	}
+	history, err := cycleHistory(ctx, e.store, e.cfg.Name)
+	if err != nil {
+		return err
+	}
+	history = append([]CycleStatus{s}, history...)
+	if len(history) > HistoryLength {
+		history = history[:HistoryLength]
+	}
+	if b, err = json.Marshal(history); err != nil {
+		return err
+	}
+	if err := e.store.SetSetting(ctx, historyKey+e.cfg.Name, string(b)); err != nil {
+		return fmt.Errorf("saving cycle history: %w", err)
+	}
+	return e.store.Flush(ctx)
+}
@@ -0,0 +1,280 @@
+package sync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// statusUpdateKey is the store setting holding, as JSON, the last status update posted for a pair. The pair
+// name is appended.
+const statusUpdateKey = "status_update:"
+
+// DefaultBlockedTag is the ADO tag marking blocked work items when none is configured.
+const DefaultBlockedTag = "Blocked"
+
+// statusListed is the most work items listed under each heading of a status update.
+const statusListed = 10
+
+// StatusUpdateConfig posts a status update on the Asana project of a pair summarizing the progress of the
+// work items its query selects, so stakeholders follow it without leaving Asana.
+type StatusUpdateConfig struct {
+	// Enabled posts a new status update at the end of every cycle after which the progress changed.
+	Enabled bool `json:"enabled,omitempty"`
+	// BlockedTag is the ADO tag marking blocked work items, defaulting to DefaultBlockedTag. Items whose
+	// Blocked field, on CMMI processes, is Yes are blocked too.
+	BlockedTag string `json:"blocked_tag,omitempty"`
+}
+
+// postedStatus is the last status update posted for a pair.
+type postedStatus struct {
+	GID string `json:"gid"`
+	// Sprint is the path of the sprint the update was posted in, whose updates replace each other.
+	Sprint string `json:"sprint,omitempty"`
+	// Hash is that of the title and text of the update, which is only posted again once they change.
+	Hash string `json:"hash"`
+}
+
+// sprintProgress is the progress of the work items of a pair, as summarized by a status update.
+type sprintProgress struct {
+	// sprint is the iteration whose dates include the time of the cycle, nil when none does.
+	sprint *ado.Iteration
+	total  int
+	done   int
+	states map[string]int
+	// inSprint and doneInSprint count the items of the current sprint and those of them that are done.
+	inSprint     int
+	doneInSprint int
+	// closed lists the items completed since the current sprint started, and blocked the open items that
+	// are blocked.
+	closed  []ado.WorkItem
+	blocked []ado.WorkItem
+}
+
+// currentSprint returns the innermost iteration whose dates include now, or nil when none does.
+func currentSprint(its []ado.Iteration, now time.Time) *ado.Iteration {
+	var cur *ado.Iteration
+	for i, it := range its {
+		if it.StartDate == nil || it.FinishDate == nil || now.Before(*it.StartDate) {
+			continue
+		}
+		// Finish dates are the start of the last day of the sprint.
+		if !now.Before(it.FinishDate.AddDate(0, 0, 1)) {
+			continue
+		}
+		if cur == nil || strings.HasPrefix(strings.ToLower(it.Path), strings.ToLower(cur.Path)+`\`) {
+			cur = &its[i]
+		}
+	}
+	return cur
+}
+
+// inIteration reports whether item is in the iteration with the given path or one below it.
+func inIteration(item ado.WorkItem, path string) bool {
+	p := strings.ToLower(item.IterationPath())
+	path = strings.ToLower(path)
+	return p == path || strings.HasPrefix(p, path+`\`)
+}
+
+// blocked reports whether the work item is blocked, by its Blocked field or the blocked tag.
+func (c StatusUpdateConfig) blocked(item ado.WorkItem) bool {
+	if strings.EqualFold(item.String(ado.FieldBlocked), "yes") {
+		return true
+	}
+	tag := c.BlockedTag
+	if tag == "" {
+		tag = DefaultBlockedTag
+	}
+	for _, t := range item.Tags() {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// progress reads the work items the pair selects, a page at a time, and summarizes their progress at now.
+func (e *Engine) progress(ctx context.Context, now time.Time) (*sprintProgress, error) {
+	its, err := e.ado.Iterations(ctx, e.cfg.ADOProject)
+	if err != nil {
+		return nil, fmt.Errorf("listing iterations: %w", err)
+	}
+	p := &sprintProgress{sprint: currentSprint(its, now), states: map[string]int{}}
+	order := newCycleOrder(nil).then(e.idPages(e.cfg.ADOProject, e.cfg.WIQL(), 0, nil))
+	for {
+		ids, err := order.next(ctx, pageSize)
+		if err != nil {
+			return nil, fmt.Errorf("querying work items: %w", err)
+		}
+		if len(ids) == 0 {
+			break
+		}
+		items, err := e.ado.GetWorkItems(ctx, ids)
+		if err != nil {
+			return nil, fmt.Errorf("fetching work items: %w", err)
+		}
+		for _, item := range items {
+			done := e.cfg.taskState(item.State()).completed
+			p.total++
+			p.states[item.State()]++
+			if done {
+				p.done++
+			}
+			if p.sprint != nil && inIteration(item, p.sprint.Path) {
+				p.inSprint++
+				if done {
+					p.doneInSprint++
+				}
+			}
+			switch {
+			case done && p.sprint != nil && !item.StateChangeDate().Before(*p.sprint.StartDate):
+				p.closed = append(p.closed, item)
+			case !done && e.cfg.StatusUpdates.blocked(item):
+				p.blocked = append(p.blocked, item)
+			}
+		}
+	}
+	return p, nil
+}
+
+// title returns the title of the status update summarizing p.
+func (p *sprintProgress) title() string {
+	if p.sprint != nil {
+		name := p.sprint.Path[strings.LastIndex(p.sprint.Path, `\`)+1:]
+		return fmt.Sprintf("%s: %d of %d items done", name, p.doneInSprint, p.inSprint)
+	}
+	return fmt.Sprintf("%d of %d items done", p.done, p.total)
+}
+
+// statusType is at risk when any item is blocked.
+func (p *sprintProgress) statusType() string {
+	if len(p.blocked) > 0 {
+		return asana.StatusAtRisk
+	}
+	return asana.StatusOnTrack
+}
+
+// statusText renders the body of the status update summarizing p, linking the items listed to their task.
+func (e *Engine) statusText(ctx context.Context, p *sprintProgress) string {
+	var b strings.Builder
+	b.WriteString("<body>")
+	if p.sprint != nil {
+		fmt.Fprintf(&b, "<strong>%s</strong> runs %s to %s. ", html.EscapeString(p.sprint.Path),
+			p.sprint.StartDate.Format("2 Jan"), p.sprint.FinishDate.Format("2 Jan 2006"))
+	}
+	fmt.Fprintf(&b, "%d of the %d synced work items are done.\n", p.done, p.total)
+
+	states := make([]string, 0, len(p.states))
+	for s := range p.states {
+		states = append(states, s)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if p.states[states[i]] != p.states[states[j]] {
+			return p.states[states[i]] > p.states[states[j]]
+		}
+		return states[i] < states[j]
+	})
+	b.WriteString("<strong>By state</strong><ul>")
+	for _, s := range states {
+		name := s
+		if name == "" {
+			name = "No state"
+		}
+		fmt.Fprintf(&b, "<li>%s: %d</li>", html.EscapeString(name), p.states[s])
+	}
+	b.WriteString("</ul>")
+
+	list := func(heading string, items []ado.WorkItem) {
+		if len(items) == 0 {
+			return
+		}
+		sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+		fmt.Fprintf(&b, "<strong>%s (%d)</strong><ul>", heading, len(items))
+		for i, item := range items {
+			if i == statusListed {
+				fmt.Fprintf(&b, "<li>and %d more</li>", len(items)-i)
+				break
+			}
+			name := html.EscapeString(fmt.Sprintf("#%d %s", item.ID, item.Title()))
+			if m, err := e.store.Get(ctx, item.ID); err == nil && m.AsanaGID != "" {
+				name = `<a href="https://app.asana.com/0/0/` + html.EscapeString(m.AsanaGID) + `">` + name + "</a>"
+			}
+			b.WriteString("<li>" + name + "</li>")
+		}
+		b.WriteString("</ul>")
+	}
+	if p.sprint != nil {
+		list("Closed this sprint", p.closed)
+	}
+	list("Blocked", p.blocked)
+	b.WriteString("</body>")
+	return b.String()
+}
+
+// postStatus posts a status update on the Asana project of the pair summarizing the progress of the work
+// items it selects, unless it is unchanged since the last one. A new update replaces the one posted
+// earlier in the same sprint, so the project keeps the last update of every sprint.
+func (e *Engine) postStatus(ctx context.Context) error {
+	if !e.cfg.StatusUpdates.Enabled {
+		return nil
+	}
+	p, err := e.progress(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	title, text := p.title(), e.statusText(ctx, p)
+	sum := sha256.Sum256([]byte(title + "\n" + text))
+	hash := hex.EncodeToString(sum[:])
+
+	key := statusUpdateKey + e.cfg.Name
+	var last postedStatus
+	switch v, err := e.store.Setting(ctx, key); {
+	case errors.Is(err, store.ErrNotFound) || (err == nil && v == ""):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal([]byte(v), &last); err != nil {
+			logging.From(ctx).Warn("ignoring invalid setting", "setting", key, "value", v)
+		}
+	}
+	if last.Hash == hash {
+		return nil
+	}
+
+	next := postedStatus{Hash: hash}
+	if p.sprint != nil {
+		next.Sprint = p.sprint.Path
+	}
+	u, err := e.asana.CreateStatusUpdate(ctx, asana.StatusUpdateRequest{Parent: e.cfg.AsanaProject, Title: title,
+		StatusType: p.statusType(), HTMLText: text})
+	if err != nil {
+		return fmt.Errorf("posting asana status update: %w", err)
+	}
+	next.GID = u.GID
+	if last.GID != "" && strings.EqualFold(last.Sprint, next.Sprint) {
+		if err := e.asana.DeleteStatusUpdate(ctx, last.GID); err != nil && !isNotFound(err) {
+			logging.From(ctx).Warn("failed to delete replaced asana status update", "status_update", last.GID, "error", err)
+		}
+	}
+	b, err := json.Marshal(next)
+	if err != nil {
+		return err
+	}
+	if err := e.store.SetSetting(ctx, key, string(b)); err != nil {
+		return fmt.Errorf("recording asana status update: %w", err)
+	}
+	logging.From(ctx).Info("posted asana status update", "title", title, "status", p.statusType())
+	return nil
+}
@@ -0,0 +1,151 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+)
+
+// approvalStatuses are the approval statuses an approval map can list.
+var approvalStatuses = []string{asana.ApprovalPending, asana.ApprovalApproved, asana.ApprovalRejected, asana.ApprovalChangesRequested}
+
+// validateSubtype checks that the subtype of the rule is an Asana task subtype and that its approval map
+// maps approval statuses onto distinct states.
+func (r TypeRule) validateSubtype() error {
+	switch r.Subtype {
+	case "", asana.SubtypeDefault, asana.SubtypeMilestone, asana.SubtypeApproval:
+	default:
+		return fmt.Errorf("unknown subtype %q, expected %s, %s or %s", r.Subtype, asana.SubtypeDefault, asana.SubtypeMilestone, asana.SubtypeApproval)
+	}
+	if len(r.Approvals) == 0 {
+		return nil
+	}
+	if r.Subtype != asana.SubtypeApproval {
+		return fmt.Errorf("approvals need subtype %s", asana.SubtypeApproval)
+	}
+	states := map[string]string{}
+	for status, state := range r.Approvals {
+		if !contains(approvalStatuses, status) {
+			return fmt.Errorf("unknown approval status %q, expected one of %s", status, strings.Join(approvalStatuses, ", "))
+		}
+		if state == "" {
+			return fmt.Errorf("approval status %q has no state", status)
+		}
+		if other, ok := states[strings.ToLower(state)]; ok {
+			first, second := other, status
+			if first > second {
+				first, second = second, first
+			}
+			return fmt.Errorf("approval statuses %q and %q both map to state %q", first, second, state)
+		}
+		states[strings.ToLower(state)] = status
+	}
+	return nil
+}
+
+// contains reports whether values holds v.
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// subtype returns the task subtype set by the rule of the type of item, or "" when tasks keep theirs.
+func (c Config) subtype(item ado.WorkItem) string {
+	if r := c.typeRule(item.Type()); r != nil {
+		return r.Subtype
+	}
+	return ""
+}
+
+// approvals returns the approval map of the type of item, or nil when its tasks are not approval tasks
+// synced by approval status.
+func (c Config) approvals(item ado.WorkItem) map[string]string {
+	if r := c.typeRule(item.Type()); r != nil && r.Subtype == asana.SubtypeApproval {
+		return r.Approvals
+	}
+	return nil
+}
+
+// approvalStatus returns the approval status the task of an item in the ADO state is given, or "" when the
+// approval map does not list the state.
+func approvalStatus(approvals map[string]string, state string) string {
+	statuses := make([]string, 0, len(approvals))
+	for status := range approvals {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	for _, status := range statuses {
+		if strings.EqualFold(approvals[status], state) {
+			return status
+		}
+	}
+	return ""
+}
+
+// subtypeRequest sets the subtype of the rule of the type of item on req, the request creating its task, with
+// the approval status of the state of item for approval tasks, which replaces their completion.
+func (c Config) subtypeRequest(item ado.WorkItem, req *asana.TaskRequest) {
+	subtype := c.subtype(item)
+	if subtype == "" {
+		return
+	}
+	req.ResourceSubtype = asana.String(subtype)
+	if status := approvalStatus(c.approvals(item), item.State()); status != "" {
+		req.ApprovalStatus, req.Completed = asana.String(status), nil
+	}
+}
+
+// syncSubtype changes the subtype of task to the one of the rule of the type of item, in req.
+func (e *Engine) syncSubtype(ctx context.Context, item ado.WorkItem, task *asana.Task, req *asana.TaskRequest) bool {
+	subtype := e.cfg.subtype(item)
+	if subtype == "" || subtype == task.ResourceSubtype {
+		return false
+	}
+	logging.From(ctx).Info("changing subtype of asana task", "from", task.ResourceSubtype, "to", subtype, "type", item.Type())
+	req.ResourceSubtype = asana.String(subtype)
+	return true
+}
+
+// syncApproval brings the approval status of the approval task of item and the state of item into step,
+// setting the status in req or returning the operation setting the state. It reports whether req changed.
+func (e *Engine) syncApproval(ctx context.Context, approvals map[string]string, item ado.WorkItem, task *asana.Task, ch changes, req *asana.TaskRequest, rep *Report) ([]ado.PatchOperation, bool) {
+	want, have := approvalStatus(approvals, item.State()), task.ApprovalStatus
+	if task.ResourceSubtype != asana.SubtypeApproval {
+		// A task becoming an approval task starts pending, so it is given the status of its item.
+		have = ""
+	}
+	if want == have {
+		e.clearConflict(ctx, item.ID, FieldState)
+		return nil, false
+	}
+	switch s, ok := e.pick(ctx, FieldState, item, task, ch, orNone(want), orNone(have), rep); {
+	case !ok:
+	case s == sideADO || have == "":
+		if want != "" {
+			req.ApprovalStatus = asana.String(want)
+			return nil, true
+		}
+	default:
+		if state := approvals[have]; state != "" && !strings.EqualFold(state, item.State()) {
+			return []ado.PatchOperation{ado.SetField(ado.FieldState, state)}, false
+		}
+	}
+	return nil, false
+}
+
+// orNone returns s, or "none" when it is empty, for the values of conflicts.
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
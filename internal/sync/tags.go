@@ -0,0 +1,267 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	gosync "sync"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/store"
+)
+
+// TagConfig controls tag synchronization between work item tags and Asana tags.
+type TagConfig struct {
+	// Direction controls tag mirroring. Tags are not synced when empty.
+	Direction Direction `json:"direction,omitempty"`
+	// Allow lists the tag name patterns that are synced, for example "team-*". Every tag is synced when empty.
+	Allow []string `json:"allow,omitempty"`
+	// Deny lists tag name patterns that are never synced, even when allowed.
+	Deny []string `json:"deny,omitempty"`
+}
+
+// ParseTagPatterns parses a comma separated list of tag name patterns.
+func ParseTagPatterns(s string) []string {
+	var patterns []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// Validate checks that every pattern is well formed.
+func (c TagConfig) Validate() error {
+	for _, p := range append(append([]string(nil), c.Allow...), c.Deny...) {
+		if _, err := path.Match(strings.ToLower(p), ""); err != nil {
+			return fmt.Errorf("invalid tag pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// synced reports whether the tag with the given name passes the allow and deny lists.
+func (c TagConfig) synced(name string) bool {
+	if matchAny(c.Deny, name) {
+		return false
+	}
+	return len(c.Allow) == 0 || matchAny(c.Allow, name)
+}
+
+func matchAny(patterns []string, name string) bool {
+	name = strings.ToLower(name)
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ToLower(p), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// tagCache caches the tags of Asana workspaces by lower case name. It is shared by the engines of a Manager.
+type tagCache struct {
+	mu          gosync.Mutex
+	byWorkspace map[string]map[string]asana.Tag
+}
+
+func newTagCache() *tagCache {
+	return &tagCache{byWorkspace: map[string]map[string]asana.Tag{}}
+}
+
+// get returns the tag with the given name, creating it when the workspace does not have it. The cached
+// tags are reloaded once before creating a tag, so tags added in Asana since they were loaded are reused.
+func (c *tagCache) get(ctx context.Context, client Asana, workspace, name string) (asana.Tag, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := strings.ToLower(name)
+	if t, ok := c.byWorkspace[workspace][key]; ok {
+		return t, nil
+	}
+
+	tags, err := c.load(ctx, client, workspace)
+	if err != nil {
+		return asana.Tag{}, err
+	}
+	if t, ok := tags[key]; ok {
+		return t, nil
+	}
+
+	t, err := client.CreateTag(ctx, workspace, name)
+	if err != nil {
+		return asana.Tag{}, fmt.Errorf("creating asana tag %q: %w", name, err)
+	}
+	logging.From(ctx).Info("created asana tag", "tag", name, "asana_workspace", workspace)
+	tags[key] = *t
+	return *t, nil
+}
+
+// forget drops the cached tag with the given name, so it is looked up again after a write referring to it
+// failed with not found, as when the tag was deleted in Asana.
+func (c *tagCache) forget(workspace, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.byWorkspace[workspace], strings.ToLower(name))
+}
+
+// warm lists the tags of the workspace unless they are cached.
+func (c *tagCache) warm(ctx context.Context, client Asana, workspace string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.byWorkspace[workspace]; ok {
+		return nil
+	}
+	_, err := c.load(ctx, client, workspace)
+	return err
+}
+
+// load lists the tags of the workspace. The caller must hold c.mu.
+func (c *tagCache) load(ctx context.Context, client Asana, workspace string) (map[string]asana.Tag, error) {
+	list, err := client.WorkspaceTags(ctx, workspace)
+	if err != nil {
+		return nil, fmt.Errorf("listing asana tags: %w", err)
+	}
+	tags := make(map[string]asana.Tag, len(list))
+	for _, t := range list {
+		tags[strings.ToLower(t.Name)] = t
+	}
+	c.byWorkspace[workspace] = tags
+	return tags, nil
+}
+
+// tagSet is a set of tag names keyed by lower case name, keeping the first spelling seen.
+type tagSet map[string]string
+
+func newTagSet(names []string) tagSet {
+	s := tagSet{}
+	for _, n := range names {
+		s.add(n)
+	}
+	return s
+}
+
+func (s tagSet) add(name string) {
+	if _, ok := s[strings.ToLower(name)]; !ok {
+		s[strings.ToLower(name)] = name
+	}
+}
+
+func (s tagSet) has(name string) bool {
+	_, ok := s[strings.ToLower(name)]
+	return ok
+}
+
+// names returns the tag names in sorted order.
+func (s tagSet) names() []string {
+	names := make([]string, 0, len(s))
+	for _, n := range s {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s tagSet) equal(o tagSet) bool {
+	if len(s) != len(o) {
+		return false
+	}
+	for k := range s {
+		if _, ok := o[k]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// syncTags brings the synced tags of item and task into step. Asana tags are written immediately; the
+// returned operations update the work item tags and must be applied by the caller. tags is the resulting
+// set of synced tags, to be recorded in the mapping. m is nil when the item was not mapped before, and
+// created is set when task was just created for item.
+func (e *Engine) syncTags(ctx context.Context, item ado.WorkItem, task *asana.Task, m *store.Mapping, created bool) (ops []ado.PatchOperation, tags []string, err error) {
+	dir := e.cfg.Tags.Direction
+	if dir == "" {
+		return nil, nil, nil
+	}
+
+	adoTags, asanaTags := tagSet{}, tagSet{}
+	var unsynced []string
+	// Tags added by type rules are left out, so they are not mirrored to ADO or removed from Asana.
+	for _, t := range item.Tags() {
+		if e.cfg.Tags.synced(t) && !e.cfg.typeTag(t) {
+			adoTags.add(t)
+		} else {
+			unsynced = append(unsynced, t)
+		}
+	}
+	asanaGIDs := map[string]string{}
+	for _, t := range task.Tags {
+		if e.cfg.Tags.synced(t.Name) && !e.cfg.typeTag(t.Name) {
+			asanaTags.add(t.Name)
+			asanaGIDs[strings.ToLower(t.Name)] = t.GID
+		}
+	}
+
+	want := tagSet{}
+	switch {
+	case created || dir == ADOToAsana:
+		want = adoTags
+	case dir == AsanaToADO:
+		want = asanaTags
+	default:
+		// Three-way merge against the tags of the last sync: a tag is kept when both sides have it or
+		// when it is new on the side that has it, so removals on either side win.
+		var base tagSet
+		if m != nil {
+			base = newTagSet(m.Tags)
+		}
+		for k, name := range adoTags {
+			if asanaTags.has(name) || !base.has(name) {
+				want[k] = name
+			}
+		}
+		for _, name := range asanaTags {
+			if !base.has(name) {
+				want.add(name)
+			}
+		}
+	}
+
+	if dir != AsanaToADO || created {
+		// Tags are added and removed in name order, so the writes and the tags created are the same every run.
+		for _, name := range want.names() {
+			if asanaTags.has(name) {
+				continue
+			}
+			tag, err := e.tags.get(ctx, e.asana, e.cfg.AsanaWorkspace, name)
+			if err != nil {
+				return nil, nil, err
+			}
+			if err := e.asana.AddTag(ctx, task.GID, tag.GID); err != nil {
+				if isNotFound(err) {
+					e.tags.forget(e.cfg.AsanaWorkspace, name)
+				}
+				return nil, nil, fmt.Errorf("adding tag %q to asana task: %w", name, err)
+			}
+			logging.From(ctx).Info("added tag to asana task", "tag", name)
+		}
+		for _, name := range asanaTags.names() {
+			if want.has(name) {
+				continue
+			}
+			if err := e.asana.RemoveTag(ctx, task.GID, asanaGIDs[strings.ToLower(name)]); err != nil {
+				return nil, nil, fmt.Errorf("removing tag %q from asana task: %w", name, err)
+			}
+			logging.From(ctx).Info("removed tag from asana task", "tag", name)
+		}
+	}
+
+	tags = want.names()
+	if dir != ADOToAsana && !created && !want.equal(adoTags) {
+		ops = append(ops, ado.SetField(ado.FieldTags, strings.Join(append(unsynced, tags...), "; ")))
+	}
+	return ops, tags, nil
+}
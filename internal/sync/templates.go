@@ -0,0 +1,131 @@
+package sync
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	"text/template"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+)
+
+// TaskData is the data the task name and notes templates are executed with.
+type TaskData struct {
+	ID    int
+	Rev   int
+	Type  string
+	State string
+	Title string
+	// AssignedTo is the display name of the assignee, empty when unassigned.
+	AssignedTo string
+	Tags       []string
+	// URL is the browser URL of the work item.
+	URL string
+	// Description is the description of the work item converted to Asana rich text. It is only set for
+	// the notes template.
+	Description htmltemplate.HTML
+	// Fields holds every field of the work item by reference name, for example "Microsoft.VSTS.Common.Priority".
+	Fields map[string]interface{}
+}
+
+// newTaskData returns the template data of item.
+func newTaskData(item ado.WorkItem) TaskData {
+	d := TaskData{
+		ID:     item.ID,
+		Rev:    item.Rev,
+		Type:   item.Type(),
+		State:  item.State(),
+		Title:  item.Title(),
+		Tags:   item.Tags(),
+		URL:    item.Link(),
+		Fields: item.Fields,
+	}
+	if a := item.AssignedTo(); a != nil {
+		d.AssignedTo = a.DisplayName
+	}
+	return d
+}
+
+// templateFuncs are available to both templates. join joins a list such as the tags.
+var templateFuncs = map[string]interface{}{
+	"join": strings.Join,
+}
+
+// taskTemplates holds the parsed task name and notes templates of a pair. A nil template keeps the default.
+type taskTemplates struct {
+	name  *template.Template
+	notes *htmltemplate.Template
+}
+
+// parseTemplates parses the templates of cfg. The notes template is an HTML template, so field values are
+// escaped unless they are the sanitized Description.
+func parseTemplates(cfg Config) (*taskTemplates, error) {
+	t := &taskTemplates{}
+	var err error
+	if cfg.NameTemplate != "" {
+		if t.name, err = template.New("name").Funcs(templateFuncs).Option("missingkey=zero").Parse(cfg.NameTemplate); err != nil {
+			return nil, fmt.Errorf("invalid name template: %w", err)
+		}
+	}
+	if cfg.NotesTemplate != "" {
+		if t.notes, err = htmltemplate.New("notes").Funcs(templateFuncs).Option("missingkey=zero").Parse(cfg.NotesTemplate); err != nil {
+			return nil, fmt.Errorf("invalid notes template: %w", err)
+		}
+	}
+	return t, nil
+}
+
+// ValidateTemplates checks that the name and notes templates of c parse, the notes format and overflow policy
+// are known, and that a custom name template is only synced from ADO, as a rendered name cannot be turned
+// back into a title.
+func (c Config) ValidateTemplates() error {
+	if _, err := parseTemplates(c); err != nil {
+		return err
+	}
+	if _, err := ParseNotesFormat(string(c.NotesFormat)); err != nil {
+		return err
+	}
+	if _, err := ParseOverflowPolicy(string(c.NotesOverflow)); err != nil {
+		return err
+	}
+	if c.NotesLimit < 0 {
+		return fmt.Errorf("the notes limit must not be negative")
+	}
+	if c.NameTemplate != "" && c.DirectionFor(FieldTitle) != ADOToAsana {
+		return fmt.Errorf("a name template needs the title to sync ado-to-asana, set the direction of the title field")
+	}
+	return nil
+}
+
+// customName reports whether a name template is configured.
+func (t *taskTemplates) customName() bool {
+	return t != nil && t.name != nil
+}
+
+// taskName returns the Asana task name of item, rendering the name template when one is configured.
+func (t *taskTemplates) taskName(item ado.WorkItem) (string, error) {
+	if !t.customName() {
+		return taskName(item), nil
+	}
+	var b bytes.Buffer
+	if err := t.name.Execute(&b, newTaskData(item)); err != nil {
+		return "", fmt.Errorf("rendering name template: %w", err)
+	}
+	// Asana task names are a single line.
+	return strings.Join(strings.Fields(b.String()), " "), nil
+}
+
+// customNotes reports whether a notes template is configured.
+func (t *taskTemplates) customNotes() bool {
+	return t != nil && t.notes != nil
+}
+
+// htmlNotes renders the html_notes of a task from d.
+func (t *taskTemplates) htmlNotes(d TaskData) (string, error) {
+	var b bytes.Buffer
+	if err := t.notes.Execute(&b, d); err != nil {
+		return "", fmt.Errorf("rendering notes template: %w", err)
+	}
+	return "<body>" + strings.TrimSpace(b.String()) + "</body>", nil
+}
@@ -0,0 +1,175 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+)
+
+// Headings of the test block of task notes: the steps of a test case, or the test cases of another work item.
+const (
+	testStepsHeading = "<strong>Test steps</strong>"
+	testCasesHeading = "<strong>Test cases</strong>"
+)
+
+// testsBlock matches the test block of task notes, as written by withTests and reformatted by Asana.
+var testsBlock = regexp.MustCompile(`(?s)\s*<hr\s*/?>\s*<strong>Test (?:steps|cases)</strong>\s*<ul>.*?</ul>`)
+
+// tests renders the test block of item: the checklist of its steps for a test case, each marked with its
+// outcome in the latest run, or the list of the test cases linked to other work items with the outcome of
+// their latest run. It is empty when there is nothing to list. Outcomes that cannot be read are left out.
+func (e *Engine) tests(ctx context.Context, item ado.WorkItem) string {
+	if strings.EqualFold(item.Type(), ado.TypeTestCase) {
+		return e.testSteps(ctx, item)
+	}
+	ids := item.Linked(ado.RelTestedBy)
+	if len(ids) == 0 {
+		return ""
+	}
+	cases, err := e.ado.GetWorkItems(ctx, ids)
+	if err != nil {
+		logging.From(ctx).Warn("failed to read linked test cases", "error", err)
+		cases = nil
+	}
+	byID := make(map[int]ado.WorkItem, len(cases))
+	for _, c := range cases {
+		byID[c.ID] = c
+	}
+	latest := e.latestPoints(ctx, ids)
+	var b strings.Builder
+	b.WriteString("<hr/>" + testCasesHeading + "<ul>")
+	for _, id := range ids {
+		text := fmt.Sprintf("Test case %d", id)
+		c, ok := byID[id]
+		if ok {
+			text += ": " + c.Title()
+		}
+		outcome := latest[id].Outcome
+		if label := outcomeLabel(outcome); label != "" {
+			text += " (" + label + ")"
+		}
+		b.WriteString("<li>" + outcomeMark(outcome) + " ")
+		if ok && c.Link() != "" {
+			b.WriteString(`<a href="` + html.EscapeString(c.Link()) + `">` + html.EscapeString(text) + "</a>")
+		} else {
+			b.WriteString(html.EscapeString(text))
+		}
+		b.WriteString("</li>")
+	}
+	b.WriteString("</ul>")
+	return b.String()
+}
+
+// testSteps renders the checklist of the steps of the test case item, or an empty string when it has none.
+func (e *Engine) testSteps(ctx context.Context, item ado.WorkItem) string {
+	steps := item.TestSteps()
+	if len(steps) == 0 {
+		return ""
+	}
+	var outcomes map[int]string
+	if p, ok := e.latestPoints(ctx, []int{item.ID})[item.ID]; ok {
+		if run, result, ok := p.Run(); ok {
+			r, err := e.ado.TestResult(ctx, e.cfg.ADOProject, run, result)
+			if err != nil {
+				logging.From(ctx).Warn("failed to read the latest test result", "test_run", run, "error", err)
+			} else {
+				outcomes = r.StepOutcomes()
+			}
+		}
+	}
+	var b strings.Builder
+	b.WriteString("<hr/>" + testStepsHeading + "<ul>")
+	for i, s := range steps {
+		text := fmt.Sprintf("%d. %s", i+1, plainText(s.Action))
+		if s.SharedSteps != 0 {
+			text = fmt.Sprintf("%d. Shared steps %d", i+1, s.SharedSteps)
+		}
+		if expected := plainText(s.Expected); expected != "" {
+			text += " → " + expected
+		}
+		if label := outcomeLabel(outcomes[s.ID]); label != "" {
+			text += " (" + label + ")"
+		}
+		b.WriteString("<li>" + outcomeMark(outcomes[s.ID]) + " " + html.EscapeString(text) + "</li>")
+	}
+	b.WriteString("</ul>")
+	return b.String()
+}
+
+// latestPoints returns the test point of each test case run most recently in any test plan, or nil when the
+// points cannot be read.
+func (e *Engine) latestPoints(ctx context.Context, testCases []int) map[int]ado.TestPoint {
+	points, err := e.ado.TestPoints(ctx, e.cfg.ADOProject, testCases)
+	if err != nil {
+		logging.From(ctx).Warn("failed to read test points", "error", err)
+		return nil
+	}
+	latest := map[int]ado.TestPoint{}
+	for _, p := range points {
+		if _, _, ok := p.Run(); !ok {
+			continue
+		}
+		if cur, ok := latest[p.TestCaseID()]; !ok || p.LastUpdatedDate.After(cur.LastUpdatedDate) {
+			latest[p.TestCaseID()] = p
+		}
+	}
+	return latest
+}
+
+// outcomeMark returns the checkbox of a test outcome: ticked when passed, crossed when failed and empty
+// otherwise.
+func outcomeMark(outcome string) string {
+	switch outcome {
+	case ado.OutcomePassed:
+		return "✅"
+	case ado.OutcomeFailed:
+		return "❌"
+	}
+	return "☐"
+}
+
+// outcomeLabel returns the lower case outcome of a run, or an empty string for outcomes of steps and test
+// cases that have not been run.
+func outcomeLabel(outcome string) string {
+	switch outcome {
+	case "", "Unspecified", "None", "NotApplicable":
+		return ""
+	}
+	return strings.ToLower(outcome)
+}
+
+// withTests returns the html_notes with their test block replaced by block, which is appended when the notes
+// have none. An empty block removes it.
+func withTests(notes, block string) string {
+	return withBlock(testsBlock, notes, block)
+}
+
+// testNotes returns the notes of task with the test block of item brought up to date, as developmentNotes
+// does for the Development block. The outcomes of test runs do not change the work item, so a block with
+// entries is compared on every sync.
+func (e *Engine) testNotes(ctx context.Context, item ado.WorkItem, task *asana.Task, rendered *string, ch changes) (notes string, ok bool, err error) {
+	if !e.cfg.TestCases {
+		return "", false, nil
+	}
+	block := e.tests(ctx, item)
+	if rendered != nil {
+		return withTests(*rendered, block), true, nil
+	}
+	if block == "" && !ch.ado {
+		return "", false, nil
+	}
+	cur, err := e.asana.TaskHTMLNotes(ctx, task.GID)
+	if err != nil {
+		return "", false, fmt.Errorf("reading asana task notes: %w", err)
+	}
+	if plainText(testsBlock.FindString(cur)) == plainText(block) {
+		return "", false, nil
+	}
+	return withTests(cur, block), true, nil
+}
@@ -0,0 +1,270 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+)
+
+// TypeRule changes how the work items of one type are synced. Settings it leaves empty keep those of the
+// pair.
+type TypeRule struct {
+	// Type is the work item type, such as Bug, matched ignoring case.
+	Type string `json:"type"`
+	// Skip leaves the items of the type unsynced. Tasks created for them before are left alone.
+	Skip bool `json:"skip,omitempty"`
+	// NameTemplate and NotesTemplate replace the task templates of the pair.
+	NameTemplate  string `json:"name_template,omitempty"`
+	NotesTemplate string `json:"notes_template,omitempty"`
+	// Section is the section all tasks of the type are moved into, whatever the state of their item.
+	Section string `json:"section,omitempty"`
+	// Sections maps states to sections for the type, replacing the section mappings of the pair.
+	Sections map[string]string `json:"sections,omitempty"`
+	// Tags are Asana tags added to every task of the type. They are left out of tag sync, so they do not
+	// reach ADO.
+	Tags []string `json:"tags,omitempty"`
+	// FieldMappings are used in addition to the field mappings of the pair, replacing those with the same
+	// target.
+	FieldMappings []FieldMapping `json:"field_mappings,omitempty"`
+	// Subtype is the Asana subtype of the tasks of the type: default_task, milestone or approval. Tasks keep
+	// their subtype when empty.
+	Subtype string `json:"subtype,omitempty"`
+	// Approvals maps the approval statuses of approval tasks onto the ADO state their work item is set to,
+	// and back. The approval status replaces the completion of the task in state sync.
+	Approvals map[string]string `json:"approvals,omitempty"`
+}
+
+// ParseSkipTypes parses a comma separated list of work item types into rules skipping them.
+func ParseSkipTypes(s string) []TypeRule {
+	var rules []TypeRule
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			rules = append(rules, TypeRule{Type: t, Skip: true})
+		}
+	}
+	return rules
+}
+
+// ValidateTypes checks that every type has a single rule and that the templates, sections and field
+// mappings of the rules are valid.
+func (c Config) ValidateTypes() error {
+	seen := map[string]bool{}
+	for _, r := range c.Types {
+		if r.Type == "" {
+			return fmt.Errorf("type rule without a type")
+		}
+		key := strings.ToLower(r.Type)
+		if seen[key] {
+			return fmt.Errorf("duplicate rule for type %q", r.Type)
+		}
+		seen[key] = true
+		if err := c.forType(r).ValidateTemplates(); err != nil {
+			return fmt.Errorf("type %q: %w", r.Type, err)
+		}
+		if (r.Section != "" || len(r.Sections) > 0) && c.Sprints.Mode == SprintsSection {
+			return fmt.Errorf("type %q: sections cannot be combined with sprint sections", r.Type)
+		}
+		for _, m := range r.FieldMappings {
+			if err := m.Validate(); err != nil {
+				return fmt.Errorf("type %q: %w", r.Type, err)
+			}
+		}
+		for _, t := range r.Tags {
+			if strings.TrimSpace(t) == "" {
+				return fmt.Errorf("type %q: empty tag", r.Type)
+			}
+		}
+		if err := r.validateSubtype(); err != nil {
+			return fmt.Errorf("type %q: %w", r.Type, err)
+		}
+	}
+	return nil
+}
+
+// typeRule returns the rule of the given work item type, or nil when it has none.
+func (c Config) typeRule(typ string) *TypeRule {
+	for i := range c.Types {
+		if strings.EqualFold(c.Types[i].Type, typ) {
+			return &c.Types[i]
+		}
+	}
+	return nil
+}
+
+// forType returns c with the templates and field mappings of rule r applied.
+func (c Config) forType(r TypeRule) Config {
+	if r.NameTemplate != "" {
+		c.NameTemplate = r.NameTemplate
+	}
+	if r.NotesTemplate != "" {
+		c.NotesTemplate = r.NotesTemplate
+	}
+	if len(r.FieldMappings) > 0 {
+		mappings := append([]FieldMapping(nil), r.FieldMappings...)
+		for _, m := range c.FieldMappings {
+			if !hasTarget(r.FieldMappings, m.Target) {
+				mappings = append(mappings, m)
+			}
+		}
+		c.FieldMappings = mappings
+	}
+	return c
+}
+
+// hasTarget reports whether one of mappings writes the Asana field target.
+func hasTarget(mappings []FieldMapping, target string) bool {
+	for _, m := range mappings {
+		if strings.EqualFold(m.Target, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// typedFields reports whether a type rule has field mappings.
+func (c Config) typedFields() bool {
+	for _, r := range c.Types {
+		if len(r.FieldMappings) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// typedSections reports whether a type rule moves tasks between sections.
+func (c Config) typedSections() bool {
+	for _, r := range c.Types {
+		if r.Section != "" || len(r.Sections) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// typeSection returns the section the rule of the type of item moves its task into.
+func (c Config) typeSection(item ado.WorkItem) (section string, ruled, ok bool) {
+	r := c.typeRule(item.Type())
+	switch {
+	case r == nil || (r.Section == "" && len(r.Sections) == 0):
+		return "", false, false
+	case r.Section != "":
+		return r.Section, true, true
+	}
+	for s, section := range r.Sections {
+		if strings.EqualFold(s, item.State()) {
+			return section, true, true
+		}
+	}
+	return "", true, false
+}
+
+// skipped reports whether the type of item is not synced.
+func (c Config) skipped(item ado.WorkItem) bool {
+	r := c.typeRule(item.Type())
+	return r != nil && r.Skip
+}
+
+// typeTags returns the tags the rule of the type of item adds to its task.
+func (c Config) typeTags(item ado.WorkItem) []string {
+	if r := c.typeRule(item.Type()); r != nil {
+		return r.Tags
+	}
+	return nil
+}
+
+// typeTag reports whether name is a tag added by a type rule, which tag sync leaves alone.
+func (c Config) typeTag(name string) bool {
+	for _, r := range c.Types {
+		for _, t := range r.Tags {
+			if strings.EqualFold(t, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// templatesFor returns the task templates of item, those of its type rule when it sets any.
+func (e *Engine) templatesFor(item ado.WorkItem) *taskTemplates {
+	if t, ok := e.typeTemplates[strings.ToLower(item.Type())]; ok {
+		return t
+	}
+	return e.templates
+}
+
+// parseTypeTemplates parses the templates of the type rules that set any, keyed by lower case type.
+func (e *Engine) parseTypeTemplates() (map[string]*taskTemplates, error) {
+	templates := map[string]*taskTemplates{}
+	for _, r := range e.cfg.Types {
+		if r.NameTemplate == "" && r.NotesTemplate == "" {
+			continue
+		}
+		t, err := parseTemplates(e.cfg.forType(r))
+		if err != nil {
+			return nil, fmt.Errorf("type %q: %w", r.Type, err)
+		}
+		templates[strings.ToLower(r.Type)] = t
+	}
+	return templates, nil
+}
+
+// fieldsFor returns the resolved field mappings of item, those of its type rule when it has any.
+func (t *target) fieldsFor(item ado.WorkItem) []resolvedField {
+	if f, ok := t.typeFields[strings.ToLower(item.Type())]; ok {
+		return f
+	}
+	return t.fields
+}
+
+// resolveTypeFields resolves the field mappings of the type rules that have any against the custom fields
+// of the Asana project, keyed by lower case type. Mappings of mismatched targets are left out.
+func (e *Engine) resolveTypeFields(fields []asana.CustomField, project string, mismatched map[string]bool) (map[string][]resolvedField, error) {
+	resolved := map[string][]resolvedField{}
+	for _, r := range e.cfg.Types {
+		if len(r.FieldMappings) == 0 {
+			continue
+		}
+		rf, err := resolveMappings(withoutTargets(e.cfg.forType(r).FieldMappings, mismatched), e.sources, fields, project)
+		if err != nil {
+			return nil, fmt.Errorf("type %q: %w", r.Type, err)
+		}
+		resolved[strings.ToLower(r.Type)] = rf
+	}
+	return resolved, nil
+}
+
+// syncTypeTags adds the tags of the type rule of item that task does not have.
+func (e *Engine) syncTypeTags(ctx context.Context, item ado.WorkItem, task *asana.Task) error {
+	for _, name := range e.cfg.typeTags(item) {
+		if hasTag(task, name) {
+			continue
+		}
+		tag, err := e.tags.get(ctx, e.asana, e.cfg.AsanaWorkspace, name)
+		if err != nil {
+			return err
+		}
+		if err := e.asana.AddTag(ctx, task.GID, tag.GID); err != nil {
+			if isNotFound(err) {
+				e.tags.forget(e.cfg.AsanaWorkspace, name)
+			}
+			return fmt.Errorf("adding tag %q to asana task: %w", name, err)
+		}
+		task.Tags = append(task.Tags, tag)
+		logging.From(ctx).Info("added tag of work item type to asana task", "tag", name, "type", item.Type())
+	}
+	return nil
+}
+
+// hasTag reports whether task has the tag with the given name.
+func hasTag(task *asana.Task, name string) bool {
+	for _, t := range task.Tags {
+		if strings.EqualFold(t.Name, name) {
+			return true
+		}
+	}
+	return false
+}
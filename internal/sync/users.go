@@ -0,0 +1,198 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+)
+
+// How an ADO assignee was matched to an Asana user.
+const (
+	MatchMapping = "mapping"
+	MatchEmail   = "email"
+	MatchFuzzy   = "fuzzy"
+)
+
+// ParseUserMappings parses a comma separated list of ado-user=asana-gid pairs, for example
+// "jdoe@contoso.com=1200000000000001". The ADO user is the unique name shown on work items.
+func ParseUserMappings(s string) (map[string]string, error) {
+	users := map[string]string{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, gid, ok := strings.Cut(part, "=")
+		name, gid = strings.TrimSpace(name), strings.TrimSpace(gid)
+		if !ok || name == "" || gid == "" {
+			return nil, fmt.Errorf("invalid user mapping %q, expected ado-user=asana-gid", part)
+		}
+		users[name] = gid
+	}
+	return users, nil
+}
+
+// userDirectory matches ADO identities to the users of the Asana workspace.
+type userDirectory struct {
+	byGID   map[string]asana.User
+	byEmail map[string]asana.User
+	// mapped holds the configured mappings keyed by lower case ADO unique name.
+	mapped map[string]string
+	fuzzy  bool
+	// byLocal and byName index the users for fuzzy matching. Keys shared by several users hold nothing, so
+	// an ambiguous match never picks one of them.
+	byLocal map[string]*asana.User
+	byName  map[string]*asana.User
+}
+
+// newUserDirectory indexes the workspace users for the mappings and fuzzy matching of cfg.
+func newUserDirectory(ctx context.Context, users []asana.User, cfg Config) *userDirectory {
+	d := &userDirectory{
+		byGID:   make(map[string]asana.User, len(users)),
+		byEmail: make(map[string]asana.User, len(users)),
+		mapped:  make(map[string]string, len(cfg.UserMappings)),
+		fuzzy:   cfg.FuzzyUserMatching,
+		byLocal: map[string]*asana.User{},
+		byName:  map[string]*asana.User{},
+	}
+	add := func(idx map[string]*asana.User, key string, u asana.User) {
+		if key == "" {
+			return
+		}
+		if _, seen := idx[key]; seen {
+			idx[key] = nil
+			return
+		}
+		idx[key] = &u
+	}
+	for _, u := range users {
+		d.byGID[u.GID] = u
+		if u.Email != "" {
+			d.byEmail[strings.ToLower(u.Email)] = u
+			add(d.byLocal, localPart(u.Email), u)
+		}
+		add(d.byName, nameKey(u.Name), u)
+	}
+	for name, gid := range cfg.UserMappings {
+		if _, ok := d.byGID[gid]; !ok {
+			logging.From(ctx).Warn("user mapping names no user of the asana workspace", "ado_user", name, "asana_user_gid", gid)
+			continue
+		}
+		d.mapped[strings.ToLower(name)] = gid
+	}
+	return d
+}
+
+// match returns the Asana user of the ADO identity and how it was matched, or nil. Configured mappings take
+// precedence over an exact email match, which takes precedence over fuzzy matching.
+func (d *userDirectory) match(id *ado.Identity) (*asana.User, string) {
+	if id == nil || d == nil {
+		return nil, ""
+	}
+	unique := strings.ToLower(id.UniqueName)
+	if gid, ok := d.mapped[unique]; ok {
+		u := d.byGID[gid]
+		return &u, MatchMapping
+	}
+	if u, ok := d.byEmail[unique]; ok {
+		return &u, MatchEmail
+	}
+	if !d.fuzzy {
+		return nil, ""
+	}
+	// Corporate domains often differ between the systems while the mailbox name is the same.
+	if u := d.byLocal[localPart(id.UniqueName)]; u != nil {
+		return u, MatchFuzzy
+	}
+	if u := d.byName[nameKey(id.DisplayName)]; u != nil {
+		return u, MatchFuzzy
+	}
+	return nil, ""
+}
+
+// localPart returns the normalized part of an email address or UPN before the @, or an empty string when s is
+// not an address.
+func localPart(s string) string {
+	local, _, ok := strings.Cut(s, "@")
+	if !ok {
+		return ""
+	}
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, local)
+}
+
+// nameKey normalizes a display name so "Doe, John", "john doe" and "John  Doe" match: the lower case words
+// without punctuation, sorted.
+func nameKey(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	sort.Strings(words)
+	return strings.Join(words, " ")
+}
+
+// AssigneeMatch is an ADO assignee found by ScanAssignees.
+type AssigneeMatch struct {
+	Identity ado.Identity
+	// User is the matched Asana user, nil when the assignee is unmatched.
+	User *asana.User
+	// Method is MatchMapping, MatchEmail or MatchFuzzy, or empty when unmatched.
+	Method string
+	// Items is the number of work items selected by the pair's query assigned to the identity.
+	Items int
+}
+
+// ScanAssignees lists the assignees of the work items selected by the pair's query and the Asana user
+// each is matched to, ordered by unique name.
+func (e *Engine) ScanAssignees(ctx context.Context) ([]AssigneeMatch, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.prepare(ctx); err != nil {
+		return nil, err
+	}
+	ids, err := e.selectIDs(ctx, e.cfg.ADOProject, e.cfg.WIQL(), 0)
+	if err != nil {
+		return nil, fmt.Errorf("querying work items: %w", err)
+	}
+	found := map[string]*AssigneeMatch{}
+	for start := 0; start < len(ids); start += pageSize {
+		end := start + pageSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		items, err := e.ado.GetWorkItems(ctx, ids[start:end])
+		if err != nil {
+			return nil, fmt.Errorf("fetching work items: %w", err)
+		}
+		for _, item := range items {
+			id := item.AssignedTo()
+			if id == nil {
+				continue
+			}
+			key := strings.ToLower(id.UniqueName)
+			if found[key] == nil {
+				u, method := e.users.match(id)
+				found[key] = &AssigneeMatch{Identity: *id, User: u, Method: method}
+			}
+			found[key].Items++
+		}
+	}
+	matches := make([]AssigneeMatch, 0, len(found))
+	for _, m := range found {
+		matches = append(matches, *m)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return strings.ToLower(matches[i].Identity.UniqueName) < strings.ToLower(matches[j].Identity.UniqueName)
+	})
+	return matches, nil
+}
@@ -0,0 +1,66 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/ratelimit"
+	"github.com/danstis/ado-asana-sync/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultWarmUpRate is the number of requests a second the warm-up after a start sends across both APIs.
+const DefaultWarmUpRate = 5
+
+// WarmUp readies the pair for its first cycle after a start, so the cycle finds the reference data cached
+// instead of listing it all at once: it validates the configuration, which resolves the custom fields,
+// sections and tags of its projects through the cache of the Asana client, lists the Asana users, the
+// workspace tags and the iteration dates, and reads the watermark of incremental sync. The requests are
+// spaced by p, so a restart does not trip the rate limits of either API; a nil p does not space them.
+func (e *Engine) WarmUp(ctx context.Context, p *ratelimit.Pace) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ctx = ratelimit.WithPace(e.begin(ctx), p)
+	ctx, span := tracing.Tracer().Start(ctx, "sync.warmup", trace.WithAttributes(attribute.String("sync.pair", e.cfg.Name)))
+	err := e.warmUp(ctx)
+	tracing.End(span, err)
+	return err
+}
+
+func (e *Engine) warmUp(ctx context.Context) error {
+	start := time.Now()
+	if err := e.prepare(ctx); err != nil {
+		return err
+	}
+	if e.cfg.Tags.Direction != "" {
+		if err := e.tags.warm(ctx, e.asana, e.cfg.AsanaWorkspace); err != nil {
+			return err
+		}
+	}
+	since, full, err := e.scope(ctx)
+	if err != nil {
+		return fmt.Errorf("reading sync watermark: %w", err)
+	}
+	attrs := []interface{}{"elapsed", time.Since(start).Round(time.Millisecond), "full", full}
+	if !full {
+		attrs = append(attrs, "since", since.Format(time.RFC3339))
+	}
+	logging.From(ctx).Info("warmed up for the first cycle", attrs...)
+	return nil
+}
+
+// WarmUp warms up every pair one after the other, spacing the requests of all of them at perSecond
+// requests a second, or as fast as the limiters allow when it is zero or less.
+func (m *Manager) WarmUp(ctx context.Context, perSecond float64) error {
+	p := ratelimit.NewPace(perSecond)
+	for _, e := range m.engines {
+		if err := e.WarmUp(ctx, p); err != nil {
+			return fmt.Errorf("sync pair %q: %w", e.Name(), err)
+		}
+	}
+	return nil
+}
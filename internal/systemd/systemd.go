@@ -0,0 +1,127 @@
+// Package systemd integrates the daemon with systemd: it notifies the service manager of readiness and
+// pings its watchdog through the sd_notify protocol, and generates the unit file the daemon is installed
+// with.
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// States sent to the service manager.
+const (
+	Ready     = "READY=1"
+	Reloading = "RELOADING=1"
+	Stopping  = "STOPPING=1"
+	Watchdog  = "WATCHDOG=1"
+)
+
+// Notify sends state to the service manager through the socket named by NOTIFY_SOCKET. It reports false,
+// without error, when the process is not run by a service manager listening for notifications.
+func Notify(state string) (bool, error) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return false, nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("systemd: connecting to the notify socket: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, fmt.Errorf("systemd: notifying %s: %w", state, err)
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns the interval the service manager expects watchdog pings within, from
+// WATCHDOG_USEC, or zero when the watchdog is disabled or set up for another process.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// RunWatchdog pings the watchdog at half the interval until ctx is done, as long as alive reports the
+// process healthy. A process that stops being alive misses its pings, so the service manager restarts it.
+func RunWatchdog(ctx context.Context, interval time.Duration, alive func() bool) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !alive() {
+			slog.Warn("not pinging the systemd watchdog, the sync loop is not live")
+			continue
+		}
+		if _, err := Notify(Watchdog); err != nil {
+			slog.Warn("failed to ping the systemd watchdog", "error", err)
+		}
+	}
+}
+
+// UnitConfig describes the unit file of the daemon.
+type UnitConfig struct {
+	// Description is that of the unit.
+	Description string
+	// Exec is the command line the daemon is started with.
+	Exec []string
+	// User runs the daemon as this user instead of root, when set.
+	User string
+	// EnvironmentFile is read for the environment of the daemon, when set.
+	EnvironmentFile string
+	// Watchdog is the interval the daemon must ping the watchdog within, which is disabled when zero.
+	Watchdog time.Duration
+}
+
+// Unit returns the unit file of a notify service running the daemon, restarted when it fails.
+func Unit(c UnitConfig) string {
+	var b strings.Builder
+	b.WriteString("[Unit]\n")
+	fmt.Fprintf(&b, "Description=%s\n", c.Description)
+	b.WriteString("Wants=network-online.target\nAfter=network-online.target\n\n")
+	b.WriteString("[Service]\nType=notify\n")
+	exec := make([]string, len(c.Exec))
+	for i, a := range c.Exec {
+		exec[i] = quote(a)
+	}
+	fmt.Fprintf(&b, "ExecStart=%s\n", strings.Join(exec, " "))
+	b.WriteString("ExecReload=/bin/kill -HUP $MAINPID\n")
+	if c.User != "" {
+		fmt.Fprintf(&b, "User=%s\n", c.User)
+	}
+	if c.EnvironmentFile != "" {
+		fmt.Fprintf(&b, "EnvironmentFile=%s\n", c.EnvironmentFile)
+	}
+	if c.Watchdog > 0 {
+		fmt.Fprintf(&b, "WatchdogSec=%d\n", int(c.Watchdog.Round(time.Second)/time.Second))
+	}
+	b.WriteString("Restart=on-failure\nRestartSec=10\n")
+	// Stopping waits for the work items in flight, which get SHUTDOWN_TIMEOUT to finish.
+	b.WriteString("TimeoutStopSec=60\n\n")
+	b.WriteString("[Install]\nWantedBy=multi-user.target\n")
+	return b.String()
+}
+
+// quote quotes a word of a unit file when it holds characters systemd would split or expand.
+func quote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\$%;") {
+		return s
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", "$$", "%", "%%")
+	return `"` + r.Replace(s) + `"`
+}
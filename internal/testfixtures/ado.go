@@ -0,0 +1,832 @@
+package testfixtures
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+)
+
+// commentsPage is the number of comments the fake ADO returns per page, kept small so every client pages.
+const commentsPage = 2
+
+// ADO is a fake Azure DevOps organization holding the work items of a single project. It serves the
+// endpoints of the work item tracking API used by the sync engine: WIQL queries, work item reads and
+// updates, comments, attachments, work item types, boards and the outcomes of test runs.
+type ADO struct {
+	// Project is the name of the project every work item belongs to.
+	Project string
+
+	server *httptest.Server
+	limit  throttle
+
+	mu       sync.Mutex
+	items    map[int]*ado.WorkItem
+	comments map[int][]ado.Comment
+	types    []ado.WorkItemType
+	// boards holds the boards of the teams of the project, keyed by lower case team and board name.
+	boards   map[string]ado.Board
+	nextID   int
+	nextNote int
+	// races holds the edits applied to work items when they are next patched, keyed by ID.
+	races map[int]map[string]interface{}
+	// batches counts the $batch requests served.
+	batches int
+	// read counts the work items served by list reads and queries the WIQL queries run, and onRead is
+	// called with them before each read.
+	read    int
+	queries int
+	onRead  func(read, queries int)
+	// queryLimit is the most work items a query without $top may select, ado.MaxQueryResults unless set by
+	// LimitQueries.
+	queryLimit int
+	// points holds the test points of test cases, and results the results of their runs by run ID.
+	points  []ado.TestPoint
+	results map[int]map[string]interface{}
+	// sprints holds the iteration nodes below the root iteration of the project.
+	sprints []map[string]interface{}
+	// fields holds the custom fields added to the process, and picklists the values of their picklists by ID.
+	fields    []ado.Field
+	picklists map[string][]string
+	// files holds the uploaded attachments by ID, whether they are linked to a work item or not.
+	files map[string]adoFile
+}
+
+// adoFile is an attachment uploaded to the fake.
+type adoFile struct {
+	name string
+	data []byte
+}
+
+// standardFields are the fields of the project's process that every work item type has.
+var standardFields = []ado.Field{
+	{ReferenceName: ado.FieldTitle, Name: "Title", Type: ado.FieldTypeString},
+	{ReferenceName: ado.FieldState, Name: "State", Type: ado.FieldTypeString},
+	{ReferenceName: ado.FieldWorkItemType, Name: "Work Item Type", Type: ado.FieldTypeString, ReadOnly: true},
+	{ReferenceName: ado.FieldAssignedTo, Name: "Assigned To", Type: ado.FieldTypeIdentity},
+	{ReferenceName: ado.FieldTags, Name: "Tags", Type: ado.FieldTypePlainText},
+	{ReferenceName: ado.FieldAreaPath, Name: "Area Path", Type: ado.FieldTypeTreePath},
+	{ReferenceName: ado.FieldIterationPath, Name: "Iteration Path", Type: ado.FieldTypeTreePath},
+	{ReferenceName: ado.FieldChangedDate, Name: "Changed Date", Type: ado.FieldTypeDateTime, ReadOnly: true},
+	{ReferenceName: ado.FieldCreatedDate, Name: "Created Date", Type: ado.FieldTypeDateTime, ReadOnly: true},
+	{ReferenceName: ado.FieldTargetDate, Name: "Target Date", Type: ado.FieldTypeDateTime},
+	{ReferenceName: ado.FieldDescription, Name: "Description", Type: ado.FieldTypeHTML},
+	{ReferenceName: "Microsoft.VSTS.Common.Priority", Name: "Priority", Type: ado.FieldTypeInteger},
+	{ReferenceName: "Microsoft.VSTS.Common.Severity", Name: "Severity", Type: ado.FieldTypeString},
+	{ReferenceName: ado.FieldStoryPoints, Name: "Story Points", Type: ado.FieldTypeDouble},
+	{ReferenceName: ado.FieldOriginalEstimate, Name: "Original Estimate", Type: ado.FieldTypeDouble},
+	{ReferenceName: ado.FieldRemainingWork, Name: "Remaining Work", Type: ado.FieldTypeDouble},
+	{ReferenceName: ado.FieldCompletedWork, Name: "Completed Work", Type: ado.FieldTypeDouble},
+}
+
+// NewADO starts a fake ADO organization holding the project. Close stops it.
+func NewADO(project string) *ADO {
+	f := &ADO{
+		Project:    project,
+		items:      map[int]*ado.WorkItem{},
+		comments:   map[int][]ado.Comment{},
+		files:      map[string]adoFile{},
+		boards:     map[string]ado.Board{},
+		nextID:     1,
+		nextNote:   1,
+		queryLimit: ado.MaxQueryResults,
+		types: []ado.WorkItemType{
+			{Name: "Task", States: []ado.WorkItemState{{Name: "New", Category: "Proposed"}, {Name: "Active", Category: "InProgress"}, {Name: "Closed", Category: "Completed"}}},
+			{Name: "Bug", States: []ado.WorkItemState{{Name: "New", Category: "Proposed"}, {Name: "Active", Category: "InProgress"}, {Name: "Resolved", Category: "Resolved"}, {Name: "Closed", Category: "Completed"}}},
+		},
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+// URL returns the organization URL of the fake.
+func (f *ADO) URL() string { return f.server.URL }
+
+// Close stops the fake.
+func (f *ADO) Close() { f.server.Close() }
+
+// RateLimit makes every n-th request fail with 429 Too Many Requests, asking to retry after retryAfter
+// seconds. A negative retryAfter omits the header, and n of zero stops the rate limiting.
+func (f *ADO) RateLimit(n, retryAfter int) { f.limit.set(n, retryAfter) }
+
+// Requests returns the number of requests the fake received and of those it rate limited.
+func (f *ADO) Requests() (requests, limited int) { return f.limit.counts() }
+
+// Add creates a work item of the given type with fields, which may override the defaults of the title,
+// state and project, and returns its ID.
+func (f *ADO) Add(typ, title string, fields map[string]interface{}) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.add(typ, title, fields).ID
+}
+
+// add creates a work item as Add does. The caller must hold f.mu.
+func (f *ADO) add(typ, title string, fields map[string]interface{}) *ado.WorkItem {
+	id := f.nextID
+	f.nextID++
+	wi := &ado.WorkItem{
+		ID:  id,
+		URL: fmt.Sprintf("%s/%s/_apis/wit/workItems/%d", f.server.URL, f.Project, id),
+		Fields: map[string]interface{}{
+			ado.FieldID:            id,
+			ado.FieldTitle:         title,
+			ado.FieldWorkItemType:  typ,
+			ado.FieldState:         "New",
+			ado.FieldTeamProject:   f.Project,
+			ado.FieldAreaPath:      f.Project,
+			ado.FieldIterationPath: f.Project,
+		},
+	}
+	for k, v := range fields {
+		wi.Fields[k] = v
+	}
+	f.items[id] = wi
+	f.touch(wi)
+	return wi
+}
+
+// AddSprint adds an iteration below the root iteration of the project running from start to finish, and
+// returns its path.
+func (f *ADO) AddSprint(name string, start, finish time.Time) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sprints = append(f.sprints, map[string]interface{}{"name": name,
+		"attributes": map[string]string{"startDate": start.UTC().Format(time.RFC3339), "finishDate": finish.UTC().Format(time.RFC3339)}})
+	return f.Project + `\` + name
+}
+
+// Assignee returns the value of System.AssignedTo for the user with the given email.
+func Assignee(name, email string) map[string]interface{} {
+	return map[string]interface{}{"displayName": name, "uniqueName": email}
+}
+
+// AddField adds a custom field to the process of the project. Passing values makes it a picklist of them.
+func (f *ADO) AddField(field ado.Field, values ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(values) > 0 {
+		if f.picklists == nil {
+			f.picklists = map[string][]string{}
+		}
+		field.IsPicklist, field.PicklistID = true, fmt.Sprintf("picklist-%d", len(f.picklists)+1)
+		f.picklists[field.PicklistID] = values
+	}
+	f.fields = append(f.fields, field)
+}
+
+// processFields returns the standard fields, the custom fields added, and the other fields work items
+// hold, typed by their values.
+func (f *ADO) processFields() []ado.Field {
+	fields := append(append([]ado.Field(nil), standardFields...), f.fields...)
+	known := map[string]bool{}
+	for _, fd := range fields {
+		known[fd.ReferenceName] = true
+	}
+	var extra []string
+	for _, wi := range f.items {
+		for name := range wi.Fields {
+			if !known[name] {
+				known[name] = true
+				extra = append(extra, name)
+			}
+		}
+	}
+	sort.Strings(extra)
+	for _, name := range extra {
+		typ := ado.FieldTypeString
+		for _, wi := range f.items {
+			switch wi.Fields[name].(type) {
+			case float64:
+				typ = ado.FieldTypeDouble
+			case bool:
+				typ = ado.FieldTypeBoolean
+			case map[string]interface{}:
+				typ = ado.FieldTypeIdentity
+			}
+		}
+		fields = append(fields, ado.Field{ReferenceName: name, Name: name, Type: typ})
+	}
+	return fields
+}
+
+// AddBoard adds the board of a team with the given columns and lanes, and returns it. The default lane is
+// added before the named ones.
+func (f *ADO) AddBoard(team, name string, columns, lanes []string) ado.Board {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := len(f.boards) + 1
+	b := ado.Board{ID: fmt.Sprintf("board-%d", n), Name: name, Rows: []ado.BoardRow{{ID: fmt.Sprintf("lane-%d-0", n)}}}
+	for i, c := range columns {
+		b.Columns = append(b.Columns, ado.BoardColumn{ID: fmt.Sprintf("column-%d-%d", n, i+1), Name: c})
+	}
+	for i, l := range lanes {
+		b.Rows = append(b.Rows, ado.BoardRow{ID: fmt.Sprintf("lane-%d-%d", n, i+1), Name: l})
+	}
+	prefix := fmt.Sprintf("WEF_%08d", n)
+	b.Fields.ColumnField.ReferenceName = prefix + "_Kanban.Column"
+	b.Fields.RowField.ReferenceName = prefix + "_Kanban.Lane"
+	b.Fields.DoneField.ReferenceName = prefix + "_Kanban.Column.Done"
+	f.boards[strings.ToLower(team+"/"+name)] = b
+	return b
+}
+
+// Move moves the work item to the column and lane of board b, as dragging its card does. An empty lane is
+// the default lane.
+func (f *ADO) Move(id int, b ado.Board, column, lane string) {
+	var l interface{}
+	if lane != "" {
+		l = lane
+	}
+	f.Update(id, map[string]interface{}{b.Fields.ColumnField.ReferenceName: column, b.Fields.RowField.ReferenceName: l})
+}
+
+// Update sets fields of the work item as an ADO user would. A nil value removes the field.
+func (f *ADO) Update(id int, fields map[string]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	wi, ok := f.items[id]
+	if !ok {
+		panic(fmt.Sprintf("testfixtures: no work item %d", id))
+	}
+	for k, v := range fields {
+		if v == nil {
+			delete(wi.Fields, k)
+		} else {
+			wi.Fields[k] = v
+		}
+	}
+	f.touch(wi)
+}
+
+// Link adds a relation of type rel from the work item to the work item target.
+func (f *ADO) Link(id int, rel string, target int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	wi := f.items[id]
+	wi.Relations = append(wi.Relations, ado.Relation{Rel: rel, URL: f.items[target].URL})
+	f.touch(wi)
+}
+
+// Attach uploads data as a file named name and links it to the work item, as a user attaching a file would,
+// and returns the URL of the attachment.
+func (f *ADO) Attach(id int, name string, data []byte) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u := f.upload(name, data)
+	wi := f.items[id]
+	wi.Relations = append(wi.Relations, ado.Relation{
+		Rel:        ado.RelAttachedFile,
+		URL:        u,
+		Attributes: map[string]interface{}{"name": name, "resourceSize": float64(len(data))},
+	})
+	f.touch(wi)
+	return u
+}
+
+// Files returns the contents of the files attached to the work item by name.
+func (f *ADO) Files(id int) map[string][]byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	files := map[string][]byte{}
+	for _, rel := range f.items[id].Attachments() {
+		if file, ok := f.files[path.Base(rel.URL)]; ok {
+			files[file.name] = file.data
+		}
+	}
+	return files
+}
+
+// upload stores an attachment and returns its URL. The caller must hold f.mu.
+func (f *ADO) upload(name string, data []byte) string {
+	id := fmt.Sprintf("file-%d", len(f.files)+1)
+	f.files[id] = adoFile{name: name, data: append([]byte(nil), data...)}
+	return f.server.URL + "/_apis/wit/attachments/" + id
+}
+
+// TestRun records a run of the test case in a test plan with the outcome of the test case and those of its steps
+// by step ID, which the test point of the case reports as its latest run.
+func (f *ADO) TestRun(testCase int, outcome string, steps map[int]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.results == nil {
+		f.results = map[int]map[string]interface{}{}
+	}
+	run := len(f.results) + 1
+	actions := make([]map[string]string, 0, len(steps))
+	for id, o := range steps {
+		actions = append(actions, map[string]string{"actionPath": fmt.Sprintf("%08x", id), "outcome": o})
+	}
+	f.results[run] = map[string]interface{}{"id": 100000, "outcome": outcome,
+		"iterationDetails": []map[string]interface{}{{"id": 1, "actionResults": actions}}}
+	for i := range f.points {
+		if f.points[i].TestCaseID() == testCase {
+			f.points = append(f.points[:i], f.points[i+1:]...)
+			break
+		}
+	}
+	p := ado.TestPoint{ID: run, Outcome: outcome, LastUpdatedDate: time.Now().UTC()}
+	p.TestCase.ID, p.TestPlan.ID = strconv.Itoa(testCase), "1"
+	p.LastTestRun.ID, p.LastResult.ID = strconv.Itoa(run), "100000"
+	f.points = append(f.points, p)
+}
+
+// Delete deletes the work item.
+func (f *ADO) Delete(id int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.items, id)
+}
+
+// Item returns a copy of the work item, or false when there is none.
+func (f *ADO) Item(id int) (ado.WorkItem, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	wi, ok := f.items[id]
+	if !ok {
+		return ado.WorkItem{}, false
+	}
+	return copyItem(wi), true
+}
+
+// Items returns copies of the work items, in the order they were created.
+func (f *ADO) Items() []ado.WorkItem {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids := make([]int, 0, len(f.items))
+	for id := range f.items {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	items := make([]ado.WorkItem, 0, len(ids))
+	for _, id := range ids {
+		items = append(items, copyItem(f.items[id]))
+	}
+	return items
+}
+
+// Comment adds a comment to the work item as an ADO user would.
+func (f *ADO) Comment(id int, author, text string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.addComment(id, ado.Identity{DisplayName: author, UniqueName: author}, text)
+}
+
+// Comments returns the comments on the work item, oldest first.
+func (f *ADO) Comments(id int) []ado.Comment {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]ado.Comment(nil), f.comments[id]...)
+}
+
+// addComment adds a comment. The caller must hold f.mu.
+func (f *ADO) addComment(id int, by ado.Identity, text string) ado.Comment {
+	c := ado.Comment{ID: f.nextNote, Text: text, CreatedBy: by, CreatedDate: time.Now().UTC()}
+	f.nextNote++
+	f.comments[id] = append(f.comments[id], c)
+	return c
+}
+
+// touch bumps the revision and changed date of the work item. The caller must hold f.mu.
+// Race makes the next patch of the work item find it changed by fields, as an edit made while the engine
+// syncs it would.
+func (f *ADO) Race(id int, fields map[string]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.races == nil {
+		f.races = map[int]map[string]interface{}{}
+	}
+	f.races[id] = fields
+}
+
+func (f *ADO) touch(wi *ado.WorkItem) {
+	wi.Rev++
+	wi.Fields[ado.FieldChangedDate] = time.Now().UTC().Format(time.RFC3339Nano)
+}
+
+// copyItem returns a deep enough copy of wi to be handed out without sharing the maps of the fake.
+func copyItem(wi *ado.WorkItem) ado.WorkItem {
+	c := *wi
+	c.Fields = make(map[string]interface{}, len(wi.Fields))
+	for k, v := range wi.Fields {
+		c.Fields[k] = v
+	}
+	c.Relations = append([]ado.Relation(nil), wi.Relations...)
+	return c
+}
+
+var (
+	adoItemPath     = regexp.MustCompile(`^/_apis/wit/workitems/(\d+)$`)
+	adoCommentsPath = regexp.MustCompile(`^/[^/]+/_apis/wit/workItems/(\d+)/comments$`)
+	adoBoardPath    = regexp.MustCompile(`^/[^/]+/([^/]+)/_apis/work/boards/([^/]+)$`)
+	adoResultPath   = regexp.MustCompile(`^/[^/]+/_apis/test/Runs/(\d+)/Results/(\d+)$`)
+	// wiqlChangedSince matches the condition added to the queries of incremental cycles.
+	wiqlChangedSince = regexp.MustCompile(`\[System\.ChangedDate\] >= '([^']+)'`)
+	// wiqlAssignedIn matches the condition selecting the items of the users mirrored into My Tasks.
+	wiqlAssignedIn = regexp.MustCompile(`\[System\.AssignedTo\] IN \(([^)]*)\)`)
+	// wiqlEquals matches the conditions requiring a field to hold a value, such as those of priority boosts.
+	wiqlEquals = regexp.MustCompile(`\[([\w.]+)\] = '([^']*)'`)
+	// wiqlAfterID matches the condition of the queries reading a selection a page at a time.
+	wiqlAfterID = regexp.MustCompile(`\[System\.Id\] > (\d+)`)
+)
+
+func (f *ADO) serve(w http.ResponseWriter, r *http.Request) {
+	if f.limit.refuse(w) {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Path == "/_apis/wit/$batch" && r.Method == http.MethodPost {
+		f.batchRequests(w, r)
+		return
+	}
+	f.route(w, r)
+}
+
+// Queries returns the number of WIQL queries run.
+func (f *ADO) Queries() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.queries
+}
+
+// Batches returns the number of $batch requests served.
+func (f *ADO) Batches() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.batches
+}
+
+// batchRequests runs the requests of a $batch request one after the other, returning their bodies as strings.
+func (f *ADO) batchRequests(w http.ResponseWriter, r *http.Request) {
+	var reqs []ado.BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+		adoError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(reqs) > ado.MaxBatchRequests {
+		adoError(w, http.StatusBadRequest, "too many requests")
+		return
+	}
+	f.batches++
+	responses := make([]ado.BatchResponse, 0, len(reqs))
+	for _, req := range reqs {
+		body, _ := json.Marshal(req.Body)
+		hr := httptest.NewRequest(req.Method, req.URI, bytes.NewReader(body))
+		for k, v := range req.Headers {
+			hr.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		f.route(rec, hr)
+		responses = append(responses, ado.BatchResponse{Code: rec.Code, Body: rec.Body.String()})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"count": len(responses), "value": responses})
+}
+
+// route serves a single request. The caller must hold f.mu.
+func (f *ADO) route(w http.ResponseWriter, r *http.Request) {
+	project := "/" + f.Project
+	switch p := r.URL.Path; {
+	case p == "/_apis/projects":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"value": []map[string]string{{"name": f.Project}}})
+	case p == project+"/_apis/wit/wiql" && r.Method == http.MethodPost:
+		f.query(w, r)
+	case p == project+"/_apis/wit/classificationnodes/Iterations":
+		// The root iteration of the project has no dates.
+		writeJSON(w, http.StatusOK, map[string]interface{}{"name": f.Project, "children": f.sprints})
+	case p == project+"/_apis/wit/classificationnodes/Areas":
+		// The project has no areas below its root.
+		writeJSON(w, http.StatusOK, map[string]interface{}{"name": f.Project})
+	case p == project+"/_apis/git/repositories":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"value": []ado.Repository{{ID: "1", Name: f.Project}}})
+	case p == project+"/_apis/wit/fields":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"value": f.processFields()})
+	case strings.HasPrefix(p, "/_apis/work/processes/lists/"):
+		values, ok := f.picklists[strings.TrimPrefix(p, "/_apis/work/processes/lists/")]
+		if !ok {
+			adoError(w, http.StatusNotFound, "no picklist "+p)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"items": values})
+	case p == project+"/_apis/wit/workitemtypes":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"value": f.types})
+	case p == "/_apis/wit/workitems":
+		f.batch(w, r)
+	case p == project+"/_apis/wit/attachments" && r.Method == http.MethodPost:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			adoError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		u := f.upload(r.URL.Query().Get("fileName"), data)
+		writeJSON(w, http.StatusOK, map[string]string{"id": path.Base(u), "url": u})
+	case strings.HasPrefix(p, "/_apis/wit/attachments/") && r.Method == http.MethodGet:
+		file, ok := f.files[strings.TrimPrefix(p, "/_apis/wit/attachments/")]
+		if !ok {
+			adoError(w, http.StatusNotFound, "no attachment "+p)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write(file.data)
+	case p == project+"/_apis/test/points" && r.Method == http.MethodPost:
+		f.testPoints(w, r)
+	case adoResultPath.MatchString(p) && strings.HasPrefix(p, project+"/"):
+		run, _ := strconv.Atoi(adoResultPath.FindStringSubmatch(p)[1])
+		res, ok := f.results[run]
+		if !ok {
+			adoError(w, http.StatusNotFound, fmt.Sprintf("test run %d does not exist", run))
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	case strings.HasPrefix(p, project+"/_apis/wit/workitems/$") && r.Method == http.MethodPost:
+		f.create(w, r, strings.TrimPrefix(p, project+"/_apis/wit/workitems/$"))
+	case adoItemPath.MatchString(p):
+		id, _ := strconv.Atoi(adoItemPath.FindStringSubmatch(p)[1])
+		f.item(w, r, id)
+	case adoCommentsPath.MatchString(p) && strings.HasPrefix(p, project+"/"):
+		id, _ := strconv.Atoi(adoCommentsPath.FindStringSubmatch(p)[1])
+		f.commentsOf(w, r, id)
+	case adoBoardPath.MatchString(p) && strings.HasPrefix(p, project+"/") && r.Method == http.MethodGet:
+		m := adoBoardPath.FindStringSubmatch(p)
+		b, ok := f.boards[strings.ToLower(m[1]+"/"+m[2])]
+		if !ok {
+			adoError(w, http.StatusNotFound, fmt.Sprintf("team %s has no board %s", m[1], m[2]))
+			return
+		}
+		writeJSON(w, http.StatusOK, b)
+	default:
+		adoError(w, http.StatusNotFound, "no route for "+r.Method+" "+p)
+	}
+}
+
+// query runs a WIQL query. Only the conditions the sync engine generates are understood: items must be
+// assigned when the query requires it, to one of the users of an AssignedTo IN condition, hold the value of
+// each equality condition, be changed since the time of a ChangedDate condition and have an ID above that of
+// an Id condition. Items are listed by ID whatever the order of the query. Like ADO, a query selecting more
+// than the query limit fails unless $top limits its results.
+func (f *ADO) query(w http.ResponseWriter, r *http.Request) {
+	f.queries++
+	var body struct {
+		Query string `json:"query"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		adoError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var since time.Time
+	if m := wiqlChangedSince.FindStringSubmatch(body.Query); m != nil {
+		t, err := time.Parse(time.RFC3339, m[1])
+		if err != nil {
+			adoError(w, http.StatusBadRequest, "invalid date "+m[1])
+			return
+		}
+		since = t
+	}
+	assigned := strings.Contains(body.Query, "[System.AssignedTo] <> ''")
+	var assignees map[string]bool
+	if m := wiqlAssignedIn.FindStringSubmatch(body.Query); m != nil {
+		assignees = map[string]bool{}
+		for _, u := range strings.Split(m[1], ",") {
+			assignees[strings.ToLower(strings.Trim(strings.TrimSpace(u), "'"))] = true
+		}
+	}
+	equals := wiqlEquals.FindAllStringSubmatch(body.Query, -1)
+	after := 0
+	if m := wiqlAfterID.FindStringSubmatch(body.Query); m != nil {
+		after, _ = strconv.Atoi(m[1])
+	}
+	ids := make([]int, 0, len(f.items))
+	for id, wi := range f.items {
+		if id <= after {
+			continue
+		}
+		if assigned && wi.AssignedTo() == nil {
+			continue
+		}
+		if assignees != nil && (wi.AssignedTo() == nil || !assignees[strings.ToLower(wi.AssignedTo().UniqueName)]) {
+			continue
+		}
+		if wi.ChangedDate().Before(since) || !matchesEquals(wi, equals) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	switch top, _ := strconv.Atoi(r.URL.Query().Get("$top")); {
+	case top > 0 && top < len(ids):
+		ids = ids[:top]
+	case top <= 0 && len(ids) > f.queryLimit:
+		adoError(w, http.StatusBadRequest, fmt.Sprintf("VS402337: The number of work items returned exceeds the size limit of %d.", f.queryLimit))
+		return
+	}
+	refs := make([]map[string]int, 0, len(ids))
+	for _, id := range ids {
+		refs = append(refs, map[string]int{"id": id})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"workItems": refs})
+}
+
+// matchesEquals reports whether wi holds the values of the equality conditions of a query.
+func matchesEquals(wi *ado.WorkItem, equals [][]string) bool {
+	for _, m := range equals {
+		if !strings.EqualFold(wi.String(m[1]), m[2]) {
+			return false
+		}
+	}
+	return true
+}
+
+// LimitQueries sets the most work items a query without $top may select to n, standing in for the
+// ado.MaxQueryResults of ADO.
+func (f *ADO) LimitQueries(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queryLimit = n
+}
+
+// OnRead sets fn to be called before each read of a list of work items with the number of work items read
+// and of queries run so far. It is called while the fake is locked, so it must not call it.
+func (f *ADO) OnRead(fn func(read, queries int)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.onRead = fn
+}
+
+// batch returns the work items of the ids parameter, with null entries for missing ones as errorPolicy=omit
+// does.
+func (f *ADO) batch(w http.ResponseWriter, r *http.Request) {
+	if f.onRead != nil {
+		f.onRead(f.read, f.queries)
+	}
+	var items []*ado.WorkItem
+	for _, s := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			adoError(w, http.StatusBadRequest, "invalid id "+s)
+			return
+		}
+		if wi, ok := f.items[id]; ok {
+			c := copyItem(wi)
+			items = append(items, &c)
+			f.read++
+		} else {
+			items = append(items, nil)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"count": len(items), "value": items})
+}
+
+// testPoints returns the test points of the test cases of the points filter.
+func (f *ADO) testPoints(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		PointsFilter struct {
+			TestcaseIDs []int `json:"testcaseIds"`
+		} `json:"pointsFilter"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		adoError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	points := []ado.TestPoint{}
+	for _, p := range f.points {
+		for _, id := range body.PointsFilter.TestcaseIDs {
+			if p.TestCaseID() == id {
+				points = append(points, p)
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"points": points})
+}
+
+// create creates a work item of type typ with the fields set by the patch operations of the request.
+func (f *ADO) create(w http.ResponseWriter, r *http.Request, typ string) {
+	var ops []ado.PatchOperation
+	if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+		adoError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	fields := map[string]interface{}{}
+	for _, op := range ops {
+		if op.Op == "add" && strings.HasPrefix(op.Path, "/fields/") {
+			fields[strings.TrimPrefix(op.Path, "/fields/")] = op.Value
+		}
+	}
+	if email, ok := fields[ado.FieldAssignedTo].(string); ok {
+		// ADO resolves the email of an assignee to their identity.
+		fields[ado.FieldAssignedTo] = Assignee(email, email)
+	}
+	title, _ := fields[ado.FieldTitle].(string)
+	if title == "" {
+		adoError(w, http.StatusBadRequest, "work items need a title")
+		return
+	}
+	wi := f.add(typ, title, fields)
+	writeJSON(w, http.StatusOK, copyItem(wi))
+}
+
+// item reads or patches a single work item.
+func (f *ADO) item(w http.ResponseWriter, r *http.Request, id int) {
+	wi, ok := f.items[id]
+	if !ok {
+		adoError(w, http.StatusNotFound, fmt.Sprintf("work item %d does not exist", id))
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPatch:
+		var ops []ado.PatchOperation
+		if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+			adoError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if race, ok := f.races[id]; ok {
+			delete(f.races, id)
+			for k, v := range race {
+				wi.Fields[k] = v
+			}
+			f.touch(wi)
+		}
+		for _, op := range ops {
+			if rev, ok := op.Value.(float64); ok && op.Op == "test" && op.Path == "/rev" && int(rev) != wi.Rev {
+				adoError(w, http.StatusPreconditionFailed, fmt.Sprintf("work item %d is at revision %d, not %d", id, wi.Rev, int(rev)))
+				return
+			}
+		}
+		if r.URL.Query().Get("validateOnly") == "true" {
+			break
+		}
+		for _, op := range ops {
+			switch {
+			case op.Op == "test":
+			case strings.HasPrefix(op.Path, "/fields/") && (op.Op == "add" || op.Op == "replace"):
+				wi.Fields[strings.TrimPrefix(op.Path, "/fields/")] = op.Value
+			case strings.HasPrefix(op.Path, "/fields/") && op.Op == "remove":
+				delete(wi.Fields, strings.TrimPrefix(op.Path, "/fields/"))
+			case op.Path == "/relations/-" && op.Op == "add":
+				b, _ := json.Marshal(op.Value)
+				var rel ado.Relation
+				_ = json.Unmarshal(b, &rel)
+				wi.Relations = append(wi.Relations, rel)
+			case strings.HasPrefix(op.Path, "/relations/") && op.Op == "remove":
+				i, err := strconv.Atoi(strings.TrimPrefix(op.Path, "/relations/"))
+				if err != nil || i < 0 || i >= len(wi.Relations) {
+					adoError(w, http.StatusBadRequest, "no relation at "+op.Path)
+					return
+				}
+				wi.Relations = append(wi.Relations[:i:i], wi.Relations[i+1:]...)
+			default:
+				adoError(w, http.StatusBadRequest, "unsupported patch operation "+op.Op+" "+op.Path)
+				return
+			}
+		}
+		f.touch(wi)
+	default:
+		adoError(w, http.StatusMethodNotAllowed, r.Method)
+		return
+	}
+	writeJSON(w, http.StatusOK, copyItem(wi))
+}
+
+// commentsOf lists the comments of a work item a page at a time, or adds one.
+func (f *ADO) commentsOf(w http.ResponseWriter, r *http.Request, id int) {
+	if _, ok := f.items[id]; !ok {
+		adoError(w, http.StatusNotFound, fmt.Sprintf("work item %d does not exist", id))
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		all := f.comments[id]
+		from, to, next := page(len(all), r.URL.Query().Get("continuationToken"), strconv.Itoa(commentsPage))
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"totalCount":        len(all),
+			"comments":          all[from:to],
+			"continuationToken": next,
+		})
+	case http.MethodPost:
+		var body struct {
+			Text string `json:"text"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			adoError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, f.addComment(id, ado.Identity{DisplayName: "Sync", UniqueName: "sync@example.com"}, body.Text))
+	default:
+		adoError(w, http.StatusMethodNotAllowed, r.Method)
+	}
+}
+
+// adoError writes an error response in the format of the ADO API.
+func adoError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"message": msg})
+}
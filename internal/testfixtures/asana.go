@@ -0,0 +1,1117 @@
+package testfixtures
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/asana"
+)
+
+// Asana is a fake Asana workspace. It serves the endpoints of the Asana API used by the sync engine, with
+// limit and offset paging on every list, and keeps the tasks, sections, tags, custom fields, stories and
+// attachments the engine reads and writes.
+type Asana struct {
+	// Workspace is the GID of the workspace.
+	Workspace string
+
+	server *httptest.Server
+	limit  throttle
+
+	mu       sync.Mutex
+	nextGID  int
+	me       asana.User
+	users    []asana.User
+	projects map[string]*fakeProject
+	tasks    map[string]*fakeTask
+	tags     []asana.Tag
+	stories  map[string][]asana.Story
+	// fields holds the custom fields created through the API, which belong to no project until added.
+	fields []asana.CustomField
+	// races holds the edits applied to tasks when they are next read on their own, keyed by GID.
+	races map[string]asana.TaskRequest
+	// noBatch rejects batch requests as an API without the batch endpoint would, and batches counts those
+	// served.
+	noBatch bool
+	batches int
+	// userLists counts the requests listing the users of the workspace.
+	userLists int
+	// statusUpdates holds the status updates posted on projects, oldest first.
+	statusUpdates []StatusUpdate
+	// files holds the attachments of tasks by task GID, oldest first.
+	files map[string][]asanaFile
+}
+
+// asanaFile is an attachment of a task of the fake.
+type asanaFile struct {
+	asana.Attachment
+	data []byte
+}
+
+// StatusUpdate is a status update posted on a project of the fake.
+type StatusUpdate struct {
+	GID string
+	asana.StatusUpdateRequest
+}
+
+type fakeProject struct {
+	asana.Project
+	sections []asana.Section
+	fields   []asana.CustomField
+	// members lists the GIDs of the users who are members of the project.
+	members []string
+}
+
+// fakeTask is a task with what the API derives its fields from.
+type fakeTask struct {
+	asana.Task
+	htmlNotes string
+	// projects lists the GIDs of the projects of the task, and sections the section of the task in each.
+	projects []string
+	sections map[string]string
+	// values holds the custom field values of the task, keyed by field GID.
+	values    map[string]interface{}
+	parent    string
+	deps      []string
+	tags      []string
+	followers []string
+}
+
+// NewAsana starts a fake Asana workspace whose access token belongs to a user named Sync. Close stops it.
+func NewAsana() *Asana {
+	f := &Asana{projects: map[string]*fakeProject{}, tasks: map[string]*fakeTask{}, stories: map[string][]asana.Story{}, files: map[string][]asanaFile{}, nextGID: 1000}
+	f.Workspace = f.gid()
+	f.me = asana.User{GID: f.gid(), Name: "Sync", Email: "sync@example.com"}
+	f.users = []asana.User{f.me}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+// URL returns the API base URL of the fake.
+func (f *Asana) URL() string { return f.server.URL }
+
+// Close stops the fake.
+func (f *Asana) Close() { f.server.Close() }
+
+// DisableBatch makes the fake reject batch requests as not found.
+func (f *Asana) DisableBatch() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.noBatch = true
+}
+
+// Batches returns the number of batch requests served.
+func (f *Asana) Batches() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.batches
+}
+
+// UserLists returns the number of requests that listed the users of the workspace.
+func (f *Asana) UserLists() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.userLists
+}
+
+// RateLimit makes every n-th request fail with 429 Too Many Requests, asking to retry after retryAfter
+// seconds. A negative retryAfter omits the header, and n of zero stops the rate limiting.
+func (f *Asana) RateLimit(n, retryAfter int) { f.limit.set(n, retryAfter) }
+
+// Requests returns the number of requests the fake received and of those it rate limited.
+func (f *Asana) Requests() (requests, limited int) { return f.limit.counts() }
+
+// gid returns a new GID. The caller must hold f.mu, or be the constructor.
+func (f *Asana) gid() string {
+	f.nextGID++
+	return strconv.Itoa(f.nextGID)
+}
+
+// AddUser adds a user to the workspace and returns its GID.
+func (f *Asana) AddUser(name, email string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u := asana.User{GID: f.gid(), Name: name, Email: email}
+	f.users = append(f.users, u)
+	return u.GID
+}
+
+// AddProject adds a project with the given sections to the workspace and returns its GID.
+func (f *Asana) AddProject(name string, sections ...string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p := &fakeProject{Project: asana.Project{GID: f.gid(), Name: name}, members: []string{f.me.GID}}
+	for _, s := range sections {
+		p.sections = append(p.sections, asana.Section{GID: f.gid(), Name: s})
+	}
+	f.projects[p.GID] = p
+	return p.GID
+}
+
+// AddMember makes the user a member of the project.
+func (f *Asana) AddMember(projectGID, userGID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if p := f.projects[projectGID]; !contains(p.members, userGID) {
+		p.members = append(p.members, userGID)
+	}
+}
+
+// Members returns the GIDs of the members of the project.
+func (f *Asana) Members(projectGID string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.projects[projectGID].members...)
+}
+
+// StatusUpdates returns the status updates on the project that were not deleted, oldest first.
+func (f *Asana) StatusUpdates(projectGID string) []StatusUpdate {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var updates []StatusUpdate
+	for _, u := range f.statusUpdates {
+		if u.Parent == projectGID {
+			updates = append(updates, u)
+		}
+	}
+	return updates
+}
+
+// AddCustomField adds a custom field of the given type to the project and returns its GID. Enum fields
+// are given the options.
+func (f *Asana) AddCustomField(projectGID, name, subtype string, options ...string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cf := asana.CustomField{GID: f.gid(), Name: name, ResourceSubtype: subtype}
+	for _, o := range options {
+		cf.EnumOptions = append(cf.EnumOptions, asana.EnumOption{GID: f.gid(), Name: o})
+	}
+	p := f.projects[projectGID]
+	p.fields = append(p.fields, cf)
+	return cf.GID
+}
+
+// Option returns the GID of the named option of the enum field, or an empty string when it has none.
+func (f *Asana) Option(fieldGID, name string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, p := range f.projects {
+		for _, cf := range p.fields {
+			if cf.GID != fieldGID {
+				continue
+			}
+			for _, o := range cf.EnumOptions {
+				if o.Name == name {
+					return o.GID
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// Names returns a name for the GID of every record of the workspace other than tasks and stories, such as
+// user:Alice, section:Fabrikam/Doing or option:Priority/High, so output referring to them can be compared
+// across fakes.
+func (f *Asana) Names() map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	names := map[string]string{f.Workspace: "workspace"}
+	for _, u := range f.users {
+		names[u.GID] = "user:" + u.Name
+	}
+	fields := append([]asana.CustomField(nil), f.fields...)
+	for _, p := range f.projects {
+		names[p.GID] = "project:" + p.Name
+		for _, s := range p.sections {
+			names[s.GID] = "section:" + p.Name + "/" + s.Name
+		}
+		fields = append(fields, p.fields...)
+	}
+	for _, cf := range fields {
+		names[cf.GID] = "field:" + cf.Name
+		for _, o := range cf.EnumOptions {
+			names[o.GID] = "option:" + cf.Name + "/" + o.Name
+		}
+	}
+	for _, tag := range f.tags {
+		names[tag.GID] = "tag:" + tag.Name
+	}
+	return names
+}
+
+// AddTask adds a task to the project as an Asana user would and returns its GID.
+func (f *Asana) AddTask(projectGID, name string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.create(asana.TaskRequest{Name: asana.String(name), Projects: []string{projectGID}}).GID
+}
+
+// create creates a task from req, whose projects must exist. The caller must hold f.mu.
+func (f *Asana) create(req asana.TaskRequest) *fakeTask {
+	t := &fakeTask{Task: asana.Task{GID: f.gid(), ResourceSubtype: asana.SubtypeDefault}, projects: req.Projects, sections: map[string]string{}, values: map[string]interface{}{}}
+	t.PermalinkURL = f.server.URL + "/0/0/" + t.GID
+	f.tasks[t.GID] = t
+	f.apply(t, req)
+	return t
+}
+
+// AssignedTasks returns the tasks assigned to the user that are in no project, as in their My Tasks, in the
+// order they were created.
+func (f *Asana) AssignedTasks(userGID string) []asana.Task {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var tasks []asana.Task
+	for _, t := range f.sorted() {
+		if len(t.projects) == 0 && t.Assignee != nil && t.Assignee.GID == userGID {
+			tasks = append(tasks, f.render(t))
+		}
+	}
+	return tasks
+}
+
+// Tasks returns the tasks in the project, in the order they were created.
+func (f *Asana) Tasks(projectGID string) []asana.Task {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var tasks []asana.Task
+	for _, t := range f.sorted() {
+		if contains(t.projects, projectGID) {
+			tasks = append(tasks, f.render(t))
+		}
+	}
+	return tasks
+}
+
+// Task returns the task, or false when there is none.
+func (f *Asana) Task(gid string) (asana.Task, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, ok := f.tasks[gid]
+	if !ok {
+		return asana.Task{}, false
+	}
+	return f.render(t), true
+}
+
+// HTMLNotes returns the rich text notes of the task.
+func (f *Asana) HTMLNotes(gid string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.tasks[gid].htmlNotes
+}
+
+// Update changes the task as an Asana user would.
+func (f *Asana) Update(gid string, req asana.TaskRequest) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.apply(f.tasks[gid], req)
+}
+
+// Race makes the next read of the task on its own find it changed by req, as an edit made while the engine
+// syncs it would.
+func (f *Asana) Race(gid string, req asana.TaskRequest) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.races == nil {
+		f.races = map[string]asana.TaskRequest{}
+	}
+	f.races[gid] = req
+}
+
+// Comment adds a comment to the task as the user with the given GID.
+func (f *Asana) Comment(taskGID, userGID, text string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.addStory(taskGID, f.user(userGID), text)
+}
+
+// Comments returns the comments on the task, oldest first.
+func (f *Asana) Comments(taskGID string) []asana.Story {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]asana.Story(nil), f.stories[taskGID]...)
+}
+
+// Attach attaches data to the task as a file named name, as an Asana user would, and returns the GID of the
+// attachment.
+func (f *Asana) Attach(taskGID, name string, data []byte) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.attach(taskGID, name, data).GID
+}
+
+// Files returns the contents of the files attached to the task by name.
+func (f *Asana) Files(taskGID string) map[string][]byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	files := map[string][]byte{}
+	for _, a := range f.files[taskGID] {
+		files[a.Name] = a.data
+	}
+	return files
+}
+
+// attach adds an attachment to the task. The caller must hold f.mu.
+func (f *Asana) attach(taskGID, name string, data []byte) asana.Attachment {
+	gid := f.gid()
+	a := asana.Attachment{GID: gid, Name: name, Size: int64(len(data)), DownloadURL: f.server.URL + "/attachments/" + gid + "/content"}
+	f.files[taskGID] = append(f.files[taskGID], asanaFile{Attachment: a, data: append([]byte(nil), data...)})
+	return a
+}
+
+// upload serves the multipart upload of an attachment.
+func (f *Asana) upload(w http.ResponseWriter, r *http.Request) {
+	parent := r.FormValue("parent")
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		asanaError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		asanaError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, ok := f.tasks[parent]; !ok {
+		asanaError(w, http.StatusNotFound, "unknown task "+parent)
+		return
+	}
+	writeData(w, f.attach(parent, header.Filename, data))
+}
+
+// Tag adds the tag with the given name to the task as an Asana user would, creating the tag when the
+// workspace has none of that name.
+func (f *Asana) Tag(gid, name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var tagGID string
+	for _, tag := range f.tags {
+		if tag.Name == name {
+			tagGID = tag.GID
+		}
+	}
+	if tagGID == "" {
+		tagGID = f.gid()
+		f.tags = append(f.tags, asana.Tag{GID: tagGID, Name: name})
+	}
+	if t := f.tasks[gid]; !contains(t.tags, tagGID) {
+		t.tags = append(t.tags, tagGID)
+		t.ModifiedAt = time.Now().UTC()
+	}
+}
+
+// DeleteTask deletes the task as an Asana user would.
+func (f *Asana) DeleteTask(gid string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.tasks, gid)
+}
+
+// RemoveFromProject removes the task from the project as an Asana user would.
+func (f *Asana) RemoveFromProject(gid, projectGID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if t, ok := f.tasks[gid]; ok {
+		t.projects = remove(t.projects, projectGID)
+		delete(t.sections, projectGID)
+		t.ModifiedAt = time.Now().UTC()
+	}
+}
+
+// user returns the user with the given GID. The caller must hold f.mu.
+func (f *Asana) user(gid string) asana.User {
+	for _, u := range f.users {
+		if u.GID == gid {
+			return u
+		}
+	}
+	return asana.User{GID: gid}
+}
+
+// addStory adds a comment to the task. The caller must hold f.mu.
+func (f *Asana) addStory(taskGID string, by asana.User, text string) asana.Story {
+	s := asana.Story{GID: f.gid(), Text: text, ResourceSubtype: "comment_added", CreatedBy: &by, CreatedAt: time.Now().UTC()}
+	f.stories[taskGID] = append(f.stories[taskGID], s)
+	f.tasks[taskGID].ModifiedAt = time.Now().UTC()
+	return s
+}
+
+// sorted returns the tasks in the order they were created. The caller must hold f.mu.
+func (f *Asana) sorted() []*fakeTask {
+	tasks := make([]*fakeTask, 0, len(f.tasks))
+	for _, t := range f.tasks {
+		tasks = append(tasks, t)
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		a, _ := strconv.Atoi(tasks[i].GID)
+		b, _ := strconv.Atoi(tasks[j].GID)
+		return a < b
+	})
+	return tasks
+}
+
+// render returns the task as the API returns it. The caller must hold f.mu.
+func (f *Asana) render(t *fakeTask) asana.Task {
+	task := t.Task
+	task.Memberships, task.CustomFields, task.Tags, task.Dependencies, task.Followers = nil, nil, nil, nil, nil
+	for _, pgid := range t.projects {
+		p := f.projects[pgid]
+		m := asana.Membership{Project: &asana.Section{GID: p.GID, Name: p.Name}}
+		for _, s := range p.sections {
+			if s.GID == t.sections[pgid] {
+				s := s
+				m.Section = &s
+			}
+		}
+		task.Memberships = append(task.Memberships, m)
+		for _, cf := range p.fields {
+			task.CustomFields = append(task.CustomFields, withValue(cf, t.values[cf.GID]))
+		}
+	}
+	for _, gid := range t.tags {
+		for _, tag := range f.tags {
+			if tag.GID == gid {
+				task.Tags = append(task.Tags, tag)
+			}
+		}
+	}
+	if p, ok := f.tasks[t.parent]; ok {
+		task.Parent = &asana.Task{GID: p.GID, Name: p.Name}
+	}
+	for _, d := range t.deps {
+		task.Dependencies = append(task.Dependencies, asana.Task{GID: d})
+	}
+	for _, u := range t.followers {
+		task.Followers = append(task.Followers, f.user(u))
+	}
+	return task
+}
+
+// withValue returns the custom field cf holding the value v, as stored by apply.
+func withValue(cf asana.CustomField, v interface{}) asana.CustomField {
+	options := cf.EnumOptions
+	cf.EnumOptions = nil
+	switch v := v.(type) {
+	case string:
+		switch cf.ResourceSubtype {
+		case asana.CustomFieldEnum:
+			for _, o := range options {
+				if o.GID == v {
+					o := o
+					cf.EnumValue = &o
+				}
+			}
+		default:
+			cf.TextValue = &v
+		}
+	case float64:
+		cf.NumberValue = &v
+	case map[string]interface{}:
+		d, _ := v["date"].(string)
+		cf.DateValue = &asana.DateValue{Date: d}
+	}
+	return cf
+}
+
+// apply applies the fields set by req to the task. The caller must hold f.mu.
+func (f *Asana) apply(t *fakeTask, req asana.TaskRequest) {
+	if req.Name != nil {
+		t.Name = *req.Name
+	}
+	if req.Notes != nil {
+		t.Notes, t.htmlNotes = *req.Notes, "<body>"+escapeHTML(*req.Notes)+"</body>"
+	}
+	if req.HTMLNotes != nil {
+		t.htmlNotes, t.Notes = *req.HTMLNotes, plainText(*req.HTMLNotes)
+	}
+	if req.Completed != nil {
+		t.Completed = *req.Completed
+	}
+	if req.Assignee != nil {
+		if *req.Assignee == "" {
+			t.Assignee = nil
+		} else {
+			u := f.user(*req.Assignee)
+			t.Assignee = &u
+		}
+	}
+	if req.DueOn != nil {
+		t.DueOn = string(*req.DueOn)
+	}
+	for gid, v := range req.CustomFields {
+		if v == nil {
+			delete(t.values, gid)
+		} else {
+			t.values[gid] = v
+		}
+	}
+	if req.External != nil {
+		t.External = req.External
+	}
+	if req.ResourceSubtype != nil {
+		t.ResourceSubtype, t.ApprovalStatus = *req.ResourceSubtype, ""
+		if t.ResourceSubtype == asana.SubtypeApproval {
+			t.ApprovalStatus = asana.ApprovalPending
+		}
+	}
+	if req.ApprovalStatus != nil && t.ResourceSubtype == asana.SubtypeApproval {
+		t.ApprovalStatus = *req.ApprovalStatus
+		t.Completed = t.ApprovalStatus == asana.ApprovalApproved || t.ApprovalStatus == asana.ApprovalRejected
+	}
+	t.ModifiedAt = time.Now().UTC()
+}
+
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// plainText returns the text of rich text notes, as Asana derives the notes field from html_notes.
+func plainText(s string) string {
+	s = htmlTag.ReplaceAllString(s, "")
+	return strings.NewReplacer("&lt;", "<", "&gt;", ">", "&quot;", `"`, "&#39;", "'", "&amp;", "&").Replace(s)
+}
+
+// escapeHTML escapes plain text notes for html_notes.
+func escapeHTML(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func remove(list []string, s string) []string {
+	out := list[:0]
+	for _, v := range list {
+		if v != s {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+var (
+	asanaTaskPath   = regexp.MustCompile(`^/tasks/(\d+)(?:/(\w+))?$`)
+	asanaProjPath   = regexp.MustCompile(`^/projects/(\d+)/(tasks|sections|custom_field_settings|addCustomFieldSetting|project_memberships|addMembers)$`)
+	asanaWSGet      = regexp.MustCompile(`^/workspaces/(\d+)$`)
+	asanaProjGet    = regexp.MustCompile(`^/projects/(\d+)$`)
+	asanaWSPath     = regexp.MustCompile(`^/workspaces/(\d+)/(users|tags|custom_fields)$`)
+	asanaEnumPath   = regexp.MustCompile(`^/custom_fields/(\d+)/enum_options$`)
+	asanaSectionAdd = regexp.MustCompile(`^/sections/(\d+)/addTask$`)
+	asanaStatusPath = regexp.MustCompile(`^/status_updates/(\d+)$`)
+	asanaFilePath   = regexp.MustCompile(`^/attachments/(\d+)/content$`)
+)
+
+func (f *Asana) serve(w http.ResponseWriter, r *http.Request) {
+	if f.limit.refuse(w) {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.URL.Path == "/batch" && r.Method == http.MethodPost:
+		f.batch(w, r)
+	case r.URL.Path == "/attachments" && r.Method == http.MethodPost:
+		f.upload(w, r)
+	default:
+		f.route(w, r)
+	}
+}
+
+// batch runs the actions of a batch request one after the other. The fake returns every field of a task
+// whatever the options of its action ask for.
+func (f *Asana) batch(w http.ResponseWriter, r *http.Request) {
+	if f.noBatch {
+		asanaError(w, http.StatusNotFound, "no route for POST /batch")
+		return
+	}
+	var body struct {
+		Data struct {
+			Actions []asana.BatchAction `json:"actions"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		asanaError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(body.Data.Actions) > asana.MaxBatchActions {
+		asanaError(w, http.StatusBadRequest, "too many actions")
+		return
+	}
+	f.batches++
+	results := make([]asana.BatchResult, 0, len(body.Data.Actions))
+	for _, a := range body.Data.Actions {
+		var data []byte
+		if a.Data != nil {
+			data, _ = json.Marshal(map[string]interface{}{"data": a.Data})
+		}
+		rec := httptest.NewRecorder()
+		f.route(rec, httptest.NewRequest(strings.ToUpper(a.Method), a.RelativePath, bytes.NewReader(data)))
+		results = append(results, asana.BatchResult{StatusCode: rec.Code, Body: rec.Body.Bytes()})
+	}
+	writeData(w, results)
+}
+
+// route serves a single request. The caller must hold f.mu.
+func (f *Asana) route(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if r.Body != nil && r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			asanaError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	decode := func(v interface{}) bool {
+		if err := json.Unmarshal(body.Data, v); err != nil {
+			asanaError(w, http.StatusBadRequest, err.Error())
+			return false
+		}
+		return true
+	}
+	q := r.URL.Query()
+
+	switch p := r.URL.Path; {
+	case p == "/users/me":
+		writeData(w, f.me)
+	case p == "/workspaces" && r.Method == http.MethodGet:
+		writePage(w, r, []asana.Workspace{{GID: f.Workspace, Name: "Workspace"}})
+	case p == "/projects" && r.Method == http.MethodGet:
+		if q.Get("workspace") != f.Workspace {
+			asanaError(w, http.StatusBadRequest, "unknown workspace")
+			return
+		}
+		projects := make([]asana.Project, 0, len(f.projects))
+		for _, proj := range f.projects {
+			projects = append(projects, proj.Project)
+		}
+		sort.Slice(projects, func(i, j int) bool { return projects[i].GID < projects[j].GID })
+		writePage(w, r, projects)
+	case asanaWSGet.MatchString(p) && r.Method == http.MethodGet:
+		if asanaWSGet.FindStringSubmatch(p)[1] != f.Workspace {
+			asanaError(w, http.StatusNotFound, "unknown workspace")
+			return
+		}
+		writeData(w, asana.Workspace{GID: f.Workspace, Name: "Workspace"})
+	case asanaProjGet.MatchString(p) && r.Method == http.MethodGet:
+		proj, ok := f.projects[asanaProjGet.FindStringSubmatch(p)[1]]
+		if !ok {
+			asanaError(w, http.StatusNotFound, "unknown project")
+			return
+		}
+		writeData(w, proj.Project)
+	case asanaWSPath.MatchString(p):
+		m := asanaWSPath.FindStringSubmatch(p)
+		if m[1] != f.Workspace {
+			asanaError(w, http.StatusNotFound, "unknown workspace")
+			return
+		}
+		switch {
+		case m[2] == "users":
+			f.userLists++
+			writePage(w, r, f.users)
+		case m[2] == "custom_fields":
+			writePage(w, r, f.workspaceFields())
+		case r.Method == http.MethodPost:
+			var req struct {
+				Name string `json:"name"`
+			}
+			if !decode(&req) {
+				return
+			}
+			tag := asana.Tag{GID: f.gid(), Name: req.Name}
+			f.tags = append(f.tags, tag)
+			writeData(w, tag)
+		default:
+			writePage(w, r, f.tags)
+		}
+	case asanaProjPath.MatchString(p):
+		m := asanaProjPath.FindStringSubmatch(p)
+		proj, ok := f.projects[m[1]]
+		if !ok {
+			asanaError(w, http.StatusNotFound, "unknown project")
+			return
+		}
+		switch {
+		case m[2] == "tasks":
+			f.listTasks(w, r, proj.GID, time.Time{})
+		case m[2] == "sections" && r.Method == http.MethodPost:
+			var req struct {
+				Name string `json:"name"`
+			}
+			if !decode(&req) {
+				return
+			}
+			s := asana.Section{GID: f.gid(), Name: req.Name}
+			proj.sections = append(proj.sections, s)
+			writeData(w, s)
+		case m[2] == "sections":
+			writePage(w, r, proj.sections)
+		case m[2] == "project_memberships":
+			members := make([]map[string]asana.User, 0, len(proj.members))
+			for _, u := range proj.members {
+				members = append(members, map[string]asana.User{"user": f.user(u)})
+			}
+			writePage(w, r, members)
+		case m[2] == "addCustomFieldSetting":
+			var req struct {
+				CustomField string `json:"custom_field"`
+			}
+			if !decode(&req) {
+				return
+			}
+			for _, cf := range f.workspaceFields() {
+				if cf.GID == req.CustomField {
+					proj.fields = append(proj.fields, cf)
+					writeData(w, struct{}{})
+					return
+				}
+			}
+			asanaError(w, http.StatusNotFound, "unknown custom field")
+		case m[2] == "addMembers":
+			var req struct {
+				Members string `json:"members"`
+			}
+			if !decode(&req) {
+				return
+			}
+			for _, u := range strings.Split(req.Members, ",") {
+				if !contains(proj.members, u) {
+					proj.members = append(proj.members, u)
+				}
+			}
+			writeData(w, proj.Project)
+		default:
+			settings := make([]map[string]asana.CustomField, 0, len(proj.fields))
+			for _, cf := range proj.fields {
+				settings = append(settings, map[string]asana.CustomField{"custom_field": cf})
+			}
+			writePage(w, r, settings)
+		}
+	case p == "/tasks" && r.Method == http.MethodGet && q.Get("assignee") != "":
+		var since time.Time
+		if s := q.Get("modified_since"); s != "" {
+			var err error
+			if since, err = time.Parse(time.RFC3339, s); err != nil {
+				asanaError(w, http.StatusBadRequest, "invalid modified_since")
+				return
+			}
+		}
+		if q.Get("workspace") != f.Workspace {
+			asanaError(w, http.StatusBadRequest, "assignee requires the workspace")
+			return
+		}
+		tasks := []asana.Task{}
+		for _, t := range f.sorted() {
+			if t.Assignee != nil && t.Assignee.GID == q.Get("assignee") && !t.ModifiedAt.Before(since) {
+				tasks = append(tasks, f.render(t))
+			}
+		}
+		writePage(w, r, tasks)
+	case p == "/tasks" && r.Method == http.MethodGet:
+		since, err := time.Parse(time.RFC3339, q.Get("modified_since"))
+		if _, ok := f.projects[q.Get("project")]; !ok || err != nil {
+			asanaError(w, http.StatusBadRequest, "project and modified_since are required")
+			return
+		}
+		f.listTasks(w, r, q.Get("project"), since)
+	case p == "/tasks" && r.Method == http.MethodPost:
+		var req asana.TaskRequest
+		if !decode(&req) {
+			return
+		}
+		for _, pgid := range req.Projects {
+			if _, ok := f.projects[pgid]; !ok {
+				asanaError(w, http.StatusBadRequest, "unknown project "+pgid)
+				return
+			}
+		}
+		writeData(w, f.render(f.create(req)))
+	case p == "/attachments":
+		atts := []asana.Attachment{}
+		for _, a := range f.files[q.Get("parent")] {
+			atts = append(atts, a.Attachment)
+		}
+		writePage(w, r, atts)
+	case asanaFilePath.MatchString(p) && r.Method == http.MethodGet:
+		gid := asanaFilePath.FindStringSubmatch(p)[1]
+		for _, files := range f.files {
+			for _, a := range files {
+				if a.GID == gid {
+					w.Header().Set("Content-Type", "application/octet-stream")
+					_, _ = w.Write(a.data)
+					return
+				}
+			}
+		}
+		asanaError(w, http.StatusNotFound, "unknown attachment "+gid)
+	case p == "/status_updates" && r.Method == http.MethodPost:
+		var req asana.StatusUpdateRequest
+		if !decode(&req) {
+			return
+		}
+		if _, ok := f.projects[req.Parent]; !ok || req.Title == "" {
+			asanaError(w, http.StatusBadRequest, "parent and title are required")
+			return
+		}
+		u := StatusUpdate{GID: f.gid(), StatusUpdateRequest: req}
+		f.statusUpdates = append(f.statusUpdates, u)
+		writeData(w, asana.StatusUpdate{GID: u.GID, Title: req.Title, StatusType: req.StatusType})
+	case asanaStatusPath.MatchString(p) && r.Method == http.MethodDelete:
+		gid := asanaStatusPath.FindStringSubmatch(p)[1]
+		for i, u := range f.statusUpdates {
+			if u.GID == gid {
+				f.statusUpdates = append(f.statusUpdates[:i], f.statusUpdates[i+1:]...)
+				writeData(w, struct{}{})
+				return
+			}
+		}
+		asanaError(w, http.StatusNotFound, "unknown status update "+gid)
+	case p == "/custom_fields" && r.Method == http.MethodPost:
+		var req struct {
+			Name            string `json:"name"`
+			ResourceSubtype string `json:"resource_subtype"`
+			EnumOptions     []struct {
+				Name string `json:"name"`
+			} `json:"enum_options"`
+		}
+		if !decode(&req) {
+			return
+		}
+		for _, cf := range f.workspaceFields() {
+			if strings.EqualFold(cf.Name, req.Name) {
+				asanaError(w, http.StatusForbidden, "a custom field named "+req.Name+" already exists")
+				return
+			}
+		}
+		cf := asana.CustomField{GID: f.gid(), Name: req.Name, ResourceSubtype: req.ResourceSubtype}
+		for _, o := range req.EnumOptions {
+			cf.EnumOptions = append(cf.EnumOptions, asana.EnumOption{GID: f.gid(), Name: o.Name})
+		}
+		f.fields = append(f.fields, cf)
+		writeData(w, cf)
+	case asanaEnumPath.MatchString(p):
+		var req struct {
+			Name string `json:"name"`
+		}
+		if !decode(&req) {
+			return
+		}
+		o := asana.EnumOption{GID: f.gid(), Name: req.Name}
+		gid, found := asanaEnumPath.FindStringSubmatch(p)[1], false
+		add := func(fields []asana.CustomField) {
+			for i := range fields {
+				if fields[i].GID == gid {
+					fields[i].EnumOptions = append(fields[i].EnumOptions, o)
+					found = true
+				}
+			}
+		}
+		add(f.fields)
+		for _, proj := range f.projects {
+			add(proj.fields)
+		}
+		if !found {
+			asanaError(w, http.StatusNotFound, "unknown custom field")
+			return
+		}
+		writeData(w, o)
+	case asanaSectionAdd.MatchString(p):
+		var req struct {
+			Task string `json:"task"`
+		}
+		if !decode(&req) {
+			return
+		}
+		t, ok := f.tasks[req.Task]
+		if !ok {
+			asanaError(w, http.StatusNotFound, "unknown task")
+			return
+		}
+		section := asanaSectionAdd.FindStringSubmatch(p)[1]
+		for _, proj := range f.projects {
+			for _, s := range proj.sections {
+				if s.GID == section {
+					if !contains(t.projects, proj.GID) {
+						t.projects = append(t.projects, proj.GID)
+					}
+					t.sections[proj.GID] = section
+					t.ModifiedAt = time.Now().UTC()
+					writeData(w, struct{}{})
+					return
+				}
+			}
+		}
+		asanaError(w, http.StatusNotFound, "unknown section")
+	case asanaTaskPath.MatchString(p):
+		m := asanaTaskPath.FindStringSubmatch(p)
+		t, ok := f.tasks[m[1]]
+		if !ok {
+			asanaError(w, http.StatusNotFound, "unknown task")
+			return
+		}
+		f.task(w, r, t, m[2], decode)
+	default:
+		asanaError(w, http.StatusNotFound, "no route for "+r.Method+" "+p)
+	}
+}
+
+// task serves the endpoints of a single task, action being the path element after its GID.
+func (f *Asana) task(w http.ResponseWriter, r *http.Request, t *fakeTask, action string, decode func(interface{}) bool) {
+	var req struct {
+		Parent       *string  `json:"parent"`
+		Dependencies []string `json:"dependencies"`
+		Project      string   `json:"project"`
+		Tag          string   `json:"tag"`
+		Text         string   `json:"text"`
+		Followers    []string `json:"followers"`
+	}
+	if r.Method == http.MethodPost && !decode(&req) {
+		return
+	}
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		if r.URL.Query().Get("opt_fields") == "html_notes" {
+			writeData(w, map[string]string{"gid": t.GID, "html_notes": t.htmlNotes})
+			return
+		}
+		if race, ok := f.races[t.GID]; ok {
+			delete(f.races, t.GID)
+			f.apply(t, race)
+		}
+		writeData(w, f.render(t))
+		return
+	case action == "" && r.Method == http.MethodPut:
+		var upd asana.TaskRequest
+		if !decode(&upd) {
+			return
+		}
+		f.apply(t, upd)
+		writeData(w, f.render(t))
+		return
+	case action == "" && r.Method == http.MethodDelete:
+		delete(f.tasks, t.GID)
+	case action == "stories" && r.Method == http.MethodGet:
+		writePage(w, r, f.stories[t.GID])
+		return
+	case action == "stories":
+		writeData(w, f.addStory(t.GID, f.me, req.Text))
+		return
+	case action == "setParent":
+		t.parent = ""
+		if req.Parent != nil {
+			t.parent = *req.Parent
+		}
+	case action == "addDependencies":
+		for _, d := range req.Dependencies {
+			if !contains(t.deps, d) {
+				t.deps = append(t.deps, d)
+			}
+		}
+	case action == "removeDependencies":
+		for _, d := range req.Dependencies {
+			t.deps = remove(t.deps, d)
+		}
+	case action == "addProject":
+		if _, ok := f.projects[req.Project]; !ok {
+			asanaError(w, http.StatusBadRequest, "unknown project")
+			return
+		}
+		if !contains(t.projects, req.Project) {
+			t.projects = append(t.projects, req.Project)
+		}
+	case action == "removeProject":
+		t.projects = remove(t.projects, req.Project)
+		delete(t.sections, req.Project)
+	case action == "addTag":
+		if !contains(t.tags, req.Tag) {
+			t.tags = append(t.tags, req.Tag)
+		}
+	case action == "removeTag":
+		t.tags = remove(t.tags, req.Tag)
+	case action == "addFollowers":
+		for _, u := range req.Followers {
+			if !contains(t.followers, u) {
+				t.followers = append(t.followers, u)
+			}
+		}
+	default:
+		asanaError(w, http.StatusNotFound, fmt.Sprintf("no route for %s %s", r.Method, r.URL.Path))
+		return
+	}
+	t.ModifiedAt = time.Now().UTC()
+	writeData(w, struct{}{})
+}
+
+// workspaceFields returns the custom fields of the workspace: those created through the API and those of
+// every project. The caller must hold f.mu.
+func (f *Asana) workspaceFields() []asana.CustomField {
+	fields := append([]asana.CustomField(nil), f.fields...)
+	seen := map[string]bool{}
+	for _, cf := range fields {
+		seen[cf.GID] = true
+	}
+	gids := make([]string, 0, len(f.projects))
+	for gid := range f.projects {
+		gids = append(gids, gid)
+	}
+	sort.Strings(gids)
+	for _, gid := range gids {
+		for _, cf := range f.projects[gid].fields {
+			if !seen[cf.GID] {
+				seen[cf.GID] = true
+				fields = append(fields, cf)
+			}
+		}
+	}
+	return fields
+}
+
+// listTasks writes a page of the tasks in the project modified since the given time.
+func (f *Asana) listTasks(w http.ResponseWriter, r *http.Request, projectGID string, since time.Time) {
+	tasks := []asana.Task{}
+	for _, t := range f.sorted() {
+		if contains(t.projects, projectGID) && !t.ModifiedAt.Before(since) {
+			tasks = append(tasks, f.render(t))
+		}
+	}
+	writePage(w, r, tasks)
+}
+
+// writeData writes v in the data envelope of the Asana API.
+func writeData(w http.ResponseWriter, v interface{}) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": v})
+}
+
+// writePage writes the page of list selected by the offset and limit parameters of r in the data envelope,
+// with the offset of the next page.
+func writePage(w http.ResponseWriter, r *http.Request, list interface{}) {
+	v := reflect.ValueOf(list)
+	from, to, next := page(v.Len(), r.URL.Query().Get("offset"), r.URL.Query().Get("limit"))
+	resp := map[string]interface{}{"data": v.Slice(from, to).Interface(), "next_page": nil}
+	if next != "" {
+		resp["next_page"] = map[string]string{"offset": next}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// asanaError writes an error response in the format of the Asana API.
+func asanaError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]interface{}{"errors": []map[string]string{{"message": msg}}})
+}
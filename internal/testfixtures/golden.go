@@ -0,0 +1,244 @@
+package testfixtures
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/config"
+	syncer "github.com/danstis/ado-asana-sync/internal/sync"
+)
+
+// Files of a golden case.
+const (
+	goldenFixture  = "fixture.json"
+	goldenConfig   = "config.json"
+	goldenSnapshot = "asana.json"
+)
+
+// Golden is a golden case: work items and a mapping configuration whose planned Asana writes are compared
+// with a snapshot checked in next to them. Each case is a directory holding fixture.json, the optional
+// config.json, a configuration file as the service reads it, and asana.json, the snapshot.
+type Golden struct {
+	Name string
+	Dir  string
+}
+
+// fixture is the Asana workspace and the ADO work items of a golden case.
+type fixture struct {
+	// Users are added to the workspace, and CustomFields to the project of the pair, before the items.
+	Users        []fixtureUser  `json:"users,omitempty"`
+	CustomFields []fixtureField `json:"custom_fields,omitempty"`
+	Items        []fixtureItem  `json:"items"`
+}
+
+type fixtureUser struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type fixtureField struct {
+	Name    string   `json:"name"`
+	Subtype string   `json:"subtype"`
+	Options []string `json:"options,omitempty"`
+}
+
+type fixtureItem struct {
+	Type  string `json:"type"`
+	Title string `json:"title"`
+	// AssignedTo is the email of the assignee, matched to the users for their display name.
+	AssignedTo string                 `json:"assigned_to,omitempty"`
+	Fields     map[string]interface{} `json:"fields,omitempty"`
+}
+
+// GoldenCases returns the golden cases in the subdirectories of dir, ordered by name.
+func GoldenCases(dir string) ([]Golden, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var cases []Golden
+	for _, e := range entries {
+		if e.IsDir() {
+			cases = append(cases, Golden{Name: e.Name(), Dir: filepath.Join(dir, e.Name())})
+		}
+	}
+	sort.Slice(cases, func(i, j int) bool { return cases[i].Name < cases[j].Name })
+	return cases, nil
+}
+
+// Run syncs the work items of the case in a dry run and compares the planned Asana writes with the snapshot,
+// reporting the first line that differs. With update set the snapshot is rewritten instead.
+func (g Golden) Run(ctx context.Context, update bool) error {
+	got, err := g.plan(ctx)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(g.Dir, goldenSnapshot)
+	if update {
+		return os.WriteFile(path, got, 0o644)
+	}
+	want, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("no snapshot %s, run with -update to write it", path)
+	}
+	if err != nil {
+		return err
+	}
+	if line, wantLine, gotLine, ok := firstDiff(want, got); ok {
+		return fmt.Errorf("%s line %d: want %s, got %s", goldenSnapshot, line, wantLine, gotLine)
+	}
+	return nil
+}
+
+// plan returns the canonical JSON of the Asana writes a dry run plans for the work items of the case.
+func (g Golden) plan(ctx context.Context) ([]byte, error) {
+	var fx fixture
+	if err := readJSON(filepath.Join(g.Dir, goldenFixture), &fx); err != nil {
+		return nil, err
+	}
+	cfg, err := g.config()
+	if err != nil {
+		return nil, err
+	}
+	// A single worker plans the items in query order, so planned GIDs are the same every run.
+	cfg.Name, cfg.DryRun, cfg.Workers = g.Name, true, 1
+	h := NewHarness(cfg)
+	defer h.Close()
+
+	names := map[string]string{}
+	for _, u := range fx.Users {
+		h.Asana.AddUser(u.Name, u.Email)
+		names[u.Email] = u.Name
+	}
+	for _, cf := range fx.CustomFields {
+		h.Asana.AddCustomField(h.Project, cf.Name, cf.Subtype, cf.Options...)
+	}
+	for _, it := range fx.Items {
+		fields := map[string]interface{}{}
+		for k, v := range it.Fields {
+			fields[k] = v
+		}
+		if it.AssignedTo != "" {
+			name := names[it.AssignedTo]
+			if name == "" {
+				name = it.AssignedTo
+			}
+			fields[ado.FieldAssignedTo] = Assignee(name, it.AssignedTo)
+		}
+		h.ADO.Add(it.Type, it.Title, fields)
+	}
+
+	rep, err := h.Run(ctx)
+	if err != nil {
+		return nil, err
+	}
+	changes := []syncer.Change{}
+	for _, c := range rep.Plan.Changes {
+		if c.System == syncer.SystemAsana {
+			c.Pair = ""
+			changes = append(changes, c)
+		}
+	}
+	b, err := json.Marshal(changes)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	v = canonical(v, h.Asana.Names(), strings.NewReplacer(h.ADO.URL(), "{ado}", h.Asana.URL(), "{asana}"))
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// config returns the configuration of the pair of the case, as config.json sets it over the defaults.
+func (g Golden) config() (syncer.Config, error) {
+	base := syncer.DefaultConfig()
+	path := filepath.Join(g.Dir, goldenConfig)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return base, nil
+	}
+	f, err := config.Load(path)
+	if err != nil {
+		return base, err
+	}
+	pairs, err := f.SyncPairs(base)
+	if err != nil {
+		return base, err
+	}
+	if len(pairs) != 1 {
+		return base, fmt.Errorf("%s: want one sync pair, got %d", path, len(pairs))
+	}
+	return pairs[0], nil
+}
+
+// canonical returns v with the GIDs of the fake workspace replaced by their names, as keys and as values,
+// and the URLs of the fakes by placeholders, so snapshots do not change with the order records are created
+// in or the ports the fakes listen on.
+func canonical(v interface{}, names map[string]string, urls *strings.Replacer) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			if name, ok := names[k]; ok {
+				k = name
+			}
+			out[k] = canonical(e, names, urls)
+		}
+		return out
+	case []interface{}:
+		for i, e := range v {
+			v[i] = canonical(e, names, urls)
+		}
+		return v
+	case string:
+		if name, ok := names[v]; ok {
+			return name
+		}
+		return urls.Replace(v)
+	}
+	return v
+}
+
+// firstDiff returns the number of the first line that differs between want and got, and its contents in
+// each without indentation.
+func firstDiff(want, got []byte) (line int, w, g string, ok bool) {
+	wl, gl := strings.Split(string(want), "\n"), strings.Split(string(got), "\n")
+	for i := 0; i < len(wl) || i < len(gl); i++ {
+		switch {
+		case i >= len(wl):
+			return i + 1, "end of file", strings.TrimSpace(gl[i]), true
+		case i >= len(gl):
+			return i + 1, strings.TrimSpace(wl[i]), "end of file", true
+		case wl[i] != gl[i]:
+			return i + 1, strings.TrimSpace(wl[i]), strings.TrimSpace(gl[i]), true
+		}
+	}
+	return 0, "", "", false
+}
+
+// readJSON decodes the JSON file at path into v, rejecting unknown keys.
+func readJSON(path string, v interface{}) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return nil
+}
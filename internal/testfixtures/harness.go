@@ -0,0 +1,146 @@
+// Package testfixtures provides stateful fakes of the Azure DevOps and Asana APIs and a harness running sync
+// cycles against them, so the sync engine can be exercised end to end without real accounts. The fakes page
+// their lists and can simulate rate limiting; Scenarios run the engine through common flows.
+package testfixtures
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/metrics"
+	"github.com/danstis/ado-asana-sync/internal/ratelimit"
+	"github.com/danstis/ado-asana-sync/internal/secret"
+	"github.com/danstis/ado-asana-sync/internal/store"
+	syncer "github.com/danstis/ado-asana-sync/internal/sync"
+)
+
+// ProjectName is the name of the ADO project and the Asana project of a Harness.
+const ProjectName = "Fabrikam"
+
+// Harness is a sync pair between a fake ADO project and a fake Asana project.
+type Harness struct {
+	ADO   *ADO
+	Asana *Asana
+	// Project is the GID of the Asana project of the pair.
+	Project string
+	Store   store.Store
+	Engine  *syncer.Engine
+	// Config is the configuration of the pair.
+	Config syncer.Config
+
+	asana *asana.Client
+}
+
+// NewHarness starts the fakes and returns a Harness syncing them with cfg, whose project and workspace are
+// set to those of the fakes. The API clients retry rate limited requests as the real ones do. Close stops
+// the fakes.
+func NewHarness(cfg syncer.Config) *Harness {
+	h := &Harness{ADO: NewADO(ProjectName), Asana: NewAsana(), Store: store.NewMemory()}
+	h.Project = h.Asana.AddProject(ProjectName, "To do", "Doing", "Done")
+	cfg.ADOProject, cfg.AsanaWorkspace, cfg.AsanaProject = ProjectName, h.Asana.Workspace, h.Project
+
+	h.Config = cfg
+	h.asana = asana.NewClient("token")
+	h.asana.BaseURL = h.Asana.URL()
+	h.asana.HTTP = limited(metrics.ProviderAsana)
+	h.Engine = syncer.New(cfg, adoClient(h.ADO), h.asana, h.Store)
+	return h
+}
+
+// Batch replaces the engine with one whose calls are grouped into batch requests within window.
+func (h *Harness) Batch(window time.Duration) {
+	_ = h.Engine.Close()
+	a, d := asana.NewBatcher(h.asana), ado.NewBatcher(adoClient(h.ADO))
+	a.Window, d.Window = window, window
+	h.Engine = syncer.New(h.Config, d, a, h.Store)
+}
+
+// Cache replaces the engine with one reading Asana reference data through a cache holding it for ttl.
+func (h *Harness) Cache(ttl time.Duration) {
+	_ = h.Engine.Close()
+	h.Engine = syncer.New(h.Config, adoClient(h.ADO), syncer.NewCachedAsana(h.asana, ttl), h.Store)
+}
+
+// Encrypt replaces the store of the harness, and the engine working on it, with one encrypting the records
+// of raw with key, and opening those sealed with the previous keys.
+func (h *Harness) Encrypt(raw store.Store, key *secret.Box, previous ...*secret.Box) {
+	_ = h.Engine.Close()
+	h.Store = store.NewEncrypted(raw, key, previous...)
+	h.Engine = syncer.New(h.Config, adoClient(h.ADO), h.asana, h.Store)
+}
+
+// EngineWith returns an engine of the pair of the harness working on st, whose API clients send their
+// requests to provider through the transport returned by transport.
+func (h *Harness) EngineWith(st store.Store, transport func(provider string) http.RoundTripper) *syncer.Engine {
+	d := ado.NewClient(h.ADO.URL(), "pat")
+	d.HTTP = &http.Client{Transport: transport(metrics.ProviderADO)}
+	a := asana.NewClient("token")
+	a.BaseURL = h.Asana.URL()
+	a.HTTP = &http.Client{Transport: transport(metrics.ProviderAsana)}
+	return syncer.New(h.Config, d, a, st)
+}
+
+// adoClient returns a client of the fake organization f.
+func adoClient(f *ADO) *ado.Client {
+	c := ado.NewClient(f.URL(), "pat")
+	c.HTTP = limited(metrics.ProviderADO)
+	return c
+}
+
+// Manager returns a Manager syncing the pair of the harness with the ADO fake of the harness, and the other
+// pairs with the ADO connections they name. Every pair syncs with the Asana fake.
+func (h *Harness) Manager(others []syncer.Config, conns map[string]syncer.ADOConnection) (*syncer.Manager, error) {
+	all := map[string]syncer.ADOConnection{
+		h.Config.ADOConnection: {OrgURL: h.ADO.URL(), ADO: adoClient(h.ADO), Store: h.Store},
+	}
+	for name, c := range conns {
+		all[name] = c
+	}
+	return syncer.NewManager(append([]syncer.Config{h.Config}, others...), all, map[string]syncer.AsanaConnection{
+		syncer.DefaultConnection: {Asana: h.asana},
+	})
+}
+
+// limited returns an HTTP client retrying the rate limited requests to provider and counting them as the
+// real clients do.
+func limited(provider string) *http.Client {
+	l := ratelimit.New(provider, ratelimit.Options{Concurrency: ratelimit.DefaultConcurrency, Retries: ratelimit.DefaultRetries})
+	return &http.Client{Transport: l.Transport(metrics.Transport(provider, nil))}
+}
+
+// Close stops the fakes and the transform plugins of the engine.
+func (h *Harness) Close() {
+	_ = h.Engine.Close()
+	h.ADO.Close()
+	h.Asana.Close()
+}
+
+// Run runs a sync cycle, failing when the cycle fails or any work item failed to sync.
+func (h *Harness) Run(ctx context.Context) (*syncer.Report, error) {
+	rep, err := h.Engine.Run(ctx)
+	if err != nil {
+		return rep, err
+	}
+	if len(rep.Failures) > 0 {
+		f := rep.Failures[0]
+		return rep, fmt.Errorf("%d work items failed to sync, the first, %d: %w", len(rep.Failures), f.ADOID, f.Err)
+	}
+	return rep, nil
+}
+
+// TaskOf returns the task mapped to the work item.
+func (h *Harness) TaskOf(ctx context.Context, id int) (asana.Task, error) {
+	m, err := h.Store.Get(ctx, id)
+	if err != nil {
+		return asana.Task{}, fmt.Errorf("mapping of work item %d: %w", id, err)
+	}
+	t, ok := h.Asana.Task(m.AsanaGID)
+	if !ok {
+		return asana.Task{}, fmt.Errorf("task %s of work item %d does not exist", m.AsanaGID, id)
+	}
+	return t, nil
+}
@@ -0,0 +1,46 @@
+package testfixtures
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/transform"
+)
+
+// PluginArg is the argument the test binary of the package is run with to serve as the exec plugin of a scenario.
+const PluginArg = "plugin"
+
+// ServePlugin speaks the protocol of exec transform plugins on r and w until r ends. It upper cases the
+// titles of work items and leaves tasks alone.
+func ServePlugin(r io.Reader, w io.Writer) error {
+	in := bufio.NewScanner(r)
+	in.Buffer(nil, 16<<20)
+	out := json.NewEncoder(w)
+	for in.Scan() {
+		var req struct {
+			Version  int           `json:"version"`
+			Kind     string        `json:"kind"`
+			WorkItem *ado.WorkItem `json:"work_item"`
+		}
+		resp := map[string]interface{}{}
+		switch err := json.Unmarshal(in.Bytes(), &req); {
+		case err != nil:
+			resp["error"] = err.Error()
+		case req.Version != transform.ProtocolVersion:
+			resp["error"] = fmt.Sprintf("unsupported protocol version %d", req.Version)
+		case req.Kind == "work_item" && req.WorkItem != nil:
+			if title, ok := req.WorkItem.Fields["System.Title"].(string); ok {
+				req.WorkItem.Fields["System.Title"] = strings.ToUpper(title)
+			}
+			resp["work_item"] = req.WorkItem
+		}
+		if err := out.Encode(resp); err != nil {
+			return err
+		}
+	}
+	return in.Err()
+}
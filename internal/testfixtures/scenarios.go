@@ -0,0 +1,3191 @@
+package testfixtures
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/analytics"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+	"github.com/danstis/ado-asana-sync/internal/config"
+	"github.com/danstis/ado-asana-sync/internal/digest"
+	"github.com/danstis/ado-asana-sync/internal/egress"
+	"github.com/danstis/ado-asana-sync/internal/leader"
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/ratelimit"
+	"github.com/danstis/ado-asana-sync/internal/replay"
+	"github.com/danstis/ado-asana-sync/internal/schedule"
+	"github.com/danstis/ado-asana-sync/internal/secret"
+	"github.com/danstis/ado-asana-sync/internal/store"
+	syncer "github.com/danstis/ado-asana-sync/internal/sync"
+	"github.com/danstis/ado-asana-sync/internal/systemd"
+	"github.com/danstis/ado-asana-sync/internal/transform"
+	"github.com/danstis/ado-asana-sync/internal/webhook"
+)
+
+// Scenario is an end to end flow run against a fresh Harness.
+type Scenario struct {
+	Name string
+	// Config, when set, adjusts the configuration of the pair.
+	Config func(*syncer.Config)
+	// Steps drive the fakes and the engine, returning an error when the sync did not behave.
+	Steps func(ctx context.Context, h *Harness) error
+}
+
+// Run runs the scenario against a fresh Harness.
+func (s Scenario) Run(ctx context.Context) error {
+	cfg := syncer.DefaultConfig()
+	cfg.Name = s.Name
+	if s.Config != nil {
+		s.Config(&cfg)
+	}
+	h := NewHarness(cfg)
+	defer h.Close()
+	return s.Steps(ctx, h)
+}
+
+// Scenarios are the flows every change to the engine is expected to keep working.
+var Scenarios = []Scenario{
+	{Name: "create-and-update", Steps: createAndUpdate},
+	{Name: "pagination", Steps: pagination},
+	{Name: "streaming", Config: func(c *syncer.Config) {
+		c.Workers, c.Prefetch, c.QueryPage = 1, 1, 400
+		c.Removal.Policy = syncer.RemoveComplete
+	}, Steps: streaming},
+	{Name: "rate-limits", Steps: rateLimits},
+	{Name: "incremental", Config: func(c *syncer.Config) { c.Incremental = true }, Steps: incremental},
+	{Name: "comments", Config: func(c *syncer.Config) { c.CommentDirection = syncer.Bidirectional }, Steps: comments},
+	{Name: "attachments", Config: func(c *syncer.Config) {
+		c.AttachmentDirection, c.MaxAttachmentSize = syncer.Bidirectional, 1024
+	}, Steps: attachments},
+	{Name: "completion", Config: func(c *syncer.Config) { c.Direction = syncer.Bidirectional }, Steps: completion},
+	{Name: "anchors", Config: func(c *syncer.Config) { c.Anchor = "ADO ID" }, Steps: anchors},
+	{Name: "effort", Config: func(c *syncer.Config) {
+		c.Effort = syncer.EffortConfig{Completed: "Completed", Remaining: "Remaining"}
+		c.FieldDirections = map[syncer.Field]syncer.Direction{syncer.FieldRemaining: syncer.Bidirectional}
+	}, Steps: effort},
+	{Name: "rollup", Config: func(c *syncer.Config) {
+		c.Rollup = syncer.RollupConfig{Points: "Total points", Remaining: "Remaining"}
+	}, Steps: rollup},
+	{Name: "organizations", Steps: organizations},
+	{Name: "closing", Config: func(c *syncer.Config) {
+		c.Closing = syncer.ClosingConfig{Action: syncer.CloseDelay, Grace: "1h"}
+	}, Steps: closing},
+	{Name: "recurrence", Config: func(c *syncer.Config) { c.Recurrence.Tag = "recurring" }, Steps: recurrence},
+	{Name: "members", Config: func(c *syncer.Config) {
+		c.Members = syncer.MembersConfig{Add: true, Follow: true}
+	}, Steps: members},
+	{Name: "fallback-assignee", Config: func(c *syncer.Config) {
+		c.Members = syncer.MembersConfig{Fallback: "lead@example.com"}
+	}, Steps: fallbackAssignee},
+	{Name: "no-op-writes", Config: func(c *syncer.Config) { c.NotesTemplate = "<strong>{{.Title}}</strong>" }, Steps: noOpWrites},
+	{Name: "concurrent-edits", Config: func(c *syncer.Config) {
+		c.Direction, c.ConflictStrategy = syncer.Bidirectional, syncer.ManualQueue
+	}, Steps: concurrentEdits},
+	{Name: "transforms", Config: func(c *syncer.Config) {
+		c.NotesTemplate = `Priority {{index .Fields "Microsoft.VSTS.Common.Priority"}}`
+		c.Transforms = []transform.Spec{{Name: "map-field", Options: map[string]string{
+			"field": "Microsoft.VSTS.Common.Priority", "values": "1=High,2=High,3=Medium,4=Low",
+		}}}
+	}, Steps: transforms},
+	{Name: "exec-plugin", Config: func(c *syncer.Config) {
+		exe, _ := os.Executable()
+		c.Transforms = []transform.Spec{{Name: "exec", Options: map[string]string{"command": exe, "args": PluginArg}}}
+	}, Steps: execPlugin},
+	{Name: "board", Config: func(c *syncer.Config) {
+		c.Board = syncer.BoardConfig{Sections: true, Columns: map[string]string{"Doing": "In Progress"}, ColumnField: "Column", LaneField: "Lane"}
+	}, Steps: boardColumns},
+	{Name: "enum-translation", Config: func(c *syncer.Config) {
+		c.FieldMappings = []syncer.FieldMapping{{
+			Source: "Microsoft.VSTS.Common.Priority", Target: "Priority", Type: syncer.TypeEnum, Direction: syncer.Bidirectional,
+			Values: map[string]string{"1": "High", "2": "High", "3": "Medium"}, Default: "Low", Reverse: map[string]string{"High": "1", "Low": "4"},
+		}}
+	}, Steps: enumTranslation},
+	{Name: "drift", Steps: drift},
+	{Name: "batching", Config: func(c *syncer.Config) { c.Direction = syncer.Bidirectional }, Steps: batching},
+	{Name: "caching", Steps: caching},
+	{Name: "replay", Config: func(c *syncer.Config) { c.Direction = syncer.Bidirectional }, Steps: replaying},
+	{Name: "trigger", Config: func(c *syncer.Config) { c.Interval = time.Hour }, Steps: trigger},
+	{Name: "single-item", Steps: singleItem},
+	{Name: "back-link", Config: func(c *syncer.Config) { c.BackLink = syncer.BackLinkHyperlink }, Steps: backLink},
+	{Name: "calendar", Config: func(c *syncer.Config) {
+		c.DueDates = true
+		c.FieldDirections = map[syncer.Field]syncer.Direction{syncer.FieldDueDate: syncer.Bidirectional}
+		c.Calendar = syncer.CalendarConfig{TimeZone: "Australia/Sydney", Snap: true, Holidays: []string{"2024-06-10"}}
+	}, Steps: calendar},
+	{Name: "approvals", Config: func(c *syncer.Config) {
+		c.Direction = syncer.Bidirectional
+		c.Types = []syncer.TypeRule{
+			{Type: "Release", Subtype: asana.SubtypeApproval, Approvals: map[string]string{
+				asana.ApprovalPending: "New", asana.ApprovalApproved: "Closed", asana.ApprovalChangesRequested: "Active",
+			}},
+			{Type: "Milestone", Subtype: asana.SubtypeMilestone},
+		}
+	}, Steps: approvals},
+	{Name: "intake", Config: func(c *syncer.Config) {
+		c.Direction = syncer.Bidirectional
+		c.Intake = syncer.IntakeConfig{Tag: "intake", Type: "User Story"}
+	}, Steps: intake},
+	{Name: "title-direction", Config: func(c *syncer.Config) {
+		c.FieldDirections = map[syncer.Field]syncer.Direction{syncer.FieldTitle: syncer.AsanaToADO}
+	}, Steps: titleDirection},
+	{Name: "encrypted-store", Steps: encryptedStore},
+	{Name: "encrypted-records", Steps: encryptedRecords},
+	{Name: "leader-election", Steps: leaderElection},
+	{Name: "sharding", Steps: sharding},
+	{Name: "call-budget", Config: func(c *syncer.Config) {
+		c.CallBudget = []ratelimit.Cap{{Calls: budgetCalls, Window: budgetWindow}}
+	}, Steps: callBudget},
+	{Name: "notes-overflow", Config: func(c *syncer.Config) {
+		c.NotesTemplate, c.NotesOverflow, c.NotesLimit = "{{.Description}}", syncer.OverflowComment, overflowLimit
+		c.CommentDirection = syncer.Bidirectional
+	}, Steps: notesOverflow},
+	{Name: "dedupe", Steps: dedupe},
+	{Name: "manage-schema", Config: func(c *syncer.Config) {
+		c.ManageSchema = true
+		c.FieldMappings = []syncer.FieldMapping{
+			{Source: "Microsoft.VSTS.Common.Priority", Target: "Priority", Type: syncer.TypeEnum, Values: map[string]string{"1": "High", "2": "Medium"}, Default: "Low"},
+			{Source: "Custom.Team", Target: "Team", Type: syncer.TypeText},
+			{Source: "Microsoft.VSTS.Common.Severity", Target: "Severity", Type: syncer.TypeText},
+		}
+	}, Steps: manageSchema},
+	{Name: "probe", Config: func(c *syncer.Config) { c.Development = true }, Steps: probe},
+	{Name: "test-cases", Config: func(c *syncer.Config) { c.TestCases = true }, Steps: testCases},
+	{Name: "warm-up", Config: func(c *syncer.Config) { c.Incremental, c.DueDates = true, true }, Steps: warmUp},
+	{Name: "journal", Config: func(c *syncer.Config) {
+		c.Journal, c.NotesTemplate = true, "<strong>Untitled</strong>"
+	}, Steps: journal},
+	{Name: "pause", Steps: pause},
+	{Name: "links", Config: func(c *syncer.Config) {
+		c.Links = syncer.LinkConfig{
+			Related: syncer.LinkNotes, Duplicate: syncer.LinkComplete,
+			BlockedBy: syncer.LinkDependency, BlockedByType: blockedByLink,
+		}
+	}, Steps: links},
+	{Name: "digest", Steps: digestReport},
+	{Name: "canary", Config: func(c *syncer.Config) { c.NotesTemplate = "<strong>Current</strong>" }, Steps: canary},
+	{Name: "purge", Config: func(c *syncer.Config) { c.Journal = true }, Steps: purge},
+	{Name: "status-updates", Config: func(c *syncer.Config) { c.StatusUpdates.Enabled = true }, Steps: statusUpdates},
+	{Name: "discover", Steps: discover},
+	{Name: "pending-mappings", Steps: pendingMappings},
+	{Name: "my-tasks", Steps: myTasks},
+	{Name: "analytics", Config: func(c *syncer.Config) { c.Analytics = true }, Steps: analyticsExport},
+	{Name: "systemd", Steps: systemdNotify},
+	{Name: "egress", Steps: egressProxy},
+	{Name: "priority", Config: func(c *syncer.Config) {
+		c.Workers = 1
+		c.Priority.Boosts = []string{"[System.WorkItemType] = 'Bug'", "[System.Title] = 'Item 3'"}
+	}, Steps: priorityOrder},
+	{Name: "error-codes", Steps: errorCodes},
+	{Name: "process-fields", Config: func(c *syncer.Config) {
+		c.ManageSchema = true
+		c.FieldMappings = []syncer.FieldMapping{{Source: "Release Train", Target: "Train"}}
+	}, Steps: processFields},
+	{Name: "conflict-strategies", Config: func(c *syncer.Config) { c.Direction = syncer.Bidirectional }, Steps: conflictStrategies},
+	{Name: "resolve-conflicts", Config: func(c *syncer.Config) {
+		c.Direction, c.ConflictStrategy = syncer.Bidirectional, syncer.ManualQueue
+	}, Steps: resolveConflicts},
+	{Name: "tenants", Steps: tenants},
+	{Name: "webhooks", Steps: webhooks},
+}
+
+// addAssigned adds n work items assigned to a user of both fakes and returns their IDs.
+func addAssigned(h *Harness, n int) []int {
+	h.Asana.AddUser("Alice", "alice@example.com")
+	ids := make([]int, 0, n)
+	for i := 1; i <= n; i++ {
+		ids = append(ids, h.ADO.Add("Task", fmt.Sprintf("Item %d", i), map[string]interface{}{
+			ado.FieldAssignedTo: Assignee("Alice", "alice@example.com"),
+		}))
+	}
+	return ids
+}
+
+// expectTasks checks that the work items are mapped to tasks in the project, named as the engine names them.
+func expectTasks(ctx context.Context, h *Harness, ids []int) error {
+	if got := len(h.Asana.Tasks(h.Project)); got != len(ids) {
+		return fmt.Errorf("want %d tasks, got %d", len(ids), got)
+	}
+	for _, id := range ids {
+		t, err := h.TaskOf(ctx, id)
+		if err != nil {
+			return err
+		}
+		wi, _ := h.ADO.Item(id)
+		if want := fmt.Sprintf("[AB#%d] %s", id, wi.Title()); t.Name != want {
+			return fmt.Errorf("task of work item %d: want name %q, got %q", id, want, t.Name)
+		}
+	}
+	return nil
+}
+
+func createAndUpdate(ctx context.Context, h *Harness) error {
+	ids := addAssigned(h, 3)
+	// Unassigned items are not selected by the default query.
+	h.ADO.Add("Task", "Unassigned", nil)
+	rep, err := h.Run(ctx)
+	if err != nil {
+		return err
+	}
+	if rep.Created != 3 {
+		return fmt.Errorf("first cycle: want 3 tasks created, got %d", rep.Created)
+	}
+	if err := expectTasks(ctx, h, ids); err != nil {
+		return err
+	}
+	t, _ := h.TaskOf(ctx, ids[0])
+	if t.Assignee == nil || t.Assignee.Email != "alice@example.com" {
+		return fmt.Errorf("task of work item %d is not assigned to alice", ids[0])
+	}
+
+	h.ADO.Update(ids[1], map[string]interface{}{ado.FieldTitle: "Renamed"})
+	if rep, err = h.Run(ctx); err != nil {
+		return err
+	}
+	if rep.Created != 0 {
+		return fmt.Errorf("second cycle: want no tasks created, got %d", rep.Created)
+	}
+	if err := expectTasks(ctx, h, ids); err != nil {
+		return err
+	}
+	// Both cycles are recorded with their statistics, newest first.
+	cycles, err := syncer.RecentCycles(ctx, h.Store, h.Config.Name, 5)
+	if err != nil {
+		return err
+	}
+	if len(cycles) != 2 || cycles[0].Created != 0 || cycles[1].Created != 3 {
+		return fmt.Errorf("want two cycles recorded creating 0 and 3 tasks, got %+v", cycles)
+	}
+	if cycles[1].APICalls == 0 {
+		return fmt.Errorf("first cycle: want its api calls counted")
+	}
+	return nil
+}
+
+// pagination syncs more items than fit in a page of either API.
+func pagination(ctx context.Context, h *Harness) error {
+	ids := addAssigned(h, 250)
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if err := expectTasks(ctx, h, ids); err != nil {
+		return err
+	}
+	rep, err := h.Run(ctx)
+	if err != nil {
+		return err
+	}
+	if rep.Created != 0 {
+		return fmt.Errorf("second cycle: want no tasks created, got %d", rep.Created)
+	}
+	return nil
+}
+
+// streaming syncs a backlog of many pages and checks that work items are fetched no further ahead of the
+// tasks created than the pages a cycle prefetches, the page being dispatched and the item in the worker.
+func streaming(ctx context.Context, h *Harness) error {
+	// The selection is larger than a query may return, so it is read 400 IDs at a time.
+	h.ADO.LimitQueries(400)
+	ids := addAssigned(h, 1500)
+	var mu gosync.Mutex
+	ahead, first := 0, -1
+	h.ADO.OnRead(func(read, queries int) {
+		n := read - len(h.Asana.Tasks(h.Project))
+		mu.Lock()
+		defer mu.Unlock()
+		if n > ahead {
+			ahead = n
+		}
+		if first < 0 {
+			first = queries
+		}
+	})
+	_, err := h.Run(ctx)
+	h.ADO.OnRead(nil)
+	if err != nil {
+		return err
+	}
+	if err := expectTasks(ctx, h, ids); err != nil {
+		return err
+	}
+	if limit := 2*200 + 1; ahead > limit {
+		return fmt.Errorf("want work items read at most %d ahead of the tasks created, got %d", limit, ahead)
+	}
+	// The most recently changed page is read, then the whole selection by ID as the cycle gets to it.
+	if queries := h.ADO.Queries(); queries != 1+4 || first != 1 {
+		return fmt.Errorf("want 5 queries, the first page and then 4 pages by ID read as they are synced, got %d with %d before the first work items", queries, first)
+	}
+
+	// Only the item that left the selection is removed, not those past its first page.
+	h.ADO.Update(ids[1499], map[string]interface{}{ado.FieldAssignedTo: nil})
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		t, err := h.TaskOf(ctx, id)
+		if id == ids[1499] {
+			if err == nil {
+				return fmt.Errorf("want the mapping of the unselected item %d forgotten", id)
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if t.Completed {
+			return fmt.Errorf("want the task of selected item %d left open", id)
+		}
+	}
+
+	// A cycle stopped before reading the rest of its selection has the next one sync all of that rest, even
+	// the items that changed before the stopped cycle started.
+	h.ADO.Update(ids[1400], map[string]interface{}{ado.FieldTitle: "Renamed before the shutdown"})
+	stop, cancel := context.WithCancel(ctx)
+	defer cancel()
+	base := -1
+	h.ADO.OnRead(func(read, _ int) {
+		if base < 0 {
+			base = read
+		}
+		if read-base >= 800 {
+			cancel()
+		}
+	})
+	_, err = h.Run(stop)
+	h.ADO.OnRead(nil)
+	if !errors.Is(err, syncer.ErrInterrupted) {
+		return fmt.Errorf("want the cycle interrupted, got %v", err)
+	}
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if t, err := h.TaskOf(ctx, ids[1400]); err != nil || !strings.Contains(t.Name, "Renamed before the shutdown") {
+		return fmt.Errorf("want the item the interrupted cycle did not read synced by the next one, got %q, %v", t.Name, err)
+	}
+	return nil
+}
+
+// rateLimits syncs while the fakes refuse every other ADO request and every fifth Asana request.
+func rateLimits(ctx context.Context, h *Harness) error {
+	h.ADO.RateLimit(2, 0)
+	h.Asana.RateLimit(5, 0)
+	ids := addAssigned(h, 20)
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if err := expectTasks(ctx, h, ids); err != nil {
+		return err
+	}
+	_, adoLimited := h.ADO.Requests()
+	_, asanaLimited := h.Asana.Requests()
+	if adoLimited == 0 || asanaLimited == 0 {
+		return fmt.Errorf("want rate limited requests on both sides, got %d on ado and %d on asana", adoLimited, asanaLimited)
+	}
+	return nil
+}
+
+// incremental checks that cycles after the first pick up the items changed since.
+func incremental(ctx context.Context, h *Harness) error {
+	ids := addAssigned(h, 5)
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	h.ADO.Update(ids[2], map[string]interface{}{ado.FieldTitle: "Changed"})
+	ids = append(ids, h.ADO.Add("Task", "Late", map[string]interface{}{
+		ado.FieldAssignedTo: Assignee("Alice", "alice@example.com"),
+	}))
+	rep, err := h.Run(ctx)
+	if err != nil {
+		return err
+	}
+	if rep.Full {
+		return fmt.Errorf("second cycle: want an incremental cycle, got a full one")
+	}
+	if rep.Created != 1 {
+		return fmt.Errorf("second cycle: want 1 task created, got %d", rep.Created)
+	}
+	return expectTasks(ctx, h, ids)
+}
+
+// comments mirrors comments both ways, with more ADO comments than fit in a page.
+func comments(ctx context.Context, h *Harness) error {
+	ids := addAssigned(h, 1)
+	for i := 1; i <= 3; i++ {
+		h.ADO.Comment(ids[0], "alice@example.com", fmt.Sprintf("ADO comment %d", i))
+	}
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	t, err := h.TaskOf(ctx, ids[0])
+	if err != nil {
+		return err
+	}
+	if got := h.Asana.Comments(t.GID); len(got) != 3 {
+		return fmt.Errorf("want 3 comments on the task, got %d", len(got))
+	}
+
+	h.Asana.Comment(t.GID, h.Asana.AddUser("Bob", "bob@example.com"), "Asana comment")
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	got := h.ADO.Comments(ids[0])
+	if len(got) != 4 || !strings.Contains(got[3].Text, "Asana comment") {
+		return fmt.Errorf("want the asana comment mirrored to the work item, got %d comments", len(got))
+	}
+	// Mirrored comments are not mirrored back.
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if n := len(h.Asana.Comments(t.GID)); n != 4 {
+		return fmt.Errorf("want 4 comments on the task, got %d", n)
+	}
+	return nil
+}
+
+// completion closes a work item from ADO and reopens it from Asana.
+func completion(ctx context.Context, h *Harness) error {
+	ids := addAssigned(h, 1)
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	h.ADO.Update(ids[0], map[string]interface{}{ado.FieldState: "Closed"})
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	t, err := h.TaskOf(ctx, ids[0])
+	if err != nil {
+		return err
+	}
+	if !t.Completed {
+		return fmt.Errorf("want the task of the closed work item completed")
+	}
+
+	h.Asana.Update(t.GID, asana.TaskRequest{Completed: asana.Bool(false)})
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if wi, _ := h.ADO.Item(ids[0]); wi.State() != "Active" {
+		return fmt.Errorf("want the work item of the reopened task active, got %s", wi.State())
+	}
+	return nil
+}
+
+// closing holds the task of a closed work item open for the grace period, then completes it.
+func closing(ctx context.Context, h *Harness) error {
+	ids := addAssigned(h, 1)
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	h.ADO.Update(ids[0], map[string]interface{}{
+		ado.FieldState:           "Closed",
+		ado.FieldStateChangeDate: time.Now().UTC().Format(time.RFC3339),
+	})
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if t, _ := h.TaskOf(ctx, ids[0]); t.Completed {
+		return fmt.Errorf("want the task held open during the grace period")
+	}
+
+	h.ADO.Update(ids[0], map[string]interface{}{
+		ado.FieldStateChangeDate: time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339),
+	})
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if t, _ := h.TaskOf(ctx, ids[0]); !t.Completed {
+		return fmt.Errorf("want the task completed once the grace period passed")
+	}
+	return nil
+}
+
+// recurrence closes and reopens a recurring work item and another one, checking that the recurring item gets
+// a task per occurrence while the other has its task reopened, and that a task completed in Asana is reopened.
+func recurrence(ctx context.Context, h *Harness) error {
+	h.Asana.AddUser("Alice", "alice@example.com")
+	chore := h.ADO.Add("Task", "Rotate the keys", map[string]interface{}{
+		ado.FieldAssignedTo: Assignee("Alice", "alice@example.com"),
+		ado.FieldTags:       "ops; Recurring",
+	})
+	once := h.ADO.Add("Task", "Migrate the database", map[string]interface{}{
+		ado.FieldAssignedTo: Assignee("Alice", "alice@example.com"),
+	})
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	first, err := h.TaskOf(ctx, chore)
+	if err != nil {
+		return err
+	}
+	other, err := h.TaskOf(ctx, once)
+	if err != nil {
+		return err
+	}
+	for _, state := range []string{"Closed", "Active"} {
+		for _, id := range []int{chore, once} {
+			h.ADO.Update(id, map[string]interface{}{
+				ado.FieldState:           state,
+				ado.FieldStateChangeDate: time.Now().UTC().Format(time.RFC3339Nano),
+			})
+		}
+		if _, err := h.Run(ctx); err != nil {
+			return err
+		}
+	}
+
+	second, err := h.TaskOf(ctx, chore)
+	switch {
+	case err != nil:
+		return err
+	case second.GID == first.GID || second.Completed:
+		return fmt.Errorf("want an open task for the new occurrence of the recurring item")
+	}
+	if t, _ := h.Asana.Task(first.GID); !t.Completed {
+		return fmt.Errorf("want the task of the first occurrence left completed")
+	}
+	earlier, err := h.Engine.Occurrences(ctx, chore)
+	if err != nil {
+		return err
+	}
+	if len(earlier) != 1 || earlier[0] != first.GID {
+		return fmt.Errorf("want the first occurrence %s recorded, got %v", first.GID, earlier)
+	}
+	if t, _ := h.TaskOf(ctx, once); t.GID != other.GID || t.Completed {
+		return fmt.Errorf("want the task of the item that does not recur reopened")
+	}
+
+	rep, err := h.Run(ctx)
+	if err != nil {
+		return err
+	}
+	if rep.Created != 0 {
+		return fmt.Errorf("want no task created once the occurrence has one, got %d", rep.Created)
+	}
+	h.Asana.Update(second.GID, asana.TaskRequest{Completed: asana.Bool(true)})
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if t, _ := h.TaskOf(ctx, chore); t.GID != second.GID || t.Completed {
+		return fmt.Errorf("want the task completed in asana while its item is open reopened")
+	}
+	if got := len(h.Asana.Tasks(h.Project)); got != 3 {
+		return fmt.Errorf("want 3 tasks, got %d", got)
+	}
+	return nil
+}
+
+// members makes an assignee who is not a member of the project a member of it and a follower of their task.
+func members(ctx context.Context, h *Harness) error {
+	ids := addAssigned(h, 1)
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	t, _ := h.TaskOf(ctx, ids[0])
+	if t.Assignee == nil || t.Assignee.Email != "alice@example.com" {
+		return fmt.Errorf("want the task assigned to alice")
+	}
+	if !contains(h.Asana.Members(h.Project), t.Assignee.GID) {
+		return fmt.Errorf("want alice made a member of the project")
+	}
+	if len(t.Followers) != 1 || t.Followers[0].GID != t.Assignee.GID {
+		return fmt.Errorf("want alice following the task, got %+v", t.Followers)
+	}
+	return nil
+}
+
+// fallbackAssignee assigns the tasks of assignees outside the project and without an Asana user to the
+// fallback assignee, commenting once on who owns them.
+func fallbackAssignee(ctx context.Context, h *Harness) error {
+	lead := h.Asana.AddUser("Lead", "lead@example.com")
+	h.Asana.AddMember(h.Project, lead)
+	ids := addAssigned(h, 1)
+	ids = append(ids, h.ADO.Add("Task", "Bob's item", map[string]interface{}{
+		ado.FieldAssignedTo: Assignee("Bob", "bob@example.com"),
+	}))
+	for cycle := 1; cycle <= 2; cycle++ {
+		if _, err := h.Run(ctx); err != nil {
+			return err
+		}
+		for _, id := range ids {
+			t, err := h.TaskOf(ctx, id)
+			if err != nil {
+				return err
+			}
+			if t.Assignee == nil || t.Assignee.GID != lead {
+				return fmt.Errorf("cycle %d: want the task of work item %d assigned to the fallback assignee", cycle, id)
+			}
+			if n := len(h.Asana.Comments(t.GID)); n != 1 {
+				return fmt.Errorf("cycle %d: want one comment naming the owner of work item %d, got %d", cycle, id, n)
+			}
+		}
+	}
+	return nil
+}
+
+// noOpWrites changes a work item in a way that does not change its task, which leaves the task untouched.
+func noOpWrites(ctx context.Context, h *Harness) error {
+	ids := addAssigned(h, 1)
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	before, _ := h.TaskOf(ctx, ids[0])
+	h.ADO.Update(ids[0], map[string]interface{}{"Microsoft.VSTS.Common.Priority": 1.0})
+	rep, err := h.Run(ctx)
+	if err != nil {
+		return err
+	}
+	if after, _ := h.TaskOf(ctx, ids[0]); rep.Updated != 0 || !after.ModifiedAt.Equal(before.ModifiedAt) {
+		return fmt.Errorf("want the task left untouched by an unrelated change, %d updated", rep.Updated)
+	}
+
+	h.ADO.Update(ids[0], map[string]interface{}{ado.FieldTitle: "Renamed"})
+	if rep, err = h.Run(ctx); err != nil {
+		return err
+	}
+	if rep.Updated != 1 || !strings.Contains(h.Asana.HTMLNotes(before.GID), "Renamed") {
+		return fmt.Errorf("want the task renamed and its notes rewritten, %d updated", rep.Updated)
+	}
+	return nil
+}
+
+// concurrentEdits edits a work item and a task while the engine writes them, which queues conflicts instead
+// of overwriting the edits.
+func concurrentEdits(ctx context.Context, h *Harness) error {
+	ids := addAssigned(h, 2)
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	first, _ := h.TaskOf(ctx, ids[0])
+	h.Asana.Update(first.GID, asana.TaskRequest{Name: asana.String(fmt.Sprintf("[AB#%d] From Asana", ids[0]))})
+	h.ADO.Race(ids[0], map[string]interface{}{ado.FieldTitle: "From ADO"})
+	second, _ := h.TaskOf(ctx, ids[1])
+	h.ADO.Update(ids[1], map[string]interface{}{ado.FieldTitle: "From ADO"})
+	h.Asana.Race(second.GID, asana.TaskRequest{Name: asana.String(fmt.Sprintf("[AB#%d] From Asana", ids[1]))})
+
+	rep, err := h.Run(ctx)
+	if err != nil {
+		return err
+	}
+	if wi, _ := h.ADO.Item(ids[0]); wi.Title() != "From ADO" {
+		return fmt.Errorf("want the concurrent ado edit kept, got title %q", wi.Title())
+	}
+	if t, _ := h.TaskOf(ctx, ids[1]); !strings.HasSuffix(t.Name, "From Asana") {
+		return fmt.Errorf("want the concurrent asana edit kept, got name %q", t.Name)
+	}
+	if len(rep.Conflicts) != 2 {
+		return fmt.Errorf("want both edits queued as conflicts, got %d", len(rep.Conflicts))
+	}
+	return nil
+}
+
+// attachments mirrors the files attached on either side to the other, skipping those above the size limit
+// and the copies of files the other side already has, and uploads nothing twice.
+func attachments(ctx context.Context, h *Harness) error {
+	id := addAssigned(h, 1)[0]
+	h.ADO.Attach(id, "spec.txt", []byte("the spec"))
+	h.ADO.Attach(id, "dump.bin", bytes.Repeat([]byte{1}, 2048))
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	t, err := h.TaskOf(ctx, id)
+	if err != nil {
+		return err
+	}
+	if files := h.Asana.Files(t.GID); len(files) != 1 || string(files["spec.txt"]) != "the spec" {
+		return fmt.Errorf("want only the spec mirrored to the task, got %v", names(files))
+	}
+
+	h.Asana.Attach(t.GID, "screenshot.png", []byte("a screenshot"))
+	h.Asana.Attach(t.GID, "spec copy.txt", []byte("the spec"))
+	for i := 0; i < 3; i++ {
+		if _, err := h.Run(ctx); err != nil {
+			return err
+		}
+	}
+	files := h.ADO.Files(id)
+	if len(files) != 3 || string(files["screenshot.png"]) != "a screenshot" || files["spec copy.txt"] != nil {
+		return fmt.Errorf("want the screenshot mirrored to the work item and the copy of the spec left out, got %v", names(files))
+	}
+	if files := h.Asana.Files(t.GID); len(files) != 3 || files["dump.bin"] != nil {
+		return fmt.Errorf("want no file mirrored back to the task, got %v", names(files))
+	}
+	if known, err := h.Store.Attachments(ctx, id); err != nil || len(known) != 3 {
+		return fmt.Errorf("want the spec, its copy and the screenshot recorded, got %+v, %v", known, err)
+	}
+	return nil
+}
+
+// names returns the sorted names of files.
+func names(files map[string][]byte) []string {
+	list := make([]string, 0, len(files))
+	for name := range files {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// transforms rewrites the priority scale of work items before they are synced.
+func transforms(ctx context.Context, h *Harness) error {
+	ids := addAssigned(h, 1)
+	h.ADO.Update(ids[0], map[string]interface{}{"Microsoft.VSTS.Common.Priority": 2.0})
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	t, _ := h.TaskOf(ctx, ids[0])
+	if notes := h.Asana.HTMLNotes(t.GID); !strings.Contains(notes, "Priority High") {
+		return fmt.Errorf("want the rewritten priority in the notes, got %q", notes)
+	}
+	if wi, _ := h.ADO.Item(ids[0]); wi.Fields["Microsoft.VSTS.Common.Priority"] != 2.0 {
+		return fmt.Errorf("want the work item left as it is, got priority %v", wi.Fields["Microsoft.VSTS.Common.Priority"])
+	}
+	return nil
+}
+
+// execPlugin rewrites work items by a plugin process, which the test binary serves when run with PluginArg.
+func execPlugin(ctx context.Context, h *Harness) error {
+	ids := addAssigned(h, 2)
+	for i := 0; i < 2; i++ {
+		if _, err := h.Run(ctx); err != nil {
+			return err
+		}
+	}
+	for _, id := range ids {
+		t, _ := h.TaskOf(ctx, id)
+		if t.Name != strings.ToUpper(t.Name) {
+			return fmt.Errorf("want the title rewritten by the plugin, got task %q", t.Name)
+		}
+		if wi, _ := h.ADO.Item(id); wi.Title() == strings.ToUpper(wi.Title()) {
+			return fmt.Errorf("want the work item left as it is, got title %q", wi.Title())
+		}
+	}
+	return nil
+}
+
+// boardColumns moves tasks between sections and updates their column and lane fields as the cards of their
+// work items move on the board.
+func boardColumns(ctx context.Context, h *Harness) error {
+	b := h.ADO.AddBoard(ProjectName+" Team", syncer.DefaultBoard, []string{"New", "Doing", "Review", "Done"}, []string{"Expedite"})
+	column := h.Asana.AddCustomField(h.Project, "Column", asana.CustomFieldEnum, "New", "Doing", "Review", "Done")
+	lane := h.Asana.AddCustomField(h.Project, "Lane", asana.CustomFieldText)
+	ids := addAssigned(h, 2)
+	h.ADO.Move(ids[0], b, "Doing", "")
+	h.ADO.Move(ids[1], b, "Review", "Expedite")
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if err := expectBoard(ctx, h, ids[0], "In Progress", column, "Doing", lane, ""); err != nil {
+		return err
+	}
+	if err := expectBoard(ctx, h, ids[1], "Review", column, "Review", lane, "Expedite"); err != nil {
+		return err
+	}
+
+	h.ADO.Move(ids[1], b, "Done", "")
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	return expectBoard(ctx, h, ids[1], "Done", column, "Done", lane, "")
+}
+
+// expectBoard checks the task of the work item is in the section and holds the column and the lane in the
+// given custom fields.
+func expectBoard(ctx context.Context, h *Harness, id int, section, columnField, column, laneField, lane string) error {
+	t, err := h.TaskOf(ctx, id)
+	if err != nil {
+		return err
+	}
+	if s := t.SectionIn(h.Project); s == nil || s.Name != section {
+		return fmt.Errorf("task of work item %d: want section %q, got %v", id, section, s)
+	}
+	if got := textValue(t, columnField); got != column {
+		return fmt.Errorf("task of work item %d: want column %q, got %q", id, column, got)
+	}
+	if got := textValue(t, laneField); got != lane {
+		return fmt.Errorf("task of work item %d: want lane %q, got %q", id, lane, got)
+	}
+	return nil
+}
+
+// textValue returns the value of the text or enum field on the task.
+func textValue(t asana.Task, field string) string {
+	for _, cf := range t.CustomFields {
+		switch {
+		case cf.GID != field:
+		case cf.EnumValue != nil:
+			return cf.EnumValue.Name
+		case cf.TextValue != nil:
+			return *cf.TextValue
+		}
+	}
+	return ""
+}
+
+// enumTranslation translates priorities to Asana options by table, falling back to the default option, and
+// writes options chosen in Asana back by the reverse table.
+func enumTranslation(ctx context.Context, h *Harness) error {
+	field := h.Asana.AddCustomField(h.Project, "Priority", asana.CustomFieldEnum, "High", "Medium", "Low")
+	ids := addAssigned(h, 3)
+	for i, p := range []float64{2, 3, 9} {
+		h.ADO.Update(ids[i], map[string]interface{}{"Microsoft.VSTS.Common.Priority": p})
+	}
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	for i, want := range []string{"High", "Medium", "Low"} {
+		t, _ := h.TaskOf(ctx, ids[i])
+		if got := textValue(t, field); got != want {
+			return fmt.Errorf("task of work item %d: want priority %q, got %q", ids[i], want, got)
+		}
+	}
+
+	for i, option := range map[int]string{0: "Medium", 1: "High"} {
+		t, _ := h.TaskOf(ctx, ids[i])
+		h.Asana.Update(t.GID, asana.TaskRequest{CustomFields: map[string]interface{}{field: h.Asana.Option(field, option)}})
+	}
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	for i, want := range []float64{3, 1, 9} {
+		if wi, _ := h.ADO.Item(ids[i]); wi.Fields["Microsoft.VSTS.Common.Priority"] != want {
+			return fmt.Errorf("work item %d: want priority %v written back, got %v", ids[i], want, wi.Fields["Microsoft.VSTS.Common.Priority"])
+		}
+	}
+	return nil
+}
+
+// drift finds a deleted task, a deleted work item and a task moved out of the project, then repairs them.
+func drift(ctx context.Context, h *Harness) error {
+	ids := addAssigned(h, 4)
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if rep, err := h.Engine.CheckDrift(ctx, false); err != nil {
+		return err
+	} else if len(rep.Drift) != 0 {
+		return fmt.Errorf("want no drift after a cycle, got %v", rep.Drift)
+	}
+
+	deleted, _ := h.TaskOf(ctx, ids[0])
+	h.Asana.DeleteTask(deleted.GID)
+	h.ADO.Delete(ids[1])
+	moved, _ := h.TaskOf(ctx, ids[2])
+	h.Asana.RemoveFromProject(moved.GID, h.Project)
+	rep, err := h.Engine.CheckDrift(ctx, false)
+	if err != nil {
+		return err
+	}
+	want := map[int]syncer.DriftKind{ids[0]: syncer.DriftMissingTask, ids[1]: syncer.DriftMissingItem, ids[2]: syncer.DriftDetached}
+	if len(rep.Drift) != len(want) || rep.Score() != 0.75 {
+		return fmt.Errorf("want 3 of 4 mappings drifted, got %v", rep.Drift)
+	}
+	for _, d := range rep.Drift {
+		if d.Kind != want[d.ADOID] || d.Repaired {
+			return fmt.Errorf("work item %d: want unrepaired %s drift, got %+v", d.ADOID, want[d.ADOID], d)
+		}
+	}
+
+	if rep, err = h.Engine.CheckDrift(ctx, true); err != nil {
+		return err
+	}
+	if rep.Repaired() != 3 {
+		return fmt.Errorf("want 3 drifted mappings repaired, got %v", rep.Drift)
+	}
+	if _, err := h.Store.Get(ctx, ids[1]); !errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("want the mapping of the deleted work item forgotten, got %v", err)
+	}
+	// The task of the deleted work item stays, as the pair has no removal policy.
+	if got := len(h.Asana.Tasks(h.Project)); got != 4 {
+		return fmt.Errorf("want a new task and the moved one back in the project, got %d tasks", got)
+	}
+	if t, err := h.TaskOf(ctx, ids[0]); err != nil || t.GID == deleted.GID {
+		return fmt.Errorf("want a new task for work item %d, got %v", ids[0], err)
+	}
+	if rep, err = h.Engine.CheckDrift(ctx, false); err != nil {
+		return err
+	} else if len(rep.Drift) != 0 {
+		return fmt.Errorf("want no drift after repairing, got %v", rep.Drift)
+	}
+	return nil
+}
+
+// batching syncs both ways through batching clients, then falls back to single calls once the Asana batch
+// API is rejected.
+func batching(ctx context.Context, h *Harness) error {
+	h.Batch(50 * time.Millisecond)
+	ids := addAssigned(h, 12)
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if err := expectTasks(ctx, h, ids); err != nil {
+		return err
+	}
+	if h.Asana.Batches() == 0 {
+		return fmt.Errorf("want the tasks created in batches")
+	}
+
+	for _, id := range ids {
+		t, _ := h.TaskOf(ctx, id)
+		h.Asana.Update(t.GID, asana.TaskRequest{Name: asana.String(fmt.Sprintf("[AB#%d] Renamed %d", id, id))})
+	}
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if wi, _ := h.ADO.Item(id); wi.Title() != fmt.Sprintf("Renamed %d", id) {
+			return fmt.Errorf("work item %d: want the title written back, got %q", id, wi.Title())
+		}
+	}
+	if h.ADO.Batches() == 0 {
+		return fmt.Errorf("want the work items updated in batches")
+	}
+
+	h.Asana.DisableBatch()
+	for _, id := range ids {
+		h.ADO.Update(id, map[string]interface{}{ado.FieldTitle: fmt.Sprintf("Unbatched %d", id)})
+	}
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	return expectTasks(ctx, h, ids)
+}
+
+// caching lists the users of the workspace once for cycles within the cache TTL, and again once it passed so
+// users added since are matched.
+func caching(ctx context.Context, h *Harness) error {
+	const ttl = 300 * time.Millisecond
+	h.Cache(ttl)
+	ids := addAssigned(h, 2)
+	for i := 0; i < 2; i++ {
+		if _, err := h.Run(ctx); err != nil {
+			return err
+		}
+	}
+	if err := expectTasks(ctx, h, ids); err != nil {
+		return err
+	}
+	if n := h.Asana.UserLists(); n != 1 {
+		return fmt.Errorf("want the users listed once within the ttl, got %d lists", n)
+	}
+
+	h.Asana.AddUser("Bob", "bob@example.com")
+	ids = append(ids, h.ADO.Add("Task", "Item 3", map[string]interface{}{
+		ado.FieldAssignedTo: Assignee("Bob", "bob@example.com"),
+	}))
+	time.Sleep(ttl)
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if n := h.Asana.UserLists(); n != 2 {
+		return fmt.Errorf("want the users listed again once the ttl passed, got %d lists", n)
+	}
+	return expectTasks(ctx, h, ids)
+}
+
+// replaying records a cycle syncing changes on both sides and replays it from the recording, checking the
+// replay made the same changes without sending a request to either fake.
+func replaying(ctx context.Context, h *Harness) error {
+	ids := addAssigned(h, 3)
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	h.ADO.Update(ids[0], map[string]interface{}{ado.FieldTitle: "Changed in ADO"})
+	t, _ := h.TaskOf(ctx, ids[1])
+	h.Asana.Update(t.GID, asana.TaskRequest{Name: asana.String(fmt.Sprintf("[AB#%d] Changed in Asana", ids[1]))})
+	h.ADO.Add("Task", "New item", map[string]interface{}{ado.FieldAssignedTo: Assignee("Alice", "alice@example.com")})
+
+	rec := replay.NewRecorder()
+	snap, err := h.Store.Export(ctx)
+	if err != nil {
+		return err
+	}
+	rec.Snapshot("", snap)
+	recorded, err := h.EngineWith(h.Store, func(provider string) http.RoundTripper { return rec.Transport(provider, nil) }).Run(ctx)
+	if err != nil {
+		return err
+	}
+	if recorded.Created != 1 || recorded.Updated != 2 {
+		return fmt.Errorf("want the recorded cycle to create 1 and update 2, got %d and %d", recorded.Created, recorded.Updated)
+	}
+	var buf bytes.Buffer
+	if err := rec.Save(&buf); err != nil {
+		return err
+	}
+
+	p, err := replay.Load(&buf)
+	if err != nil {
+		return err
+	}
+	st := store.NewMemory()
+	if err := st.Import(ctx, p.Snapshot("")); err != nil {
+		return err
+	}
+	adoRequests, _ := h.ADO.Requests()
+	asanaRequests, _ := h.Asana.Requests()
+	replayed, err := h.EngineWith(st, p.Transport).Run(ctx)
+	if err != nil {
+		return err
+	}
+	if replayed.Created != recorded.Created || replayed.Updated != recorded.Updated || len(replayed.Failures) != len(recorded.Failures) {
+		return fmt.Errorf("want the replay to create %d and update %d, got %d and %d with %d failures",
+			recorded.Created, recorded.Updated, replayed.Created, replayed.Updated, len(replayed.Failures))
+	}
+	if n := p.Unplayed(); n != 0 {
+		return fmt.Errorf("want every recorded response replayed, %d were not", n)
+	}
+	if n, _ := h.ADO.Requests(); n != adoRequests {
+		return fmt.Errorf("want the replay to send no requests to ado, got %d", n-adoRequests)
+	}
+	if n, _ := h.Asana.Requests(); n != asanaRequests {
+		return fmt.Errorf("want the replay to send no requests to asana, got %d", n-asanaRequests)
+	}
+	return nil
+}
+
+// trigger runs the first cycle of a manager, then triggers a cycle long before the next one is due.
+func trigger(ctx context.Context, h *Harness) error {
+	m, err := h.Manager(nil, nil)
+	if err != nil {
+		return err
+	}
+	if err := m.Trigger("unknown"); !errors.Is(err, syncer.ErrUnknownPair) {
+		return fmt.Errorf("want triggering an unknown pair to fail with ErrUnknownPair, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cycles := make(chan error, 10)
+	go m.Run(ctx, func(e *syncer.Engine, rep *syncer.Report, err error) { cycles <- err })
+	next := func() error {
+		select {
+		case err := <-cycles:
+			return err
+		case <-time.After(5 * time.Second):
+			return errors.New("want a cycle to run, none did")
+		}
+	}
+	if err := next(); err != nil {
+		return err
+	}
+
+	ids := addAssigned(h, 1)
+	if err := m.Trigger(h.Config.Name); err != nil {
+		return err
+	}
+	if err := next(); err != nil {
+		return err
+	}
+	return expectTasks(ctx, h, ids)
+}
+
+// singleItem syncs one renamed work item, found by its ID and by its task, without a cycle and checks the
+// diff of its states shows the task renamed.
+func singleItem(ctx context.Context, h *Harness) error {
+	ids := addAssigned(h, 2)
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	m, err := h.Manager(nil, nil)
+	if err != nil {
+		return err
+	}
+	h.ADO.Update(ids[0], map[string]interface{}{ado.FieldTitle: "Renamed"})
+	h.ADO.Update(ids[1], map[string]interface{}{ado.FieldTitle: "Untouched"})
+	t, _ := h.TaskOf(ctx, ids[0])
+	org, id, err := m.TaskItem(ctx, t.GID)
+	if err != nil {
+		return err
+	}
+	if id != ids[0] {
+		return fmt.Errorf("want task %s mapped to work item %d, got %d", t.GID, ids[0], id)
+	}
+
+	before, err := m.ItemState(ctx, org, id)
+	if err != nil {
+		return err
+	}
+	if _, err := m.SyncItem(ctx, org, id); err != nil {
+		return err
+	}
+	after, err := m.ItemState(ctx, org, id)
+	if err != nil {
+		return err
+	}
+	want, renamed := fmt.Sprintf("[AB#%d] Renamed", id), false
+	for _, c := range before.Diff(after) {
+		renamed = renamed || (c.System == syncer.SystemAsana && c.Field == "name" && c.After == want)
+	}
+	if !renamed {
+		return fmt.Errorf("want the diff to show the task renamed to %q, got %+v", want, before.Diff(after))
+	}
+	if other, _ := h.TaskOf(ctx, ids[1]); strings.Contains(other.Name, "Untouched") {
+		return fmt.Errorf("work item %d was synced along with %d", ids[1], id)
+	}
+	return nil
+}
+
+// backLink links a work item to its task, then to the task created again after the first was deleted.
+func backLink(ctx context.Context, h *Harness) error {
+	ids := addAssigned(h, 1)
+	check := func(when string) error {
+		t, err := h.TaskOf(ctx, ids[0])
+		if err != nil {
+			return err
+		}
+		wi, _ := h.ADO.Item(ids[0])
+		var links []string
+		for _, r := range wi.Relations {
+			if r.Rel == ado.RelHyperlink {
+				links = append(links, r.URL)
+			}
+		}
+		if len(links) != 1 || links[0] != t.PermalinkURL {
+			return fmt.Errorf("%s: want the work item linked to %s only, got %v", when, t.PermalinkURL, links)
+		}
+		return nil
+	}
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if err := check("created"); err != nil {
+		return err
+	}
+	t, _ := h.TaskOf(ctx, ids[0])
+	h.Asana.DeleteTask(t.GID)
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if err := check("recreated"); err != nil {
+		return err
+	}
+	// The link is not written again once it is current.
+	before, _ := h.ADO.Item(ids[0])
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if after, _ := h.ADO.Item(ids[0]); after.Rev != before.Rev {
+		return fmt.Errorf("want the linked work item left alone, went from revision %d to %d", before.Rev, after.Rev)
+	}
+	return check("unchanged")
+}
+
+// calendar translates target dates into due dates on the day they fall on in Sydney, snapped to working
+// days, and writes a due date set in Asana back as midnight in Sydney.
+func calendar(ctx context.Context, h *Harness) error {
+	h.Asana.AddUser("Alice", "alice@example.com")
+	want := map[int]string{}
+	for target, due := range map[string]string{
+		"2024-06-04T13:00:00Z": "2024-06-04", // 23:00 on a Tuesday in Sydney, though nearer to UTC midnight of the 5th.
+		"2024-06-08T00:00:00Z": "2024-06-07", // A Saturday, snapped back to Friday.
+		"2024-06-10T01:00:00Z": "2024-06-11", // A holiday Monday, snapped to Tuesday as Sunday is off too.
+	} {
+		id := h.ADO.Add("Task", "Due "+target, map[string]interface{}{
+			ado.FieldAssignedTo: Assignee("Alice", "alice@example.com"), ado.FieldTargetDate: target,
+		})
+		want[id] = due
+	}
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	for id, due := range want {
+		if t, _ := h.TaskOf(ctx, id); t.DueOn != due {
+			return fmt.Errorf("work item %d: want due on %s, got %q", id, due, t.DueOn)
+		}
+	}
+
+	var id int
+	for id = range want {
+		break
+	}
+	t, _ := h.TaskOf(ctx, id)
+	h.Asana.Update(t.GID, asana.TaskRequest{DueOn: asana.DateOf("2024-06-12")})
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if wi, _ := h.ADO.Item(id); wi.String(ado.FieldTargetDate) != "2024-06-11T14:00:00Z" {
+		return fmt.Errorf("want the target date set to midnight in Sydney, got %q", wi.String(ado.FieldTargetDate))
+	}
+	return nil
+}
+
+// approvals creates an approval task and a milestone for work items of types with subtypes, then sets the
+// state of the release from its approval status and its approval status from its state.
+func approvals(ctx context.Context, h *Harness) error {
+	h.Asana.AddUser("Alice", "alice@example.com")
+	alice := map[string]interface{}{ado.FieldAssignedTo: Assignee("Alice", "alice@example.com")}
+	release := h.ADO.Add("Release", "Release 1.0", alice)
+	milestone := h.ADO.Add("Milestone", "Beta", alice)
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	t, err := h.TaskOf(ctx, release)
+	if err != nil {
+		return err
+	}
+	if t.ResourceSubtype != asana.SubtypeApproval || t.ApprovalStatus != asana.ApprovalPending {
+		return fmt.Errorf("want a pending approval task for the release, got a %s task %q", t.ResourceSubtype, t.ApprovalStatus)
+	}
+	if m, _ := h.TaskOf(ctx, milestone); m.ResourceSubtype != asana.SubtypeMilestone {
+		return fmt.Errorf("want a milestone for the milestone, got a %s task", m.ResourceSubtype)
+	}
+
+	h.Asana.Update(t.GID, asana.TaskRequest{ApprovalStatus: asana.String(asana.ApprovalApproved)})
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if wi, _ := h.ADO.Item(release); wi.State() != "Closed" {
+		return fmt.Errorf("want the work item of the approved task closed, got %s", wi.State())
+	}
+
+	h.ADO.Update(release, map[string]interface{}{ado.FieldState: "Active"})
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if t, _ = h.TaskOf(ctx, release); t.ApprovalStatus != asana.ApprovalChangesRequested || t.Completed {
+		return fmt.Errorf("want changes requested on the task of the reactivated work item, got %q", t.ApprovalStatus)
+	}
+	return nil
+}
+
+// anchors anchors a legacy task matched by name and new tasks, then matches a task by its anchor once its
+// name and mapping are gone.
+func anchors(ctx context.Context, h *Harness) error {
+	field := h.Asana.AddCustomField(h.Project, "ADO ID", asana.CustomFieldNumber)
+	ids := addAssigned(h, 3)
+	legacy := h.Asana.AddTask(h.Project, fmt.Sprintf("[AB#%d] Item 1", ids[0]))
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if err := expectTasks(ctx, h, ids); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		t, _ := h.TaskOf(ctx, id)
+		if id == ids[0] && t.GID != legacy {
+			return fmt.Errorf("want the legacy task matched to work item %d, got task %s", id, t.GID)
+		}
+		if got := anchorValue(t, field); got != id {
+			return fmt.Errorf("task of work item %d: want anchor %d, got %d", id, id, got)
+		}
+	}
+
+	t, _ := h.TaskOf(ctx, ids[1])
+	h.Asana.Update(t.GID, asana.TaskRequest{Name: asana.String("Renamed in Asana")})
+	if err := h.Store.Delete(ctx, ids[1]); err != nil {
+		return err
+	}
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if err := expectTasks(ctx, h, ids); err != nil {
+		return err
+	}
+	if again, _ := h.TaskOf(ctx, ids[1]); again.GID != t.GID {
+		return fmt.Errorf("want work item %d matched to its anchored task %s, got %s", ids[1], t.GID, again.GID)
+	}
+	return nil
+}
+
+// effort syncs the completed and remaining work of a work item to number fields, then writes remaining work
+// edited in Asana back to ADO.
+func effort(ctx context.Context, h *Harness) error {
+	completed := h.Asana.AddCustomField(h.Project, "Completed", asana.CustomFieldNumber)
+	remaining := h.Asana.AddCustomField(h.Project, "Remaining", asana.CustomFieldNumber)
+	ids := addAssigned(h, 1)
+	h.ADO.Update(ids[0], map[string]interface{}{ado.FieldCompletedWork: 2.5, ado.FieldRemainingWork: 6.0})
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	t, err := h.TaskOf(ctx, ids[0])
+	if err != nil {
+		return err
+	}
+	if got := numberValue(t, completed); got != 2.5 {
+		return fmt.Errorf("want 2.5 hours completed on the task, got %v", got)
+	}
+	if got := numberValue(t, remaining); got != 6 {
+		return fmt.Errorf("want 6 hours remaining on the task, got %v", got)
+	}
+
+	h.Asana.Update(t.GID, asana.TaskRequest{CustomFields: map[string]interface{}{remaining: 4.0}})
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	wi, _ := h.ADO.Item(ids[0])
+	if got := wi.Fields[ado.FieldRemainingWork]; got != 4.0 {
+		return fmt.Errorf("want the remaining work edited in asana written back, got %v", got)
+	}
+	return nil
+}
+
+// rollup sums the estimates of two children onto the task of their parent, then follows a child's edit.
+func rollup(ctx context.Context, h *Harness) error {
+	points := h.Asana.AddCustomField(h.Project, "Total points", asana.CustomFieldNumber)
+	remaining := h.Asana.AddCustomField(h.Project, "Remaining", asana.CustomFieldNumber)
+	ids := addAssigned(h, 3)
+	parent, children := ids[0], ids[1:]
+	for i, c := range children {
+		h.ADO.Link(c, ado.RelParent, parent)
+		h.ADO.Link(parent, ado.RelChild, c)
+		h.ADO.Update(c, map[string]interface{}{ado.FieldStoryPoints: float64(3 + 2*i), ado.FieldRemainingWork: float64(2 + 2*i)})
+	}
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	t, err := h.TaskOf(ctx, parent)
+	if err != nil {
+		return err
+	}
+	if got := numberValue(t, points); got != 8 {
+		return fmt.Errorf("want 8 points rolled up onto the parent, got %v", got)
+	}
+	if got := numberValue(t, remaining); got != 6 {
+		return fmt.Errorf("want 6 hours remaining rolled up onto the parent, got %v", got)
+	}
+
+	h.ADO.Update(children[1], map[string]interface{}{ado.FieldRemainingWork: 1.0})
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if t, err = h.TaskOf(ctx, parent); err != nil {
+		return err
+	}
+	if got := numberValue(t, remaining); got != 3 {
+		return fmt.Errorf("want the rollup to follow the edited child to 3 hours, got %v", got)
+	}
+	return nil
+}
+
+// organizations syncs a second organization whose work item IDs collide with those of the first, then
+// routes an event to the item of the organization it came from.
+func organizations(ctx context.Context, h *Harness) error {
+	contoso := NewADO("Contoso")
+	defer contoso.Close()
+	ids := addAssigned(h, 2)
+	other := h.Config
+	other.Name, other.ADOConnection, other.ADOProject = "contoso", "contoso", "Contoso"
+	other.AsanaProject = h.Asana.AddProject("Contoso", "To do", "Doing", "Done")
+	otherIDs := []int{
+		contoso.Add("Task", "Contoso 1", map[string]interface{}{ado.FieldAssignedTo: Assignee("Alice", "alice@example.com")}),
+		contoso.Add("Task", "Contoso 2", map[string]interface{}{ado.FieldAssignedTo: Assignee("Alice", "alice@example.com")}),
+	}
+	if otherIDs[0] != ids[0] {
+		return fmt.Errorf("want the work item IDs of both organizations to collide, got %d and %d", ids[0], otherIDs[0])
+	}
+	otherStore := store.NewMemory()
+	m, err := h.Manager([]syncer.Config{other}, map[string]syncer.ADOConnection{
+		"contoso": {OrgURL: contoso.URL(), ADO: adoClient(contoso), Store: otherStore},
+	})
+	if err != nil {
+		return err
+	}
+	for _, e := range m.Engines() {
+		rep, err := e.Run(ctx)
+		if err != nil {
+			return fmt.Errorf("sync pair %q: %w", e.Name(), err)
+		}
+		if len(rep.Failures) > 0 {
+			return fmt.Errorf("sync pair %q: %d work items failed to sync", e.Name(), len(rep.Failures))
+		}
+	}
+	if err := expectTasks(ctx, h, ids); err != nil {
+		return err
+	}
+	if got := len(h.Asana.Tasks(other.AsanaProject)); got != len(otherIDs) {
+		return fmt.Errorf("want %d tasks in the contoso project, got %d", len(otherIDs), got)
+	}
+
+	contoso.Update(otherIDs[0], map[string]interface{}{ado.FieldTitle: "Renamed in Contoso"})
+	if _, err := m.SyncItem(ctx, contoso.URL()+"/", otherIDs[0]); err != nil {
+		return err
+	}
+	mp, err := otherStore.Get(ctx, otherIDs[0])
+	if err != nil {
+		return err
+	}
+	if t, _ := h.Asana.Task(mp.AsanaGID); t.Name != fmt.Sprintf("[AB#%d] Renamed in Contoso", otherIDs[0]) {
+		return fmt.Errorf("want the event synced to the contoso task, got %q", t.Name)
+	}
+	if t, _ := h.TaskOf(ctx, ids[0]); t.Name != fmt.Sprintf("[AB#%d] Item 1", ids[0]) {
+		return fmt.Errorf("want the task of the colliding work item left alone, got %q", t.Name)
+	}
+	return nil
+}
+
+// anchorValue returns the value of the number field on the task.
+func anchorValue(t asana.Task, field string) int {
+	return int(numberValue(t, field))
+}
+
+// numberValue returns the value of the number field on the task.
+func numberValue(t asana.Task, field string) float64 {
+	for _, cf := range t.CustomFields {
+		if cf.GID == field && cf.NumberValue != nil {
+			return *cf.NumberValue
+		}
+	}
+	return 0
+}
+
+// intake creates a work item for a tagged Asana task, which then syncs like a task created for a work item,
+// and leaves untagged tasks alone.
+func intake(ctx context.Context, h *Harness) error {
+	alice := h.Asana.AddUser("Alice", "alice@example.com")
+	gid := h.Asana.AddTask(h.Project, "Fix the login page")
+	h.Asana.Update(gid, asana.TaskRequest{Assignee: asana.String(alice), Notes: asana.String("It <breaks> on mobile.")})
+	h.Asana.Tag(gid, "intake")
+	other := h.Asana.AddTask(h.Project, "Buy cake")
+	rep, err := h.Run(ctx)
+	if err != nil {
+		return err
+	}
+	if rep.Intake != 1 {
+		return fmt.Errorf("want 1 work item taken in, got %d", rep.Intake)
+	}
+	var id int
+	for _, wi := range h.ADO.Items() {
+		if wi.Title() == "Fix the login page" {
+			id = wi.ID
+		}
+	}
+	wi, ok := h.ADO.Item(id)
+	if !ok {
+		return fmt.Errorf("want a work item created for the tagged task")
+	}
+	if typ := wi.Fields[ado.FieldWorkItemType]; typ != "User Story" {
+		return fmt.Errorf("want a User Story created, got %v", typ)
+	}
+	if d, _ := wi.Fields[ado.FieldDescription].(string); !strings.Contains(d, "&lt;breaks&gt;") {
+		return fmt.Errorf("want the notes as the escaped description, got %q", d)
+	}
+	if len(h.ADO.Items()) != 1 {
+		return fmt.Errorf("want the untagged task %s left alone, got %d work items", other, len(h.ADO.Items()))
+	}
+	t, err := h.TaskOf(ctx, id)
+	if err != nil {
+		return err
+	}
+	if t.GID != gid {
+		return fmt.Errorf("want work item %d mapped to task %s, got %s", id, gid, t.GID)
+	}
+	if want := fmt.Sprintf("[AB#%d] Fix the login page", id); t.Name != want {
+		return fmt.Errorf("want the task renamed to %q, got %q", want, t.Name)
+	}
+
+	// From now on the pair syncs like any other, and the task is not taken in twice.
+	h.Asana.Update(gid, asana.TaskRequest{Name: asana.String(fmt.Sprintf("[AB#%d] Fix the login and signup pages", id))})
+	if rep, err = h.Run(ctx); err != nil {
+		return err
+	}
+	if rep.Intake != 0 {
+		return fmt.Errorf("want no work item taken in again, got %d", rep.Intake)
+	}
+	if wi, _ := h.ADO.Item(id); wi.Title() != "Fix the login and signup pages" {
+		return fmt.Errorf("want the rename synced to the work item, got %q", wi.Title())
+	}
+	return nil
+}
+
+// titleDirection syncs titles from Asana only, so a task whose work item reference is edited away keeps its
+// name rather than having the reference written back.
+func titleDirection(ctx context.Context, h *Harness) error {
+	id := addAssigned(h, 1)[0]
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	t, err := h.TaskOf(ctx, id)
+	if err != nil {
+		return err
+	}
+	h.Asana.Update(t.GID, asana.TaskRequest{Name: asana.String("Item 1")})
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if t, _ = h.Asana.Task(t.GID); t.Name != "Item 1" {
+		return fmt.Errorf("want the task name left as edited in Asana, got %q", t.Name)
+	}
+	if wi, _ := h.ADO.Item(id); wi.Title() != "Item 1" {
+		return fmt.Errorf("want the work item title unchanged, got %q", wi.Title())
+	}
+	return nil
+}
+
+// encryptedStore syncs through a store encrypting its records, leaving no title readable in the underlying
+// store, then rotates the key and keeps syncing with the new one only.
+func encryptedStore(ctx context.Context, h *Harness) error {
+	ids := addAssigned(h, 2)
+	oldKey, _ := secret.New("old passphrase")
+	newKey, _ := secret.New("new passphrase")
+	raw := store.NewMemory()
+	h.Encrypt(raw, oldKey)
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		m, err := raw.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(m.Title, "enc:v1:") || strings.Contains(m.Title, "Item") {
+			return fmt.Errorf("work item %d: want its title stored encrypted, got %q", id, m.Title)
+		}
+		if m, err = h.Store.Get(ctx, id); err != nil || m.Title != fmt.Sprintf("Item %d", id) {
+			return fmt.Errorf("work item %d: want its title read decrypted, got %q (%v)", id, m.Title, err)
+		}
+	}
+
+	// Rotating: the new key is current with the old one as a previous key until the store is rekeyed.
+	h.Encrypt(raw, newKey, oldKey)
+	n, err := h.Store.(*store.Encrypted).Rekey(ctx)
+	if err != nil {
+		return err
+	}
+	if n < len(ids) {
+		return fmt.Errorf("want at least the %d titles rekeyed, got %d values", len(ids), n)
+	}
+	if _, err := store.NewEncrypted(raw, oldKey).Get(ctx, ids[0]); err == nil {
+		return errors.New("want the old key unable to read the rekeyed store")
+	}
+	h.Encrypt(raw, newKey)
+	rep, err := h.Run(ctx)
+	if err != nil {
+		return err
+	}
+	if rep.Created != 0 {
+		return fmt.Errorf("want the tasks found through the rekeyed mappings, got %d created", rep.Created)
+	}
+	if n, err := h.Store.(*store.Encrypted).Rekey(ctx); err != nil || n != 0 {
+		return fmt.Errorf("want nothing left to rekey, got %d values (%v)", n, err)
+	}
+	return nil
+}
+
+// encryptedRecords writes a record of every kind through an encrypted store, and to the underlying store
+// before encryption was enabled, and checks that the underlying store holds none of their contents once
+// the store is rekeyed, while they are read back through the encrypted store as they were written.
+func encryptedRecords(ctx context.Context, h *Harness) error {
+	// The records belong to another pair, so the cycle leaves them alone.
+	const plain, pair = "Confidential", "archive"
+	pts := 3.0
+	now := time.Now().UTC().Truncate(time.Second)
+	records := func(id int) *store.Snapshot {
+		v := fmt.Sprintf("%s %d", plain, id)
+		return &store.Snapshot{
+			Mappings:    []store.Mapping{{ADOID: id, AsanaGID: fmt.Sprint(id), Title: v, Tags: []string{v}, Pair: pair}},
+			Conflicts:   []store.Conflict{{ADOID: id, AsanaGID: fmt.Sprint(id), Field: "title", ADOValue: v, AsanaValue: v}},
+			Comments:    []store.CommentMapping{{ADOID: id, ADOCommentID: id, AsanaStoryGID: fmt.Sprint(id), Origin: v}},
+			Attachments: []store.AttachmentMapping{{ADOID: id, ADOURL: "https://ado.example/" + v, AsanaGID: fmt.Sprint(id), Name: v, Origin: store.OriginADO}},
+			Retries:     []store.Retry{{ADOID: id, Pair: pair, Attempts: 1, NextAttempt: now, LastError: v}},
+			Projects:    []store.Project{{Pair: pair, Name: v, GID: fmt.Sprint(id), Created: now}},
+			Audit:       []store.AuditRecord{{Time: now, Pair: pair, System: store.OriginAsana, Action: "update", ADOID: id, Changes: []store.FieldChange{{Field: "name", Before: v, After: v}}}},
+			Journal:     []store.JournalEntry{{Time: now, Pair: pair, Sources: []string{store.OriginADO}, ADOID: id, Item: v, Task: v}},
+			Samples:     []store.Sample{{Time: now, Pair: pair, ADOID: id, Rev: 1, Type: v, State: v, Iteration: v, Area: v, Points: &pts}},
+			Settings:    map[string]string{fmt.Sprintf("scenario:%d", id): v},
+		}
+	}
+	key, err := secret.New("records passphrase")
+	if err != nil {
+		return err
+	}
+	raw := store.NewMemory()
+	if err := raw.Import(ctx, records(9001)); err != nil {
+		return err
+	}
+	h.Encrypt(raw, key)
+	if err := h.Store.Import(ctx, records(9002)); err != nil {
+		return err
+	}
+	ids := addAssigned(h, 2)
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if n, err := h.Store.(*store.Encrypted).Rekey(ctx); err != nil || n == 0 {
+		return fmt.Errorf("want the records written before encryption rekeyed, got %d values (%v)", n, err)
+	}
+
+	snap, err := raw.Export(ctx)
+	if err != nil {
+		return err
+	}
+	for kind, n := range map[string]int{
+		"mappings": len(snap.Mappings), "conflicts": len(snap.Conflicts), "comments": len(snap.Comments),
+		"attachments": len(snap.Attachments), "retries": len(snap.Retries), "projects": len(snap.Projects),
+		"audit records": len(snap.Audit), "journal entries": len(snap.Journal), "samples": len(snap.Samples),
+		"settings": len(snap.Settings),
+	} {
+		if n < 2 {
+			return fmt.Errorf("want the %s of both writes in the underlying store, got %d", kind, n)
+		}
+	}
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	for _, v := range []string{plain, fmt.Sprintf("Item %d", ids[0]), fmt.Sprintf("Item %d", ids[1])} {
+		if strings.Contains(string(b), v) {
+			return fmt.Errorf("want no %q in the underlying store, got %s", v, b)
+		}
+	}
+	for k, v := range snap.Settings {
+		if v != "" && !strings.HasPrefix(v, "enc:v1:") {
+			return fmt.Errorf("want setting %s stored encrypted, got %q", k, v)
+		}
+	}
+
+	// Through the encrypted store every record reads as it was written, and projects are still looked up
+	// by name.
+	for _, id := range []int{9001, 9002} {
+		want := records(id)
+		v := fmt.Sprintf("%s %d", plain, id)
+		if m, err := h.Store.Get(ctx, id); err != nil || m.Title != v || len(m.Tags) != 1 || m.Tags[0] != v {
+			return fmt.Errorf("mapping %d: want %q, got %+v (%v)", id, v, m, err)
+		}
+		if c, err := h.Store.Conflict(ctx, id, "title"); err != nil || c.ADOValue != v || c.AsanaValue != v {
+			return fmt.Errorf("conflict %d: want %q, got %+v (%v)", id, v, c, err)
+		}
+		if c, err := h.Store.CommentByAsana(ctx, fmt.Sprint(id)); err != nil || c.Origin != v {
+			return fmt.Errorf("comment %d: want %q, got %+v (%v)", id, v, c, err)
+		}
+		if c, err := h.Store.CommentByADO(ctx, id, id); err != nil || c.Origin != v {
+			return fmt.Errorf("comment %d: want %q, got %+v (%v)", id, v, c, err)
+		}
+		if a, err := h.Store.Attachments(ctx, id); err != nil || len(a) != 1 || a[0] != want.Attachments[0] {
+			return fmt.Errorf("attachment %d: want %+v, got %+v (%v)", id, want.Attachments, a, err)
+		}
+		if r, err := h.Store.Retry(ctx, id); err != nil || r.LastError != v {
+			return fmt.Errorf("retry %d: want %q, got %+v (%v)", id, v, r, err)
+		}
+		if p, err := h.Store.Project(ctx, pair, v); err != nil || p.GID != fmt.Sprint(id) {
+			return fmt.Errorf("project %q: want it found, got %+v (%v)", v, p, err)
+		}
+		if a, err := h.Store.Audit(ctx, store.AuditFilter{ADOID: id}); err != nil || len(a) != 1 || a[0].Changes[0] != want.Audit[0].Changes[0] {
+			return fmt.Errorf("audit %d: want %+v, got %+v (%v)", id, want.Audit, a, err)
+		}
+		if j, err := h.Store.Journal(ctx, store.JournalFilter{ADOID: id}); err != nil || len(j) != 1 || j[0].Item != v || j[0].Task != v {
+			return fmt.Errorf("journal %d: want %q, got %+v (%v)", id, v, j, err)
+		}
+		if smp, err := h.Store.Samples(ctx, store.SampleFilter{ADOID: id}); err != nil || len(smp) != 1 || smp[0].Type != v || smp[0].State != v || smp[0].Iteration != v || smp[0].Area != v {
+			return fmt.Errorf("sample %d: want %q, got %+v (%v)", id, v, smp, err)
+		}
+		if got, err := h.Store.Setting(ctx, fmt.Sprintf("scenario:%d", id)); err != nil || got != v {
+			return fmt.Errorf("setting %d: want %q, got %q (%v)", id, v, got, err)
+		}
+	}
+	if err := h.Store.PutProject(ctx, store.Project{Pair: pair, Name: plain + " 9001", GID: "10", Created: now}); err != nil {
+		return err
+	}
+	projects, err := h.Store.Projects(ctx)
+	if err != nil {
+		return err
+	}
+	if len(projects) != 2 || projects[0].Name != plain+" 9001" || projects[0].GID != "10" || projects[1].Name != plain+" 9002" {
+		return fmt.Errorf("want the project registered again replaced, in order, got %+v", projects)
+	}
+	return nil
+}
+
+// leaderElection runs two replicas electing a leader through a lease in their shared store: only the first
+// syncs, and the second takes over once the first stops.
+func leaderElection(ctx context.Context, _ *Harness) error {
+	st := store.NewMemory()
+	const ttl = 300 * time.Millisecond
+	events := make(chan string, 8)
+	replica := func(id string) context.CancelFunc {
+		rctx, cancel := context.WithCancel(ctx)
+		e := &leader.Elector{Lock: leader.StoreLock{Store: st, Name: "sync"}, Identity: id, TTL: ttl}
+		go e.Run(rctx, func(ctx context.Context) {
+			events <- id
+			<-ctx.Done()
+			events <- id + " stopped"
+		})
+		return cancel
+	}
+	next := func(want string) error {
+		select {
+		case got := <-events:
+			if got != want {
+				return fmt.Errorf("want %q, got %q", want, got)
+			}
+			return nil
+		case <-time.After(2 * ttl):
+			return fmt.Errorf("want %q within %s, got nothing", want, 2*ttl)
+		}
+	}
+
+	stopA := replica("a")
+	if err := next("a"); err != nil {
+		return err
+	}
+	stopB := replica("b")
+	defer stopB()
+	select {
+	case got := <-events:
+		return fmt.Errorf("want b on standby while a leads, got %q", got)
+	case <-time.After(2 * ttl):
+	}
+	stopA()
+	if err := next("a stopped"); err != nil {
+		return err
+	}
+	// a released the lease as it stopped, so b takes over without waiting for it to expire.
+	return next("b")
+}
+
+func sharding(ctx context.Context, _ *Harness) error {
+	st := store.NewMemory()
+	const ttl = 300 * time.Millisecond
+	pairs := []string{"p1", "p2", "p3", "p4"}
+	var mu gosync.Mutex
+	owners := map[string]string{}
+	var overlap error
+	instance := func(id string) context.CancelFunc {
+		ictx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		s := &leader.Sharder{Store: st, Identity: id, TTL: ttl, Pairs: pairs}
+		go func() {
+			defer close(done)
+			s.Run(ictx, func(ctx context.Context, pair string) {
+				mu.Lock()
+				if owner, ok := owners[pair]; ok && overlap == nil {
+					overlap = fmt.Errorf("%s claimed %s while %s synced it", id, pair, owner)
+				}
+				owners[pair] = id
+				mu.Unlock()
+				<-ctx.Done()
+				mu.Lock()
+				delete(owners, pair)
+				mu.Unlock()
+			})
+		}()
+		return func() { cancel(); <-done }
+	}
+	// await waits for the instances to sync the given numbers of pairs.
+	await := func(want map[string]int) error {
+		deadline := time.Now().Add(5 * ttl)
+		for {
+			mu.Lock()
+			got := map[string]int{}
+			for _, owner := range owners {
+				got[owner]++
+			}
+			err := overlap
+			mu.Unlock()
+			if err != nil {
+				return err
+			}
+			if fmt.Sprint(got) == fmt.Sprint(want) {
+				return nil
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("want pairs synced by %v, got %v", want, got)
+			}
+			time.Sleep(ttl / 10)
+		}
+	}
+
+	stopA := instance("a")
+	defer stopA()
+	if err := await(map[string]int{"a": 4}); err != nil {
+		return err
+	}
+	// a releases half of the pairs once b joins, and b claims them.
+	stopB := instance("b")
+	if err := await(map[string]int{"a": 2, "b": 2}); err != nil {
+		return err
+	}
+	// b releases its pairs as it stops, so a claims them without waiting for their leases to expire.
+	stopB()
+	return await(map[string]int{"a": 4})
+}
+
+// The call budget of the call-budget scenario.
+const (
+	budgetCalls  = 10
+	budgetWindow = 300 * time.Millisecond
+)
+
+func callBudget(ctx context.Context, h *Harness) error {
+	ids := addAssigned(h, 15)
+	_, err := h.Run(ctx)
+	if !errors.Is(err, ratelimit.ErrBudgetExhausted) || !errors.Is(err, syncer.ErrInterrupted) {
+		return fmt.Errorf("first cycle: want it stopped by the call budget, got %v", err)
+	}
+	if got := len(h.Asana.Tasks(h.Project)); got == 0 || got == len(ids) {
+		return fmt.Errorf("first cycle: want some of the %d tasks created, got %d", len(ids), got)
+	}
+	// Every cycle resumes the last one once the budget is available again, until all the items are synced.
+	for cycles := 2; ; cycles++ {
+		time.Sleep(budgetWindow)
+		_, err := h.Run(ctx)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ratelimit.ErrBudgetExhausted) || cycles == 10 {
+			return fmt.Errorf("cycle %d: %w", cycles, err)
+		}
+	}
+	return expectTasks(ctx, h, ids)
+}
+
+// overflowLimit is the notes limit of the notes-overflow scenario.
+const overflowLimit = 400
+
+// notesOverflow syncs a description longer than the notes limit, which is cut to fit with the rest posted as
+// comments once, and not mirrored back to the work item.
+func notesOverflow(ctx context.Context, h *Harness) error {
+	ids := addAssigned(h, 1)
+	var desc strings.Builder
+	for i := 1; i <= 40; i++ {
+		fmt.Fprintf(&desc, "<p>Paragraph <b>%d</b> of the description.</p>", i)
+	}
+	h.ADO.Update(ids[0], map[string]interface{}{ado.FieldDescription: desc.String()})
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	t, _ := h.TaskOf(ctx, ids[0])
+	notes := h.Asana.HTMLNotes(t.GID)
+	if len(notes) > overflowLimit || !strings.Contains(notes, "Paragraph <strong>1</strong>") || !strings.Contains(notes, "in the comments of this task") {
+		return fmt.Errorf("want the notes cut to %d bytes ending with a pointer to the comments, got %d bytes: %q", overflowLimit, len(notes), notes)
+	}
+	comments := h.Asana.Comments(t.GID)
+	if len(comments) == 0 || !strings.HasPrefix(comments[0].Text, fmt.Sprintf("Description of AB#%d continued", ids[0])) ||
+		!strings.Contains(comments[len(comments)-1].Text, "Paragraph 40 of the description.") {
+		return fmt.Errorf("want the rest of the description posted as comments, got %d comments", len(comments))
+	}
+
+	h.ADO.Update(ids[0], map[string]interface{}{"Microsoft.VSTS.Common.Priority": 1.0})
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if got := len(h.Asana.Comments(t.GID)); got != len(comments) {
+		return fmt.Errorf("want the rest of an unchanged description posted once, got %d comments after %d", got, len(comments))
+	}
+	if got := h.ADO.Comments(ids[0]); len(got) != 0 {
+		return fmt.Errorf("want the overflow comments left out of the work item, got %d comments", len(got))
+	}
+	return nil
+}
+
+// dedupe proposes tasks made by hand for unsynced work items, by title and by ID, and adopts them so the
+// first cycle creates tasks for the other items only.
+func dedupe(ctx context.Context, h *Harness) error {
+	h.Asana.AddUser("Alice", "alice@example.com")
+	var ids []int
+	for _, title := range []string{"Fix the login page", "Export reports as CSV", "Upgrade the database"} {
+		ids = append(ids, h.ADO.Add("Task", title, map[string]interface{}{ado.FieldAssignedTo: Assignee("Alice", "alice@example.com")}))
+	}
+	byTitle := h.Asana.AddTask(h.Project, "Fix login page")
+	byID := h.Asana.AddTask(h.Project, fmt.Sprintf("Follow up on AB#%d with finance", ids[1]))
+	h.Asana.AddTask(h.Project, "Order team lunch")
+
+	dups, err := h.Engine.FindDuplicates(ctx, 0)
+	if err != nil {
+		return err
+	}
+	want := []syncer.Duplicate{
+		{ADOID: ids[0], AsanaGID: byTitle, Reason: syncer.DuplicateByTitle},
+		{ADOID: ids[1], AsanaGID: byID, Reason: syncer.DuplicateByID},
+	}
+	if len(dups) != len(want) {
+		return fmt.Errorf("want %d proposed duplicates, got %+v", len(want), dups)
+	}
+	for i, w := range want {
+		if d := dups[i]; d.ADOID != w.ADOID || d.AsanaGID != w.AsanaGID || d.Reason != w.Reason {
+			return fmt.Errorf("duplicate %d: want work item %d and task %s by %s, got %+v", i, w.ADOID, w.AsanaGID, w.Reason, d)
+		}
+	}
+
+	rep, err := h.Engine.Adopt(ctx, dups)
+	if err != nil {
+		return err
+	}
+	if len(rep.Failures) != 0 || rep.Created != 0 {
+		return fmt.Errorf("want both tasks adopted without creating any, got %d failures and %d created", len(rep.Failures), rep.Created)
+	}
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	for i, gid := range []string{byTitle, byID} {
+		t, err := h.TaskOf(ctx, ids[i])
+		if err != nil {
+			return err
+		}
+		if wi, _ := h.ADO.Item(ids[i]); t.GID != gid || t.Name != fmt.Sprintf("[AB#%d] %s", ids[i], wi.Title()) {
+			return fmt.Errorf("work item %d: want task %s adopted and renamed, got %s %q", ids[i], gid, t.GID, t.Name)
+		}
+	}
+	if got := len(h.Asana.Tasks(h.Project)); got != 4 {
+		return fmt.Errorf("want a task created for the third item only, got %d tasks", got)
+	}
+	if dups, err = h.Engine.FindDuplicates(ctx, 0); err != nil || len(dups) != 0 {
+		return fmt.Errorf("want nothing left to propose, got %+v, %v", dups, err)
+	}
+	return nil
+}
+
+// manageSchema creates a missing custom field and the enum options a mapping needs, and leaves out the
+// mapping of a field of another type instead of failing.
+func manageSchema(ctx context.Context, h *Harness) error {
+	h.Asana.AddCustomField(h.Project, "Priority", asana.CustomFieldEnum, "High")
+	h.Asana.AddCustomField(h.Project, "Severity", asana.CustomFieldEnum, "1 - Critical")
+	ids := addAssigned(h, 2)
+	for i, p := range []float64{2, 7} {
+		h.ADO.Update(ids[i], map[string]interface{}{"Microsoft.VSTS.Common.Priority": p, "Custom.Team": "Platform", "Microsoft.VSTS.Common.Severity": "1 - Critical"})
+	}
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	for i, want := range []string{"Medium", "Low"} {
+		t, _ := h.TaskOf(ctx, ids[i])
+		values := map[string]string{}
+		for _, cf := range t.CustomFields {
+			values[cf.Name] = textValue(t, cf.GID)
+		}
+		if values["Priority"] != want || values["Team"] != "Platform" || values["Severity"] != "" {
+			return fmt.Errorf("task of work item %d: want priority %q, team Platform and severity left alone, got %v", ids[i], want, values)
+		}
+	}
+	return nil
+}
+
+// probe checks a working pair, which must pass every check without leaving a task or an edit behind, then a
+// pair whose ADO project does not exist, whose failure must come with a hint and skip the checks after it.
+func probe(ctx context.Context, h *Harness) error {
+	ids := addAssigned(h, 1)
+	before, _ := h.ADO.Item(ids[0])
+	for _, c := range h.Engine.Probe(ctx, true) {
+		if c.Result != syncer.CheckPass {
+			return fmt.Errorf("check %s of %s: want pass, got %s: %s", c.Name, c.Target, c.Result, c.Detail)
+		}
+	}
+	if n := len(h.Asana.Tasks(h.Project)); n != 0 {
+		return fmt.Errorf("want the probe task deleted, got %d tasks", n)
+	}
+	if after, _ := h.ADO.Item(ids[0]); after.Rev != before.Rev {
+		return fmt.Errorf("want the work item left at revision %d, got %d", before.Rev, after.Rev)
+	}
+
+	cfg := h.Config
+	cfg.ADOProject = "Missing"
+	results := map[string]syncer.Check{}
+	for _, c := range syncer.New(cfg, adoClient(h.ADO), h.asana, store.NewMemory()).Probe(ctx, false) {
+		results[c.Name] = c
+	}
+	if c := results["ado project"]; c.Result != syncer.CheckFail || c.Hint == "" {
+		return fmt.Errorf("want the missing project to fail with a hint, got %s %q", c.Result, c.Hint)
+	}
+	for _, name := range []string{"ado query", "ado write", "asana write", "configuration"} {
+		if c := results[name]; c.Result != syncer.CheckSkip {
+			return fmt.Errorf("check %s: want skip, got %s", name, c.Result)
+		}
+	}
+	if c := results["asana project"]; c.Result != syncer.CheckPass {
+		return fmt.Errorf("want the asana project found, got %s: %s", c.Result, c.Detail)
+	}
+	return nil
+}
+
+// journal syncs with a faulty notes template, then replays the journaled change with the repaired one.
+func journal(ctx context.Context, h *Harness) error {
+	ids := addAssigned(h, 1)
+	for i := 0; i < 2; i++ {
+		if _, err := h.Run(ctx); err != nil {
+			return err
+		}
+	}
+	entries, err := h.Store.Journal(ctx, store.JournalFilter{})
+	if err != nil {
+		return err
+	}
+	if len(entries) != 1 || entries[0].ADOID != ids[0] || strings.Join(entries[0].Sources, ",") != store.OriginADO {
+		return fmt.Errorf("want the new work item journaled once, got %+v", entries)
+	}
+
+	cfg := h.Config
+	cfg.NotesTemplate = "<strong>{{.Title}}</strong>"
+	repaired := syncer.New(cfg, adoClient(h.ADO), h.asana, h.Store)
+	if _, err := repaired.ReplayJournal(ctx, "missing"); !errors.Is(err, syncer.ErrNoJournal) {
+		return fmt.Errorf("want ErrNoJournal for an unknown cycle, got %v", err)
+	}
+	rep, err := repaired.ReplayJournal(ctx, entries[0].CycleID)
+	if err != nil {
+		return err
+	}
+	if len(rep.Failures) > 0 {
+		return fmt.Errorf("want the replay to succeed, got %v", rep.Failures[0].Err)
+	}
+	t, err := h.TaskOf(ctx, ids[0])
+	if err != nil {
+		return err
+	}
+	wi, _ := h.ADO.Item(ids[0])
+	if notes := h.Asana.HTMLNotes(t.GID); !strings.Contains(notes, wi.Title()) {
+		return fmt.Errorf("want the replay to rewrite the notes with the title, got %q", notes)
+	}
+	if entries, _ = h.Store.Journal(ctx, store.JournalFilter{}); len(entries) != 1 {
+		return fmt.Errorf("want the replay journaled nothing, got %d entries", len(entries))
+	}
+	return nil
+}
+
+// testCases lists the steps of a test case and the test case of a story in their notes, then marks them with
+// the outcomes of a test run.
+func testCases(ctx context.Context, h *Harness) error {
+	story := addAssigned(h, 1)[0]
+	tc := h.ADO.Add(ado.TypeTestCase, "Login works", map[string]interface{}{
+		ado.FieldAssignedTo: Assignee("Alice", "alice@example.com"),
+		ado.FieldSteps: `<steps id="0" last="3">` +
+			`<step id="2" type="ActionStep"><parameterizedString isformatted="true">&lt;P&gt;Open the login page&lt;/P&gt;</parameterizedString>` +
+			`<parameterizedString isformatted="true">&lt;P&gt;The form shows&lt;/P&gt;</parameterizedString><description/></step>` +
+			`<step id="3" type="ActionStep"><parameterizedString isformatted="true">Sign in</parameterizedString>` +
+			`<parameterizedString isformatted="true"></parameterizedString><description/></step></steps>`,
+	})
+	h.ADO.Link(story, ado.RelTestedBy, tc)
+	notes := func(id int) (string, error) {
+		t, err := h.TaskOf(ctx, id)
+		if err != nil {
+			return "", err
+		}
+		return h.Asana.HTMLNotes(t.GID), nil
+	}
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	n, err := notes(tc)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(n, "☐ 1. Open the login page → The form shows") || !strings.Contains(n, "☐ 2. Sign in") {
+		return fmt.Errorf("want the steps listed unchecked in the test case's notes, got %q", n)
+	}
+	if n, err = notes(story); err != nil {
+		return err
+	}
+	if want := fmt.Sprintf("Test case %d: Login works", tc); !strings.Contains(n, want) {
+		return fmt.Errorf("want %q in the story's notes, got %q", want, n)
+	}
+
+	h.ADO.TestRun(tc, ado.OutcomeFailed, map[int]string{2: ado.OutcomePassed, 3: ado.OutcomeFailed})
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if n, err = notes(tc); err != nil {
+		return err
+	}
+	if !strings.Contains(n, "✅ 1. Open the login page") || !strings.Contains(n, "❌ 2. Sign in (failed)") {
+		return fmt.Errorf("want the steps marked with the outcomes of the run, got %q", n)
+	}
+	if n, err = notes(story); err != nil {
+		return err
+	}
+	if !strings.Contains(n, "❌") || !strings.Contains(n, "(failed)") {
+		return fmt.Errorf("want the failed test case marked in the story's notes, got %q", n)
+	}
+	return nil
+}
+
+// warmUp warms the pair up at a throttled rate and then runs its first cycle.
+func warmUp(ctx context.Context, h *Harness) error {
+	h.Cache(time.Minute)
+	ids := addAssigned(h, 2)
+	adoBefore, _ := h.ADO.Requests()
+	asanaBefore, _ := h.Asana.Requests()
+	start := time.Now()
+	if err := h.Engine.WarmUp(ctx, ratelimit.NewPace(100)); err != nil {
+		return err
+	}
+	elapsed := time.Since(start)
+	adoAfter, _ := h.ADO.Requests()
+	asanaAfter, _ := h.Asana.Requests()
+	n := adoAfter - adoBefore + asanaAfter - asanaBefore
+	if n < 2 {
+		return fmt.Errorf("want the warm-up to read the reference data, got %d requests", n)
+	}
+	if min := time.Duration(n-1) * 10 * time.Millisecond; elapsed < min {
+		return fmt.Errorf("want %d requests spaced over at least %v, took %v", n, min, elapsed)
+	}
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	return expectTasks(ctx, h, ids)
+}
+
+// pause pauses the pair, checks that its cycles and targeted syncs write nothing while the targeted item is
+// queued, and resumes it to sync the changes held back. It also checks when a maintenance window is open.
+func pause(ctx context.Context, h *Harness) error {
+	ids := addAssigned(h, 2)
+	if err := h.Engine.Pause(ctx, time.Time{}, "migration"); err != nil {
+		return err
+	}
+	adoBefore, _ := h.ADO.Requests()
+	asanaBefore, _ := h.Asana.Requests()
+	if _, err := h.Run(ctx); !errors.Is(err, syncer.ErrPaused) {
+		return fmt.Errorf("want the cycle of a paused pair to fail with ErrPaused, got %v", err)
+	}
+	if _, err := h.Engine.SyncItem(ctx, ids[0]); !errors.Is(err, syncer.ErrPaused) {
+		return fmt.Errorf("want a targeted sync of a paused pair to fail with ErrPaused, got %v", err)
+	}
+	if rep, err := h.Engine.RetryDue(ctx); err != nil || rep != nil {
+		return fmt.Errorf("want no retries while paused, got %v, %v", rep, err)
+	}
+	adoAfter, _ := h.ADO.Requests()
+	asanaAfter, _ := h.Asana.Requests()
+	if adoAfter != adoBefore || asanaAfter != asanaBefore {
+		return fmt.Errorf("want no requests while paused, got %d to ado and %d to asana", adoAfter-adoBefore, asanaAfter-asanaBefore)
+	}
+	if n := len(h.Asana.Tasks(h.Project)); n != 0 {
+		return fmt.Errorf("want no tasks created while paused, got %d", n)
+	}
+	if _, err := h.Store.Retry(ctx, ids[0]); err != nil {
+		return fmt.Errorf("want the targeted item queued while paused: %w", err)
+	}
+	if p, err := h.Engine.Paused(ctx); err != nil || p == nil || p.Reason != "migration" {
+		return fmt.Errorf("want the pause recorded with its reason, got %+v, %v", p, err)
+	}
+
+	if err := h.Engine.Resume(ctx); err != nil {
+		return err
+	}
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if err := expectTasks(ctx, h, ids); err != nil {
+		return err
+	}
+
+	w, err := schedule.ParseWindow("CRON_TZ=UTC 0 22 * * FRI for 10h")
+	if err != nil {
+		return err
+	}
+	saturday := time.Date(2024, 6, 8, 3, 0, 0, 0, time.UTC)
+	if _, end, ok := w.Open(saturday); !ok || !end.Equal(saturday.Add(5*time.Hour)) {
+		return fmt.Errorf("want the window open until 08:00 on saturday, got %v, %v", end, ok)
+	}
+	if _, _, ok := w.Open(saturday.Add(5 * time.Hour)); ok {
+		return errors.New("want the window closed at its end")
+	}
+	if _, err := schedule.ParseWindow("@every 1h for 10m"); err == nil {
+		return errors.New("want a window on an interval rejected")
+	}
+	return nil
+}
+
+// blockedByLink is the custom link type of blocked by links in the links scenario.
+const blockedByLink = "Custom.BlockedBy-Reverse"
+
+// links syncs related, duplicate and blocked by links: related items are listed in the task notes once both
+// are synced, the task of a duplicate is completed, and a blocked item depends on the task of its blocker.
+func links(ctx context.Context, h *Harness) error {
+	ids := addAssigned(h, 4)
+	original, related, duplicate, blocked := ids[0], ids[1], ids[2], ids[3]
+	h.ADO.Link(original, ado.RelRelated, related)
+	h.ADO.Link(related, ado.RelRelated, original)
+	h.ADO.Link(duplicate, ado.RelDuplicateOf, original)
+	h.ADO.Link(blocked, blockedByLink, original)
+	// The second cycle lists the related item that was not synced yet when the first synced the original.
+	for i := 0; i < 2; i++ {
+		if _, err := h.Run(ctx); err != nil {
+			return err
+		}
+	}
+	orig, err := h.TaskOf(ctx, original)
+	if err != nil {
+		return err
+	}
+	if n := h.Asana.HTMLNotes(orig.GID); !strings.Contains(n, "Related: <a href=") || !strings.Contains(n, fmt.Sprintf("[AB#%d]", related)) {
+		return fmt.Errorf("want the related item linked in the notes, got %q", n)
+	}
+	dup, err := h.TaskOf(ctx, duplicate)
+	if err != nil {
+		return err
+	}
+	if !dup.Completed {
+		return errors.New("want the task of the duplicate completed")
+	}
+	if n := h.Asana.HTMLNotes(dup.GID); !strings.Contains(n, fmt.Sprintf("Duplicate of: <a href=\"https://app.asana.com/0/0/%s\">", orig.GID)) {
+		return fmt.Errorf("want the duplicate to link to the original, got %q", n)
+	}
+	b, err := h.TaskOf(ctx, blocked)
+	if err != nil {
+		return err
+	}
+	if len(b.Dependencies) != 1 || b.Dependencies[0].GID != orig.GID {
+		return fmt.Errorf("want the blocked task to depend on %s, got %v", orig.GID, b.Dependencies)
+	}
+	return nil
+}
+
+// sentDigests records the digests sent in the digest scenario.
+type sentDigests []digest.Message
+
+func (s *sentDigests) Send(_ context.Context, msg digest.Message) error {
+	*s = append(*s, msg)
+	return nil
+}
+
+func digestReport(ctx context.Context, h *Harness) error {
+	since := time.Now().Add(-time.Hour)
+	ids := addAssigned(h, 3)
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	h.ADO.Update(ids[0], map[string]interface{}{ado.FieldState: "Closed"})
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	task, err := h.TaskOf(ctx, ids[1])
+	if err != nil {
+		return err
+	}
+	if err := h.Store.PutConflict(ctx, store.Conflict{ADOID: ids[1], AsanaGID: task.GID, Field: "title", ADOValue: "Mine", AsanaValue: "Theirs", DetectedAt: time.Now()}); err != nil {
+		return err
+	}
+	if err := h.Store.PutRetry(ctx, store.Retry{ADOID: ids[2], Pair: h.Config.Name, Attempts: 4, LastError: "asana responded 500", FirstFailed: time.Now(), NextAttempt: time.Now().Add(time.Hour)}); err != nil {
+		return err
+	}
+
+	var sent sentDigests
+	d := &digest.Digester{
+		Pairs:  []digest.Pair{{Name: h.Config.Name, ADOProject: h.Config.ADOProject, AsanaProject: h.Config.AsanaProject, Store: h.Store}},
+		Sender: &sent,
+		From:   "sync@example.com",
+		To:     []string{"team@example.com"},
+	}
+	until := time.Now().Add(time.Minute)
+	r, err := digest.Build(ctx, d.Pairs, since, until)
+	if err != nil {
+		return err
+	}
+	p := r.Pairs[0]
+	if len(p.Created) != 3 || len(p.Completed) != 1 || p.Completed[0].ADOID != ids[0] || p.Cycles != 2 {
+		return fmt.Errorf("want 3 created and 1 completed tasks over 2 cycles, got %+v", p)
+	}
+	if len(p.Conflicts) != 1 || len(p.Failing) != 1 || p.Failing[0].ADOID != ids[2] || p.Failing[0].Title == "" {
+		return fmt.Errorf("want the conflict and the failing item reported, got %+v and %+v", p.Conflicts, p.Failing)
+	}
+	if err := d.Send(ctx, since, until); err != nil {
+		return err
+	}
+	if len(sent) != 1 || !strings.HasPrefix(sent[0].Subject, "Sync digest") || !strings.Contains(sent[0].HTML, "asana responded 500") {
+		return fmt.Errorf("want the digest rendered and sent, got %+v", sent)
+	}
+	if r, err := digest.Build(ctx, d.Pairs, until, until.Add(time.Hour)); err != nil || len(r.Pairs[0].Created) != 0 || r.Pairs[0].Cycles != 0 {
+		return fmt.Errorf("want no activity in a later period, got %+v, %v", r, err)
+	}
+	return nil
+}
+
+func canary(ctx context.Context, h *Harness) error {
+	ids := addAssigned(h, 3)
+	m, err := h.Manager(nil, nil)
+	if err != nil {
+		return err
+	}
+	candidate := h.Config
+	candidate.NotesTemplate = "<strong>Candidate</strong>"
+	rep, err := m.Canary(ctx, candidate, syncer.CanaryOptions{Items: []int{ids[1]}})
+	if err != nil {
+		return err
+	}
+	if len(rep.Items) != 1 || rep.Items[0].ADOID != ids[1] || rep.Items[0].Error != "" {
+		return fmt.Errorf("want only the named item compared, got %+v", rep.Items)
+	}
+	found := false
+	for _, d := range rep.Items[0].Diffs {
+		found = found || (d.Change == "asana create html_notes" && strings.Contains(d.Candidate, "Candidate") && strings.Contains(d.Current, "Current"))
+	}
+	if !found {
+		return fmt.Errorf("want the notes of the new task to differ, got %+v", rep.Items[0].Diffs)
+	}
+	if n := len(h.Asana.Tasks(h.Project)); n != 0 {
+		return fmt.Errorf("want nothing written while comparing, got %d tasks", n)
+	}
+	if mappings, err := h.Store.All(ctx); err != nil || len(mappings) != 0 {
+		return fmt.Errorf("want no mappings saved while comparing, got %d, %v", len(mappings), err)
+	}
+
+	if rep, err = m.Canary(ctx, candidate, syncer.CanaryOptions{Percent: 100}); err != nil || len(rep.Items) != 3 || rep.Differing() != 3 {
+		return fmt.Errorf("want every item sampled at 100%%, got %+v, %v", rep, err)
+	}
+	if rep, err = m.Canary(ctx, candidate, syncer.CanaryOptions{Items: []int{ids[1]}, Apply: true}); err != nil || rep.Applied != 1 {
+		return fmt.Errorf("want the canary applied to the named item, got %+v, %v", rep, err)
+	}
+	if n := len(h.Asana.Tasks(h.Project)); n != 1 {
+		return fmt.Errorf("want only the canary item synced, got %d tasks", n)
+	}
+	task, err := h.TaskOf(ctx, ids[1])
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(h.Asana.HTMLNotes(task.GID), "Candidate") {
+		return fmt.Errorf("want the canary item synced with the candidate, got %q", h.Asana.HTMLNotes(task.GID))
+	}
+	return nil
+}
+
+func purge(ctx context.Context, h *Harness) error {
+	key, err := secret.New("purge passphrase")
+	if err != nil {
+		return err
+	}
+	h.Encrypt(store.NewMemory(), key)
+	ids := addAssigned(h, 2)
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if err := h.Store.PutConflict(ctx, store.Conflict{ADOID: ids[0], Field: "assignee", ADOValue: "ALICE@example.com", AsanaValue: "bob@example.com"}); err != nil {
+		return err
+	}
+	holds := func() (bool, error) {
+		snap, err := h.Store.Export(ctx)
+		if err != nil {
+			return false, err
+		}
+		b, err := json.Marshal(snap)
+		return strings.Contains(strings.ToLower(string(b)), "alice"), err
+	}
+	if found, err := holds(); err != nil || !found {
+		return fmt.Errorf("want the store to hold the assignee before the purge, got %v, %v", found, err)
+	}
+
+	n, err := store.Scrub(ctx, h.Store, "alice@example.com", "Alice")
+	if err != nil {
+		return err
+	}
+	if found, err := holds(); err != nil || found || n == 0 {
+		return fmt.Errorf("want every trace of the assignee scrubbed, got %d values and found %v, %v", n, found, err)
+	}
+	c, err := h.Store.Conflict(ctx, ids[0], "assignee")
+	if err != nil || c.ADOValue != store.Redacted || c.AsanaValue != "bob@example.com" {
+		return fmt.Errorf("want only the identity replaced, got %+v, %v", c, err)
+	}
+	if mappings, err := h.Store.All(ctx); err != nil || len(mappings) != 2 {
+		return fmt.Errorf("want the mappings kept, got %d, %v", len(mappings), err)
+	}
+	if _, err := h.Run(ctx); err != nil {
+		return fmt.Errorf("want the sync to carry on after a purge: %w", err)
+	}
+
+	var out bytes.Buffer
+	log := slog.New(logging.NewRedact(slog.NewJSONHandler(&out, nil), logging.ParseRedaction("title,emails")))
+	log.With("title", "Secret item").Info("assigned to alice@example.com", "assignee", "alice@example.com", "error", errors.New("no user bob@example.com"))
+	line := out.String()
+	if strings.Contains(line, "alice@") || strings.Contains(line, "bob@") || strings.Contains(line, "Secret") ||
+		!strings.Contains(line, "a***@example.com") || !strings.Contains(line, logging.Redacted) {
+		return fmt.Errorf("want the log line redacted, got %s", line)
+	}
+	return nil
+}
+
+func statusUpdates(ctx context.Context, h *Harness) error {
+	sprint := h.ADO.AddSprint("Sprint 4", time.Now().AddDate(0, 0, -1), time.Now().AddDate(0, 0, 7))
+	ids := addAssigned(h, 3)
+	h.ADO.Update(ids[0], map[string]interface{}{ado.FieldIterationPath: sprint})
+	h.ADO.Update(ids[1], map[string]interface{}{ado.FieldIterationPath: sprint, ado.FieldTags: "Blocked"})
+	latest := func(title, status string, contains ...string) (StatusUpdate, error) {
+		updates := h.Asana.StatusUpdates(h.Project)
+		if len(updates) != 1 {
+			return StatusUpdate{}, fmt.Errorf("want the last status update of the sprint, got %+v", updates)
+		}
+		u := updates[0]
+		if u.Title != title || u.StatusType != status {
+			return u, fmt.Errorf("want status update %q %s, got %q %s", title, status, u.Title, u.StatusType)
+		}
+		for _, c := range contains {
+			if !strings.Contains(u.HTMLText, c) {
+				return u, fmt.Errorf("want the status update to contain %q, got %s", c, u.HTMLText)
+			}
+		}
+		return u, nil
+	}
+
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	blocked, err := h.TaskOf(ctx, ids[1])
+	if err != nil {
+		return err
+	}
+	first, err := latest("Sprint 4: 0 of 2 items done", asana.StatusAtRisk, "<li>New: 3</li>", "Blocked (1)", blocked.GID)
+	if err != nil {
+		return err
+	}
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if u, err := latest(first.Title, first.StatusType); err != nil || u.GID != first.GID {
+		return fmt.Errorf("want an unchanged status update kept, got %+v, %v", u, err)
+	}
+
+	h.ADO.Update(ids[0], map[string]interface{}{ado.FieldState: "Closed"})
+	h.ADO.Update(ids[1], map[string]interface{}{ado.FieldTags: nil})
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	u, err := latest("Sprint 4: 1 of 2 items done", asana.StatusOnTrack, "Closed this sprint (1)", "Item 1")
+	if err != nil {
+		return err
+	}
+	if u.GID == first.GID || strings.Contains(u.HTMLText, "Blocked") {
+		return fmt.Errorf("want the status update replaced, got %+v", u)
+	}
+	return nil
+}
+
+func discover(ctx context.Context, h *Harness) error {
+	sprint := h.ADO.AddSprint("Sprint 1", time.Now(), time.Now().AddDate(0, 0, 14))
+	client := adoClient(h.ADO)
+	if areas, err := client.AreaPaths(ctx, ProjectName); err != nil || len(areas) != 1 || areas[0] != ProjectName {
+		return fmt.Errorf("want the root area path, got %v, %v", areas, err)
+	}
+	if its, err := client.Iterations(ctx, ProjectName); err != nil || len(its) != 2 || its[1].Path != sprint || its[1].StartDate == nil {
+		return fmt.Errorf("want the root iteration and the sprint, got %+v, %v", its, err)
+	}
+	ws, err := h.asana.Workspaces(ctx)
+	if err != nil || len(ws) != 1 || ws[0].GID != h.Asana.Workspace {
+		return fmt.Errorf("want the workspace of the token, got %+v, %v", ws, err)
+	}
+	other := h.Asana.AddProject("Other")
+	projects, err := h.asana.WorkspaceProjects(ctx, h.Asana.Workspace)
+	if err != nil {
+		return err
+	}
+	var gids []string
+	for _, p := range projects {
+		gids = append(gids, p.GID)
+	}
+	if len(gids) != 2 || gids[0] != h.Project || gids[1] != other {
+		return fmt.Errorf("want the projects %s and %s, got %v", h.Project, other, gids)
+	}
+	return nil
+}
+
+// captureLogs sends the lines logged through the default logger to the returned buffer, rather than the
+// output of the scenario, until restore is called.
+func captureLogs() (logs *bytes.Buffer, restore func()) {
+	prev := slog.Default()
+	logs = new(bytes.Buffer)
+	slog.SetDefault(slog.New(slog.NewTextHandler(logs, &slog.HandlerOptions{Level: slog.LevelWarn})))
+	return logs, func() { slog.SetDefault(prev) }
+}
+
+// failingPuts is a store failing to record the mappings of tasks while fail is set.
+type failingPuts struct {
+	store.Store
+	fail bool
+}
+
+func (s *failingPuts) Put(ctx context.Context, m store.Mapping) error {
+	if s.fail && m.AsanaGID != "" {
+		return errors.New("store unavailable")
+	}
+	return s.Store.Put(ctx, m)
+}
+
+func pendingMappings(ctx context.Context, h *Harness) error {
+	ids := addAssigned(h, 3)
+	// A task whose mapping cannot be recorded is deleted again, and created once the store recovers.
+	st := &failingPuts{Store: h.Store, fail: true}
+	logs, restore := captureLogs()
+	rep, err := syncer.New(h.Config, adoClient(h.ADO), h.asana, st).Run(ctx)
+	restore()
+	if err != nil {
+		return err
+	}
+	if len(rep.Failures) != 3 || len(h.Asana.Tasks(h.Project)) != 0 {
+		return fmt.Errorf("want every item failed and no task left, got %d failures and %d tasks", len(rep.Failures), len(h.Asana.Tasks(h.Project)))
+	}
+	for _, id := range ids {
+		if want := fmt.Sprintf("work_item_id=%d", id); !strings.Contains(logs.String(), want) {
+			return fmt.Errorf("want the failure of %d logged, got %s", id, logs)
+		}
+	}
+	if n := strings.Count(logs.String(), "level=ERROR"); n != 3 || strings.Count(logs.String(), "store unavailable") != 3 {
+		return fmt.Errorf("want an error logged for each failed mapping only, got %s", logs)
+	}
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if err := expectTasks(ctx, h, ids); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if m, err := h.Store.Get(ctx, id); err != nil || m.Pending {
+			return fmt.Errorf("want the mapping of %d committed, got %+v, %v", id, m, err)
+		}
+	}
+
+	// A create whose outcome was lost is matched to the task anchored to its item, and a pending mapping
+	// whose task is gone is forgotten, so neither item gets a second task.
+	lost := h.ADO.Add("Task", "Lost", map[string]interface{}{ado.FieldAssignedTo: Assignee("Alice", "alice@example.com")})
+	orphan := h.ADO.Add("Task", "Orphan", map[string]interface{}{ado.FieldAssignedTo: Assignee("Alice", "alice@example.com")})
+	gid := h.Asana.AddTask(h.Project, fmt.Sprintf("[AB#%d] Lost", lost))
+	if err := h.Store.Put(ctx, store.Mapping{ADOID: lost, Pair: h.Config.Name, Pending: true}); err != nil {
+		return err
+	}
+	if err := h.Store.Put(ctx, store.Mapping{ADOID: orphan, AsanaGID: "999999", Pair: h.Config.Name, Pending: true}); err != nil {
+		return err
+	}
+	if _, err := syncer.New(h.Config, adoClient(h.ADO), h.asana, h.Store).Run(ctx); err != nil {
+		return err
+	}
+	if err := expectTasks(ctx, h, append(ids, lost, orphan)); err != nil {
+		return err
+	}
+	if m, err := h.Store.Get(ctx, lost); err != nil || m.AsanaGID != gid || m.Pending {
+		return fmt.Errorf("want the lost task %s adopted, got %+v, %v", gid, m, err)
+	}
+	if m, err := h.Store.Get(ctx, orphan); err != nil || m.AsanaGID == "999999" || m.Pending {
+		return fmt.Errorf("want the orphan given a new task, got %+v, %v", m, err)
+	}
+
+	// A targeted sync straight after a restart settles the pending mappings too, rather than creating a
+	// second task for the item.
+	again := h.ADO.Add("Task", "Lost again", map[string]interface{}{ado.FieldAssignedTo: Assignee("Alice", "alice@example.com")})
+	gid = h.Asana.AddTask(h.Project, fmt.Sprintf("[AB#%d] Lost again", again))
+	if err := h.Store.Put(ctx, store.Mapping{ADOID: again, Pair: h.Config.Name, Pending: true}); err != nil {
+		return err
+	}
+	tasks := len(h.Asana.Tasks(h.Project))
+	if _, err := syncer.New(h.Config, adoClient(h.ADO), h.asana, h.Store).SyncItem(ctx, again); err != nil {
+		return err
+	}
+	if got := len(h.Asana.Tasks(h.Project)); got != tasks {
+		return fmt.Errorf("want no task created by the targeted sync, got %d tasks instead of %d", got, tasks)
+	}
+	if m, err := h.Store.Get(ctx, again); err != nil || m.AsanaGID != gid || m.Pending {
+		return fmt.Errorf("want the task %s adopted by the targeted sync, got %+v, %v", gid, m, err)
+	}
+	return nil
+}
+
+func myTasks(ctx context.Context, h *Harness) error {
+	cfg := h.Config
+	cfg.AsanaProject = ""
+	cfg.MyTasks = syncer.MyTasksConfig{Users: []string{"alice@example.com", "bob@example.com"}}
+	cfg.Removal.Policy = syncer.RemoveComplete
+	e := syncer.New(cfg, adoClient(h.ADO), h.asana, h.Store)
+	run := func() error {
+		rep, err := e.Run(ctx)
+		if err == nil && len(rep.Failures) > 0 {
+			err = rep.Failures[0].Err
+		}
+		return err
+	}
+	ids := addAssigned(h, 2)
+	bob := h.Asana.AddUser("Bob", "bob@example.com")
+	// Carol does not mirror her items.
+	h.Asana.AddUser("Carol", "carol@example.com")
+	carol := h.ADO.Add("Task", "Carol's item", map[string]interface{}{ado.FieldAssignedTo: Assignee("Carol", "carol@example.com")})
+	if err := run(); err != nil {
+		return err
+	}
+	first, err := h.TaskOf(ctx, ids[0])
+	if err != nil {
+		return err
+	}
+	alice := first.Assignee.GID
+	if got := len(h.Asana.AssignedTasks(alice)); got != 2 || len(h.Asana.Tasks(h.Project)) != 0 {
+		return fmt.Errorf("want both items of alice in her my tasks only, got %d", got)
+	}
+	if _, err := h.Store.Get(ctx, carol); err == nil {
+		return fmt.Errorf("want the item of carol left alone")
+	}
+
+	// Reassigning an item moves its task to the my tasks of the new assignee, and a full cycle finds every
+	// task again instead of creating another.
+	moved, err := h.TaskOf(ctx, ids[1])
+	if err != nil {
+		return err
+	}
+	h.ADO.Update(ids[1], map[string]interface{}{ado.FieldAssignedTo: Assignee("Bob", "bob@example.com")})
+	if err := run(); err != nil {
+		return err
+	}
+	if err := run(); err != nil {
+		return err
+	}
+	if a, b := h.Asana.AssignedTasks(alice), h.Asana.AssignedTasks(bob); len(a) != 1 || len(b) != 1 || b[0].GID != moved.GID {
+		return fmt.Errorf("want the task of %d moved to bob, got %d for alice and %+v for bob", ids[1], len(a), b)
+	}
+
+	// An item handed to somebody who does not mirror leaves the pair.
+	h.ADO.Update(ids[0], map[string]interface{}{ado.FieldAssignedTo: Assignee("Carol", "carol@example.com")})
+	if err := run(); err != nil {
+		return err
+	}
+	if t, _ := h.Asana.Task(first.GID); !t.Completed {
+		return fmt.Errorf("want the task of the item reassigned to carol completed")
+	}
+	return nil
+}
+
+func analyticsExport(ctx context.Context, h *Harness) error {
+	ids := addAssigned(h, 2)
+	h.ADO.Update(ids[0], map[string]interface{}{ado.FieldStoryPoints: 5.0})
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	samples, err := h.Store.Samples(ctx, store.SampleFilter{})
+	if err != nil || len(samples) != 2 {
+		return fmt.Errorf("want a sample of each new item and none of unchanged ones, got %d, %v", len(samples), err)
+	}
+	// The workers sample the items in no set order, so the sample of the first item is looked up by its ID.
+	if samples, err = h.Store.Samples(ctx, store.SampleFilter{ADOID: ids[0]}); err != nil || len(samples) != 1 {
+		return fmt.Errorf("want a sample of the first item, got %d, %v", len(samples), err)
+	}
+	if s := samples[0]; s.Points == nil || *s.Points != 5 || s.Done || s.CycleID == "" {
+		return fmt.Errorf("want the points of the first item sampled, got %+v", s)
+	}
+
+	h.ADO.Update(ids[0], map[string]interface{}{ado.FieldState: "Closed"})
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if samples, err = h.Store.Samples(ctx, store.SampleFilter{ADOID: ids[0]}); err != nil || len(samples) != 2 || !samples[1].Done {
+		return fmt.Errorf("want the closed item sampled again as done, got %+v, %v", samples, err)
+	}
+
+	all, err := h.Store.Samples(ctx, store.SampleFilter{})
+	if err != nil {
+		return err
+	}
+	// A sample taken again at the same revision is dropped from the export.
+	all = append(all, all[len(all)-1])
+	var csv bytes.Buffer
+	if err := analytics.Write(&csv, analytics.Snapshots(all, false, time.Now()), analytics.FormatCSV); err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimSpace(csv.String()), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "snapshot_time,pair,") || !strings.Contains(lines[3], ",Closed,true,") {
+		return fmt.Errorf("want a header and a row per sample, got %q", lines)
+	}
+	daily := analytics.Snapshots(all, true, time.Now())
+	if len(daily) != 2 || daily[0].ADOID != ids[0] || !daily[0].Done || daily[1].Done {
+		return fmt.Errorf("want the items as they ended the day, got %+v", daily)
+	}
+	var pq bytes.Buffer
+	if err := analytics.Write(&pq, daily, analytics.FormatParquet); err != nil {
+		return err
+	}
+	if b := pq.Bytes(); len(b) < 12 || string(b[:4]) != "PAR1" || string(b[len(b)-4:]) != "PAR1" {
+		return fmt.Errorf("want a parquet file, got %d bytes", len(b))
+	}
+	return nil
+}
+
+func systemdNotify(ctx context.Context, h *Harness) error {
+	if ok, err := systemd.Notify(systemd.Ready); ok || err != nil {
+		return fmt.Errorf("want nothing notified outside systemd, got %v, %v", ok, err)
+	}
+	dir, err := os.MkdirTemp("", "notify")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	addr := &net.UnixAddr{Name: filepath.Join(dir, "notify.sock"), Net: "unixgram"}
+	conn, err := net.ListenUnixgram("unixgram", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	os.Setenv("NOTIFY_SOCKET", addr.Name)
+	os.Setenv("WATCHDOG_USEC", "30000000")
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	defer os.Unsetenv("WATCHDOG_USEC")
+
+	if ok, err := systemd.Notify(systemd.Ready); !ok || err != nil {
+		return fmt.Errorf("want readiness notified, got %v, %v", ok, err)
+	}
+	buf := make([]byte, 64)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != systemd.Ready {
+		return fmt.Errorf("want %s received, got %q, %v", systemd.Ready, buf[:n], err)
+	}
+	if d := systemd.WatchdogInterval(); d != 30*time.Second {
+		return fmt.Errorf("want the watchdog interval of WATCHDOG_USEC, got %s", d)
+	}
+
+	unit := systemd.Unit(systemd.UnitConfig{Description: "sync", Exec: []string{"/opt/sync dir/ado-asana-sync", "service", "run"},
+		User: "sync", Watchdog: time.Minute})
+	for _, want := range []string{"Type=notify\n", `ExecStart="/opt/sync dir/ado-asana-sync" service run` + "\n", "User=sync\n",
+		"WatchdogSec=60\n", "Restart=on-failure\n"} {
+		if !strings.Contains(unit, want) {
+			return fmt.Errorf("want the unit to hold %q, got\n%s", want, unit)
+		}
+	}
+	return nil
+}
+
+func egressProxy(ctx context.Context, h *Harness) error {
+	// The proxy answers every request itself, once it carries the credentials of its user.
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != "Basic cHJveHl1c2VyOnMzY3JldA==" {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		proxied = append(proxied, r.URL.String())
+	}))
+	defer proxy.Close()
+	os.Setenv("EGRESS_PROXY_PASSWORD", "s3cret")
+	defer os.Unsetenv("EGRESS_PROXY_PASSWORD")
+	get := func(o egress.Options, url string) (int, error) {
+		t, err := o.Transport()
+		if err != nil {
+			return 0, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return 0, err
+		}
+		resp, err := (&http.Client{Transport: t}).Do(req)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+	withUser := strings.Replace(proxy.URL, "http://", "http://proxyuser@", 1)
+	if code, err := get(egress.Options{ProxyURL: withUser, ProxyPasswordEnv: "EGRESS_PROXY_PASSWORD"}, "http://dev.azure.example/org"); err != nil ||
+		code != http.StatusOK || len(proxied) != 1 || proxied[0] != "http://dev.azure.example/org" {
+		return fmt.Errorf("want the request sent through the proxy with its credentials, got %d, %q, %v", code, proxied, err)
+	}
+	if code, err := get(egress.Options{ProxyURL: proxy.URL}, "http://dev.azure.example/org"); err != nil || code != http.StatusProxyAuthRequired {
+		return fmt.Errorf("want the proxy to refuse requests without credentials, got %d, %v", code, err)
+	}
+	if _, err := (egress.Options{ProxyURL: "ftp://proxy"}).Transport(); err == nil {
+		return fmt.Errorf("want a proxy url that is not http refused")
+	}
+
+	// A server with a certificate of its own is trusted once its CA bundle is given.
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	if _, err := get(egress.Options{}, server.URL); err == nil {
+		return fmt.Errorf("want the certificate of the test server untrusted by default")
+	}
+	dir, err := os.MkdirTemp("", "egress")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	bundle := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600); err != nil {
+		return err
+	}
+	if code, err := get(egress.Options{CAFile: bundle}, server.URL); err != nil || code != http.StatusOK {
+		return fmt.Errorf("want the server trusted with the CA bundle, got %d, %v", code, err)
+	}
+	if _, err := (egress.Options{CertFile: bundle}).Transport(); err == nil {
+		return fmt.Errorf("want a client certificate without its key refused")
+	}
+	if o := (egress.Options{CAFile: bundle}).Or(egress.Options{ProxyURL: proxy.URL, CAFile: "other.pem"}); o.ProxyURL != proxy.URL || o.CAFile != bundle {
+		return fmt.Errorf("want the settings of a connection to fall back to the environment, got %+v", o)
+	}
+	return nil
+}
+
+// priorityOrder checks that boosted work items are synced first, those of the first boost before those of the
+// second, and the others after them.
+func priorityOrder(ctx context.Context, h *Harness) error {
+	ids := addAssigned(h, 3)
+	bug := h.ADO.Add("Bug", "Crash on save", map[string]interface{}{ado.FieldAssignedTo: Assignee("Alice", "alice@example.com")})
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	// The single worker creates the tasks in the order the items are synced, and task GIDs ascend.
+	created := map[string]int{}
+	for _, id := range append(ids, bug) {
+		t, err := h.TaskOf(ctx, id)
+		if err != nil {
+			return err
+		}
+		created[t.GID] = id
+	}
+	var order []int
+	for _, t := range h.Asana.Tasks(h.Project) {
+		order = append(order, created[t.GID])
+	}
+	if want := []int{bug, ids[2], ids[0], ids[1]}; fmt.Sprint(order) != fmt.Sprint(want) {
+		return fmt.Errorf("want the items synced in the order %v, got %v", want, order)
+	}
+	return nil
+}
+
+// errorCodes checks that failures are classified with a stable code, which the cycle status records.
+func errorCodes(ctx context.Context, h *Harness) error {
+	for err, want := range map[error]syncer.Code{
+		fmt.Errorf("listing tasks: %w", &asana.Error{StatusCode: http.StatusUnauthorized}): syncer.CodeAsanaAuth,
+		&ado.Error{StatusCode: http.StatusTooManyRequests}:                                 syncer.CodeADORateLimit,
+		&ado.Error{StatusCode: http.StatusServiceUnavailable}:                              syncer.CodeADOOutage,
+		&asana.Error{StatusCode: http.StatusBadRequest}:                                    syncer.CodeRejected,
+		errors.New("boom"): syncer.CodeUnknown,
+		context.Canceled:   "",
+	} {
+		if got := syncer.Classify(err); got != want {
+			return fmt.Errorf("want %v classified as %q, got %q", err, want, got)
+		}
+	}
+	if syncer.CodeADOOutage.Class() != syncer.ClassOutage || syncer.CodeADOOutage.Remediation() == "" {
+		return fmt.Errorf("want outages of the provider_outage class with a remediation")
+	}
+
+	// A field mapping to a custom field missing from the project fails the cycle as misconfigured.
+	cfg := h.Config
+	cfg.FieldMappings = []syncer.FieldMapping{{Source: "Microsoft.VSTS.Scheduling.StoryPoints", Target: "Points", Type: syncer.TypeNumber}}
+	addAssigned(h, 1)
+	_, err := syncer.New(cfg, adoClient(h.ADO), h.asana, h.Store).Run(ctx)
+	if code := syncer.Classify(err); code != syncer.CodeFieldMissing || code.Class() != syncer.ClassConfig {
+		return fmt.Errorf("want the cycle failed with %s, got %q: %v", syncer.CodeFieldMissing, code, err)
+	}
+	s, err := syncer.LastCycle(ctx, h.Store, cfg.Name)
+	if err != nil {
+		return err
+	}
+	if s.ErrorCode != syncer.CodeFieldMissing {
+		return fmt.Errorf("want the cycle status to record %s, got %q", syncer.CodeFieldMissing, s.ErrorCode)
+	}
+	return nil
+}
+
+// processFields maps a custom picklist field of the inherited process by its display name and without a
+// type, which must sync as an enum given the values of the picklist, then maps a field the project lacks.
+func processFields(ctx context.Context, h *Harness) error {
+	h.ADO.AddField(ado.Field{ReferenceName: "Custom.ReleaseTrain", Name: "Release Train", Type: ado.FieldTypeString}, "Alpha", "Beta", "Gamma")
+	ids := addAssigned(h, 1)
+	h.ADO.Update(ids[0], map[string]interface{}{"Custom.ReleaseTrain": "Gamma"})
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	t, _ := h.TaskOf(ctx, ids[0])
+	values := map[string]string{}
+	for _, cf := range t.CustomFields {
+		values[cf.Name] = textValue(t, cf.GID)
+	}
+	if values["Train"] != "Gamma" {
+		return fmt.Errorf("want the release train synced to the enum field created from its picklist, got %v", values)
+	}
+
+	cfg := h.Config
+	cfg.FieldMappings = []syncer.FieldMapping{{Source: "Custom.Missing", Target: "Train"}}
+	_, err := syncer.New(cfg, adoClient(h.ADO), h.asana, h.Store).Run(ctx)
+	if code := syncer.Classify(err); code != syncer.CodeADOFieldMissing {
+		return fmt.Errorf("want a mapping of a missing ado field to fail with %s, got %q: %v", syncer.CodeADOFieldMissing, code, err)
+	}
+	return nil
+}
+
+// conflictStrategies edits the titles of two work items and their tasks on both sides, the work item first
+// for one and the task first for the other, and checks which edit each strategy keeps on both sides.
+func conflictStrategies(ctx context.Context, h *Harness) error {
+	ids := addAssigned(h, 2)
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	for _, c := range []struct {
+		strategy syncer.ConflictStrategy
+		want     [2]string
+	}{
+		{syncer.ADOWins, [2]string{"ADO", "ADO"}},
+		{syncer.AsanaWins, [2]string{"Asana", "Asana"}},
+		{syncer.NewestWins, [2]string{"Asana", "ADO"}},
+	} {
+		for i, id := range ids {
+			t, err := h.TaskOf(ctx, id)
+			if err != nil {
+				return err
+			}
+			edits := []func(){
+				func() {
+					h.ADO.Update(id, map[string]interface{}{ado.FieldTitle: fmt.Sprintf("%s from ADO", c.strategy)})
+				},
+				func() {
+					h.Asana.Update(t.GID, asana.TaskRequest{Name: asana.String(fmt.Sprintf("[AB#%d] %s from Asana", id, c.strategy))})
+				},
+			}
+			if i == 1 {
+				edits[0], edits[1] = edits[1], edits[0]
+			}
+			edits[0]()
+			// The clocks of the fakes must tell the edits apart.
+			time.Sleep(10 * time.Millisecond)
+			edits[1]()
+		}
+		cfg := h.Config
+		cfg.ConflictStrategy = c.strategy
+		if _, err := syncer.New(cfg, adoClient(h.ADO), h.asana, h.Store).Run(ctx); err != nil {
+			return err
+		}
+		for i, id := range ids {
+			want := fmt.Sprintf("%s from %s", c.strategy, c.want[i])
+			wi, _ := h.ADO.Item(id)
+			t, _ := h.TaskOf(ctx, id)
+			if wi.Title() != want || t.Name != fmt.Sprintf("[AB#%d] %s", id, want) {
+				return fmt.Errorf("%s: want work item %d and its task titled %q, got %q and %q", c.strategy, id, want, wi.Title(), t.Name)
+			}
+		}
+		if conflicts, err := h.Store.Conflicts(ctx); err != nil || len(conflicts) != 0 {
+			return fmt.Errorf("%s: want no conflict queued, got %v, %v", c.strategy, conflicts, err)
+		}
+	}
+	return nil
+}
+
+// resolveConflicts queues title conflicts on two work items, then resolves one for Asana and the other with
+// a merged title, which must reach both sides and the audit log.
+func resolveConflicts(ctx context.Context, h *Harness) error {
+	ids := addAssigned(h, 2)
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		t, _ := h.TaskOf(ctx, id)
+		h.ADO.Update(id, map[string]interface{}{ado.FieldTitle: "From ADO"})
+		h.Asana.Update(t.GID, asana.TaskRequest{Name: asana.String(fmt.Sprintf("[AB#%d] From Asana", id))})
+	}
+	if _, err := h.Run(ctx); err != nil {
+		return err
+	}
+	if conflicts, err := h.Engine.Conflicts(ctx); err != nil || len(conflicts) != 2 {
+		return fmt.Errorf("want both title edits queued, got %v: %v", conflicts, err)
+	}
+
+	if _, err := h.Engine.ResolveConflict(ctx, ids[0], string(syncer.FieldTitle), syncer.Resolution{Winner: syncer.SystemAsana}); err != nil {
+		return err
+	}
+	merged := "Merged"
+	if _, err := h.Engine.ResolveConflict(ctx, ids[1], string(syncer.FieldTitle), syncer.Resolution{Merged: &merged}); err != nil {
+		return err
+	}
+	for i, want := range []string{"From Asana", "Merged"} {
+		wi, _ := h.ADO.Item(ids[i])
+		t, _ := h.TaskOf(ctx, ids[i])
+		if wi.Title() != want || !strings.HasSuffix(t.Name, want) {
+			return fmt.Errorf("work item %d: want title %q on both sides, got %q and %q", ids[i], want, wi.Title(), t.Name)
+		}
+	}
+	if conflicts, _ := h.Engine.Conflicts(ctx); len(conflicts) != 0 {
+		return fmt.Errorf("want no conflicts left queued, got %v", conflicts)
+	}
+	records, err := h.Store.Audit(ctx, store.AuditFilter{ADOID: ids[1]})
+	if err != nil {
+		return err
+	}
+	resolutions := 0
+	for _, r := range records {
+		if r.Action == string(syncer.ActionResolve) {
+			resolutions++
+		}
+	}
+	if resolutions != 2 {
+		return fmt.Errorf("want the merged title audited as a resolution of both sides, got %d records", resolutions)
+	}
+	return nil
+}
+
+// tenantFile returns the JSON of the file of a tenant syncing the ADO project into the Asana project, with
+// the call budget and the extra settings given, which override the credentials. The scenario connects the
+// tenant to the ADO fake of h itself.
+func tenantFile(h *Harness, project, budget, extra string) []byte {
+	return []byte(fmt.Sprintf(`{"ado_org_url": "https://dev.azure.com/contoso", "ado_pat": "pat", "asana_token": "token", "call_budget": %q,%s
+		"pairs": [{"name": "board", "ado_project": %q, "asana_workspace": %q, "asana_project": %q}]}`,
+		budget, extra, ProjectName, h.Asana.Workspace, project))
+}
+
+// tenants syncs the same organization for two tenants, each with its own store at the tenant location of a
+// shared store and its own call budget. Tenant files that would reach into the service are rejected.
+func tenants(ctx context.Context, h *Harness) error {
+	// Nor can a tenant have the PAT sent elsewhere, or refer to secrets other than its own.
+	for _, extra := range []string{
+		`"env": {"ADO_PAT": "x"},`,
+		`"transforms": [{"name": "exec", "options": {"command": "sh"}}],`,
+		`"ado_org_url": "https://collector.example.com/contoso",`,
+		`"ado_org_url": "http://dev.azure.com/contoso",`,
+		`"ado_pat": "keyvault://service-vault/ado-pat",`,
+		`"ado_pat": "vault://secret/tenants/globex#ado_pat",`,
+		`"asana_token": "vault://secret/tenants/acme/../globex#asana_token",`,
+		`"asana_token": "vault://secret/ado-asana-sync#asana_token",`,
+	} {
+		if _, err := config.ParseTenant("acme", tenantFile(h, h.Project, "", extra)); err == nil {
+			return fmt.Errorf("want the tenant file with %s rejected", extra)
+		}
+	}
+	extra := `"ado_org_url": "https://contoso.visualstudio.com", "ado_pat": "vault://secret/tenants/acme#ado_pat", "asana_token": "vault://kv/tenants/acme/asana",`
+	if _, err := config.ParseTenant("acme", tenantFile(h, h.Project, "", extra)); err != nil {
+		return fmt.Errorf("want references to the secrets of the tenant accepted: %w", err)
+	}
+	dir, err := os.MkdirTemp("", "tenants")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	ids := addAssigned(h, 4)
+	projects := map[string]string{"acme": h.Project, "globex": h.Asana.AddProject("Globex", "To do", "Doing", "Done")}
+	budgets := map[string]string{"acme": "", "globex": "3/m"}
+	stores := map[string]store.Store{}
+	for _, name := range []string{"acme", "globex"} {
+		cfg, err := config.ParseTenant(name, tenantFile(h, projects[name], budgets[name], ""))
+		if err != nil {
+			return err
+		}
+		pairs, err := cfg.SyncPairs(name, h.Config)
+		if err != nil {
+			return err
+		}
+		st, err := store.Open(ctx, store.TenantLocation("sqlite://"+filepath.Join(dir, "mappings.db"), name))
+		if err != nil {
+			return err
+		}
+		defer st.Close()
+		stores[name] = st
+		m, err := syncer.NewManager(pairs, map[string]syncer.ADOConnection{name: {OrgURL: h.ADO.URL(), ADO: adoClient(h.ADO), Store: st}},
+			map[string]syncer.AsanaConnection{name: {Asana: h.asana}})
+		if err != nil {
+			return err
+		}
+		caps, _ := ratelimit.ParseCaps(cfg.CallBudget)
+		e := m.Engines()[0]
+		if e.Name() != name+"/board" {
+			return fmt.Errorf("want the pair of tenant %s named %s/board, got %s", name, name, e.Name())
+		}
+		_, err = e.Run(ratelimit.WithAllowance(ctx, ratelimit.NewAllowance("tenant:"+name, caps)))
+		switch {
+		case name == "globex" && !errors.Is(err, ratelimit.ErrBudgetExhausted):
+			return fmt.Errorf("tenant globex: want the cycle stopped by the budget of the tenant, got %v", err)
+		case name == "acme" && err != nil:
+			return fmt.Errorf("tenant acme: %w", err)
+		}
+	}
+	if got := len(h.Asana.Tasks(projects["acme"])); got != len(ids) {
+		return fmt.Errorf("tenant acme: want %d tasks, got %d", len(ids), got)
+	}
+	if got := len(h.Asana.Tasks(projects["globex"])); got >= len(ids) {
+		return fmt.Errorf("tenant globex: want its budget to hold back some of its %d tasks, got %d", len(ids), got)
+	}
+	for _, name := range []string{"acme", "globex"} {
+		if _, err := os.Stat(filepath.Join(dir, "tenants", name, "mappings.db")); err != nil {
+			return fmt.Errorf("tenant %s: want its store in its own directory: %w", name, err)
+		}
+	}
+	acme, err := stores["acme"].All(ctx)
+	if err != nil {
+		return err
+	}
+	globex, err := stores["globex"].All(ctx)
+	if err != nil {
+		return err
+	}
+	if len(acme) != len(ids) || len(globex) >= len(ids) {
+		return fmt.Errorf("want the mappings of each tenant in its own store, got %d and %d", len(acme), len(globex))
+	}
+	return nil
+}
+
+func webhooks(ctx context.Context, h *Harness) error {
+	m, err := h.Manager(nil, nil)
+	if err != nil {
+		return err
+	}
+	hooks := webhook.NewServer(m, h.Store)
+	server := httptest.NewServer(hooks.Handler())
+	defer server.Close()
+	post := func(path string, header map[string]string, body string) (int, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			return 0, err
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+	signed := func(secret, body string) map[string]string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(body))
+		return map[string]string{"X-Hook-Signature": hex.EncodeToString(mac.Sum(nil))}
+	}
+
+	// Each webhook keeps the secret of its first handshake, and a later one cannot replace it.
+	for _, c := range []struct {
+		path, secret string
+		want         int
+	}{
+		{"/hooks/asana/web", "first", http.StatusOK},
+		{"/hooks/asana/web", "forged", http.StatusConflict},
+		{"/hooks/asana/api", "second", http.StatusOK},
+		{"/hooks/asana", "third", http.StatusOK},
+		{"/hooks/asana", "forged", http.StatusConflict},
+		{"/hooks/asana/not%20an%20id", "forged", http.StatusNotFound},
+	} {
+		if code, err := post(c.path, map[string]string{"X-Hook-Secret": c.secret}, ""); err != nil || code != c.want {
+			return fmt.Errorf("want the handshake of %s with %q answered %d, got %d, %v", c.path, c.secret, c.want, code, err)
+		}
+	}
+	body := `{"events":[]}`
+	for _, c := range []struct {
+		path, secret string
+		want         int
+	}{
+		{"/hooks/asana/web", "first", http.StatusOK},
+		{"/hooks/asana/web", "forged", http.StatusUnauthorized},
+		{"/hooks/asana/web", "second", http.StatusUnauthorized},
+		{"/hooks/asana/api", "second", http.StatusOK},
+		{"/hooks/asana", "third", http.StatusOK},
+		{"/hooks/asana/other", "first", http.StatusUnauthorized},
+	} {
+		if code, err := post(c.path, signed(c.secret, body), body); err != nil || code != c.want {
+			return fmt.Errorf("want a delivery to %s signed with %q answered %d, got %d, %v", c.path, c.secret, c.want, code, err)
+		}
+	}
+
+	// ADO deliveries need the credentials of the service hook, unless they are explicitly not required.
+	event := `{"eventType":"workitem.updated","resource":{"workItemId":1}}`
+	auth := func(user, pass string) map[string]string {
+		req, _ := http.NewRequest(http.MethodPost, "/", nil)
+		req.SetBasicAuth(user, pass)
+		return map[string]string{"Authorization": req.Header.Get("Authorization")}
+	}
+	if code, err := post("/hooks/ado", nil, event); err != nil || code != http.StatusUnauthorized {
+		return fmt.Errorf("want an ado delivery refused without configured credentials, got %d, %v", code, err)
+	}
+	hooks.ADOUnauthenticated = true
+	if code, err := post("/hooks/ado", nil, event); err != nil || code != http.StatusNoContent {
+		return fmt.Errorf("want an unauthenticated ado delivery accepted once allowed, got %d, %v", code, err)
+	}
+	hooks.ADOUsername, hooks.ADOPassword = "hook", "s3cret"
+	if code, err := post("/hooks/ado", nil, event); err != nil || code != http.StatusUnauthorized {
+		return fmt.Errorf("want an ado delivery without credentials refused once they are configured, got %d, %v", code, err)
+	}
+	if code, err := post("/hooks/ado", auth("hook", "wrong"), event); err != nil || code != http.StatusUnauthorized {
+		return fmt.Errorf("want an ado delivery with the wrong password refused, got %d, %v", code, err)
+	}
+	if code, err := post("/hooks/ado", auth("hook", "s3cret"), event); err != nil || code != http.StatusNoContent {
+		return fmt.Errorf("want an ado delivery with the credentials accepted, got %d, %v", code, err)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package testfixtures
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// throttle makes a fake respond 429 Too Many Requests to some of its requests, as the real APIs do when
+// their quota is spent.
+type throttle struct {
+	mu sync.Mutex
+	// every is the period of the rate limited requests: every n-th request is refused. Zero disables it.
+	every int
+	// retryAfter is the Retry-After header of the refusals in seconds, or omitted when negative.
+	retryAfter int
+	requests   int
+	limited    int
+}
+
+// set rate limits every n-th request, asking clients to retry after retryAfter seconds, or omitting
+// Retry-After when it is negative. n of zero stops the rate limiting.
+func (t *throttle) set(n, retryAfter int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.every, t.retryAfter = n, retryAfter
+}
+
+// refuse counts a request and reports, having written the 429, whether it was rate limited.
+func (t *throttle) refuse(w http.ResponseWriter) bool {
+	t.mu.Lock()
+	t.requests++
+	limit := t.every > 0 && t.requests%t.every == 0
+	if limit {
+		t.limited++
+	}
+	retry := t.retryAfter
+	t.mu.Unlock()
+	if !limit {
+		return false
+	}
+	if retry >= 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(retry))
+	}
+	http.Error(w, `{"message":"rate limit exceeded"}`, http.StatusTooManyRequests)
+	return true
+}
+
+// counts returns the number of requests served and of those that were rate limited.
+func (t *throttle) counts() (requests, limited int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.requests, t.limited
+}
+
+// writeJSON writes v as the JSON body of a response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// page returns the items of a list from the offset to at most limit items on, and the offset of the next
+// page, or an empty string on the last page.
+func page(n int, offset, limit string) (from, to int, next string) {
+	from, _ = strconv.Atoi(offset)
+	size, err := strconv.Atoi(limit)
+	if err != nil || size <= 0 {
+		size = 100
+	}
+	if from < 0 || from > n {
+		from = n
+	}
+	to = from + size
+	if to >= n {
+		return from, n, ""
+	}
+	return from, to, strconv.Itoa(to)
+}
@@ -0,0 +1,73 @@
+package testfixtures_test
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/testfixtures"
+)
+
+var (
+	update  = flag.Bool("update", false, "rewrite the snapshots of the golden cases")
+	cycles  = flag.Bool("cycles", false, "log the sync cycles")
+	timeout = flag.Duration("scenario.timeout", 2*time.Minute, "give up on a scenario after this long")
+)
+
+// TestMain serves as the exec plugin of the scenarios when the test binary is run with PluginArg.
+func TestMain(m *testing.M) {
+	if len(os.Args) == 2 && os.Args[1] == testfixtures.PluginArg {
+		if err := testfixtures.ServePlugin(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	flag.Parse()
+	level := slog.LevelWarn + 1
+	if *cycles {
+		level = slog.LevelDebug
+	}
+	logger, _ := logging.New(os.Stderr, level, logging.FormatText)
+	slog.SetDefault(logger)
+	os.Exit(m.Run())
+}
+
+// TestScenarios runs the end to end scenarios against the fake ADO and Asana servers.
+func TestScenarios(t *testing.T) {
+	for _, s := range testfixtures.Scenarios {
+		s := s
+		t.Run(s.Name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+			defer cancel()
+			if err := s.Run(ctx); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// TestGolden compares the planned Asana writes of the golden cases with their snapshots, or rewrites the
+// snapshots with -update.
+func TestGolden(t *testing.T) {
+	cases, err := testfixtures.GoldenCases(filepath.Join("testdata", "golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range cases {
+		g := g
+		t.Run(g.Name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+			defer cancel()
+			if err := g.Run(ctx, *update); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+// Package tracing configures OpenTelemetry tracing for sync cycles and API calls.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentation = "github.com/danstis/ado-asana-sync"
+
+// Tracer returns the tracer used by the app. Spans are discarded until Setup installs an exporter.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentation)
+}
+
+// Setup installs an OTLP/HTTP trace exporter when OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set. The exporter reads the rest of its configuration, such as
+// OTEL_EXPORTER_OTLP_HEADERS, from the standard OpenTelemetry environment variables. The returned function
+// flushes pending spans and must be called before exit.
+func Setup(ctx context.Context, version string) (func(context.Context) error, error) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exp, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("creating trace exporter: %w", err)
+	}
+	// resource.Default honours OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES, which take precedence.
+	res, err := resource.Merge(
+		resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceName("ado-asana-sync"),
+			semconv.ServiceVersion(version),
+		),
+		resource.Default(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating trace resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return tp.Shutdown, nil
+}
+
+// Transport wraps next so every request sent to provider is recorded as a client span.
+// A nil next uses http.DefaultTransport.
+func Transport(provider string, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return otelhttp.NewTransport(next,
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return provider + " " + r.Method
+		}),
+		otelhttp.WithSpanOptions(trace.WithAttributes(attribute.String("provider", provider))),
+	)
+}
+
+// End records err on span, if set, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,201 @@
+package transform
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+)
+
+// ProtocolVersion is the version of the protocol spoken with exec plugins, sent with every request.
+const ProtocolVersion = 1
+
+// DefaultExecTimeout is how long an exec plugin may take to answer a request.
+const DefaultExecTimeout = 10 * time.Second
+
+// execRequest is a line written to an exec plugin: a work item or a task to transform.
+type execRequest struct {
+	Version  int           `json:"version"`
+	Kind     string        `json:"kind"`
+	WorkItem *ado.WorkItem `json:"work_item,omitempty"`
+	Task     *asana.Task   `json:"task,omitempty"`
+}
+
+// execResponse is the line an exec plugin answers a request with. A response without the work item or task
+// leaves it unchanged.
+type execResponse struct {
+	WorkItem *ado.WorkItem `json:"work_item,omitempty"`
+	Task     *asana.Task   `json:"task,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// execPlugin is a transformer run as a subprocess, so teams can customize the sync in any language without
+// forking it. The process is started on first use and kept running; it reads one JSON request per line on
+// standard input and answers each with one JSON response line on standard output. Its standard error is
+// passed through. A process that exits, or fails to answer in time, is started again for the next request.
+//
+// Options:
+//   - command: the program to run, required.
+//   - args: its arguments, separated by spaces.
+//   - timeout: how long it may take to answer, as a duration, defaulting to DefaultExecTimeout.
+type execPlugin struct {
+	command string
+	args    []string
+	timeout time.Duration
+
+	mu     gosync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+func newExecPlugin(options map[string]string) (Transformer, error) {
+	p := &execPlugin{command: options["command"], args: strings.Fields(options["args"]), timeout: DefaultExecTimeout}
+	if p.command == "" {
+		return nil, fmt.Errorf("the command option is required")
+	}
+	if v := options["timeout"]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q", v)
+		}
+		p.timeout = d
+	}
+	return p, nil
+}
+
+// WorkItem implements Transformer.
+func (p *execPlugin) WorkItem(ctx context.Context, item *ado.WorkItem) error {
+	resp, err := p.call(ctx, execRequest{Version: ProtocolVersion, Kind: "work_item", WorkItem: item})
+	if err != nil {
+		return err
+	}
+	if resp.WorkItem != nil {
+		*item = *resp.WorkItem
+	}
+	return nil
+}
+
+// Task implements Transformer.
+func (p *execPlugin) Task(ctx context.Context, task *asana.Task) error {
+	resp, err := p.call(ctx, execRequest{Version: ProtocolVersion, Kind: "task", Task: task})
+	if err != nil {
+		return err
+	}
+	if resp.Task != nil {
+		*task = *resp.Task
+	}
+	return nil
+}
+
+// call sends req to the process, starting it when it is not running, and returns its response. Requests
+// are sent one at a time.
+func (p *execPlugin) call(ctx context.Context, req execRequest) (*execResponse, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd == nil {
+		if err := p.start(); err != nil {
+			return nil, err
+		}
+	}
+
+	type result struct {
+		line []byte
+		err  error
+	}
+	done := make(chan result, 1)
+	stdin, stdout := p.stdin, p.stdout
+	go func() {
+		if _, err := stdin.Write(append(b, '\n')); err != nil {
+			done <- result{err: err}
+			return
+		}
+		line, err := stdout.ReadBytes('\n')
+		done <- result{line: line, err: err}
+	}()
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+	var r result
+	select {
+	case r = <-done:
+	case <-timer.C:
+		r.err = fmt.Errorf("no answer within %s", p.timeout)
+	case <-ctx.Done():
+		r.err = ctx.Err()
+	}
+	if r.err != nil {
+		// The process is in an unknown state, so it is replaced for the next request.
+		p.stop()
+		return nil, fmt.Errorf("plugin %s: %w", p.command, r.err)
+	}
+	var resp execResponse
+	if err := json.Unmarshal(r.line, &resp); err != nil {
+		p.stop()
+		return nil, fmt.Errorf("plugin %s: decoding response: %w", p.command, err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("plugin %s: %s", p.command, resp.Error)
+	}
+	return &resp, nil
+}
+
+// start starts the process. The caller holds p.mu.
+func (p *execPlugin) start() error {
+	cmd := exec.Command(p.command, p.args...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting plugin %s: %w", p.command, err)
+	}
+	p.cmd, p.stdin, p.stdout = cmd, stdin, bufio.NewReader(stdout)
+	return nil
+}
+
+// stop ends the process, closing its standard input first so it can exit on its own. The caller holds
+// p.mu.
+func (p *execPlugin) stop() {
+	if p.cmd == nil {
+		return
+	}
+	_ = p.stdin.Close()
+	exited := make(chan struct{})
+	go func(cmd *exec.Cmd) {
+		_ = cmd.Wait()
+		close(exited)
+	}(p.cmd)
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		_ = p.cmd.Process.Kill()
+		<-exited
+	}
+	p.cmd, p.stdin, p.stdout = nil, nil, nil
+}
+
+// Close stops the process, if it runs.
+func (p *execPlugin) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stop()
+	return nil
+}
@@ -0,0 +1,74 @@
+package transform
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+)
+
+// mapField rewrites the values of a work item field by a table, such as a priority scale of 1 to 4 into
+// the names of Asana enum options. It leaves tasks alone, so the field is meant to sync ado-to-asana.
+//
+// Options:
+//   - field: reference name of the work item field, required.
+//   - values: comma separated from=to pairs, required, e.g. "1=High,2=High,3=Medium,4=Low".
+//   - default: value of the field when it holds a value the table does not list; unset keeps the value.
+type mapField struct {
+	field  string
+	values map[string]string
+	// fallback is the value of unlisted values, when hasFallback is set.
+	fallback    string
+	hasFallback bool
+}
+
+func newMapField(options map[string]string) (Transformer, error) {
+	m := &mapField{field: options["field"], values: map[string]string{}}
+	if m.field == "" {
+		return nil, fmt.Errorf("the field option is required")
+	}
+	for _, pair := range strings.Split(options["values"], ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid value %q, expected from=to", pair)
+		}
+		m.values[strings.ToLower(strings.TrimSpace(from))] = strings.TrimSpace(to)
+	}
+	if len(m.values) == 0 {
+		return nil, fmt.Errorf("the values option is required")
+	}
+	m.fallback, m.hasFallback = options["default"]
+	return m, nil
+}
+
+// WorkItem implements Transformer.
+func (m *mapField) WorkItem(_ context.Context, item *ado.WorkItem) error {
+	v, ok := item.Fields[m.field]
+	if !ok || v == nil {
+		return nil
+	}
+	var key string
+	switch v := v.(type) {
+	case string:
+		key = v
+	case float64:
+		key = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		key = fmt.Sprint(v)
+	}
+	if to, ok := m.values[strings.ToLower(strings.TrimSpace(key))]; ok {
+		item.Fields[m.field] = to
+	} else if m.hasFallback {
+		item.Fields[m.field] = m.fallback
+	}
+	return nil
+}
+
+// Task implements Transformer.
+func (m *mapField) Task(context.Context, *asana.Task) error { return nil }
@@ -0,0 +1,129 @@
+// Package transform holds the transformers that rewrite work items and tasks on their way through the sync
+// engine, for business rules the configuration cannot express. Transformers are compiled in, registered by
+// name with Register, and enabled for a pair by naming them in its configuration; the exec transformer runs
+// an external program, so they can also be written without rebuilding the sync.
+package transform
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	gosync "sync"
+
+	"github.com/danstis/ado-asana-sync/internal/ado"
+	"github.com/danstis/ado-asana-sync/internal/asana"
+)
+
+// Transformer rewrites the work items and tasks the engine reads, before it compares them and writes either
+// side. A value removed by a transformer is seen as absent, so it may be cleared on the other side.
+type Transformer interface {
+	// WorkItem rewrites a work item read from ADO.
+	WorkItem(ctx context.Context, item *ado.WorkItem) error
+	// Task rewrites a task read from Asana.
+	Task(ctx context.Context, task *asana.Task) error
+}
+
+// Factory returns a Transformer configured by options.
+type Factory func(options map[string]string) (Transformer, error)
+
+// Spec enables the transformer registered under Name with its options.
+type Spec struct {
+	Name    string            `json:"name"`
+	Options map[string]string `json:"options,omitempty"`
+}
+
+var (
+	mu        gosync.RWMutex
+	factories = map[string]Factory{
+		"exec":      newExecPlugin,
+		"map-field": newMapField,
+	}
+)
+
+// Register makes a transformer available under name. It panics when the name is taken, so it belongs in
+// the init function of the file defining the transformer.
+func Register(name string, f Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("transform: %q is registered twice", name))
+	}
+	factories[name] = f
+}
+
+// Names returns the names of the registered transformers, sorted.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for n := range factories {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New returns the transformer described by s.
+func New(s Spec) (Transformer, error) {
+	mu.RLock()
+	f, ok := factories[s.Name]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown transform %q, expected one of %s", s.Name, strings.Join(Names(), ", "))
+	}
+	t, err := f(s.Options)
+	if err != nil {
+		return nil, fmt.Errorf("transform %s: %w", s.Name, err)
+	}
+	return t, nil
+}
+
+// Chain applies transformers in order.
+type Chain []Transformer
+
+// NewChain returns the chain of the transformers described by specs.
+func NewChain(specs []Spec) (Chain, error) {
+	var c Chain
+	for _, s := range specs {
+		t, err := New(s)
+		if err != nil {
+			return nil, err
+		}
+		c = append(c, t)
+	}
+	return c, nil
+}
+
+// WorkItem implements Transformer.
+func (c Chain) WorkItem(ctx context.Context, item *ado.WorkItem) error {
+	for _, t := range c {
+		if err := t.WorkItem(ctx, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Task implements Transformer.
+func (c Chain) Task(ctx context.Context, task *asana.Task) error {
+	for _, t := range c {
+		if err := t.Task(ctx, task); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close stops the transformers of the chain that hold resources such as processes.
+func (c Chain) Close() error {
+	var errs []error
+	for _, t := range c {
+		if cl, ok := t.(io.Closer); ok {
+			errs = append(errs, cl.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,310 @@
+// Package webhook receives Azure DevOps service hook and Asana webhook events and triggers targeted syncs.
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/danstis/ado-asana-sync/internal/logging"
+	"github.com/danstis/ado-asana-sync/internal/store"
+	syncer "github.com/danstis/ado-asana-sync/internal/sync"
+)
+
+// asanaSecretKey is the store setting holding the secret negotiated during the handshake of the Asana webhook
+// delivering to /hooks/asana. The secret of the webhook delivering to /hooks/asana/{id} is held in the setting
+// of this key followed by ":" and the ID.
+const asanaSecretKey = "asana_webhook_secret"
+
+// hookID matches the IDs of the Asana webhooks in their delivery paths.
+var hookID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// maxBody is the largest event payload accepted.
+const maxBody = 1 << 20
+
+// Syncer syncs individual items on demand.
+type Syncer interface {
+	SyncItem(ctx context.Context, orgURL string, adoID int) (*syncer.Report, error)
+	SyncTask(ctx context.Context, taskGID string) (*syncer.Report, error)
+}
+
+// Server handles webhook deliveries. Events are acknowledged immediately and synced in the background,
+// with repeated events for the same item collapsed while they wait.
+type Server struct {
+	// ADOUsername and ADOPassword are the basic auth credentials configured on the ADO service hook.
+	// Deliveries are rejected when both are empty, unless ADOUnauthenticated is set.
+	ADOUsername string
+	ADOPassword string
+	// ADOUnauthenticated accepts ADO deliveries without credentials when none are configured.
+	ADOUnauthenticated bool
+	// Active, when set, reports whether events are synced. Events received while it returns false, on a
+	// standby replica, are acknowledged and dropped, as the cycles of the leader pick their changes up.
+	Active func() bool
+
+	syncer Syncer
+	store  store.Store
+
+	mu      sync.Mutex
+	pending map[target]bool
+	queue   chan target
+
+	// handshakes serializes the Asana handshakes, so only the first one of a webhook stores its secret.
+	handshakes sync.Mutex
+}
+
+// target identifies an item to sync: a work item ID in the organization at orgURL, which is empty when the
+// event did not name it, or an Asana task GID.
+type target struct {
+	orgURL  string
+	adoID   int
+	taskGID string
+}
+
+// NewServer returns a Server triggering syncs on s. The Asana handshake secret is persisted in st.
+func NewServer(s Syncer, st store.Store) *Server {
+	return &Server{
+		syncer:  s,
+		store:   st,
+		pending: map[target]bool{},
+		queue:   make(chan target, 1024),
+	}
+}
+
+// Handler returns the HTTP handler serving the webhook endpoints.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hooks/ado", s.handleADO)
+	mux.HandleFunc("/hooks/asana", s.handleAsana)
+	mux.HandleFunc("/hooks/asana/", s.handleAsana)
+	return mux
+}
+
+// Run processes queued events until ctx is cancelled.
+func (s *Server) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case t := <-s.queue:
+			s.mu.Lock()
+			delete(s.pending, t)
+			s.mu.Unlock()
+
+			var err error
+			log := slog.Default()
+			if t.taskGID != "" {
+				log = log.With(logging.KeyTask, t.taskGID)
+				_, err = s.syncer.SyncTask(ctx, t.taskGID)
+			} else {
+				log = log.With(logging.KeyWorkItem, t.adoID)
+				_, err = s.syncer.SyncItem(ctx, t.orgURL, t.adoID)
+			}
+			switch {
+			case errors.Is(err, syncer.ErrPaused):
+				log.Info("webhook sync queued until the sync pair is resumed", "error", err)
+			case err != nil && !errors.Is(err, syncer.ErrNotMapped) && !errors.Is(err, syncer.ErrNoPair):
+				log.Error("webhook sync failed", "error", err)
+			}
+		}
+	}
+}
+
+// enqueue schedules t for syncing unless it is already waiting.
+func (s *Server) enqueue(t target) {
+	if s.Active != nil && !s.Active() {
+		slog.Debug("dropping webhook event on a standby replica")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending[t] {
+		return
+	}
+	select {
+	case s.queue <- t:
+		s.pending[t] = true
+	default:
+		slog.Warn("webhook queue full, dropping event; the next full cycle will pick it up")
+	}
+}
+
+// adoEvent is the subset of an ADO work item service hook payload used to find the changed item.
+type adoEvent struct {
+	EventType string `json:"eventType"`
+	Resource  struct {
+		ID         int `json:"id"`
+		WorkItemID int `json:"workItemId"`
+	} `json:"resource"`
+	ResourceContainers struct {
+		Account struct {
+			BaseURL string `json:"baseUrl"`
+		} `json:"account"`
+	} `json:"resourceContainers"`
+}
+
+func (s *Server) handleADO(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.ADOUsername != "" || s.ADOPassword != "" {
+		user, pass, ok := r.BasicAuth()
+		if !ok || !equal(user, s.ADOUsername) || !equal(pass, s.ADOPassword) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	} else if !s.ADOUnauthenticated {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var ev adoEvent
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxBody)).Decode(&ev); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	// Update events carry the revision ID in resource.id and the work item ID in resource.workItemId.
+	id := ev.Resource.WorkItemID
+	if id == 0 {
+		id = ev.Resource.ID
+	}
+	switch ev.EventType {
+	case "workitem.created", "workitem.updated", "workitem.restored", "workitem.commented", "workitem.deleted":
+		if id != 0 {
+			s.enqueue(target{orgURL: ev.ResourceContainers.Account.BaseURL, adoID: id})
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// asanaEvents is the subset of an Asana webhook delivery used to find the changed task.
+type asanaEvents struct {
+	Events []struct {
+		Resource struct {
+			GID          string `json:"gid"`
+			ResourceType string `json:"resource_type"`
+		} `json:"resource"`
+		Parent *struct {
+			GID          string `json:"gid"`
+			ResourceType string `json:"resource_type"`
+		} `json:"parent"`
+	} `json:"events"`
+}
+
+func (s *Server) handleAsana(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key, ok := secretKey(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	// The first delivery is a handshake carrying the secret used to sign every later delivery.
+	if secret := r.Header.Get("X-Hook-Secret"); secret != "" {
+		s.handshake(w, r, key, secret)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBody))
+	if err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	secret, err := s.store.Setting(r.Context(), key)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		slog.Error("failed to load asana webhook secret", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if err != nil || !validSignature(secret, body, r.Header.Get("X-Hook-Signature")) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var evs asanaEvents
+	if err := json.Unmarshal(body, &evs); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	for _, ev := range evs.Events {
+		switch {
+		case ev.Resource.ResourceType == "task":
+			s.enqueue(target{taskGID: ev.Resource.GID})
+		case ev.Parent != nil && ev.Parent.ResourceType == "task":
+			// Stories and attachments report the task they belong to as their parent.
+			s.enqueue(target{taskGID: ev.Parent.GID})
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// secretKey returns the store setting holding the handshake secret of the Asana webhook delivering to path,
+// and false when path does not name a webhook.
+func secretKey(path string) (string, bool) {
+	if path == "/hooks/asana" {
+		return asanaSecretKey, true
+	}
+	id := strings.TrimPrefix(path, "/hooks/asana/")
+	if !hookID.MatchString(id) {
+		return "", false
+	}
+	return asanaSecretKey + ":" + id, true
+}
+
+// handshake stores the secret of the Asana webhook whose secret is held in the setting key. A webhook keeps
+// the secret of its first handshake: later ones are rejected, so nobody can replace it and sign deliveries
+// of their own.
+func (s *Server) handshake(w http.ResponseWriter, r *http.Request, key, secret string) {
+	s.handshakes.Lock()
+	defer s.handshakes.Unlock()
+	_, err := s.store.Setting(r.Context(), key)
+	switch {
+	case err == nil:
+		slog.Warn("rejected asana webhook handshake, the webhook already has a secret", "path", r.URL.Path)
+		http.Error(w, "webhook already registered", http.StatusConflict)
+		return
+	case !errors.Is(err, store.ErrNotFound):
+		slog.Error("failed to load asana webhook secret", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	err = s.store.SetSetting(r.Context(), key, secret)
+	if err == nil {
+		err = s.store.Flush(r.Context())
+	}
+	if err != nil {
+		slog.Error("failed to store asana webhook secret", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("X-Hook-Secret", secret)
+	w.WriteHeader(http.StatusOK)
+}
+
+// validSignature reports whether signature is the hex HMAC-SHA256 of body keyed with secret.
+func validSignature(secret string, body []byte, signature string) bool {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	want := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(want), []byte(signature))
+}
+
+// equal compares two strings in constant time.
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}